@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/dashboard"
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+// runCollectorsCommand prints the collectors of e with their prerequisites and metric families,
+// returning the process exit code
+func runCollectorsCommand(args []string, e exporter.Exporter, w io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("collectors", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	asJSON := fs.Bool("json", false, "Print the collectors as JSON.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cl, ok := e.(exporter.CollectorLister)
+	if !ok {
+		fmt.Fprintln(stderr, "The exporter doesn't support listing collectors")
+		return 1
+	}
+	collectors := cl.Collectors()
+
+	if *asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(collectors); err != nil {
+			fmt.Fprintf(stderr, "Error printing collectors: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLLECTOR\tENABLED\tPREREQUISITES\tMETRIC FAMILIES")
+	for _, c := range collectors {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, yesNo(c.Enabled), formatPrerequisites(c.Prerequisites), strings.Join(c.Families, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintf(stderr, "Error printing collectors: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+// formatPrerequisites returns e.g. "getsysinfo (/sbin/getsysinfo), NUT upsd not found"
+func formatPrerequisites(prerequisites []exporter.Prerequisite) string {
+	if len(prerequisites) == 0 {
+		return "-"
+	}
+
+	items := make([]string, 0, len(prerequisites))
+	for _, p := range prerequisites {
+		item := p.Name
+		if !p.Found {
+			item += " not found"
+		}
+		if p.Detail != "" {
+			item += " (" + p.Detail + ")"
+		}
+		items = append(items, item)
+	}
+
+	return strings.Join(items, ", ")
+}
+
+// runTestCommand runs the collector named in args once, printing its metrics, the time taken and the commands executed,
+// and returns the process exit code (non-zero if the collector failed)
+func runTestCommand(args []string, e exporter.Exporter, w io.Writer, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "Usage: qnapexporter test <collector>")
+		return 2
+	}
+	name := args[0]
+
+	cl, ok := e.(exporter.CollectorLister)
+	if !ok {
+		fmt.Fprintln(stderr, "The exporter doesn't support running collectors")
+		return 1
+	}
+
+	utils.CommandLogger = log.New(stderr, "", 0)
+	defer func() { utils.CommandLogger = nil }()
+
+	start := time.Now()
+	err := cl.WriteCollectorMetrics(w, name)
+	elapsed := time.Since(start)
+	if err != nil {
+		fmt.Fprintf(stderr, "Collector %s failed after %v: %v\n", name, elapsed, err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "Collector %s succeeded in %v (including the environment read)\n", name, elapsed)
+	return 0
+}
+
+// runDashboardCommand prints a Grafana dashboard for the collectors of e, with panels for the disks and interfaces
+// detected by a first scrape unless --generic is set, and returns the process exit code
+func runDashboardCommand(args []string, e exporter.Exporter, status *exporter.Status, w io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("dashboard", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	generic := fs.Bool("generic", false, "Generate panels covering any NAS, rather than the disks and interfaces detected on this one.")
+	title := fs.String("title", dashboard.DefaultTitle, "Title of the dashboard.")
+	job := fs.String("job", dashboard.DefaultJob, "Name of the Prometheus job scraping the exporter.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cl, ok := e.(exporter.CollectorLister)
+	if !ok {
+		fmt.Fprintln(stderr, "The exporter doesn't support listing collectors")
+		return 1
+	}
+
+	config := dashboard.Config{Title: *title, Job: *job, Generic: *generic}
+	if !*generic {
+		// Scrape once to detect the disks and interfaces, ignoring the collectors which fail
+		_ = e.WriteMetrics(io.Discard)
+		config.Disks = status.Disks
+		config.Interfaces = status.Interfaces
+		if hp, ok := e.(exporter.HostnameProvider); ok {
+			config.Node = hp.Hostname()
+		}
+	}
+	config.Collectors = cl.Collectors()
+
+	if err := dashboard.Generate(w, config); err != nil {
+		fmt.Fprintf(stderr, "Error generating dashboard: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// writeSelfCheckReport prints the outcome of each collector run by a self-check, with a hint for the known causes
+// of their failures
+func writeSelfCheckReport(w io.Writer, results []exporter.SelfCheckResult) {
+	var failed, missing int
+	for _, r := range results {
+		switch {
+		case r.Failed():
+			failed++
+		case len(r.Missing) > 0:
+			missing++
+		}
+	}
+	fmt.Fprintf(w, "Self-check of %d collectors: %d working, %d failed, %d with missing prerequisites\n",
+		len(results), len(results)-failed-missing, failed, missing)
+
+	for _, r := range results {
+		switch {
+		case r.Failed():
+			fmt.Fprintf(w, "  FAILED   %s: %s\n", r.Collector, r.Error)
+			if r.Hint != "" {
+				fmt.Fprintf(w, "           hint: %s\n", r.Hint)
+			}
+		case len(r.Missing) > 0:
+			fmt.Fprintf(w, "  MISSING  %s: %s not found (%d samples)\n", r.Collector, strings.Join(r.Missing, ", "), r.Samples)
+		default:
+			fmt.Fprintf(w, "  OK       %s (%d samples)\n", r.Collector, r.Samples)
+		}
+	}
+}
+
+// selfCheckFailed returns whether any collector failed the self-check
+func selfCheckFailed(results []exporter.SelfCheckResult) bool {
+	for _, r := range results {
+		if r.Failed() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -47,7 +47,7 @@ func handleDockerEvents(ctx context.Context, args httpServerArgs, annotator noti
 			m := strings.Join([]string{msg.Type, msg.Action, msg.Actor.ID, formatDockerActorAttributes(msg.Actor.Attributes)}, " ")
 			exporterStatus.Docker = m
 			args.logger.Printf("%v: %s\n", t, m)
-			_, _ = annotator.Post(m, t)
+			_, _ = annotator.PostAnnotation(notifications.Annotation{Text: m, Time: t})
 		case <-ctx.Done():
 			exporterStatus.Docker = "Done"
 			return nil
@@ -0,0 +1,316 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ListValue is implemented by flags which can be repeated on the command line.
+// Each item of a YAML list sets them once, and their effective value is printed as a list.
+type ListValue interface {
+	flag.Value
+	Values() []string
+}
+
+// setting is a scalar or list value read from the configuration file
+type setting struct {
+	name   string
+	line   int
+	values []string
+	isList bool
+}
+
+// Load applies the settings of the YAML file at path to the flags of fs, except those set on the command line
+// (i.e. flags override the file). Keys are flag names, and can be nested in maps joined by "-"
+// (e.g. "grafana: {url: ...}" sets --grafana-url). Lists set repeatable flags once per item, and other flags
+// to their comma-separated items. Unknown keys and invalid values are reported with their line number.
+func Load(fs *flag.FlagSet, path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(root.Content) == 0 {
+		// Empty file
+		return nil
+	}
+
+	var settings []setting
+	if err := flatten(root.Content[0], "", &settings); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	setOnCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { setOnCommandLine[f.Name] = true })
+
+	var errs []string
+	seen := map[string]int{}
+	for _, s := range settings {
+		f := fs.Lookup(s.name)
+		if f == nil {
+			errs = append(errs, fmt.Sprintf("line %d: unknown setting %q", s.line, s.name))
+			continue
+		}
+		if line, ok := seen[s.name]; ok {
+			errs = append(errs, fmt.Sprintf("line %d: setting %q is already set on line %d", s.line, s.name, line))
+			continue
+		}
+		seen[s.name] = s.line
+		if setOnCommandLine[s.name] {
+			continue
+		}
+
+		values := s.values
+		if _, ok := f.Value.(ListValue); !ok && s.isList {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, value := range values {
+			if err := fs.Set(s.name, value); err != nil {
+				errs = append(errs, fmt.Sprintf("line %d: invalid value %q for setting %q: %v", s.line, value, s.name, err))
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %s", path, strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// LoadEnv applies the environment variables named after the flags of fs (e.g. QNAPEXPORTER_GRAFANA_URL for
+// --grafana-url, or QNAPEXPORTER_PATH_ROOTFS for --path.rootfs with the "QNAPEXPORTER_" prefix) to the flags not set
+// on the command line. Repeatable flags are set once per comma-separated item.
+// It must be called before Load, so that the environment overrides the file.
+func LoadEnv(fs *flag.FlagSet, prefix string, environ []string) error {
+	setOnCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { setOnCommandLine[f.Name] = true })
+	flags := map[string]*flag.Flag{}
+	fs.VisitAll(func(f *flag.Flag) { flags[envName(prefix, f.Name)] = f })
+
+	var errs []string
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) {
+			continue
+		}
+		f, ok := flags[parts[0]]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown setting %q", parts[0]))
+			continue
+		}
+		name := f.Name
+		if setOnCommandLine[name] {
+			continue
+		}
+
+		values := []string{parts[1]}
+		if _, ok := f.Value.(ListValue); ok {
+			values = splitList(parts[1])
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid value %q for %s: %v", value, parts[0], err))
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("environment: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// envName returns the name of the environment variable setting the flag
+func envName(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+func splitList(s string) []string {
+	var values []string
+	for _, value := range strings.Split(s, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// flatten collects the settings of the mapping node, prefixing their names with prefix
+func flatten(node *yaml.Node, prefix string, settings *[]setting) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a map of settings", node.Line)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		name := key.Value
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+
+		switch value.Kind {
+		case yaml.MappingNode:
+			if err := flatten(value, name, settings); err != nil {
+				return err
+			}
+		case yaml.SequenceNode:
+			s := setting{name: name, line: key.Line, isList: true}
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: expected a list of values for setting %q", item.Line, name)
+				}
+				s.values = append(s.values, item.Value)
+			}
+			*settings = append(*settings, s)
+		case yaml.ScalarNode:
+			*settings = append(*settings, setting{name: name, line: key.Line, values: []string{value.Value}})
+		default:
+			return fmt.Errorf("line %d: unsupported value for setting %q", value.Line, name)
+		}
+	}
+
+	return nil
+}
+
+// Write prints the effective value of the flags of fs as a YAML configuration file, skipping the excluded flags.
+// The values of secrets (passwords, tokens, HTTP headers and webhook URLs) are masked, as are the credentials
+// embedded in URLs.
+func Write(w io.Writer, fs *flag.FlagSet, exclude ...string) error {
+	excluded := map[string]bool{}
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+
+	settings := map[string]interface{}{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if !excluded[f.Name] {
+			settings[f.Name] = effectiveValue(f)
+		}
+	})
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(settings); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// maskedValue replaces the values of secrets
+const maskedValue = "********"
+
+// isSecret returns whether the flag holds a secret, rather than e.g. the path of a file holding it.
+// Webhook URLs (e.g. Slack's) are secrets, since knowing them is enough to post to them.
+func isSecret(name string) bool {
+	if strings.HasSuffix(name, "-file") {
+		return false
+	}
+	if strings.HasSuffix(name, "webhook-url") {
+		return true
+	}
+	for _, word := range []string{"password", "token", "secret", "header"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSecretParameter returns whether the URL query parameter holds a credential (e.g. access_token or api_key)
+func isSecretParameter(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"password", "token", "secret", "key", "sig", "auth"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactURL masks the password and the secret query parameters of value, if it is a URL
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return value
+	}
+
+	redacted := false
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), maskedValue)
+		redacted = true
+	}
+	query := u.Query()
+	for name := range query {
+		if isSecretParameter(name) {
+			query.Set(name, maskedValue)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return value
+	}
+	u.RawQuery = query.Encode()
+
+	// Keep the mask readable rather than percent-encoded
+	return strings.ReplaceAll(u.String(), url.QueryEscape(maskedValue), maskedValue)
+}
+
+func effectiveValue(f *flag.Flag) interface{} {
+	secret := isSecret(f.Name)
+
+	switch v := f.Value.(type) {
+	case ListValue:
+		values := []string{}
+		for _, value := range v.Values() {
+			if secret {
+				// Keep the names of "Name: value" items (i.e. HTTP headers)
+				if parts := strings.SplitN(value, ":", 2); len(parts) == 2 {
+					value = parts[0] + ": " + maskedValue
+				} else {
+					value = maskedValue
+				}
+			} else {
+				value = redactURL(value)
+			}
+			values = append(values, value)
+		}
+		return values
+	case flag.Getter:
+		if s, ok := v.Get().(string); ok {
+			if secret && s != "" {
+				return maskedValue
+			}
+			return redactURL(s)
+		}
+		switch g := v.Get().(type) {
+		case time.Duration:
+			return g.String()
+		default:
+			return g
+		}
+	default:
+		if secret && v.String() != "" {
+			return maskedValue
+		}
+		return redactURL(v.String())
+	}
+}
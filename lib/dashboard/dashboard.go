@@ -0,0 +1,348 @@
+package dashboard
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+const (
+	// DefaultTitle is the title of the dashboard when none is configured
+	DefaultTitle = "QNAP"
+	// DefaultJob is the name of the Prometheus job scraping the exporter when none is configured
+	DefaultJob = "qnap"
+
+	gridWidth = 24
+)
+
+//go:embed templates/*.json templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}).ParseFS(templateFS, "templates/*.json", "templates/*.tmpl"))
+
+// Config holds the settings of a generated dashboard
+type Config struct {
+	// Title is the title of the dashboard (defaults to DefaultTitle)
+	Title string
+	// Job is the name of the Prometheus job scraping the exporter (defaults to DefaultJob)
+	Job string
+	// Node is the default value of the node variable, i.e. the hostname of the NAS (defaults to empty, i.e. the first node)
+	Node string
+	// Generic generates panels covering any NAS, rather than one panel per detected disk and interface,
+	// and doesn't require the prerequisites of the collectors to be found
+	Generic bool
+	// Disks lists the detected disk slots (e.g. "1", "2")
+	Disks []string
+	// Interfaces lists the detected network interfaces (e.g. "eth0")
+	Interfaces []string
+	// Collectors describes the collectors of the exporter. Only the panels of enabled collectors are generated.
+	Collectors []exporter.CollectorInfo
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type target struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+// panel holds the values rendered by the panel templates (row, stat, gauge or timeseries)
+type panel struct {
+	kind    string
+	ID      int
+	Title   string
+	GridPos gridPos
+	Unit    string
+	Targets []target
+	// Max is the maximum of gauges
+	Max float64
+	// Warn and Crit are the thresholds of stats and gauges (unset if zero)
+	Warn, Crit float64
+}
+
+// builder lays out the panels from left to right, in rows of gridWidth
+type builder struct {
+	Config
+
+	panels []panel
+	x, y   int
+	// rowHeight is the height of the tallest panel of the current line
+	rowHeight int
+}
+
+// Generate writes a Grafana dashboard importable through the Grafana UI, displaying the metrics of the collectors
+func Generate(w io.Writer, config Config) error {
+	if config.Title == "" {
+		config.Title = DefaultTitle
+	}
+	if config.Job == "" {
+		config.Job = DefaultJob
+	}
+
+	b := &builder{Config: config}
+	b.addPanels()
+
+	var buf bytes.Buffer
+	data := struct {
+		Title, UID, Node, NodeQuery string
+	}{
+		Title:     config.Title,
+		UID:       "qnapexporter-" + strings.ToLower(strings.Join(strings.Fields(config.Title), "-")),
+		Node:      config.Node,
+		NodeQuery: fmt.Sprintf(`label_values(node_time_seconds{job=%q}, node)`, config.Job),
+	}
+	if err := templates.ExecuteTemplate(&buf, "dashboard.json", data); err != nil {
+		return err
+	}
+	var dashboard map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &dashboard); err != nil {
+		return fmt.Errorf("render dashboard: %w", err)
+	}
+
+	panels := make([]interface{}, 0, len(b.panels))
+	for _, p := range b.panels {
+		buf.Reset()
+		if err := templates.ExecuteTemplate(&buf, p.kind+".json", p); err != nil {
+			return err
+		}
+		var v interface{}
+		if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+			return fmt.Errorf("render %s panel %q: %w", p.kind, p.Title, err)
+		}
+		panels = append(panels, v)
+	}
+	dashboard["panels"] = panels
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dashboard)
+}
+
+// enabled returns whether the panels of the named collector are generated
+func (b *builder) enabled(name string) bool {
+	if b.Collectors == nil {
+		return true
+	}
+
+	for _, c := range b.Collectors {
+		if c.Name != name {
+			continue
+		}
+		if !c.Enabled {
+			return false
+		}
+		if b.Generic {
+			return true
+		}
+		for _, p := range c.Prerequisites {
+			if !p.Found {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// selector returns the label selector of the node, with the additional matchers
+func (b *builder) selector(matchers ...string) string {
+	return "{" + strings.Join(append([]string{fmt.Sprintf(`job=%q`, b.Job), `node="$node"`}, matchers...), ",") + "}"
+}
+
+func (b *builder) row(title string) {
+	if b.x > 0 {
+		b.x, b.y = 0, b.y+b.rowHeight
+	}
+	b.panels = append(b.panels, panel{kind: "row", ID: len(b.panels) + 1, Title: title, GridPos: gridPos{H: 1, W: gridWidth, X: 0, Y: b.y}})
+	b.y++
+	b.rowHeight = 0
+}
+
+func (b *builder) add(p panel, w, h int) {
+	if b.x+w > gridWidth {
+		b.x, b.y = 0, b.y+b.rowHeight
+		b.rowHeight = 0
+	}
+	p.ID = len(b.panels) + 1
+	p.GridPos = gridPos{H: h, W: w, X: b.x, Y: b.y}
+	for i := range p.Targets {
+		p.Targets[i].RefID = string(rune('A' + i))
+	}
+	b.panels = append(b.panels, p)
+
+	b.x += w
+	if h > b.rowHeight {
+		b.rowHeight = h
+	}
+}
+
+// dropEmptyRows removes the rows without panels
+func (b *builder) dropEmptyRows() {
+	panels := b.panels[:0]
+	for i, p := range b.panels {
+		if p.kind == "row" && (i+1 == len(b.panels) || b.panels[i+1].kind == "row") {
+			continue
+		}
+		panels = append(panels, p)
+	}
+	b.panels = panels
+}
+
+// width returns the width of each of count panels filling a line, within [min, max]
+func width(count, min, max int) int {
+	w := gridWidth
+	if count > 0 {
+		w = gridWidth / count
+	}
+	switch {
+	case w < min:
+		return min
+	case w > max:
+		return max
+	default:
+		return w
+	}
+}
+
+func (b *builder) addPanels() {
+	s := b.selector
+
+	b.row("Overview")
+	if b.enabled("uptime") {
+		b.add(panel{kind: "stat", Title: "Uptime", Unit: "s", Targets: []target{{Expr: "node_time_seconds" + s()}}}, 4, 4)
+	}
+	if b.enabled("temperature") {
+		b.add(panel{kind: "stat", Title: "CPU temperature", Unit: "celsius", Warn: 70, Crit: 80, Targets: []target{{Expr: "node_cputmp_C" + s()}}}, 4, 4)
+		b.add(panel{kind: "stat", Title: "System temperature", Unit: "celsius", Warn: 45, Crit: 55, Targets: []target{{Expr: "node_systmp_C" + s()}}}, 4, 4)
+	}
+	if b.enabled("loadavg") && b.enabled("cpu") {
+		b.add(panel{kind: "gauge", Title: "CPU load (1m)", Unit: "percentunit", Max: 1, Warn: 0.7, Crit: 0.9, Targets: []target{{Expr: "node_load1" + s() + " / node_cpu_count" + s()}}}, 4, 4)
+	}
+	if b.enabled("meminfo") {
+		b.add(panel{kind: "gauge", Title: "Used memory", Unit: "percentunit", Max: 1, Warn: 0.8, Crit: 0.9, Targets: []target{{Expr: "1 - node_memory_MemAvailable_bytes" + s() + " / node_memory_MemTotal_bytes" + s()}}}, 4, 4)
+	}
+	if b.enabled("volume") {
+		b.add(panel{kind: "stat", Title: "Volume usage", Unit: "percentunit", Warn: 0.8, Crit: 0.9, Targets: []target{{Expr: "1 - node_volume_avail_bytes" + s() + " / node_volume_size_bytes" + s(), LegendFormat: "{{volume}}"}}}, 4, 4)
+	}
+
+	b.row("CPU / memory")
+	if b.enabled("cpu") {
+		b.add(panel{kind: "timeseries", Title: "CPU usage", Unit: "percentunit", Targets: []target{{
+			Expr:         "sum by (mode) (rate(node_cpu_seconds_total" + s(`mode!="idle"`) + "[$__rate_interval])) / ignoring(mode) group_left node_cpu_count" + s(),
+			LegendFormat: "{{mode}}",
+		}}}, 8, 8)
+	}
+	if b.enabled("loadavg") {
+		b.add(panel{kind: "timeseries", Title: "Load average", Unit: "short", Targets: []target{
+			{Expr: "node_load1" + s(), LegendFormat: "1m"},
+			{Expr: "node_load5" + s(), LegendFormat: "5m"},
+			{Expr: "node_load15" + s(), LegendFormat: "15m"},
+		}}, 8, 8)
+	}
+	if b.enabled("meminfo") {
+		b.add(panel{kind: "timeseries", Title: "Memory", Unit: "bytes", Targets: []target{
+			{Expr: "node_memory_MemTotal_bytes" + s() + " - node_memory_MemAvailable_bytes" + s(), LegendFormat: "used"},
+			{Expr: "node_memory_MemAvailable_bytes" + s(), LegendFormat: "available"},
+			{Expr: "node_memory_SwapTotal_bytes" + s() + " - node_memory_SwapFree_bytes" + s(), LegendFormat: "swap used"},
+		}}, 8, 8)
+	}
+
+	b.row("Temperatures and fans")
+	if b.enabled("hd") {
+		if !b.Generic {
+			w := width(len(b.Disks), 2, 4)
+			for _, disk := range b.Disks {
+				b.add(panel{kind: "gauge", Title: "Disk " + disk, Unit: "celsius", Max: 70, Warn: 45, Crit: 55, Targets: []target{{Expr: "node_hdtmp_C" + s(fmt.Sprintf("hd=%q", disk))}}}, w, 4)
+			}
+		}
+		b.add(panel{kind: "timeseries", Title: "Disk temperatures", Unit: "celsius", Targets: []target{{Expr: "node_hdtmp_C" + s(), LegendFormat: "Disk {{hd}}"}}}, 8, 8)
+	}
+	if b.enabled("temperature") {
+		b.add(panel{kind: "timeseries", Title: "CPU and system temperatures", Unit: "celsius", Targets: []target{
+			{Expr: "node_cputmp_C" + s(), LegendFormat: "CPU"},
+			{Expr: "node_systmp_C" + s(), LegendFormat: "System"},
+		}}, 8, 8)
+	}
+	if b.enabled("fan") || b.enabled("enclosure") {
+		b.add(panel{kind: "timeseries", Title: "Fans", Unit: "rotrpm", Targets: []target{{Expr: "node_sysfan_RPM" + s(), LegendFormat: "{{type}} fan {{fan}}"}}}, 8, 8)
+	}
+
+	b.row("Storage")
+	if b.enabled("volume") {
+		b.add(panel{kind: "timeseries", Title: "Volume free space", Unit: "bytes", Targets: []target{{Expr: "node_volume_avail_bytes" + s(), LegendFormat: "{{volume}}"}}}, 8, 8)
+	}
+	if b.enabled("diskstats") {
+		b.add(panel{kind: "timeseries", Title: "Disk throughput", Unit: "Bps", Targets: []target{
+			{Expr: "rate(node_disk_read_bytes_total" + s() + "[$__rate_interval])", LegendFormat: "{{device}} read"},
+			{Expr: "rate(node_disk_written_bytes_total" + s() + "[$__rate_interval])", LegendFormat: "{{device}} written"},
+		}}, 8, 8)
+	}
+	if b.enabled("md") {
+		b.add(panel{kind: "stat", Title: "Degraded RAID members", Unit: "short", Crit: 1, Targets: []target{{Expr: "node_md_disks_degraded" + s(), LegendFormat: "{{device}}"}}}, 8, 8)
+	}
+	if b.enabled("flashcache") || b.enabled("dmcache") {
+		b.add(panel{kind: "timeseries", Title: "SSD cache hit rate", Unit: "percent", Targets: []target{
+			{Expr: "node_flashcache_read_hit_percent" + s(), LegendFormat: "read"},
+			{Expr: "node_flashcache_write_hit_percent" + s(), LegendFormat: "write"},
+		}}, 8, 8)
+	}
+
+	b.row("Network")
+	if b.enabled("netdev") {
+		throughput := func(matchers ...string) []target {
+			return []target{
+				{Expr: "rate(node_network_receive_bytes_total" + s(matchers...) + "[$__rate_interval]) * 8", LegendFormat: "{{device}} received"},
+				{Expr: "rate(node_network_transmit_bytes_total" + s(matchers...) + "[$__rate_interval]) * 8", LegendFormat: "{{device}} transmitted"},
+			}
+		}
+		if b.Generic || len(b.Interfaces) == 0 {
+			b.add(panel{kind: "timeseries", Title: "Network throughput", Unit: "bps", Targets: throughput()}, 12, 8)
+		} else {
+			w := width(len(b.Interfaces), 8, 12)
+			for _, iface := range b.Interfaces {
+				b.add(panel{kind: "timeseries", Title: "Network throughput (" + iface + ")", Unit: "bps", Targets: throughput(fmt.Sprintf("device=%q", iface))}, w, 8)
+			}
+		}
+	}
+	if b.enabled("ping") {
+		b.add(panel{kind: "timeseries", Title: "External round-trip time", Unit: "ms", Targets: []target{{Expr: "node_network_external_roundtrip_time_ms" + s(), LegendFormat: "{{target}}"}}}, 12, 8)
+	}
+
+	b.row("UPS")
+	if b.enabled("ups") {
+		b.add(panel{kind: "stat", Title: "UPS status", Unit: "short", Targets: []target{{Expr: "ups_ups_status" + s(), LegendFormat: "{{ups}} {{status}}"}}}, 6, 4)
+		b.add(panel{kind: "gauge", Title: "UPS battery charge", Unit: "percent", Max: 100, Targets: []target{{Expr: "ups_battery_charge" + s(), LegendFormat: "{{ups}}"}}}, 6, 4)
+		b.add(panel{kind: "stat", Title: "UPS battery runtime", Unit: "s", Targets: []target{{Expr: "ups_battery_runtime" + s(), LegendFormat: "{{ups}}"}}}, 6, 4)
+		b.add(panel{kind: "gauge", Title: "UPS load", Unit: "percent", Max: 100, Warn: 70, Crit: 90, Targets: []target{{Expr: "ups_ups_load" + s(), LegendFormat: "{{ups}}"}}}, 6, 4)
+	}
+
+	b.row("Notifications")
+	if b.enabled("notifications") {
+		b.add(panel{kind: "timeseries", Title: "Notifications", Unit: "short", Targets: []target{
+			{Expr: "increase(qnapexporter_notifications_delivered_total" + s() + "[$__rate_interval])", LegendFormat: "delivered"},
+			{Expr: "increase(qnapexporter_notifications_failed_total" + s() + "[$__rate_interval])", LegendFormat: "failed"},
+			{Expr: "increase(qnapexporter_notifications_dropped_total" + s() + "[$__rate_interval])", LegendFormat: "dropped"},
+			{Expr: "qnapexporter_notification_queue_depth" + s(), LegendFormat: "queued"},
+		}}, 12, 8)
+	}
+
+	b.dropEmptyRows()
+}
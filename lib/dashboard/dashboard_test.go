@@ -0,0 +1,154 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/pedropombeiro/qnapexporter/lib/exporter/prometheus"
+)
+
+// metricSelectorRe matches the metric names of the expressions, which are always followed by a label selector
+var metricSelectorRe = regexp.MustCompile(`([a-zA-Z_:][a-zA-Z0-9_:]*)\{`)
+
+type dashboardJSON struct {
+	Title  string `json:"title"`
+	Panels []struct {
+		ID      int    `json:"id"`
+		Type    string `json:"type"`
+		Title   string `json:"title"`
+		GridPos struct {
+			H, W, X, Y int
+		} `json:"gridPos"`
+		Targets []struct {
+			Expr  string `json:"expr"`
+			RefID string `json:"refId"`
+		} `json:"targets"`
+	} `json:"panels"`
+	Templating struct {
+		List []struct {
+			Name    string                 `json:"name"`
+			Current map[string]interface{} `json:"current"`
+		} `json:"list"`
+	} `json:"templating"`
+}
+
+func collectors(t *testing.T) []exporter.CollectorInfo {
+	e := prometheus.NewExporter(prometheus.ExporterConfig{
+		Logger:            log.New(io.Discard, "", 0),
+		PingTarget:        "1.1.1.1",
+		NotificationStats: func() exporter.NotificationStats { return exporter.NotificationStats{} },
+	}, &exporter.Status{})
+	t.Cleanup(e.Close)
+
+	return e.(exporter.CollectorLister).Collectors()
+}
+
+func generate(t *testing.T, config Config) dashboardJSON {
+	var buf bytes.Buffer
+	require.NoError(t, Generate(&buf, config))
+
+	var d dashboardJSON
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &d), "the dashboard is valid JSON")
+	return d
+}
+
+func TestGenerateReferencesExportedMetrics(t *testing.T) {
+	infos := collectors(t)
+	families := map[string]bool{}
+	var prefixes []string
+	for _, c := range infos {
+		for _, f := range c.Families {
+			if strings.HasSuffix(f, "*") {
+				prefixes = append(prefixes, strings.TrimSuffix(f, "*"))
+				continue
+			}
+			families[f] = true
+		}
+	}
+	exported := func(name string) bool {
+		if families[name] {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	d := generate(t, Config{Generic: true, Collectors: infos})
+
+	var exprs int
+	ids := map[int]bool{}
+	for _, p := range d.Panels {
+		assert.False(t, ids[p.ID], "panel ID %d is unique", p.ID)
+		ids[p.ID] = true
+		assert.LessOrEqual(t, p.GridPos.X+p.GridPos.W, gridWidth, p.Title)
+
+		for _, target := range p.Targets {
+			exprs++
+			matches := metricSelectorRe.FindAllStringSubmatch(target.Expr, -1)
+			require.NotEmpty(t, matches, target.Expr)
+			for _, m := range matches {
+				assert.True(t, exported(m[1]), "%s references unknown metric %q", p.Title, m[1])
+			}
+			assert.Contains(t, target.Expr, `job="qnap",node="$node"`)
+		}
+	}
+	assert.Greater(t, exprs, 20)
+}
+
+func TestGenerateLiveEnvironment(t *testing.T) {
+	infos := []exporter.CollectorInfo{
+		{Name: "hd", Enabled: true, Prerequisites: []exporter.Prerequisite{{Name: "getsysinfo", Found: true}}},
+		{Name: "netdev", Enabled: true},
+		{Name: "ups", Enabled: true, Prerequisites: []exporter.Prerequisite{{Name: "NUT upsd"}}},
+	}
+
+	d := generate(t, Config{Title: "My NAS", Job: "nas", Node: "nas1", Disks: []string{"1", "2", "3"}, Interfaces: []string{"eth0", "eth1"}, Collectors: infos})
+
+	assert.Equal(t, "My NAS", d.Title)
+	var titles []string
+	for _, p := range d.Panels {
+		titles = append(titles, p.Title)
+		for _, target := range p.Targets {
+			assert.Contains(t, target.Expr, `job="nas",node="$node"`)
+		}
+	}
+	assert.Equal(t, []string{
+		"Temperatures and fans", "Disk 1", "Disk 2", "Disk 3", "Disk temperatures",
+		"Network", "Network throughput (eth0)", "Network throughput (eth1)",
+	}, titles, "UPS panels are skipped while the NUT daemon isn't found")
+	require.Len(t, d.Templating.List, 1)
+	assert.Equal(t, "nas1", d.Templating.List[0].Current["value"])
+}
+
+func TestGenerateGeneric(t *testing.T) {
+	infos := []exporter.CollectorInfo{
+		{Name: "hd", Enabled: true, Prerequisites: []exporter.Prerequisite{{Name: "getsysinfo"}}},
+		{Name: "ups", Enabled: true, Prerequisites: []exporter.Prerequisite{{Name: "NUT upsd"}}},
+		{Name: "ping", Enabled: false},
+	}
+
+	d := generate(t, Config{Generic: true, Disks: []string{"1"}, Collectors: infos})
+
+	var titles []string
+	for _, p := range d.Panels {
+		titles = append(titles, p.Title)
+	}
+	assert.Equal(t, []string{
+		"Temperatures and fans", "Disk temperatures",
+		"UPS", "UPS status", "UPS battery charge", "UPS battery runtime", "UPS load",
+	}, titles)
+	assert.Empty(t, d.Templating.List[0].Current)
+}
@@ -11,19 +11,166 @@ type Exporter interface {
 	Close()
 }
 
+// HostnameProvider is implemented by Exporters which detect the hostname of the machine they run on
+type HostnameProvider interface {
+	// Hostname returns the detected hostname, or an empty string if it is not known yet
+	Hostname() string
+}
+
+// HealthReporter is implemented by Exporters which track the outcome of their scrapes
+type HealthReporter interface {
+	// Healthy returns whether the last scrape succeeded, and the one in progress (if any) has been running
+	// for less than timeout
+	Healthy(timeout time.Duration) bool
+}
+
+// DeadlineWriter is implemented by Exporters which can serve something else when a scrape is too slow
+type DeadlineWriter interface {
+	// WriteMetricsWithin writes out the metrics like WriteMetrics, but doesn't have to wait for the scrape
+	// to complete beyond timeout
+	WriteMetricsWithin(w io.Writer, timeout time.Duration) error
+}
+
+// ReadinessReporter is implemented by Exporters which prepare their collection before the first scrape
+type ReadinessReporter interface {
+	// Ready returns nil once the exporter is ready to be scraped, or the reason why it isn't
+	Ready() error
+}
+
+// Reloader is implemented by Exporters which cache state between scrapes, e.g. to reload it on SIGHUP
+type Reloader interface {
+	// Reload drops the cached state, so that it is read again on the next scrape
+	Reload()
+}
+
+// SampleCollector is implemented by Exporters which can return their samples, e.g. to push them to other backends
+type SampleCollector interface {
+	// CollectSamples scrapes the metrics once, returning the samples collected even if some collectors failed
+	CollectSamples() ([]Sample, error)
+}
+
+// Sample is a metric sample collected by an Exporter
+type Sample struct {
+	Name string
+	// Labels holds the labels of the sample, except for the node label (i.e. the hostname)
+	Labels map[string]string
+	Value  float64
+	// Type is the type of the metric family ("counter" or "gauge"), if known
+	Type string
+	Help string
+	// Timestamp is the time of the sample, if it wasn't taken during the scrape
+	Timestamp time.Time
+}
+
+// PushStats holds the counters of the batches of samples pushed to a backend
+type PushStats struct {
+	Sent    uint64
+	Dropped uint64
+	// SamplesSent and SamplesDropped count the samples of these batches
+	SamplesSent    uint64
+	SamplesDropped uint64
+}
+
+// DebugStateReporter is implemented by Exporters which can describe their internal state for debugging
+type DebugStateReporter interface {
+	// DebugState returns a snapshot of the internal state, which can be encoded as JSON
+	DebugState() interface{}
+}
+
+// CollectorLister is implemented by Exporters whose metrics are produced by named collectors
+type CollectorLister interface {
+	// Collectors describes the collectors, checking their prerequisites in the environment
+	Collectors() []CollectorInfo
+	// WriteCollectorMetrics runs the named collector once, even if disabled, and writes out its metrics
+	WriteCollectorMetrics(w io.Writer, name string) error
+}
+
+// SelfChecker is implemented by Exporters which can check that their collectors work, e.g. on startup
+type SelfChecker interface {
+	// SelfCheck runs each enabled collector once and returns their outcomes, which are kept for LastSelfCheck
+	SelfCheck() []SelfCheckResult
+	// LastSelfCheck returns the outcomes of the last self-check, or nil if none has completed yet
+	LastSelfCheck() []SelfCheckResult
+}
+
+// SelfCheckResult is the outcome of running a collector during a self-check
+type SelfCheckResult struct {
+	Collector string `json:"collector"`
+	// Samples is the number of samples collected
+	Samples int `json:"samples"`
+	// Error holds the error returned by the collector, if it failed
+	Error string `json:"error,omitempty"`
+	// Missing lists the prerequisites which weren't found in the environment
+	Missing []string `json:"missing,omitempty"`
+	// Hint suggests how to fix the failure, if known
+	Hint string `json:"hint,omitempty"`
+}
+
+// Failed returns whether the collector returned an error
+func (r SelfCheckResult) Failed() bool {
+	return r.Error != ""
+}
+
+// CollectorInfo describes a collector
+type CollectorInfo struct {
+	Name string `json:"name"`
+	// Enabled is set if the collector is configured to run
+	Enabled bool `json:"enabled"`
+	// Prerequisites lists what the collector needs to find in the environment to produce metrics
+	Prerequisites []Prerequisite `json:"prerequisites"`
+	// Families lists the names of the metric families produced, where a "*" suffix stands for any suffix
+	Families []string `json:"metric_families"`
+}
+
+// Prerequisite is a command, file or service a collector depends on
+type Prerequisite struct {
+	Name  string `json:"name"`
+	Found bool   `json:"found"`
+	// Detail holds e.g. the path where the prerequisite was found
+	Detail string `json:"detail,omitempty"`
+}
+
 type Status struct {
 	Branch, Revision, Built, Version string
 
 	Uptime            time.Time
 	LastFetch         time.Time
 	LastFetchDuration time.Duration
-	MetricCount       int
-	Ups               []string
-	Interfaces        []string
-	Devices           []string
-	Volumes           []string
-	Enclosures        []string
-	DmCaches          []string
-	DmCacheDevice     string
-	Docker            string
+	// LastFetchErrors holds the errors of the collectors which failed during the last scrape, by collector name
+	LastFetchErrors map[string]string
+	MetricCount     int
+	Hostname        string
+	// EnvironmentRead is the time of the last environment read, and EnvironmentError holds its failures, if any
+	EnvironmentRead  time.Time
+	EnvironmentError string
+	// Collectors lists the names of the enabled collectors
+	Collectors []string
+	Ups        []string
+	Interfaces []string
+	Devices    []string
+	// Disks lists the disk slots reporting a temperature
+	Disks         []string
+	Volumes       []string
+	Enclosures    []string
+	DmCaches      []string
+	DmCacheDevice string
+	Docker        string
+}
+
+// NotificationStats holds the counters of the notification queue
+type NotificationStats struct {
+	QueueDepth int
+	Delivered  uint64
+	Failed     uint64
+	Dropped    uint64
+	// Suppressed and RateLimited count the notifications discarded as duplicates or because of the rate limit
+	Suppressed  uint64
+	RateLimited uint64
+	// AnnotationsDeleted counts the Grafana annotations deleted by the retention policy
+	AnnotationsDeleted uint64
+	// Journaled, Replayed and Evicted count the notifications written to the queue journals, delivered from them,
+	// and evicted from them when full
+	Journaled uint64
+	Replayed  uint64
+	Evicted   uint64
 }
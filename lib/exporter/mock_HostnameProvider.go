@@ -0,0 +1,24 @@
+// Code generated by mockery v0.0.0-dev. DO NOT EDIT.
+
+package exporter
+
+import mock "github.com/stretchr/testify/mock"
+
+// MockHostnameProvider is an autogenerated mock type for the HostnameProvider type
+type MockHostnameProvider struct {
+	mock.Mock
+}
+
+// Hostname provides a mock function with given fields:
+func (_m *MockHostnameProvider) Hostname() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
@@ -0,0 +1,62 @@
+package prometheus
+
+import "fmt"
+
+// metricAliases maps the legacy metric names to the names following the Prometheus conventions (base units,
+// spelled out), which are exported alongside them unless ExporterConfig.DropLegacyMetricNames is set
+var metricAliases = map[string]string{
+	"node_cputmp_C":   "node_cpu_temperature_celsius",
+	"node_systmp_C":   "node_system_temperature_celsius",
+	"node_hdtmp_C":    "node_hd_temperature_celsius",
+	"node_sysfan_RPM": "node_fan_speed_rpm",
+}
+
+// aliasMetrics returns the metrics along with the copies of those with a legacy name under their new name, which
+// follow all the other metrics so that the samples of a family stay grouped. The legacy metrics are dropped if
+// DropLegacyMetricNames is set.
+func (e *promExporter) aliasMetrics(metrics []metric) []metric {
+	var aliased []metric
+	for _, m := range metrics {
+		if _, ok := metricAliases[m.name]; ok {
+			aliased = append(aliased, m)
+		}
+	}
+	if len(aliased) == 0 {
+		return metrics
+	}
+
+	result := make([]metric, 0, len(metrics)+len(aliased))
+	for _, m := range metrics {
+		alias, ok := metricAliases[m.name]
+		switch {
+		case !ok:
+			result = append(result, m)
+		case !e.DropLegacyMetricNames:
+			m.help = fmt.Sprintf("%s (deprecated, use %s)", m.help, alias)
+			result = append(result, m)
+		}
+	}
+	for _, m := range aliased {
+		m.name = metricAliases[m.name]
+		result = append(result, m)
+	}
+
+	return result
+}
+
+// aliasFamilies returns the families with the new names of the legacy ones, as reported by aliasMetrics
+func (e *promExporter) aliasFamilies(families []string) []string {
+	result := make([]string, 0, len(families))
+	var aliases []string
+	for _, family := range families {
+		alias, ok := metricAliases[family]
+		if ok {
+			aliases = append(aliases, alias)
+		}
+		if !ok || !e.DropLegacyMetricNames {
+			result = append(result, family)
+		}
+	}
+
+	return append(result, aliases...)
+}
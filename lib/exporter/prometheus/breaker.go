@@ -0,0 +1,175 @@
+package prometheus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerThreshold is the default value of BreakerConfig.Threshold
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerBackoff is the time a collector is first skipped for when no backoff is configured
+	DefaultBreakerBackoff = 1 * time.Minute
+	// DefaultBreakerMaxBackoff is the longest time a collector is skipped for when no maximum is configured
+	DefaultBreakerMaxBackoff = 30 * time.Minute
+)
+
+// BreakerConfig configures the skipping of the collectors which keep failing, e.g. when getsysinfo breaks after a
+// firmware upgrade
+type BreakerConfig struct {
+	// Threshold is the number of consecutive failures after which a collector is degraded (never, if zero)
+	Threshold int
+	// Backoff is the time a degraded collector is skipped for before running it again, doubling after each
+	// failure up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// breakerState tracks the consecutive failures of a collector
+type breakerState struct {
+	failures int
+	degraded bool
+	// retryAt is the time from which a degraded collector is run again
+	retryAt time.Time
+	backoff time.Duration
+}
+
+// withDefaults returns the configuration, with the default backoffs if unset
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.Backoff <= 0 {
+		c.Backoff = DefaultBreakerBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultBreakerMaxBackoff
+	}
+	if c.MaxBackoff < c.Backoff {
+		c.MaxBackoff = c.Backoff
+	}
+
+	return c
+}
+
+// collectorBreakers tracks the collectors which keep failing, by name. The zero value never degrades them.
+type collectorBreakers struct {
+	BreakerConfig
+
+	mu     sync.Mutex
+	states map[string]*breakerState
+}
+
+// enabled returns whether collectors are ever degraded
+func (b *collectorBreakers) enabled() bool {
+	return b.Threshold > 0
+}
+
+// skip returns whether the collector is degraded and shouldn't be run until its backoff has elapsed
+func (b *collectorBreakers) skip(collector string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.states[collector]
+	return s != nil && s.degraded && now.Before(s.retryAt)
+}
+
+// recordSuccess resets the failures of the collector, returning whether it was degraded
+func (b *collectorBreakers) recordSuccess(collector string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.states[collector]
+	if s == nil {
+		return false
+	}
+	delete(b.states, collector)
+
+	return s.degraded
+}
+
+// recordFailure counts a failure of the collector, returning the time it is skipped for if it is degraded by it
+// (or by the failure of the probe which ran after its backoff)
+func (b *collectorBreakers) recordFailure(collector string, now time.Time) time.Duration {
+	if !b.enabled() {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.states == nil {
+		b.states = map[string]*breakerState{}
+	}
+	s := b.states[collector]
+	if s == nil {
+		s = &breakerState{}
+		b.states[collector] = s
+	}
+	s.failures++
+	switch {
+	case s.degraded:
+		s.backoff *= 2
+		if s.backoff > b.MaxBackoff {
+			s.backoff = b.MaxBackoff
+		}
+	case s.failures >= b.Threshold:
+		s.degraded, s.backoff = true, b.Backoff
+	default:
+		return 0
+	}
+	s.retryAt = now.Add(s.backoff)
+
+	return s.backoff
+}
+
+// reset forgets the failures of all the collectors
+func (b *collectorBreakers) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.states = nil
+}
+
+// degraded returns the names of the degraded collectors, sorted
+func (b *collectorBreakers) degraded() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var names []string
+	for name, s := range b.states {
+		if s.degraded {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// getBreakerMetrics reports whether each enabled collector is degraded
+func (e *promExporter) getBreakerMetrics() ([]metric, error) {
+	degraded := map[string]bool{}
+	for _, name := range e.breakers.degraded() {
+		degraded[name] = true
+	}
+
+	var metrics []metric
+	for _, c := range e.collectors {
+		if !c.Enabled() || c.name == "breaker" {
+			continue
+		}
+		value := 0.0
+		if degraded[c.name] {
+			value = 1
+		}
+		metrics = append(metrics, metric{
+			name:       "node_scrape_collector_degraded",
+			attr:       fmt.Sprintf("collector=%q", c.name),
+			value:      value,
+			help:       "Whether the collector is skipped after failing repeatedly",
+			metricType: "gauge",
+		})
+	}
+
+	return metrics, nil
+}
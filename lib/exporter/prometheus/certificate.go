@@ -0,0 +1,146 @@
+package prometheus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultCertificateFile is the certificate of the QTS web UI
+	DefaultCertificateFile = "/etc/stunnel/stunnel.pem"
+
+	certificateTimeout = 5 * time.Second
+)
+
+// CertificateConfig lists the certificates whose expiry is reported
+type CertificateConfig struct {
+	// Files are the paths of PEM files holding certificates, relative to the root file system
+	Files []string
+	// Targets are the host:port addresses whose TLS certificate is retrieved with a handshake
+	Targets []string
+}
+
+// Enabled returns whether any certificate source is configured
+func (c CertificateConfig) Enabled() bool {
+	return len(c.Files) > 0 || len(c.Targets) > 0
+}
+
+// getCertificateMetrics reports the expiry of the certificates of each source, along with whether it could be read,
+// so that a missing file or unreachable target doesn't hide the other sources
+func (e *promExporter) getCertificateMetrics() ([]metric, error) {
+	type source struct {
+		name string
+		read func() ([]*x509.Certificate, error)
+	}
+	sources := make([]source, 0, len(e.Certificates.Files)+len(e.Certificates.Targets))
+	for _, file := range e.Certificates.Files {
+		path := e.Paths.rootPath(file)
+		sources = append(sources, source{name: file, read: func() ([]*x509.Certificate, error) { return readCertificateFile(path) }})
+	}
+	for _, target := range e.Certificates.Targets {
+		target := target
+		sources = append(sources, source{name: target, read: func() ([]*x509.Certificate, error) { return readCertificateTarget(target) }})
+	}
+
+	metrics := make([]metric, 0, 2*len(sources))
+	for _, s := range sources {
+		attr := fmt.Sprintf("source=%q", s.name)
+		failed := 0.0
+		certs, err := s.read()
+		if err != nil {
+			e.Logger.Printf("Failed to read the certificate of %s: %v", s.name, err)
+			failed = 1
+		} else {
+			metrics = append(metrics, metric{
+				name:       "node_certificate_expiry_timestamp_seconds",
+				attr:       attr,
+				value:      float64(earliestExpiry(certs).Unix()),
+				help:       "Time the first of the certificates of the source expires, in seconds since the epoch",
+				metricType: "gauge",
+			})
+		}
+		metrics = append(metrics, metric{
+			name:       "node_certificate_error",
+			attr:       attr,
+			value:      failed,
+			help:       "Whether the certificates of the source couldn't be read",
+			metricType: "gauge",
+		})
+	}
+
+	return metrics, nil
+}
+
+// readCertificateFile returns the certificates of the PEM file in path, ignoring its other blocks (e.g. the private key)
+func readCertificateFile(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate in %s: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+
+	return certs, nil
+}
+
+// readCertificateTarget returns the certificates presented by target (host:port) in a TLS handshake.
+// They aren't verified, since the certificates of NAS are often self-signed.
+func readCertificateTarget(target string) ([]*x509.Certificate, error) {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: host,
+		//nolint:gosec // Only the expiry of the certificates is read
+		InsecureSkipVerify: true,
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: certificateTimeout}, "tcp", target, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+
+	return certs, nil
+}
+
+func earliestExpiry(certs []*x509.Certificate) time.Time {
+	expiry := certs[0].NotAfter
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+
+	return expiry
+}
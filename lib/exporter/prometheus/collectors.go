@@ -0,0 +1,391 @@
+package prometheus
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+const (
+	upsdHost    = "127.0.0.1"
+	upsdPort    = "3493"
+	upsdTimeout = 1 * time.Second
+)
+
+// collector is a named group of related metrics
+type collector struct {
+	name string
+	// families lists the metric families produced, where a "*" suffix stands for any suffix
+	families []string
+	fetch    fetchMetricFn
+	// enabled returns whether the collector is configured to run (always, if nil)
+	enabled func() bool
+	// check returns the prerequisites of the collector in the current environment (none, if nil)
+	check func() []exporter.Prerequisite
+	// wakesDisks marks the collectors which spin the disks up, e.g. by querying their temperature or SMART status,
+	// which are paused during the quiet hours
+	wakesDisks bool
+	// slow marks the collectors which run commands (e.g. getsysinfo) or network probes, which start first when
+	// ExporterConfig.StartSpread staggers the collectors
+	slow bool
+}
+
+// collectorError is the error returned by a collector during a scrape
+type collectorError struct {
+	collector string
+	err       error
+}
+
+func (e *collectorError) Error() string {
+	return fmt.Sprintf("retrieve %s metrics: %v", e.collector, e.err)
+}
+
+func (e *collectorError) Unwrap() error {
+	return e.err
+}
+
+// collectorSuccess is sent by a collector which succeeded during a scrape, after its metrics
+type collectorSuccess string
+
+// Describe returns the metric families produced by the collector
+func (c collector) Describe() []string {
+	return c.families
+}
+
+// Check returns the prerequisites of the collector, and whether they were found in the environment
+func (c collector) Check() []exporter.Prerequisite {
+	if c.check == nil {
+		return []exporter.Prerequisite{}
+	}
+
+	return c.check()
+}
+
+// Collect fetches the metrics of the collector
+func (c collector) Collect() ([]metric, error) {
+	return c.fetch()
+}
+
+// Enabled returns whether the collector is configured to run
+func (c collector) Enabled() bool {
+	return c.enabled == nil || c.enabled()
+}
+
+func (e *promExporter) newCollectors() []collector {
+	return []collector{
+		{name: "version", families: []string{"go_program", "qnapexporter_process_start_time_seconds"}, fetch: e.getVersionMetrics},
+		{name: "uptime", families: []string{"node_time_seconds"}, fetch: getUptimeMetrics},
+		{
+			name:     "loadavg",
+			families: []string{"node_load1", "node_load5", "node_load15", "node_load1_per_cpu", "node_load5_per_cpu", "node_load15_per_cpu"},
+			fetch:    e.getLoadAvgMetrics,
+		},
+		{name: "cpu", families: []string{"node_cpu_seconds_total", "node_cpu_count"}, fetch: func() ([]metric, error) { return getCpuRatioMetrics(e.cpuCount) }},
+		{
+			name: "meminfo",
+			families: []string{
+				"node_memory_MemTotal_bytes", "node_memory_MemFree_bytes", "node_memory_Cached_bytes", "node_memory_Active_bytes",
+				"node_memory_Inactive_bytes", "node_memory_SwapTotal_bytes", "node_memory_SwapFree_bytes", "node_memory_MemAvailable_bytes",
+			},
+			fetch: getMemInfoMetrics,
+		},
+		{name: "edac", families: []string{"node_edac_*"}, fetch: e.getEdacMetrics, check: e.checkEdac},
+		{name: "ups", families: []string{"ups_*", "ups_ups_status"}, fetch: e.getUpsStatsMetricsWithRetry, check: checkUpsd, slow: true},
+		{name: "temperature", families: []string{"node_cputmp_C", "node_systmp_C"}, fetch: e.getSysInfoTempMetrics, check: e.checkSensors, slow: true},
+		{name: "fan", families: []string{"node_sysfan_RPM"}, fetch: e.getSysInfoFanMetrics, check: e.checkSensors, slow: true},
+		{name: "enclosure", families: []string{"node_sysfan_RPM"}, fetch: e.getEnclosureFanMetrics, check: e.checkEnclosures, slow: true},
+		{
+			name:     "expansion",
+			families: []string{"node_enclosure_present", "node_enclosure_temperature_celsius"},
+			fetch:    e.getExpansionMetrics,
+			check:    e.checkExpansionUnits,
+			slow:     true,
+		},
+		{name: "hd", families: []string{"node_hdtmp_C"}, fetch: e.getSysInfoHdMetrics, check: e.checkSensors, wakesDisks: true, slow: true},
+		{name: "volume", families: []string{"node_volume_avail_bytes", "node_volume_size_bytes", "node_volume_status", "node_volume_temperature_celsius"}, fetch: e.getSysInfoVolMetrics, check: e.checkGetsysinfo, wakesDisks: true, slow: true},
+		{
+			name: "diskstats",
+			families: []string{
+				"node_disk_read_bytes_total", "node_disk_written_bytes_total", "node_disk_read_ops_total", "node_disk_write_ops_total",
+				"node_disk_read_time_msec", "node_disk_write_time_msec", "node_disk_iops_in_progress", "node_disk_iotime_msec",
+				"node_disk_read_time_seconds_total", "node_disk_write_time_seconds_total", "node_disk_io_now", "node_disk_io_time_weighted_seconds_total",
+				"node_disk_scsi_ioerr_total", "node_disk_scsi_iorequest_total", "node_disk_scsi_iodone_total", "node_disk_ata_errors",
+			},
+			fetch: e.getDiskStatsMetrics,
+			check: e.checkDevices,
+		},
+		{name: "flashcache", families: []string{"node_flashcache_*"}, fetch: e.getFlashCacheStatsMetrics, check: e.checkFlashCache},
+		{
+			name: "dmcache",
+			families: []string{
+				"node_flashcache_cached_blocks", "node_flashcache_total_blocks", "node_dmcache_used_bytes_total", "node_dmcache_bytes_total",
+				"node_dmcache_read_hit_total", "node_dmcache_read_total", "node_dmcache_read_hit_percent",
+				"node_dmcache_write_hit_total", "node_dmcache_write_total", "node_dmcache_write_hit_percent",
+				// Also reported with the names of the flashcache metrics, for compatibility with QTS 4 dashboards
+				"node_flashcache_read_hits", "node_flashcache_reads", "node_flashcache_read_hit_percent",
+				"node_flashcache_write_hits", "node_flashcache_writes", "node_flashcache_write_hit_percent",
+			},
+			fetch: e.getDmCacheStatsMetrics,
+			check: e.checkDmCache,
+			slow:  true,
+		},
+		{
+			name:     "netdev",
+			families: []string{"node_network_receive_bytes_total", "node_network_transmit_bytes_total", "node_network_up"},
+			fetch:    e.getNetworkStatsMetrics,
+			check:    e.checkInterfaces,
+		},
+		{
+			name:     "netaddr",
+			families: []string{"node_network_address_assigned", "node_network_address_info"},
+			fetch:    e.getNetworkAddressMetrics,
+			check:    e.checkInterfaces,
+		},
+		{
+			name:       "storage",
+			families:   []string{"qnap_pool_size_bytes", "qnap_pool_used_bytes", "qnap_pool_status", "qnap_raid_group_status"},
+			fetch:      e.getStoragePoolMetrics,
+			check:      e.checkQcliStorage,
+			wakesDisks: true,
+			slow:       true,
+		},
+		{
+			name:     "ethtool",
+			families: []string{"node_ethtool_*"},
+			fetch:    e.getEthtoolMetrics,
+			enabled:  func() bool { return e.EthtoolStats },
+			check:    e.checkEthtool,
+			slow:     true,
+		},
+		{
+			name:     "ping",
+			families: []string{"node_network_external_roundtrip_time_ms"},
+			fetch:    e.getPingMetrics,
+			enabled:  func() bool { return e.PingTarget != "" },
+			slow:     true,
+		},
+		{name: "qpkg", families: []string{"qnap_qpkg_info"}, fetch: e.getQpkgMetrics, check: e.checkQpkgs},
+		{
+			name:       "quota",
+			families:   []string{"node_quota_used_bytes", "node_quota_limit_bytes"},
+			fetch:      e.getQuotaMetrics,
+			enabled:    func() bool { return e.Quota.Enabled },
+			check:      e.checkQuota,
+			wakesDisks: true,
+			slow:       true,
+		},
+		{
+			name:     "gpu",
+			families: []string{"node_gpu_temperature_celsius", "node_gpu_frequency_hertz", "node_gpu_frequency_max_hertz"},
+			fetch:    e.getGPUMetrics,
+			enabled:  func() bool { return e.GPUStats },
+			check:    e.checkGPU,
+		},
+		{
+			name:     "malware",
+			families: []string{"qnap_malware_scan_last_timestamp_seconds", "qnap_malware_scan_threats_found"},
+			fetch:    e.getMalwareScanMetrics,
+			enabled:  func() bool { return e.MalwareScanStats },
+			check:    e.checkMalwareRemover,
+		},
+		{
+			name:     "certificate",
+			families: []string{"node_certificate_expiry_timestamp_seconds", "node_certificate_error"},
+			fetch:    e.getCertificateMetrics,
+			enabled:  e.Certificates.Enabled,
+		},
+		{
+			name:     "ntp",
+			families: []string{"node_ntp_offset_seconds", "node_ntp_rtt_seconds"},
+			fetch:    e.getNTPMetrics,
+			enabled:  func() bool { return e.NTPServer != "" },
+			slow:     true,
+		},
+		{
+			name:     "dns",
+			families: []string{"node_dns_lookup_duration_seconds", "node_dns_lookup_success"},
+			fetch:    e.getDNSMetrics,
+			enabled:  e.DNS.Enabled,
+			slow:     true,
+		},
+		{name: "sessions", families: []string{"node_logged_in_users"}, fetch: e.getSessionMetrics, check: e.checkSessions, slow: true},
+		{name: "timex", families: []string{"node_timex_sync_status"}, fetch: getTimexMetrics},
+		{name: "md", families: []string{"node_md_disks", "node_md_disks_degraded", "node_disk_member_of"}, fetch: e.getMdArrayMetrics, check: e.checkMdArrays},
+		{
+			name: "notifications",
+			families: []string{
+				"qnapexporter_notification_queue_depth", "qnapexporter_notifications_delivered_total", "qnapexporter_notifications_failed_total",
+				"qnapexporter_notifications_dropped_total", "qnapexporter_notifications_suppressed_total", "qnapexporter_annotations_deleted_total",
+				"qnapexporter_notifications_journaled_total", "qnapexporter_notifications_replayed_total", "qnapexporter_notifications_evicted_total",
+			},
+			fetch:   e.getNotificationMetrics,
+			enabled: func() bool { return e.NotificationStats != nil },
+		},
+		{name: "dedup", families: []string{"qnapexporter_duplicate_samples_dropped_total"}, fetch: e.getDuplicateMetrics},
+		{name: "scrape", families: []string{"qnapexporter_scrape_samples", "qnapexporter_scrape_response_bytes"}, fetch: e.getScrapeSizeMetrics},
+		{
+			name:     "scrapeduration",
+			families: []string{"node_scrape_duration_warning"},
+			fetch:    e.getScrapeDurationMetrics,
+			enabled:  func() bool { return e.ScrapeDuration.Timeout > 0 },
+		},
+		{
+			name:     "breaker",
+			families: []string{"node_scrape_collector_degraded"},
+			fetch:    e.getBreakerMetrics,
+			enabled:  e.breakers.enabled,
+		},
+		{
+			name:     "paused",
+			families: []string{"node_collector_paused"},
+			fetch:    e.getPausedMetrics,
+			enabled:  func() bool { return len(e.QuietHours) > 0 },
+		},
+		{
+			name:     "push",
+			families: []string{"qnapexporter_push_batches_sent_total", "qnapexporter_push_batches_dropped_total", "qnapexporter_push_samples_sent_total", "qnapexporter_push_samples_dropped_total"},
+			fetch:    e.getPushMetrics,
+			enabled:  func() bool { return e.PushStats != nil },
+		},
+		{
+			name:     "connlog",
+			families: []string{"qnap_connlog_events_total"},
+			fetch:    e.getConnLogMetrics,
+			enabled:  func() bool { return e.ConnLogStats != nil },
+		},
+	}
+}
+
+// Collectors describes the collectors, reading the environment first if needed to check their prerequisites
+func (e *promExporter) Collectors() []exporter.CollectorInfo {
+	e.fetchMu.Lock()
+	defer e.fetchMu.Unlock()
+
+	e.refreshEnvironment()
+
+	infos := make([]exporter.CollectorInfo, 0, len(e.collectors))
+	for _, c := range e.collectors {
+		infos = append(infos, exporter.CollectorInfo{
+			Name:          c.name,
+			Enabled:       c.Enabled(),
+			Prerequisites: c.Check(),
+			Families:      c.Describe(),
+		})
+	}
+
+	return infos
+}
+
+// WriteCollectorMetrics reads the environment if needed, then runs the named collector once (even if disabled)
+// and writes out its metrics
+func (e *promExporter) WriteCollectorMetrics(w io.Writer, name string) error {
+	e.fetchMu.Lock()
+	defer e.fetchMu.Unlock()
+
+	var names []string
+	for _, c := range e.collectors {
+		if c.name != name {
+			names = append(names, c.name)
+			continue
+		}
+
+		e.refreshEnvironment()
+		metrics, err := c.Collect()
+		e.writeMetrics(w, e.aliasMetrics(metrics), map[string]bool{})
+		if err != nil {
+			return &collectorError{collector: c.name, err: err}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown collector %q (expected one of %s)", name, strings.Join(names, ", "))
+}
+
+func checkUpsd() []exporter.Prerequisite {
+	address := net.JoinHostPort(upsdHost, upsdPort)
+	conn, err := net.DialTimeout("tcp", address, upsdTimeout)
+	if err != nil {
+		return []exporter.Prerequisite{{Name: "NUT upsd", Detail: err.Error()}}
+	}
+	conn.Close()
+
+	return []exporter.Prerequisite{{Name: "NUT upsd", Found: true, Detail: address}}
+}
+
+func (e *promExporter) checkGetsysinfo() []exporter.Prerequisite {
+	return []exporter.Prerequisite{{Name: "getsysinfo", Found: e.getsysinfo != "", Detail: e.getsysinfo}}
+}
+
+// checkSensors reports getsysinfo, or hal_app if it is used instead
+func (e *promExporter) checkSensors() []exporter.Prerequisite {
+	if e.halAppSensors() {
+		return []exporter.Prerequisite{{Name: "hal_app", Found: true, Detail: e.hal_app}}
+	}
+
+	return e.checkGetsysinfo()
+}
+
+func (e *promExporter) checkEnclosures() []exporter.Prerequisite {
+	names := make([]string, 0, len(e.enclosures))
+	for _, enc := range e.enclosures {
+		names = append(names, enc.name)
+	}
+
+	return []exporter.Prerequisite{
+		{Name: "hal_app", Found: e.hal_app != "", Detail: e.hal_app},
+		{Name: "enclosures with fans", Found: len(names) > 0, Detail: strings.Join(names, ", ")},
+	}
+}
+
+func (e *promExporter) checkDevices() []exporter.Prerequisite {
+	return []exporter.Prerequisite{{Name: "block devices", Found: len(e.devices) > 0, Detail: strings.Join(e.devices, ", ")}}
+}
+
+func (e *promExporter) checkFlashCache() []exporter.Prerequisite {
+	path := e.Paths.procPath(flashcacheStatsPath)
+	_, err := os.Stat(path)
+
+	return []exporter.Prerequisite{
+		{Name: "kernel 4", Found: e.kernelVersion < 5, Detail: strconv.Itoa(e.kernelVersion)},
+		{Name: "flashcache statistics", Found: err == nil, Detail: path},
+	}
+}
+
+func (e *promExporter) checkDmCache() []exporter.Prerequisite {
+	dmsetup, _ := exec.LookPath("dmsetup")
+
+	return []exporter.Prerequisite{
+		{Name: "kernel 5 or later", Found: e.kernelVersion >= 5, Detail: strconv.Itoa(e.kernelVersion)},
+		{Name: "dmsetup", Found: dmsetup != "", Detail: dmsetup},
+		{Name: "dm-cache clients", Found: len(e.dmCacheClients) > 0, Detail: strings.Join(e.dmCacheClients, ", ")},
+	}
+}
+
+func (e *promExporter) checkEdac() []exporter.Prerequisite {
+	path := e.Paths.sysPath(edacDir)
+	_, err := os.Stat(path)
+
+	return []exporter.Prerequisite{{Name: "EDAC memory controllers", Found: err == nil, Detail: path}}
+}
+
+func (e *promExporter) checkInterfaces() []exporter.Prerequisite {
+	return []exporter.Prerequisite{{Name: "network interfaces", Found: len(e.ifaces) > 0, Detail: strings.Join(e.ifaces, ", ")}}
+}
+
+func (e *promExporter) checkMdArrays() []exporter.Prerequisite {
+	arrays, _ := readMdArrays(e.Paths.sysPath(blockDir))
+	names := make([]string, 0, len(arrays))
+	for _, a := range arrays {
+		names = append(names, a.name)
+	}
+
+	return []exporter.Prerequisite{{Name: "md arrays", Found: len(names) > 0, Detail: strings.Join(names, ", ")}}
+}
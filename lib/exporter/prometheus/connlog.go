@@ -0,0 +1,29 @@
+package prometheus
+
+import (
+	"fmt"
+	"sort"
+)
+
+func (e *promExporter) getConnLogMetrics() ([]metric, error) {
+	counts := e.ConnLogStats()
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+
+	metrics := make([]metric, 0, len(rules))
+	for _, rule := range rules {
+		metrics = append(metrics, metric{
+			name:       "qnap_connlog_events_total",
+			attr:       fmt.Sprintf("rule=%q", rule),
+			value:      float64(counts[rule]),
+			help:       "Number of records of the QTS connection log matched by the rule since the exporter started",
+			metricType: "counter",
+			created:    e.startTime,
+		})
+	}
+
+	return metrics, nil
+}
@@ -4,65 +4,72 @@ import (
 	"github.com/shirou/gopsutil/v3/cpu"
 )
 
-func getCpuRatioMetrics() ([]metric, error) {
+// getCpuRatioMetrics reports the time spent in each mode, summed over the cpuCount logical CPUs
+func getCpuRatioMetrics(cpuCount int) ([]metric, error) {
 	a, err := cpu.Times(false)
 	if err != nil {
 		return nil, err
 	}
 	s := a[0]
 
-	counts, err := cpu.Counts(false)
-	if err != nil {
-		return nil, err
-	}
-
 	metrics := []metric{
 		{
 			name:       "node_cpu_seconds_total",
 			attr:       `mode="user"`,
+			help:       "Seconds the CPUs spent in each mode",
 			metricType: "counter",
 			value:      float64(s.User),
 		},
 		{
 			name:       "node_cpu_seconds_total",
 			attr:       `mode="nice"`,
+			help:       "Seconds the CPUs spent in each mode",
 			metricType: "counter",
 			value:      float64(s.Nice),
 		},
 		{
 			name:       "node_cpu_seconds_total",
 			attr:       `mode="system"`,
+			help:       "Seconds the CPUs spent in each mode",
 			metricType: "counter",
 			value:      float64(s.System),
 		},
 		{
 			name:       "node_cpu_seconds_total",
 			attr:       `mode="idle"`,
+			help:       "Seconds the CPUs spent in each mode",
 			metricType: "counter",
 			value:      float64(s.Idle),
 		},
 		{
 			name:       "node_cpu_seconds_total",
 			attr:       `mode="iowait"`,
+			help:       "Seconds the CPUs spent in each mode",
 			metricType: "counter",
 			value:      float64(s.Iowait),
 		},
 		{
 			name:       "node_cpu_seconds_total",
 			attr:       `mode="irq"`,
+			help:       "Seconds the CPUs spent in each mode",
 			metricType: "counter",
 			value:      float64(s.Irq),
 		},
 		{
 			name:       "node_cpu_seconds_total",
 			attr:       `mode="softirq"`,
+			help:       "Seconds the CPUs spent in each mode",
 			metricType: "counter",
 			value:      float64(s.Softirq),
 		},
-		{
-			name:  "node_cpu_count",
-			value: float64(counts),
-		},
+	}
+	if cpuCount > 0 {
+		metrics = append(metrics, metric{
+			name:       "node_cpu_count",
+			value:      float64(cpuCount),
+			help:       "Number of logical CPUs",
+			metricType: "gauge",
+		})
 	}
 
 	return metrics, nil
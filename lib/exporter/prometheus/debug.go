@@ -0,0 +1,82 @@
+package prometheus
+
+import (
+	"time"
+)
+
+// debugState is a snapshot of the internal state of the exporter, served as JSON for debugging
+type debugState struct {
+	EnvironmentExpiry time.Time `json:"environment_expiry"`
+	Devices           []string  `json:"devices"`
+	Interfaces        []string  `json:"interfaces"`
+	Volumes           struct {
+		LastFetch time.Time     `json:"last_fetch"`
+		Expiry    time.Time     `json:"expiry"`
+		Cache     []debugVolume `json:"cache"`
+	} `json:"volumes"`
+	// LastFetchErrors holds the errors of the collectors which failed during the last scrape, by collector name
+	LastFetchErrors map[string]string `json:"last_fetch_errors"`
+	Ups             struct {
+		Connected          bool      `json:"connected"`
+		ConnectionAttempts int       `json:"connection_attempts"`
+		LastError          string    `json:"last_error,omitempty"`
+		LastErrorTime      time.Time `json:"last_error_time"`
+		Devices            []string  `json:"devices"`
+	} `json:"ups"`
+}
+
+type debugVolume struct {
+	Index          string  `json:"index"`
+	Description    string  `json:"description"`
+	FileSystem     string  `json:"file_system"`
+	Status         string  `json:"status"`
+	FreeSizeBytes  float64 `json:"free_size_bytes"`
+	TotalSizeBytes float64 `json:"total_size_bytes"`
+}
+
+// DebugState returns a snapshot of the internal state of the exporter, taken between scrapes
+func (e *promExporter) DebugState() interface{} {
+	e.fetchMu.Lock()
+	defer e.fetchMu.Unlock()
+
+	var s debugState
+	s.EnvironmentExpiry = e.envExpiry
+	s.Devices = append([]string{}, e.devices...)
+	s.Interfaces = append([]string{}, e.ifaces...)
+	s.Volumes.LastFetch = e.volumeLastFetch
+	if !e.volumeLastFetch.IsZero() {
+		s.Volumes.Expiry = e.volumeLastFetch.Add(volumeValidity)
+	}
+	s.Volumes.Cache = make([]debugVolume, 0, len(e.volumes))
+	for _, v := range e.volumes {
+		s.Volumes.Cache = append(s.Volumes.Cache, debugVolume{
+			Index:          v.index,
+			Description:    v.description,
+			FileSystem:     v.fileSystem,
+			Status:         v.status,
+			FreeSizeBytes:  v.freeSizeBytes,
+			TotalSizeBytes: v.totalSizeBytes,
+		})
+	}
+	s.LastFetchErrors = make(map[string]string, len(e.lastFetchErrors))
+	for collector, err := range e.lastFetchErrors {
+		s.LastFetchErrors[collector] = err
+	}
+
+	e.upsState.upsLock.Lock()
+	defer e.upsState.upsLock.Unlock()
+	s.Ups.Connected = e.upsState.upsClient.ProtocolVersion != ""
+	s.Ups.ConnectionAttempts = e.upsState.upsConnAttempts
+	if e.upsState.upsConnErr != nil {
+		s.Ups.LastError = e.upsState.upsConnErr.Error()
+		s.Ups.LastErrorTime = e.upsState.upsConnErrTimestamp
+	}
+	s.Ups.Devices = []string{}
+	if e.upsState.upsList != nil {
+		for _, ups := range *e.upsState.upsList {
+			s.Ups.Devices = append(s.Ups.Devices, ups.Name)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,55 @@
+package prometheus
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// seriesKey identifies the series of a metric by its name and labels, sorted so that their order doesn't matter
+func seriesKey(m metric) string {
+	labels := parseLabels(m.attr)
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(m.name)
+	for _, name := range names {
+		sb.WriteString("\x00" + name + "=" + labels[name])
+	}
+
+	return sb.String()
+}
+
+// dropDuplicates returns the metrics whose series aren't in seen yet, adding them to it. A series exported twice
+// in a scrape makes Prometheus reject the whole scrape, so the later samples are dropped and logged instead.
+func (e *promExporter) dropDuplicates(metrics []metric, seen map[string]bool) []metric {
+	kept := make([]metric, 0, len(metrics))
+	for _, m := range metrics {
+		key := seriesKey(m)
+		if seen[key] {
+			atomic.AddUint64(&e.duplicateSamples, 1)
+			e.Logger.Printf("Dropped duplicate sample of %s{%s}\n", m.name, m.attr)
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, m)
+	}
+
+	return kept
+}
+
+func (e *promExporter) getDuplicateMetrics() ([]metric, error) {
+	return []metric{
+		{
+			name:       "qnapexporter_duplicate_samples_dropped_total",
+			value:      float64(atomic.LoadUint64(&e.duplicateSamples)),
+			help:       "Total number of samples dropped since their series was already exported in the scrape",
+			metricType: "counter",
+			created:    e.startTime,
+		},
+	}, nil
+}
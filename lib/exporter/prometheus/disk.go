@@ -1,63 +1,168 @@
 package prometheus
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
 	"github.com/pedropombeiro/qnapexporter/lib/utils"
 	"github.com/shirou/gopsutil/v3/disk"
 )
 
+// scsiCounters lists the I/O counters of the SCSI devices in sysfs, which are hex-encoded
+var scsiCounters = []struct{ attr, name, help string }{
+	{"ioerr_cnt", "node_disk_scsi_ioerr_total", "Total number of SCSI commands completed with an error"},
+	{"iorequest_cnt", "node_disk_scsi_iorequest_total", "Total number of SCSI commands issued"},
+	{"iodone_cnt", "node_disk_scsi_iodone_total", "Total number of SCSI commands completed"},
+}
+
+var ataPortRe = regexp.MustCompile(`^ata(\d+)$`)
+
+// diskQuery holds the outcome of the getsysinfo queries for a disk slot
+type diskQuery struct {
+	temp, smart string
+	err         error
+}
+
 func (e *promExporter) getSysInfoHdMetrics() ([]metric, error) {
-	if e.getsysinfo == "" {
+	// The count is negative if it couldn't be read, which is reported by the readiness of the exporter
+	if (e.getsysinfo == "" && !e.halAppSensors()) || e.syshdnum < 0 {
 		return nil, nil
 	}
 
+	queries := e.queryDisks(e.syshdnum, e.queryDisk)
+
 	metrics := make([]metric, 0, e.syshdnum)
+	disks := make([]string, 0, e.syshdnum)
+	var failures []string
 	highestAvailable := 0
-
-	for hdnum := 1; hdnum <= e.syshdnum; hdnum++ {
+	for index, q := range queries {
+		hdnum := index + 1
 		hdnumStr := strconv.Itoa(hdnum)
-		tempStr, err := utils.ExecCommand(e.getsysinfo, "hdtmp", hdnumStr)
-		if err != nil {
-			return nil, err
+		if q.err != nil {
+			failures = append(failures, fmt.Sprintf("disk %d: %v", hdnum, q.err))
+			// Keep querying the disk on the next scrapes
+			highestAvailable = hdnum
+			continue
 		}
-		if strings.HasPrefix(tempStr, "--") {
+		if strings.HasPrefix(q.temp, "--") {
 			continue
 		}
+		highestAvailable = hdnum
 
-		smart, err := utils.ExecCommand(e.getsysinfo, "hdsmart", hdnumStr)
-		if err != nil {
-			return nil, err
-		}
+		e.trackDiskSmart(hdnumStr, q.smart)
 
-		temp, err := strconv.ParseFloat(strings.SplitN(tempStr, " ", 2)[0], 64)
+		temp, err := parseTemperature(q.temp)
 		if err != nil {
-			return metrics, err
+			failures = append(failures, fmt.Sprintf("disk %d: %v", hdnum, err))
+			continue
 		}
+		e.watchTemperature(thermalClassDisk, "Disk "+hdnumStr, temp, time.Now())
 
 		metrics = append(metrics, metric{
-			name:  "node_hdtmp_C",
-			attr:  fmt.Sprintf(`hd=%q,smart=%q`, hdnumStr, smart),
-			value: temp,
+			name:       "node_hdtmp_C",
+			attr:       fmt.Sprintf(`hd=%q,smart=%q`, hdnumStr, q.smart),
+			value:      temp,
+			help:       "Disk temperature in degrees Celsius",
+			metricType: "gauge",
 		})
-		highestAvailable = hdnum
+		disks = append(disks, hdnumStr)
 	}
 
 	// Do not ask for data next time on disks that do not report it
 	e.syshdnum = highestAvailable
+	if e.status != nil {
+		e.status.Disks = disks
+	}
+
+	if len(e.expansions) > 0 && e.hal_app != "" {
+		expansionMetrics, expansionFailures := e.getExpansionHdMetrics()
+		metrics = append(metrics, expansionMetrics...)
+		failures = append(failures, expansionFailures...)
+	}
+
+	if len(failures) != 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
 
 	return metrics, nil
 }
 
+// queryDisks runs the queries of the disk slots 1 to count, GetsysinfoConcurrency at a time, returning their outcome
+// by slot
+func (e *promExporter) queryDisks(count int, query func(hdnumStr string) diskQuery) []diskQuery {
+	queries := make([]diskQuery, count)
+
+	slots := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < e.GetsysinfoConcurrency && i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range slots {
+				queries[index] = query(strconv.Itoa(index + 1))
+			}
+		}()
+	}
+	for index := 0; index < count; index++ {
+		slots <- index
+	}
+	close(slots)
+	wg.Wait()
+
+	return queries
+}
+
+func (e *promExporter) queryDisk(hdnumStr string) diskQuery {
+	if e.halAppSensors() {
+		return e.queryHalAppDisk(halAppRootEnclosure, hdnumStr)
+	}
+
+	var q diskQuery
+	q.temp, q.err = e.execCommand(e.getsysinfo, "hdtmp", hdnumStr)
+	if q.err != nil || strings.HasPrefix(q.temp, "--") {
+		return q
+	}
+
+	q.smart, q.err = e.execCommand(e.getsysinfo, "hdsmart", hdnumStr)
+
+	return q
+}
+
 func (e *promExporter) getFlashCacheStatsMetrics() ([]metric, error) {
 	if e.kernelVersion >= 5 {
 		return nil, nil
 	}
 
-	lines, err := utils.ReadFileLines(flashcacheStatsPath)
+	var metrics []metric
+	err := utils.ScanFileLines(e.Paths.procPath(flashcacheStatsPath), utils.LineLimits{}, func(line string) error {
+		tokens := strings.SplitN(line, ":", 2)
+		if len(tokens) != 2 {
+			return fmt.Errorf("parse flashcache statistic %q", line)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(tokens[1]), 64)
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSpace(tokens[0])
+		metrics = append(metrics, metric{
+			name:       "node_flashcache_" + name,
+			value:      value,
+			help:       fmt.Sprintf("Flashcache statistic %s", name),
+			metricType: flashcacheStatType(name),
+		})
+		return nil
+	})
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Ignore if the file does not exist
@@ -67,22 +172,16 @@ func (e *promExporter) getFlashCacheStatsMetrics() ([]metric, error) {
 		return nil, err
 	}
 
-	metrics := make([]metric, 0, len(lines))
-	for _, line := range lines {
-		tokens := strings.SplitN(line, ":", 2)
-		valueStr := strings.TrimSpace(tokens[1])
-		value, err := strconv.ParseFloat(valueStr, 64)
-		if err != nil {
-			return nil, err
-		}
+	return metrics, nil
+}
 
-		metrics = append(metrics, metric{
-			name:  "node_flashcache_" + tokens[0],
-			value: value,
-		})
+// flashcacheStatType returns the type of the flashcache statistic, whose values are cumulative except for the ratios
+func flashcacheStatType(name string) string {
+	if strings.HasSuffix(name, "_percent") || strings.HasSuffix(name, "_pct") {
+		return "gauge"
 	}
 
-	return metrics, nil
+	return "counter"
 }
 
 func (e *promExporter) getDmCacheStatsMetrics() ([]metric, error) {
@@ -91,7 +190,7 @@ func (e *promExporter) getDmCacheStatsMetrics() ([]metric, error) {
 	}
 
 	args := append([]string{"status", "--noflush"}, e.dmCacheClients...)
-	lines, err := utils.ExecCommandGetLines("dmsetup", args...)
+	lines, err := e.execCommandGetLines("dmsetup", args...)
 	if err != nil {
 		return nil, fmt.Errorf("get dm-cache status (dmsetup %s): %w", args, err)
 	}
@@ -104,10 +203,10 @@ func (e *promExporter) getDmCacheStatsMetrics() ([]metric, error) {
 		allocationTokens := strings.SplitN(allocationRatioStr, "/", 2)
 		attr := fmt.Sprintf("device=%q", cache)
 
-		metrics = appendFloatMetric(metrics, "node_flashcache_cached_blocks", allocationTokens[0], 1, "", "")
-		metrics = appendFloatMetric(metrics, "node_flashcache_total_blocks", allocationTokens[1], 1, "", "")
-		metrics = appendFloatMetric(metrics, "node_dmcache_used_bytes_total", allocationTokens[0], 1024*1024, attr, "Number of blocks resident in the cache")
-		metrics = appendFloatMetric(metrics, "node_dmcache_bytes_total", allocationTokens[1], 1024*1024, attr, "Total number of cache blocks")
+		metrics = appendFloatMetric(metrics, "node_flashcache_cached_blocks", allocationTokens[0], 1, "", "Number of blocks resident in the cache", "gauge")
+		metrics = appendFloatMetric(metrics, "node_flashcache_total_blocks", allocationTokens[1], 1, "", "Total number of cache blocks", "gauge")
+		metrics = appendFloatMetric(metrics, "node_dmcache_used_bytes_total", allocationTokens[0], 1024*1024, attr, "Size of the data resident in the cache in bytes", "gauge")
+		metrics = appendFloatMetric(metrics, "node_dmcache_bytes_total", allocationTokens[1], 1024*1024, attr, "Total size of the cache in bytes", "gauge")
 	}
 
 	return e.appendDmCacheHitMetrics(metrics)
@@ -119,26 +218,19 @@ func (e *promExporter) appendDmCacheHitMetrics(metrics []metric) ([]metric, erro
 	}
 
 	cache := fmt.Sprintf("dm-%s", e.dmCacheDeviceMinorNumber)
-	dmCacheStatsFilePath := fmt.Sprintf(dmCacheStatsFilePathFormat, cache)
-
-	lines, err := utils.ReadFileLines(dmCacheStatsFilePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Ignore if the file does not exist
-			return metrics, nil
-		}
-
-		return nil, err
-	}
+	dmCacheStatsFilePath := e.Paths.sysPath(fmt.Sprintf(dmCacheStatsFilePathFormat, cache))
 
 	var readHits, readTotal, writeHits, writeTotal float64
-	for _, line := range lines {
+	err := utils.ScanFileLines(dmCacheStatsFilePath, utils.LineLimits{}, func(line string) error {
 		tokens := strings.SplitN(line, ":", 2)
+		if len(tokens) != 2 {
+			return fmt.Errorf("parse dm-cache statistic %q", line)
+		}
 		id := strings.TrimSpace(tokens[0])
 		valueStr := strings.TrimSpace(tokens[1])
 		value, err := strconv.ParseFloat(valueStr, 64)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		switch id {
@@ -151,6 +243,15 @@ func (e *promExporter) appendDmCacheHitMetrics(metrics []metric) ([]metric, erro
 		case "writes":
 			writeTotal = value
 		}
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Ignore if the file does not exist
+			return metrics, nil
+		}
+
+		return nil, err
 	}
 
 	attr := fmt.Sprintf("device=%q", cache)
@@ -187,13 +288,15 @@ func (e *promExporter) appendDmCacheHitMetrics(metrics []metric) ([]metric, erro
 			name:       "node_flashcache_read_hit_percent",
 			attr:       attr,
 			value:      readHits / readTotal * 100,
-			metricType: "counter",
+			help:       "Percentage of READ bios mapped to the cache",
+			metricType: "gauge",
 		})
 		metrics = append(metrics, metric{
 			name:       "node_dmcache_read_hit_percent",
 			attr:       attr,
 			value:      readHits / readTotal * 100,
-			metricType: "counter",
+			help:       "Percentage of READ bios mapped to the cache",
+			metricType: "gauge",
 		})
 	}
 
@@ -230,13 +333,15 @@ func (e *promExporter) appendDmCacheHitMetrics(metrics []metric) ([]metric, erro
 			name:       "node_flashcache_write_hit_percent",
 			attr:       attr,
 			value:      writeHits / writeTotal * 100,
-			metricType: "counter",
+			help:       "Percentage of WRITE bios mapped to the cache",
+			metricType: "gauge",
 		})
 		metrics = append(metrics, metric{
 			name:       "node_dmcache_write_hit_percent",
 			attr:       attr,
 			value:      writeHits / writeTotal * 100,
-			metricType: "counter",
+			help:       "Percentage of WRITE bios mapped to the cache",
+			metricType: "gauge",
 		})
 	}
 
@@ -252,8 +357,8 @@ func getTokenValue(token string) float64 {
 	return value
 }
 
-func appendFloatMetric(metrics []metric, metricName string, valueStr string, factor float64, attr string, help string) []metric {
-	value, err := strconv.ParseFloat(valueStr, 64)
+func appendFloatMetric(metrics []metric, metricName string, valueStr string, factor float64, attr string, help string, metricType string) []metric {
+	value, err := utils.ParseFloat(valueStr)
 	if err != nil {
 		return metrics
 	}
@@ -263,7 +368,7 @@ func appendFloatMetric(metrics []metric, metricName string, valueStr string, fac
 		attr:       attr,
 		value:      value * factor,
 		help:       help,
-		metricType: "counter",
+		metricType: metricType,
 	})
 }
 
@@ -275,7 +380,7 @@ func (e *promExporter) getDiskStatsMetrics() ([]metric, error) {
 
 	metrics := make([]metric, 0, len(e.devices)*2)
 	for _, s := range stats {
-		attr := fmt.Sprintf(`device=%q`, s.Name)
+		attr := e.diskAttr(s.Name)
 
 		metrics = append(
 			metrics,
@@ -338,5 +443,260 @@ func (e *promExporter) getDiskStatsMetrics() ([]metric, error) {
 		)
 	}
 
+	var failures []string
+	for _, fetch := range []fetchMetricFn{e.getDiskLatencyMetrics, e.getDiskErrorMetrics} {
+		m, err := fetch()
+		metrics = append(metrics, m...)
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
+
+	return metrics, nil
+}
+
+// blockStat holds the fields of /sys/block/<dev>/stat used to derive the latency and utilization of a device
+type blockStat struct {
+	readTicks, writeTicks, inFlight, timeInQueue uint64
+}
+
+// parseBlockStat parses the contents of /sys/block/<dev>/stat, which has 11 fields on kernel 4,
+// followed by the discard fields since kernel 4.18 and the flush fields since kernel 5.5
+func parseBlockStat(contents string) (blockStat, error) {
+	fields := strings.Fields(contents)
+	if len(fields) < 11 {
+		return blockStat{}, fmt.Errorf("expected at least 11 fields, found %d", len(fields))
+	}
+
+	values := make([]uint64, 11)
+	for i := range values {
+		value, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return blockStat{}, fmt.Errorf("parse field %d: %w", i+1, err)
+		}
+		values[i] = value
+	}
+
+	return blockStat{readTicks: values[3], writeTicks: values[7], inFlight: values[8], timeInQueue: values[10]}, nil
+}
+
+// getDiskLatencyMetrics returns the time spent on the I/Os of the devices, named as by the node exporter
+// so that the average latency and utilization can be derived with the usual queries
+func (e *promExporter) getDiskLatencyMetrics() ([]metric, error) {
+	metrics := make([]metric, 0, len(e.devices)*4)
+	var failures []string
+	for _, dev := range e.devices {
+		contents, err := utils.ReadFile(e.Paths.sysPath(blockDir, dev, "stat"))
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", dev, err))
+			continue
+		}
+		stat, err := parseBlockStat(contents)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", dev, err))
+			continue
+		}
+
+		attr := e.diskAttr(dev)
+		metrics = append(
+			metrics,
+			metric{
+				name:       "node_disk_read_time_seconds_total",
+				attr:       attr,
+				value:      float64(stat.readTicks) / 1000,
+				help:       "Total number of seconds spent by all reads",
+				metricType: "counter",
+			},
+			metric{
+				name:       "node_disk_write_time_seconds_total",
+				attr:       attr,
+				value:      float64(stat.writeTicks) / 1000,
+				help:       "Total number of seconds spent by all writes",
+				metricType: "counter",
+			},
+			metric{
+				name:       "node_disk_io_now",
+				attr:       attr,
+				value:      float64(stat.inFlight),
+				help:       "Number of I/Os currently in progress",
+				metricType: "gauge",
+			},
+			metric{
+				name:       "node_disk_io_time_weighted_seconds_total",
+				attr:       attr,
+				value:      float64(stat.timeInQueue) / 1000,
+				help:       "Total number of seconds spent by the I/Os, weighted by the number of I/Os in progress",
+				metricType: "counter",
+			},
+		)
+	}
+
+	if len(failures) > 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
+
+	return metrics, nil
+}
+
+// getDiskErrorMetrics returns the I/O counters of the SCSI devices and the error count of their ATA device,
+// skipping the attributes which aren't found (e.g. NVMe devices have none)
+func (e *promExporter) getDiskErrorMetrics() ([]metric, error) {
+	var metrics []metric
+	var failures []string
+	for _, dev := range e.devices {
+		attr := e.diskAttr(dev)
+		deviceDir := e.Paths.sysPath(blockDir, dev, "device")
+
+		for _, c := range scsiCounters {
+			value, err := readSysfsCounter(filepath.Join(deviceDir, c.attr))
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					failures = append(failures, fmt.Sprintf("%s: %v", dev, err))
+				}
+				continue
+			}
+
+			metrics = append(metrics, metric{name: c.name, attr: attr, value: float64(value), help: c.help, metricType: "counter"})
+		}
+
+		port := ataPort(deviceDir)
+		if port == "" {
+			continue
+		}
+		// The device on the link of a port without a port multiplier is always the first one
+		ering, err := utils.ReadFile(e.Paths.sysPath("class", "ata_device", fmt.Sprintf("dev%s.0", port), "ering"))
+		if err != nil {
+			continue
+		}
+		metrics = append(metrics, metric{
+			name:       "node_disk_ata_errors",
+			attr:       fmt.Sprintf(`%s,port="ata%s"`, attr, port),
+			value:      float64(strings.Count(ering, "[")),
+			help:       "Number of errors in the error ring of the ATA device, which keeps the last 32",
+			metricType: "gauge",
+		})
+	}
+
+	if len(failures) > 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
+
 	return metrics, nil
 }
+
+// readSysfsCounter reads an unsigned counter from path, which may be hex-encoded (e.g. "0x1a")
+func readSysfsCounter(path string) (uint64, error) {
+	contents, err := utils.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseUint(contents, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return value, nil
+}
+
+// ataPort returns the number of the ATA port the SCSI device in deviceDir is attached to (e.g. "3" for
+// /sys/devices/pci0000:00/0000:00:17.0/ata3/host2/target2:0:0/2:0:0:0), or "" if it isn't an ATA device
+func ataPort(deviceDir string) string {
+	path, err := filepath.EvalSymlinks(deviceDir)
+	if err != nil {
+		return ""
+	}
+
+	for _, dir := range strings.Split(filepath.ToSlash(path), "/") {
+		if m := ataPortRe.FindStringSubmatch(dir); m != nil {
+			return m[1]
+		}
+	}
+
+	return ""
+}
+
+// trackDiskSmart annotates the changes of the SMART summary of the disk in slot (e.g. "[disk] Disk 3 SMART status Warning")
+func (e *promExporter) trackDiskSmart(slot, smart string) {
+	if e.Annotator == nil || !e.DiskAnnotations {
+		return
+	}
+
+	previous, changed := e.diskSmart.update(slot, strings.ToLower(smart))
+	if !changed {
+		return
+	}
+
+	e.Logger.Printf("Disk %s SMART status changed from %q to %q\n", slot, previous, smart)
+	e.annotate(notifications.Annotation{Text: fmt.Sprintf("Disk %s SMART status %s", slot, smart), Tags: []string{"disk"}, Key: "disk:" + slot})
+}
+
+// trackDevices annotates the disks added or removed since the previous environment refresh
+// (e.g. "[disk] Disk sdc added: WDC WD40EFRX-68N32N0 (WD-WCC7K0123456)").
+// The devices found on the first refresh are only recorded.
+func (e *promExporter) trackDevices(dir string) {
+	if e.Annotator == nil || !e.DiskAnnotations {
+		return
+	}
+
+	identities := make(map[string]string, len(e.devices))
+	for _, dev := range e.devices {
+		identities[dev] = readDiskIdentity(dir, dev)
+	}
+	previous := e.deviceIdentities
+	e.deviceIdentities = identities
+	if previous == nil {
+		return
+	}
+
+	var annotations []notifications.Annotation
+	for _, dev := range e.devices {
+		if _, ok := previous[dev]; !ok {
+			annotations = append(annotations, deviceAnnotation(dev, "added", identities[dev]))
+		}
+	}
+	removed := make([]string, 0, len(previous))
+	for dev := range previous {
+		if _, ok := identities[dev]; !ok {
+			removed = append(removed, dev)
+		}
+	}
+	sort.Strings(removed)
+	for _, dev := range removed {
+		annotations = append(annotations, deviceAnnotation(dev, "removed", previous[dev]))
+	}
+
+	for _, a := range annotations {
+		e.Logger.Println(a.Text)
+	}
+	e.annotate(annotations...)
+}
+
+func deviceAnnotation(dev, change, identity string) notifications.Annotation {
+	text := fmt.Sprintf("Disk %s %s", dev, change)
+	if identity != "" {
+		text += ": " + identity
+	}
+
+	return notifications.Annotation{Text: text, Tags: []string{"disk"}}
+}
+
+// readDiskIdentity returns the model and serial number of dev reported in dir (i.e. /sys/block), if any
+func readDiskIdentity(dir, dev string) string {
+	deviceDir := filepath.Join(dir, dev, "device")
+	model, _ := utils.ReadFile(filepath.Join(deviceDir, "model"))
+	serial, _ := utils.ReadFile(filepath.Join(deviceDir, "serial"))
+
+	switch {
+	case model != "" && serial != "":
+		return fmt.Sprintf("%s (%s)", model, serial)
+	case serial != "":
+		return serial
+	default:
+		return model
+	}
+}
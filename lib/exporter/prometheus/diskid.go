@@ -0,0 +1,112 @@
+package prometheus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+// The links named after the stable identities of the disks and of their file systems, relative to the root
+const (
+	diskByIDDir    = "dev/disk/by-id"
+	diskByLabelDir = "dev/disk/by-label"
+)
+
+// readRemovableDiskIDs returns the stable identities of the removable devices (e.g. USB disks), by device name,
+// since a removable device gets whichever name is free when it is plugged in
+func (e *promExporter) readRemovableDiskIDs() map[string]string {
+	blockPath := e.Paths.sysPath(blockDir)
+	var byID, byLabel map[string][]string
+
+	ids := map[string]string{}
+	for _, dev := range e.devices {
+		if !isRemovable(blockPath, dev) {
+			continue
+		}
+		if byID == nil {
+			byID = readLinkedNames(e.Paths.rootPath(diskByIDDir))
+			byLabel = readLinkedNames(e.Paths.rootPath(diskByLabelDir))
+		}
+
+		if id := diskID(byID, byLabel, dev); id != "" {
+			ids[dev] = id
+		}
+	}
+
+	return ids
+}
+
+// isRemovable returns whether dev is flagged as removable in blockPath (i.e. /sys/block), or is attached through USB,
+// since USB disks usually aren't flagged as removable
+func isRemovable(blockPath, dev string) bool {
+	if removable, err := utils.ReadFile(filepath.Join(blockPath, dev, "removable")); err == nil && removable == "1" {
+		return true
+	}
+
+	path, err := filepath.EvalSymlinks(filepath.Join(blockPath, dev))
+	return err == nil && strings.Contains(filepath.ToSlash(path), "/usb")
+}
+
+// readLinkedNames returns the sorted names of the links in dir, by the name of the device they point to
+func readLinkedNames(dir string) map[string][]string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	names := map[string][]string{}
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		dev := filepath.Base(target)
+		names[dev] = append(names[dev], entry.Name())
+	}
+	for _, n := range names {
+		sort.Strings(n)
+	}
+
+	return names
+}
+
+// diskID returns the stable identity of dev: the name of its link in /dev/disk/by-id (e.g. the bus, model and serial
+// of the disk in usb-WD_Elements_25A3_575833314435-0:0), else the label of the first of its partitions with one
+func diskID(byID, byLabel map[string][]string, dev string) string {
+	ids := byID[dev]
+	for _, id := range ids {
+		// The WWN doesn't tell one disk from another to a human
+		if !strings.HasPrefix(id, "wwn-") {
+			return id
+		}
+	}
+	if len(ids) > 0 {
+		return ids[0]
+	}
+
+	partitions := make([]string, 0, len(byLabel))
+	for partition := range byLabel {
+		if partition != dev && strings.HasPrefix(partition, dev) {
+			partitions = append(partitions, partition)
+		}
+	}
+	sort.Strings(partitions)
+	for _, partition := range partitions {
+		return byLabel[partition][0]
+	}
+
+	return ""
+}
+
+// diskAttr returns the labels of the metrics of dev, including its stable identity if known
+func (e *promExporter) diskAttr(dev string) string {
+	if id, ok := e.diskIDs[dev]; ok {
+		return fmt.Sprintf(`device=%q,id=%q`, dev, id)
+	}
+
+	return fmt.Sprintf(`device=%q`, dev)
+}
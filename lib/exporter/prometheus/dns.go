@@ -0,0 +1,116 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DNSSystemResolver stands for the resolver configured on the host (i.e. in /etc/resolv.conf)
+	DNSSystemResolver = "system"
+
+	// dnsTimeout bounds each lookup, well under the usual scrape timeouts since they run concurrently
+	dnsTimeout = 2 * time.Second
+)
+
+// DNSConfig configures the DNS resolution probe
+type DNSConfig struct {
+	// Targets are the hostnames resolved on every scrape
+	Targets []string
+	// Resolvers are the DNS servers (host or host:port) each target is resolved with,
+	// where DNSSystemResolver stands for the resolver of the host (the only one, if empty)
+	Resolvers []string
+}
+
+// Enabled returns whether any target is configured
+func (c DNSConfig) Enabled() bool {
+	return len(c.Targets) > 0
+}
+
+// getDNSMetrics resolves each target with each resolver concurrently, reporting the time taken and whether it succeeded
+func (e *promExporter) getDNSMetrics() ([]metric, error) {
+	resolvers := e.DNS.Resolvers
+	if len(resolvers) == 0 {
+		resolvers = []string{DNSSystemResolver}
+	}
+
+	type lookup struct {
+		target, resolver string
+		duration         time.Duration
+		err              error
+	}
+	lookups := make([]lookup, 0, len(e.DNS.Targets)*len(resolvers))
+	for _, resolver := range resolvers {
+		for _, target := range e.DNS.Targets {
+			lookups = append(lookups, lookup{target: target, resolver: resolver})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range lookups {
+		wg.Add(1)
+		go func(l *lookup) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+			defer cancel()
+
+			start := time.Now()
+			_, l.err = newDNSResolver(l.resolver).LookupHost(ctx, l.target)
+			l.duration = time.Since(start)
+		}(&lookups[i])
+	}
+	wg.Wait()
+
+	metrics := make([]metric, 0, 2*len(lookups))
+	for _, l := range lookups {
+		attr := fmt.Sprintf("target=%q,resolver=%q", l.target, l.resolver)
+		success := 1.0
+		if l.err != nil {
+			e.Logger.Printf("Failed to resolve %s with the %s resolver: %v", l.target, l.resolver, l.err)
+			success = 0
+		}
+
+		metrics = append(
+			metrics,
+			metric{
+				name:       "node_dns_lookup_duration_seconds",
+				attr:       attr,
+				value:      l.duration.Seconds(),
+				help:       "Time taken to resolve the target, or to fail to",
+				metricType: "gauge",
+			},
+			metric{
+				name:       "node_dns_lookup_success",
+				attr:       attr,
+				value:      success,
+				help:       "Whether the target was resolved",
+				metricType: "gauge",
+			},
+		)
+	}
+
+	return metrics, nil
+}
+
+// newDNSResolver returns the system resolver, or one querying server (with the DNS port, unless it has one)
+func newDNSResolver(server string) *net.Resolver {
+	if server == DNSSystemResolver {
+		return net.DefaultResolver
+	}
+
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// edacDir holds a directory per memory controller, relative to the sysfs mount point
+const edacDir = "devices/system/edac/mc"
+
+var (
+	edacControllerRe = regexp.MustCompile(`^mc(\d+)$`)
+	edacCsrowRe      = regexp.MustCompile(`^csrow(\d+)$`)
+	edacDimmRe       = regexp.MustCompile(`^dimm(\d+)$`)
+)
+
+// getEdacMetrics reports the memory errors counted by the EDAC memory controllers, named as by the node exporter,
+// as well as per DIMM where the kernel reports them. Systems without ECC memory have no controllers.
+func (e *promExporter) getEdacMetrics() ([]metric, error) {
+	entries, err := os.ReadDir(e.Paths.sysPath(edacDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var metrics []metric
+	var failures []string
+	count := func(name, path, attr, help string) {
+		value, err := readSysfsCounter(path)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				failures = append(failures, err.Error())
+			}
+			return
+		}
+		metrics = append(metrics, metric{name: name, attr: attr, value: float64(value), help: help, metricType: "counter"})
+	}
+
+	for _, controller := range entries {
+		m := edacControllerRe.FindStringSubmatch(controller.Name())
+		if m == nil {
+			continue
+		}
+		dir := e.Paths.sysPath(edacDir, controller.Name())
+		attr := fmt.Sprintf("controller=%q", m[1])
+
+		count("node_edac_correctable_errors_total", filepath.Join(dir, "ce_count"), attr, "Total correctable memory errors")
+		count("node_edac_uncorrectable_errors_total", filepath.Join(dir, "ue_count"), attr, "Total uncorrectable memory errors")
+		// The errors which couldn't be attributed to a csrow
+		unknownAttr := attr + `,csrow="unknown"`
+		count("node_edac_csrow_correctable_errors_total", filepath.Join(dir, "ce_noinfo_count"), unknownAttr, "Total correctable memory errors for this csrow")
+		count("node_edac_csrow_uncorrectable_errors_total", filepath.Join(dir, "ue_noinfo_count"), unknownAttr, "Total uncorrectable memory errors for this csrow")
+
+		children, _ := os.ReadDir(dir)
+		for _, child := range children {
+			childDir := filepath.Join(dir, child.Name())
+			if m := edacCsrowRe.FindStringSubmatch(child.Name()); m != nil {
+				csrowAttr := fmt.Sprintf("%s,csrow=%q", attr, m[1])
+				count("node_edac_csrow_correctable_errors_total", filepath.Join(childDir, "ce_count"), csrowAttr, "Total correctable memory errors for this csrow")
+				count("node_edac_csrow_uncorrectable_errors_total", filepath.Join(childDir, "ue_count"), csrowAttr, "Total uncorrectable memory errors for this csrow")
+			} else if m := edacDimmRe.FindStringSubmatch(child.Name()); m != nil {
+				dimmAttr := fmt.Sprintf("%s,dimm=%q", attr, m[1])
+				count("node_edac_dimm_correctable_errors_total", filepath.Join(childDir, "dimm_ce_count"), dimmAttr, "Total correctable memory errors for this DIMM")
+				count("node_edac_dimm_uncorrectable_errors_total", filepath.Join(childDir, "dimm_ue_count"), dimmAttr, "Total uncorrectable memory errors for this DIMM")
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,103 @@
+package prometheus
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+// envSnapshot holds what an environment refresh found, so that only the changes since the previous refresh are logged
+type envSnapshot struct {
+	hostname   string
+	devices    []string
+	ifaces     []string
+	volumes    []string
+	enclosures []string
+	expansions []string
+	// tools holds the paths of the optional commands, by name (empty if not found)
+	tools map[string]string
+}
+
+func (e *promExporter) takeEnvSnapshot() envSnapshot {
+	s := envSnapshot{
+		hostname: e.hostname,
+		devices:  e.devices,
+		ifaces:   e.ifaces,
+		tools: map[string]string{
+			"getsysinfo":   e.getsysinfo,
+			"hal_app":      e.hal_app,
+			"ethtool":      e.ethtool,
+			"qcli_storage": e.qcliStorage,
+			"repquota":     e.repquota,
+			"zfs":          e.zfs,
+		},
+	}
+	for _, v := range e.volumes {
+		s.volumes = append(s.volumes, v.description)
+	}
+	for _, enc := range e.enclosures {
+		s.enclosures = append(s.enclosures, enc.name)
+	}
+	for _, enc := range e.expansions {
+		s.expansions = append(s.expansions, enc.name)
+	}
+
+	return s
+}
+
+// changes describes the differences from previous, e.g. `devices [sda] -> [sda sdb]` or `found hal_app at /sbin/hal_app`
+func (s envSnapshot) changes(previous envSnapshot) []string {
+	var changes []string
+	if s.hostname != previous.hostname {
+		changes = append(changes, fmt.Sprintf("hostname %q -> %q", previous.hostname, s.hostname))
+	}
+	lists := []struct {
+		name              string
+		current, previous []string
+	}{
+		{"devices", s.devices, previous.devices},
+		{"interfaces", s.ifaces, previous.ifaces},
+		{"volumes", s.volumes, previous.volumes},
+		{"enclosures", s.enclosures, previous.enclosures},
+		{"expansion units", s.expansions, previous.expansions},
+	}
+	for _, l := range lists {
+		// A nil list and an empty one are the same
+		if len(l.current) != len(l.previous) || len(l.current) != 0 && !reflect.DeepEqual(l.current, l.previous) {
+			changes = append(changes, fmt.Sprintf("%s %v -> %v", l.name, l.previous, l.current))
+		}
+	}
+
+	tools := make([]string, 0, len(s.tools))
+	for tool := range s.tools {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	for _, tool := range tools {
+		path, previousPath := s.tools[tool], previous.tools[tool]
+		switch {
+		case path == previousPath:
+		case path == "":
+			changes = append(changes, fmt.Sprintf("lost %s", tool))
+		default:
+			changes = append(changes, fmt.Sprintf("found %s at %s", tool, path))
+		}
+	}
+
+	return changes
+}
+
+// logEnvironment logs the changes of the environment since the previous refresh, if any
+func (e *promExporter) logEnvironment(s envSnapshot) {
+	changes := s.changes(e.envSnapshot)
+	e.envSnapshot = s
+	if len(changes) == 0 {
+		utils.Debugf(e.Logger, "Environment unchanged")
+		return
+	}
+
+	e.Logger.Printf("Environment changed: %s", strings.Join(changes, ", "))
+}
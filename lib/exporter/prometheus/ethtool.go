@@ -0,0 +1,105 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+// ethtoolStats lists the NIC statistics exported by the ethtool collector, with their description.
+// Drivers name their statistics differently, so each one only reports those it shares with this list.
+var ethtoolStats = map[string]string{
+	"rx_missed_errors":   "Number of received packets missed by the NIC, e.g. for lack of buffers",
+	"rx_no_buffer_count": "Number of times the NIC had no receive buffer available",
+	"rx_fifo_errors":     "Number of receive FIFO overruns",
+	"tx_fifo_errors":     "Number of transmit FIFO underruns",
+	"rx_crc_errors":      "Number of received packets with a CRC error",
+	"tx_timeout_count":   "Number of transmit timeouts",
+}
+
+// ethtoolQueueDropsRe matches the per-queue drop counters (e.g. rx_queue_0_drops)
+var ethtoolQueueDropsRe = regexp.MustCompile(`^(rx|tx)_queue_(\d+)_drops$`)
+
+// getEthtoolMetrics reports the allowlisted NIC statistics of the physical interfaces, as returned by ethtool -S
+func (e *promExporter) getEthtoolMetrics() ([]metric, error) {
+	if e.ethtool == "" {
+		return nil, nil
+	}
+
+	var metrics []metric
+	var failures []string
+	for _, iface := range e.ifaces {
+		if e.interfaceClass(iface) != InterfaceClassPhysical {
+			continue
+		}
+
+		output, err := e.execCommand(e.ethtool, "-S", iface)
+		if err != nil {
+			var cmdErr *utils.CommandError
+			if errors.As(err, &cmdErr) && strings.Contains(cmdErr.Stderr, "no stats available") {
+				// The driver doesn't report any statistics
+				continue
+			}
+			failures = append(failures, fmt.Sprintf("%s: %v", iface, err))
+			continue
+		}
+
+		metrics = append(metrics, parseEthtoolStats(iface, output)...)
+	}
+
+	if len(failures) != 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
+
+	return metrics, nil
+}
+
+// parseEthtoolStats returns the metrics of the allowlisted statistics in the output of ethtool -S
+func parseEthtoolStats(iface, output string) []metric {
+	var metrics []metric
+	for _, line := range strings.Split(output, "\n") {
+		tokens := strings.SplitN(line, ":", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+		stat := strings.TrimSpace(tokens[0])
+		value, err := strconv.ParseFloat(strings.TrimSpace(tokens[1]), 64)
+		if err != nil {
+			// e.g. the "NIC statistics:" header
+			continue
+		}
+
+		attr := fmt.Sprintf("device=%q", iface)
+		if help, ok := ethtoolStats[stat]; ok {
+			metrics = append(metrics, metric{
+				name:       "node_ethtool_" + stat + "_total",
+				attr:       attr,
+				value:      value,
+				help:       help,
+				metricType: "counter",
+			})
+		} else if m := ethtoolQueueDropsRe.FindStringSubmatch(stat); m != nil {
+			metrics = append(metrics, metric{
+				name:       "node_ethtool_" + m[1] + "_queue_drops_total",
+				attr:       fmt.Sprintf("%s,queue=%q", attr, m[2]),
+				value:      value,
+				help:       "Number of packets dropped by the queue",
+				metricType: "counter",
+			})
+		}
+	}
+
+	return metrics
+}
+
+func (e *promExporter) checkEthtool() []exporter.Prerequisite {
+	return []exporter.Prerequisite{
+		{Name: "ethtool", Found: e.ethtool != "", Detail: e.ethtool},
+		{Name: "physical interfaces", Found: len(e.ifaces) > 0, Detail: strings.Join(e.ifaces, ", ")},
+	}
+}
@@ -0,0 +1,50 @@
+package prometheus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+)
+
+// stateTracker remembers the last state observed for each key between scrapes, to annotate the transitions.
+// The first state observed for a key is only recorded, so that a restart doesn't post spurious events.
+type stateTracker struct {
+	mu     sync.Mutex
+	states map[string]string
+}
+
+// update records state for key, returning the previous state and whether this is a transition from it
+func (t *stateTracker) update(key, state string) (previous string, changed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.states == nil {
+		t.states = map[string]string{}
+	}
+	previous, ok := t.states[key]
+	t.states[key] = state
+
+	return previous, ok && previous != state
+}
+
+// annotate posts the annotations in order through the configured annotator, if any, without holding up the scrape
+func (e *promExporter) annotate(annotations ...notifications.Annotation) {
+	if e.Annotator == nil || len(annotations) == 0 {
+		return
+	}
+	now := time.Now()
+	for i := range annotations {
+		if annotations[i].Time.IsZero() {
+			annotations[i].Time = now
+		}
+	}
+
+	go func() {
+		for _, annotation := range annotations {
+			if _, err := e.Annotator.PostAnnotation(annotation); err != nil {
+				e.Logger.Printf("Error posting annotation %q: %v\n", annotation.Text, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,164 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+// isExpansionUnit returns whether the enclosure listed by hal_app --se_enum is an expansion unit (e.g. a TL-D800C or
+// REXP shelf), i.e. an enclosure with disk slots other than the NAS itself and its QM2 cards
+func isExpansionUnit(enc qnapEnclosure) bool {
+	return enc.id != "" && enc.id != halAppRootEnclosure && !strings.HasPrefix(enc.id, "qm2_") && enc.diskCount > 0
+}
+
+// readExpansionUnits records the expansion units listed in the hal_app --se_enum output. Those with fans are also
+// added to the enclosures, whose fans are reported by the enclosure collector.
+func (e *promExporter) readExpansionUnits(seEnumOutput string) {
+	for _, line := range strings.Split(seEnumOutput, "\n") {
+		enc := parseEnclosure(line)
+		if !isExpansionUnit(enc) {
+			continue
+		}
+
+		e.expansions = append(e.expansions, enc)
+		if e.expansionsSeen == nil {
+			e.expansionsSeen = map[string]bool{}
+		}
+		e.expansionsSeen[enc.name] = true
+		if e.status != nil {
+			e.status.Enclosures = append(e.status.Enclosures, enc.name)
+		}
+		if enc.fanCount != 0 {
+			e.enclosures = append(e.enclosures, enc)
+		}
+		utils.Debugf(e.Logger, "Retrieved expansion unit %s (%s): %d disks, %d fans, %d temperature sensors",
+			enc.name, enc.id, enc.diskCount, enc.fanCount, enc.tempCount)
+	}
+}
+
+// getExpansionMetrics reports whether each expansion unit found since the exporter started is still connected, and
+// the temperatures of those which are
+func (e *promExporter) getExpansionMetrics() ([]metric, error) {
+	if len(e.expansionsSeen) == 0 {
+		return nil, nil
+	}
+
+	connected := make(map[string]qnapEnclosure, len(e.expansions))
+	for _, enc := range e.expansions {
+		connected[enc.name] = enc
+	}
+	names := make([]string, 0, len(e.expansionsSeen))
+	for name := range e.expansionsSeen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		_, ok := connected[name]
+		value := 0.0
+		if ok {
+			value = 1
+		}
+		metrics = append(metrics, metric{
+			name:       "node_enclosure_present",
+			attr:       fmt.Sprintf("enclosure=%q", name),
+			value:      value,
+			help:       "Whether the expansion unit is connected",
+			metricType: "gauge",
+		})
+	}
+
+	var failures []string
+	for _, name := range names {
+		enc, ok := connected[name]
+		if !ok {
+			continue
+		}
+		for sensor := 0; sensor < enc.tempCount; sensor++ {
+			output, err := e.execCommand(e.hal_app, "--se_sys_get_temp", fmt.Sprintf("enc_sys_id=%s,obj_index=%d", enc.id, sensor))
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s sensor %d: %v", enc.name, sensor+1, err))
+				continue
+			}
+
+			value, err := parseHalAppTemp(output)
+			if err != nil {
+				continue
+			}
+			metrics = append(metrics, metric{
+				name:       "node_enclosure_temperature_celsius",
+				attr:       fmt.Sprintf("enclosure=%q,sensor=%q", enc.name, strconv.Itoa(sensor+1)),
+				value:      value,
+				help:       "Temperature of the expansion unit in degrees Celsius",
+				metricType: "gauge",
+			})
+		}
+	}
+
+	if len(failures) != 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
+
+	return metrics, nil
+}
+
+// getExpansionHdMetrics reports the temperature of the disks of the expansion units like those of the NAS, along with
+// the name of their enclosure
+func (e *promExporter) getExpansionHdMetrics() ([]metric, []string) {
+	var metrics []metric
+	var failures []string
+	for _, enc := range e.expansions {
+		enc := enc
+		queries := e.queryDisks(enc.diskCount, func(port string) diskQuery { return e.queryHalAppDisk(enc.id, port) })
+		for index, q := range queries {
+			port := strconv.Itoa(index + 1)
+			if q.err != nil {
+				failures = append(failures, fmt.Sprintf("%s disk %s: %v", enc.name, port, q.err))
+				continue
+			}
+			if strings.HasPrefix(q.temp, "--") {
+				continue
+			}
+
+			slot := enc.name + ":" + port
+			e.trackDiskSmart(slot, q.smart)
+
+			temp, err := parseTemperature(q.temp)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s disk %s: %v", enc.name, port, err))
+				continue
+			}
+			e.watchTemperature(thermalClassDisk, "Disk "+slot, temp, time.Now())
+
+			metrics = append(metrics, metric{
+				name:       "node_hdtmp_C",
+				attr:       fmt.Sprintf(`hd=%q,smart=%q,enclosure=%q`, port, q.smart, enc.name),
+				value:      temp,
+				help:       "Disk temperature in degrees Celsius",
+				metricType: "gauge",
+			})
+		}
+	}
+
+	return metrics, failures
+}
+
+func (e *promExporter) checkExpansionUnits() []exporter.Prerequisite {
+	names := make([]string, 0, len(e.expansions))
+	for _, enc := range e.expansions {
+		names = append(names, enc.name)
+	}
+
+	return []exporter.Prerequisite{
+		{Name: "hal_app", Found: e.hal_app != "", Detail: e.hal_app},
+		{Name: "expansion units", Found: len(names) > 0, Detail: strings.Join(names, ", ")},
+	}
+}
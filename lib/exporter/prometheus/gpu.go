@@ -0,0 +1,120 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+const (
+	// drmDir holds a directory per graphics card and per connector, relative to the sysfs mount point
+	drmDir = "class/drm"
+	// hwmonDir holds a directory per hardware monitoring chip, relative to the sysfs mount point
+	hwmonDir = "class/hwmon"
+
+	// i915HwmonName is the name of the hwmon chip of the Intel integrated GPUs
+	i915HwmonName = "i915"
+)
+
+// drmCardRe matches the graphics cards, rather than their connectors (e.g. card0-HDMI-A-1)
+var drmCardRe = regexp.MustCompile(`^card\d+$`)
+
+// getGPUMetrics reports the temperature and the frequency of the Intel integrated GPUs, the current frequency
+// standing for their load since i915 doesn't report how busy they are. Models without one (e.g. ARM) report nothing.
+func (e *promExporter) getGPUMetrics() ([]metric, error) {
+	entries, err := os.ReadDir(e.Paths.sysPath(drmDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var metrics []metric
+	var failures []string
+	for _, entry := range entries {
+		card := entry.Name()
+		if !drmCardRe.MatchString(card) {
+			continue
+		}
+		dir := e.Paths.sysPath(drmDir, card)
+		attr := fmt.Sprintf("card=%q", card)
+
+		for _, f := range []struct{ name, file, help string }{
+			{"node_gpu_frequency_hertz", "gt_act_freq_mhz", "Current frequency of the GPU in hertz"},
+			{"node_gpu_frequency_max_hertz", "gt_max_freq_mhz", "Maximum frequency of the GPU in hertz"},
+		} {
+			mhz, err := readSysfsCounter(filepath.Join(dir, f.file))
+			if err != nil {
+				// Only the i915 cards report their frequency
+				if !errors.Is(err, os.ErrNotExist) {
+					failures = append(failures, err.Error())
+				}
+				continue
+			}
+			metrics = append(metrics, metric{name: f.name, attr: attr, value: float64(mhz) * 1e6, help: f.help, metricType: "gauge"})
+		}
+
+		if celsius, ok := e.readGPUTemperature(dir); ok {
+			metrics = append(metrics, metric{
+				name:       "node_gpu_temperature_celsius",
+				attr:       attr,
+				value:      celsius,
+				help:       "Temperature of the GPU in degrees Celsius",
+				metricType: "gauge",
+			})
+		}
+	}
+
+	if len(failures) > 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
+
+	return metrics, nil
+}
+
+// readGPUTemperature returns the temperature of the card in cardDir from its hwmon chip, falling back to the i915
+// chip among all the hwmon chips, for the kernels which don't link it to the card
+func (e *promExporter) readGPUTemperature(cardDir string) (float64, bool) {
+	chips, _ := filepath.Glob(filepath.Join(cardDir, "device", "hwmon", "hwmon*"))
+	if len(chips) == 0 {
+		all, _ := filepath.Glob(e.Paths.sysPath(hwmonDir, "hwmon*"))
+		for _, chip := range all {
+			if name, err := utils.ReadFile(filepath.Join(chip, "name")); err == nil && name == i915HwmonName {
+				chips = append(chips, chip)
+			}
+		}
+	}
+
+	for _, chip := range chips {
+		value, err := utils.ReadFile(filepath.Join(chip, "temp1_input"))
+		if err != nil {
+			continue
+		}
+		millidegrees, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			utils.Debugf(e.Logger, "Error parsing GPU temperature %q: %v", value, err)
+			continue
+		}
+
+		return millidegrees / 1000, true
+	}
+
+	return 0, false
+}
+
+func (e *promExporter) checkGPU() []exporter.Prerequisite {
+	cards, _ := filepath.Glob(e.Paths.sysPath(drmDir, "card*", "gt_act_freq_mhz"))
+	names := make([]string, 0, len(cards))
+	for _, card := range cards {
+		names = append(names, filepath.Base(filepath.Dir(card)))
+	}
+
+	return []exporter.Prerequisite{{Name: "i915 graphics cards", Found: len(names) > 0, Detail: strings.Join(names, ", ")}}
+}
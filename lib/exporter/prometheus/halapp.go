@@ -0,0 +1,158 @@
+package prometheus
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+// halAppRootEnclosure is the enc_sys_id of the enclosure of the NAS itself
+const halAppRootEnclosure = "root"
+
+// halAppFieldRe matches the "key = value" lines printed by the hal_app getters
+var halAppFieldRe = regexp.MustCompile(`(?m)^\s*([a-z_ ]+?)\s*=\s*(.*?)\s*$`)
+
+// halAppTempIndexes are the obj_index of the temperature sensors of the root enclosure, by getsysinfo device
+var halAppTempIndexes = []struct {
+	dev   string
+	index int
+}{
+	{"cputmp", 0},
+	{"systmp", 1},
+}
+
+// halAppSensors returns whether the system sensors are read with hal_app, which replaces getsysinfo on QuTS hero
+func (e *promExporter) halAppSensors() bool {
+	return e.getsysinfo == "" && e.hal_app != ""
+}
+
+// parseEnclosure parses an enclosure line of hal_app --se_enum, returning a zero enclosure if it is truncated
+func parseEnclosure(line string) qnapEnclosure {
+	fields := strings.Fields(line)
+	if len(fields) < 11 {
+		return qnapEnclosure{}
+	}
+
+	enc := qnapEnclosure{
+		id:   fields[2],
+		name: fields[4],
+	}
+	enc.diskCount, _ = strconv.Atoi(fields[7])
+	enc.fanCount, _ = strconv.Atoi(fields[8])
+	enc.tempCount, _ = strconv.Atoi(fields[10])
+
+	return enc
+}
+
+// readHalAppEnvironment reads the disk and fan counts of the root enclosure from the hal_app --se_enum output, on
+// the systems without getsysinfo (e.g. QuTS hero)
+func (e *promExporter) readHalAppEnvironment(seEnumOutput string) {
+	for _, line := range strings.Split(seEnumOutput, "\n") {
+		if enc := parseEnclosure(line); enc.id == halAppRootEnclosure {
+			e.rootEnclosure = enc
+			break
+		}
+	}
+
+	e.syshdnum, e.sysfannum = e.rootEnclosure.diskCount, e.rootEnclosure.fanCount
+	utils.Debugf(e.Logger, "Retrieved root enclosure from hal_app: %d disks, %d fans, %d temperature sensors",
+		e.syshdnum, e.sysfannum, e.rootEnclosure.tempCount)
+}
+
+// parseHalAppField returns the value of the key field printed by a hal_app getter
+func parseHalAppField(output, key string) (string, bool) {
+	for _, m := range halAppFieldRe.FindAllStringSubmatch(output, -1) {
+		if m[1] == key {
+			return m[2], true
+		}
+	}
+
+	return "", false
+}
+
+// parseHalAppTemp parses a temperature printed by a hal_app getter, e.g. "temp = 42 C"
+func parseHalAppTemp(output string) (float64, error) {
+	value, ok := parseHalAppField(output, "temp")
+	if !ok {
+		return 0, fmt.Errorf("parse hal_app temperature %q", output)
+	}
+
+	return utils.ParseFloat(strings.SplitN(value, " ", 2)[0])
+}
+
+func (e *promExporter) getHalAppTempMetrics() ([]metric, error) {
+	metrics := make([]metric, 0, len(halAppTempIndexes))
+
+	for _, sensor := range halAppTempIndexes {
+		if sensor.index >= e.rootEnclosure.tempCount {
+			continue
+		}
+
+		output, err := e.execCommand(e.hal_app, "--se_sys_get_temp", fmt.Sprintf("enc_sys_id=%s,obj_index=%d", halAppRootEnclosure, sensor.index))
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := parseHalAppTemp(output)
+		if err != nil {
+			continue
+		}
+		metrics = append(metrics, e.sysTempMetric(sensor.dev, value))
+	}
+
+	return metrics, nil
+}
+
+func (e *promExporter) getHalAppFanMetrics() ([]metric, error) {
+	metrics := make([]metric, 0, e.sysfannum)
+
+	for fanNum := 0; fanNum < e.sysfannum; fanNum++ {
+		fanOutput, err := e.execCommand(e.hal_app, "--se_sys_get_fan", fmt.Sprintf("enc_sys_id=%s,obj_index=%d", halAppRootEnclosure, fanNum))
+		if err != nil {
+			return nil, err
+		}
+
+		matches := fanRpmRe.FindStringSubmatch(fanOutput)
+		if len(matches) < 2 {
+			continue
+		}
+
+		fan, err := strconv.ParseFloat(matches[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, sysFanMetric(strconv.Itoa(1+fanNum), "System", fan))
+	}
+
+	return metrics, nil
+}
+
+// queryHalAppDisk runs the hal_app queries of a disk slot of the enclosure, returning them in the format of getsysinfo
+// so that the same disk metrics are reported
+func (e *promExporter) queryHalAppDisk(encID, hdnumStr string) diskQuery {
+	var q diskQuery
+	port := fmt.Sprintf("enc_sys_id=%s,port_id=%s", encID, hdnumStr)
+
+	output, err := e.execCommand(e.hal_app, "--pd_get_temp", port)
+	if err != nil {
+		q.err = err
+		return q
+	}
+	temp, ok := parseHalAppField(output, "temp")
+	if !ok {
+		// Empty slot
+		q.temp = "--"
+		return q
+	}
+	q.temp = temp
+
+	output, q.err = e.execCommand(e.hal_app, "--pd_get_smart_status", port)
+	if q.err == nil {
+		q.smart, _ = parseHalAppField(output, "smart status")
+	}
+
+	return q
+}
@@ -0,0 +1,86 @@
+package prometheus
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// entrySet is a fingerprint of the names of the entries of a directory, to detect the devices and interfaces which
+// appear or disappear between the environment refreshes without listing them on every scrape
+type entrySet struct {
+	count int
+	hash  uint64
+}
+
+// envEntries holds the fingerprints of the devices and network interfaces found on the last environment refresh
+type envEntries struct {
+	devices, ifaces entrySet
+}
+
+// readEnvEntries returns the fingerprints of the disk devices and of the network interfaces, leaving out the ephemeral
+// interfaces which come and go with the containers, since those are listed on every scrape anyway
+func (e *promExporter) readEnvEntries() envEntries {
+	devices, _ := readEntrySet(e.Paths.rootPath(devDir), isDiskDevice)
+	ifaces, _ := readEntrySet(e.Paths.sysPath(netDir), func(iface string) bool {
+		return !strings.HasPrefix(iface, "veth")
+	})
+
+	return envEntries{devices: devices, ifaces: ifaces}
+}
+
+// environmentChanged returns whether devices or network interfaces appeared or disappeared since the last environment
+// refresh. It runs on every scrape, so it only reads the names in /dev and /sys/class/net without keeping them.
+func (e *promExporter) environmentChanged() bool {
+	return e.readEnvEntries() != e.envEntries
+}
+
+// readEntrySet returns the fingerprint of the names in dir accepted by include. The names are hashed independently
+// of their order, so that they don't need to be sorted.
+func readEntrySet(dir string, include func(string) bool) (entrySet, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return entrySet{}, err
+	}
+	defer f.Close()
+
+	var set entrySet
+	for {
+		names, err := f.Readdirnames(64)
+		for _, name := range names {
+			if include(name) {
+				set.count++
+				set.hash += hashName(name)
+			}
+		}
+		if err == io.EOF {
+			return set, nil
+		}
+		if err != nil {
+			return set, err
+		}
+	}
+}
+
+// hashName returns the FNV-1a hash of name
+func hashName(name string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(name); i++ {
+		h ^= uint64(name[i])
+		h *= 1099511628211
+	}
+
+	return h
+}
+
+// isDiskDevice returns whether dev names a whole disk, e.g. sda or nvme0n1, rather than a partition or another device
+func isDiskDevice(dev string) bool {
+	switch {
+	case strings.HasPrefix(dev, "nvme"):
+		return len(dev) == 7
+	case strings.HasPrefix(dev, "sd"):
+		return len(dev) == 3
+	}
+
+	return false
+}
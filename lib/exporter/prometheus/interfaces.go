@@ -0,0 +1,149 @@
+package prometheus
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// InterfaceClassPhysical selects the Ethernet interfaces (eth*)
+	InterfaceClassPhysical = "physical"
+	// InterfaceClassLoopback selects the loopback interface
+	InterfaceClassLoopback = "loopback"
+	// InterfaceClassBridges selects the bridges, e.g. docker0 or the virtual switches
+	InterfaceClassBridges = "bridges"
+	// InterfaceClassEphemeral selects the interfaces created for each container (veth*)
+	InterfaceClassEphemeral = "virtual-ephemeral"
+
+	// ephemeralTotalDevice is the device label of the summed counters of the ephemeral interfaces
+	ephemeralTotalDevice = "veth_total"
+)
+
+var interfaceClasses = []string{InterfaceClassPhysical, InterfaceClassLoopback, InterfaceClassBridges, InterfaceClassEphemeral}
+
+// NetworkConfig selects the network interfaces whose counters are reported
+type NetworkConfig struct {
+	// Classes lists the interface classes reported (only InterfaceClassPhysical, if empty)
+	Classes []string
+	// AggregateEphemeral reports the sum of the counters of the ephemeral interfaces as a single device,
+	// rather than a series per interface
+	AggregateEphemeral bool
+	// Snapshot reads the counters of all the interfaces at once from /proc/net/dev, rather than each one from its own
+	// sysfs file, timestamping them with the time of the read so that both directions of an interface match
+	Snapshot bool
+	// AddressInfo also reports the global addresses of the interfaces, as node_network_address_info
+	AddressInfo bool
+}
+
+// ParseInterfaceClasses parses comma-separated interface classes (physical, loopback, bridges or virtual-ephemeral)
+func ParseInterfaceClasses(s string) ([]string, error) {
+	var classes []string
+	for _, field := range strings.Split(s, ",") {
+		class := strings.ToLower(strings.TrimSpace(field))
+		if class == "" {
+			continue
+		}
+
+		known := false
+		for _, c := range interfaceClasses {
+			known = known || c == class
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown interface class %q (expected one of %s)", class, strings.Join(interfaceClasses, ", "))
+		}
+		classes = append(classes, class)
+	}
+
+	return classes, nil
+}
+
+func (c NetworkConfig) includes(class string) bool {
+	if len(c.Classes) == 0 {
+		return class == InterfaceClassPhysical
+	}
+	for _, included := range c.Classes {
+		if included == class {
+			return true
+		}
+	}
+
+	return false
+}
+
+// interfaceClass returns the class of the interface, or an empty string if it belongs to none
+func (e *promExporter) interfaceClass(iface string) string {
+	switch {
+	case iface == "lo":
+		return InterfaceClassLoopback
+	case strings.HasPrefix(iface, "veth"):
+		return InterfaceClassEphemeral
+	case strings.HasPrefix(iface, "eth"):
+		return InterfaceClassPhysical
+	}
+	if _, err := os.Stat(e.Paths.sysPath(netDir, iface, "bridge")); err == nil {
+		return InterfaceClassBridges
+	}
+
+	return ""
+}
+
+// listInterfaces returns the names of the interfaces of the included classes, either the ephemeral ones or the others
+func (e *promExporter) listInterfaces(ephemeral bool) []string {
+	info, _ := os.ReadDir(e.Paths.sysPath(netDir))
+	ifaces := make([]string, 0, len(info))
+	for _, d := range info {
+		iface := d.Name()
+		class := e.interfaceClass(iface)
+		if class == "" || !e.Network.includes(class) || (class == InterfaceClassEphemeral) != ephemeral {
+			continue
+		}
+
+		ifaces = append(ifaces, iface)
+	}
+
+	return ifaces
+}
+
+// ephemeralCounters sums the counters of the ephemeral interfaces. The last values of the interfaces which
+// disappear are carried over, so that the sum only decreases when the exporter restarts.
+type ephemeralCounters struct {
+	mu sync.Mutex
+	// last holds the values of the previous scrape, by direction and interface
+	last map[string]map[string]float64
+	// retired holds the sum of the last values of the interfaces which disappeared, by direction
+	retired map[string]float64
+}
+
+// sum returns the total of the counters in direction, given their current values by interface
+func (c *ephemeralCounters) sum(direction string, values map[string]float64) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.last == nil {
+		c.last, c.retired = map[string]map[string]float64{}, map[string]float64{}
+	}
+	last := c.last[direction]
+	for iface, value := range last {
+		// An interface recreated with the same name restarts from zero
+		if current, ok := values[iface]; !ok || current < value {
+			c.retired[direction] += value
+		}
+	}
+
+	c.last[direction] = values
+	total := c.retired[direction]
+	ifaces := make([]string, 0, len(values))
+	for iface := range values {
+		ifaces = append(ifaces, iface)
+	}
+	// Sum in a stable order, for the float additions to be reproducible
+	sort.Strings(ifaces)
+	for _, iface := range ifaces {
+		total += values[iface]
+	}
+
+	return total
+}
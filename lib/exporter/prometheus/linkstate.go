@@ -0,0 +1,98 @@
+package prometheus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+const (
+	linkStateUp   = "up"
+	linkStateDown = "down"
+)
+
+// linkTracker debounces the link state changes of the interfaces between scrapes: a new state is only confirmed
+// once it is still observed on the next scrape, so that flapping links aren't annotated.
+// The first state observed for an interface is only recorded.
+type linkTracker struct {
+	mu        sync.Mutex
+	confirmed map[string]string
+	pending   map[string]pendingLinkState
+}
+
+// pendingLinkState is a new link state, waiting to be observed again
+type pendingLinkState struct {
+	state string
+	since time.Time
+}
+
+// update records the state of the interface observed at now, returning the previous confirmed state, the time the
+// new one was first observed and whether it is confirmed as a transition
+func (t *linkTracker) update(iface, state string, now time.Time) (previous string, since time.Time, changed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.confirmed == nil {
+		t.confirmed, t.pending = map[string]string{}, map[string]pendingLinkState{}
+	}
+	previous, ok := t.confirmed[iface]
+	switch {
+	case !ok:
+		t.confirmed[iface] = state
+		return "", time.Time{}, false
+	case state == previous:
+		delete(t.pending, iface)
+		return previous, time.Time{}, false
+	}
+
+	p, ok := t.pending[iface]
+	if !ok || p.state != state {
+		t.pending[iface] = pendingLinkState{state: state, since: now}
+		return previous, time.Time{}, false
+	}
+	delete(t.pending, iface)
+	t.confirmed[iface] = state
+
+	return previous, p.since, true
+}
+
+// readLinkState returns whether the link of the interface is up or down, from its operstate or, for the drivers
+// which don't report it, its carrier
+func (e *promExporter) readLinkState(iface string) (string, error) {
+	operstate, err := utils.ReadFile(e.Paths.sysPath(netDir, iface, "operstate"))
+	if err != nil {
+		return "", err
+	}
+
+	switch operstate {
+	case "up":
+		return linkStateUp, nil
+	case "unknown":
+		// Reading the carrier fails while the interface is administratively down
+		if carrier, err := utils.ReadFile(e.Paths.sysPath(netDir, iface, "carrier")); err == nil && carrier == "1" {
+			return linkStateUp, nil
+		}
+	}
+
+	return linkStateDown, nil
+}
+
+// trackLinkState annotates the link state changes of the physical interfaces as regions, lasting while the link is
+// down (e.g. "[network] Link eth1 down")
+func (e *promExporter) trackLinkState(iface, state string) {
+	if e.Annotator == nil || !e.NetworkAnnotations {
+		return
+	}
+
+	previous, since, changed := e.links.update(iface, state, time.Now())
+	if !changed {
+		return
+	}
+
+	e.Logger.Printf("Link %s changed from %s to %s\n", iface, previous, state)
+	text := fmt.Sprintf("Link %s down", iface)
+	e.annotate(notifications.Annotation{Text: text, Tags: []string{"network"}, Time: since, End: state == linkStateUp})
+}
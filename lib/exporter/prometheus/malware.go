@@ -0,0 +1,175 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+const (
+	// malwareRemoverQpkg is the name of the Malware Remover application in qpkg.conf
+	malwareRemoverQpkg = "MalwareRemover"
+
+	// malwareScanTimeLayout is the layout of the local scan times in the status file of Malware Remover 3.x and 4.x
+	malwareScanTimeLayout = "2006/01/02 15:04:05"
+)
+
+// malwareScan is the outcome of the last scan of Malware Remover
+type malwareScan struct {
+	lastScan time.Time
+	threats  float64
+}
+
+// malwareScanFiles are the files where the versions of Malware Remover record their last scan, relative to the
+// directory the application is installed in, most recent version first
+var malwareScanFiles = []struct {
+	path  string
+	parse func(data []byte) (malwareScan, error)
+}{
+	{path: "status/last_scan.json", parse: parseMalwareScanJSON},
+	{path: "conf/scan_status.conf", parse: parseMalwareScanConf},
+}
+
+// parseMalwareScanJSON parses the status file of Malware Remover 5.x, e.g.
+//
+//	{"last_scan": {"start_time": 1577844000, "end_time": 1577847600, "status": "completed", "threat_count": 2}}
+func parseMalwareScanJSON(data []byte) (malwareScan, error) {
+	var status struct {
+		LastScan *struct {
+			EndTime     int64   `json:"end_time"`
+			ThreatCount float64 `json:"threat_count"`
+		} `json:"last_scan"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return malwareScan{}, err
+	}
+	if status.LastScan == nil || status.LastScan.EndTime == 0 {
+		return malwareScan{}, errors.New("no completed scan")
+	}
+
+	return malwareScan{lastScan: time.Unix(status.LastScan.EndTime, 0), threats: status.LastScan.ThreatCount}, nil
+}
+
+// parseMalwareScanConf parses the status file of Malware Remover 3.x and 4.x, holding local times, e.g.
+//
+//	[Scan]
+//	Last_Scan_Time = 2020/01/01 03:00:00
+//	Last_Scan_Result = 1
+//	Infected_Files = 2
+func parseMalwareScanConf(data []byte) (malwareScan, error) {
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		tokens := strings.SplitN(line, "=", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+		fields[strings.ToLower(strings.TrimSpace(tokens[0]))] = strings.Trim(strings.TrimSpace(tokens[1]), `"`)
+	}
+
+	value := fields["last_scan_time"]
+	if value == "" {
+		return malwareScan{}, errors.New("no completed scan")
+	}
+	lastScan, err := time.ParseInLocation(malwareScanTimeLayout, value, time.Local)
+	if err != nil {
+		return malwareScan{}, fmt.Errorf("parse last scan time: %w", err)
+	}
+	var threats float64
+	if value := fields["infected_files"]; value != "" {
+		if threats, err = strconv.ParseFloat(value, 64); err != nil {
+			return malwareScan{}, fmt.Errorf("parse infected files: %w", err)
+		}
+	}
+
+	return malwareScan{lastScan: lastScan, threats: threats}, nil
+}
+
+// malwareRemover returns the Malware Remover application, if installed
+func (e *promExporter) malwareRemover() (qpkgInfo, bool) {
+	for _, q := range e.qpkgs {
+		if q.name == malwareRemoverQpkg && q.installPath != "" {
+			return q, true
+		}
+	}
+
+	return qpkgInfo{}, false
+}
+
+// readMalwareScan reads the last scan from the first status file found in the directory Malware Remover is installed in
+func (e *promExporter) readMalwareScan(installPath string) (malwareScan, error) {
+	for _, f := range malwareScanFiles {
+		path := e.Paths.rootPath(installPath, f.path)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return malwareScan{}, err
+		}
+
+		scan, err := f.parse(data)
+		if err != nil {
+			return malwareScan{}, fmt.Errorf("read %s: %w", path, err)
+		}
+
+		return scan, nil
+	}
+
+	return malwareScan{}, os.ErrNotExist
+}
+
+func (e *promExporter) getMalwareScanMetrics() ([]metric, error) {
+	q, ok := e.malwareRemover()
+	if !ok {
+		return nil, nil
+	}
+
+	scan, err := e.readMalwareScan(q.installPath)
+	if err != nil {
+		// No scan has completed since Malware Remover was installed
+		if errors.Is(err, os.ErrNotExist) {
+			utils.Debugf(e.Logger, "No Malware Remover scan status found in %q", q.installPath)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return []metric{
+		{
+			name:       "qnap_malware_scan_last_timestamp_seconds",
+			value:      float64(scan.lastScan.Unix()),
+			help:       "Time the last Malware Remover scan completed, in seconds since the epoch",
+			metricType: "gauge",
+		},
+		{
+			name:       "qnap_malware_scan_threats_found",
+			value:      scan.threats,
+			help:       "Number of threats found by the last Malware Remover scan",
+			metricType: "gauge",
+		},
+	}, nil
+}
+
+func (e *promExporter) checkMalwareRemover() []exporter.Prerequisite {
+	q, ok := e.malwareRemover()
+	if !ok {
+		return []exporter.Prerequisite{{Name: "Malware Remover", Detail: "not installed"}}
+	}
+
+	detail := filepath.Join(q.installPath, malwareScanFiles[0].path)
+	for _, f := range malwareScanFiles {
+		if _, err := os.Stat(e.Paths.rootPath(q.installPath, f.path)); err == nil {
+			return []exporter.Prerequisite{{Name: "Malware Remover scan status", Found: true, Detail: filepath.Join(q.installPath, f.path)}}
+		}
+	}
+
+	return []exporter.Prerequisite{{Name: "Malware Remover scan status", Detail: detail}}
+}
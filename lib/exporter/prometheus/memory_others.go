@@ -13,13 +13,13 @@ func getMemInfoMetrics() ([]metric, error) {
 	}
 
 	metrics := []metric{
-		{name: "node_memory_MemTotal_bytes", value: float64(s.Total)},
-		{name: "node_memory_MemFree_bytes", value: float64(s.Free)},
-		{name: "node_memory_Cached_bytes", value: float64(s.Cached)},
-		{name: "node_memory_Active_bytes", value: float64(s.Active)},
-		{name: "node_memory_Inactive_bytes", value: float64(s.Inactive)},
-		{name: "node_memory_SwapTotal_bytes", value: float64(s.SwapTotal)},
-		{name: "node_memory_SwapFree_bytes", value: float64(s.SwapFree)},
+		{name: "node_memory_MemTotal_bytes", value: float64(s.Total), help: "Total usable memory in bytes", metricType: "gauge"},
+		{name: "node_memory_MemFree_bytes", value: float64(s.Free), help: "Unused memory in bytes", metricType: "gauge"},
+		{name: "node_memory_Cached_bytes", value: float64(s.Cached), help: "Memory used by the page cache in bytes", metricType: "gauge"},
+		{name: "node_memory_Active_bytes", value: float64(s.Active), help: "Memory used recently in bytes", metricType: "gauge"},
+		{name: "node_memory_Inactive_bytes", value: float64(s.Inactive), help: "Memory not used recently in bytes", metricType: "gauge"},
+		{name: "node_memory_SwapTotal_bytes", value: float64(s.SwapTotal), help: "Total swap space in bytes", metricType: "gauge"},
+		{name: "node_memory_SwapFree_bytes", value: float64(s.SwapFree), help: "Unused swap space in bytes", metricType: "gauge"},
 	}
 
 	return metrics, nil
@@ -1,6 +1,10 @@
 package prometheus
 
-import "time"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
 type metric struct {
 	name       string
@@ -9,4 +13,33 @@ type metric struct {
 	value      float64
 	help       string
 	metricType string
+	// created is the time the counter was last reset, for the counters accumulated by the exporter itself (e.g. since
+	// it started), which is exported as a _created series so that consumers can tell a restart from a wraparound
+	created time.Time
+}
+
+// createdName returns the name of the series holding the creation time of the counter name, following OpenMetrics
+// (e.g. foo_created for foo_total)
+func createdName(name string) string {
+	return strings.TrimSuffix(name, "_total") + "_created"
+}
+
+// parseLabels parses the labels of a metric attr (e.g. `device="sda",port="ata3"`), whose values are quoted with %q
+func parseLabels(attr string) map[string]string {
+	labels := map[string]string{}
+	for attr != "" {
+		tokens := strings.SplitN(attr, "=", 2)
+		if len(tokens) != 2 {
+			break
+		}
+		quoted, err := strconv.QuotedPrefix(tokens[1])
+		if err != nil {
+			break
+		}
+		value, _ := strconv.Unquote(quoted)
+		labels[strings.TrimSpace(tokens[0])] = value
+		attr = strings.TrimPrefix(tokens[1][len(quoted):], ",")
+	}
+
+	return labels
 }
@@ -0,0 +1,113 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// NameFilter selects the metric families written out by name, e.g. to fetch a single family while debugging
+type NameFilter struct {
+	patterns []*regexp.Regexp
+}
+
+// ParseNameFilter compiles the regular expressions matching the names of the families to write out. They are anchored,
+// so that e.g. "node_hdtmp_C" only matches that family, and "node_disk_.*" every disk family.
+func ParseNameFilter(patterns []string) (*NameFilter, error) {
+	f := &NameFilter{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric name pattern %q: %w", pattern, err)
+		}
+		f.patterns = append(f.patterns, re)
+	}
+
+	return f, nil
+}
+
+// Match returns whether the family is written out
+func (f *NameFilter) Match(family string) bool {
+	for _, re := range f.patterns {
+		if re.MatchString(family) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writer returns a writer passing the lines of the matching families (their samples, HELP and TYPE) on to w,
+// which must be closed to write out the last line if it isn't terminated. The other comments, e.g. the collector
+// errors, are passed on as well.
+func (f *NameFilter) Writer(w io.Writer) io.WriteCloser {
+	return &nameFilterWriter{w: w, filter: f}
+}
+
+type nameFilterWriter struct {
+	w      io.Writer
+	filter *NameFilter
+	// partial holds the start of a line whose end hasn't been written yet
+	partial []byte
+}
+
+func (fw *nameFilterWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			fw.partial = append(fw.partial, p...)
+			break
+		}
+
+		line := p[:i+1]
+		if len(fw.partial) > 0 {
+			line = append(fw.partial, line...)
+			fw.partial = fw.partial[:0]
+		}
+		if err := fw.writeLine(line); err != nil {
+			return n - len(p), err
+		}
+		p = p[i+1:]
+	}
+
+	return n, nil
+}
+
+func (fw *nameFilterWriter) Close() error {
+	if len(fw.partial) == 0 {
+		return nil
+	}
+
+	err := fw.writeLine(fw.partial)
+	fw.partial = nil
+
+	return err
+}
+
+func (fw *nameFilterWriter) writeLine(line []byte) error {
+	if family := lineFamily(string(line)); family != "" && !fw.filter.Match(family) {
+		return nil
+	}
+
+	_, err := fw.w.Write(line)
+	return err
+}
+
+// lineFamily returns the name of the family of a line of the text exposition format, or "" if it isn't a sample
+// or a HELP or TYPE comment
+func lineFamily(line string) string {
+	if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+		if fields := strings.Fields(line); len(fields) > 2 {
+			return fields[2]
+		}
+		return ""
+	}
+	if strings.HasPrefix(line, "#") {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.SplitN(strings.SplitN(line, "{", 2)[0], " ", 2)[0])
+}
@@ -0,0 +1,83 @@
+package prometheus
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// addressFamilies lists the address families whose presence is reported for each interface
+var addressFamilies = []string{"ipv4", "ipv6"}
+
+// lookupInterfaceAddrs returns the addresses of the interface, read from the kernel over netlink
+func lookupInterfaceAddrs(iface string) ([]net.Addr, error) {
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.Addrs()
+}
+
+// getNetworkAddressMetrics reports whether the interfaces have a global address of each family, so that an interface
+// whose link is up but which only has a link-local address (e.g. after a DHCP failure) can be alerted on. The
+// addresses themselves are only exported with Network.AddressInfo.
+func (e *promExporter) getNetworkAddressMetrics() ([]metric, error) {
+	var metrics []metric
+	for _, iface := range e.ifaces {
+		if e.interfaceClass(iface) == InterfaceClassLoopback {
+			continue
+		}
+
+		addrs, err := e.interfaceAddrs(iface)
+		if err != nil {
+			// The interface was removed since it was listed
+			continue
+		}
+
+		global := map[string][]string{}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			family := "ipv6"
+			if ipNet.IP.To4() != nil {
+				family = "ipv4"
+			}
+			global[family] = append(global[family], ipNet.String())
+		}
+
+		for _, family := range addressFamilies {
+			value := 0.0
+			if len(global[family]) > 0 {
+				value = 1
+			}
+			metrics = append(metrics, metric{
+				name:       "node_network_address_assigned",
+				attr:       fmt.Sprintf("device=%q,family=%q", iface, family),
+				value:      value,
+				help:       "Whether the interface has a global (i.e. not link-local) address of the family",
+				metricType: "gauge",
+			})
+		}
+
+		if !e.Network.AddressInfo {
+			continue
+		}
+		for _, family := range addressFamilies {
+			sort.Strings(global[family])
+			for _, address := range global[family] {
+				metrics = append(metrics, metric{
+					name:       "node_network_address_info",
+					attr:       fmt.Sprintf("device=%q,family=%q,address=%q", iface, family, address),
+					value:      1,
+					help:       "Global address assigned to the interface, with its prefix length",
+					metricType: "gauge",
+				})
+			}
+		}
+	}
+
+	return metrics, nil
+}
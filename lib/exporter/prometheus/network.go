@@ -3,8 +3,9 @@ package prometheus
 import (
 	"fmt"
 	"math"
-	"path"
+	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-ping/ping"
@@ -12,42 +13,194 @@ import (
 )
 
 func (e *promExporter) getNetworkStatsMetrics() ([]metric, error) {
+	stats, err := e.readNetworkStats()
+	if err != nil {
+		return nil, err
+	}
+
 	metrics := make([]metric, 0, len(e.ifaces)*2)
 	for _, iface := range e.ifaces {
-		rxMetric, err := getNetworkStatMetric("node_network_receive_bytes_total", "Total number of bytes received", iface, "rx")
+		rxMetric, err := stats.metric("node_network_receive_bytes_total", "Total number of bytes received", iface, "rx")
 		if err != nil {
 			return nil, err
 		}
 		metrics = append(metrics, rxMetric)
 
-		txMetric, err := getNetworkStatMetric("node_network_transmit_bytes_total", "Total number of bytes transmitted", iface, "tx")
+		txMetric, err := stats.metric("node_network_transmit_bytes_total", "Total number of bytes transmitted", iface, "tx")
 		if err != nil {
 			return nil, err
 		}
 		metrics = append(metrics, txMetric)
+
+		if e.interfaceClass(iface) != InterfaceClassPhysical {
+			continue
+		}
+		if state, err := e.readLinkState(iface); err == nil {
+			e.trackLinkState(iface, state)
+
+			value := 0.0
+			if state == linkStateUp {
+				value = 1
+			}
+			metrics = append(metrics, metric{
+				name:       "node_network_up",
+				attr:       fmt.Sprintf(`device=%q`, iface),
+				value:      value,
+				help:       "Whether the link of the physical interface is up",
+				metricType: "gauge",
+			})
+		}
+	}
+
+	if e.Network.includes(InterfaceClassEphemeral) {
+		metrics = append(metrics, e.getEphemeralNetworkStatsMetrics(stats)...)
 	}
 
 	return metrics, nil
 }
 
-func getNetworkStatMetric(name string, help string, iface string, direction string) (metric, error) {
-	str, err := utils.ReadFile(path.Join(netDir, iface, "statistics", direction+"_bytes"))
+// getEphemeralNetworkStatsMetrics returns the counters of the ephemeral interfaces, listed on every scrape
+// since they come and go with the containers
+func (e *promExporter) getEphemeralNetworkStatsMetrics(stats networkStats) []metric {
+	ifaces := e.listInterfaces(true)
+	metrics := make([]metric, 0, 2*len(ifaces))
+	for _, direction := range []struct{ name, help, stat string }{
+		{"node_network_receive_bytes_total", "Total number of bytes received", "rx"},
+		{"node_network_transmit_bytes_total", "Total number of bytes transmitted", "tx"},
+	} {
+		values := make(map[string]float64, len(ifaces))
+		for _, iface := range ifaces {
+			// Skip the interfaces removed since they were listed
+			if value, err := stats.read(iface, direction.stat); err == nil {
+				values[iface] = value
+			}
+		}
+
+		if e.Network.AggregateEphemeral {
+			m := networkStatMetric(direction.name, direction.help, ephemeralTotalDevice, e.ephemeral.sum(direction.stat, values))
+			m.timestamp = stats.time
+			metrics = append(metrics, m)
+			continue
+		}
+		for _, iface := range ifaces {
+			if value, ok := values[iface]; ok {
+				m := networkStatMetric(direction.name, direction.help, iface, value)
+				m.timestamp = stats.time
+				metrics = append(metrics, m)
+			}
+		}
+	}
+
+	return metrics
+}
+
+// networkStats reads the byte counters of the interfaces, either each from its sysfs file, or from a snapshot of
+// /proc/net/dev taken at time
+type networkStats struct {
+	e        *promExporter
+	snapshot map[string]netDevCounters
+	time     time.Time
+}
+
+// netDevCounters holds the byte counters of a row of /proc/net/dev, by direction
+type netDevCounters map[string]float64
+
+// readNetworkStats takes a snapshot of the counters of all the interfaces, if Network.Snapshot is set
+func (e *promExporter) readNetworkStats() (networkStats, error) {
+	stats := networkStats{e: e}
+	if !e.Network.Snapshot {
+		return stats, nil
+	}
+
+	var err error
+	stats.snapshot, err = e.readNetDev()
 	if err != nil {
-		return metric{}, err
+		return networkStats{}, err
+	}
+	stats.time = time.Now()
+
+	return stats, nil
+}
+
+func (s networkStats) read(iface string, direction string) (float64, error) {
+	if s.snapshot == nil {
+		return s.e.readNetworkStat(iface, direction)
 	}
 
-	value, err := strconv.ParseFloat(str, 64)
+	counters, ok := s.snapshot[iface]
+	if !ok {
+		return 0, fmt.Errorf("interface %s not found in %s", iface, netDevPath)
+	}
+
+	return counters[direction], nil
+}
+
+func (s networkStats) metric(name string, help string, iface string, direction string) (metric, error) {
+	value, err := s.read(iface, direction)
 	if err != nil {
 		return metric{}, err
 	}
 
+	m := networkStatMetric(name, help, iface, value)
+	m.timestamp = s.time
+
+	return m, nil
+}
+
+// readNetDev reads the byte counters of all the interfaces from /proc/net/dev, where each row is produced at once
+func (e *promExporter) readNetDev() (map[string]netDevCounters, error) {
+	counters := map[string]netDevCounters{}
+	err := utils.ScanFileLines(e.Paths.procPath(netDevPath), utils.LineLimits{}, func(line string) error {
+		iface, c, ok := parseNetDevLine(line)
+		if ok {
+			counters[iface] = c
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counters, nil
+}
+
+// parseNetDevLine parses a row of /proc/net/dev (e.g. "  eth0: 1234 10 0 0 0 0 0 0 5678 20 0 0 0 0 0 0"),
+// returning false for the header lines
+func parseNetDevLine(line string) (string, netDevCounters, bool) {
+	tokens := strings.SplitN(line, ":", 2)
+	if len(tokens) != 2 {
+		return "", nil, false
+	}
+	fields := strings.Fields(tokens[1])
+	if len(fields) < 9 {
+		return "", nil, false
+	}
+	rx, errRx := strconv.ParseFloat(fields[0], 64)
+	tx, errTx := strconv.ParseFloat(fields[8], 64)
+	if errRx != nil || errTx != nil {
+		return "", nil, false
+	}
+
+	return strings.TrimSpace(tokens[0]), netDevCounters{"rx": rx, "tx": tx}, true
+}
+
+func (e *promExporter) readNetworkStat(iface string, direction string) (float64, error) {
+	str, err := utils.ReadFile(e.Paths.sysPath(netDir, iface, "statistics", direction+"_bytes"))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(str, 64)
+}
+
+func networkStatMetric(name string, help string, device string, value float64) metric {
 	return metric{
 		name:       name,
-		attr:       fmt.Sprintf(`device=%q`, iface),
+		attr:       fmt.Sprintf(`device=%q`, device),
 		value:      value,
 		help:       help,
 		metricType: "counter",
-	}, nil
+	}
 }
 
 func (e *promExporter) getPingMetrics() ([]metric, error) {
@@ -60,6 +213,13 @@ func (e *promExporter) getPingMetrics() ([]metric, error) {
 		return nil, err
 	}
 
+	if e.PingSource != "" {
+		pinger.Source, err = resolvePingSource(e.PingSource, pinger.IPAddr().IP.To4() == nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	pinger.SetPrivileged(true)
 	pinger.Timeout = 2 * time.Second
 	pinger.Count = 1
@@ -73,12 +233,51 @@ func (e *promExporter) getPingMetrics() ([]metric, error) {
 	if stats.PacketLoss > 0 {
 		value = math.NaN()
 	}
+	attr := fmt.Sprintf("target=%q", pinger.IPAddr().String())
+	if e.PingSource != "" {
+		attr += fmt.Sprintf(",source=%q", e.PingSource)
+	}
 	m := metric{
-		name:      "node_network_external_roundtrip_time_ms",
-		attr:      fmt.Sprintf("target=%q", pinger.IPAddr().String()),
-		value:     value,
-		timestamp: time.Now(),
+		name:       "node_network_external_roundtrip_time_ms",
+		attr:       attr,
+		value:      value,
+		timestamp:  time.Now(),
+		help:       "Round-trip time of a ping to the target in milliseconds (NaN if lost)",
+		metricType: "gauge",
 	}
 
 	return []metric{m}, nil
 }
+
+// resolvePingSource returns the address the pings are sent from, given either an address or the name of an
+// interface, whose first address of the family of the target is used
+func resolvePingSource(source string, ipv6 bool) (string, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		if (ip.To4() == nil) != ipv6 {
+			return "", fmt.Errorf("ping source %s doesn't match the address family of the target", source)
+		}
+		return ip.String(), nil
+	}
+
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return "", fmt.Errorf("ping source interface %q: %w", source, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("ping source interface %q: %w", source, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() == nil) != ipv6 || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipNet.IP.String(), nil
+	}
+
+	family := "IPv4"
+	if ipv6 {
+		family = "IPv6"
+	}
+	return "", fmt.Errorf("ping source interface %q has no %s address", source, family)
+}
@@ -0,0 +1,79 @@
+package prometheus
+
+func (e *promExporter) getNotificationMetrics() ([]metric, error) {
+	stats := e.NotificationStats()
+
+	return []metric{
+		{
+			name:       "qnapexporter_notification_queue_depth",
+			value:      float64(stats.QueueDepth),
+			help:       "Number of notifications waiting to be delivered",
+			metricType: "gauge",
+		},
+		{
+			name:       "qnapexporter_notifications_delivered_total",
+			value:      float64(stats.Delivered),
+			help:       "Number of notifications delivered",
+			metricType: "counter",
+			created:    e.startTime,
+		},
+		{
+			name:       "qnapexporter_notifications_failed_total",
+			value:      float64(stats.Failed),
+			help:       "Number of notifications which could not be delivered after all retries",
+			metricType: "counter",
+			created:    e.startTime,
+		},
+		{
+			name:       "qnapexporter_notifications_dropped_total",
+			value:      float64(stats.Dropped),
+			help:       "Number of notifications dropped because the queue was full or closed",
+			metricType: "counter",
+			created:    e.startTime,
+		},
+		{
+			name:       "qnapexporter_notifications_suppressed_total",
+			attr:       `reason="duplicate"`,
+			value:      float64(stats.Suppressed),
+			help:       "Number of notifications suppressed by deduplication or rate limiting",
+			metricType: "counter",
+			created:    e.startTime,
+		},
+		{
+			name:       "qnapexporter_notifications_suppressed_total",
+			attr:       `reason="rate_limit"`,
+			value:      float64(stats.RateLimited),
+			help:       "Number of notifications suppressed by deduplication or rate limiting",
+			metricType: "counter",
+			created:    e.startTime,
+		},
+		{
+			name:       "qnapexporter_notifications_journaled_total",
+			value:      float64(stats.Journaled),
+			help:       "Number of notifications written to the journal, since the queue was full or they couldn't be delivered",
+			metricType: "counter",
+			created:    e.startTime,
+		},
+		{
+			name:       "qnapexporter_notifications_replayed_total",
+			value:      float64(stats.Replayed),
+			help:       "Number of notifications delivered from the journal",
+			metricType: "counter",
+			created:    e.startTime,
+		},
+		{
+			name:       "qnapexporter_notifications_evicted_total",
+			value:      float64(stats.Evicted),
+			help:       "Number of the oldest notifications evicted from the journal because it was full",
+			metricType: "counter",
+			created:    e.startTime,
+		},
+		{
+			name:       "qnapexporter_annotations_deleted_total",
+			value:      float64(stats.AnnotationsDeleted),
+			help:       "Number of Grafana annotations deleted because they were older than the retention period",
+			metricType: "counter",
+			created:    e.startTime,
+		},
+	}, nil
+}
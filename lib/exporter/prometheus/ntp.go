@@ -0,0 +1,144 @@
+package prometheus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	ntpTimeout = 2 * time.Second
+	// ntpInterval is the minimum time between two queries of the NTP server, after a successful one
+	ntpInterval = 1 * time.Minute
+	// ntpMaxBackoff bounds the time between two queries of the NTP server while they fail
+	ntpMaxBackoff = 16 * time.Minute
+
+	ntpPacketSize = 48
+	// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and the Unix epoch
+	ntpEpochOffset = 2208988800
+)
+
+// ntpState holds the outcome of the last query of the NTP server, and when to query it next
+type ntpState struct {
+	next    time.Time
+	backoff time.Duration
+	valid   bool
+	offset  time.Duration
+	rtt     time.Duration
+}
+
+// getNTPMetrics reports the offset of the local clock from the NTP server. The server is queried at most once
+// per ntpInterval, backing off while the queries fail, which only drops the metrics.
+func (e *promExporter) getNTPMetrics() ([]metric, error) {
+	now := time.Now()
+	if !now.Before(e.ntp.next) {
+		offset, rtt, err := queryNTP(ntpAddress(e.NTPServer), ntpTimeout)
+		if err != nil {
+			e.ntp.valid = false
+			e.ntp.backoff *= 2
+			if e.ntp.backoff < ntpInterval {
+				e.ntp.backoff = ntpInterval
+			} else if e.ntp.backoff > ntpMaxBackoff {
+				e.ntp.backoff = ntpMaxBackoff
+			}
+			e.ntp.next = now.Add(e.ntp.backoff)
+			e.Logger.Printf("Failed to query NTP server %s, retrying in %v: %v", e.NTPServer, e.ntp.backoff, err)
+		} else {
+			e.ntp = ntpState{next: now.Add(ntpInterval), valid: true, offset: offset, rtt: rtt}
+		}
+	}
+
+	if !e.ntp.valid {
+		return nil, nil
+	}
+
+	attr := fmt.Sprintf("server=%q", e.NTPServer)
+	return []metric{
+		{
+			name:       "node_ntp_offset_seconds",
+			attr:       attr,
+			value:      e.ntp.offset.Seconds(),
+			help:       "Offset of the NTP server clock from the local clock",
+			metricType: "gauge",
+		},
+		{
+			name:       "node_ntp_rtt_seconds",
+			attr:       attr,
+			value:      e.ntp.rtt.Seconds(),
+			help:       "Round-trip time of the query of the NTP server, excluding its processing time",
+			metricType: "gauge",
+		},
+	}, nil
+}
+
+// ntpAddress returns server with the NTP port, unless it already has one
+func ntpAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+
+	return net.JoinHostPort(server, "123")
+}
+
+// queryNTP sends a single SNTP request to address, returning the offset of its clock and the round-trip time
+func queryNTP(address string, timeout time.Duration) (offset, rtt time.Duration, err error) {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, 0, err
+	}
+
+	request := make([]byte, ntpPacketSize)
+	// Leap indicator 0, version 4, client mode
+	request[0] = 0x23
+	sent := time.Now()
+	transmit := toNTPTime(sent)
+	binary.BigEndian.PutUint64(request[40:], transmit)
+	if _, err := conn.Write(request); err != nil {
+		return 0, 0, err
+	}
+
+	response := make([]byte, ntpPacketSize)
+	n, err := conn.Read(response)
+	received := time.Now()
+	if err != nil {
+		return 0, 0, err
+	}
+	if n < ntpPacketSize {
+		return 0, 0, fmt.Errorf("short response of %d bytes", n)
+	}
+	switch {
+	case response[0]&0x7 != 4:
+		return 0, 0, fmt.Errorf("unexpected mode %d in response", response[0]&0x7)
+	case response[1] == 0:
+		return 0, 0, errors.New("kiss-of-death response")
+	case binary.BigEndian.Uint64(response[24:]) != transmit:
+		return 0, 0, errors.New("response doesn't match the request")
+	}
+
+	serverReceived := fromNTPTime(binary.BigEndian.Uint64(response[32:]))
+	serverSent := fromNTPTime(binary.BigEndian.Uint64(response[40:]))
+	offset = (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+	rtt = received.Sub(sent) - serverSent.Sub(serverReceived)
+
+	return offset, rtt, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+
+	return seconds<<32 | fraction
+}
+
+func fromNTPTime(ts uint64) time.Time {
+	seconds := int64(ts>>32) - ntpEpochOffset
+	nanoseconds := int64((ts & 0xffffffff) * uint64(time.Second) >> 32)
+
+	return time.Unix(seconds, nanoseconds)
+}
@@ -0,0 +1,64 @@
+package prometheus
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Default mount points of the host file systems
+const (
+	DefaultRootFS = "/"
+	DefaultProcFS = "/proc"
+	DefaultSysFS  = "/sys"
+)
+
+// Paths holds the mount points the host state is read from, so that the exporter can run in a container
+// with the host's file systems bind-mounted (e.g. under /host)
+type Paths struct {
+	// RootFS is the root of the host file system, where e.g. /dev is found (defaults to DefaultRootFS)
+	RootFS string
+	// ProcFS is the host procfs mount point (defaults to DefaultProcFS)
+	ProcFS string
+	// SysFS is the host sysfs mount point (defaults to DefaultSysFS)
+	SysFS string
+}
+
+func (p Paths) withDefaults() Paths {
+	if p.RootFS == "" {
+		p.RootFS = DefaultRootFS
+	}
+	if p.ProcFS == "" {
+		p.ProcFS = DefaultProcFS
+	}
+	if p.SysFS == "" {
+		p.SysFS = DefaultSysFS
+	}
+
+	return p
+}
+
+// configureGopsutil points gopsutil to the configured paths, which it only reads from the environment
+func (p Paths) configureGopsutil() {
+	for _, v := range []struct{ env, value, defaultValue string }{
+		{"HOST_ROOT", p.RootFS, DefaultRootFS},
+		{"HOST_PROC", p.ProcFS, DefaultProcFS},
+		{"HOST_SYS", p.SysFS, DefaultSysFS},
+		{"HOST_DEV", p.rootPath(devDir), "/" + devDir},
+	} {
+		if v.value != v.defaultValue {
+			_ = os.Setenv(v.env, v.value)
+		}
+	}
+}
+
+func (p Paths) rootPath(elem ...string) string {
+	return filepath.Join(append([]string{p.RootFS}, elem...)...)
+}
+
+func (p Paths) procPath(elem ...string) string {
+	return filepath.Join(append([]string{p.ProcFS}, elem...)...)
+}
+
+func (p Paths) sysPath(elem ...string) string {
+	return filepath.Join(append([]string{p.SysFS}, elem...)...)
+}
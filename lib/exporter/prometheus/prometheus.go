@@ -1,28 +1,43 @@
 package prometheus
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"net"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
 	"github.com/pedropombeiro/qnapexporter/lib/utils"
 )
 
 const (
-	devDir                     = "/dev"
-	netDir                     = "/sys/class/net"
-	flashcacheStatsPath        = "/proc/flashcache/CG0/flashcache_stats"
-	dmCacheStatsFilePathFormat = "/sys/block/%s/dm/cache/curr_stats"
+	// The paths below are relative to the configured root, sysfs and procfs mount points
+	devDir                     = "dev"
+	netDir                     = "class/net"
+	netDevPath                 = "net/dev"
+	blockDir                   = "block"
+	flashcacheStatsPath        = "flashcache/CG0/flashcache_stats"
+	dmCacheStatsFilePathFormat = "block/%s/dm/cache/curr_stats"
 
 	envValidity    = time.Duration(5 * time.Minute)
 	volumeValidity = time.Duration(1 * time.Minute)
+
+	// DefaultGetsysinfoConcurrency is the default value of ExporterConfig.GetsysinfoConcurrency
+	DefaultGetsysinfoConcurrency = 4
+	// MaxStartSpread is the maximum value of ExporterConfig.StartSpread, small enough for the scrapes to fit the
+	// default scrape timeout
+	MaxStartSpread = time.Duration(2 * time.Second)
 )
 
 type fetchMetricFn func() ([]metric, error)
@@ -39,91 +54,428 @@ type promExporter struct {
 	ExporterConfig
 
 	status *exporter.Status
+	// runCommand executes the commands collecting metrics (replaced in tests)
+	runCommand func(ctx context.Context, cmd string, args ...string) (string, error)
+	// interfaceAddrs returns the addresses of a network interface (replaced in tests)
+	interfaceAddrs func(iface string) ([]net.Addr, error)
 
 	hostname      string
+	hostnameMu    sync.RWMutex
 	kernelVersion int
+	// cpuCount is the number of logical CPUs, read with the environment since they aren't hot-plugged
+	cpuCount int
 
 	upsState upsState
+	ntp      ntpState
 
 	getsysinfo string
 	syshdnum   int
 	sysfannum  int
 	ifaces     []string
+	ephemeral  ephemeralCounters
 	devices    []string
 	hal_app    string
+	ethtool    string
+	repquota   string
+	zfs        string
 	enclosures []qnapEnclosure
+	qpkgs      []qpkgInfo
 	envExpiry  time.Time
+	// envSnapshot holds what the previous environment refresh found, to only log the changes
+	envSnapshot envSnapshot
+	// envEntries holds the fingerprints of the devices and interfaces, to refresh the environment early on hot-plug
+	envEntries envEntries
+	// rootEnclosure is the enclosure of the NAS itself, whose sensors are read with hal_app without getsysinfo
+	rootEnclosure qnapEnclosure
+	// expansions holds the expansion units connected to the NAS
+	expansions []qnapEnclosure
+	// expansionsSeen holds the names of the expansion units found since the exporter started, to report those
+	// which were disconnected
+	expansionsSeen map[string]bool
+
+	// deviceIdentities holds the model and serial number of the devices found on the previous environment refresh
+	deviceIdentities map[string]string
+	diskSmart        stateTracker
+	links            linkTracker
+	thermal          thermalWatcher
+	// diskIDs holds the stable identities of the removable devices, if DiskIDLabels is set
+	diskIDs map[string]string
+	// diskMembers holds the edges of the block device stack, e.g. from the disks to the md arrays
+	diskMembers []diskMember
 
 	volumes         []volumeInfo
 	volumeLastFetch time.Time
+	// unsupportedSubcommands holds the optional getsysinfo subcommands which exited with an error, so that they
+	// aren't run again on every environment refresh
+	unsupportedSubcommands map[string]bool
+	volumeStatus           stateTracker
+	mdArrayState           stateTracker
+
+	quotaMetrics []metric
+	quotaErr     error
+	quotaExpiry  time.Time
+
+	qcliStorage    string
+	storageMetrics []metric
+	storageErr     error
+	storageExpiry  time.Time
 
 	dmCacheClients           []string
 	dmCacheDeviceMinorNumber string
 
-	fns     []fetchMetricFn
-	fetchMu sync.Mutex
+	collectors []collector
+	fetchMu    sync.Mutex
+	ready      sync.Once
+
+	envMu sync.Mutex
+	// envRead is set once the environment has been read, and envErr holds the failures of the last read
+	envRead bool
+	envErr  error
+	// selfCheck holds the outcomes of the last self-check, served along with the readiness
+	selfCheck []exporter.SelfCheckResult
+
+	scrapeMu sync.Mutex
+	// scrapeStart is the start time of the scrape in progress, if any
+	scrapeStart time.Time
+	scrapeErr   error
+	// lastFetchErrors holds the errors of the collectors which failed during the last scrape, by collector name
+	lastFetchErrors map[string]string
+	breakers        collectorBreakers
+	// startTime is the time the exporter started, when the counters it accumulates were reset
+	startTime time.Time
+	// quiet is whether the last scrape fell in the quiet hours, pausing the collectors which spin the disks up
+	quiet bool
+	// duplicateSamples counts the samples dropped since their series was already exported in the scrape
+	duplicateSamples uint64
+	// scrapeSamples and scrapeBytes hold the size of the output of the previous scrape
+	scrapeSamples uint64
+	scrapeBytes   uint64
+	seriesWarning sync.Once
+	// scrapeDuration tracks whether the scrapes keep approaching the scrape timeout
+	scrapeDuration scrapeDurationWatcher
+	// stale holds the metrics of the last successful scrape, if StaleMaxAge is set
+	stale staleCache
 }
 
 type ExporterConfig struct {
 	PingTarget string
+	// PingSource, if set, is the address or the name of the interface the pings are sent from
+	PingSource string
 	Logger     *log.Logger
+	// NotificationStats returns the counters of the notification queue, if any
+	NotificationStats func() exporter.NotificationStats
+	// PushStats returns the counters of the batches pushed to each backend, if any
+	PushStats func() map[string]exporter.PushStats
+	// ConnLogStats returns the number of records of the QTS connection log matched by each rule, if watched
+	ConnLogStats func() map[string]uint64
+	// Annotator, if set, receives annotations for the events detected by the exporter
+	Annotator notifications.Annotator
+	// UpsAnnotations enables the annotations of UPS power events
+	UpsAnnotations bool
+	// StorageAnnotations enables the annotations of volume and md array state changes
+	StorageAnnotations bool
+	// DiskAnnotations enables the annotations of disk SMART status changes and hot-swaps
+	DiskAnnotations bool
+	// NetworkAnnotations enables the annotations of the link state changes of the physical interfaces
+	NetworkAnnotations bool
+	// Thermal holds the temperature thresholds above which regions are annotated
+	Thermal ThermalConfig
+	// Paths holds the mount points the host state is read from
+	Paths Paths
+	// Network selects the network interfaces reported
+	Network NetworkConfig
+	// DiskIDLabels adds the stable identity of the removable disks (e.g. USB backup drives) to their metrics as an id
+	// label, since their device name changes whenever they are plugged in
+	DiskIDLabels bool
+	// EthtoolStats enables the collection of the NIC statistics reported by ethtool
+	EthtoolStats bool
+	// Quota configures the collection of the user quota usage
+	Quota QuotaConfig
+	// GPUStats enables the collection of the temperature and frequency of the Intel integrated GPUs
+	GPUStats bool
+	// MalwareScanStats enables the collection of the time and outcome of the last Malware Remover scan
+	MalwareScanStats bool
+	// StoragePoolInterval is the time the qcli_storage pools and RAID groups are cached for
+	// (DefaultStoragePoolInterval, if zero)
+	StoragePoolInterval time.Duration
+	// Certificates lists the certificates whose expiry is reported
+	Certificates CertificateConfig
+	// NTPServer, if set, is the NTP server the offset of the local clock is measured against
+	NTPServer string
+	// DNS configures the DNS resolution probe
+	DNS DNSConfig
+	// Breaker configures the skipping of the collectors which keep failing
+	Breaker BreakerConfig
+	// ScrapeDuration configures the warning raised when the scrapes approach the scrape timeout
+	ScrapeDuration ScrapeDurationConfig
+	// QuietHours holds the time ranges during which the collectors which spin the disks up are paused
+	QuietHours QuietHours
+	// LoadPerCPU enables the load averages divided by the number of logical CPUs (e.g. node_load1_per_cpu)
+	LoadPerCPU bool
+	// DropLegacyMetricNames stops exporting the metrics under their legacy names (e.g. node_cputmp_C), once the
+	// dashboards use their new names (e.g. node_cpu_temperature_celsius)
+	DropLegacyMetricNames bool
+	// SeriesWarningThreshold, if non-zero, is the number of series in a scrape above which a warning is logged once
+	SeriesWarningThreshold int
+	// StaleMaxAge, if non-zero, enables serving the metrics of the last successful scrape when a scrape times out
+	// in WriteMetricsWithin, as long as they were collected less than StaleMaxAge ago
+	StaleMaxAge time.Duration
+	// OnReady, if set, is called once the first environment read completes
+	OnReady func()
+	// ReadEnvironmentOnStartup starts reading the environment in NewExporter, rather than on the first scrape
+	ReadEnvironmentOnStartup bool
+	// CommandTimeout bounds the duration of each command run to collect metrics (utils.DefaultCommandTimeout, if zero)
+	CommandTimeout time.Duration
+	// GetsysinfoConcurrency is the maximum number of disk slots queried at once with getsysinfo
+	// (DefaultGetsysinfoConcurrency, if zero)
+	GetsysinfoConcurrency int
+	// StartSpread staggers the start of the collectors evenly over this window on each scrape, the slow ones first,
+	// rather than spawning all their commands at once (at most MaxStartSpread; all start at once, if zero)
+	StartSpread time.Duration
 }
 
 func NewExporter(config ExporterConfig, status *exporter.Status) exporter.Exporter {
+	config.Paths = config.Paths.withDefaults()
+	config.Paths.configureGopsutil()
+	if config.CommandTimeout <= 0 {
+		config.CommandTimeout = utils.DefaultCommandTimeout
+	}
+	if config.GetsysinfoConcurrency <= 0 {
+		config.GetsysinfoConcurrency = DefaultGetsysinfoConcurrency
+	}
+	if config.Quota.TopUsers <= 0 {
+		config.Quota.TopUsers = DefaultQuotaTopUsers
+	}
+	if config.Quota.Interval <= 0 {
+		config.Quota.Interval = DefaultQuotaInterval
+	}
+	if config.StoragePoolInterval <= 0 {
+		config.StoragePoolInterval = DefaultStoragePoolInterval
+	}
+	config.ScrapeDuration = config.ScrapeDuration.withDefaults()
+	if config.StartSpread > MaxStartSpread {
+		config.StartSpread = MaxStartSpread
+	}
+
 	now := time.Now()
 	e := &promExporter{
 		ExporterConfig: config,
 		status:         status,
+		runCommand:     utils.ExecCommandContext,
+		interfaceAddrs: lookupInterfaceAddrs,
 		envExpiry:      now,
+		startTime:      now,
 	}
-	e.fns = []fetchMetricFn{
-		e.getVersionMetrics,           // #1
-		getUptimeMetrics,              // #2
-		getLoadAvgMetrics,             // #3
-		getCpuRatioMetrics,            // #4
-		getMemInfoMetrics,             // #5
-		e.getUpsStatsMetricsWithRetry, // #6
-		e.getSysInfoTempMetrics,       // #7
-		e.getSysInfoFanMetrics,        // #8
-		e.getEnclosureFanMetrics,      // #9
-		e.getSysInfoHdMetrics,         // #10
-		e.getSysInfoVolMetrics,        // #11
-		e.getDiskStatsMetrics,         // #12
-		e.getFlashCacheStatsMetrics,   // #13
-		e.getDmCacheStatsMetrics,      // #14
-		e.getNetworkStatsMetrics,      // #15
-		e.getPingMetrics,              // #16
+	e.breakers.BreakerConfig = config.Breaker.withDefaults()
+	e.collectors = e.newCollectors()
+	// The collectors also produce the families of the new names of their legacy metrics
+	for i, c := range e.collectors {
+		e.collectors[i].families = e.aliasFamilies(c.families)
 	}
 
 	if status != nil {
 		status.Uptime = now
+		for _, c := range e.collectors {
+			if c.Enabled() {
+				status.Collectors = append(status.Collectors, c.name)
+			}
+		}
+	}
+
+	if config.ReadEnvironmentOnStartup {
+		// Scrapes wait for the read to complete, rather than starting another one
+		e.fetchMu.Lock()
+		go func() {
+			defer e.fetchMu.Unlock()
+
+			e.refreshEnvironment()
+		}()
 	}
 
 	return e
 }
 
+// refreshEnvironment reads the environment, if it has expired. The caller must hold fetchMu.
+func (e *promExporter) refreshEnvironment() {
+	if time.Now().Before(e.envExpiry) {
+		if !e.environmentChanged() {
+			return
+		}
+		e.Logger.Println("Devices or network interfaces changed, reading environment again")
+		e.envExpiry = time.Now()
+	}
+
+	err := e.readEnvironment()
+	if err != nil {
+		e.Logger.Printf("Error reading environment, retrying in %v: %v\n", envValidity, err)
+	}
+	e.envMu.Lock()
+	e.envRead, e.envErr = true, err
+	e.envMu.Unlock()
+	if err == nil {
+		// The collectors which kept failing may work with the new environment (e.g. a replaced disk), so they are
+		// probed again
+		e.breakers.reset()
+	}
+	if e.status != nil {
+		e.status.EnvironmentRead = time.Now()
+		e.status.EnvironmentError = ""
+		if err != nil {
+			e.status.EnvironmentError = err.Error()
+		}
+	}
+
+	if e.OnReady != nil {
+		e.ready.Do(e.OnReady)
+	}
+}
+
+// Ready returns nil once the environment has been read successfully, or the reason why it hasn't
+func (e *promExporter) Ready() error {
+	e.envMu.Lock()
+	defer e.envMu.Unlock()
+
+	if !e.envRead {
+		return errors.New("the environment hasn't been read yet")
+	}
+
+	return e.envErr
+}
+
+// Reload reads the environment again on the next scrape, and runs the collectors skipped after failing repeatedly
+func (e *promExporter) Reload() {
+	e.fetchMu.Lock()
+	defer e.fetchMu.Unlock()
+
+	e.envExpiry = time.Time{}
+	e.breakers.reset()
+}
+
+// execCommand runs a command, killing it if it doesn't complete within the configured timeout
+func (e *promExporter) execCommand(cmd string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.CommandTimeout)
+	defer cancel()
+
+	return e.runCommand(ctx, cmd, args...)
+}
+
+// execCommandGetLines is like execCommand, returning the standard output as an array of lines
+func (e *promExporter) execCommandGetLines(cmd string, args ...string) ([]string, error) {
+	output, err := e.execCommand(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.SplitLines(output), nil
+}
+
 func (e *promExporter) WriteMetrics(w io.Writer) error {
+	// The HELP and TYPE lines of a family are written once, before its first sample
+	described := map[string]bool{}
+	cw := &countingWriter{w: w}
+	samples := 0
+	var written []metric
+	start := time.Now()
+
+	err := e.scrape(
+		func(metrics []metric) {
+			samples += len(metrics)
+			if e.StaleMaxAge > 0 {
+				written = append(written, metrics...)
+			}
+			e.writeMetrics(cw, metrics, described)
+		},
+		func(err error) { _, _ = fmt.Fprintf(cw, "## %v\n", err) },
+	)
+	e.recordScrapeSize(samples, cw.n)
+	e.recordScrapeDuration(time.Since(start))
+	if err == nil && written != nil {
+		e.stale.set(written, start)
+	}
+
+	return err
+}
+
+// CollectSamples scrapes the metrics once, returning them as samples
+func (e *promExporter) CollectSamples() ([]exporter.Sample, error) {
+	var samples []exporter.Sample
+	err := e.scrape(
+		func(metrics []metric) {
+			for _, m := range metrics {
+				samples = append(samples, exporter.Sample{
+					Name:      m.name,
+					Labels:    parseLabels(m.attr),
+					Value:     m.value,
+					Type:      m.metricType,
+					Help:      m.help,
+					Timestamp: m.timestamp,
+				})
+			}
+		},
+		func(error) {},
+	)
+
+	return samples, err
+}
+
+// scrape runs the enabled collectors, passing their metrics to handleMetrics and their errors to handleError
+// as they complete, and returns the last error
+func (e *promExporter) scrape(handleMetrics func([]metric), handleError func(error)) (err error) {
 	e.fetchMu.Lock()
 	defer e.fetchMu.Unlock()
 
+	e.scrapeMu.Lock()
+	e.scrapeStart = time.Now()
+	e.scrapeMu.Unlock()
+	defer func() {
+		e.scrapeMu.Lock()
+		e.scrapeStart, e.scrapeErr = time.Time{}, err
+		e.scrapeMu.Unlock()
+	}()
+
+	fetchErrors := map[string]string{}
+	defer func() { e.lastFetchErrors = fetchErrors }()
 	if e.status != nil {
 		e.status.MetricCount = 0
 		e.status.LastFetch = time.Now()
 		defer func() {
 			e.status.LastFetchDuration = time.Since(e.status.LastFetch)
+			e.status.LastFetchErrors = fetchErrors
 		}()
 	}
 
-	if time.Now().After(e.envExpiry) {
-		e.readEnvironment()
-	}
+	e.refreshEnvironment()
 
 	var wg sync.WaitGroup
 	metricsCh := make(chan interface{}, 4)
-	for idx, fn := range e.fns {
+	now := time.Now()
+	if quiet := e.QuietHours.Contains(now); quiet != e.quiet {
+		e.quiet = quiet
+		if quiet {
+			e.Logger.Println("Quiet hours started, pausing the collectors which spin the disks up")
+		} else {
+			e.Logger.Println("Quiet hours ended, resuming the collectors which spin the disks up")
+		}
+	}
+	var started []collector
+	for _, c := range e.collectors {
+		if !c.Enabled() || (c.wakesDisks && e.quiet) {
+			continue
+		}
+		if e.breakers.skip(c.name, now) {
+			fetchErrors[c.name] = "skipped after failing repeatedly"
+			continue
+		}
+		started = append(started, c)
+	}
+	if e.StartSpread > 0 {
+		// Start the slow collectors first, for the delays not to push them past the scrape timeout
+		sort.SliceStable(started, func(i, j int) bool { return started[i].slow && !started[j].slow })
+	}
+	for i, c := range started {
 		wg.Add(1)
 
-		go fetchMetricsWorker(&wg, metricsCh, idx, fn)
+		go fetchMetricsWorker(&wg, metricsCh, c, e.StartSpread*time.Duration(i)/time.Duration(len(started)))
 	}
 
 	go func() {
@@ -133,43 +485,121 @@ func (e *promExporter) WriteMetrics(w io.Writer) error {
 	}()
 
 	// Retrieve metrics from channel and write them to the response
-	var err error
+	seen := map[string]bool{}
 	for m := range metricsCh {
 		switch v := m.(type) {
 		case []metric:
+			v = e.dropDuplicates(e.aliasMetrics(v), seen)
 			if e.status != nil {
 				e.status.MetricCount += len(v)
 			}
-			for _, m := range v {
-				writeMetricMetadata(w, m)
-
-				var timestamp string
-				if !m.timestamp.IsZero() {
-					timestamp = strconv.Itoa(int(m.timestamp.UnixNano() / 1000000))
-				}
-				_, _ = fmt.Fprintf(w, "%s %g %s\n", e.getMetricFullName(m), m.value, timestamp)
+			handleMetrics(v)
+		case collectorSuccess:
+			if e.breakers.recordSuccess(string(v)) {
+				e.Logger.Printf("Collector %s recovered\n", v)
 			}
 		case error:
 			err = v
 			e.Logger.Println(v.Error())
+			var ce *collectorError
+			if errors.As(v, &ce) {
+				fetchErrors[ce.collector] = ce.err.Error()
+				if backoff := e.breakers.recordFailure(ce.collector, now); backoff > 0 {
+					e.Logger.Printf("Collector %s keeps failing, skipping it for %v\n", ce.collector, backoff)
+				}
+			}
 
-			_, _ = fmt.Fprintf(w, "## %v\n", v)
+			handleError(v)
 		}
 	}
 
 	return err
 }
 
-func fetchMetricsWorker(wg *sync.WaitGroup, metricsCh chan<- interface{}, idx int, fetchMetricsFn fetchMetricFn) {
+// writeMetrics writes out metrics, along with the metadata of the families which aren't in described yet. The
+// creation times of the counters accumulated by the exporter follow the samples of their family.
+func (e *promExporter) writeMetrics(w io.Writer, metrics []metric, described map[string]bool) {
+	var created []metric
+	for i, m := range metrics {
+		if !described[m.name] && (m.help != "" || m.metricType != "") {
+			writeMetricMetadata(w, m)
+			described[m.name] = true
+		}
+
+		var timestamp string
+		if !m.timestamp.IsZero() {
+			timestamp = strconv.Itoa(int(m.timestamp.UnixNano() / 1000000))
+		}
+		_, _ = fmt.Fprintf(w, "%s %s %s\n", e.getMetricFullName(m), formatValue(m.value), timestamp)
+
+		if !m.created.IsZero() {
+			created = append(created, m)
+		}
+		if len(created) > 0 && (i == len(metrics)-1 || metrics[i+1].name != m.name) {
+			e.writeCreated(w, created, described)
+			created = created[:0]
+		}
+	}
+}
+
+// writeCreated writes out the creation times of the counters, in seconds since the epoch
+func (e *promExporter) writeCreated(w io.Writer, counters []metric, described map[string]bool) {
+	for _, m := range counters {
+		c := metric{
+			name:       createdName(m.name),
+			attr:       m.attr,
+			value:      float64(m.created.UnixNano()) / 1e9,
+			help:       fmt.Sprintf("Time the %s counter was last reset, in seconds since the epoch", m.name),
+			metricType: "gauge",
+		}
+		if !described[c.name] {
+			writeMetricMetadata(w, c)
+			described[c.name] = true
+		}
+		_, _ = fmt.Fprintf(w, "%s %s\n", e.getMetricFullName(c), formatValue(c.value))
+	}
+}
+
+// maxExactInteger is the largest integer up to which every integer is exactly representable as a float64 (2^53)
+const maxExactInteger = 1 << 53
+
+// formatValue formats a sample value for the text exposition format, printing the integers which a float64
+// represents exactly (e.g. byte counters) in full rather than in scientific notation
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case v == math.Trunc(v) && math.Abs(v) <= maxExactInteger:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// fetchMetricsWorker runs the collector after delay, sending its metrics and outcome to metricsCh
+func fetchMetricsWorker(wg *sync.WaitGroup, metricsCh chan<- interface{}, c collector, delay time.Duration) {
 	defer wg.Done()
 
-	metrics, err := fetchMetricsFn()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	metrics, err := c.Collect()
 	if err != nil {
-		metricsCh <- fmt.Errorf("retrieve metric #%d: %w", 1+idx, err)
+		// Keep the metrics collected before the failure, e.g. of the disks which could be queried
+		if len(metrics) > 0 {
+			metricsCh <- metrics
+		}
+		metricsCh <- &collectorError{collector: c.name, err: err}
 		return
 	}
 
 	metricsCh <- metrics
+	metricsCh <- collectorSuccess(c.name)
 }
 
 func (e *promExporter) Close() {
@@ -180,18 +610,30 @@ func (e *promExporter) Close() {
 	}
 }
 
-func (e *promExporter) readEnvironment() {
-	e.Logger.Println("Reading environment...")
+// readEnvironment detects the host, disks, enclosures, interfaces and devices to collect metrics from,
+// returning the failures which may cause metrics to be missing until the next read
+func (e *promExporter) readEnvironment() error {
+	utils.Debugf(e.Logger, "Reading environment...")
 
+	var failures []string
 	var err error
-	e.hostname = os.Getenv("HOSTNAME")
-	if e.hostname == "" {
-		e.hostname, err = utils.ExecCommand("hostname")
+	hostname := os.Getenv("HOSTNAME")
+	if hostname == "" {
+		hostname, err = e.execCommand("hostname")
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("get hostname: %v", err))
+		}
+	}
+	e.hostnameMu.Lock()
+	e.hostname = hostname
+	e.hostnameMu.Unlock()
+	if e.status != nil {
+		e.status.Hostname = hostname
 	}
-	e.Logger.Printf("Hostname: %s, err=%v", e.hostname, err)
+	utils.Debugf(e.Logger, "Hostname: %s, err=%v", e.hostname, err)
 
-	e.Logger.Println("Retrieving QTS version")
-	kernelVersionStr, err := utils.ExecCommand("uname", "-r")
+	utils.Debugf(e.Logger, "Retrieving QTS version")
+	kernelVersionStr, err := e.execCommand("uname", "-r")
 	if err == nil {
 		e.kernelVersion, err = strconv.Atoi(strings.SplitN(kernelVersionStr, ".", 2)[0])
 	}
@@ -199,116 +641,161 @@ func (e *promExporter) readEnvironment() {
 		e.kernelVersion = 4
 	}
 
+	e.cpuCount = readCPUCount()
+	utils.Debugf(e.Logger, "Retrieved CPU count: %d", e.cpuCount)
+
+	if e.getsysinfo != "" {
+		// getsysinfo briefly disappears during firmware upgrades
+		if _, err := os.Stat(e.getsysinfo); err != nil {
+			utils.Debugf(e.Logger, "Lost getsysinfo: %v", err)
+			e.clearGetsysinfo()
+		}
+	}
 	if e.getsysinfo == "" {
-		e.getsysinfo, _ = exec.LookPath("getsysinfo")
+		path, err := exec.LookPath("getsysinfo")
 		if err == nil {
-			e.Logger.Printf("Retrieved getsysinfo path: %q", e.getsysinfo)
+			utils.Debugf(e.Logger, "Retrieved getsysinfo path: %q", path)
+			e.getsysinfo = path
 		} else {
-			e.Logger.Printf("Failed to find getsysinfo: %v", err)
+			utils.Debugf(e.Logger, "Failed to find getsysinfo: %v", err)
 		}
 	}
 	if e.getsysinfo != "" {
-		hdnumOutput, err := utils.ExecCommand(e.getsysinfo, "hdnum")
+		hdnumOutput, err := e.execCommand(e.getsysinfo, "hdnum")
 		if err == nil {
 			e.syshdnum, _ = strconv.Atoi(hdnumOutput)
 		} else {
 			e.syshdnum = -1
+			failures = append(failures, fmt.Sprintf("get disk count: %v", err))
 		}
-		e.Logger.Printf("Retrieved sysdhnum: %d", e.syshdnum)
+		utils.Debugf(e.Logger, "Retrieved sysdhnum: %d", e.syshdnum)
 
-		sysfannumOutput, err := utils.ExecCommand(e.getsysinfo, "sysfannum")
+		sysfannumOutput, err := e.execCommand(e.getsysinfo, "sysfannum")
 		if err == nil {
 			e.sysfannum, _ = strconv.Atoi(sysfannumOutput)
 		} else {
 			e.sysfannum = -1
+			failures = append(failures, fmt.Sprintf("get fan count: %v", err))
 		}
-		e.Logger.Printf("Retrieved sysfannum: %d", e.sysfannum)
+		utils.Debugf(e.Logger, "Retrieved sysfannum: %d", e.sysfannum)
 
 		e.readSysVolInfo()
-		e.Logger.Printf("Retrieved sysvolinfo")
+		utils.Debugf(e.Logger, "Retrieved sysvolinfo")
 	}
 
 	if e.hal_app == "" {
 		e.hal_app, _ = exec.LookPath("hal_app")
 		if err != nil {
-			e.Logger.Printf("Failed to find hal_app: %v", err)
+			utils.Debugf(e.Logger, "Failed to find hal_app: %v", err)
 		}
-		e.Logger.Printf("Retrieved hal_app path: %q", e.hal_app)
+		utils.Debugf(e.Logger, "Retrieved hal_app path: %q", e.hal_app)
 	}
 	e.enclosures = nil
-	e.status.Enclosures = nil
+	e.expansions = nil
+	e.rootEnclosure = qnapEnclosure{}
+	if e.status != nil {
+		e.status.Enclosures = nil
+	}
 	if e.hal_app != "" {
-		e.Logger.Println("Retrieving QM2 enclosures")
-		seEnumOutput, err := utils.ExecCommand(e.hal_app, "--se_enum")
+		utils.Debugf(e.Logger, "Retrieving QM2 enclosures and expansion units")
+		seEnumOutput, err := e.execCommand(e.hal_app, "--se_enum")
 		if err == nil {
 			lines := utils.FindMatchingLines("qm2_", seEnumOutput)
 			if len(lines) != 0 {
 				for _, line := range lines {
-					fields := strings.Fields(line)
-					enc := qnapEnclosure{
-						id:   fields[2],
-						name: fields[4],
-					}
-					enc.diskCount, _ = strconv.Atoi(fields[7])
-					enc.fanCount, _ = strconv.Atoi(fields[8])
-					enc.tempCount, _ = strconv.Atoi(fields[10])
+					enc := parseEnclosure(line)
 					if enc.fanCount != 0 {
 						e.enclosures = append(e.enclosures, enc)
-						e.status.Enclosures = append(e.status.Enclosures, enc.name)
+						if e.status != nil {
+							e.status.Enclosures = append(e.status.Enclosures, enc.name)
+						}
 					}
 				}
 			}
+			e.readExpansionUnits(seEnumOutput)
+			if e.getsysinfo == "" {
+				e.readHalAppEnvironment(seEnumOutput)
+			}
+		} else {
+			failures = append(failures, fmt.Sprintf("get enclosures: %v", err))
 		}
 	}
 
-	e.Logger.Printf("Retrieving network interfaces in %q...", netDir)
-	info, _ := os.ReadDir(netDir)
-	e.ifaces = make([]string, 0, len(info))
-	for _, d := range info {
-		iface := d.Name()
-		if !strings.HasPrefix(iface, "eth") {
-			continue
+	// Read before listing the devices and interfaces, so that those changing meanwhile trigger another refresh
+	e.envEntries = e.readEnvEntries()
+	netPath := e.Paths.sysPath(netDir)
+	utils.Debugf(e.Logger, "Retrieving network interfaces in %q...", netPath)
+	// The ephemeral interfaces are listed on every scrape instead
+	e.ifaces = e.listInterfaces(false)
+	if e.EthtoolStats && e.ethtool == "" {
+		e.ethtool, err = exec.LookPath("ethtool")
+		if err != nil {
+			utils.Debugf(e.Logger, "Failed to find ethtool: %v", err)
+		}
+	}
+	if e.qcliStorage == "" {
+		// Only QTS 5 ships qcli_storage, the volumes are still reported from getsysinfo without it
+		e.qcliStorage, _ = exec.LookPath("qcli_storage")
+		if e.qcliStorage != "" {
+			utils.Debugf(e.Logger, "Retrieved qcli_storage path: %q", e.qcliStorage)
+		}
+	}
+	if e.Quota.Enabled && e.repquota == "" && e.zfs == "" {
+		// Volumes use either ext4 quotas or, on QuTS hero, ZFS user quotas
+		e.repquota, _ = exec.LookPath("repquota")
+		e.zfs, _ = exec.LookPath("zfs")
+		if e.repquota == "" && e.zfs == "" {
+			utils.Debugf(e.Logger, "Failed to find repquota or zfs")
 		}
-
-		e.ifaces = append(e.ifaces, iface)
 	}
 
-	e.Logger.Printf("Retrieving devices in %q...", devDir)
-	info, _ = os.ReadDir(devDir)
+	devPath := e.Paths.rootPath(devDir)
+	utils.Debugf(e.Logger, "Retrieving devices in %q...", devPath)
+	info, _ := os.ReadDir(devPath)
 	e.devices = make([]string, 0, len(info))
 	for _, d := range info {
 		dev := d.Name()
-		if d.IsDir() || !strings.HasPrefix(dev, "nvme") && !strings.HasPrefix(dev, "sd") {
-			continue
-		}
-		switch {
-		case strings.HasPrefix(dev, "nvme") && len(dev) != 7:
-			continue
-		case strings.HasPrefix(dev, "sd") && len(dev) != 3:
+		if d.IsDir() || !isDiskDevice(dev) {
 			continue
 		}
 
 		e.devices = append(e.devices, dev)
 	}
-	e.Logger.Printf("Found devices: %v", e.devices)
+	utils.Debugf(e.Logger, "Found devices: %v", e.devices)
+	e.trackDevices(e.Paths.sysPath(blockDir))
+	if e.DiskIDLabels {
+		e.diskIDs = e.readRemovableDiskIDs()
+		utils.Debugf(e.Logger, "Found removable device identities: %v", e.diskIDs)
+	}
+	e.diskMembers, err = readDiskMembers(e.Paths.sysPath(blockDir))
+	if err != nil {
+		e.Logger.Printf("Failed to read the members of the md and dm devices: %v", err)
+	}
+
+	qpkgPath := e.Paths.rootPath(qpkgConfPath)
+	e.qpkgs, err = readQpkgs(qpkgPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		e.Logger.Printf("Failed to read the installed applications from %q: %v", qpkgPath, err)
+	}
+	utils.Debugf(e.Logger, "Found %d installed applications", len(e.qpkgs))
 
 	e.dmCacheClients = []string{}
 	if e.kernelVersion >= 5 {
-		e.Logger.Print("Retrieving dm-cache devices...")
+		utils.Debugf(e.Logger, "Retrieving dm-cache devices...")
 
-		table, err := utils.ExecCommand("dmsetup", "table")
+		table, err := e.execCommand("dmsetup", "table")
 		if err == nil {
 			cacheClients := utils.FindMatchingLines("cache_client", table)
 			for _, cacheClient := range cacheClients {
 				e.dmCacheClients = append(e.dmCacheClients, strings.SplitN(cacheClient, ":", 2)[0])
 			}
 		}
-		e.Logger.Printf("Found cache clients: %v", e.dmCacheClients)
+		utils.Debugf(e.Logger, "Found cache clients: %v", e.dmCacheClients)
 
-		table, err = utils.ExecCommand("dmsetup", "ls")
+		table, err = e.execCommand("dmsetup", "ls")
 		if err == nil {
 			cacheDevices := utils.FindMatchingLines("vg256-lv256\t", table)
-			e.Logger.Printf("Found cache volumes: %v", cacheDevices)
+			utils.Debugf(e.Logger, "Found cache volumes: %v", cacheDevices)
 			if len(cacheDevices) == 1 {
 				e.dmCacheDeviceMinorNumber = strings.Split(cacheDevices[0], ":")[1]
 				e.dmCacheDeviceMinorNumber = strings.TrimRight(e.dmCacheDeviceMinorNumber, ")")
@@ -317,6 +804,7 @@ func (e *promExporter) readEnvironment() {
 	}
 
 	e.envExpiry = e.envExpiry.Add(envValidity)
+	e.logEnvironment(e.takeEnvSnapshot())
 
 	if e.status != nil {
 		e.status.Devices = e.devices
@@ -328,6 +816,44 @@ func (e *promExporter) readEnvironment() {
 			e.status.DmCacheDevice = ""
 		}
 	}
+
+	if len(failures) != 0 {
+		return fmt.Errorf("read environment: %s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// clearGetsysinfo forgets getsysinfo and what it reported, so that its collectors don't run until it is found again,
+// when the disks, fans and volumes are counted again
+func (e *promExporter) clearGetsysinfo() {
+	e.getsysinfo = ""
+	e.syshdnum, e.sysfannum = 0, 0
+	e.volumes = nil
+	e.volumeLastFetch = time.Time{}
+	// The getsysinfo found again may be a newer version, supporting more subcommands
+	e.unsupportedSubcommands = nil
+}
+
+// Healthy returns whether the last scrape succeeded, and the one in progress (if any) has been running
+// for less than timeout
+func (e *promExporter) Healthy(timeout time.Duration) bool {
+	e.scrapeMu.Lock()
+	defer e.scrapeMu.Unlock()
+
+	if !e.scrapeStart.IsZero() && time.Since(e.scrapeStart) >= timeout {
+		return false
+	}
+
+	return e.scrapeErr == nil
+}
+
+// Hostname returns the hostname detected when reading the environment
+func (e *promExporter) Hostname() string {
+	e.hostnameMu.RLock()
+	defer e.hostnameMu.RUnlock()
+
+	return e.hostname
 }
 
 func (e *promExporter) getMetricFullName(m metric) string {
@@ -2,13 +2,35 @@ package prometheus
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,7 +73,7 @@ func BenchmarkWriteMetrics(b *testing.B) {
 		PingTarget: "8.8.8.8",
 		Logger:     log.New(io.Discard, "", 0),
 	}
-	e := NewExporter(config, nil)
+	e := NewExporter(config, &exporter.Status{})
 	defer e.Close()
 
 	for i := 0; i < b.N; i++ {
@@ -59,3 +81,2811 @@ func BenchmarkWriteMetrics(b *testing.B) {
 		_ = e.WriteMetrics(buf)
 	}
 }
+
+func TestGetNotificationMetrics(t *testing.T) {
+	config := ExporterConfig{
+		Logger: log.New(io.Discard, "", 0),
+		NotificationStats: func() exporter.NotificationStats {
+			return exporter.NotificationStats{QueueDepth: 3, Delivered: 10, Failed: 2, Dropped: 1, Suppressed: 5, RateLimited: 4, AnnotationsDeleted: 7, Journaled: 6, Replayed: 5, Evicted: 1}
+		},
+	}
+	e := NewExporter(config, nil).(*promExporter)
+
+	metrics, err := e.getNotificationMetrics()
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, m := range metrics {
+		values[e.getMetricFullName(m)] = m.value
+	}
+	assert.Equal(t, map[string]float64{
+		`qnapexporter_notification_queue_depth{node=""}`:                           3,
+		`qnapexporter_notifications_delivered_total{node=""}`:                      10,
+		`qnapexporter_notifications_failed_total{node=""}`:                         2,
+		`qnapexporter_notifications_dropped_total{node=""}`:                        1,
+		`qnapexporter_notifications_suppressed_total{node="",reason="duplicate"}`:  5,
+		`qnapexporter_notifications_suppressed_total{node="",reason="rate_limit"}`: 4,
+		`qnapexporter_annotations_deleted_total{node=""}`:                          7,
+		`qnapexporter_notifications_journaled_total{node=""}`:                      6,
+		`qnapexporter_notifications_replayed_total{node=""}`:                       5,
+		`qnapexporter_notifications_evicted_total{node=""}`:                        1,
+	}, values)
+}
+
+func TestGetConnLogMetrics(t *testing.T) {
+	config := ExporterConfig{
+		Logger: log.New(io.Discard, "", 0),
+		ConnLogStats: func() map[string]uint64 {
+			return map[string]uint64{"ssh_failed_login": 4, "smb_writes": 12}
+		},
+	}
+	e := NewExporter(config, nil).(*promExporter)
+
+	metrics, err := e.getConnLogMetrics()
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, m := range metrics {
+		names = append(names, fmt.Sprintf("%s %v", e.getMetricFullName(m), m.value))
+		assert.Equal(t, e.startTime, m.created)
+	}
+	assert.Equal(t, []string{
+		`qnap_connlog_events_total{node="",rule="smb_writes"} 12`,
+		`qnap_connlog_events_total{node="",rule="ssh_failed_login"} 4`,
+	}, names)
+}
+
+func TestWriteMetricsCreated(t *testing.T) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, nil).(*promExporter)
+	e.hostname = "nas"
+	e.startTime = time.Unix(1700000000, 500000000)
+
+	var b bytes.Buffer
+	counters := []metric{
+		{name: "qnapexporter_push_batches_sent_total", attr: `backend="graphite"`, value: 3, metricType: "counter", created: e.startTime},
+		{name: "qnapexporter_push_batches_sent_total", attr: `backend="otlp"`, value: 4, metricType: "counter", created: e.startTime},
+		{name: "node_load1", value: 0.5, metricType: "gauge"},
+	}
+	e.writeMetrics(&b, counters, map[string]bool{})
+	assert.Equal(t, `# TYPE qnapexporter_push_batches_sent_total counter
+qnapexporter_push_batches_sent_total{node="nas",backend="graphite"} 3 
+qnapexporter_push_batches_sent_total{node="nas",backend="otlp"} 4 
+# HELP qnapexporter_push_batches_sent_created Time the qnapexporter_push_batches_sent_total counter was last reset, in seconds since the epoch
+# TYPE qnapexporter_push_batches_sent_created gauge
+qnapexporter_push_batches_sent_created{node="nas",backend="graphite"} 1.7000000005e+09
+qnapexporter_push_batches_sent_created{node="nas",backend="otlp"} 1.7000000005e+09
+# TYPE node_load1 gauge
+node_load1{node="nas"} 0.5 
+`, b.String())
+
+	metrics, err := e.getDuplicateMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, e.startTime, metrics[0].created)
+}
+
+func TestCollectors(t *testing.T) {
+	t.Setenv("HOSTNAME", "nas1")
+	config := ExporterConfig{
+		Logger:     log.New(io.Discard, "", 0),
+		PingTarget: "1.1.1.1",
+	}
+	e := NewExporter(config, &exporter.Status{})
+	defer e.Close()
+
+	cl, ok := e.(exporter.CollectorLister)
+	require.True(t, ok)
+	collectors := map[string]exporter.CollectorInfo{}
+	for _, c := range cl.Collectors() {
+		assert.NotEmpty(t, c.Families, c.Name)
+		collectors[c.Name] = c
+	}
+
+	assert.Equal(t, "nas1", e.(exporter.HostnameProvider).Hostname(), "the environment is read")
+	assert.True(t, collectors["cpu"].Enabled)
+	assert.Empty(t, collectors["cpu"].Prerequisites)
+	assert.True(t, collectors["ping"].Enabled)
+	assert.False(t, collectors["notifications"].Enabled)
+	require.Len(t, collectors["hd"].Prerequisites, 1)
+	assert.Equal(t, "getsysinfo", collectors["hd"].Prerequisites[0].Name)
+	assert.Equal(t, []string{"node_hdtmp_C", "node_hd_temperature_celsius"}, collectors["hd"].Families)
+}
+
+func TestWriteCollectorMetrics(t *testing.T) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, &exporter.Status{}).(*promExporter)
+	defer e.Close()
+
+	e.collectors = []collector{
+		{name: "good", fetch: func() ([]metric, error) { return []metric{{name: "good_metric", value: 1}}, nil }},
+		{
+			name:    "partial",
+			fetch:   func() ([]metric, error) { return []metric{{name: "partial_metric", value: 2}}, errors.New("boom") },
+			enabled: func() bool { return false },
+		},
+	}
+
+	b := new(bytes.Buffer)
+	require.NoError(t, e.WriteCollectorMetrics(b, "good"))
+	assert.Contains(t, b.String(), "good_metric{node=")
+
+	// Disabled collectors also run, and the metrics collected before the error are written
+	b.Reset()
+	assert.EqualError(t, e.WriteCollectorMetrics(b, "partial"), "retrieve partial metrics: boom")
+	assert.Contains(t, b.String(), "partial_metric{node=")
+	assert.NotContains(t, b.String(), "good_metric")
+
+	assert.EqualError(t, e.WriteCollectorMetrics(b, "missing"), `unknown collector "missing" (expected one of good, partial)`)
+}
+
+func TestWriteMetricsSkipsDisabledCollectors(t *testing.T) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, &exporter.Status{}).(*promExporter)
+	defer e.Close()
+
+	e.collectors = []collector{
+		{name: "enabled", fetch: func() ([]metric, error) { return []metric{{name: "enabled_metric", value: 1}}, nil }},
+		{name: "failing", fetch: func() ([]metric, error) { return nil, errors.New("boom") }},
+		{
+			name:    "disabled",
+			fetch:   func() ([]metric, error) { return []metric{{name: "disabled_metric", value: 1}}, nil },
+			enabled: func() bool { return false },
+		},
+	}
+	b := new(bytes.Buffer)
+
+	err := e.WriteMetrics(b)
+
+	assert.EqualError(t, err, "retrieve failing metrics: boom")
+	assert.Contains(t, b.String(), "enabled_metric{node=")
+	assert.Contains(t, b.String(), "## retrieve failing metrics: boom\n")
+	assert.NotContains(t, b.String(), "disabled_metric")
+}
+
+func TestWriteMetricsStartSpread(t *testing.T) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), StartSpread: 200 * time.Millisecond}, nil).(*promExporter)
+	defer e.Close()
+
+	var mu sync.Mutex
+	starts := map[string]time.Duration{}
+	begin := time.Now()
+	fetch := func(name string) fetchMetricFn {
+		return func() ([]metric, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			starts[name] = time.Since(begin)
+			return nil, nil
+		}
+	}
+	e.collectors = []collector{
+		{name: "loadavg", fetch: fetch("loadavg")},
+		{name: "meminfo", fetch: fetch("meminfo")},
+		{name: "hd", fetch: fetch("hd"), slow: true},
+		{name: "volume", fetch: fetch("volume"), slow: true},
+	}
+
+	require.NoError(t, e.WriteMetrics(io.Discard))
+
+	// The slow collectors start first, each collector 50ms after the previous one
+	assert.Less(t, starts["hd"], starts["volume"])
+	assert.Less(t, starts["volume"], starts["loadavg"])
+	assert.Less(t, starts["loadavg"], starts["meminfo"])
+	assert.GreaterOrEqual(t, starts["meminfo"]-starts["hd"], 150*time.Millisecond)
+
+	e = NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), StartSpread: time.Hour}, nil).(*promExporter)
+	defer e.Close()
+	assert.Equal(t, MaxStartSpread, e.StartSpread)
+}
+
+func TestHostname(t *testing.T) {
+	t.Setenv("HOSTNAME", "nas1")
+	config := ExporterConfig{
+		Logger: log.New(io.Discard, "", 0),
+	}
+	e := NewExporter(config, &exporter.Status{})
+	defer e.Close()
+
+	hp, ok := e.(exporter.HostnameProvider)
+	require.True(t, ok)
+	assert.Empty(t, hp.Hostname())
+
+	_ = e.WriteMetrics(io.Discard)
+	assert.Equal(t, "nas1", hp.Hostname())
+}
+
+func TestHealthyAndOnReady(t *testing.T) {
+	t.Setenv("HOSTNAME", "nas1")
+	var ready int
+	config := ExporterConfig{
+		Logger:  log.New(io.Discard, "", 0),
+		OnReady: func() { ready++ },
+	}
+	e := NewExporter(config, &exporter.Status{}).(*promExporter)
+	defer e.Close()
+
+	var fail bool
+	block := make(chan struct{})
+	e.collectors = []collector{
+		{
+			name: "test",
+			fetch: func() ([]metric, error) {
+				<-block
+				if fail {
+					return nil, errors.New("scrape failed")
+				}
+				return nil, nil
+			},
+		},
+	}
+	hr, ok := exporter.Exporter(e).(exporter.HealthReporter)
+	require.True(t, ok)
+	assert.True(t, hr.Healthy(time.Minute))
+
+	// A scrape in progress for longer than the timeout is unhealthy
+	done := make(chan error)
+	go func() { done <- e.WriteMetrics(io.Discard) }()
+	require.Eventually(t, func() bool { return !e.Healthy(0) }, time.Second, time.Millisecond)
+	assert.True(t, e.Healthy(time.Minute))
+	close(block)
+	require.NoError(t, <-done)
+	assert.True(t, e.Healthy(0))
+	assert.Equal(t, 1, ready)
+
+	fail = true
+	require.Error(t, e.WriteMetrics(io.Discard))
+	assert.False(t, e.Healthy(time.Minute))
+
+	fail = false
+	e.envExpiry = time.Now()
+	require.NoError(t, e.WriteMetrics(io.Discard))
+	assert.True(t, e.Healthy(time.Minute))
+	assert.Equal(t, 1, ready, "OnReady is only called after the first environment read")
+}
+
+func TestTrackUpsPower(t *testing.T) {
+	annotator := &notifications.MockAnnotator{}
+	posted := make(chan notifications.Annotation, 2)
+	annotator.On("PostAnnotation", mock.Anything).
+		Run(func(args mock.Arguments) { posted <- args.Get(0).(notifications.Annotation) }).
+		Return(1, nil)
+	config := ExporterConfig{
+		Logger:         log.New(io.Discard, "", 0),
+		Annotator:      annotator,
+		UpsAnnotations: true,
+	}
+	e := NewExporter(config, nil).(*promExporter)
+	defer e.Close()
+
+	// A single reading on battery is ignored
+	for _, status := range []string{"OL", "OL CHRG", "OB DISCHRG", "OL", "OFF", "OB DISCHRG"} {
+		e.trackUpsPower("qnapups", status, false)
+	}
+	annotator.AssertNotCalled(t, "PostAnnotation", mock.Anything)
+
+	e.trackUpsPower("qnapups", "OB DISCHRG", false)
+	a := <-posted
+	assert.Equal(t, "On battery", a.Text)
+	assert.Equal(t, []string{"ups"}, a.Tags)
+	assert.Equal(t, "ups:qnapups", a.Key)
+	assert.False(t, a.End)
+
+	e.trackUpsPower("qnapups", "OB LB", false)
+	e.trackUpsPower("qnapups", "OL CHRG", false)
+	e.trackUpsPower("qnapups", "OL CHRG", false)
+	a = <-posted
+	assert.Equal(t, "On battery", a.Text)
+	assert.Equal(t, "ups:qnapups", a.Key)
+	assert.True(t, a.End)
+
+	e.trackUpsPower("qnapups", "OL", false)
+	annotator.AssertNumberOfCalls(t, "PostAnnotation", 2)
+}
+
+func newAnnotationRecorder() (*notifications.MockAnnotator, chan notifications.Annotation) {
+	annotator := &notifications.MockAnnotator{}
+	posted := make(chan notifications.Annotation, 10)
+	annotator.On("PostAnnotation", mock.Anything).
+		Run(func(args mock.Arguments) { posted <- args.Get(0).(notifications.Annotation) }).
+		Return(1, nil)
+
+	return annotator, posted
+}
+
+func TestRecordScrapeDuration(t *testing.T) {
+	annotator, posted := newAnnotationRecorder()
+	config := ExporterConfig{
+		Logger:         log.New(io.Discard, "", 0),
+		Annotator:      annotator,
+		ScrapeDuration: ScrapeDurationConfig{Timeout: 10 * time.Second, Scrapes: 2},
+	}
+	e := NewExporter(config, nil).(*promExporter)
+	defer e.Close()
+	warning := func() float64 {
+		metrics, err := e.getScrapeDurationMetrics()
+		require.NoError(t, err)
+		return metrics[0].value
+	}
+
+	// A single slow scrape is ignored
+	for _, d := range []time.Duration{9 * time.Second, 2 * time.Second, 8500 * time.Millisecond} {
+		e.recordScrapeDuration(d)
+	}
+	assert.Equal(t, 0.0, warning())
+	annotator.AssertNotCalled(t, "PostAnnotation", mock.Anything)
+
+	e.recordScrapeDuration(8400 * time.Millisecond)
+	a := <-posted
+	assert.Equal(t, "Scrapes taking 8.4s, approaching the timeout of 10s", a.Text)
+	assert.Equal(t, []string{"exporter"}, a.Tags)
+	assert.Equal(t, 1.0, warning())
+
+	// Scrapes just under the budget don't clear the warning, nor is it posted again
+	for _, d := range []time.Duration{7500 * time.Millisecond, 7500 * time.Millisecond, 9 * time.Second, 9 * time.Second} {
+		e.recordScrapeDuration(d)
+	}
+	assert.Equal(t, 1.0, warning())
+
+	e.recordScrapeDuration(3 * time.Second)
+	e.recordScrapeDuration(3 * time.Second)
+	assert.Equal(t, 0.0, warning())
+	annotator.AssertNumberOfCalls(t, "PostAnnotation", 1)
+
+	// Inert without the scrape timeout
+	e = NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, nil).(*promExporter)
+	defer e.Close()
+	for i := 0; i < 5; i++ {
+		e.recordScrapeDuration(time.Minute)
+	}
+	assert.Equal(t, 0.0, warning())
+	for _, c := range e.collectors {
+		if c.name == "scrapeduration" {
+			assert.False(t, c.Enabled())
+		}
+	}
+}
+
+func TestTrackVolumeStatus(t *testing.T) {
+	annotator, posted := newAnnotationRecorder()
+	config := ExporterConfig{
+		Logger:             log.New(io.Discard, "", 0),
+		Annotator:          annotator,
+		StorageAnnotations: true,
+	}
+	e := NewExporter(config, nil).(*promExporter)
+	defer e.Close()
+
+	// The status observed on the first scrape isn't a transition
+	e.trackVolumeStatus(volumeInfo{index: "1", description: "Media", status: "Degraded"})
+	e.trackVolumeStatus(volumeInfo{index: "1", description: "Media", status: "Degraded"})
+	annotator.AssertNotCalled(t, "PostAnnotation", mock.Anything)
+
+	e.trackVolumeStatus(volumeInfo{index: "1", description: "Media", status: "Rebuilding... (10%)"})
+	a := <-posted
+	assert.Equal(t, notifications.Annotation{Text: "Volume Media degraded", Tags: []string{"storage"}, Time: a.Time, End: true, Key: "volume:1"}, a)
+	a = <-posted
+	assert.Equal(t, notifications.Annotation{Text: "Volume Media rebuilding", Tags: []string{"storage"}, Time: a.Time, Key: "volume:1"}, a)
+
+	// Progress isn't a transition
+	e.trackVolumeStatus(volumeInfo{index: "1", description: "Media", status: "Rebuilding... (50%)"})
+
+	e.trackVolumeStatus(volumeInfo{index: "1", description: "Media", status: "Ready"})
+	a = <-posted
+	assert.Equal(t, notifications.Annotation{Text: "Volume Media rebuilding", Tags: []string{"storage"}, Time: a.Time, End: true, Key: "volume:1"}, a)
+
+	e.trackVolumeStatus(volumeInfo{index: "1", description: "Media", status: "Degraded"})
+	a = <-posted
+	assert.Equal(t, notifications.Annotation{Text: "Volume Media degraded", Tags: []string{"storage"}, Time: a.Time, Key: "volume:1"}, a)
+	annotator.AssertNumberOfCalls(t, "PostAnnotation", 4)
+}
+
+func TestSysVolInfoTemperature(t *testing.T) {
+	testCases := map[string]struct {
+		volTemp      func() (string, error)
+		expectedTemp bool
+		// expectedCalls is the number of vol_temp calls over two environment reads and a scrape
+		expectedCalls int
+	}{
+		"supported": {
+			volTemp:       func() (string, error) { return "41 C/105 F", nil },
+			expectedTemp:  true,
+			expectedCalls: 3,
+		},
+		"unsupported": {
+			volTemp:       func() (string, error) { return "", &utils.CommandError{Command: "getsysinfo", ExitCode: 1} },
+			expectedCalls: 1,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, &exporter.Status{}).(*promExporter)
+			defer e.Close()
+			e.getsysinfo = "getsysinfo"
+			volTempCalls := 0
+			e.runCommand = func(ctx context.Context, cmd string, args ...string) (string, error) {
+				switch args[0] {
+				case "sysvolnum":
+					return "1", nil
+				case "vol_desc":
+					return "[Volume Media, Pool 1]", nil
+				case "vol_fs":
+					return "EXT4", nil
+				case "vol_totalsize", "vol_freesize":
+					return "1.00 TB", nil
+				case "vol_status":
+					return "Rebuilding... (50%)", nil
+				case "vol_temp":
+					volTempCalls++
+					return tc.volTemp()
+				}
+				return "", fmt.Errorf("unexpected command %s %v", cmd, args)
+			}
+
+			e.readSysVolInfo()
+			e.readSysVolInfo()
+			metrics, err := e.getSysInfoVolMetrics()
+			require.NoError(t, err)
+
+			values := map[string]float64{}
+			for _, m := range metrics {
+				values[e.getMetricFullName(m)] = m.value
+			}
+			assert.Equal(t, 0.0, values[`node_volume_status{node="",volume="Media",status="Rebuilding"}`])
+			temp, ok := values[`node_volume_temperature_celsius{node="",volume="Media"}`]
+			assert.Equal(t, tc.expectedTemp, ok)
+			if tc.expectedTemp {
+				assert.Equal(t, 41.0, temp)
+			}
+			assert.Equal(t, tc.expectedCalls, volTempCalls)
+		})
+	}
+}
+
+func TestGetMdArrayMetrics(t *testing.T) {
+	dir := t.TempDir()
+	writeMdAttr := func(array, attr, value string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, array, "md"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, array, "md", attr), []byte(value+"\n"), 0o644))
+	}
+	writeMdAttr("md1", "degraded", "0")
+	writeMdAttr("md1", "raid_disks", "4")
+	writeMdAttr("md9", "raid_disks", "1") // RAID 0
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sda"), 0o755))
+
+	arrays, err := readMdArrays(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []mdArray{{name: "md1", raidDisks: 4}}, arrays)
+
+	annotator, posted := newAnnotationRecorder()
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), Annotator: annotator, StorageAnnotations: true}, nil).(*promExporter)
+	defer e.Close()
+
+	e.trackMdArray(mdArray{name: "md1", raidDisks: 4})
+	e.trackMdArray(mdArray{name: "md1", raidDisks: 4, degraded: 1})
+	a := <-posted
+	assert.Equal(t, notifications.Annotation{Text: "RAID md1 degraded", Tags: []string{"storage"}, Time: a.Time}, a)
+	e.trackMdArray(mdArray{name: "md1", raidDisks: 4, degraded: 2})
+	e.trackMdArray(mdArray{name: "md1", raidDisks: 4})
+	a = <-posted
+	assert.Equal(t, notifications.Annotation{Text: "RAID md1 degraded", Tags: []string{"storage"}, Time: a.Time, End: true}, a)
+	annotator.AssertNumberOfCalls(t, "PostAnnotation", 2)
+}
+
+func TestTrackDiskSmart(t *testing.T) {
+	annotator, posted := newAnnotationRecorder()
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), Annotator: annotator, DiskAnnotations: true}, nil).(*promExporter)
+	defer e.Close()
+
+	e.trackDiskSmart("3", "Good")
+	e.trackDiskSmart("3", "GOOD")
+	annotator.AssertNotCalled(t, "PostAnnotation", mock.Anything)
+
+	e.trackDiskSmart("3", "Warning")
+	e.trackDiskSmart("3", "Warning")
+	a := <-posted
+	assert.Equal(t, notifications.Annotation{Text: "Disk 3 SMART status Warning", Tags: []string{"disk"}, Time: a.Time, Key: "disk:3"}, a)
+
+	e.trackDiskSmart("3", "Good")
+	a = <-posted
+	assert.Equal(t, notifications.Annotation{Text: "Disk 3 SMART status Good", Tags: []string{"disk"}, Time: a.Time, Key: "disk:3"}, a)
+	annotator.AssertNumberOfCalls(t, "PostAnnotation", 2)
+}
+
+func TestTrackDevices(t *testing.T) {
+	dir := t.TempDir()
+	writeDeviceAttr := func(dev, attr, value string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, dev, "device"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, dev, "device", attr), []byte(value+"\n"), 0o644))
+	}
+	writeDeviceAttr("sda", "model", "WDC WD40EFRX-68N")
+	writeDeviceAttr("nvme0n1", "model", "Samsung SSD 970")
+	writeDeviceAttr("nvme0n1", "serial", "S4EWNX0N123456")
+	writeDeviceAttr("sdc", "model", "ST4000VN008")
+
+	annotator, posted := newAnnotationRecorder()
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), Annotator: annotator, DiskAnnotations: true}, nil).(*promExporter)
+	defer e.Close()
+
+	// The devices found on the first refresh are only recorded
+	e.devices = []string{"sda", "sdb", "nvme0n1"}
+	e.trackDevices(dir)
+	e.trackDevices(dir)
+	annotator.AssertNotCalled(t, "PostAnnotation", mock.Anything)
+
+	e.devices = []string{"sda", "sdc"}
+	e.trackDevices(dir)
+	for _, text := range []string{"Disk sdc added: ST4000VN008", "Disk nvme0n1 removed: Samsung SSD 970 (S4EWNX0N123456)", "Disk sdb removed"} {
+		a := <-posted
+		assert.Equal(t, notifications.Annotation{Text: text, Tags: []string{"disk"}, Time: a.Time}, a)
+	}
+	annotator.AssertNumberOfCalls(t, "PostAnnotation", 3)
+}
+
+func TestParseThermalThresholds(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ThermalConfig
+		wantErr string
+	}{
+		{value: "", want: ThermalConfig{}},
+		{value: "cpu,hd", want: ThermalConfig{CPU: 80, Disk: 50}},
+		{value: "cpu=85, System=60 ,hd=45.5", want: ThermalConfig{CPU: 85, System: 60, Disk: 45.5}},
+		{value: "gpu=70", wantErr: `unknown sensor class "gpu" (expected cpu, system or hd)`},
+		{value: "cpu=hot", wantErr: `invalid cpu temperature threshold "hot"`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			config, err := ParseThermalThresholds(tc.value)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, config)
+			assert.Equal(t, tc.want != ThermalConfig{}, config.Enabled())
+		})
+	}
+}
+
+func TestWatchTemperature(t *testing.T) {
+	annotator, posted := newAnnotationRecorder()
+	config := ExporterConfig{
+		Logger:    log.New(io.Discard, "", 0),
+		Annotator: annotator,
+		Thermal:   ThermalConfig{Disk: 50, Duration: 5 * time.Minute},
+	}
+	e := NewExporter(config, nil).(*promExporter)
+	defer e.Close()
+
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	// Disabled class
+	e.watchTemperature(thermalClassCPU, "CPU", 99, at(0))
+	e.watchTemperature(thermalClassCPU, "CPU", 99, at(time.Hour))
+	// Not above the threshold for long enough
+	e.watchTemperature(thermalClassDisk, "Disk 1", 51, at(0))
+	e.watchTemperature(thermalClassDisk, "Disk 1", 50, at(4*time.Minute))
+	e.watchTemperature(thermalClassDisk, "Disk 1", 51, at(5*time.Minute))
+	annotator.AssertNotCalled(t, "PostAnnotation", mock.Anything)
+
+	e.watchTemperature(thermalClassDisk, "Disk 1", 52, at(10*time.Minute))
+	a := <-posted
+	assert.Equal(t, notifications.Annotation{Text: "Disk 1 temperature above 50°C", Tags: []string{"thermal"}, Time: at(5 * time.Minute)}, a)
+
+	// Within the hysteresis
+	e.watchTemperature(thermalClassDisk, "Disk 1", 49, at(11*time.Minute))
+	e.watchTemperature(thermalClassDisk, "Disk 1", 48, at(12*time.Minute))
+	e.watchTemperature(thermalClassDisk, "Disk 1", 47.5, at(13*time.Minute))
+	a = <-posted
+	assert.Equal(t, notifications.Annotation{Text: "Disk 1 temperature above 50°C", Tags: []string{"thermal"}, Time: at(13 * time.Minute), End: true}, a)
+	annotator.AssertNumberOfCalls(t, "PostAnnotation", 2)
+}
+
+func TestPathsPrefixCollectors(t *testing.T) {
+	for _, env := range []string{"HOST_ROOT", "HOST_PROC", "HOST_SYS", "HOST_DEV"} {
+		t.Setenv(env, "")
+	}
+	root := t.TempDir()
+	writeFixture := func(name, contents string) {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+	writeFixture("dev/sda", "")
+	writeFixture("dev/tty0", "")
+	writeFixture("proc/flashcache/CG0/flashcache_stats", "reads: 10\nwrites: 20\n")
+	writeFixture("sys/class/net/eth0/statistics/rx_bytes", "1000\n")
+	writeFixture("sys/class/net/eth0/statistics/tx_bytes", "2000\n")
+	writeFixture("sys/class/net/lo/statistics/rx_bytes", "1\n")
+	writeFixture("sys/block/md1/md/degraded", "1\n")
+	writeFixture("sys/block/md1/md/raid_disks", "2\n")
+
+	config := ExporterConfig{
+		Logger: log.New(io.Discard, "", 0),
+		Paths:  Paths{RootFS: root, ProcFS: filepath.Join(root, "proc"), SysFS: filepath.Join(root, "sys")},
+	}
+	e := NewExporter(config, &exporter.Status{}).(*promExporter)
+	defer e.Close()
+	assert.Equal(t, filepath.Join(root, "proc"), os.Getenv("HOST_PROC"))
+	assert.Equal(t, filepath.Join(root, "dev"), os.Getenv("HOST_DEV"))
+
+	e.readEnvironment()
+	assert.Equal(t, []string{"eth0"}, e.ifaces)
+	assert.Equal(t, []string{"sda"}, e.devices)
+
+	values := func(metrics []metric, err error) map[string]float64 {
+		require.NoError(t, err)
+		v := map[string]float64{}
+		for _, m := range metrics {
+			v[e.getMetricFullName(m)] = m.value
+		}
+		return v
+	}
+	node := e.Hostname()
+	assert.Equal(t, map[string]float64{
+		`node_network_receive_bytes_total{node="` + node + `",device="eth0"}`:  1000,
+		`node_network_transmit_bytes_total{node="` + node + `",device="eth0"}`: 2000,
+	}, values(e.getNetworkStatsMetrics()))
+	assert.Equal(t, map[string]float64{
+		`node_md_disks{node="` + node + `",device="md1"}`:          2,
+		`node_md_disks_degraded{node="` + node + `",device="md1"}`: 1,
+	}, values(e.getMdArrayMetrics()))
+
+	e.kernelVersion = 4
+	assert.Equal(t, map[string]float64{
+		`node_flashcache_reads{node="` + node + `"}`:  10,
+		`node_flashcache_writes{node="` + node + `"}`: 20,
+	}, values(e.getFlashCacheStatsMetrics()))
+}
+
+func TestRefreshEnvironmentOnHotplug(t *testing.T) {
+	for _, env := range []string{"HOST_ROOT", "HOST_PROC", "HOST_SYS", "HOST_DEV"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("HOSTNAME", "nas")
+	root := t.TempDir()
+	writeFixture := func(name string) {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, nil, 0o644))
+	}
+	writeFixture("dev/sda")
+	writeFixture("sys/class/net/eth0/operstate")
+
+	var logs bytes.Buffer
+	config := ExporterConfig{
+		Logger: log.New(&logs, "", 0),
+		Paths:  Paths{RootFS: root, ProcFS: filepath.Join(root, "proc"), SysFS: filepath.Join(root, "sys")},
+	}
+	e := NewExporter(config, nil).(*promExporter)
+	defer e.Close()
+	e.refreshEnvironment()
+	require.Equal(t, []string{"sda"}, e.devices)
+	expiry := e.envExpiry
+
+	e.refreshEnvironment()
+	writeFixture("dev/sda1")
+	writeFixture("sys/class/net/veth1a2b3c/operstate")
+	e.refreshEnvironment()
+	assert.Equal(t, 1, bytes.Count(logs.Bytes(), []byte("Environment changed: ")), "partitions and ephemeral interfaces don't trigger a refresh")
+
+	writeFixture("dev/sdb")
+	e.refreshEnvironment()
+	assert.Equal(t, []string{"sda", "sdb"}, e.devices)
+	assert.True(t, e.envExpiry.After(expiry), "the next refresh is scheduled from the early one")
+
+	require.NoError(t, os.Remove(filepath.Join(root, "dev", "sda")))
+	writeFixture("sys/class/net/eth1/operstate")
+	e.refreshEnvironment()
+	assert.Equal(t, []string{"sdb"}, e.devices)
+	assert.Equal(t, []string{"eth0", "eth1"}, e.ifaces)
+	assert.Equal(t, 3, bytes.Count(logs.Bytes(), []byte("Environment changed: ")))
+}
+
+func BenchmarkEnvironmentChanged(b *testing.B) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, nil).(*promExporter)
+	defer e.Close()
+	e.envEntries = e.readEnvEntries()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = e.environmentChanged()
+	}
+}
+
+func TestDefaultPaths(t *testing.T) {
+	t.Setenv("HOST_PROC", "")
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, nil).(*promExporter)
+	defer e.Close()
+
+	assert.Equal(t, Paths{RootFS: "/", ProcFS: "/proc", SysFS: "/sys"}, e.Paths)
+	assert.Equal(t, "/sys/class/net", e.Paths.sysPath(netDir))
+	assert.Equal(t, "/dev", e.Paths.rootPath(devDir))
+	assert.Equal(t, "/proc/flashcache/CG0/flashcache_stats", e.Paths.procPath(flashcacheStatsPath))
+	assert.Equal(t, "/sys/block/dm-1/dm/cache/curr_stats", e.Paths.sysPath(fmt.Sprintf(dmCacheStatsFilePathFormat, "dm-1")))
+	assert.Empty(t, os.Getenv("HOST_PROC"))
+}
+
+func TestLogEnvironmentChanges(t *testing.T) {
+	var logs bytes.Buffer
+	e := &promExporter{ExporterConfig: ExporterConfig{Logger: log.New(&logs, "", 0)}}
+	snapshot := envSnapshot{
+		hostname: "nas",
+		devices:  []string{"sda"},
+		ifaces:   []string{"eth0"},
+		tools:    map[string]string{"getsysinfo": "/sbin/getsysinfo", "hal_app": ""},
+	}
+
+	e.logEnvironment(snapshot)
+	assert.Equal(t, `Environment changed: hostname "" -> "nas", devices [] -> [sda], interfaces [] -> [eth0], found getsysinfo at /sbin/getsysinfo`+"\n", logs.String())
+
+	logs.Reset()
+	e.logEnvironment(envSnapshot{
+		hostname: "nas",
+		devices:  []string{"sda"},
+		ifaces:   []string{"eth0"},
+		volumes:  []string{},
+		tools:    map[string]string{"getsysinfo": "/sbin/getsysinfo", "hal_app": ""},
+	})
+	assert.Empty(t, logs.String(), "an unchanged environment is only logged at debug level")
+
+	logs.Reset()
+	e.logEnvironment(envSnapshot{
+		hostname: "nas2",
+		devices:  []string{"sda", "sdb"},
+		ifaces:   []string{"eth0"},
+		tools:    map[string]string{"getsysinfo": "", "hal_app": "/sbin/hal_app"},
+	})
+	assert.Equal(t, `Environment changed: hostname "nas" -> "nas2", devices [sda] -> [sda sdb], lost getsysinfo, found hal_app at /sbin/hal_app`+"\n", logs.String())
+}
+
+func TestReadEnvironmentOnStartup(t *testing.T) {
+	t.Setenv("HOSTNAME", "nas")
+	var logs bytes.Buffer
+	ready := make(chan struct{})
+	config := ExporterConfig{
+		Logger:                   log.New(&logs, "", 0),
+		OnReady:                  func() { close(ready) },
+		ReadEnvironmentOnStartup: true,
+	}
+	var s exporter.Status
+	e := NewExporter(config, &s)
+	defer e.Close()
+
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the environment wasn't read on startup")
+	}
+	assert.NoError(t, e.(exporter.ReadinessReporter).Ready())
+	assert.Equal(t, "nas", s.Hostname)
+	assert.False(t, s.EnvironmentRead.IsZero())
+	assert.Empty(t, s.EnvironmentError)
+
+	read := s.EnvironmentRead
+	_ = e.WriteMetrics(io.Discard)
+	assert.Equal(t, read, s.EnvironmentRead, "the first scrape doesn't read the environment again")
+}
+
+func TestReadEnvironmentFailure(t *testing.T) {
+	t.Setenv("HOSTNAME", "nas")
+	getsysinfo := filepath.Join(t.TempDir(), "getsysinfo")
+	require.NoError(t, os.WriteFile(getsysinfo, []byte("#!/bin/sh\necho 'not supported' >&2\nexit 1\n"), 0o755))
+
+	var s exporter.Status
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, &s).(*promExporter)
+	defer e.Close()
+	assert.EqualError(t, e.Ready(), "the environment hasn't been read yet")
+
+	e.getsysinfo = getsysinfo
+	_ = e.WriteMetrics(io.Discard)
+
+	err := e.Ready()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "get disk count: "+getsysinfo+" exited with status 1: not supported")
+	assert.Equal(t, err.Error(), s.EnvironmentError)
+	assert.True(t, e.envExpiry.After(time.Now()), "the read is retried on the normal schedule")
+}
+
+func TestReadEnvironmentGetsysinfoFlap(t *testing.T) {
+	t.Setenv("HOSTNAME", "nas")
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	getsysinfo := filepath.Join(bin, "getsysinfo")
+	writeGetsysinfo := func(hdnum int) {
+		script := fmt.Sprintf("#!/bin/sh\ncase $1 in\nhdnum) echo %d;;\nsysfannum) echo 1;;\n*) echo 0;;\nesac\n", hdnum)
+		require.NoError(t, os.WriteFile(getsysinfo, []byte(script), 0o755))
+	}
+	writeGetsysinfo(2)
+
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, nil).(*promExporter)
+	defer e.Close()
+	_ = e.readEnvironment()
+	require.Equal(t, getsysinfo, e.getsysinfo)
+	assert.Equal(t, 2, e.syshdnum)
+	assert.Equal(t, 1, e.sysfannum)
+
+	require.NoError(t, os.Remove(getsysinfo))
+	e.unsupportedSubcommands = map[string]bool{"vol_temp": true}
+	_ = e.readEnvironment()
+	assert.Empty(t, e.getsysinfo)
+	assert.Zero(t, e.syshdnum)
+	assert.Zero(t, e.sysfannum)
+	assert.Empty(t, e.unsupportedSubcommands)
+	for _, fetch := range []func() ([]metric, error){e.getSysInfoTempMetrics, e.getSysInfoFanMetrics, e.getSysInfoVolMetrics} {
+		metrics, err := fetch()
+		assert.NoError(t, err)
+		assert.Empty(t, metrics)
+	}
+
+	writeGetsysinfo(4)
+	_ = e.readEnvironment()
+	assert.Equal(t, getsysinfo, e.getsysinfo)
+	assert.Equal(t, 4, e.syshdnum, "the disks are counted again")
+	assert.Equal(t, 1, e.sysfannum)
+}
+
+// fakeGetsysinfo returns a command runner answering the disk queries of getsysinfo after latency,
+// and the maximum number of queries which ran at once
+func fakeGetsysinfo(latency time.Duration, temps map[string]string) (func(ctx context.Context, cmd string, args ...string) (string, error), func() int) {
+	var mu sync.Mutex
+	var running, maxRunning int
+	run := func(ctx context.Context, cmd string, args ...string) (string, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		time.Sleep(latency)
+
+		switch args[0] {
+		case "hdtmp":
+			if temp, ok := temps[args[1]]; ok {
+				return temp, nil
+			}
+			return "", errors.New("exit status 1")
+		case "hdsmart":
+			return "GOOD", nil
+		}
+		return "", fmt.Errorf("unexpected command %s %v", cmd, args)
+	}
+
+	return run, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return maxRunning
+	}
+}
+
+func TestGetDmCacheStatsMetrics(t *testing.T) {
+	var s exporter.Status
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, &s).(*promExporter)
+	defer e.Close()
+	e.dmCacheClients = []string{"CG0", "CG1"}
+	var commands []string
+	e.runCommand = func(ctx context.Context, cmd string, args ...string) (string, error) {
+		commands = append(commands, strings.Join(append([]string{cmd}, args...), " "))
+		return "0 2097152 cache 16/32 rest\r\n0 2097152 cache 8/64 rest", nil
+	}
+
+	metrics, err := e.getDmCacheStatsMetrics()
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"dmsetup status --noflush CG0 CG1"}, commands)
+	values := map[string]float64{}
+	for _, m := range metrics {
+		if m.attr != "" {
+			values[m.name+"{"+m.attr+"}"] = m.value
+			assert.Equal(t, "gauge", m.metricType, m.name)
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		`node_dmcache_used_bytes_total{device="CG0"}`: 16 * 1024 * 1024,
+		`node_dmcache_bytes_total{device="CG0"}`:      32 * 1024 * 1024,
+		`node_dmcache_used_bytes_total{device="CG1"}`: 8 * 1024 * 1024,
+		`node_dmcache_bytes_total{device="CG1"}`:      64 * 1024 * 1024,
+	}, values)
+}
+
+func TestGetSysInfoHdMetricsConcurrency(t *testing.T) {
+	temps := map[string]string{"1": "35 C/95 F", "2": "--", "4": "40 C/104 F", "5": "hot", "6": "38,5 C/101 F", "7": "--"}
+	var s exporter.Status
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), GetsysinfoConcurrency: 2}, &s).(*promExporter)
+	defer e.Close()
+	var maxRunning func() int
+	e.runCommand, maxRunning = fakeGetsysinfo(10*time.Millisecond, temps)
+	e.getsysinfo = "getsysinfo"
+	e.syshdnum = 7
+
+	metrics, err := e.getSysInfoHdMetrics()
+
+	assert.EqualError(t, err, `disk 3: exit status 1; disk 5: parse temperature "hot"`)
+	var attrs []string
+	for _, m := range metrics {
+		attrs = append(attrs, m.attr)
+	}
+	assert.Equal(t, []string{`hd="1",smart="GOOD"`, `hd="4",smart="GOOD"`, `hd="6",smart="GOOD"`}, attrs, "the disks are reported in slot order")
+	assert.Equal(t, 38.5, metrics[2].value, "the decimal comma of a localized getsysinfo is accepted")
+	assert.Equal(t, []string{"1", "4", "6"}, s.Disks)
+	assert.Equal(t, 6, e.syshdnum, "the trailing empty slot isn't queried anymore")
+	assert.Equal(t, 2, maxRunning())
+}
+
+func benchmarkGetSysInfoHdMetrics(b *testing.B, concurrency int) {
+	temps := map[string]string{}
+	for hdnum := 1; hdnum <= 16; hdnum++ {
+		temps[fmt.Sprint(hdnum)] = "35 C/95 F"
+	}
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), GetsysinfoConcurrency: concurrency}, nil).(*promExporter)
+	defer e.Close()
+	// Typical latency of getsysinfo on a busy NAS
+	e.runCommand, _ = fakeGetsysinfo(50*time.Millisecond, temps)
+	e.getsysinfo = "getsysinfo"
+
+	for i := 0; i < b.N; i++ {
+		e.syshdnum = 16
+		if _, err := e.getSysInfoHdMetrics(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetSysInfoHdMetricsSerial(b *testing.B) {
+	benchmarkGetSysInfoHdMetrics(b, 1)
+}
+
+func BenchmarkGetSysInfoHdMetricsConcurrent(b *testing.B) {
+	benchmarkGetSysInfoHdMetrics(b, DefaultGetsysinfoConcurrency)
+}
+
+func TestNameFilterWriter(t *testing.T) {
+	filter, err := ParseNameFilter([]string{"node_hdtmp_C", "node_disk_.*"})
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	fw := filter.Writer(&b)
+	// Lines may be split across writes
+	for _, chunk := range []string{
+		"# HELP node_hdtmp_C Disk temperature\n# TYPE node_hdtmp_C gauge\nnode_hdtmp_C{node=\"nas\",disk=\"1\"} 35 \n",
+		"# HELP node_hdtmp_C_max Hottest disk\nnode_hdtmp_C_max{node=\"nas\"} 35 \n",
+		"## retrieve ups metrics: connection refused\n",
+		"node_disk_io_now{node=\"nas\",device=\"sda\"} 1 \nnode_lo",
+		"ad1{node=\"nas\"} 0.5 \nnode_disk_io_now{node=\"nas\",device=\"sdb\"} 2",
+	} {
+		n, err := fw.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	require.NoError(t, fw.Close())
+
+	assert.Equal(t, `# HELP node_hdtmp_C Disk temperature
+# TYPE node_hdtmp_C gauge
+node_hdtmp_C{node="nas",disk="1"} 35 
+## retrieve ups metrics: connection refused
+node_disk_io_now{node="nas",device="sda"} 1 
+node_disk_io_now{node="nas",device="sdb"} 2`, b.String())
+
+	_, err = ParseNameFilter([]string{"node_(hdtmp"})
+	assert.ErrorContains(t, err, `invalid metric name pattern "node_(hdtmp"`)
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := map[string]struct {
+		value float64
+		want  string
+	}{
+		"large counter":    {value: 4123456789012, want: "4123456789012"},
+		"largest exact":    {value: 1 << 53, want: "9007199254740992"},
+		"beyond exact":     {value: 1 << 60, want: "1.152921504606847e+18"},
+		"negative integer": {value: -42, want: "-42"},
+		"zero":             {value: 0, want: "0"},
+		"fraction":         {value: 0.25, want: "0.25"},
+		"small fraction":   {value: 1e-9, want: "1e-09"},
+		"NaN":              {value: math.NaN(), want: "NaN"},
+		"+Inf":             {value: math.Inf(1), want: "+Inf"},
+		"-Inf":             {value: math.Inf(-1), want: "-Inf"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatValue(tt.value))
+		})
+	}
+}
+
+func TestWriteMetricsLargeCounter(t *testing.T) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, nil).(*promExporter)
+	defer e.Close()
+	var b bytes.Buffer
+
+	e.writeMetrics(&b, []metric{{name: "node_network_receive_bytes_total", attr: `device="eth0"`, value: 4123456789012}}, map[string]bool{})
+
+	assert.Contains(t, b.String(), `node_network_receive_bytes_total{node="`+e.Hostname()+`",device="eth0"} 4123456789012 `)
+}
+
+func TestWriteMetricsMetadata(t *testing.T) {
+	for _, env := range []string{"HOST_ROOT", "HOST_PROC", "HOST_SYS", "HOST_DEV"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("HOSTNAME", "nas")
+	root := t.TempDir()
+	writeFixture := func(name, contents string) {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+	writeFixture("dev/sda", "")
+	writeFixture("proc/uptime", "1000.00 2000.00\n")
+	writeFixture("var/run/utmp", "")
+	writeFixture("proc/loadavg", "0.50 0.40 0.30 1/100 1234\n")
+	writeFixture("proc/stat", "cpu  100 0 50 1000 10 0 5 0 0 0\ncpu0 100 0 50 1000 10 0 5 0 0 0\n")
+	writeFixture("proc/cpuinfo", "processor\t: 0\nphysical id\t: 0\ncore id\t\t: 0\ncpu cores\t: 1\n\n")
+	writeFixture("proc/meminfo", "MemTotal:        1000 kB\nMemFree:          500 kB\nMemAvailable:     700 kB\nCached:           100 kB\nSwapTotal:        200 kB\nSwapFree:         200 kB\n")
+	writeFixture("proc/diskstats", "   8       0 sda 10 0 80 5 20 0 160 10 0 15 15 0 0 0 0\n")
+	writeFixture("proc/flashcache/CG0/flashcache_stats", "reads: 10\nwrites: 20\nread_hit_percent: 50\n")
+	for _, iface := range []string{"eth0", "eth1"} {
+		writeFixture("sys/class/net/"+iface+"/statistics/rx_bytes", "1000\n")
+		writeFixture("sys/class/net/"+iface+"/statistics/tx_bytes", "2000\n")
+	}
+	writeFixture("sys/block/sda/stat", "      10        0       80        5       20        0      160       10        0       15       15\n")
+	writeFixture("sys/block/md1/md/degraded", "0\n")
+	writeFixture("sys/block/md1/md/raid_disks", "2\n")
+
+	answers := map[string]string{
+		"uname -r":                   "4.14.24-qnap",
+		"getsysinfo cputmp":          "45 C/113 F",
+		"getsysinfo systmp":          "35 C/95 F",
+		"getsysinfo hdnum":           "2",
+		"getsysinfo sysfannum":       "1",
+		"getsysinfo sysfan 1":        "900 RPM",
+		"getsysinfo hdtmp 1":         "35 C/95 F",
+		"getsysinfo hdtmp 2":         "36 C/96 F",
+		"getsysinfo hdsmart 1":       "GOOD",
+		"getsysinfo hdsmart 2":       "GOOD",
+		"getsysinfo sysvolnum":       "1",
+		"getsysinfo vol_desc 0":      "[Volume DataVol1, Pool 1]",
+		"getsysinfo vol_fs 0":        "ext4",
+		"getsysinfo vol_totalsize 0": "10.00 TB",
+		"getsysinfo vol_freesize 0":  "4.00 TB",
+		"getsysinfo vol_status 0":    "Ready",
+		"hal_app --se_enum":          "enc_id enc_sys_id qm2_1 x QM2-1 x x 2 1 x 1",
+		"hal_app --se_sys_get_fan enc_sys_id=qm2_1,obj_index=0": "fan = 1200 rpm",
+	}
+	config := ExporterConfig{
+		Logger:            log.New(io.Discard, "", 0),
+		Paths:             Paths{RootFS: root, ProcFS: filepath.Join(root, "proc"), SysFS: filepath.Join(root, "sys")},
+		NotificationStats: func() exporter.NotificationStats { return exporter.NotificationStats{} },
+	}
+	e := NewExporter(config, &exporter.Status{}).(*promExporter)
+	defer e.Close()
+	e.runCommand = func(ctx context.Context, cmd string, args ...string) (string, error) {
+		command := strings.Join(append([]string{filepath.Base(cmd)}, args...), " ")
+		if answer, ok := answers[command]; ok {
+			return answer, nil
+		}
+		return "", fmt.Errorf("unexpected command %q", command)
+	}
+	// The environment refresh checks that getsysinfo still exists
+	writeFixture("sbin/getsysinfo", "")
+	e.getsysinfo, e.hal_app = filepath.Join(root, "sbin", "getsysinfo"), "hal_app"
+	var b bytes.Buffer
+
+	// Only the UPS collector fails, without upsd
+	err := e.WriteMetrics(&b)
+	assert.ErrorContains(t, err, "retrieve ups metrics")
+
+	sampleRe := regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)[{ ]`)
+	helps, types := map[string]int{}, map[string]int{}
+	samples := map[string]bool{}
+	for _, line := range strings.Split(b.String(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			helps[strings.Fields(line)[2]]++
+		case strings.HasPrefix(line, "# TYPE "):
+			family := strings.Fields(line)[2]
+			types[family]++
+			assert.Contains(t, []string{"counter", "gauge"}, strings.Fields(line)[3], line)
+			assert.False(t, samples[family], "the TYPE of %s precedes its samples", family)
+		case line == "", strings.HasPrefix(line, "#"):
+		default:
+			m := sampleRe.FindStringSubmatch(line)
+			require.NotNil(t, m, line)
+			samples[m[1]] = true
+		}
+	}
+
+	for _, family := range []string{"node_load1", "node_cputmp_C", "node_sysfan_RPM", "node_hdtmp_C", "node_flashcache_reads", "node_volume_avail_bytes", "node_md_disks", "node_disk_io_now", "node_logged_in_users"} {
+		assert.True(t, samples[family], "%s is scraped", family)
+	}
+	for family := range samples {
+		assert.Equal(t, 1, types[family], "%s has a single TYPE", family)
+		assert.Equal(t, 1, helps[family], "%s has a single HELP", family)
+	}
+	assert.Contains(t, b.String(), "# TYPE node_flashcache_reads counter\n")
+	assert.Contains(t, b.String(), "# TYPE node_flashcache_read_hit_percent gauge\n")
+}
+
+func TestParseInterfaceClasses(t *testing.T) {
+	classes, err := ParseInterfaceClasses(" physical, Bridges,virtual-ephemeral,")
+	require.NoError(t, err)
+	assert.Equal(t, []string{InterfaceClassPhysical, InterfaceClassBridges, InterfaceClassEphemeral}, classes)
+
+	_, err = ParseInterfaceClasses("physical,wifi")
+	assert.EqualError(t, err, `unknown interface class "wifi" (expected one of physical, loopback, bridges, virtual-ephemeral)`)
+}
+
+func TestNetworkInterfaceClasses(t *testing.T) {
+	for _, env := range []string{"HOST_ROOT", "HOST_PROC", "HOST_SYS", "HOST_DEV"} {
+		t.Setenv(env, "")
+	}
+	sysFS := t.TempDir()
+	writeStats := func(iface string, rx, tx int) {
+		dir := filepath.Join(sysFS, netDir, iface, "statistics")
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "rx_bytes"), []byte(fmt.Sprintf("%d\n", rx)), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "tx_bytes"), []byte(fmt.Sprintf("%d\n", tx)), 0o644))
+	}
+	writeStats("eth0", 1000, 2000)
+	writeStats("lo", 10, 10)
+	writeStats("docker0", 300, 400)
+	require.NoError(t, os.MkdirAll(filepath.Join(sysFS, netDir, "docker0", "bridge"), 0o755))
+	writeStats("veth1", 100, 200)
+	writeStats("veth2", 50, 60)
+	writeStats("tun0", 1, 1)
+
+	values := func(e *promExporter) map[string]float64 {
+		metrics, err := e.getNetworkStatsMetrics()
+		require.NoError(t, err)
+		v := map[string]float64{}
+		for _, m := range metrics {
+			v[m.name+"{"+m.attr+"}"] = m.value
+		}
+		return v
+	}
+	newExporter := func(network NetworkConfig) *promExporter {
+		e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), Paths: Paths{SysFS: sysFS}, Network: network}, nil).(*promExporter)
+		t.Cleanup(e.Close)
+		e.ifaces = e.listInterfaces(false)
+		return e
+	}
+
+	e := newExporter(NetworkConfig{})
+	assert.Equal(t, []string{"eth0"}, e.ifaces)
+
+	e = newExporter(NetworkConfig{Classes: []string{InterfaceClassPhysical, InterfaceClassLoopback, InterfaceClassBridges, InterfaceClassEphemeral}})
+	assert.Equal(t, []string{"docker0", "eth0", "lo"}, e.ifaces, "ephemeral interfaces are listed on every scrape")
+	v := values(e)
+	assert.Equal(t, float64(100), v[`node_network_receive_bytes_total{device="veth1"}`])
+	assert.Equal(t, float64(60), v[`node_network_transmit_bytes_total{device="veth2"}`])
+	assert.NotContains(t, v, `node_network_receive_bytes_total{device="tun0"}`)
+
+	e = newExporter(NetworkConfig{Classes: []string{InterfaceClassEphemeral}, AggregateEphemeral: true})
+	assert.Empty(t, e.ifaces)
+	assert.Equal(t, map[string]float64{
+		`node_network_receive_bytes_total{device="veth_total"}`:  150,
+		`node_network_transmit_bytes_total{device="veth_total"}`: 260,
+	}, values(e))
+
+	// veth2 goes away and veth3 appears: the total carries over the last values of veth2
+	require.NoError(t, os.RemoveAll(filepath.Join(sysFS, netDir, "veth2")))
+	writeStats("veth1", 110, 210)
+	writeStats("veth3", 5, 5)
+	assert.Equal(t, map[string]float64{
+		`node_network_receive_bytes_total{device="veth_total"}`:  165,
+		`node_network_transmit_bytes_total{device="veth_total"}`: 275,
+	}, values(e))
+}
+
+func TestNetworkAddressMetrics(t *testing.T) {
+	addrs := map[string][]net.Addr{
+		"eth0": {
+			&net.IPNet{IP: net.ParseIP("192.168.1.10").To4(), Mask: net.CIDRMask(24, 32)},
+			&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+			&net.IPNet{IP: net.ParseIP("2001:db8::10"), Mask: net.CIDRMask(64, 128)},
+		},
+		// Lost its DHCP lease
+		"eth1": {
+			&net.IPNet{IP: net.ParseIP("169.254.3.4").To4(), Mask: net.CIDRMask(16, 32)},
+			&net.IPNet{IP: net.ParseIP("fe80::2"), Mask: net.CIDRMask(64, 128)},
+		},
+		"lo": {&net.IPNet{IP: net.ParseIP("127.0.0.1").To4(), Mask: net.CIDRMask(8, 32)}},
+	}
+	values := func(network NetworkConfig) map[string]float64 {
+		e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), Network: network}, nil).(*promExporter)
+		e.ifaces = []string{"eth0", "eth1", "eth2", "lo"}
+		e.interfaceAddrs = func(iface string) ([]net.Addr, error) {
+			a, ok := addrs[iface]
+			if !ok {
+				return nil, errors.New("no such network interface")
+			}
+			return a, nil
+		}
+
+		metrics, err := e.getNetworkAddressMetrics()
+		require.NoError(t, err)
+		v := map[string]float64{}
+		for _, m := range metrics {
+			v[m.name+"{"+m.attr+"}"] = m.value
+		}
+		return v
+	}
+
+	assigned := map[string]float64{
+		`node_network_address_assigned{device="eth0",family="ipv4"}`: 1,
+		`node_network_address_assigned{device="eth0",family="ipv6"}`: 1,
+		`node_network_address_assigned{device="eth1",family="ipv4"}`: 0,
+		`node_network_address_assigned{device="eth1",family="ipv6"}`: 0,
+	}
+	assert.Equal(t, assigned, values(NetworkConfig{}))
+
+	assigned[`node_network_address_info{device="eth0",family="ipv4",address="192.168.1.10/24"}`] = 1
+	assigned[`node_network_address_info{device="eth0",family="ipv6",address="2001:db8::10/64"}`] = 1
+	assert.Equal(t, assigned, values(NetworkConfig{AddressInfo: true}))
+}
+
+func TestNetworkStatsSnapshot(t *testing.T) {
+	for _, env := range []string{"HOST_ROOT", "HOST_PROC", "HOST_SYS", "HOST_DEV"} {
+		t.Setenv(env, "")
+	}
+	sysFS, procFS := t.TempDir(), t.TempDir()
+	// The sysfs counters lag behind, as if they had been read earlier
+	for _, iface := range []string{"eth0", "veth1"} {
+		dir := filepath.Join(sysFS, netDir, iface, "statistics")
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "rx_bytes"), []byte("1\n"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "tx_bytes"), []byte("1\n"), 0o644))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(procFS, "net"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(procFS, netDevPath), []byte(`Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     100       1    0    0    0     0          0         0      100       1    0    0    0     0       0          0
+  eth0:123456789012  90    0    0    0     0          0         0 987654321      80    0    0    0     0       0          0
+ veth1:    4000      30    0    0    0     0          0         0     5000      40    0    0    0     0       0          0
+`), 0o644))
+
+	config := ExporterConfig{
+		Logger:  log.New(io.Discard, "", 0),
+		Paths:   Paths{SysFS: sysFS, ProcFS: procFS},
+		Network: NetworkConfig{Classes: []string{InterfaceClassPhysical, InterfaceClassEphemeral}, Snapshot: true},
+	}
+	e := NewExporter(config, nil).(*promExporter)
+	defer e.Close()
+	e.ifaces = e.listInterfaces(false)
+
+	before := time.Now()
+	metrics, err := e.getNetworkStatsMetrics()
+	require.NoError(t, err)
+
+	// Both directions of an interface come from the same row, and carry the time of the read
+	v := map[string]float64{}
+	for _, m := range metrics {
+		v[m.name+"{"+m.attr+"}"] = m.value
+		assert.Equal(t, metrics[0].timestamp, m.timestamp)
+	}
+	assert.False(t, metrics[0].timestamp.Before(before))
+	assert.Equal(t, map[string]float64{
+		`node_network_receive_bytes_total{device="eth0"}`:   123456789012,
+		`node_network_transmit_bytes_total{device="eth0"}`:  987654321,
+		`node_network_receive_bytes_total{device="veth1"}`:  4000,
+		`node_network_transmit_bytes_total{device="veth1"}`: 5000,
+	}, v)
+
+	e.ifaces = append(e.ifaces, "eth1")
+	_, err = e.getNetworkStatsMetrics()
+	assert.EqualError(t, err, "interface eth1 not found in net/dev")
+}
+
+func TestEphemeralCountersRecreatedInterface(t *testing.T) {
+	var c ephemeralCounters
+
+	assert.Equal(t, float64(100), c.sum("rx", map[string]float64{"veth1": 100}))
+	// veth1 was recreated, and restarted from zero
+	assert.Equal(t, float64(110), c.sum("rx", map[string]float64{"veth1": 10}))
+	assert.Equal(t, float64(120), c.sum("rx", map[string]float64{"veth1": 20}))
+	assert.Equal(t, float64(5), c.sum("tx", map[string]float64{"veth1": 5}), "directions are summed separately")
+	assert.Equal(t, float64(120), c.sum("rx", map[string]float64{}))
+}
+
+func TestEthtoolMetrics(t *testing.T) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), EthtoolStats: true}, nil).(*promExporter)
+	defer e.Close()
+	e.ethtool = "ethtool"
+	e.ifaces = []string{"eth0", "eth1", "eth2"}
+	e.runCommand = func(ctx context.Context, cmd string, args ...string) (string, error) {
+		switch args[1] {
+		case "eth0":
+			return "NIC statistics:\n     rx_packets: 1000\n     rx_missed_errors: 12\n     rx_no_buffer_count: 3\n" +
+				"     tx_timeout_count: 0\n     rx_queue_0_drops: 4\n     rx_queue_1_drops: 5\n     os2bmc_rx_by_bmc: 7", nil
+		case "eth1":
+			return "", &utils.CommandError{Command: cmd, ExitCode: 94, Stderr: "no stats available"}
+		}
+		return "", errors.New("exit status 1")
+	}
+
+	metrics, err := e.getEthtoolMetrics()
+
+	assert.EqualError(t, err, "eth2: exit status 1")
+	values := map[string]float64{}
+	for _, m := range metrics {
+		values[m.name+"{"+m.attr+"}"] = m.value
+		assert.Equal(t, "counter", m.metricType)
+	}
+	assert.Equal(t, map[string]float64{
+		`node_ethtool_rx_missed_errors_total{device="eth0"}`:         12,
+		`node_ethtool_rx_no_buffer_count_total{device="eth0"}`:       3,
+		`node_ethtool_tx_timeout_count_total{device="eth0"}`:         0,
+		`node_ethtool_rx_queue_drops_total{device="eth0",queue="0"}`: 4,
+		`node_ethtool_rx_queue_drops_total{device="eth0",queue="1"}`: 5,
+	}, values)
+}
+
+func TestDiskErrorMetrics(t *testing.T) {
+	sysFS := t.TempDir()
+	writeFixture := func(name, contents string) {
+		path := filepath.Join(sysFS, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+	sdaDevice := "devices/pci0000:00/0000:00:17.0/ata3/host2/target2:0:0/2:0:0:0"
+	writeFixture(sdaDevice+"/ioerr_cnt", "0x1a\n")
+	writeFixture(sdaDevice+"/iorequest_cnt", "0x2710\n")
+	writeFixture(sdaDevice+"/iodone_cnt", "0x270f\n")
+	writeFixture("class/ata_device/dev3.0/ering", "[   12.345678901]ATA_BUS [   13.000000000]TIMEOUT\n")
+	writeFixture("devices/virtual/usb/sdb/ioerr_cnt", "0x0\n")
+	writeFixture("block/nvme0n1/device/model", "Samsung SSD 970 EVO Plus 1TB\n")
+	require.NoError(t, os.MkdirAll(filepath.Join(sysFS, "block", "sda"), 0o755))
+	require.NoError(t, os.Symlink(filepath.Join(sysFS, sdaDevice), filepath.Join(sysFS, "block", "sda", "device")))
+	require.NoError(t, os.MkdirAll(filepath.Join(sysFS, "block", "sdb"), 0o755))
+	require.NoError(t, os.Symlink(filepath.Join(sysFS, "devices/virtual/usb/sdb"), filepath.Join(sysFS, "block", "sdb", "device")))
+
+	e := &promExporter{ExporterConfig: ExporterConfig{Paths: Paths{SysFS: sysFS}}, devices: []string{"sda", "sdb", "nvme0n1"}}
+
+	metrics, err := e.getDiskErrorMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, []metric{
+		{name: "node_disk_scsi_ioerr_total", attr: `device="sda"`, value: 26, help: "Total number of SCSI commands completed with an error", metricType: "counter"},
+		{name: "node_disk_scsi_iorequest_total", attr: `device="sda"`, value: 10000, help: "Total number of SCSI commands issued", metricType: "counter"},
+		{name: "node_disk_scsi_iodone_total", attr: `device="sda"`, value: 9999, help: "Total number of SCSI commands completed", metricType: "counter"},
+		{name: "node_disk_ata_errors", attr: `device="sda",port="ata3"`, value: 2, help: "Number of errors in the error ring of the ATA device, which keeps the last 32", metricType: "gauge"},
+		{name: "node_disk_scsi_ioerr_total", attr: `device="sdb"`, value: 0, help: "Total number of SCSI commands completed with an error", metricType: "counter"},
+	}, metrics, "the missing attributes are skipped")
+
+	writeFixture(sdaDevice+"/iodone_cnt", "unknown\n")
+	metrics, err = e.getDiskErrorMetrics()
+	assert.ErrorContains(t, err, "sda: parse ")
+	assert.Len(t, metrics, 4, "the other counters are still reported")
+}
+
+func TestParseBlockStat(t *testing.T) {
+	tests := map[string]struct {
+		contents string
+		want     blockStat
+		wantErr  string
+	}{
+		"kernel 4": {
+			contents: "   18508     2974  1154789   215260    20561    29534  1312432   612774        1   132637   828034\n",
+			want:     blockStat{readTicks: 215260, writeTicks: 612774, inFlight: 1, timeInQueue: 828034},
+		},
+		"kernel 5 with discard and flush fields": {
+			contents: "   18508     2974  1154789   215260    20561    29534  1312432   612774        2   132637   828034      120        0   262144       35     1402     1055\n",
+			want:     blockStat{readTicks: 215260, writeTicks: 612774, inFlight: 2, timeInQueue: 828034},
+		},
+		"truncated": {contents: "18508 2974 1154789 215260", wantErr: "expected at least 11 fields, found 4"},
+		"invalid":   {contents: "18508 2974 1154789 215260 20561 29534 1312432 x 0 132637 828034", wantErr: "parse field 8"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseBlockStat(tt.contents)
+
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDiskLatencyMetrics(t *testing.T) {
+	sysFS := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(sysFS, "block", "sda"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(sysFS, "block", "sda", "stat"), []byte("1 0 8 1500 2 0 16 250 3 1000 4000\n"), 0o644))
+	e := &promExporter{ExporterConfig: ExporterConfig{Paths: Paths{SysFS: sysFS}}, devices: []string{"sda", "sdb"}}
+
+	metrics, err := e.getDiskLatencyMetrics()
+	assert.ErrorContains(t, err, "sdb: ")
+	values := map[string]float64{}
+	for _, m := range metrics {
+		assert.Equal(t, `device="sda"`, m.attr)
+		values[m.name] = m.value
+	}
+	assert.Equal(t, map[string]float64{
+		"node_disk_read_time_seconds_total":        1.5,
+		"node_disk_write_time_seconds_total":       0.25,
+		"node_disk_io_now":                         3,
+		"node_disk_io_time_weighted_seconds_total": 4,
+	}, values)
+}
+
+func TestReadRemovableDiskIDs(t *testing.T) {
+	root := t.TempDir()
+	sysFS := filepath.Join(root, "sys")
+	writeFile := func(path, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	link := func(dir, name, dev string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0o755))
+		require.NoError(t, os.Symlink(filepath.Join("..", "..", dev), filepath.Join(root, dir, name)))
+	}
+	writeFile(filepath.Join(sysFS, "block", "sda", "removable"), "0")
+	writeFile(filepath.Join(sysFS, "block", "sdc", "removable"), "1")
+	writeFile(filepath.Join(sysFS, "devices", "pci0000:00", "usb2", "2-1", "block", "sdd", "removable"), "0")
+	require.NoError(t, os.Symlink(filepath.Join(sysFS, "devices", "pci0000:00", "usb2", "2-1", "block", "sdd"), filepath.Join(sysFS, "block", "sdd")))
+	writeFile(filepath.Join(sysFS, "block", "sde", "removable"), "1")
+	link(diskByIDDir, "ata-WDC_WD40EFRX_WD-WCC4E1234567", "sda")
+	link(diskByIDDir, "wwn-0x50014ee2b1234567", "sdc")
+	link(diskByIDDir, "usb-WD_Elements_25A3_575833314435-0:0", "sdc")
+	link(diskByIDDir, "usb-WD_Elements_25A3_575833314435-0:0-part1", "sdc1")
+	link(diskByLabelDir, "Backup", "sdd1")
+
+	e := &promExporter{
+		ExporterConfig: ExporterConfig{Paths: Paths{RootFS: root, SysFS: sysFS}},
+		devices:        []string{"sda", "sdc", "sdd", "sde"},
+	}
+	e.diskIDs = e.readRemovableDiskIDs()
+
+	assert.Equal(t, map[string]string{"sdc": "usb-WD_Elements_25A3_575833314435-0:0", "sdd": "Backup"}, e.diskIDs)
+	assert.Equal(t, `device="sda"`, e.diskAttr("sda"))
+	assert.Equal(t, `device="sdc",id="usb-WD_Elements_25A3_575833314435-0:0"`, e.diskAttr("sdc"))
+}
+
+func TestGPUMetrics(t *testing.T) {
+	sysFS := t.TempDir()
+	writeFile := func(path, content string) {
+		path = filepath.Join(sysFS, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	writeFile("class/drm/card0/gt_act_freq_mhz", "350\n")
+	writeFile("class/drm/card0/gt_max_freq_mhz", "1150\n")
+	writeFile("class/drm/card0-HDMI-A-1/status", "connected\n")
+	writeFile("class/hwmon/hwmon0/name", "coretemp\n")
+	writeFile("class/hwmon/hwmon0/temp1_input", "45000\n")
+	writeFile("class/hwmon/hwmon3/name", "i915\n")
+	writeFile("class/hwmon/hwmon3/temp1_input", "52500\n")
+	e := &promExporter{ExporterConfig: ExporterConfig{Logger: log.New(io.Discard, "", 0), Paths: Paths{SysFS: sysFS}}}
+
+	metrics, err := e.getGPUMetrics()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, m := range metrics {
+		values[m.name+"{"+m.attr+"}"] = m.value
+	}
+	assert.Equal(t, map[string]float64{
+		`node_gpu_frequency_hertz{card="card0"}`:     350e6,
+		`node_gpu_frequency_max_hertz{card="card0"}`: 1150e6,
+		`node_gpu_temperature_celsius{card="card0"}`: 52.5,
+	}, values)
+	assert.True(t, e.checkGPU()[0].Found)
+
+	e.Paths.SysFS = t.TempDir()
+	metrics, err = e.getGPUMetrics()
+	assert.NoError(t, err, "models without a GPU report nothing")
+	assert.Empty(t, metrics)
+}
+
+func TestQuotaMetrics(t *testing.T) {
+	answers := map[string]string{
+		"repquota -a -u": `*** Report for user quotas on device /dev/mapper/cachedev1
+Block grace time: 7days; Inode grace time: 7days
+                        Block limits                File limits
+User            used    soft    hard  grace    used  soft  hard  grace
+----------------------------------------------------------------------
+admin     --  1048576       0       0            120     0     0
+alice     +-   500000  400000  600000  6days     10     0     0
+bob       --        0  100000       0              0     0     0
+#1001     --     2048    4096       0              2     0     0`,
+		"zfs list -H -o name -t filesystem":                   "zpool1\nzpool1/zfs18",
+		"zfs userspace -H -p -o name,used,quota zpool1":       "",
+		"zfs userspace -H -p -o name,used,quota zpool1/zfs18": "admin\t2147483648\tnone\ncarol\t1073741824\t5368709120",
+	}
+	var commands int
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), Quota: QuotaConfig{Enabled: true, TopUsers: 4}}, nil).(*promExporter)
+	defer e.Close()
+	e.runCommand = func(ctx context.Context, cmd string, args ...string) (string, error) {
+		commands++
+		command := strings.Join(append([]string{cmd}, args...), " ")
+		if answer, ok := answers[command]; ok {
+			return answer, nil
+		}
+		return "", fmt.Errorf("unexpected command %q", command)
+	}
+	e.repquota, e.zfs = "repquota", "zfs"
+
+	metrics, err := e.getQuotaMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, []metric{
+		{name: "node_quota_used_bytes", attr: `volume="zpool1/zfs18",user="admin"`, value: 2147483648, help: "Space used by the user on the volume", metricType: "gauge"},
+		{name: "node_quota_used_bytes", attr: `volume="/dev/mapper/cachedev1",user="admin"`, value: 1073741824, help: "Space used by the user on the volume", metricType: "gauge"},
+		{name: "node_quota_used_bytes", attr: `volume="zpool1/zfs18",user="carol"`, value: 1073741824, help: "Space used by the user on the volume", metricType: "gauge"},
+		{name: "node_quota_limit_bytes", attr: `volume="zpool1/zfs18",user="carol"`, value: 5368709120, help: "Quota of the user on the volume", metricType: "gauge"},
+		{name: "node_quota_used_bytes", attr: `volume="/dev/mapper/cachedev1",user="alice"`, value: 512000000, help: "Space used by the user on the volume", metricType: "gauge"},
+		{name: "node_quota_limit_bytes", attr: `volume="/dev/mapper/cachedev1",user="alice"`, value: 614400000, help: "Quota of the user on the volume", metricType: "gauge"},
+	}, metrics, "only the top users with a non-zero usage are reported")
+	assert.Equal(t, 4, commands)
+
+	// The quotas are cached
+	_, err = e.getQuotaMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 4, commands)
+}
+
+func TestReadQpkgs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qpkg.conf")
+	require.NoError(t, os.WriteFile(path, []byte(`[container-station]
+Name = container-station
+Class = null
+Status = complete
+Version = 2.6.3.445
+Enable = TRUE
+
+[HybridBackup]
+Name = HybridBackup
+Version = "v3.0.23-0307	beta"
+Enable = FALSE
+# Duplicate entry left behind by an interrupted update
+[HybridBackup]
+Name = HybridBackup
+Version = 3.0.22
+
+[QsyncServer]
+Status = complete
+Install_Path = /share/CACHEDEV1_DATA/.qpkg/QsyncServer
+`), 0o644))
+
+	qpkgs, err := readQpkgs(path)
+	require.NoError(t, err)
+	assert.Equal(t, []qpkgInfo{
+		{name: "container-station", version: "2.6.3.445", enabled: true},
+		{name: "HybridBackup", version: "v3.0.23-0307beta"},
+		{name: "QsyncServer", version: "unknown", installPath: "/share/CACHEDEV1_DATA/.qpkg/QsyncServer"},
+	}, qpkgs)
+
+	e := &promExporter{qpkgs: qpkgs}
+	metrics, err := e.getQpkgMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 3)
+	assert.Equal(t, `name="container-station",version="2.6.3.445",enabled="true"`, metrics[0].attr)
+	assert.Equal(t, `name="QsyncServer",version="unknown",enabled="false"`, metrics[2].attr)
+
+	_, err = readQpkgs(filepath.Join(t.TempDir(), "missing.conf"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestMalwareScanMetrics(t *testing.T) {
+	lastScan := time.Date(2020, 1, 1, 3, 0, 0, 0, time.Local)
+	testCases := map[string]struct {
+		file, contents  string
+		expectedMetrics map[string]float64
+		expectedErr     string
+	}{
+		"Malware Remover 5.x": {
+			file:     "status/last_scan.json",
+			contents: fmt.Sprintf(`{"last_scan": {"start_time": %d, "end_time": %d, "status": "completed", "threat_count": 2}}`, lastScan.Unix()-600, lastScan.Unix()),
+			expectedMetrics: map[string]float64{
+				"qnap_malware_scan_last_timestamp_seconds": float64(lastScan.Unix()),
+				"qnap_malware_scan_threats_found":          2,
+			},
+		},
+		"Malware Remover 4.x": {
+			file:     "conf/scan_status.conf",
+			contents: "[Scan]\nLast_Scan_Time = 2020/01/01 03:00:00\nLast_Scan_Result = 0\nInfected_Files = 0\n",
+			expectedMetrics: map[string]float64{
+				"qnap_malware_scan_last_timestamp_seconds": float64(lastScan.Unix()),
+				"qnap_malware_scan_threats_found":          0,
+			},
+		},
+		"never scanned": {
+			file:        "conf/scan_status.conf",
+			contents:    "[Scan]\nLast_Scan_Time = \n",
+			expectedErr: "no completed scan",
+		},
+		"no status file": {},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			root := t.TempDir()
+			installPath := "/share/CACHEDEV1_DATA/.qpkg/MalwareRemover"
+			if tc.file != "" {
+				path := filepath.Join(root, installPath, tc.file)
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				require.NoError(t, os.WriteFile(path, []byte(tc.contents), 0o644))
+			}
+			e := &promExporter{
+				ExporterConfig: ExporterConfig{Logger: log.New(io.Discard, "", 0), Paths: Paths{RootFS: root}},
+				qpkgs:          []qpkgInfo{{name: malwareRemoverQpkg, version: "5.0.1", enabled: true, installPath: installPath}},
+			}
+
+			metrics, err := e.getMalwareScanMetrics()
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			values := map[string]float64{}
+			for _, m := range metrics {
+				values[m.name] = m.value
+			}
+			if tc.expectedMetrics == nil {
+				assert.Empty(t, values)
+			} else {
+				assert.Equal(t, tc.expectedMetrics, values)
+			}
+		})
+	}
+}
+
+func TestMalwareScanMetricsNotInstalled(t *testing.T) {
+	e := &promExporter{qpkgs: []qpkgInfo{{name: "container-station", installPath: "/share/CACHEDEV1_DATA/.qpkg/container-station"}}}
+
+	metrics, err := e.getMalwareScanMetrics()
+	assert.NoError(t, err)
+	assert.Empty(t, metrics)
+	assert.Equal(t, []exporter.Prerequisite{{Name: "Malware Remover", Detail: "not installed"}}, e.checkMalwareRemover())
+}
+
+func TestSanitizeQpkgVersion(t *testing.T) {
+	assert.Equal(t, "1.2.3", sanitizeQpkgVersion(" 1.2.3\x00 "))
+	assert.Equal(t, "unknown", sanitizeQpkgVersion(""))
+	assert.Len(t, sanitizeQpkgVersion(strings.Repeat("9", 100)), maxQpkgVersionLength)
+}
+
+func TestCertificateMetrics(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	cert := server.Certificate()
+
+	root := t.TempDir()
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")})
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc", "stunnel"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "stunnel", "stunnel.pem"), append(keyPEM, certPEM...), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "invalid.pem"), []byte("not a certificate"), 0o644))
+
+	// A listener closed right away gives an unreachable address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := listener.Addr().String()
+	listener.Close()
+
+	target := strings.TrimPrefix(server.URL, "https://")
+	e := &promExporter{ExporterConfig: ExporterConfig{
+		Logger:       log.New(io.Discard, "", 0),
+		Paths:        Paths{RootFS: root},
+		Certificates: CertificateConfig{Files: []string{DefaultCertificateFile, "/invalid.pem"}, Targets: []string{target, unreachable}},
+	}}
+
+	metrics, err := e.getCertificateMetrics()
+	require.NoError(t, err, "the failed sources don't fail the collector")
+	values := map[string]float64{}
+	for _, m := range metrics {
+		values[m.name+"{"+m.attr+"}"] = m.value
+	}
+	expiry := float64(cert.NotAfter.Unix())
+	assert.Equal(t, map[string]float64{
+		`node_certificate_expiry_timestamp_seconds{source="/etc/stunnel/stunnel.pem"}`: expiry,
+		`node_certificate_error{source="/etc/stunnel/stunnel.pem"}`:                    0,
+		`node_certificate_error{source="/invalid.pem"}`:                                1,
+		`node_certificate_expiry_timestamp_seconds{source="` + target + `"}`:           expiry,
+		`node_certificate_error{source="` + target + `"}`:                              0,
+		`node_certificate_error{source="` + unreachable + `"}`:                         1,
+	}, values)
+}
+
+// serveNTP answers the SNTP requests received on a local UDP socket with a clock ahead by offset,
+// or with an invalid response if offset is negative
+func serveNTP(t *testing.T, offset time.Duration) (string, *int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	var requests int32
+	go func() {
+		buf := make([]byte, ntpPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&requests, 1)
+			if n != ntpPacketSize {
+				continue
+			}
+
+			response := make([]byte, ntpPacketSize)
+			response[0], response[1] = 0x24, 2
+			if offset < 0 {
+				// Client mode
+				response[0] = 0x23
+			}
+			copy(response[24:32], buf[40:48])
+			now := toNTPTime(time.Now().Add(offset))
+			binary.BigEndian.PutUint64(response[32:], now)
+			binary.BigEndian.PutUint64(response[40:], now)
+			_, _ = conn.WriteTo(response, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String(), &requests
+}
+
+func TestNTPMetrics(t *testing.T) {
+	server, requests := serveNTP(t, 10*time.Second)
+	e := &promExporter{ExporterConfig: ExporterConfig{Logger: log.New(io.Discard, "", 0), NTPServer: server}}
+
+	metrics, err := e.getNTPMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "node_ntp_offset_seconds", metrics[0].name)
+	assert.InDelta(t, 10, metrics[0].value, 0.1)
+	assert.Equal(t, "node_ntp_rtt_seconds", metrics[1].name)
+	assert.InDelta(t, 0, metrics[1].value, 0.1)
+
+	// The server isn't queried again within a minute
+	metrics, err = e.getNTPMetrics()
+	require.NoError(t, err)
+	assert.Len(t, metrics, 2)
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func TestNTPMetricsFailure(t *testing.T) {
+	server, requests := serveNTP(t, -1)
+	e := &promExporter{ExporterConfig: ExporterConfig{Logger: log.New(io.Discard, "", 0), NTPServer: server}}
+
+	for i := 0; i < 2; i++ {
+		metrics, err := e.getNTPMetrics()
+		assert.NoError(t, err, "the failures aren't fatal")
+		assert.Empty(t, metrics)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests), "the server isn't queried again until the backoff elapses")
+	assert.Equal(t, ntpInterval, e.ntp.backoff)
+
+	e.ntp.next = time.Time{}
+	_, _ = e.getNTPMetrics()
+	assert.Equal(t, 2*ntpInterval, e.ntp.backoff)
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Unix(1700000000, 123456789)
+
+	assert.WithinDuration(t, now, fromNTPTime(toNTPTime(now)), time.Microsecond)
+	assert.Equal(t, uint64(ntpEpochOffset)<<32, toNTPTime(time.Unix(0, 0)))
+	assert.Equal(t, "pool.ntp.org:123", ntpAddress("pool.ntp.org"))
+	assert.Equal(t, "127.0.0.1:1123", ntpAddress("127.0.0.1:1123"))
+}
+
+// serveDNS answers the A queries received on a local UDP socket with 192.0.2.1 for nas.example.com, and with no
+// records for any other query
+func serveDNS(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// The question follows the 12-byte header, and ends with its type and class
+			qname := 12
+			for qname < n && buf[qname] != 0 {
+				qname += int(buf[qname]) + 1
+			}
+			if qname+5 > n {
+				continue
+			}
+			question := buf[12 : qname+5]
+			answer := binary.BigEndian.Uint16(buf[qname+1:]) == 1 && bytes.Equal(question[:qname-11], []byte("\x03nas\x07example\x03com\x00"))
+
+			response := append([]byte{buf[0], buf[1], 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0}, question...)
+			if answer {
+				response[7] = 1
+				response = append(response, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0, 2, 1)
+			}
+			_, _ = conn.WriteTo(response, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestDNSMetrics(t *testing.T) {
+	server := serveDNS(t)
+	// A listener closed right away gives an unreachable server
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := listener.LocalAddr().String()
+	listener.Close()
+
+	e := &promExporter{ExporterConfig: ExporterConfig{
+		Logger: log.New(io.Discard, "", 0),
+		DNS:    DNSConfig{Targets: []string{"nas.example.com", "missing.example.com"}, Resolvers: []string{server, unreachable}},
+	}}
+
+	metrics, err := e.getDNSMetrics()
+	require.NoError(t, err)
+	successes := map[string]float64{}
+	for _, m := range metrics {
+		switch m.name {
+		case "node_dns_lookup_success":
+			successes[m.attr] = m.value
+		case "node_dns_lookup_duration_seconds":
+			assert.Less(t, m.value, dnsTimeout.Seconds()+1)
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		`target="nas.example.com",resolver="` + server + `"`:          1,
+		`target="missing.example.com",resolver="` + server + `"`:      0,
+		`target="nas.example.com",resolver="` + unreachable + `"`:     0,
+		`target="missing.example.com",resolver="` + unreachable + `"`: 0,
+	}, successes)
+}
+
+func TestCountUtmpUsers(t *testing.T) {
+	// Builds a 384-byte glibc utmp record
+	record := func(recordType int16, line, user string) []byte {
+		b := make([]byte, 384)
+		binary.LittleEndian.PutUint16(b[0:], uint16(recordType))
+		binary.LittleEndian.PutUint32(b[4:], 1234)
+		copy(b[8:40], line)
+		copy(b[44:76], user)
+		copy(b[76:332], "192.168.1.10")
+		binary.LittleEndian.PutUint32(b[340:], 1700000000)
+		return b
+	}
+	var utmp []byte
+	utmp = append(utmp, record(2, "~", "reboot")...)
+	utmp = append(utmp, record(1, "~", "runlevel")...)
+	utmp = append(utmp, record(7, "pts/0", "admin")...)
+	utmp = append(utmp, record(7, "pts/1", "alice")...)
+	// A session which ended
+	utmp = append(utmp, record(8, "pts/2", "")...)
+	path := filepath.Join(t.TempDir(), "utmp")
+	require.NoError(t, os.WriteFile(path, utmp, 0o644))
+
+	users, err := countUtmpUsers(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, users)
+
+	require.NoError(t, os.WriteFile(path, utmp[:len(utmp)-10], 0o644))
+	_, err = countUtmpUsers(path)
+	assert.ErrorContains(t, err, "truncated record, expected 384 bytes per record")
+
+	_, err = countUtmpUsers(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestEdacMetrics(t *testing.T) {
+	sysFS := t.TempDir()
+	e := &promExporter{ExporterConfig: ExporterConfig{Paths: Paths{SysFS: sysFS}}}
+
+	metrics, err := e.getEdacMetrics()
+	require.NoError(t, err)
+	assert.Empty(t, metrics, "systems without EDAC have no metrics")
+
+	for name, contents := range map[string]string{
+		"mc0/ce_count":            "3",
+		"mc0/ue_count":            "0",
+		"mc0/ce_noinfo_count":     "1",
+		"mc0/ue_noinfo_count":     "0",
+		"mc0/csrow0/ce_count":     "2",
+		"mc0/csrow0/ue_count":     "0",
+		"mc0/dimm1/dimm_ce_count": "2",
+		"mc0/dimm1/dimm_ue_count": "0",
+		"mc0/dimm1/dimm_label":    "CPU_SrcID#0_Ha#0_Chan#0_DIMM#1",
+		"mc1/ce_count":            "0",
+		"mc1/ue_count":            "1",
+		"power/autosuspend_delay": "",
+	} {
+		path := filepath.Join(sysFS, edacDir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents+"\n"), 0o644))
+	}
+
+	metrics, err = e.getEdacMetrics()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, m := range metrics {
+		assert.Equal(t, "counter", m.metricType)
+		values[m.name+"{"+m.attr+"}"] = m.value
+	}
+	assert.Equal(t, map[string]float64{
+		`node_edac_correctable_errors_total{controller="0"}`:                         3,
+		`node_edac_uncorrectable_errors_total{controller="0"}`:                       0,
+		`node_edac_csrow_correctable_errors_total{controller="0",csrow="unknown"}`:   1,
+		`node_edac_csrow_uncorrectable_errors_total{controller="0",csrow="unknown"}`: 0,
+		`node_edac_csrow_correctable_errors_total{controller="0",csrow="0"}`:         2,
+		`node_edac_csrow_uncorrectable_errors_total{controller="0",csrow="0"}`:       0,
+		`node_edac_dimm_correctable_errors_total{controller="0",dimm="1"}`:           2,
+		`node_edac_dimm_uncorrectable_errors_total{controller="0",dimm="1"}`:         0,
+		`node_edac_correctable_errors_total{controller="1"}`:                         0,
+		`node_edac_uncorrectable_errors_total{controller="1"}`:                       1,
+	}, values)
+}
+
+func TestDebugState(t *testing.T) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, nil).(*promExporter)
+	defer e.Close()
+	e.devices, e.ifaces = []string{"sda", "nvme0n1"}, []string{"eth0"}
+	e.volumes = []volumeInfo{{index: "0", description: "DataVol1", fileSystem: "ext4", status: "Ready", freeSizeBytes: 4e12, totalSizeBytes: 1e13}}
+	fetchTime := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	e.volumeLastFetch = fetchTime
+	e.lastFetchErrors = map[string]string{"ups": "connection refused (attempt 1)"}
+	e.upsState.upsConnAttempts, e.upsState.upsConnErr = 1, errors.New("connection refused")
+
+	state, ok := e.DebugState().(debugState)
+	require.True(t, ok)
+	assert.Equal(t, []string{"sda", "nvme0n1"}, state.Devices)
+	assert.Equal(t, []string{"eth0"}, state.Interfaces)
+	assert.Equal(t, fetchTime.Add(volumeValidity), state.Volumes.Expiry)
+	assert.Equal(t, []debugVolume{{Index: "0", Description: "DataVol1", FileSystem: "ext4", Status: "Ready", FreeSizeBytes: 4e12, TotalSizeBytes: 1e13}}, state.Volumes.Cache)
+	assert.Equal(t, map[string]string{"ups": "connection refused (attempt 1)"}, state.LastFetchErrors)
+	assert.False(t, state.Ups.Connected)
+	assert.Equal(t, "connection refused", state.Ups.LastError)
+
+	// The snapshot doesn't change with the exporter
+	e.devices[0] = "sdb"
+	assert.Equal(t, "sda", state.Devices[0])
+	_, err := json.Marshal(state)
+	assert.NoError(t, err)
+}
+
+func TestParseLabels(t *testing.T) {
+	testCases := map[string]struct {
+		attr string
+		want map[string]string
+	}{
+		"no labels":       {attr: "", want: map[string]string{}},
+		"single label":    {attr: `device="sda"`, want: map[string]string{"device": "sda"}},
+		"several labels":  {attr: `device="sda",port="ata3"`, want: map[string]string{"device": "sda", "port": "ata3"}},
+		"escaped quotes":  {attr: `name="a \"b\", c",d="e"`, want: map[string]string{"name": `a "b", c`, "d": "e"}},
+		"malformed value": {attr: `device="sda",port=ata3`, want: map[string]string{"device": "sda"}},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tc.want, parseLabels(tc.attr))
+		})
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	e := &promExporter{ExporterConfig: ExporterConfig{
+		PushStats: func() map[string]exporter.PushStats {
+			return map[string]exporter.PushStats{"otlp": {Sent: 5, Dropped: 1, SamplesSent: 500, SamplesDropped: 100}}
+		},
+	}}
+
+	metrics, err := e.getPushMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, []metric{
+		{name: "qnapexporter_push_batches_sent_total", attr: `backend="otlp"`, value: 5, help: "Number of batches of samples pushed to the backend", metricType: "counter"},
+		{name: "qnapexporter_push_batches_dropped_total", attr: `backend="otlp"`, value: 1, help: "Number of batches of samples dropped after failing to push them to the backend", metricType: "counter"},
+		{name: "qnapexporter_push_samples_sent_total", attr: `backend="otlp"`, value: 500, help: "Number of samples pushed to the backend", metricType: "counter"},
+		{name: "qnapexporter_push_samples_dropped_total", attr: `backend="otlp"`, value: 100, help: "Number of samples dropped after failing to push them to the backend", metricType: "counter"},
+	}, metrics)
+}
+
+func TestCollectorBreakers(t *testing.T) {
+	b := collectorBreakers{BreakerConfig: BreakerConfig{Threshold: 2, Backoff: time.Minute, MaxBackoff: 3 * time.Minute}.withDefaults()}
+	now := time.Now()
+
+	assert.Zero(t, b.recordFailure("hd", now))
+	assert.False(t, b.skip("hd", now))
+	assert.Equal(t, time.Minute, b.recordFailure("hd", now))
+	assert.True(t, b.skip("hd", now))
+	assert.Equal(t, []string{"hd"}, b.degraded())
+
+	// The probe after the backoff fails
+	now = now.Add(time.Minute)
+	assert.False(t, b.skip("hd", now))
+	assert.Equal(t, 2*time.Minute, b.recordFailure("hd", now))
+	assert.Equal(t, 3*time.Minute, b.recordFailure("hd", now))
+	assert.True(t, b.skip("hd", now.Add(2*time.Minute)))
+
+	assert.True(t, b.recordSuccess("hd"))
+	assert.False(t, b.skip("hd", now))
+	assert.Empty(t, b.degraded())
+	assert.False(t, b.recordSuccess("fan"))
+
+	// The zero value never degrades collectors
+	var disabled collectorBreakers
+	for i := 0; i < 10; i++ {
+		assert.Zero(t, disabled.recordFailure("hd", now))
+	}
+	assert.False(t, disabled.skip("hd", now))
+}
+
+func TestWriteMetricsSkipsDegradedCollectors(t *testing.T) {
+	var logs bytes.Buffer
+	config := ExporterConfig{Logger: log.New(&logs, "", 0), Breaker: BreakerConfig{Threshold: 2, Backoff: time.Hour}}
+	e := NewExporter(config, &exporter.Status{}).(*promExporter)
+	defer e.Close()
+
+	runs := 0
+	e.collectors = []collector{
+		{name: "failing", fetch: func() ([]metric, error) { runs++; return nil, errors.New("boom") }},
+		{name: "breaker", fetch: e.getBreakerMetrics, enabled: e.breakers.enabled},
+	}
+
+	// The first failures are logged
+	assert.Error(t, e.WriteMetrics(io.Discard))
+	assert.Contains(t, logs.String(), "retrieve failing metrics: boom")
+	assert.Error(t, e.WriteMetrics(io.Discard))
+	assert.Contains(t, logs.String(), "Collector failing keeps failing, skipping it for 1h0m0s")
+
+	b := new(bytes.Buffer)
+	assert.NoError(t, e.WriteMetrics(b))
+	assert.Equal(t, 2, runs)
+	assert.Contains(t, b.String(), `node_scrape_collector_degraded{node="`)
+	assert.Contains(t, b.String(), `,collector="failing"} 1`)
+	assert.Equal(t, map[string]string{"failing": "skipped after failing repeatedly"}, e.lastFetchErrors)
+
+	// The collector runs again after a reload
+	e.Reload()
+	assert.Error(t, e.WriteMetrics(io.Discard))
+	assert.Equal(t, 3, runs)
+}
+
+func TestRefreshEnvironmentResetsBreakers(t *testing.T) {
+	for _, env := range []string{"HOST_ROOT", "HOST_PROC", "HOST_SYS", "HOST_DEV"} {
+		t.Setenv(env, "")
+	}
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "dev"), 0o755))
+	config := ExporterConfig{
+		Logger:  log.New(io.Discard, "", 0),
+		Paths:   Paths{RootFS: root, ProcFS: filepath.Join(root, "proc"), SysFS: filepath.Join(root, "sys")},
+		Breaker: BreakerConfig{Threshold: 2, Backoff: time.Hour},
+	}
+	e := NewExporter(config, &exporter.Status{}).(*promExporter)
+	defer e.Close()
+
+	runs := 0
+	e.collectors = []collector{
+		{name: "failing", fetch: func() ([]metric, error) { runs++; return nil, errors.New("boom") }},
+	}
+	assert.Error(t, e.WriteMetrics(io.Discard))
+	assert.Error(t, e.WriteMetrics(io.Discard))
+	assert.NoError(t, e.WriteMetrics(io.Discard))
+	require.Equal(t, 2, runs)
+	require.NoError(t, e.Ready())
+
+	// The environment expires and is read again successfully, as it was before
+	e.envExpiry = time.Time{}
+	assert.Error(t, e.WriteMetrics(io.Discard))
+	assert.Equal(t, 3, runs)
+}
+
+func TestParseQuietHours(t *testing.T) {
+	hours, err := ParseQuietHours("Mon-Fri 23:00-07:00, Sat 12:00-14:30")
+	require.NoError(t, err)
+
+	// 2024-01-01 is a Monday
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local) }
+	assert.True(t, hours.Contains(at(1, 23, 0)))
+	assert.True(t, hours.Contains(at(2, 6, 59)))
+	assert.False(t, hours.Contains(at(2, 7, 0)))
+	assert.False(t, hours.Contains(at(1, 6, 0)), "the Sunday night isn't quiet")
+	assert.True(t, hours.Contains(at(6, 6, 0)), "the Friday night ends on Saturday")
+	assert.False(t, hours.Contains(at(6, 23, 0)))
+	assert.True(t, hours.Contains(at(6, 14, 29)))
+	assert.False(t, hours.Contains(at(6, 14, 30)))
+
+	hours, err = ParseQuietHours("00:00-00:00")
+	require.NoError(t, err)
+	assert.True(t, hours.Contains(at(3, 12, 0)))
+
+	hours, err = ParseQuietHours("")
+	require.NoError(t, err)
+	assert.Empty(t, hours)
+
+	for _, spec := range []string{"23:00", "25:00-07:00", "Someday 01:00-02:00", "Mon Tue 01:00-02:00", "1:60-2:00"} {
+		_, err := ParseQuietHours(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestWriteMetricsPausesDiskCollectorsDuringQuietHours(t *testing.T) {
+	var logs bytes.Buffer
+	hours, err := ParseQuietHours("00:00-24:00")
+	require.NoError(t, err)
+	config := ExporterConfig{Logger: log.New(&logs, "", 0), QuietHours: hours}
+	e := NewExporter(config, &exporter.Status{}).(*promExporter)
+	defer e.Close()
+
+	// The collectors run concurrently
+	var mu sync.Mutex
+	runs := map[string]int{}
+	fetch := func(name string) fetchMetricFn {
+		return func() ([]metric, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[name]++
+			return nil, nil
+		}
+	}
+	e.collectors = []collector{
+		{name: "cpu", fetch: fetch("cpu")},
+		{name: "hd", fetch: fetch("hd"), wakesDisks: true},
+		{name: "quota", fetch: fetch("quota"), wakesDisks: true, enabled: func() bool { return false }},
+		{name: "paused", fetch: e.getPausedMetrics, enabled: func() bool { return len(e.QuietHours) > 0 }},
+	}
+
+	b := new(bytes.Buffer)
+	require.NoError(t, e.WriteMetrics(b))
+	assert.Equal(t, map[string]int{"cpu": 1}, runs)
+	assert.Contains(t, b.String(), `,collector="hd"} 1`)
+	assert.NotContains(t, b.String(), `collector="quota"`)
+	assert.Contains(t, logs.String(), "Quiet hours started")
+
+	// The collectors run again once the quiet hours are over
+	e.QuietHours = QuietHours{}
+	e.collectors[3].enabled = nil
+	b.Reset()
+	require.NoError(t, e.WriteMetrics(b))
+	assert.Equal(t, map[string]int{"cpu": 2, "hd": 1}, runs)
+	assert.Contains(t, b.String(), `,collector="hd"} 0`)
+	assert.Contains(t, logs.String(), "Quiet hours ended")
+}
+
+func TestParseTemperature(t *testing.T) {
+	testCases := map[string]struct {
+		output  string
+		want    float64
+		wantErr bool
+	}{
+		"Celsius first":        {output: "45 C/113 F", want: 45},
+		"Fahrenheit first":     {output: "113 F/45 C", want: 45},
+		"degree sign":          {output: "45°C", want: 45},
+		"degree sign, spaced":  {output: "45 °C / 113 °F", want: 45},
+		"bare number":          {output: "45", want: 45},
+		"no space":             {output: "45C/113F", want: 45},
+		"decimal comma":        {output: "38,5 C", want: 38.5},
+		"only Fahrenheit":      {output: "113 F", want: 45},
+		"only Fahrenheit, °":   {output: "104.9°F", want: 40.5},
+		"trailing line break":  {output: "41 C/105 F\n", want: 41},
+		"unavailable":          {output: "--", wantErr: true},
+		"not a temperature":    {output: "hot", wantErr: true},
+		"empty":                {output: "", wantErr: true},
+		"negative":             {output: "-5 C/23 F", want: -5},
+		"lowercase unit":       {output: "50 c", want: 50},
+		"unit with a word":     {output: "45 Celsius", want: 45},
+		"Fahrenheit converted": {output: "32 F", want: 0},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			value, err := parseTemperature(tc.output)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tc.want, value, 1e-9)
+		})
+	}
+}
+
+func TestParseHalAppTemp(t *testing.T) {
+	testCases := map[string]struct {
+		output  string
+		want    float64
+		wantErr bool
+	}{
+		"temperature":            {output: "temp = 42 C", want: 42},
+		"among other fields":     {output: "obj_index = 1\ntemp = 38.5 C\nstatus = OK", want: 38.5},
+		"no temperature":         {output: "status = not installed", wantErr: true},
+		"invalid temperature":    {output: "temp = N/A", wantErr: true},
+		"unsupported subcommand": {output: "Usage: hal_app --se_sys_get_temp enc_sys_id=<id>,obj_index=<index>", wantErr: true},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			value, err := parseHalAppTemp(tc.output)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, value)
+		})
+	}
+}
+
+func TestHalAppSensorMetrics(t *testing.T) {
+	answers := map[string]string{
+		"hal_app --se_sys_get_temp enc_sys_id=root,obj_index=0":   "temp = 45 C",
+		"hal_app --se_sys_get_temp enc_sys_id=root,obj_index=1":   "temp = 35 C",
+		"hal_app --se_sys_get_fan enc_sys_id=root,obj_index=0":    "fan = 900 rpm",
+		"hal_app --se_sys_get_fan enc_sys_id=root,obj_index=1":    "fan = 0 rpm\nstatus = failed",
+		"hal_app --pd_get_temp enc_sys_id=root,port_id=1":         "port_id = 1\ntemp = 35 C",
+		"hal_app --pd_get_smart_status enc_sys_id=root,port_id=1": "smart status = GOOD",
+		"hal_app --pd_get_temp enc_sys_id=root,port_id=2":         "port_id = 2\nstatus = not present",
+		"hal_app --pd_get_temp enc_sys_id=root,port_id=3":         "port_id = 3\ntemp = 41 C",
+		"hal_app --pd_get_smart_status enc_sys_id=root,port_id=3": "smart status = Warning",
+	}
+	var s exporter.Status
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, &s).(*promExporter)
+	defer e.Close()
+	e.runCommand = func(ctx context.Context, cmd string, args ...string) (string, error) {
+		command := strings.Join(append([]string{cmd}, args...), " ")
+		if answer, ok := answers[command]; ok {
+			return answer, nil
+		}
+		return "", fmt.Errorf("unexpected command %q", command)
+	}
+	e.getsysinfo, e.hal_app = "", "hal_app"
+	e.readHalAppEnvironment("enc_id enc_sys_id root x TS-h973AX x x 3 2 x 2\nenc_id enc_sys_id 1 x QM2-1 x x 2 1 x 1")
+	require.Equal(t, 3, e.syshdnum)
+	require.Equal(t, 2, e.sysfannum)
+
+	// The series are the same as the ones reported with getsysinfo
+	testCases := map[string]struct {
+		fetch     fetchMetricFn
+		wantAttrs []string
+		want      []float64
+	}{
+		"temperature": {fetch: e.getSysInfoTempMetrics, wantAttrs: []string{"", ""}, want: []float64{45, 35}},
+		"fan":         {fetch: e.getSysInfoFanMetrics, wantAttrs: []string{`fan="1",type="System"`, `fan="2",type="System"`}, want: []float64{900, 0}},
+		"hd":          {fetch: e.getSysInfoHdMetrics, wantAttrs: []string{`hd="1",smart="GOOD"`, `hd="3",smart="Warning"`}, want: []float64{35, 41}},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			metrics, err := tc.fetch()
+			require.NoError(t, err)
+			var attrs []string
+			var values []float64
+			for _, m := range metrics {
+				attrs = append(attrs, m.attr)
+				values = append(values, m.value)
+			}
+			assert.Equal(t, tc.wantAttrs, attrs)
+			assert.Equal(t, tc.want, values)
+		})
+	}
+	assert.Equal(t, []string{"1", "3"}, s.Disks)
+	assert.Equal(t, []exporter.Prerequisite{{Name: "hal_app", Found: true, Detail: "hal_app"}}, e.checkSensors())
+}
+
+func TestExpansionUnitMetrics(t *testing.T) {
+	seEnum := "enc_id enc_sys_id root x TS-873A x x 8 2 x 2\n" +
+		"enc_id enc_sys_id qm2_1 x QM2-1 x x 2 1 x 1\n" +
+		"enc_id enc_sys_id tl_1 x TL-D800C x x 2 1 x 1"
+	answers := map[string]string{
+		"getsysinfo hdtmp 1":   "35 C/95 F",
+		"getsysinfo hdsmart 1": "GOOD",
+		"hal_app --se_sys_get_temp enc_sys_id=tl_1,obj_index=0":   "temp = 31 C",
+		"hal_app --pd_get_temp enc_sys_id=tl_1,port_id=1":         "port_id = 1\ntemp = 33 C",
+		"hal_app --pd_get_smart_status enc_sys_id=tl_1,port_id=1": "smart status = GOOD",
+		"hal_app --pd_get_temp enc_sys_id=tl_1,port_id=2":         "port_id = 2\nstatus = not present",
+	}
+	var s exporter.Status
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, &s).(*promExporter)
+	defer e.Close()
+	e.runCommand = func(ctx context.Context, cmd string, args ...string) (string, error) {
+		command := strings.Join(append([]string{cmd}, args...), " ")
+		if answer, ok := answers[command]; ok {
+			return answer, nil
+		}
+		return "", fmt.Errorf("unexpected command %q", command)
+	}
+	e.getsysinfo, e.hal_app, e.syshdnum = "getsysinfo", "hal_app", 1
+	e.readExpansionUnits(seEnum)
+
+	require.Len(t, e.expansions, 1)
+	assert.Equal(t, "TL-D800C", e.expansions[0].name)
+	assert.Equal(t, []qnapEnclosure{e.expansions[0]}, e.enclosures, "the fans of the expansion units are reported")
+	assert.Equal(t, []string{"TL-D800C"}, s.Enclosures)
+
+	values := func(fetch fetchMetricFn) map[string]float64 {
+		metrics, err := fetch()
+		require.NoError(t, err)
+		v := map[string]float64{}
+		for _, m := range metrics {
+			v[m.name+"{"+m.attr+"}"] = m.value
+		}
+		return v
+	}
+	assert.Equal(t, map[string]float64{
+		`node_hdtmp_C{hd="1",smart="GOOD"}`:                      35,
+		`node_hdtmp_C{hd="1",smart="GOOD",enclosure="TL-D800C"}`: 33,
+	}, values(e.getSysInfoHdMetrics))
+	assert.Equal(t, map[string]float64{
+		`node_enclosure_present{enclosure="TL-D800C"}`:                        1,
+		`node_enclosure_temperature_celsius{enclosure="TL-D800C",sensor="1"}`: 31,
+	}, values(e.getExpansionMetrics))
+
+	// A disconnected expansion unit is still reported, as absent
+	e.expansions = nil
+	assert.Equal(t, map[string]float64{`node_enclosure_present{enclosure="TL-D800C"}`: 0}, values(e.getExpansionMetrics))
+
+	// Nothing changes without expansion units
+	e = NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, nil).(*promExporter)
+	defer e.Close()
+	e.readExpansionUnits("enc_id enc_sys_id root x TS-873A x x 8 2 x 2\nenc_id enc_sys_id qm2_1 x QM2-1 x x 2 1 x 1")
+	assert.Empty(t, e.expansions)
+	assert.Empty(t, values(e.getExpansionMetrics))
+}
+
+func TestParseQcliTable(t *testing.T) {
+	testCases := map[string]struct {
+		output string
+		want   qcliTable
+	}{
+		"empty": {},
+		"columns with spaces": {
+			output: "Pool ID  Status   Capacity\n-------  -------  --------\n1        Ready    21.80 TB\n\n2        Warning  3.63 TB\n",
+			want: qcliTable{
+				{"poolid": "1", "status": "Ready", "capacity": "21.80 TB"},
+				{"poolid": "2", "status": "Warning", "capacity": "3.63 TB"},
+			},
+		},
+		"value longer than its column": {
+			output: "RAID  Status  Level\n1     Rebuilding... (50%)  RAID 5\n2     Ready\n",
+			want: qcliTable{
+				{"raid": "1", "status": "Rebuilding... (50%)", "level": "RAID 5"},
+				{"raid": "2", "status": "Ready", "level": ""},
+			},
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tc.want, parseQcliTable(tc.output))
+		})
+	}
+}
+
+func TestStoragePoolMetrics(t *testing.T) {
+	answers := map[string]string{
+		"qcli_storage -p": "Pool ID  Status              Capacity  Used Size  Free Size\n" +
+			"1        Ready               21.80 TB  12.40 TB   9.40 TB\n" +
+			"2        Rebuilding (12%)    3.00 TB              1.00 TB\n",
+		"qcli_storage -d": "RAID Group  Pool ID  RAID Level  Status      Disk Members\n" +
+			"1           1        RAID 5      Ready       1,2,3,4\n" +
+			"2           2        RAID 1      Rebuilding  5,6\n",
+	}
+	var commands int
+	e := &promExporter{ExporterConfig: ExporterConfig{StoragePoolInterval: time.Hour}}
+	e.runCommand = func(ctx context.Context, cmd string, args ...string) (string, error) {
+		commands++
+		command := strings.Join(append([]string{cmd}, args...), " ")
+		if answer, ok := answers[command]; ok {
+			return answer, nil
+		}
+		return "", fmt.Errorf("unexpected command %q", command)
+	}
+
+	// Nothing is reported without qcli_storage
+	metrics, err := e.getStoragePoolMetrics()
+	assert.NoError(t, err)
+	assert.Empty(t, metrics)
+
+	e.qcliStorage = "qcli_storage"
+	metrics, err = e.getStoragePoolMetrics()
+	require.NoError(t, err)
+	var lines []string
+	for _, m := range metrics {
+		lines = append(lines, fmt.Sprintf("%s{%s} %v", m.name, m.attr, m.value))
+	}
+	tb := 1024.0 * 1024 * 1024 * 1024
+	assert.Equal(t, []string{
+		`qnap_pool_status{pool="1",status="Ready"} 1`,
+		fmt.Sprintf(`qnap_pool_size_bytes{pool="1"} %v`, 21.80*tb),
+		fmt.Sprintf(`qnap_pool_used_bytes{pool="1"} %v`, 12.40*tb),
+		`qnap_pool_status{pool="2",status="Rebuilding"} 0`,
+		fmt.Sprintf(`qnap_pool_size_bytes{pool="2"} %v`, 3*tb),
+		fmt.Sprintf(`qnap_pool_used_bytes{pool="2"} %v`, 2*tb),
+		`qnap_raid_group_status{raid_group="1",pool="1",level="RAID 5",status="Ready"} 1`,
+		`qnap_raid_group_status{raid_group="2",pool="2",level="RAID 1",status="Rebuilding"} 0`,
+	}, lines)
+	assert.Equal(t, 2, commands)
+
+	// The tables are cached
+	_, err = e.getStoragePoolMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 2, commands)
+}
+
+func TestWriteMetricsDropsDuplicateSeries(t *testing.T) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, &exporter.Status{}).(*promExporter)
+	defer e.Close()
+	cached := []metric{{name: "node_volume_size_bytes", attr: `volume="Data",pool="1"`, value: 2, metricType: "gauge"}}
+	e.collectors = []collector{
+		{name: "volume", fetch: func() ([]metric, error) {
+			return []metric{
+				{name: "node_volume_size_bytes", attr: `pool="1",volume="Data"`, value: 1, metricType: "gauge"},
+				{name: "node_volume_size_bytes", attr: `pool="2",volume="Data"`, value: 1, metricType: "gauge"},
+			}, nil
+		}},
+		{name: "storage", fetch: func() ([]metric, error) { return cached, nil }},
+	}
+	var b bytes.Buffer
+
+	// The collectors run concurrently, so that either of the duplicates may be kept
+	require.NoError(t, e.WriteMetrics(&b))
+
+	series := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		require.Len(t, fields, 2, line)
+		_, err := strconv.ParseFloat(fields[1], 64)
+		require.NoError(t, err, line)
+		series[fields[0]]++
+	}
+	assert.Len(t, series, 2)
+	for name, count := range series {
+		assert.Equal(t, 1, count, name)
+	}
+	assert.Equal(t, uint64(1), e.duplicateSamples)
+	assert.Len(t, cached, 1, "the metrics of the collectors aren't modified")
+
+	metrics, err := e.getDuplicateMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, metrics[0].value)
+}
+
+func TestScrapeSizeMetrics(t *testing.T) {
+	var logs bytes.Buffer
+	e := NewExporter(ExporterConfig{Logger: log.New(&logs, "", 0), SeriesWarningThreshold: 2}, nil).(*promExporter)
+	defer e.Close()
+	e.envExpiry, e.envEntries = time.Now().Add(time.Hour), e.readEnvEntries()
+	e.collectors = []collector{
+		{name: "veth", fetch: func() ([]metric, error) {
+			return []metric{
+				{name: "node_network_receive_bytes_total", attr: `device="veth1"`, value: 1},
+				{name: "node_network_receive_bytes_total", attr: `device="veth2"`, value: 2},
+				{name: "node_network_receive_bytes_total", attr: `device="veth3"`, value: 3},
+			}, nil
+		}},
+	}
+
+	metrics, err := e.getScrapeSizeMetrics()
+	require.NoError(t, err)
+	assert.Empty(t, metrics, "nothing is reported before the first scrape")
+
+	var b bytes.Buffer
+	require.NoError(t, e.WriteMetrics(&b))
+	require.NoError(t, e.WriteMetrics(io.Discard))
+
+	metrics, err = e.getScrapeSizeMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "qnapexporter_scrape_samples", metrics[0].name)
+	assert.Equal(t, 3.0, metrics[0].value)
+	assert.Equal(t, "qnapexporter_scrape_response_bytes", metrics[1].name)
+	assert.Equal(t, float64(b.Len()), metrics[1].value)
+	assert.Equal(t, 1, strings.Count(logs.String(), "Scrape exported 3 series, more than the threshold of 2"), "the warning is logged once")
+}
+
+func TestWriteMetricsWithinServesStaleMetrics(t *testing.T) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0), StaleMaxAge: time.Hour}, nil).(*promExporter)
+	defer e.Close()
+	e.envExpiry, e.envEntries = time.Now().Add(time.Hour), e.readEnvEntries()
+	release := make(chan struct{})
+	slow := false
+	e.collectors = []collector{
+		{name: "slow", fetch: func() ([]metric, error) {
+			if slow {
+				<-release
+			}
+			return []metric{{name: "node_volume_size_bytes", attr: `volume="Data"`, value: 1}}, nil
+		}},
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, e.WriteMetricsWithin(&b, time.Second))
+	assert.Contains(t, b.String(), `node_volume_size_bytes{node="",volume="Data"} 1 `+"\n")
+	assert.Contains(t, b.String(), `qnapexporter_serving_stale{node=""} 0 `+"\n")
+	_, scraped, ok := e.stale.get(time.Hour)
+	require.True(t, ok)
+
+	slow = true
+	b.Reset()
+	require.NoError(t, e.WriteMetricsWithin(&b, 10*time.Millisecond))
+	assert.Contains(t, b.String(), fmt.Sprintf(`node_volume_size_bytes{node="",volume="Data"} 1 %d`+"\n", scraped.UnixNano()/1000000))
+	assert.Contains(t, b.String(), `qnapexporter_serving_stale{node=""} 1 `+"\n")
+
+	// Metrics older than the maximum age are never served
+	e.StaleMaxAge = time.Nanosecond
+	done := make(chan error)
+	go func() { done <- e.WriteMetricsWithin(io.Discard, 10*time.Millisecond) }()
+	select {
+	case <-done:
+		t.Fatal("the scrape must be waited for")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(t, <-done)
+}
+
+func TestAliasMetrics(t *testing.T) {
+	metrics := []metric{
+		{name: "node_hdtmp_C", attr: `hd="1",smart="GOOD"`, value: 35, help: "Disk temperature in degrees Celsius", metricType: "gauge"},
+		{name: "node_hdtmp_C", attr: `hd="2",smart="GOOD"`, value: 36, help: "Disk temperature in degrees Celsius", metricType: "gauge"},
+		{name: "node_load1", value: 0.5, help: "1m load average", metricType: "gauge"},
+	}
+	testCases := map[string]struct {
+		dropLegacy   bool
+		want         []string
+		wantFamilies []string
+	}{
+		"alongside the legacy names": {
+			want: []string{
+				`node_hdtmp_C{hd="1",smart="GOOD"} 35 Disk temperature in degrees Celsius (deprecated, use node_hd_temperature_celsius)`,
+				`node_hdtmp_C{hd="2",smart="GOOD"} 36 Disk temperature in degrees Celsius (deprecated, use node_hd_temperature_celsius)`,
+				`node_load1{} 0.5 1m load average`,
+				`node_hd_temperature_celsius{hd="1",smart="GOOD"} 35 Disk temperature in degrees Celsius`,
+				`node_hd_temperature_celsius{hd="2",smart="GOOD"} 36 Disk temperature in degrees Celsius`,
+			},
+			wantFamilies: []string{"node_hdtmp_C", "node_load1", "node_hd_temperature_celsius"},
+		},
+		"without the legacy names": {
+			dropLegacy: true,
+			want: []string{
+				`node_load1{} 0.5 1m load average`,
+				`node_hd_temperature_celsius{hd="1",smart="GOOD"} 35 Disk temperature in degrees Celsius`,
+				`node_hd_temperature_celsius{hd="2",smart="GOOD"} 36 Disk temperature in degrees Celsius`,
+			},
+			wantFamilies: []string{"node_load1", "node_hd_temperature_celsius"},
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			e := &promExporter{ExporterConfig: ExporterConfig{DropLegacyMetricNames: tc.dropLegacy}}
+
+			var lines []string
+			for _, m := range e.aliasMetrics(metrics) {
+				lines = append(lines, fmt.Sprintf("%s{%s} %v %s", m.name, m.attr, m.value, m.help))
+			}
+			assert.Equal(t, tc.want, lines)
+			assert.Equal(t, tc.wantFamilies, e.aliasFamilies([]string{"node_hdtmp_C", "node_load1"}))
+		})
+	}
+}
+
+func TestLoadAvgMetricsPerCPU(t *testing.T) {
+	testCases := map[string]struct {
+		loadPerCPU bool
+		cpuCount   int
+		wantLen    int
+	}{
+		"disabled":          {cpuCount: 4, wantLen: 3},
+		"enabled":           {loadPerCPU: true, cpuCount: 4, wantLen: 6},
+		"unknown cpu count": {loadPerCPU: true, wantLen: 3},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			e := &promExporter{ExporterConfig: ExporterConfig{LoadPerCPU: tc.loadPerCPU}, cpuCount: tc.cpuCount}
+
+			metrics, err := e.getLoadAvgMetrics()
+			require.NoError(t, err)
+			require.Len(t, metrics, tc.wantLen)
+			for i := 3; i < len(metrics); i++ {
+				assert.Equal(t, metrics[i-3].name+"_per_cpu", metrics[i].name)
+				assert.InDelta(t, metrics[i-3].value/4, metrics[i].value, 1e-9)
+			}
+		})
+	}
+}
+
+func TestCpuCount(t *testing.T) {
+	assert.Positive(t, readCPUCount())
+
+	metrics, err := getCpuRatioMetrics(8)
+	require.NoError(t, err)
+	last := metrics[len(metrics)-1]
+	assert.Equal(t, "node_cpu_count", last.name)
+	assert.Equal(t, 8.0, last.value)
+}
+
+func TestLinkStateAnnotations(t *testing.T) {
+	for _, env := range []string{"HOST_ROOT", "HOST_PROC", "HOST_SYS", "HOST_DEV"} {
+		t.Setenv(env, "")
+	}
+	sysFS := t.TempDir()
+	writeLink := func(iface, operstate, carrier string) {
+		dir := filepath.Join(sysFS, netDir, iface)
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "statistics"), 0o755))
+		for name, contents := range map[string]string{"statistics/rx_bytes": "1", "statistics/tx_bytes": "1", "operstate": operstate, "carrier": carrier} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(contents+"\n"), 0o644))
+		}
+	}
+	writeLink("eth0", "up", "1")
+	// Some drivers don't report the operstate
+	writeLink("eth1", "unknown", "1")
+	writeLink("veth1", "down", "0")
+
+	annotator, posted := newAnnotationRecorder()
+	config := ExporterConfig{
+		Logger:             log.New(io.Discard, "", 0),
+		Paths:              Paths{SysFS: sysFS},
+		Network:            NetworkConfig{Classes: []string{InterfaceClassPhysical, InterfaceClassEphemeral}},
+		Annotator:          annotator,
+		NetworkAnnotations: true,
+	}
+	e := NewExporter(config, nil).(*promExporter)
+	defer e.Close()
+	e.ifaces = e.listInterfaces(false)
+	scrape := func() map[string]float64 {
+		metrics, err := e.getNetworkStatsMetrics()
+		require.NoError(t, err)
+		up := map[string]float64{}
+		for _, m := range metrics {
+			if m.name == "node_network_up" {
+				up[m.attr] = m.value
+			}
+		}
+		return up
+	}
+
+	assert.Equal(t, map[string]float64{`device="eth0"`: 1, `device="eth1"`: 1}, scrape(), "the virtual interfaces aren't reported")
+
+	// A flap isn't posted
+	writeLink("eth0", "down", "0")
+	assert.Equal(t, 0.0, scrape()[`device="eth0"`])
+	writeLink("eth0", "up", "1")
+	scrape()
+	annotator.AssertNotCalled(t, "PostAnnotation", mock.Anything)
+
+	// The region starts when the link was first seen down
+	writeLink("eth1", "unknown", "0")
+	scrape()
+	downSince := time.Now()
+	scrape()
+	a := <-posted
+	assert.Equal(t, notifications.Annotation{Text: "Link eth1 down", Tags: []string{"network"}, Time: a.Time}, a)
+	assert.True(t, a.Time.Before(downSince))
+
+	writeLink("eth1", "up", "1")
+	scrape()
+	scrape()
+	a = <-posted
+	assert.Equal(t, notifications.Annotation{Text: "Link eth1 down", Tags: []string{"network"}, Time: a.Time, End: true}, a)
+	annotator.AssertNumberOfCalls(t, "PostAnnotation", 2)
+}
+
+func TestReadDiskMembers(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs := func(paths ...string) {
+		for _, path := range paths {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, path), 0o755))
+		}
+	}
+	mkdirs("sda/sda1", "sda/sda3", "sdb/sdb3", "nvme0n1/nvme0n1p1", "sdc")
+	// md9 is built from two partitions of each disk, md1 is cached by dm-0 with an SSD partition
+	mkdirs("md9/slaves/sda1", "md9/slaves/sdb1", "sdb/sdb1")
+	mkdirs("md1/slaves/sda3", "md1/slaves/sdb3")
+	mkdirs("dm-0/slaves/md1", "dm-0/slaves/nvme0n1p1")
+	mkdirs("md2/slaves/sdc")
+
+	members, err := readDiskMembers(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []diskMember{
+		{device: "md1", array: "dm-0"},
+		{device: "nvme0n1", array: "dm-0"},
+		{device: "sda", array: "md1"},
+		{device: "sdb", array: "md1"},
+		{device: "sdc", array: "md2"},
+		{device: "sda", array: "md9"},
+		{device: "sdb", array: "md9"},
+	}, members)
+
+	e := &promExporter{ExporterConfig: ExporterConfig{Paths: Paths{SysFS: t.TempDir()}}, diskMembers: members[:1]}
+	metrics, err := e.getMdArrayMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, metric{name: "node_disk_member_of", attr: `device="md1",array="dm-0"`, value: 1, help: "Relationship between a device and the array or dm device it is a member of", metricType: "gauge"}, metrics[0])
+}
+
+func TestResolvePingSource(t *testing.T) {
+	testCases := map[string]struct {
+		source  string
+		ipv6    bool
+		want    string
+		wantErr string
+	}{
+		"address":           {source: "192.168.2.10", want: "192.168.2.10"},
+		"IPv6 address":      {source: "fd00::10", ipv6: true, want: "fd00::10"},
+		"address family":    {source: "192.168.2.10", ipv6: true, wantErr: "ping source 192.168.2.10 doesn't match the address family of the target"},
+		"interface":         {source: "lo", want: "127.0.0.1"},
+		"missing interface": {source: "eth9", wantErr: `ping source interface "eth9": route ip+net: no such network interface`},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			source, err := resolvePingSource(tc.source, tc.ipv6)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, source)
+		})
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	e := NewExporter(ExporterConfig{Logger: log.New(io.Discard, "", 0)}, &exporter.Status{}).(*promExporter)
+	defer e.Close()
+
+	e.collectors = []collector{
+		{name: "good", fetch: func() ([]metric, error) { return []metric{{name: "good_metric", value: 1}}, nil }},
+		{
+			name:  "ping",
+			fetch: func() ([]metric, error) { return nil, fmt.Errorf("listen ip4:icmp : socket: %w", syscall.EPERM) },
+		},
+		{
+			name:  "missing",
+			fetch: func() ([]metric, error) { return nil, nil },
+			check: func() []exporter.Prerequisite { return []exporter.Prerequisite{{Name: "flashcache statistics"}} },
+		},
+		{
+			name:    "disabled",
+			fetch:   func() ([]metric, error) { return nil, errors.New("boom") },
+			enabled: func() bool { return false },
+		},
+	}
+	assert.Nil(t, e.LastSelfCheck())
+
+	results := e.SelfCheck()
+
+	require.Len(t, results, 3)
+	assert.Equal(t, exporter.SelfCheckResult{Collector: "good", Samples: 1}, results[0])
+	assert.True(t, results[1].Failed())
+	assert.Contains(t, results[1].Hint, "CAP_NET_RAW")
+	assert.Equal(t, exporter.SelfCheckResult{Collector: "missing", Missing: []string{"flashcache statistics"}}, results[2])
+	assert.Equal(t, results, e.LastSelfCheck())
+}
+
+func TestSelfCheckHint(t *testing.T) {
+	testCases := map[string]struct {
+		collector string
+		err       error
+		want      string
+	}{
+		"command not in PATH": {collector: "hd", err: &exec.Error{Name: "getsysinfo", Err: exec.ErrNotFound}, want: "PATH"},
+		"missing file":        {collector: "meminfo", err: &os.PathError{Op: "open", Path: "/proc/meminfo", Err: syscall.ENOENT}, want: "--path.procfs"},
+		"upsd not listening":  {collector: "ups", err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}, want: "NUT upsd"},
+		"unknown":             {collector: "hd", err: errors.New("parse temperature"), want: ""},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			hint := selfCheckHint(tc.collector, tc.err)
+			if tc.want == "" {
+				assert.Empty(t, hint)
+			} else {
+				assert.Contains(t, hint, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package prometheus
+
+import (
+	"fmt"
+	"sort"
+)
+
+func (e *promExporter) getPushMetrics() ([]metric, error) {
+	stats := e.PushStats()
+	backends := make([]string, 0, len(stats))
+	for backend := range stats {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+
+	metrics := make([]metric, 0, 4*len(backends))
+	for _, backend := range backends {
+		attr := fmt.Sprintf("backend=%q", backend)
+		metrics = append(
+			metrics,
+			metric{
+				name:       "qnapexporter_push_batches_sent_total",
+				attr:       attr,
+				value:      float64(stats[backend].Sent),
+				help:       "Number of batches of samples pushed to the backend",
+				metricType: "counter",
+				created:    e.startTime,
+			},
+			metric{
+				name:       "qnapexporter_push_batches_dropped_total",
+				attr:       attr,
+				value:      float64(stats[backend].Dropped),
+				help:       "Number of batches of samples dropped after failing to push them to the backend",
+				metricType: "counter",
+				created:    e.startTime,
+			},
+			metric{
+				name:       "qnapexporter_push_samples_sent_total",
+				attr:       attr,
+				value:      float64(stats[backend].SamplesSent),
+				help:       "Number of samples pushed to the backend",
+				metricType: "counter",
+				created:    e.startTime,
+			},
+			metric{
+				name:       "qnapexporter_push_samples_dropped_total",
+				attr:       attr,
+				value:      float64(stats[backend].SamplesDropped),
+				help:       "Number of samples dropped after failing to push them to the backend",
+				metricType: "counter",
+				created:    e.startTime,
+			},
+		)
+	}
+
+	return metrics, nil
+}
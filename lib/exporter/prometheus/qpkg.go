@@ -0,0 +1,123 @@
+package prometheus
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+const (
+	// qpkgConfPath is the INI file listing the installed QNAP applications, relative to the root file system
+	qpkgConfPath = "etc/config/qpkg.conf"
+
+	maxQpkgVersionLength = 64
+)
+
+type qpkgInfo struct {
+	name, version string
+	enabled       bool
+	// installPath is the directory the application is installed in, e.g. /share/CACHEDEV1_DATA/.qpkg/MalwareRemover
+	installPath string
+}
+
+// readQpkgs reads the installed applications from the sections of the qpkg.conf file in path, e.g.
+//
+//	[container-station]
+//	Name = container-station
+//	Version = 2.6.3.445
+//	Enable = TRUE
+//	Install_Path = /share/CACHEDEV1_DATA/.qpkg/container-station
+func readQpkgs(path string) ([]qpkgInfo, error) {
+	lines, err := utils.ReadFileLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var qpkgs []qpkgInfo
+	seen := map[string]bool{}
+	var section string
+	fields := map[string]string{}
+	flush := func() {
+		if section == "" {
+			return
+		}
+		name := fields["name"]
+		if name == "" {
+			name = section
+		}
+		if !seen[name] {
+			seen[name] = true
+			qpkgs = append(qpkgs, qpkgInfo{
+				name:        name,
+				version:     sanitizeQpkgVersion(fields["version"]),
+				enabled:     strings.EqualFold(fields["enable"], "TRUE"),
+				installPath: fields["install_path"],
+			})
+		}
+		section, fields = "", map[string]string{}
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			flush()
+			section = strings.TrimSpace(line[1 : len(line)-1])
+		default:
+			tokens := strings.SplitN(line, "=", 2)
+			if len(tokens) != 2 {
+				continue
+			}
+			fields[strings.ToLower(strings.TrimSpace(tokens[0]))] = strings.Trim(strings.TrimSpace(tokens[1]), `"`)
+		}
+	}
+	flush()
+
+	return qpkgs, nil
+}
+
+// sanitizeQpkgVersion drops the non-printable characters of version, limiting its length to keep the label readable
+func sanitizeQpkgVersion(version string) string {
+	version = strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, version)
+	version = strings.TrimSpace(version)
+	if runes := []rune(version); len(runes) > maxQpkgVersionLength {
+		version = string(runes[:maxQpkgVersionLength])
+	}
+	if version == "" {
+		return "unknown"
+	}
+
+	return version
+}
+
+func (e *promExporter) getQpkgMetrics() ([]metric, error) {
+	metrics := make([]metric, 0, len(e.qpkgs))
+	for _, q := range e.qpkgs {
+		metrics = append(metrics, metric{
+			name:       "qnap_qpkg_info",
+			attr:       fmt.Sprintf("name=%q,version=%q,enabled=%q", q.name, q.version, fmt.Sprint(q.enabled)),
+			value:      1,
+			help:       "Installed QNAP application, with its version and whether it is enabled",
+			metricType: "gauge",
+		})
+	}
+
+	return metrics, nil
+}
+
+func (e *promExporter) checkQpkgs() []exporter.Prerequisite {
+	path := e.Paths.rootPath(qpkgConfPath)
+	_, err := os.Stat(path)
+
+	return []exporter.Prerequisite{{Name: "qpkg.conf", Found: err == nil, Detail: path}}
+}
@@ -0,0 +1,151 @@
+package prometheus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// allWeekdays is the mask of the days of a quiet hours range without days
+const allWeekdays = 1<<7 - 1
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// quietRange is a daily time range, in minutes since midnight, which crosses midnight if end isn't after start
+type quietRange struct {
+	// days is the mask of the weekdays the range starts on
+	days       uint8
+	start, end int
+}
+
+// QuietHours holds the time ranges during which the collectors which wake the disks up are paused, so that they can
+// stay in standby, e.g. overnight
+type QuietHours []quietRange
+
+// ParseQuietHours parses comma-separated time ranges in local time, optionally preceded by the weekday or the range
+// of weekdays they start on, e.g. "23:00-07:00" or "Mon-Fri 01:00-06:30,Sat-Sun 00:00-09:00". A range ending before
+// it starts crosses midnight, and one ending when it starts lasts the whole day.
+func ParseQuietHours(spec string) (QuietHours, error) {
+	var hours QuietHours
+	for _, entry := range strings.Split(spec, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		r := quietRange{days: allWeekdays}
+		var err error
+		switch len(fields) {
+		case 1:
+		case 2:
+			if r.days, err = parseWeekdays(fields[0]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("invalid quiet hours %q, expected [days] HH:MM-HH:MM", strings.TrimSpace(entry))
+		}
+
+		times := strings.Split(fields[len(fields)-1], "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid quiet hours %q, expected [days] HH:MM-HH:MM", strings.TrimSpace(entry))
+		}
+		if r.start, err = parseTimeOfDay(times[0]); err != nil {
+			return nil, err
+		}
+		if r.end, err = parseTimeOfDay(times[1]); err != nil {
+			return nil, err
+		}
+		hours = append(hours, r)
+	}
+
+	return hours, nil
+}
+
+// parseWeekdays parses a weekday or a range of weekdays (e.g. "Sat" or "Fri-Mon") into a mask
+func parseWeekdays(s string) (uint8, error) {
+	bounds := strings.Split(strings.ToLower(s), "-")
+	if len(bounds) > 2 {
+		return 0, fmt.Errorf("invalid weekdays %q", s)
+	}
+	first, ok := weekdayNames[bounds[0]]
+	if !ok {
+		return 0, fmt.Errorf("invalid weekday %q", bounds[0])
+	}
+	last := first
+	if len(bounds) == 2 {
+		if last, ok = weekdayNames[bounds[1]]; !ok {
+			return 0, fmt.Errorf("invalid weekday %q", bounds[1])
+		}
+	}
+
+	var days uint8
+	for d := first; ; d = (d + 1) % 7 {
+		days |= 1 << d
+		if d == last {
+			break
+		}
+	}
+
+	return days, nil
+}
+
+// parseTimeOfDay parses HH:MM into minutes since midnight, allowing 24:00
+func parseTimeOfDay(s string) (int, error) {
+	tokens := strings.Split(s, ":")
+	if len(tokens) == 2 {
+		h, errH := strconv.Atoi(tokens[0])
+		m, errM := strconv.Atoi(tokens[1])
+		if errH == nil && errM == nil && h >= 0 && m >= 0 && m < 60 && h*60+m <= 24*60 {
+			return h*60 + m, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+}
+
+// Contains returns whether t falls in one of the ranges
+func (q QuietHours) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := uint8(1) << t.Weekday()
+	yesterday := uint8(1) << ((t.Weekday() + 6) % 7)
+	for _, r := range q {
+		if r.start < r.end {
+			if r.days&today != 0 && minute >= r.start && minute < r.end {
+				return true
+			}
+			continue
+		}
+		if (r.days&today != 0 && minute >= r.start) || (r.days&yesterday != 0 && minute < r.end) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getPausedMetrics reports whether each enabled collector which wakes the disks up is paused by the quiet hours
+func (e *promExporter) getPausedMetrics() ([]metric, error) {
+	var metrics []metric
+	for _, c := range e.collectors {
+		if !c.wakesDisks || !c.Enabled() {
+			continue
+		}
+		value := 0.0
+		if e.quiet {
+			value = 1
+		}
+		metrics = append(metrics, metric{
+			name:       "node_collector_paused",
+			attr:       fmt.Sprintf("collector=%q", c.name),
+			value:      value,
+			help:       "Whether the collector is paused during the quiet hours, so that the disks can stay in standby",
+			metricType: "gauge",
+		})
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,190 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+const (
+	// DefaultQuotaTopUsers is the default value of QuotaConfig.TopUsers
+	DefaultQuotaTopUsers = 20
+	// DefaultQuotaInterval is the default value of QuotaConfig.Interval
+	DefaultQuotaInterval = 10 * time.Minute
+
+	repquotaReportPrefix = "*** Report for user quotas on device "
+)
+
+// QuotaConfig configures the collection of the user quota usage
+type QuotaConfig struct {
+	// Enabled enables the quota collector
+	Enabled bool
+	// TopUsers is the maximum number of users reported, by usage (DefaultQuotaTopUsers, if zero)
+	TopUsers int
+	// Interval is the time the quotas are cached for, since the commands reading them are slow (DefaultQuotaInterval, if zero)
+	Interval time.Duration
+}
+
+// quotaUsage holds the usage of a user on a volume, with a zero limit if unlimited
+type quotaUsage struct {
+	volume, user          string
+	usedBytes, limitBytes float64
+}
+
+// getQuotaMetrics reports the users using the most space, from the cached quotas if they haven't expired
+func (e *promExporter) getQuotaMetrics() ([]metric, error) {
+	if e.repquota == "" && e.zfs == "" {
+		return nil, nil
+	}
+
+	if e.quotaExpiry.IsZero() || time.Now().After(e.quotaExpiry) {
+		e.quotaExpiry = time.Now().Add(e.Quota.Interval)
+		e.quotaMetrics, e.quotaErr = e.readQuotaMetrics()
+	}
+
+	return e.quotaMetrics, e.quotaErr
+}
+
+func (e *promExporter) readQuotaMetrics() ([]metric, error) {
+	var usages []quotaUsage
+	var failures []string
+	if e.repquota != "" {
+		output, err := e.execCommand(e.repquota, "-a", "-u")
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("repquota: %v", err))
+		}
+		usages = append(usages, parseRepquota(output)...)
+	}
+	if e.zfs != "" {
+		zfsUsages, err := e.readZfsUserspace()
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+		usages = append(usages, zfsUsages...)
+	}
+
+	sort.SliceStable(usages, func(i, j int) bool {
+		return usages[i].usedBytes > usages[j].usedBytes
+	})
+	if len(usages) > e.Quota.TopUsers {
+		usages = usages[:e.Quota.TopUsers]
+	}
+
+	metrics := make([]metric, 0, 2*len(usages))
+	for _, u := range usages {
+		attr := fmt.Sprintf("volume=%q,user=%q", u.volume, u.user)
+		metrics = append(metrics, metric{
+			name:       "node_quota_used_bytes",
+			attr:       attr,
+			value:      u.usedBytes,
+			help:       "Space used by the user on the volume",
+			metricType: "gauge",
+		})
+		if u.limitBytes > 0 {
+			metrics = append(metrics, metric{
+				name:       "node_quota_limit_bytes",
+				attr:       attr,
+				value:      u.limitBytes,
+				help:       "Quota of the user on the volume",
+				metricType: "gauge",
+			})
+		}
+	}
+
+	if len(failures) > 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
+
+	return metrics, nil
+}
+
+// parseRepquota returns the users with a non-zero usage in the output of repquota -a -u, whose block counts are in KiB.
+// The limit is the hard limit, or the soft limit if there is no hard limit.
+func parseRepquota(output string) []quotaUsage {
+	var usages []quotaUsage
+	var volume string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, repquotaReportPrefix) {
+			volume = strings.TrimSpace(strings.TrimPrefix(line, repquotaReportPrefix))
+			continue
+		}
+
+		// e.g. "alice     +-  500000  400000  600000  6days     10     0     0"
+		fields := strings.Fields(line)
+		if volume == "" || len(fields) < 5 || len(fields[1]) != 2 || strings.Trim(fields[1], "+-") != "" {
+			continue
+		}
+		used, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || used == 0 {
+			continue
+		}
+		soft, _ := strconv.ParseFloat(fields[3], 64)
+		hard, _ := strconv.ParseFloat(fields[4], 64)
+		limit := hard
+		if limit == 0 {
+			limit = soft
+		}
+
+		usages = append(usages, quotaUsage{volume: volume, user: strings.TrimPrefix(fields[0], "#"), usedBytes: used * 1024, limitBytes: limit * 1024})
+	}
+
+	return usages
+}
+
+// readZfsUserspace returns the users with a non-zero usage of the ZFS file systems (e.g. the shares of QuTS hero)
+func (e *promExporter) readZfsUserspace() ([]quotaUsage, error) {
+	output, err := e.execCommand(e.zfs, "list", "-H", "-o", "name", "-t", "filesystem")
+	if err != nil {
+		return nil, fmt.Errorf("list ZFS file systems: %w", err)
+	}
+
+	var usages []quotaUsage
+	var failures []string
+	for _, dataset := range strings.Fields(output) {
+		output, err := e.execCommand(e.zfs, "userspace", "-H", "-p", "-o", "name,used,quota", dataset)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", dataset, err))
+			continue
+		}
+		usages = append(usages, parseZfsUserspace(dataset, output)...)
+	}
+
+	if len(failures) > 0 {
+		return usages, errors.New(strings.Join(failures, "; "))
+	}
+
+	return usages, nil
+}
+
+// parseZfsUserspace returns the users with a non-zero usage in the output of zfs userspace -H -p -o name,used,quota,
+// whose quota is "none" if unlimited
+func parseZfsUserspace(dataset, output string) []quotaUsage {
+	var usages []quotaUsage
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		used, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || used == 0 {
+			continue
+		}
+		limit, _ := strconv.ParseFloat(fields[2], 64)
+
+		usages = append(usages, quotaUsage{volume: dataset, user: fields[0], usedBytes: used, limitBytes: limit})
+	}
+
+	return usages
+}
+
+func (e *promExporter) checkQuota() []exporter.Prerequisite {
+	return []exporter.Prerequisite{
+		{Name: "repquota", Found: e.repquota != "", Detail: e.repquota},
+		{Name: "zfs", Found: e.zfs != "", Detail: e.zfs},
+	}
+}
@@ -0,0 +1,161 @@
+package prometheus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+type mdArray struct {
+	name      string
+	raidDisks int
+	degraded  int
+}
+
+// diskMember is an edge of the block device stack, from a device to the array or dm device built on top of it
+type diskMember struct {
+	device, array string
+}
+
+func (e *promExporter) getMdArrayMetrics() ([]metric, error) {
+	arrays, err := readMdArrays(e.Paths.sysPath(blockDir))
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]metric, 0, 2*len(arrays)+len(e.diskMembers))
+	for _, m := range e.diskMembers {
+		metrics = append(metrics, metric{
+			name:       "node_disk_member_of",
+			attr:       fmt.Sprintf("device=%q,array=%q", m.device, m.array),
+			value:      1,
+			help:       "Relationship between a device and the array or dm device it is a member of",
+			metricType: "gauge",
+		})
+	}
+	for _, a := range arrays {
+		e.trackMdArray(a)
+
+		attr := fmt.Sprintf("device=%q", a.name)
+		metrics = append(metrics,
+			metric{
+				name:       "node_md_disks",
+				attr:       attr,
+				value:      float64(a.raidDisks),
+				help:       "Number of member disks of the md array",
+				metricType: "gauge",
+			},
+			metric{
+				name:       "node_md_disks_degraded",
+				attr:       attr,
+				value:      float64(a.degraded),
+				help:       "Number of member disks missing from the md array",
+				metricType: "gauge",
+			},
+		)
+	}
+
+	return metrics, nil
+}
+
+// readMdArrays returns the md arrays found in dir (i.e. /sys/block), skipping those which aren't redundant
+func readMdArrays(dir string) ([]mdArray, error) {
+	mdDirs, err := filepath.Glob(filepath.Join(dir, "md*", "md"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(mdDirs)
+
+	arrays := make([]mdArray, 0, len(mdDirs))
+	for _, mdDir := range mdDirs {
+		a := mdArray{name: filepath.Base(filepath.Dir(mdDir))}
+
+		// Only redundant arrays (RAID 1, 5, 6, 10) report a degraded count
+		degraded, err := utils.ReadFile(filepath.Join(mdDir, "degraded"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			a.degraded, err = strconv.Atoi(degraded)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read md array %s degraded state: %w", a.name, err)
+		}
+		raidDisks, err := utils.ReadFile(filepath.Join(mdDir, "raid_disks"))
+		if err == nil {
+			a.raidDisks, _ = strconv.Atoi(raidDisks)
+		}
+
+		arrays = append(arrays, a)
+	}
+
+	return arrays, nil
+}
+
+// readDiskMembers returns the members of the md and dm devices found in dir (i.e. /sys/block), from their slaves,
+// with one edge per layer of the nested stacks (e.g. sda -> md1 -> dm-0). The partitions are reported as their disk,
+// so that the members have the device of their I/O counters.
+func readDiskMembers(dir string) ([]diskMember, error) {
+	slaveDirs, err := filepath.Glob(filepath.Join(dir, "*", "slaves", "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(slaveDirs)
+
+	var members []diskMember
+	seen := map[diskMember]bool{}
+	for _, slaveDir := range slaveDirs {
+		m := diskMember{
+			device: partitionDisk(dir, filepath.Base(slaveDir)),
+			array:  filepath.Base(filepath.Dir(filepath.Dir(slaveDir))),
+		}
+		if !seen[m] {
+			seen[m] = true
+			members = append(members, m)
+		}
+	}
+
+	return members, nil
+}
+
+// partitionDisk returns the disk holding the partition (e.g. sda for sda3), or dev itself if it isn't a partition
+func partitionDisk(dir, dev string) string {
+	if _, err := os.Stat(filepath.Join(dir, dev)); err == nil {
+		return dev
+	}
+	disks, _ := filepath.Glob(filepath.Join(dir, "*", dev))
+	if len(disks) == 0 {
+		return dev
+	}
+
+	return filepath.Base(filepath.Dir(disks[0]))
+}
+
+// trackMdArray annotates md arrays dropping members as regions, lasting until the array is whole again
+// (e.g. "[storage] RAID md1 degraded")
+func (e *promExporter) trackMdArray(a mdArray) {
+	if e.Annotator == nil || !e.StorageAnnotations {
+		return
+	}
+
+	state := "clean"
+	if a.degraded > 0 {
+		state = "degraded"
+	}
+	previous, changed := e.mdArrayState.update(a.name, state)
+	if !changed {
+		return
+	}
+
+	e.Logger.Printf("md array %s changed from %s to %s (%d of %d members missing)\n", a.name, previous, state, a.degraded, a.raidDisks)
+	e.annotate(notifications.Annotation{
+		Text: fmt.Sprintf("RAID %s degraded", a.name),
+		Tags: []string{"storage"},
+		End:  state == "clean",
+	})
+}
@@ -0,0 +1,118 @@
+package prometheus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+)
+
+const (
+	// DefaultScrapeBudget is the default value of ScrapeDurationConfig.Budget
+	DefaultScrapeBudget = 0.8
+	// DefaultScrapeWarningScrapes is the default value of ScrapeDurationConfig.Scrapes
+	DefaultScrapeWarningScrapes = 3
+
+	// scrapeBudgetHysteresis is the fraction of the timeout the scrapes must drop under the budget by to clear the
+	// warning, so that scrapes hovering around the budget don't flap it
+	scrapeBudgetHysteresis = 0.1
+)
+
+// ScrapeDurationConfig configures the warning raised when the scrapes approach the scrape timeout of Prometheus, e.g.
+// as disks are added
+type ScrapeDurationConfig struct {
+	// Timeout is the scrape timeout configured in Prometheus (never warns, if zero)
+	Timeout time.Duration
+	// Budget is the fraction of Timeout above which a scrape is slow (DefaultScrapeBudget, if zero)
+	Budget float64
+	// Scrapes is the number of consecutive slow scrapes which raise the warning, and of fast ones which clear it
+	// (DefaultScrapeWarningScrapes, if zero)
+	Scrapes int
+}
+
+// withDefaults returns the configuration, with the default budget and number of scrapes if unset
+func (c ScrapeDurationConfig) withDefaults() ScrapeDurationConfig {
+	if c.Budget <= 0 {
+		c.Budget = DefaultScrapeBudget
+	}
+	if c.Scrapes <= 0 {
+		c.Scrapes = DefaultScrapeWarningScrapes
+	}
+
+	return c
+}
+
+// scrapeDurationWatcher counts the consecutive slow and fast scrapes
+type scrapeDurationWatcher struct {
+	mu      sync.Mutex
+	slow    int
+	fast    int
+	warning bool
+}
+
+// observe records the duration of a scrape, returning whether the warning was raised or cleared by it
+func (w *scrapeDurationWatcher) observe(c ScrapeDurationConfig, d time.Duration) (raised, cleared bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	budget := time.Duration(c.Budget * float64(c.Timeout))
+	recovery := budget - time.Duration(scrapeBudgetHysteresis*float64(c.Timeout))
+	switch {
+	case d > budget:
+		w.slow, w.fast = w.slow+1, 0
+	case d < recovery:
+		w.slow, w.fast = 0, w.fast+1
+	default:
+		// Between the recovery threshold and the budget, the warning stays as it is
+		w.slow, w.fast = 0, 0
+	}
+
+	switch {
+	case !w.warning && w.slow >= c.Scrapes:
+		w.warning = true
+		return true, false
+	case w.warning && w.fast >= c.Scrapes:
+		w.warning = false
+		return false, true
+	}
+
+	return false, false
+}
+
+// recordScrapeDuration warns when the scrapes keep taking longer than the budget, until they are back well under it
+func (e *promExporter) recordScrapeDuration(d time.Duration) {
+	if e.ScrapeDuration.Timeout <= 0 {
+		return
+	}
+
+	raised, cleared := e.scrapeDuration.observe(e.ScrapeDuration, d)
+	switch {
+	case raised:
+		text := fmt.Sprintf("Scrapes taking %.1fs, approaching the timeout of %v", d.Seconds(), e.ScrapeDuration.Timeout)
+		e.Logger.Println(text)
+		e.annotate(notifications.Annotation{Text: text, Tags: []string{"exporter"}})
+	case cleared:
+		e.Logger.Printf("Scrapes back to %.1fs, well within the timeout of %v\n", d.Seconds(), e.ScrapeDuration.Timeout)
+	}
+}
+
+func (e *promExporter) getScrapeDurationMetrics() ([]metric, error) {
+	e.scrapeDuration.mu.Lock()
+	warning := e.scrapeDuration.warning
+	e.scrapeDuration.mu.Unlock()
+
+	value := 0.0
+	if warning {
+		value = 1
+	}
+
+	return []metric{
+		{
+			name:       "node_scrape_duration_warning",
+			value:      value,
+			help:       "Whether the recent scrapes took longer than the share of the scrape timeout they are budgeted",
+			metricType: "gauge",
+		},
+	}, nil
+}
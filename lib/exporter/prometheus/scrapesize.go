@@ -0,0 +1,56 @@
+package prometheus
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// recordScrapeSize keeps the size of the scrape just written out, reported by the next scrape. Exceeding the
+// configured number of series is logged once, since it usually means a label got too many values (e.g. veth interfaces).
+func (e *promExporter) recordScrapeSize(samples int, bytes int64) {
+	atomic.StoreUint64(&e.scrapeSamples, uint64(samples))
+	atomic.StoreUint64(&e.scrapeBytes, uint64(bytes))
+
+	if e.SeriesWarningThreshold > 0 && samples > e.SeriesWarningThreshold {
+		e.seriesWarning.Do(func() {
+			e.Logger.Printf("Scrape exported %d series, more than the threshold of %d: check the labels for a cardinality issue\n",
+				samples, e.SeriesWarningThreshold)
+		})
+	}
+}
+
+func (e *promExporter) getScrapeSizeMetrics() ([]metric, error) {
+	bytes := atomic.LoadUint64(&e.scrapeBytes)
+	if bytes == 0 {
+		// Nothing has been written out yet
+		return nil, nil
+	}
+
+	return []metric{
+		{
+			name:       "qnapexporter_scrape_samples",
+			value:      float64(atomic.LoadUint64(&e.scrapeSamples)),
+			help:       "Number of samples exported by the previous scrape",
+			metricType: "gauge",
+		},
+		{
+			name:       "qnapexporter_scrape_response_bytes",
+			value:      float64(bytes),
+			help:       "Size of the response to the previous scrape",
+			metricType: "gauge",
+		},
+	}, nil
+}
@@ -0,0 +1,73 @@
+package prometheus
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+// SelfCheck reads the environment if needed, then runs each enabled collector once, e.g. to report on startup the
+// collectors which can't work in this deployment
+func (e *promExporter) SelfCheck() []exporter.SelfCheckResult {
+	e.fetchMu.Lock()
+	defer e.fetchMu.Unlock()
+
+	e.refreshEnvironment()
+
+	results := make([]exporter.SelfCheckResult, 0, len(e.collectors))
+	for _, c := range e.collectors {
+		if !c.Enabled() {
+			continue
+		}
+
+		metrics, err := c.Collect()
+		r := exporter.SelfCheckResult{Collector: c.name, Samples: len(metrics)}
+		for _, p := range c.Check() {
+			if !p.Found {
+				r.Missing = append(r.Missing, p.Name)
+			}
+		}
+		if err != nil {
+			r.Error = err.Error()
+			r.Hint = selfCheckHint(c.name, err)
+		}
+		results = append(results, r)
+	}
+
+	e.envMu.Lock()
+	e.selfCheck = results
+	e.envMu.Unlock()
+
+	return results
+}
+
+// LastSelfCheck returns the outcomes of the last self-check, or nil if none has completed yet
+func (e *promExporter) LastSelfCheck() []exporter.SelfCheckResult {
+	e.envMu.Lock()
+	defer e.envMu.Unlock()
+
+	return e.selfCheck
+}
+
+// selfCheckHint suggests how to fix the usual causes of the failure of a collector, or returns an empty string
+func selfCheckHint(collector string, err error) string {
+	msg := err.Error()
+	switch {
+	case collector == "ping" && (errors.Is(err, os.ErrPermission) || strings.Contains(msg, "operation not permitted")):
+		return "the pings are sent from a raw socket: grant the CAP_NET_RAW capability (e.g. docker run --cap-add NET_RAW) or run as root"
+	case errors.Is(err, exec.ErrNotFound) || strings.Contains(msg, "executable file not found"):
+		return "the command isn't in the PATH: when running in a container, mount it from the host (e.g. /sbin/getsysinfo) and add its directory to the PATH"
+	case errors.Is(err, os.ErrNotExist) || strings.Contains(msg, "no such file or directory"):
+		return "the file doesn't exist: when running in a container, mount the host's /proc and /sys and point --path.procfs and --path.sysfs to them"
+	case errors.Is(err, os.ErrPermission) || strings.Contains(msg, "permission denied"):
+		return "the exporter isn't allowed to read it: run it as root, or as a privileged container"
+	case collector == "ups" && (errors.Is(err, syscall.ECONNREFUSED) || strings.Contains(msg, "connection refused")):
+		return "NUT upsd isn't listening on " + upsdHost + ":" + upsdPort + ": enable the network UPS server in the QTS UPS settings"
+	}
+
+	return ""
+}
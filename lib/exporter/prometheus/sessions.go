@@ -0,0 +1,122 @@
+package prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+const (
+	// utmpPath is the file recording the current logins, relative to the root file system
+	utmpPath = "var/run/utmp"
+
+	// utmpUserProcess is the type of the utmp records of logged-in users
+	utmpUserProcess = 7
+)
+
+// utmpRecord is the layout of the glibc utmp records. glibc keeps 32-bit times in utmp on both the 32- and 64-bit
+// x86 and ARM builds, so the 384-byte layout is the same on all of them (in little-endian byte order).
+type utmpRecord struct {
+	Type    int16
+	_       [2]byte
+	Pid     int32
+	Line    [32]byte
+	ID      [4]byte
+	User    [32]byte
+	Host    [256]byte
+	Exit    [2]int16
+	Session int32
+	Tv      [2]int32
+	AddrV6  [4]int32
+	_       [20]byte
+}
+
+// getSessionMetrics reports the number of logged-in users (e.g. over SSH), from utmp, or from who if there is no utmp
+func (e *promExporter) getSessionMetrics() ([]metric, error) {
+	users, err := countUtmpUsers(e.Paths.rootPath(utmpPath))
+	if errors.Is(err, os.ErrNotExist) {
+		who, lookErr := exec.LookPath("who")
+		if lookErr != nil {
+			return nil, nil
+		}
+		users, err = e.countWhoUsers(who)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []metric{
+		{
+			name:       "node_logged_in_users",
+			value:      float64(users),
+			help:       "Number of sessions of logged-in users, e.g. over SSH",
+			metricType: "gauge",
+		},
+	}, nil
+}
+
+// countUtmpUsers returns the number of user process records in the utmp file in path
+func countUtmpUsers(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	users := 0
+	for {
+		var record utmpRecord
+		err := binary.Read(r, binary.LittleEndian, &record)
+		switch {
+		case errors.Is(err, io.EOF):
+			return users, nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return 0, fmt.Errorf("read %s: truncated record, expected %d bytes per record", path, binary.Size(record))
+		case err != nil:
+			return 0, fmt.Errorf("read %s: %w", path, err)
+		}
+
+		if record.Type == utmpUserProcess && len(bytes.TrimRight(record.User[:], "\x00")) > 0 {
+			users++
+		}
+	}
+}
+
+// countWhoUsers returns the number of sessions listed by who
+func (e *promExporter) countWhoUsers(who string) (int, error) {
+	output, err := e.execCommand(who)
+	if err != nil {
+		return 0, err
+	}
+
+	users := 0
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) != "" {
+			users++
+		}
+	}
+
+	return users, nil
+}
+
+func (e *promExporter) checkSessions() []exporter.Prerequisite {
+	path := e.Paths.rootPath(utmpPath)
+	if _, err := os.Stat(path); err == nil {
+		return []exporter.Prerequisite{{Name: "utmp", Found: true, Detail: path}}
+	}
+	who, _ := exec.LookPath("who")
+
+	return []exporter.Prerequisite{
+		{Name: "utmp", Detail: path},
+		{Name: "who", Found: who != "", Detail: who},
+	}
+}
@@ -0,0 +1,92 @@
+package prometheus
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// staleCache holds the metrics of the last scrape in which every collector succeeded
+type staleCache struct {
+	mu      sync.Mutex
+	metrics []metric
+	time    time.Time
+}
+
+func (c *staleCache) set(metrics []metric, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.metrics, c.time = metrics, t
+}
+
+// get returns the cached metrics, if they were collected less than maxAge ago
+func (c *staleCache) get(maxAge time.Duration) ([]metric, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.metrics == nil || time.Since(c.time) > maxAge {
+		return nil, time.Time{}, false
+	}
+
+	return c.metrics, c.time, true
+}
+
+// WriteMetricsWithin writes out the metrics like WriteMetrics. If serving stale metrics is enabled and the scrape
+// doesn't complete within timeout, the metrics of the last successful scrape are written instead, with their original
+// timestamps, as long as they are recent enough. The scrape carries on in the background to refresh the cache.
+func (e *promExporter) WriteMetricsWithin(w io.Writer, timeout time.Duration) error {
+	if e.StaleMaxAge <= 0 || timeout <= 0 {
+		return e.WriteMetrics(w)
+	}
+
+	var buf bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- e.WriteMetrics(&buf) }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		_, _ = w.Write(buf.Bytes())
+		e.writeServingStale(w, false)
+		return err
+	case <-timer.C:
+	}
+
+	metrics, t, ok := e.stale.get(e.StaleMaxAge)
+	if !ok {
+		// Nothing recent enough to serve, so wait for the scrape after all
+		err := <-done
+		_, _ = w.Write(buf.Bytes())
+		e.writeServingStale(w, false)
+		return err
+	}
+
+	e.Logger.Printf("Scrape didn't complete within %v, serving the metrics collected at %v\n", timeout, t.Format(time.RFC3339))
+	stale := make([]metric, len(metrics))
+	for i, m := range metrics {
+		if m.timestamp.IsZero() {
+			m.timestamp = t
+		}
+		stale[i] = m
+	}
+	e.writeMetrics(w, stale, map[string]bool{})
+	e.writeServingStale(w, true)
+
+	return nil
+}
+
+// writeServingStale writes out whether the metrics served are those of a previous scrape
+func (e *promExporter) writeServingStale(w io.Writer, stale bool) {
+	m := metric{
+		name:       "qnapexporter_serving_stale",
+		help:       "Whether the metrics served are those of a previous scrape, since the current one timed out",
+		metricType: "gauge",
+	}
+	if stale {
+		m.value = 1
+	}
+	e.writeMetrics(w, []metric{m}, map[string]bool{})
+}
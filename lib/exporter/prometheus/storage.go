@@ -0,0 +1,230 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+// DefaultStoragePoolInterval is the default value of ExporterConfig.StoragePoolInterval
+const DefaultStoragePoolInterval = 10 * time.Minute
+
+// qcliTable holds the rows of a table printed by qcli_storage, as cells by normalized column name
+type qcliTable []map[string]string
+
+// get returns the cell of the row in the first of the columns found, e.g. "Used" or "Used Size"
+func (t qcliTable) get(row int, columns ...string) string {
+	for _, column := range columns {
+		if value, ok := t[row][normalizeQcliColumn(column)]; ok {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// getStoragePoolMetrics reports the storage pools and RAID groups, from the cached metrics if they haven't expired
+func (e *promExporter) getStoragePoolMetrics() ([]metric, error) {
+	// The volume collector reports the getsysinfo volumes on the systems without qcli_storage
+	if e.qcliStorage == "" {
+		return nil, nil
+	}
+
+	if e.storageExpiry.IsZero() || time.Now().After(e.storageExpiry) {
+		e.storageExpiry = time.Now().Add(e.StoragePoolInterval)
+		e.storageMetrics, e.storageErr = e.readStoragePoolMetrics()
+	}
+
+	return e.storageMetrics, e.storageErr
+}
+
+func (e *promExporter) readStoragePoolMetrics() ([]metric, error) {
+	var metrics []metric
+	var failures []string
+
+	output, err := e.execCommand(e.qcliStorage, "-p")
+	if err == nil {
+		var poolMetrics []metric
+		poolMetrics, err = parseStoragePools(parseQcliTable(output))
+		metrics = append(metrics, poolMetrics...)
+	}
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("storage pools: %v", err))
+	}
+
+	output, err = e.execCommand(e.qcliStorage, "-d")
+	if err == nil {
+		metrics = append(metrics, parseRaidGroups(parseQcliTable(output))...)
+	} else {
+		failures = append(failures, fmt.Sprintf("RAID groups: %v", err))
+	}
+
+	if len(failures) > 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
+
+	return metrics, nil
+}
+
+// parseStoragePools returns the metrics of the pools listed by qcli_storage -p, computing the used space from the
+// free space if it isn't listed
+func parseStoragePools(table qcliTable) ([]metric, error) {
+	metrics := make([]metric, 0, 3*len(table))
+	var failures []string
+	for row := range table {
+		pool := table.get(row, "Pool ID", "Pool")
+		if pool == "" {
+			continue
+		}
+		status := normalizeVolumeStatus(table.get(row, "Status"))
+		metrics = append(metrics, statusMetric("qnap_pool_status", fmt.Sprintf("pool=%q,status=%q", pool, status), status, "Whether the storage pool is ready, with its status as a label"))
+
+		size, err := parseVolSize(table.get(row, "Capacity", "Size"))
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("pool %s: %v", pool, err))
+			continue
+		}
+		var used float64
+		if usedStr := table.get(row, "Used", "Used Size"); usedStr != "" {
+			used, err = parseVolSize(usedStr)
+		} else {
+			var free float64
+			free, err = parseVolSize(table.get(row, "Free", "Free Size"))
+			used = size - free
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("pool %s: %v", pool, err))
+			continue
+		}
+
+		attr := fmt.Sprintf("pool=%q", pool)
+		metrics = append(metrics,
+			metric{
+				name:       "qnap_pool_size_bytes",
+				attr:       attr,
+				value:      size,
+				help:       "Total size of the storage pool in bytes",
+				metricType: "gauge",
+			},
+			metric{
+				name:       "qnap_pool_used_bytes",
+				attr:       attr,
+				value:      used,
+				help:       "Space allocated in the storage pool in bytes",
+				metricType: "gauge",
+			},
+		)
+	}
+
+	if len(failures) > 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
+	}
+
+	return metrics, nil
+}
+
+// parseRaidGroups returns the status of the RAID groups listed by qcli_storage -d
+func parseRaidGroups(table qcliTable) []metric {
+	metrics := make([]metric, 0, len(table))
+	for row := range table {
+		group := table.get(row, "RAID Group", "RAID")
+		if group == "" {
+			continue
+		}
+		status := normalizeVolumeStatus(table.get(row, "Status"))
+		attr := fmt.Sprintf("raid_group=%q,pool=%q,level=%q,status=%q", group, table.get(row, "Pool ID", "Pool"), table.get(row, "RAID Level", "Level"), status)
+		metrics = append(metrics, statusMetric("qnap_raid_group_status", attr, status, "Whether the RAID group is ready, with its status as a label"))
+	}
+
+	return metrics
+}
+
+// statusMetric returns a gauge which is 1 if the status is ready, and 0 otherwise
+func statusMetric(name, attr, status, help string) metric {
+	value := 0.0
+	if status == volumeReadyStatus {
+		value = 1
+	}
+
+	return metric{name: name, attr: attr, value: value, help: help, metricType: "gauge"}
+}
+
+// parseQcliTable parses a table printed by qcli_storage. The columns are found in the header, the first non-empty
+// line, as the runs of words separated by at least two spaces, so that their widths needn't be known: each cell
+// starts at the offset of its column, or after the end of a longer value of the previous column, which is
+// followed by two spaces too.
+func parseQcliTable(output string) qcliTable {
+	lines := strings.Split(strings.ReplaceAll(output, "\t", "    "), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	header := lines[0]
+	var names []string
+	var offsets []int
+	for i := 0; i < len(header); {
+		if header[i] == ' ' {
+			i++
+			continue
+		}
+		end := strings.Index(header[i:], "  ")
+		if end < 0 {
+			end = len(header)
+		} else {
+			end += i
+		}
+		names = append(names, normalizeQcliColumn(header[i:end]))
+		offsets = append(offsets, i)
+		i = end
+	}
+
+	var table qcliTable
+	for _, line := range lines[1:] {
+		// Skip the blank lines and the separators, e.g. "-----"
+		if strings.Trim(line, " -=") == "" {
+			continue
+		}
+
+		row := make(map[string]string, len(names))
+		start := offsets[0]
+		for col, name := range names {
+			end := len(line)
+			if col+1 < len(offsets) && offsets[col+1] < len(line) {
+				end = offsets[col+1]
+				// A longer value spills over the next column, up to the next gap of two spaces
+				if line[end-1] != ' ' && line[end] != ' ' {
+					if gap := strings.Index(line[end:], "  "); gap >= 0 {
+						end += gap
+					} else {
+						end = len(line)
+					}
+				}
+			}
+			if start >= end {
+				row[name] = ""
+				continue
+			}
+			row[name] = strings.TrimSpace(line[start:end])
+			start = end
+		}
+		table = append(table, row)
+	}
+
+	return table
+}
+
+func (e *promExporter) checkQcliStorage() []exporter.Prerequisite {
+	return []exporter.Prerequisite{{Name: "qcli_storage", Found: e.qcliStorage != "", Detail: e.qcliStorage}}
+}
+
+// normalizeQcliColumn returns the column name in lower case without spaces and underscores, since they differ
+// between the QTS versions (e.g. "Pool ID" or "poolID")
+func normalizeQcliColumn(name string) string {
+	return strings.NewReplacer(" ", "", "_", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
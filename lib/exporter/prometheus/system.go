@@ -1,18 +1,28 @@
 package prometheus
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pedropombeiro/qnapexporter/lib/utils"
+	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/host"
 	"github.com/shirou/gopsutil/v3/load"
 )
 
 var fanRpmRe = regexp.MustCompile(`(?m)fan = (\d+) rpm`)
 
+// temperatureRe matches the temperatures printed by getsysinfo, with their unit if any (e.g. "45 C", "113 F" or "45°C")
+var temperatureRe = regexp.MustCompile(`(-?\d+(?:[.,]\d+)?)\s*(?:°|º)?\s*([CcFf])?\b`)
+
+// fanHelp describes node_sysfan_RPM, reported by both the system and enclosure fan collectors
+const fanHelp = "Fan speed in revolutions per minute"
+
 func getUptimeMetrics() ([]metric, error) {
 	u, err := host.Uptime()
 	if err != nil {
@@ -29,49 +39,143 @@ func getUptimeMetrics() ([]metric, error) {
 	}, err
 }
 
-func getLoadAvgMetrics() ([]metric, error) {
+func (e *promExporter) getLoadAvgMetrics() ([]metric, error) {
 	s, err := load.Avg()
 	if err != nil {
 		return nil, err
 	}
 
 	metrics := []metric{
-		{name: "node_load1", value: s.Load1},
-		{name: "node_load5", value: s.Load5},
-		{name: "node_load15", value: s.Load15},
+		{name: "node_load1", value: s.Load1, help: "1m load average", metricType: "gauge"},
+		{name: "node_load5", value: s.Load5, help: "5m load average", metricType: "gauge"},
+		{name: "node_load15", value: s.Load15, help: "15m load average", metricType: "gauge"},
+	}
+	// The load average per CPU allows a single alert threshold across models with different core counts
+	if e.LoadPerCPU && e.cpuCount > 0 {
+		count := float64(e.cpuCount)
+		metrics = append(metrics,
+			metric{name: "node_load1_per_cpu", value: s.Load1 / count, help: "1m load average divided by the number of logical CPUs", metricType: "gauge"},
+			metric{name: "node_load5_per_cpu", value: s.Load5 / count, help: "5m load average divided by the number of logical CPUs", metricType: "gauge"},
+			metric{name: "node_load15_per_cpu", value: s.Load15 / count, help: "15m load average divided by the number of logical CPUs", metricType: "gauge"},
+		)
 	}
+
 	return metrics, nil
 }
 
+// readCPUCount returns the number of logical CPUs of the host, from /proc/cpuinfo or else from the runtime
+func readCPUCount() int {
+	if count, err := cpu.Counts(true); err == nil && count > 0 {
+		return count
+	}
+
+	return runtime.NumCPU()
+}
+
+// sysTempHelp describes the system temperatures, by getsysinfo device
+var sysTempHelp = map[string]string{"cputmp": "CPU temperature in degrees Celsius", "systmp": "System temperature in degrees Celsius"}
+
+// sysTempMetric watches the temperature of the getsysinfo device (cputmp or systmp), returning its metric
+func (e *promExporter) sysTempMetric(dev string, value float64) metric {
+	if dev == "cputmp" {
+		e.watchTemperature(thermalClassCPU, "CPU", value, time.Now())
+	} else {
+		e.watchTemperature(thermalClassSystem, "System", value, time.Now())
+	}
+
+	return metric{
+		name:       fmt.Sprintf("node_%s_C", dev),
+		value:      value,
+		help:       sysTempHelp[dev],
+		metricType: "gauge",
+	}
+}
+
+// sysFanMetric returns the metric of the speed of a fan of the system or of an enclosure
+func sysFanMetric(fan, fanType string, value float64) metric {
+	return metric{
+		name:       "node_sysfan_RPM",
+		attr:       fmt.Sprintf(`fan=%q,type=%q`, fan, fanType),
+		value:      value,
+		help:       fanHelp,
+		metricType: "gauge",
+	}
+}
+
+// parseTemperature parses a temperature printed by getsysinfo into degrees Celsius. Depending on the firmware, it is
+// printed in both units in either order (e.g. "45 C/113 F" or "113 F/45 C"), with a degree sign (e.g. "45°C"), or as a
+// bare number, taken as Celsius. A temperature only printed in Fahrenheit is converted.
+func parseTemperature(output string) (float64, error) {
+	var fahrenheit, bare string
+	for _, m := range temperatureRe.FindAllStringSubmatch(output, -1) {
+		switch strings.ToUpper(m[2]) {
+		case "C":
+			return utils.ParseFloat(m[1])
+		case "F":
+			if fahrenheit == "" {
+				fahrenheit = m[1]
+			}
+		default:
+			if bare == "" {
+				bare = m[1]
+			}
+		}
+	}
+
+	switch {
+	case fahrenheit != "":
+		value, err := utils.ParseFloat(fahrenheit)
+		if err != nil {
+			return 0, err
+		}
+		return (value - 32) * 5 / 9, nil
+	case bare != "":
+		return utils.ParseFloat(bare)
+	}
+
+	return 0, fmt.Errorf("parse temperature %q", output)
+}
+
 func (e *promExporter) getSysInfoTempMetrics() ([]metric, error) {
+	if e.halAppSensors() {
+		return e.getHalAppTempMetrics()
+	}
 	if e.getsysinfo == "" {
 		return nil, nil
 	}
 
 	metrics := make([]metric, 0, 2)
+	var failures []string
 
 	for _, dev := range []string{"cputmp", "systmp"} {
-		output, err := utils.ExecCommand(e.getsysinfo, dev)
+		output, err := e.execCommand(e.getsysinfo, dev)
 		if err != nil {
 			return nil, err
 		}
 
-		tokens := strings.SplitN(output, " ", 2)
-		value, err := strconv.ParseFloat(tokens[0], 64)
+		value, err := parseTemperature(output)
 		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", dev, err))
 			continue
 		}
+		metrics = append(metrics, e.sysTempMetric(dev, value))
+	}
 
-		metrics = append(metrics, metric{
-			name:  fmt.Sprintf("node_%s_C", dev),
-			value: value,
-		})
+	if len(failures) != 0 {
+		return metrics, errors.New(strings.Join(failures, "; "))
 	}
 
 	return metrics, nil
 }
 
 func (e *promExporter) getSysInfoFanMetrics() ([]metric, error) {
+	// The count is negative if it couldn't be read, which is reported by the readiness of the exporter
+	if e.sysfannum < 0 {
+		return nil, nil
+	}
+	if e.halAppSensors() {
+		return e.getHalAppFanMetrics()
+	}
 	if e.getsysinfo == "" {
 		return nil, nil
 	}
@@ -81,7 +185,7 @@ func (e *promExporter) getSysInfoFanMetrics() ([]metric, error) {
 	for fannum := 1; fannum <= e.sysfannum; fannum++ {
 		fannumStr := strconv.Itoa(fannum)
 
-		fanStr, err := utils.ExecCommand(e.getsysinfo, "sysfan", fannumStr)
+		fanStr, err := e.execCommand(e.getsysinfo, "sysfan", fannumStr)
 		if err != nil {
 			return nil, err
 		}
@@ -90,11 +194,7 @@ func (e *promExporter) getSysInfoFanMetrics() ([]metric, error) {
 		if err != nil {
 			return nil, err
 		}
-		metrics = append(metrics, metric{
-			name:  "node_sysfan_RPM",
-			attr:  fmt.Sprintf(`fan=%q,type="System"`, fannumStr),
-			value: fan,
-		})
+		metrics = append(metrics, sysFanMetric(fannumStr, "System", fan))
 	}
 
 	return metrics, nil
@@ -109,7 +209,7 @@ func (e *promExporter) getEnclosureFanMetrics() ([]metric, error) {
 
 	for _, enc := range e.enclosures {
 		for fanNum := 0; fanNum < enc.fanCount; fanNum++ {
-			fanOutput, err := utils.ExecCommand(e.hal_app, "--se_sys_get_fan", fmt.Sprintf("enc_sys_id=%s,obj_index=%d", enc.id, fanNum))
+			fanOutput, err := e.execCommand(e.hal_app, "--se_sys_get_fan", fmt.Sprintf("enc_sys_id=%s,obj_index=%d", enc.id, fanNum))
 			if err != nil {
 				return nil, err
 			}
@@ -123,11 +223,7 @@ func (e *promExporter) getEnclosureFanMetrics() ([]metric, error) {
 			if err != nil {
 				return nil, err
 			}
-			metrics = append(metrics, metric{
-				name:  "node_sysfan_RPM",
-				attr:  fmt.Sprintf(`fan="%d",type=%q`, 1+fanNum, enc.name),
-				value: fan,
-			})
+			metrics = append(metrics, sysFanMetric(strconv.Itoa(1+fanNum), enc.name, fan))
 		}
 	}
 
@@ -0,0 +1,150 @@
+package prometheus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+)
+
+const (
+	// DefaultThermalDuration is the time a temperature must stay above its threshold before it is annotated
+	DefaultThermalDuration = 5 * time.Minute
+
+	// thermalHysteresis is the drop below the threshold required to close a thermal region
+	thermalHysteresis = 2
+
+	thermalClassCPU    = "cpu"
+	thermalClassSystem = "system"
+	thermalClassDisk   = "hd"
+)
+
+// defaultThermalThresholds holds the thresholds used for the classes listed without a temperature
+var defaultThermalThresholds = map[string]float64{
+	thermalClassCPU:    80,
+	thermalClassSystem: 55,
+	thermalClassDisk:   50,
+}
+
+// ThermalConfig holds the temperature thresholds (in °C) of each sensor class, above which a region is annotated.
+// A zero threshold disables the class.
+type ThermalConfig struct {
+	CPU    float64
+	System float64
+	Disk   float64
+	// Duration is the time a temperature must stay above its threshold before it is annotated
+	Duration time.Duration
+}
+
+// Enabled returns whether any threshold is set
+func (c ThermalConfig) Enabled() bool {
+	return c.CPU > 0 || c.System > 0 || c.Disk > 0
+}
+
+// ParseThermalThresholds parses comma-separated thresholds per sensor class (cpu, system or hd), e.g. "cpu=85,hd=45".
+// Classes listed without a temperature use a default threshold (cpu=80, system=55, hd=50).
+func ParseThermalThresholds(s string) (ThermalConfig, error) {
+	var config ThermalConfig
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		tokens := strings.SplitN(field, "=", 2)
+		class := strings.ToLower(strings.TrimSpace(tokens[0]))
+		threshold, ok := defaultThermalThresholds[class]
+		if !ok {
+			return ThermalConfig{}, fmt.Errorf("unknown sensor class %q (expected cpu, system or hd)", class)
+		}
+		if len(tokens) == 2 {
+			var err error
+			threshold, err = strconv.ParseFloat(strings.TrimSpace(tokens[1]), 64)
+			if err != nil || threshold <= 0 {
+				return ThermalConfig{}, fmt.Errorf("invalid %s temperature threshold %q", class, tokens[1])
+			}
+		}
+
+		switch class {
+		case thermalClassCPU:
+			config.CPU = threshold
+		case thermalClassSystem:
+			config.System = threshold
+		case thermalClassDisk:
+			config.Disk = threshold
+		}
+	}
+
+	return config, nil
+}
+
+type thermalState struct {
+	// since is the time of the first of the consecutive readings above the threshold
+	since time.Time
+	// active is set while the region is open
+	active bool
+}
+
+type thermalWatcher struct {
+	mu     sync.Mutex
+	states map[string]*thermalState
+}
+
+func (c ThermalConfig) threshold(class string) float64 {
+	switch class {
+	case thermalClassCPU:
+		return c.CPU
+	case thermalClassSystem:
+		return c.System
+	case thermalClassDisk:
+		return c.Disk
+	default:
+		return 0
+	}
+}
+
+// watchTemperature annotates a region while the temperature of sensor stays above the threshold of its class.
+// The region opens once the temperature has been above the threshold for the configured duration,
+// and closes when it drops thermalHysteresis degrees below the threshold.
+func (e *promExporter) watchTemperature(class, sensor string, value float64, now time.Time) {
+	threshold := e.Thermal.threshold(class)
+	if e.Annotator == nil || threshold <= 0 {
+		return
+	}
+
+	e.thermal.mu.Lock()
+	defer e.thermal.mu.Unlock()
+
+	if e.thermal.states == nil {
+		e.thermal.states = map[string]*thermalState{}
+	}
+	state, ok := e.thermal.states[sensor]
+	if !ok {
+		state = &thermalState{}
+		e.thermal.states[sensor] = state
+	}
+
+	text := fmt.Sprintf("%s temperature above %g°C", sensor, threshold)
+	switch {
+	case state.active:
+		if value < threshold-thermalHysteresis {
+			state.active, state.since = false, time.Time{}
+			e.Logger.Printf("%s temperature back to %g°C\n", sensor, value)
+			e.annotate(notifications.Annotation{Text: text, Tags: []string{"thermal"}, Time: now, End: true})
+		}
+	case value > threshold:
+		if state.since.IsZero() {
+			state.since = now
+		}
+		if now.Sub(state.since) >= e.Thermal.Duration {
+			state.active = true
+			e.Logger.Printf("%s temperature at %g°C since %v\n", sensor, value, state.since)
+			e.annotate(notifications.Annotation{Text: text, Tags: []string{"thermal"}, Time: state.since})
+		}
+	default:
+		state.since = time.Time{}
+	}
+}
@@ -0,0 +1,29 @@
+package prometheus
+
+import (
+	"syscall"
+)
+
+// staUnsync is the STA_UNSYNC flag of the kernel clock status, set while the clock isn't synchronized
+const staUnsync = 0x0040
+
+func getTimexMetrics() ([]metric, error) {
+	var timex syscall.Timex
+	if _, err := syscall.Adjtimex(&timex); err != nil {
+		return nil, err
+	}
+
+	synced := 0.0
+	if timex.Status&staUnsync == 0 {
+		synced = 1
+	}
+
+	return []metric{
+		{
+			name:       "node_timex_sync_status",
+			value:      synced,
+			help:       "Whether the kernel clock is synchronized, e.g. by the local ntpd",
+			metricType: "gauge",
+		},
+	}, nil
+}
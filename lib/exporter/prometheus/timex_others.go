@@ -0,0 +1,8 @@
+// +build !linux
+
+package prometheus
+
+// getTimexMetrics returns no metrics, since adjtimex is specific to Linux
+func getTimexMetrics() ([]metric, error) {
+	return nil, nil
+}
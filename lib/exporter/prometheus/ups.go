@@ -9,9 +9,13 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
 	nut "github.com/robbiet480/go.nut"
 )
 
+// upsPowerDebounceReadings is the number of consecutive readings required to consider that the UPS power source changed
+const upsPowerDebounceReadings = 2
+
 type upsState struct {
 	upsLock   sync.Mutex
 	upsClient nut.Client
@@ -20,6 +24,15 @@ type upsState struct {
 	upsConnErrTimestamp time.Time
 	upsConnAttempts     int
 	upsList             *[]nut.UPS
+
+	// power holds the power source of each UPS, to annotate the transitions
+	power map[string]*upsPowerState
+}
+
+type upsPowerState struct {
+	onBattery bool
+	// pending counts the consecutive readings contradicting onBattery
+	pending int
 }
 
 func (e *promExporter) getUpsStatsMetricsWithRetry() ([]metric, error) {
@@ -61,7 +74,7 @@ func (e *promExporter) getUpsStatsMetrics() (metrics []metric, err error) {
 			e.Logger.Println("Connecting to UPS daemon")
 
 			e.upsState.upsConnAttempts++
-			e.upsState.upsClient, e.upsState.upsConnErr = nut.Connect("127.0.0.1")
+			e.upsState.upsClient, e.upsState.upsConnErr = nut.Connect(upsdHost)
 		}
 		if e.upsState.upsConnErr != nil {
 			e.upsState.upsConnErrTimestamp = time.Now()
@@ -119,23 +132,35 @@ func (e *promExporter) getUpsStatsMetrics() (metrics []metric, err error) {
 			}
 
 			metrics = append(metrics, metric{
-				name:  "ups_" + strings.ReplaceAll(v.Name, ".", "_"),
-				attr:  attr,
-				value: value,
-				help:  v.Description,
+				name:       "ups_" + strings.ReplaceAll(v.Name, ".", "_"),
+				attr:       attr,
+				value:      value,
+				help:       upsVariableHelp(v.Name, v.Description),
+				metricType: "gauge",
 			})
 		}
+		e.trackUpsPower(ups.Name, status, len(*e.upsState.upsList) > 1)
 		metrics = append(metrics, metric{
-			name:  "ups_ups_status",
-			attr:  fmt.Sprintf(`status=%q,firmware=%q,%s`, status, firmware, attr),
-			value: getUpsStatus(status),
-			help:  statusHelp,
+			name:       "ups_ups_status",
+			attr:       fmt.Sprintf(`status=%q,firmware=%q,%s`, status, firmware, attr),
+			value:      getUpsStatus(status),
+			help:       upsVariableHelp("ups.status", statusHelp),
+			metricType: "gauge",
 		})
 	}
 
 	return metrics, nil
 }
 
+// upsVariableHelp returns the description of a NUT variable, which some drivers leave empty
+func upsVariableHelp(name, description string) string {
+	if description == "" || strings.Contains(strings.ToLower(description), "unavailable") {
+		return fmt.Sprintf("Value of the NUT variable %s", name)
+	}
+
+	return description
+}
+
 func getUpsStatus(status string) float64 {
 	switch status {
 	case "OL":
@@ -152,3 +177,60 @@ func getUpsStatus(status string) float64 {
 		return 99
 	}
 }
+
+// trackUpsPower annotates the transitions of the UPS between line power (OL) and battery (OB) as a region,
+// once the new power source has been reported by upsPowerDebounceReadings consecutive readings.
+// It must be called with the UPS lock held.
+func (e *promExporter) trackUpsPower(name, status string, multiple bool) {
+	if e.Annotator == nil || !e.UpsAnnotations {
+		return
+	}
+
+	var onBattery bool
+	flags := strings.Fields(status)
+	switch {
+	case containsString(flags, "OB"):
+		onBattery = true
+	case containsString(flags, "OL"):
+		onBattery = false
+	default:
+		// Neither on line nor on battery (e.g. OFF), so there is no transition to annotate
+		return
+	}
+
+	if e.upsState.power == nil {
+		e.upsState.power = map[string]*upsPowerState{}
+	}
+	state, ok := e.upsState.power[name]
+	if !ok {
+		state = &upsPowerState{}
+		e.upsState.power[name] = state
+	}
+
+	if onBattery == state.onBattery {
+		state.pending = 0
+		return
+	}
+	state.pending++
+	if state.pending < upsPowerDebounceReadings {
+		return
+	}
+	state.onBattery, state.pending = onBattery, 0
+
+	annotation := notifications.Annotation{Text: "On battery", Tags: []string{"ups"}, End: !onBattery, Key: "ups:" + name}
+	if multiple {
+		annotation.Text += ": " + name
+	}
+	e.Logger.Printf("UPS %s power source changed (status: %q)\n", name, status)
+	e.annotate(annotation)
+}
+
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -5,10 +5,17 @@ import "fmt"
 func (e *promExporter) getVersionMetrics() (metrics []metric, err error) {
 	return []metric{
 		{
-			name:  "go_program",
-			attr:  fmt.Sprintf("branch=%q,revision=%q,built=%q,version=%q", e.status.Branch, e.status.Revision, e.status.Built, e.status.Version),
-			help:  "Information about qnapexporter",
-			value: 1,
+			name:       "go_program",
+			attr:       fmt.Sprintf("branch=%q,revision=%q,built=%q,version=%q", e.status.Branch, e.status.Revision, e.status.Built, e.status.Version),
+			help:       "Information about qnapexporter",
+			metricType: "gauge",
+			value:      1,
+		},
+		{
+			name:       "qnapexporter_process_start_time_seconds",
+			value:      float64(e.startTime.UnixNano()) / 1e9,
+			help:       "Time the exporter started, when the counters it accumulates were reset, in seconds since the epoch",
+			metricType: "gauge",
 		},
 	}, nil
 }
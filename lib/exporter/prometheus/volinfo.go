@@ -1,32 +1,40 @@
 package prometheus
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
 	"github.com/pedropombeiro/qnapexporter/lib/utils"
 )
 
+// volumeReadyStatus is the status reported by getsysinfo for healthy volumes
+const volumeReadyStatus = "Ready"
+
 type volumeInfo struct {
 	index                         string
 	fileSystem                    string
 	description                   string
 	status                        string
 	freeSizeBytes, totalSizeBytes float64
+	// temperature is the temperature of the volume, if hasTemperature is set (only some QTS versions report it)
+	temperature    float64
+	hasTemperature bool
 }
 
 func (e *promExporter) readSysVolInfo() {
 	volCount := 0
-	sysvolnumOutput, err := utils.ExecCommand(e.getsysinfo, "sysvolnum")
+	sysvolnumOutput, err := e.execCommand(e.getsysinfo, "sysvolnum")
 	if err == nil {
 		volCount, err = strconv.Atoi(sysvolnumOutput)
 		if err != nil {
 			volCount = 0
 		}
 	}
-	e.Logger.Printf("Retrieved volCount: %d", volCount)
+	utils.Debugf(e.Logger, "Retrieved volCount: %d", volCount)
 
 	e.volumes = make([]volumeInfo, 0, volCount)
 
@@ -34,62 +42,61 @@ func (e *promExporter) readSysVolInfo() {
 	for parsedVolCount := 0; parsedVolCount < volCount; idx++ {
 		volIdx := strconv.FormatUint(idx, 10)
 
-		desc, err := utils.ExecCommand(e.getsysinfo, "vol_desc", volIdx)
+		desc, err := e.execCommand(e.getsysinfo, "vol_desc", volIdx)
 		if err != nil {
 			e.Logger.Printf("Error fetching volume %d description: %v", idx, err)
 			continue
 		}
 		description := parseVolDesc(desc)
-		e.Logger.Printf("Retrieved vol_desc %q, parsed to %q", desc, description)
+		utils.Debugf(e.Logger, "Retrieved vol_desc %q, parsed to %q", desc, description)
 
 		parsedVolCount++
 		if description == "" {
 			continue
 		}
 
-		fileSystem, err := utils.ExecCommand(e.getsysinfo, "vol_fs", volIdx)
+		fileSystem, err := e.execCommand(e.getsysinfo, "vol_fs", volIdx)
 		if err != nil {
 			e.Logger.Printf("Error fetching volume %q file system: %v", description, err)
 			continue
 		}
-		e.Logger.Printf("Retrieved volume %q vol_fs %q", description, fileSystem)
+		utils.Debugf(e.Logger, "Retrieved volume %q vol_fs %q", description, fileSystem)
 		if fileSystem == "Unknown" {
-			e.Logger.Printf("Ignoring %q volume with %s file system", description, fileSystem)
+			utils.Debugf(e.Logger, "Ignoring %q volume with %s file system", description, fileSystem)
 			continue
 		}
 
-		volsizeStr, err := utils.ExecCommand(e.getsysinfo, "vol_totalsize", volIdx)
+		volsizeStr, err := e.execCommand(e.getsysinfo, "vol_totalsize", volIdx)
 		if err != nil {
 			e.Logger.Printf("Error fetching volume %q size: %v", description, err)
 			continue
 		}
-		e.Logger.Printf("Retrieved volume %q vol_totalsize %q", description, volsizeStr)
+		utils.Debugf(e.Logger, "Retrieved volume %q vol_totalsize %q", description, volsizeStr)
 
 		volsizeBytes, err := parseVolSize(volsizeStr)
 		if err != nil {
 			continue
 		}
 
-		status, err := utils.ExecCommand(e.getsysinfo, "vol_status", volIdx)
+		status, err := e.execCommand(e.getsysinfo, "vol_status", volIdx)
 		if err != nil {
 			e.Logger.Printf("Error fetching volume %q status: %v", description, err)
 			continue
 		}
-		e.Logger.Printf("Retrieved volume %q vol_status %q", description, status)
-
-		e.volumes = append(
-			e.volumes,
-			volumeInfo{
-				index:          volIdx,
-				description:    description,
-				fileSystem:     fileSystem,
-				status:         status,
-				totalSizeBytes: volsizeBytes,
-			},
-		)
+		utils.Debugf(e.Logger, "Retrieved volume %q vol_status %q", description, status)
+
+		v := volumeInfo{
+			index:          volIdx,
+			description:    description,
+			fileSystem:     fileSystem,
+			status:         status,
+			totalSizeBytes: volsizeBytes,
+		}
+		v.temperature, v.hasTemperature = e.readVolTemp(v)
+		e.volumes = append(e.volumes, v)
 	}
 
-	e.Logger.Printf("Found volumes %v", e.volumes)
+	utils.Debugf(e.Logger, "Found volumes %v", e.volumes)
 }
 
 func (e *promExporter) getSysInfoVolMetrics() ([]metric, error) {
@@ -109,7 +116,7 @@ func (e *promExporter) getSysInfoVolMetrics() ([]metric, error) {
 		e.status.Volumes = append(e.status.Volumes, v.description)
 
 		if expired || v.freeSizeBytes == 0 {
-			freesizeStr, err := utils.ExecCommand(e.getsysinfo, "vol_freesize", v.index)
+			freesizeStr, err := e.execCommand(e.getsysinfo, "vol_freesize", v.index)
 			if err != nil {
 				return nil, err
 			}
@@ -119,28 +126,146 @@ func (e *promExporter) getSysInfoVolMetrics() ([]metric, error) {
 			}
 
 			v.freeSizeBytes = freeSizeBytes
-			e.volumes[idx] = v
 		}
 
+		// Check the status on every scrape, so that a degraded volume is reported immediately
+		if status, err := e.execCommand(e.getsysinfo, "vol_status", v.index); err == nil {
+			v.status = status
+		} else {
+			e.Logger.Printf("Error fetching volume %q status: %v", v.description, err)
+		}
+		if v.hasTemperature {
+			v.temperature, v.hasTemperature = e.readVolTemp(v)
+		}
+		e.volumes[idx] = v
+		e.trackVolumeStatus(v)
+
 		attr := fmt.Sprintf("volume=%q,filesystem=%q,status=%q", v.description, v.fileSystem, v.status)
 		newMetrics := []metric{
 			{
-				name:  "node_volume_avail_bytes",
-				attr:  attr,
-				value: v.freeSizeBytes,
+				name:       "node_volume_avail_bytes",
+				attr:       attr,
+				value:      v.freeSizeBytes,
+				help:       "Free space of the volume in bytes",
+				metricType: "gauge",
+			},
+			{
+				name:       "node_volume_size_bytes",
+				attr:       attr,
+				value:      v.totalSizeBytes,
+				help:       "Total size of the volume in bytes",
+				metricType: "gauge",
 			},
 			{
-				name:  "node_volume_size_bytes",
-				attr:  attr,
-				value: v.totalSizeBytes,
+				name:       "node_volume_status",
+				attr:       fmt.Sprintf("volume=%q,status=%q", v.description, normalizeVolumeStatus(v.status)),
+				value:      volumeReadyValue(v.status),
+				help:       "Whether the volume is ready (1) or e.g. degraded or rebuilding (0), with its status",
+				metricType: "gauge",
 			},
 		}
+		if v.hasTemperature {
+			newMetrics = append(newMetrics, metric{
+				name:       "node_volume_temperature_celsius",
+				attr:       fmt.Sprintf("volume=%q", v.description),
+				value:      v.temperature,
+				help:       "Temperature of the volume in degrees Celsius",
+				metricType: "gauge",
+			})
+		}
 		metrics = append(metrics, newMetrics...)
 	}
 
 	return metrics, nil
 }
 
+// readVolTemp returns the temperature of the volume, if getsysinfo reports it
+func (e *promExporter) readVolTemp(v volumeInfo) (float64, bool) {
+	output, ok := e.probeGetsysinfo("vol_temp", v.index)
+	if !ok {
+		return 0, false
+	}
+
+	value, err := utils.ParseFloat(strings.SplitN(output, " ", 2)[0])
+	if err != nil {
+		e.Logger.Printf("Error parsing volume %q temperature %q: %v", v.description, output, err)
+		return 0, false
+	}
+
+	return value, true
+}
+
+// probeGetsysinfo runs a getsysinfo subcommand which only some QTS versions support, returning its output and whether
+// it succeeded. A subcommand exiting with an error is remembered as unsupported, and isn't run again.
+func (e *promExporter) probeGetsysinfo(subcommand string, args ...string) (string, bool) {
+	if e.unsupportedSubcommands[subcommand] {
+		return "", false
+	}
+
+	output, err := e.execCommand(e.getsysinfo, append([]string{subcommand}, args...)...)
+	if err != nil {
+		var cmdErr *utils.CommandError
+		if errors.As(err, &cmdErr) {
+			e.Logger.Printf("getsysinfo %s is not supported, not running it again: %v", subcommand, err)
+			if e.unsupportedSubcommands == nil {
+				e.unsupportedSubcommands = map[string]bool{}
+			}
+			e.unsupportedSubcommands[subcommand] = true
+		} else {
+			e.Logger.Printf("Error running getsysinfo %s: %v", subcommand, err)
+		}
+		return "", false
+	}
+
+	return output, true
+}
+
+// volumeReadyValue returns 1 if the status is the ready status, 0 otherwise
+func volumeReadyValue(status string) float64 {
+	if normalizeVolumeStatus(status) == volumeReadyStatus {
+		return 1
+	}
+
+	return 0
+}
+
+// trackVolumeStatus annotates the changes of the volume status as regions, lasting while the volume isn't ready
+// (e.g. "[storage] Volume Media degraded")
+func (e *promExporter) trackVolumeStatus(v volumeInfo) {
+	if e.Annotator == nil || !e.StorageAnnotations {
+		return
+	}
+
+	status := normalizeVolumeStatus(v.status)
+	previous, changed := e.volumeStatus.update(v.index, status)
+	if !changed {
+		return
+	}
+
+	e.Logger.Printf("Volume %q status changed from %q to %q\n", v.description, previous, status)
+	key := "volume:" + v.index
+	var annotations []notifications.Annotation
+	if previous != volumeReadyStatus {
+		annotations = append(annotations, notifications.Annotation{Text: volumeStatusText(v.description, previous), Tags: []string{"storage"}, End: true, Key: key})
+	}
+	if status != volumeReadyStatus {
+		annotations = append(annotations, notifications.Annotation{Text: volumeStatusText(v.description, status), Tags: []string{"storage"}, Key: key})
+	}
+	e.annotate(annotations...)
+}
+
+// normalizeVolumeStatus strips the progress from the volume status (e.g. "Rebuilding... (50%)" -> "Rebuilding"),
+// so that the progress of an operation isn't considered a transition
+func normalizeVolumeStatus(status string) string {
+	status = strings.SplitN(status, "(", 2)[0]
+
+	return strings.TrimRight(strings.TrimSpace(status), ".")
+}
+
+func volumeStatusText(description, status string) string {
+	return fmt.Sprintf("Volume %s %s", description, strings.ToLower(status))
+}
+
 func parseVolDesc(desc string) string {
 	var index int
 	switch {
@@ -157,6 +282,9 @@ func parseVolDesc(desc string) string {
 
 func parseVolSize(s string) (float64, error) {
 	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("parse volume size (%s)", s)
+	}
 	size, err := strconv.ParseFloat(fields[0], 64)
 	if err != nil {
 		return 0, fmt.Errorf("parse volume size (%s): %w", s, err)
@@ -0,0 +1,206 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds each request when no timeout is configured
+	DefaultTimeout = 10 * time.Second
+	// DefaultMaxIdleConnsPerHost is the number of keep-alive connections kept open to each host
+	DefaultMaxIdleConnsPerHost = 4
+	// DefaultIdleConnTimeout is the time a keep-alive connection is kept open without being used
+	DefaultIdleConnTimeout = 90 * time.Second
+)
+
+// Config holds the settings of the outbound HTTP clients
+type Config struct {
+	// Timeout bounds each request (DefaultTimeout, if zero)
+	Timeout time.Duration
+	// ProxyURL is the proxy the requests go through (defaults to the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment
+	// variables)
+	ProxyURL string
+	// CAFile is the path of a PEM file with additional certificate authorities to trust
+	CAFile string
+	// InsecureSkipVerify disables the verification of the TLS certificates
+	InsecureSkipVerify bool
+	// MaxIdleConnsPerHost is the number of keep-alive connections kept open to each host
+	// (DefaultMaxIdleConnsPerHost, if zero)
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is the time a keep-alive connection is kept open without being used
+	// (DefaultIdleConnTimeout, if zero)
+	IdleConnTimeout time.Duration
+}
+
+// merge returns the configuration with the settings set in override replacing its own
+func (c Config) merge(override Config) Config {
+	if override.Timeout > 0 {
+		c.Timeout = override.Timeout
+	}
+	if override.ProxyURL != "" {
+		c.ProxyURL = override.ProxyURL
+	}
+	if override.CAFile != "" {
+		c.CAFile = override.CAFile
+	}
+	if override.InsecureSkipVerify {
+		c.InsecureSkipVerify = true
+	}
+	if override.MaxIdleConnsPerHost > 0 {
+		c.MaxIdleConnsPerHost = override.MaxIdleConnsPerHost
+	}
+	if override.IdleConnTimeout > 0 {
+		c.IdleConnTimeout = override.IdleConnTimeout
+	}
+
+	return c
+}
+
+// withDefaults returns the configuration, with the default timeout and keep-alive pool sizes if unset
+func (c Config) withDefaults() Config {
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+
+	return c
+}
+
+// Factory creates the HTTP clients used by the outbound integrations (e.g. Grafana, Slack, Loki). The clients whose
+// transport settings are the same share their transport, and so their pool of keep-alive connections, instead of each
+// opening its own TLS connections to the same hosts.
+type Factory struct {
+	config Config
+
+	mu         sync.Mutex
+	transports map[Config]*http.Transport
+}
+
+// NewFactory creates a Factory whose clients default to the settings in config
+func NewFactory(config Config) *Factory {
+	return &Factory{config: config.withDefaults(), transports: map[Config]*http.Transport{}}
+}
+
+// Client returns an HTTP client for the service, with the settings set in override replacing the defaults of the
+// factory. The service name is used in error messages.
+func (f *Factory) Client(service string, override Config) (*http.Client, error) {
+	config := f.config.merge(override)
+	transport, err := f.transport(service, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Timeout: config.Timeout, Transport: transport}, nil
+}
+
+// transport returns the transport shared by the clients with the settings in config, creating it if needed
+func (f *Factory) transport(service string, config Config) (*http.Transport, error) {
+	// The timeout applies to each client, not to their connections
+	key := config
+	key.Timeout = 0
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if transport, ok := f.transports[key]; ok {
+		return transport, nil
+	}
+
+	transport, err := NewTransport(service, config)
+	if err != nil {
+		return nil, err
+	}
+	f.transports[key] = transport
+
+	return transport, nil
+}
+
+// NewTransport creates a transport honoring the proxy, TLS and keep-alive settings in config.
+// The service name is used in error messages.
+func NewTransport(service string, config Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxy, err := ProxyFunc(service, config.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = proxy
+
+	tlsConfig, err := NewTLSConfig(service, config.CAFile, config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+
+	return transport, nil
+}
+
+// NewTLSConfig returns the TLS configuration trusting the additional certificate authorities in caFile,
+// or nil if the defaults apply. The service name is used in error messages.
+func NewTLSConfig(service, caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	if caFile == "" && !insecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		//nolint:gosec // Explicitly requested by the user, e.g. for self-signed certificates
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read %s CA file: %w", service, err)
+		}
+
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in %s CA file %q", service, caFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	return tlsConfig, nil
+}
+
+// ProxyFunc returns the proxy selection function: the explicit proxy if one is configured,
+// or the one specified by the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables.
+// The service name is used in error messages.
+func ProxyFunc(service, rawURL string) (func(*http.Request) (*url.URL, error), error) {
+	if rawURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s proxy URL: %w", service, err)
+	}
+	if proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid %s proxy URL %q, expected e.g. http://proxy:3128", service, rawURL)
+	}
+
+	return http.ProxyURL(proxyURL), nil
+}
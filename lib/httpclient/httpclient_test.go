@@ -0,0 +1,96 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCountingServer returns a TLS server counting the connections opened to it
+func newCountingServer(t *testing.T) (*httptest.Server, *int32) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server, &conns
+}
+
+func writeCAFile(t *testing.T, server *httptest.Server) string {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, certPEM, 0o600))
+
+	return caFile
+}
+
+func get(t *testing.T, client *http.Client, url string) {
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	// The body must be read to the end for the connection to be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestFactoryReusesConnections(t *testing.T) {
+	server, conns := newCountingServer(t)
+	f := NewFactory(Config{CAFile: writeCAFile(t, server)})
+
+	slack, err := f.Client("Slack", Config{})
+	require.NoError(t, err)
+	loki, err := f.Client("Loki", Config{Timeout: time.Minute})
+	require.NoError(t, err)
+	assert.Same(t, slack.Transport, loki.Transport)
+	assert.Equal(t, DefaultTimeout, slack.Timeout)
+	assert.Equal(t, time.Minute, loki.Timeout)
+
+	for i := 0; i < 5; i++ {
+		get(t, slack, server.URL)
+		get(t, loki, server.URL)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(conns))
+
+	// Different TLS settings require a transport of their own
+	insecure, err := f.Client("Grafana", Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	assert.NotSame(t, slack.Transport, insecure.Transport)
+	get(t, insecure, server.URL)
+	get(t, insecure, server.URL)
+	assert.Equal(t, int32(2), atomic.LoadInt32(conns))
+}
+
+func TestFactoryClientOverrides(t *testing.T) {
+	f := NewFactory(Config{MaxIdleConnsPerHost: 8, ProxyURL: "http://proxy:3128"})
+
+	client, err := f.Client("Loki", Config{IdleConnTimeout: time.Second})
+	require.NoError(t, err)
+	transport := client.Transport.(*http.Transport)
+	assert.Equal(t, 8, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, time.Second, transport.IdleConnTimeout)
+	proxyURL, err := transport.Proxy(httptest.NewRequest("GET", "https://loki.example.com", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy:3128", proxyURL.String())
+
+	_, err = f.Client("Grafana", Config{ProxyURL: "proxy:3128"})
+	assert.EqualError(t, err, `invalid Grafana proxy URL "proxy:3128", expected e.g. http://proxy:3128`)
+
+	_, err = f.Client("Slack", Config{CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.ErrorContains(t, err, "read Slack CA file")
+}
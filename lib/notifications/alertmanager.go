@@ -0,0 +1,126 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAlertmanagerRequestSize bounds the size of the body accepted by the Alertmanager handler, which holds a group
+	// of alerts
+	maxAlertmanagerRequestSize = 1024 * 1024
+
+	alertResolved = "resolved"
+)
+
+// alertmanagerPayload is the part of the body of the Alertmanager webhook notifications used for annotations
+// (see https://prometheus.io/docs/alerting/latest/configuration/#webhook_config)
+type alertmanagerPayload struct {
+	Version string              `json:"version"`
+	Alerts  []alertmanagerAlert `json:"alerts"`
+}
+
+type alertmanagerAlert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+type alertmanagerHandler struct {
+	annotator Annotator
+	token     string
+	logger    *log.Logger
+}
+
+// NewAlertmanagerHandler creates an HTTP handler receiving the Alertmanager webhook notifications, opening a region
+// through annotator for each firing alert of the group and closing it once the alert is resolved.
+// Requests must be authenticated with token, either as a bearer token or as the basic authentication password.
+func NewAlertmanagerHandler(annotator Annotator, token string, logger *log.Logger) http.Handler {
+	return &alertmanagerHandler{
+		annotator: annotator,
+		token:     token,
+		logger:    logger,
+	}
+}
+
+func (h *alertmanagerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if !tokenAuthorized(r, h.token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="qnapexporter"`)
+		http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+		return
+	}
+
+	var payload alertmanagerPayload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAlertmanagerRequestSize)).Decode(&payload); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("request body exceeds %d bytes", maxAlertmanagerRequestSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if payload.Version != "" && payload.Version != "4" {
+		http.Error(w, fmt.Sprintf("unsupported webhook version %q", payload.Version), http.StatusBadRequest)
+		return
+	}
+
+	// Every alert of the group is posted even if some fail, Alertmanager retrying the whole group on errors
+	var failures []string
+	for _, alert := range payload.Alerts {
+		if _, err := h.annotator.PostAnnotation(alertAnnotation(alert)); err != nil {
+			h.logger.Printf("Error posting annotation for alert %s received from %s: %v\n", alert.Fingerprint, r.RemoteAddr, err)
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) != 0 {
+		http.Error(w, strings.Join(failures, "; "), http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// alertAnnotation returns the annotation of alert: its summary (or name) tagged with its labels as name:value,
+// opening a region identified by its fingerprint while it is firing, and closing it once resolved
+func alertAnnotation(alert alertmanagerAlert) Annotation {
+	text := strings.TrimSpace(alert.Annotations["summary"])
+	if text == "" {
+		text = alert.Labels["alertname"]
+	}
+
+	names := make([]string, 0, len(alert.Labels))
+	for name := range alert.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tags := make([]string, 0, len(names))
+	for _, name := range names {
+		tags = append(tags, name+":"+alert.Labels[name])
+	}
+
+	annotation := Annotation{Text: text, Tags: tags, Time: alert.StartsAt}
+	if alert.Fingerprint != "" {
+		annotation.Key = "alertmanager:" + alert.Fingerprint
+	}
+	if alert.Status == alertResolved {
+		annotation.End = true
+		annotation.Time = alert.EndsAt
+	}
+
+	return annotation
+}
@@ -2,153 +2,561 @@ package notifications
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/pedropombeiro/qnapexporter/lib/httpclient"
 	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
 )
 
+// DefaultTimeout is the timeout applied to Grafana requests when GrafanaConfig.Timeout is not set
+const DefaultTimeout = 10 * time.Second
+
+const (
+	// DefaultRetryBackoff is the delay before the first retry when GrafanaConfig.RetryBackoff is not set
+	DefaultRetryBackoff = 1 * time.Second
+	// DefaultRetryMaxBackoff is the maximum delay between retries when GrafanaConfig.RetryMaxBackoff is not set
+	DefaultRetryMaxBackoff = 30 * time.Second
+)
+
 type Annotator interface {
+	// Post creates an annotation from a string, where tags can be specified with a "[tag] " prefix syntax
 	Post(annotation string, time time.Time) (int, error)
+	// PostAnnotation creates an annotation with explicit tags and time
+	PostAnnotation(annotation Annotation) (int, error)
+}
+
+// Annotation describes an event to be annotated
+type Annotation struct {
+	Text string
+	Tags []string
+	// Time is the time of the event (defaults to the current time)
+	Time time.Time
+	// End marks the event as the end of a region opened by an annotation with the same text and tags (or Key)
+	End bool
+	// Key, if set, identifies the region of the event regardless of its text (e.g. "ups:apc1"),
+	// so that the end event closes the region opened by the start event with the same key
+	Key string
+	// DashboardUID and PanelID restrict the annotation to a dashboard/panel, overriding the configured ones
+	DashboardUID string
+	PanelID      int
 }
 
 type grafanaAnnotation struct {
-	Id      int      `json:"id,omitempty"`
-	Tags    []string `json:"tags,omitempty"`
-	Time    int64    `json:"time,omitempty"`
-	TimeEnd int64    `json:"timeEnd,omitempty"`
-	Text    string   `json:"text,omitempty"`
+	Id           int      `json:"id,omitempty"`
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	PanelId      int      `json:"panelId,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Time         int64    `json:"time,omitempty"`
+	TimeEnd      int64    `json:"timeEnd,omitempty"`
+	IsRegion     bool     `json:"isRegion,omitempty"`
+	Text         string   `json:"text,omitempty"`
 }
 
+const (
+	// dashboardTagPrefix and panelTagPrefix mark tags which target the annotation to a dashboard/panel (e.g. "[panel:2] ")
+	dashboardTagPrefix = "dashboard:"
+	panelTagPrefix     = "panel:"
+)
+
 type httpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+// GrafanaConfig holds the settings used to reach the Grafana annotations API
+type GrafanaConfig struct {
+	URL       string
+	AuthToken string
+	// AuthTokenSource, if set, provides the token instead of AuthToken
+	AuthTokenSource TokenSource
+	// Username and Password are used for basic authentication, but only if no token is configured
+	Username, Password string
+	// OrgID, if non-zero, selects the Grafana organization through the X-Grafana-Org-Id header
+	OrgID int64
+	// Tags, TextPrefix and TextSuffix can contain template placeholders, expanded when posting:
+	// {{.Hostname}} is the name of the NAS and {{.Time}} the time of the event (e.g. {{.Time.Format "15:04"}})
+	Tags []string
+	// TextPrefix and TextSuffix are added to the text of every annotation
+	TextPrefix, TextSuffix string
+	// Hostname, if set, returns the hostname used in templates (defaults to the OS hostname)
+	Hostname func() string
+	// DashboardUID and PanelID restrict annotations to a dashboard/panel (by default annotations are global)
+	DashboardUID string
+	PanelID      int
+	// CAFile is the path of a PEM bundle with additional certificate authorities to trust
+	CAFile string
+	// InsecureSkipVerify disables the verification of the Grafana TLS certificate
+	InsecureSkipVerify bool
+	// ProxyURL is the proxy used to reach Grafana (defaults to the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables)
+	ProxyURL string
+	// Timeout bounds each request made to Grafana (defaults to DefaultTimeout)
+	Timeout time.Duration
+	// Retries is the number of additional attempts after a connection error or an HTTP 5xx response
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled on every subsequent retry (defaults to DefaultRetryBackoff)
+	RetryBackoff time.Duration
+	// RetryMaxBackoff caps the delay between retries (defaults to DefaultRetryMaxBackoff)
+	RetryMaxBackoff time.Duration
+	// RetryJitter is the fraction (0-1) of each delay that is randomized, to avoid retrying in lockstep
+	RetryJitter float64
+}
+
 func NewSimpleAnnotator(
-	grafanaURL, grafanaAuthToken string,
-	tags []string,
+	config GrafanaConfig,
 	c httpClient,
 	logger *log.Logger,
 ) Annotator {
-	if len(tags) == 1 && tags[0] == "" {
-		tags = nil
-	}
-
-	return &regionMatchingAnnotator{
-		grafanaURL:       grafanaURL,
-		grafanaAuthToken: grafanaAuthToken,
-		tags:             tags,
-		tagExtractor:     tagextractor.NewNoOpTagExtractor(),
-		cache:            NewNoOpRegionMatcher(),
-		client:           c,
-		logger:           logger,
-	}
+	return NewRegionMatchingAnnotator(
+		config,
+		tagextractor.NewNoOpTagExtractor(),
+		NewNoOpRegionMatcher(),
+		c,
+		logger,
+	)
 }
 
 type regionMatchingAnnotator struct {
 	grafanaURL       string
 	grafanaAuthToken string
-	tags             []string
+	tokenSource      TokenSource
+	username         string
+	password         string
+	orgID            int64
+	tags             []textTemplate
+	textPrefix       textTemplate
+	textSuffix       textTemplate
+	hostname         func() string
+	dashboardUID     string
+	panelID          int
+	timeout          time.Duration
+	retries          int
+	retryBackoff     time.Duration
+	retryMaxBackoff  time.Duration
+	retryJitter      float64
 	tagExtractor     tagextractor.TagExtractor
 	cache            RegionMatcher
 	client           httpClient
+	proxy            func(*http.Request) (*url.URL, error)
 	logger           *log.Logger
+
+	pageSize int
 }
 
+// NewRegionMatchingAnnotator creates an Annotator that closes open regions when a matching end event is posted.
+// If c is nil, an HTTP client honoring the timeout and TLS settings in config is created.
 func NewRegionMatchingAnnotator(
-	grafanaURL, grafanaAuthToken string,
-	tags []string,
+	config GrafanaConfig,
 	tagExtractor tagextractor.TagExtractor,
 	cache RegionMatcher,
 	c httpClient,
 	logger *log.Logger,
 ) Annotator {
-	if len(tags) == 1 && tags[0] == "" {
-		tags = nil
+	var tags []textTemplate
+	if len(config.Tags) != 1 || config.Tags[0] != "" {
+		for _, tag := range config.Tags {
+			tags = append(tags, parseTemplateOrLog(tag, logger))
+		}
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if c == nil {
+		client, err := NewGrafanaHTTPClient(config)
+		if err != nil {
+			logger.Printf("Error creating Grafana HTTP client, using defaults: %v\n", err)
+			client = &http.Client{Timeout: timeout}
+		}
+		c = client
+	}
+
+	proxy, err := httpclient.ProxyFunc("Grafana", config.ProxyURL)
+	if err != nil {
+		proxy = http.ProxyFromEnvironment
+	}
+
+	retryBackoff := config.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = DefaultRetryBackoff
+	}
+	retryMaxBackoff := config.RetryMaxBackoff
+	if retryMaxBackoff <= 0 {
+		retryMaxBackoff = DefaultRetryMaxBackoff
 	}
 
 	return &regionMatchingAnnotator{
-		grafanaURL:       grafanaURL,
-		grafanaAuthToken: grafanaAuthToken,
+		grafanaURL:       config.URL,
+		grafanaAuthToken: config.AuthToken,
+		tokenSource:      config.AuthTokenSource,
+		username:         config.Username,
+		password:         config.Password,
+		orgID:            config.OrgID,
 		tags:             tags,
+		textPrefix:       parseTemplateOrLog(config.TextPrefix, logger),
+		textSuffix:       parseTemplateOrLog(config.TextSuffix, logger),
+		hostname:         config.Hostname,
+		dashboardUID:     config.DashboardUID,
+		panelID:          config.PanelID,
+		timeout:          timeout,
+		retries:          config.Retries,
+		retryBackoff:     retryBackoff,
+		retryMaxBackoff:  retryMaxBackoff,
+		retryJitter:      config.RetryJitter,
 		tagExtractor:     tagExtractor,
 		cache:            cache,
 		client:           c,
+		proxy:            proxy,
 		logger:           logger,
+		pageSize:         defaultPageSize,
 	}
 }
 
 func (a *regionMatchingAnnotator) Post(annotation string, time time.Time) (int, error) {
+	text, tags := a.tagExtractor.Extract(annotation)
+
+	return a.post(Annotation{Text: text, Tags: tags, Time: time}, annotation)
+}
+
+func (a *regionMatchingAnnotator) PostAnnotation(annotation Annotation) (int, error) {
+	if annotation.Key != "" {
+		return a.post(annotation, annotation.Key)
+	}
+
+	return a.post(annotation, a.tagExtractor.Restore(annotation.Text, annotation.Tags))
+}
+
+// post sends an annotation to Grafana, or closes the matching region if one exists.
+// The key identifies the annotation in the region cache.
+func (a *regionMatchingAnnotator) post(annotation Annotation, key string) (int, error) {
+	t := annotation.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
 	url := fmt.Sprintf("%s/api/annotations", a.grafanaURL)
-	trimmedAnnotation, annotationTags := a.tagExtractor.Extract(annotation)
+	tags, dashboardUID, panelID := extractTargetTags(annotation.Tags)
+	data := a.templateData(t)
 	ga := grafanaAnnotation{
-		Text: trimmedAnnotation,
-		Tags: mergeTags(a.tags, annotationTags),
-		Time: time.UnixNano() / 1000000,
+		DashboardUID: firstNonEmpty(annotation.DashboardUID, dashboardUID, a.dashboardUID),
+		PanelId:      panelID,
+		Text:         a.expandText(annotation.Text, data),
+		Tags:         mergeTags(a.expandTags(data), tags),
+		Time:         t.UnixNano() / 1000000,
+	}
+	switch {
+	case annotation.PanelID != 0:
+		ga.PanelId = annotation.PanelID
+	case ga.PanelId == 0:
+		ga.PanelId = a.panelID
+	}
+	id := -1
+	switch {
+	case annotation.Key != "":
+		// Semantic keys only ever match the start event of the same key
+		if annotation.End {
+			id = a.cache.MatchKey(key)
+		}
+	default:
+		id = a.cache.Match(key)
+		if id == -1 && annotation.End {
+			id = a.cache.MatchKey(key)
+		}
+	}
+	var original *grafanaAnnotation
+	if id != -1 {
+		var open bool
+		if original, open = a.lookupOpen(id); !open {
+			id = -1
+		}
 	}
-	id := a.cache.Match(annotation)
 
 	reqType := "POST"
+	reqURL := url
 	if id != -1 {
 		reqType = "PATCH"
+		ga.TimeEnd = ga.Time
+		ga.IsRegion = true
 		ga.Time = 0
-		ga.TimeEnd = time.UnixNano() / 1000000
-		url = fmt.Sprintf("%s/%d", url, id)
+		if original != nil && original.Time != 0 {
+			// Grafana 8 turns a PATCH without the start time into a zero-length region at the close time,
+			// so send the complete annotation back
+			ga.Time = original.Time
+			ga.Tags = mergeTags(original.Tags, ga.Tags)
+		}
+		reqURL = fmt.Sprintf("%s/%d", url, id)
 	}
 
+	var (
+		responseID, statusCode int
+		err                    error
+		recreated              bool
+	)
+	maxAttempts := 1 + a.retries
+	attempt := 0
+	for attempt < maxAttempts {
+		if attempt > 0 {
+			a.waitBeforeRetry(attempt)
+		}
+		attempt++
+
+		responseID, statusCode, err = a.send(reqType, reqURL, ga)
+		if err == nil {
+			break
+		}
+
+		if reqType == "PATCH" && statusCode == http.StatusNotFound {
+			// The annotation to be closed no longer exists, so create a fresh one instead
+			a.logger.Printf("Grafana annotation %d not found, creating a new annotation\n", id)
+			reqType = "POST"
+			reqURL = url
+			ga.Time, ga.TimeEnd, ga.IsRegion = ga.TimeEnd, 0, false
+			recreated = true
+			maxAttempts++
+			continue
+		}
+
+		if !isRetryable(statusCode) {
+			break
+		}
+	}
+
+	if err != nil {
+		if a.retries > 0 {
+			err = fmt.Errorf("%w (attempt %d)", err, attempt)
+		}
+		return -1, err
+	}
+
+	// The annotation recreated in place of a stale region is the one the next update of the region applies to
+	if id == -1 && !annotation.End || recreated {
+		a.cache.AddKey(key, responseID, t)
+	}
+
+	return responseID, nil
+}
+
+// send performs a single request to the Grafana annotations API, returning the annotation ID
+// and the HTTP status code (0 if no response was received)
+func (a *regionMatchingAnnotator) send(reqType, url string, ga grafanaAnnotation) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
 	jsonBytes, err := json.Marshal(ga)
 	if err != nil {
 		a.logger.Printf("Error marshalling Grafana annotation: %v\n", err)
-		return -1, err
+		return -1, 0, err
 	}
-	bodyReader := bytes.NewReader(jsonBytes)
-	req, err := http.NewRequest(reqType, url, bodyReader)
+	req, err := a.newRequest(ctx, reqType, url, bytes.NewReader(jsonBytes))
 	if err != nil {
 		a.logger.Printf("Error creating Grafana annotation request: %v\n", err)
-		return -1, err
+		return -1, 0, err
 	}
-
 	req.Header.Set("Content-Type", "application/json")
-	if a.grafanaAuthToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.grafanaAuthToken))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		err = a.connectionError(req, err)
+		a.logger.Printf("Error creating Grafana annotation at %s: %v\n", url, err)
+		return -1, 0, err
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+
+	if resp.StatusCode >= 300 {
+		message := readErrorMessage(resp)
+		a.logger.Printf("Error creating Grafana annotation at %s: HTTP %d %q %s\n", url, resp.StatusCode, resp.Status, message)
+		if message != "" {
+			// Surface validation errors returned by Grafana (e.g. an unknown dashboard)
+			return -1, resp.StatusCode, fmt.Errorf("call to %s failed with HTTP %d %q: %s", url, resp.StatusCode, resp.Status, message)
+		}
+		return -1, resp.StatusCode, fmt.Errorf("call to %s failed with HTTP %d %q", url, resp.StatusCode, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return -1, resp.StatusCode, fmt.Errorf("reading response body: %w", err)
+	}
+
+	var response struct {
+		Id      int    `json:"id"`
+		Message string `json:"message"`
+	}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return -1, resp.StatusCode, fmt.Errorf("unmarshaling response body: %w", err)
+	}
+
+	a.logger.Printf("%s (status: %q), ID: %d\n", response.Message, resp.Status, response.Id)
+	return response.Id, resp.StatusCode, nil
+}
+
+// lookupOpen checks that the annotation matched in the cache still exists in Grafana and hasn't been closed yet,
+// so that a stale match doesn't close the wrong region. If the check itself fails, the annotation is assumed to be open.
+// The annotation fetched from Grafana is returned when available, so that it can be closed without losing its start time.
+func (a *regionMatchingAnnotator) lookupOpen(id int) (*grafanaAnnotation, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/api/annotations/%d", a.grafanaURL, id)
+	req, err := a.newRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, true
 	}
 
 	resp, err := a.client.Do(req)
-	if err == nil {
-		if resp.StatusCode < 300 {
-			body, readErr := io.ReadAll(resp.Body)
-			if readErr != nil {
-				return -1, fmt.Errorf("reading response body: %w", readErr)
-			}
+	if err != nil {
+		a.logger.Printf("Error checking Grafana annotation %d: %v\n", id, a.connectionError(req, err))
+		return nil, true
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
 
-			var response struct {
-				Id      int    `json:"id"`
-				Message string `json:"message"`
-			}
-			err = json.Unmarshal(body, &response)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		a.logger.Printf("Grafana annotation %d not found, creating a new annotation\n", id)
+		return nil, false
+	case resp.StatusCode != http.StatusOK || resp.Body == nil:
+		return nil, true
+	}
+
+	var ga grafanaAnnotation
+	if err := json.NewDecoder(resp.Body).Decode(&ga); err != nil {
+		return nil, true
+	}
+	if ga.TimeEnd != 0 && ga.TimeEnd != ga.Time {
+		a.logger.Printf("Grafana annotation %d is already closed, creating a new annotation\n", id)
+		return nil, false
+	}
+
+	return &ga, true
+}
+
+// newRequest creates a request to the Grafana API, including the authorization headers.
+// An API token takes precedence over basic authentication.
+func (a *regionMatchingAnnotator) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	token := a.grafanaAuthToken
+	if a.tokenSource != nil {
+		token = a.tokenSource.Token()
+	}
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	case a.username != "":
+		req.SetBasicAuth(a.username, a.password)
+	}
+
+	if a.orgID != 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.FormatInt(a.orgID, 10))
+	}
+
+	return req, nil
+}
+
+// connectionError adds the proxy host (if any) to an error returned by the HTTP client,
+// so that a misconfigured proxy is obvious
+func (a *regionMatchingAnnotator) connectionError(req *http.Request, err error) error {
+	if a.proxy == nil {
+		return err
+	}
+
+	proxyURL, proxyErr := a.proxy(req)
+	if proxyErr != nil || proxyURL == nil {
+		return err
+	}
+
+	return fmt.Errorf("%w (via proxy %s)", err, proxyURL.Host)
+}
+
+// waitBeforeRetry sleeps for an exponentially growing, jittered delay before the given retry attempt
+func (a *regionMatchingAnnotator) waitBeforeRetry(attempt int) {
+	time.Sleep(retryDelay(attempt, a.retryBackoff, a.retryMaxBackoff, a.retryJitter))
+}
+
+// retryDelay returns the delay before the given retry attempt, doubling backoff on every attempt
+// up to maxBackoff and randomly shortening it by up to the jitter fraction
+func retryDelay(attempt int, backoff, maxBackoff time.Duration, jitter float64) time.Duration {
+	delay := backoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	if jitter > 0 {
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay))
+	}
+
+	return delay
+}
+
+// isRetryable returns whether a request that completed with the given status code should be retried.
+// A status code of 0 represents a connection error.
+func isRetryable(statusCode int) bool {
+	return statusCode == 0 || statusCode >= 500
+}
+
+// readErrorMessage returns the message included in a Grafana error response, if any
+func readErrorMessage(resp *http.Response) string {
+	if resp.Body == nil {
+		return ""
+	}
+
+	var response struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response); err != nil {
+		return ""
+	}
+
+	return response.Message
+}
+
+// extractTargetTags removes the dashboard/panel targeting tags from tags, returning their values
+func extractTargetTags(tags []string) (remainingTags []string, dashboardUID string, panelID int) {
+	for _, tag := range tags {
+		switch {
+		case strings.HasPrefix(tag, dashboardTagPrefix):
+			dashboardUID = strings.TrimPrefix(tag, dashboardTagPrefix)
+		case strings.HasPrefix(tag, panelTagPrefix):
+			id, err := strconv.Atoi(strings.TrimPrefix(tag, panelTagPrefix))
 			if err != nil {
-				return -1, fmt.Errorf("unmarshaling response body: %w", err)
+				remainingTags = append(remainingTags, tag)
+				continue
 			}
+			panelID = id
+		default:
+			remainingTags = append(remainingTags, tag)
+		}
+	}
 
-			if id == -1 {
-				a.cache.Add(response.Id, annotation)
-			}
+	return remainingTags, dashboardUID, panelID
+}
 
-			a.logger.Printf("%s (status: %q), ID: %d\n", response.Message, resp.Status, response.Id)
-			return response.Id, nil
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
 		}
-
-		a.logger.Printf("Error creating Grafana annotation at %s: HTTP %d %q\n", url, resp.StatusCode, resp.Status)
-		err = fmt.Errorf("call to %s failed with HTTP %d %q", url, resp.StatusCode, resp.Status)
-	} else {
-		a.logger.Printf("Error creating Grafana annotation at %s: %v\n", url, err)
 	}
 
-	return -1, err
+	return ""
 }
 
 func mergeTags(t1 []string, t2 []string) []string {
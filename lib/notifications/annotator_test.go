@@ -1,10 +1,12 @@
 package notifications
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -17,9 +19,7 @@ import (
 
 func TestNewAnnotator(t *testing.T) {
 	a := NewRegionMatchingAnnotator(
-		"",
-		"",
-		strings.Split("", ","),
+		GrafanaConfig{Tags: strings.Split("", ",")},
 		new(tagextractor.MockTagExtractor),
 		new(MockRegionMatcher),
 		new(mockHttpClient),
@@ -30,6 +30,44 @@ func TestNewAnnotator(t *testing.T) {
 	require.IsType(t, &regionMatchingAnnotator{}, a)
 	c := a.(*regionMatchingAnnotator)
 	assert.Empty(t, c.tags)
+	assert.Equal(t, DefaultTimeout, c.timeout)
+}
+
+func TestNewAnnotatorWithoutClient(t *testing.T) {
+	a := NewSimpleAnnotator(GrafanaConfig{Timeout: 3 * time.Second}, nil, log.New(io.Discard, "", 0))
+
+	require.IsType(t, &regionMatchingAnnotator{}, a)
+	c := a.(*regionMatchingAnnotator)
+	require.IsType(t, &http.Client{}, c.client)
+	assert.Equal(t, 3*time.Second, c.client.(*http.Client).Timeout)
+	assert.Equal(t, 3*time.Second, c.timeout)
+}
+
+func TestPostAnnotationTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Never respond
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	timeout := 100 * time.Millisecond
+	a := NewSimpleAnnotator(
+		GrafanaConfig{URL: server.URL, Timeout: timeout},
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	start := time.Now()
+	id, err := a.Post("test notification", time.Now())
+
+	assert.Error(t, err)
+	assert.Equal(t, -1, id)
+	assert.Less(t, time.Since(start), 10*timeout)
 }
 
 func TestPostAnnotation(t *testing.T) {
@@ -57,7 +95,7 @@ func TestPostAnnotation(t *testing.T) {
 				m.On("Match", "test notification").
 					Once().
 					Return(-1, nil)
-				m.On("Add", 1, "test notification").
+				m.On("AddKey", "test notification", 1, mock.Anything).
 					Once()
 			},
 			setupClientMock: func(m *mockHttpClient) {
@@ -90,6 +128,9 @@ func TestPostAnnotation(t *testing.T) {
 					Return(98, nil)
 			},
 			setupClientMock: func(m *mockHttpClient) {
+				m.On("Do", mock.MatchedBy(isOpenCheck(98))).
+					Once().
+					Return(responseWithBody(`{"id": 98, "time": 1577880000000}`), nil)
 				m.On("Do", mock.MatchedBy(func(req *http.Request) bool {
 					body := readBody(req)
 					return assert.Equal(t, "PATCH", req.Method) &&
@@ -121,7 +162,7 @@ func TestPostAnnotation(t *testing.T) {
 				m.On("Match", "[tag2] test notification").
 					Once().
 					Return(-1, nil)
-				m.On("Add", 1, "[tag2] test notification").
+				m.On("AddKey", "[tag2] test notification", 1, mock.Anything).
 					Once()
 			},
 			setupClientMock: func(m *mockHttpClient) {
@@ -152,7 +193,7 @@ func TestPostAnnotation(t *testing.T) {
 				m.On("Match", "[tag1] [tag2] [tag3] test notification").
 					Once().
 					Return(-1, nil)
-				m.On("Add", 1, "[tag1] [tag2] [tag3] test notification").
+				m.On("AddKey", "[tag1] [tag2] [tag3] test notification", 1, mock.Anything).
 					Once()
 			},
 			setupClientMock: func(m *mockHttpClient) {
@@ -233,9 +274,7 @@ func TestPostAnnotation(t *testing.T) {
 			tc.setupClientMock(clientMock)
 
 			a := NewRegionMatchingAnnotator(
-				tc.testURL,
-				tc.testAuthToken,
-				tc.tags,
+				GrafanaConfig{URL: tc.testURL, AuthToken: tc.testAuthToken, Tags: tc.tags},
 				tagExtractorMock,
 				cacheMock,
 				clientMock,
@@ -254,6 +293,483 @@ func TestPostAnnotation(t *testing.T) {
 	}
 }
 
+func TestPostAnnotationRetries(t *testing.T) {
+	unavailable := func() *http.Response {
+		return &http.Response{StatusCode: 503, Status: "Service Unavailable"}
+	}
+	testCases := map[string]struct {
+		retries         int
+		cachedID        int
+		setupClientMock func(m *mockHttpClient)
+		expectCacheAdd  bool
+		expectedID      int
+		expectedErr     string
+	}{
+		"retries server errors until success": {
+			retries: 3,
+			setupClientMock: func(m *mockHttpClient) {
+				m.On("Do", mock.Anything).Times(2).Return(unavailable(), nil)
+				m.On("Do", mock.Anything).Once().Return(responseWithBody(`{"id": 1}`), nil)
+			},
+			cachedID:       -1,
+			expectCacheAdd: true,
+			expectedID:     1,
+		},
+		"retries connection errors until attempts are exhausted": {
+			retries: 2,
+			setupClientMock: func(m *mockHttpClient) {
+				m.On("Do", mock.Anything).Times(3).Return(nil, assert.AnError)
+			},
+			cachedID:    -1,
+			expectedID:  -1,
+			expectedErr: assert.AnError.Error() + " (attempt 3)",
+		},
+		"does not retry client errors": {
+			retries: 3,
+			setupClientMock: func(m *mockHttpClient) {
+				m.On("Do", mock.Anything).Once().Return(&http.Response{StatusCode: 400, Status: "Bad Request"}, nil)
+			},
+			cachedID:    -1,
+			expectedID:  -1,
+			expectedErr: `call to http://grafana.com/api/annotations failed with HTTP 400 "Bad Request" (attempt 1)`,
+		},
+		"falls back to POST when PATCH target no longer exists": {
+			retries:  3,
+			cachedID: 98,
+			setupClientMock: func(m *mockHttpClient) {
+				m.On("Do", mock.MatchedBy(isOpenCheck(98))).Once().Return(responseWithBody(`{"id": 98}`), nil)
+				m.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+					return req.Method == "PATCH" && req.URL.Path == "/api/annotations/98"
+				})).Once().Return(&http.Response{StatusCode: 404, Status: "Not Found"}, nil)
+				m.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+					return req.Method == "POST" && req.URL.Path == "/api/annotations" &&
+						assert.Equal(t, `{"time":1577880000000,"text":"test notification"}`, readBody(req))
+				})).Once().Return(responseWithBody(`{"id": 99}`), nil)
+			},
+			expectCacheAdd: true,
+			expectedID:     99,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			cacheMock := new(MockRegionMatcher)
+			clientMock := new(mockHttpClient)
+			defer func() {
+				cacheMock.AssertExpectations(t)
+				clientMock.AssertExpectations(t)
+			}()
+			cacheMock.On("Match", "test notification").Once().Return(tc.cachedID)
+			if tc.expectCacheAdd {
+				cacheMock.On("AddKey", "test notification", tc.expectedID, mock.Anything).Once()
+			}
+			tc.setupClientMock(clientMock)
+
+			a := NewRegionMatchingAnnotator(
+				GrafanaConfig{
+					URL:             "http://grafana.com",
+					Retries:         tc.retries,
+					RetryBackoff:    time.Millisecond,
+					RetryMaxBackoff: 2 * time.Millisecond,
+					RetryJitter:     0.5,
+				},
+				tagextractor.NewNoOpTagExtractor(),
+				cacheMock,
+				clientMock,
+				log.New(io.Discard, "", 0),
+			)
+
+			id, err := a.Post("test notification", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+
+			assert.Equal(t, tc.expectedID, id)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestPostAnnotationChecksMatchedAnnotationIsOpen(t *testing.T) {
+	testCases := map[string]struct {
+		checkResponse *http.Response
+		expectPatch   bool
+	}{
+		"open annotation is closed": {
+			checkResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"id": 98, "time": 1577876400000, "timeEnd": 1577876400000}`))},
+			expectPatch:   true,
+		},
+		"deleted annotation is replaced": {
+			checkResponse: &http.Response{StatusCode: 404, Status: "Not Found"},
+		},
+		"closed annotation is replaced": {
+			checkResponse: &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"id": 98, "time": 1577876400000, "timeEnd": 1577878200000}`))},
+		},
+		"failed check assumes the annotation is open": {
+			checkResponse: &http.Response{StatusCode: 500, Status: "Internal Server Error"},
+			expectPatch:   true,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			cacheMock := new(MockRegionMatcher)
+			clientMock := new(mockHttpClient)
+			defer func() {
+				cacheMock.AssertExpectations(t)
+				clientMock.AssertExpectations(t)
+			}()
+			cacheMock.On("Match", "test notification").Once().Return(98)
+			clientMock.On("Do", mock.MatchedBy(isOpenCheck(98))).Once().Return(tc.checkResponse, nil)
+			expectedID := 98
+			if tc.expectPatch {
+				clientMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+					return req.Method == "PATCH" && req.URL.Path == "/api/annotations/98"
+				})).Once().Return(responseWithBody(`{"id": 98}`), nil)
+			} else {
+				expectedID = 99
+				cacheMock.On("AddKey", "test notification", 99, mock.Anything).Once()
+				clientMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+					return req.Method == "POST" && req.URL.Path == "/api/annotations" &&
+						assert.Equal(t, `{"time":1577880000000,"text":"test notification"}`, readBody(req))
+				})).Once().Return(responseWithBody(`{"id": 99}`), nil)
+			}
+
+			a := NewRegionMatchingAnnotator(
+				GrafanaConfig{URL: "http://grafana.com"},
+				tagextractor.NewNoOpTagExtractor(),
+				cacheMock,
+				clientMock,
+				log.New(io.Discard, "", 0),
+			)
+
+			id, err := a.Post("test notification", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+
+			require.NoError(t, err)
+			assert.Equal(t, expectedID, id)
+		})
+	}
+}
+
+func TestPostStructuredAnnotation(t *testing.T) {
+	eventTime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	clientMock := new(mockHttpClient)
+	defer clientMock.AssertExpectations(t)
+
+	clientMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "POST" &&
+			assert.Equal(t, `{"tags":["tag1","ups"],"time":1577880000000,"text":"[not a tag] On battery"}`, readBody(req))
+	})).Once().Return(responseWithBody(`{"id": 5}`), nil)
+	clientMock.On("Do", mock.MatchedBy(isOpenCheck(5))).Once().Return(&http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"id": 5, "time": 1577880000000, "tags": ["tag1", "ups"]}`))}, nil)
+	clientMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "PATCH" &&
+			assert.Equal(t, "/api/annotations/5", req.URL.Path) &&
+			assert.Equal(t, `{"tags":["tag1","ups"],"time":1577880000000,"timeEnd":1577883600000,"isRegion":true,"text":"[not a tag] On battery"}`, readBody(req))
+	})).Once().Return(responseWithBody(`{"id": 5}`), nil)
+
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{URL: "http://grafana.example.com", Tags: []string{"tag1"}},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		NewRegionMatcher(20, 0, nil, log.New(io.Discard, "", 0)),
+		clientMock,
+		log.New(io.Discard, "", 0),
+	)
+
+	id, err := a.PostAnnotation(Annotation{Text: "[not a tag] On battery", Tags: []string{"ups"}, Time: eventTime})
+	require.NoError(t, err)
+	assert.Equal(t, 5, id)
+
+	id, err = a.PostAnnotation(Annotation{Text: "[not a tag] On battery", Tags: []string{"ups"}, Time: eventTime.Add(time.Hour), End: true})
+	require.NoError(t, err)
+	assert.Equal(t, 5, id)
+}
+
+func TestPostAnnotationClosesRegionWithCompletePayload(t *testing.T) {
+	var patchBody grafanaAnnotation
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			fmt.Fprint(w, `{"id": 7}`)
+		case r.Method == "GET" && r.URL.Path == "/api/annotations/7":
+			fmt.Fprint(w, `{"id": 7, "time": 1577880000000, "tags": ["qnap", "manual"], "text": "On battery"}`)
+		case r.Method == "PATCH" && r.URL.Path == "/api/annotations/7":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&patchBody))
+			fmt.Fprint(w, `{"id": 7}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{URL: server.URL, Tags: []string{"qnap"}},
+		tagextractor.NewNoOpTagExtractor(),
+		NewRegionMatcher(20, 0, nil, log.New(io.Discard, "", 0)),
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	eventTime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	_, err := a.PostAnnotation(Annotation{Text: "On battery", Time: eventTime})
+	require.NoError(t, err)
+	id, err := a.PostAnnotation(Annotation{Text: "On battery", Time: eventTime.Add(time.Hour), End: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, 7, id)
+	assert.Equal(t, int64(1577880000000), patchBody.Time)
+	assert.Equal(t, int64(1577883600000), patchBody.TimeEnd)
+	assert.True(t, patchBody.IsRegion)
+	assert.Equal(t, []string{"qnap", "manual"}, patchBody.Tags)
+}
+
+func TestPostAnnotationUpdatesRecreatedRegion(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "POST":
+			fmt.Fprintf(w, `{"id": %d}`, 6+len(requests))
+		case r.Method == "GET":
+			fmt.Fprint(w, `{"id": 7, "time": 1577880000000, "text": "On battery"}`)
+		case r.Method == "PATCH" && r.URL.Path == "/api/annotations/7":
+			// The region was deleted from Grafana after it was checked
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer server.Close()
+
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{URL: server.URL},
+		tagextractor.NewNoOpTagExtractor(),
+		NewRegionMatcher(20, 0, nil, log.New(io.Discard, "", 0)),
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	eventTime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	_, err := a.PostAnnotation(Annotation{Text: "On battery", Time: eventTime})
+	require.NoError(t, err)
+	id, err := a.PostAnnotation(Annotation{Text: "On battery", Time: eventTime.Add(time.Hour), End: true})
+	require.NoError(t, err)
+	assert.Equal(t, 10, id)
+
+	// The next update applies to the recreated annotation, rather than to the stale region again
+	requests = nil
+	_, err = a.PostAnnotation(Annotation{Text: "On battery", Time: eventTime.Add(2 * time.Hour), End: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GET /api/annotations/10", "PATCH /api/annotations/10"}, requests)
+}
+
+func TestPostAnnotationWithKey(t *testing.T) {
+	eventTime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	cacheMock := new(MockRegionMatcher)
+	clientMock := new(mockHttpClient)
+	defer func() {
+		cacheMock.AssertExpectations(t)
+		clientMock.AssertExpectations(t)
+	}()
+	cacheMock.On("AddKey", "volume:2", 1, eventTime).Once()
+	cacheMock.On("MatchKey", "volume:2").Once().Return(1)
+	clientMock.On("Do", mock.MatchedBy(func(req *http.Request) bool { return req.Method == "POST" })).
+		Once().Return(responseWithBody(`{"id": 1}`), nil)
+	clientMock.On("Do", mock.MatchedBy(isOpenCheck(1))).Once().Return(responseWithBody(`{"id": 1}`), nil)
+	clientMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "PATCH" && req.URL.Path == "/api/annotations/1"
+	})).Once().Return(responseWithBody(`{"id": 1}`), nil)
+
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{URL: "http://grafana.example.com"},
+		tagextractor.NewNoOpTagExtractor(),
+		cacheMock,
+		clientMock,
+		log.New(io.Discard, "", 0),
+	)
+
+	id, err := a.PostAnnotation(Annotation{Text: "Volume Media degraded", Key: "volume:2", Time: eventTime})
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	// The end event closes the region regardless of its text
+	id, err = a.PostAnnotation(Annotation{Text: "Volume Media rebuilt", Key: "volume:2", Time: eventTime.Add(time.Hour), End: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+}
+
+func TestPostStructuredAnnotationDefaults(t *testing.T) {
+	clientMock := new(mockHttpClient)
+	defer clientMock.AssertExpectations(t)
+
+	start := time.Now()
+	isPostAtCurrentTime := func(req *http.Request) bool {
+		var ga grafanaAnnotation
+		return assert.NoError(t, json.Unmarshal([]byte(readBody(req)), &ga)) &&
+			assert.Equal(t, "POST", req.Method) &&
+			assert.GreaterOrEqual(t, ga.Time, start.UnixNano()/1000000) &&
+			assert.Zero(t, ga.TimeEnd)
+	}
+	clientMock.On("Do", mock.MatchedBy(isPostAtCurrentTime)).Once().Return(responseWithBody(`{"id": 2}`), nil)
+	clientMock.On("Do", mock.MatchedBy(isPostAtCurrentTime)).Once().Return(responseWithBody(`{"id": 1}`), nil)
+
+	cacheMock := new(MockRegionMatcher)
+	defer cacheMock.AssertExpectations(t)
+	cacheMock.On("Match", "[ups] On line").Twice().Return(-1)
+	cacheMock.On("MatchKey", "[ups] On line").Once().Return(-1)
+	cacheMock.On("AddKey", "[ups] On line", 1, mock.Anything).Once()
+
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{URL: "http://grafana.example.com"},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		cacheMock,
+		clientMock,
+		log.New(io.Discard, "", 0),
+	)
+
+	// An unmatched end event is posted as a plain annotation, and is not added to the cache
+	_, err := a.PostAnnotation(Annotation{Text: "On line", Tags: []string{"ups"}, End: true})
+	require.NoError(t, err)
+
+	_, err = a.PostAnnotation(Annotation{Text: "On line", Tags: []string{"ups"}})
+	require.NoError(t, err)
+}
+
+func TestPostAnnotationTargeting(t *testing.T) {
+	testCases := map[string]struct {
+		config       GrafanaConfig
+		post         func(a Annotator) (int, error)
+		expectedBody string
+	}{
+		"global by default": {
+			post: func(a Annotator) (int, error) {
+				return a.Post("[ups] on battery", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+			},
+			expectedBody: `{"tags":["ups"],"time":1577880000000,"text":"on battery"}`,
+		},
+		"configured dashboard and panel": {
+			config: GrafanaConfig{DashboardUID: "qnap", PanelID: 2},
+			post: func(a Annotator) (int, error) {
+				return a.Post("[ups] on battery", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+			},
+			expectedBody: `{"dashboardUID":"qnap","panelId":2,"tags":["ups"],"time":1577880000000,"text":"on battery"}`,
+		},
+		"tags override configuration": {
+			config: GrafanaConfig{DashboardUID: "qnap", PanelID: 2},
+			post: func(a Annotator) (int, error) {
+				return a.Post("[dashboard:ups] [panel:7] [ups] on battery", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+			},
+			expectedBody: `{"dashboardUID":"ups","panelId":7,"tags":["ups"],"time":1577880000000,"text":"on battery"}`,
+		},
+		"invalid panel tag is kept": {
+			post: func(a Annotator) (int, error) {
+				return a.Post("[panel:x] on battery", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+			},
+			expectedBody: `{"tags":["panel:x"],"time":1577880000000,"text":"on battery"}`,
+		},
+		"structured annotation overrides configuration": {
+			config: GrafanaConfig{DashboardUID: "qnap", PanelID: 2},
+			post: func(a Annotator) (int, error) {
+				return a.PostAnnotation(Annotation{
+					Text:         "on battery",
+					Time:         time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+					DashboardUID: "ups",
+					PanelID:      3,
+				})
+			},
+			expectedBody: `{"dashboardUID":"ups","panelId":3,"time":1577880000000,"text":"on battery"}`,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			clientMock := new(mockHttpClient)
+			defer clientMock.AssertExpectations(t)
+			clientMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+				return assert.Equal(t, tc.expectedBody, readBody(req))
+			})).Once().Return(responseWithBody(`{"id": 1}`), nil)
+
+			tc.config.URL = "http://grafana.example.com"
+			a := NewRegionMatchingAnnotator(
+				tc.config,
+				tagextractor.NewNotificationCenterTagExtractor(),
+				NewNoOpRegionMatcher(),
+				clientMock,
+				log.New(io.Discard, "", 0),
+			)
+
+			id, err := tc.post(a)
+			require.NoError(t, err)
+			assert.Equal(t, 1, id)
+		})
+	}
+}
+
+func TestPostAnnotationSurfacesGrafanaValidationErrors(t *testing.T) {
+	clientMock := new(mockHttpClient)
+	defer clientMock.AssertExpectations(t)
+	clientMock.On("Do", mock.Anything).Once().Return(&http.Response{
+		StatusCode: 400,
+		Status:     "Bad Request",
+		Body:       io.NopCloser(strings.NewReader(`{"message":"Dashboard not found"}`)),
+	}, nil)
+
+	a := NewSimpleAnnotator(GrafanaConfig{URL: "http://grafana.com", DashboardUID: "unknown"}, clientMock, log.New(io.Discard, "", 0))
+
+	_, err := a.Post("test notification", time.Now())
+	assert.EqualError(t, err, `call to http://grafana.com/api/annotations failed with HTTP 400 "Bad Request": Dashboard not found`)
+}
+
+func TestPostAnnotationAuthentication(t *testing.T) {
+	testCases := map[string]struct {
+		config                GrafanaConfig
+		expectedAuthorization string
+		expectedOrgID         string
+	}{
+		"anonymous": {
+			expectedAuthorization: "",
+		},
+		"token": {
+			config:                GrafanaConfig{AuthToken: "token1"},
+			expectedAuthorization: "Bearer token1",
+		},
+		"basic auth": {
+			config:                GrafanaConfig{Username: "admin", Password: "secret"},
+			expectedAuthorization: "Basic YWRtaW46c2VjcmV0",
+		},
+		"token wins over basic auth": {
+			config:                GrafanaConfig{AuthToken: "token1", Username: "admin", Password: "secret"},
+			expectedAuthorization: "Bearer token1",
+		},
+		"organization": {
+			config:                GrafanaConfig{Username: "admin", Password: "secret", OrgID: 3},
+			expectedAuthorization: "Basic YWRtaW46c2VjcmV0",
+			expectedOrgID:         "3",
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			var requests []*http.Request
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r)
+				_, _ = io.WriteString(w, `{"id": 1}`)
+			}))
+			defer server.Close()
+
+			tc.config.URL = server.URL
+			a := NewSimpleAnnotator(tc.config, nil, log.New(io.Discard, "", 0))
+
+			_, err := a.Post("test notification", time.Now())
+			require.NoError(t, err)
+
+			require.Len(t, requests, 1)
+			assert.Equal(t, tc.expectedAuthorization, requests[0].Header.Get("Authorization"))
+			assert.Equal(t, tc.expectedOrgID, requests[0].Header.Get("X-Grafana-Org-Id"))
+		})
+	}
+}
+
 func readBody(req *http.Request) string {
 	body, err := req.GetBody()
 	if err != nil {
@@ -268,6 +784,19 @@ func readBody(req *http.Request) string {
 	return string(b)
 }
 
+func requestWithHeader(key, value string) interface{} {
+	return mock.MatchedBy(func(req *http.Request) bool {
+		return req.Header.Get(key) == value
+	})
+}
+
+// isOpenCheck matches the request checking that annotation id is still open before closing it
+func isOpenCheck(id int) func(req *http.Request) bool {
+	return func(req *http.Request) bool {
+		return req.Method == "GET" && req.URL.Path == fmt.Sprintf("/api/annotations/%d", id)
+	}
+}
+
 func responseWithBody(body string) *http.Response {
 	return &http.Response{Body: io.NopCloser(strings.NewReader(body))}
 }
@@ -0,0 +1,101 @@
+package notifications
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxAnnotationRequestSize bounds the size of the body accepted by the annotation handler
+const maxAnnotationRequestSize = 64 * 1024
+
+type annotationRequest struct {
+	Text string   `json:"text"`
+	Tags []string `json:"tags"`
+	End  bool     `json:"end"`
+}
+
+type annotationResponse struct {
+	ID int `json:"id"`
+}
+
+type annotationHandler struct {
+	annotator Annotator
+	token     string
+	logger    *log.Logger
+}
+
+// NewAnnotationHandler creates an HTTP handler posting the annotation in the JSON body of POST requests
+// ({"text": "...", "tags": ["..."], "end": false}) through annotator, and responding with the annotation ID.
+// Requests must be authenticated with token, either as a bearer token or as the basic authentication password.
+func NewAnnotationHandler(annotator Annotator, token string, logger *log.Logger) http.Handler {
+	return &annotationHandler{
+		annotator: annotator,
+		token:     token,
+		logger:    logger,
+	}
+}
+
+func (h *annotationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if !tokenAuthorized(r, h.token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="qnapexporter"`)
+		http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+		return
+	}
+
+	var req annotationRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnotationRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("request body exceeds %d bytes", maxAnnotationRequestSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	annotation := Annotation{Text: strings.TrimSpace(req.Text), End: req.End}
+	if annotation.Text == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+	for _, tag := range req.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			annotation.Tags = append(annotation.Tags, tag)
+		}
+	}
+
+	id, err := h.annotator.PostAnnotation(annotation)
+	if err != nil {
+		h.logger.Printf("Error posting annotation received from %s: %v\n", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(annotationResponse{ID: id})
+}
+
+// tokenAuthorized returns whether the request carries token, either as a bearer token or as the basic authentication
+// password. No request is authorized with an empty token.
+func tokenAuthorized(r *http.Request, token string) bool {
+	var provided string
+	if _, password, ok := r.BasicAuth(); ok {
+		provided = password
+	} else if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		provided = strings.TrimPrefix(header, "Bearer ")
+	}
+
+	return token != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
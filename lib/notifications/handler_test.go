@@ -0,0 +1,234 @@
+package notifications
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotationHandler(t *testing.T) {
+	testCases := map[string]struct {
+		method         string
+		body           string
+		setupRequest   func(r *http.Request)
+		setupAnnotator func(m *MockAnnotator)
+		expectedStatus int
+		expectedBody   string
+	}{
+		"posts the annotation": {
+			body: `{"text": " Garage door opened ", "tags": ["home-assistant", " "], "end": true}`,
+			setupAnnotator: func(m *MockAnnotator) {
+				m.On("PostAnnotation", Annotation{Text: "Garage door opened", Tags: []string{"home-assistant"}, End: true}).
+					Once().
+					Return(42, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":42}` + "\n",
+		},
+		"accepts basic authentication": {
+			body:         `{"text": "Backup started"}`,
+			setupRequest: func(r *http.Request) { r.SetBasicAuth("user", "secret") },
+			setupAnnotator: func(m *MockAnnotator) {
+				m.On("PostAnnotation", Annotation{Text: "Backup started"}).Once().Return(1, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":1}` + "\n",
+		},
+		"rejects missing token": {
+			body:           `{"text": "Backup started"}`,
+			setupRequest:   func(r *http.Request) { r.Header.Del("Authorization") },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"rejects invalid token": {
+			body:           `{"text": "Backup started"}`,
+			setupRequest:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"rejects other methods": {
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"rejects invalid JSON": {
+			body:           `{"text": `,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"rejects unknown fields": {
+			body:           `{"text": "Backup started", "color": "red"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"rejects empty text": {
+			body:           `{"text": "  ", "tags": ["backup"]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "text is required\n",
+		},
+		"rejects large bodies": {
+			body:           fmt.Sprintf(`{"text": %q}`, strings.Repeat("a", maxAnnotationRequestSize)),
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		"reports notifier failures": {
+			body: `{"text": "Backup started"}`,
+			setupAnnotator: func(m *MockAnnotator) {
+				m.On("PostAnnotation", Annotation{Text: "Backup started"}).Once().Return(-1, fmt.Errorf("grafana: connection refused"))
+			},
+			expectedStatus: http.StatusBadGateway,
+			expectedBody:   "grafana: connection refused\n",
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			annotator := new(MockAnnotator)
+			defer annotator.AssertExpectations(t)
+			if tc.setupAnnotator != nil {
+				tc.setupAnnotator(annotator)
+			}
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			req := httptest.NewRequest(method, "/annotation", strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer secret")
+			if tc.setupRequest != nil {
+				tc.setupRequest(req)
+			}
+			rec := httptest.NewRecorder()
+
+			NewAnnotationHandler(annotator, "secret", log.New(io.Discard, "", 0)).ServeHTTP(rec, req)
+
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAnnotationHandlerWithoutToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/annotation", strings.NewReader(`{"text": "Backup started"}`))
+	rec := httptest.NewRecorder()
+
+	NewAnnotationHandler(new(MockAnnotator), "", log.New(io.Discard, "", 0)).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestAlertmanagerHandler(t *testing.T) {
+	startsAt := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	endsAt := startsAt.Add(time.Hour)
+	group := `{
+		"version": "4",
+		"groupKey": "{}:{alertname=\"DiskFull\"}",
+		"status": "firing",
+		"alerts": [
+			{
+				"status": "firing",
+				"labels": {"alertname": "DiskFull", "volume": "Data"},
+				"annotations": {"summary": "Volume Data is almost full"},
+				"startsAt": "2020-01-01T12:00:00Z",
+				"endsAt": "0001-01-01T00:00:00Z",
+				"fingerprint": "a1b2c3"
+			},
+			{
+				"status": "resolved",
+				"labels": {"alertname": "NASDown"},
+				"annotations": {},
+				"startsAt": "2020-01-01T12:00:00Z",
+				"endsAt": "2020-01-01T13:00:00Z",
+				"fingerprint": "d4e5f6"
+			}
+		]
+	}`
+	firing := Annotation{
+		Text: "Volume Data is almost full",
+		Tags: []string{"alertname:DiskFull", "volume:Data"},
+		Time: startsAt,
+		Key:  "alertmanager:a1b2c3",
+	}
+	resolved := Annotation{Text: "NASDown", Tags: []string{"alertname:NASDown"}, Time: endsAt, End: true, Key: "alertmanager:d4e5f6"}
+
+	testCases := map[string]struct {
+		method         string
+		body           string
+		setupRequest   func(r *http.Request)
+		setupAnnotator func(m *MockAnnotator)
+		expectedStatus int
+		expectedBody   string
+	}{
+		"posts every alert of the group": {
+			body: group,
+			setupAnnotator: func(m *MockAnnotator) {
+				m.On("PostAnnotation", firing).Once().Return(1, nil)
+				m.On("PostAnnotation", resolved).Once().Return(2, nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		"reports notifier failures after posting the other alerts": {
+			body: group,
+			setupAnnotator: func(m *MockAnnotator) {
+				m.On("PostAnnotation", firing).Once().Return(-1, fmt.Errorf("grafana: connection refused"))
+				m.On("PostAnnotation", resolved).Once().Return(2, nil)
+			},
+			expectedStatus: http.StatusBadGateway,
+			expectedBody:   "grafana: connection refused\n",
+		},
+		"rejects invalid token": {
+			body:           group,
+			setupRequest:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"rejects other methods": {
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"rejects invalid JSON": {
+			body:           `{"alerts": `,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"rejects other webhook versions": {
+			body:           `{"version": "5", "alerts": []}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unsupported webhook version \"5\"\n",
+		},
+		"rejects large bodies": {
+			body:           fmt.Sprintf(`{"version": "4", "groupKey": %q}`, strings.Repeat("a", maxAlertmanagerRequestSize)),
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			annotator := new(MockAnnotator)
+			defer annotator.AssertExpectations(t)
+			if tc.setupAnnotator != nil {
+				tc.setupAnnotator(annotator)
+			}
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			req := httptest.NewRequest(method, "/alertmanager", strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer secret")
+			if tc.setupRequest != nil {
+				tc.setupRequest(req)
+			}
+			rec := httptest.NewRecorder()
+
+			NewAlertmanagerHandler(annotator, "secret", log.New(io.Discard, "", 0)).ServeHTTP(rec, req)
+
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+)
+
+const (
+	// DefaultLokiBatchSize is the number of log lines pushed in a single request when none is configured
+	DefaultLokiBatchSize = 100
+	// DefaultLokiBatchWait is the maximum time a log line waits to be pushed when none is configured
+	DefaultLokiBatchWait = 5 * time.Second
+
+	lokiPushPath = "/loki/api/v1/push"
+	// lokiMaxPendingBatches bounds the number of batches kept in memory while Loki is unreachable
+	lokiMaxPendingBatches = 10
+)
+
+// LokiConfig holds the settings used to push annotations to Loki as log lines
+type LokiConfig struct {
+	// URL is the base URL of Loki (e.g. http://loki:3100)
+	URL      string
+	Username string
+	Password string
+	// TenantID is sent as the X-Scope-OrgID header of multi-tenant Loki installations
+	TenantID string
+	// Hostname returns the value of the host label (defaults to the OS hostname)
+	Hostname func() string
+	// BatchSize is the maximum number of log lines pushed in a single request (defaults to DefaultLokiBatchSize)
+	BatchSize int
+	// BatchWait is the maximum time a log line waits to be pushed (defaults to DefaultLokiBatchWait)
+	BatchWait time.Duration
+	// Timeout bounds each request made to Loki (defaults to DefaultTimeout)
+	Timeout time.Duration
+	// Retries is the number of additional attempts to push a batch after a connection error or an HTTP 5xx response
+	Retries int
+}
+
+// LokiStats holds the counters of a LokiNotifier
+type LokiStats struct {
+	Pushed  uint64
+	Dropped uint64
+}
+
+type lokiEntry struct {
+	tag  string
+	time time.Time
+	line string
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+// LokiNotifier is an Annotator which pushes annotations to Loki as log lines labelled
+// {job="qnapexporter", host=<hostname>, tag=<first tag>}. Lines are pushed in batches from a worker goroutine,
+// once BatchSize lines are waiting or the oldest one has waited for BatchWait.
+type LokiNotifier struct {
+	LokiConfig
+
+	tagExtractor tagextractor.TagExtractor
+	client       httpClient
+	logger       *log.Logger
+	sleep        func(time.Duration)
+
+	mu      sync.Mutex
+	pending []lokiEntry
+	// oldest is the time the oldest pending line was queued
+	oldest time.Time
+	closed bool
+	stats  LokiStats
+
+	wakeCh  chan struct{}
+	closeCh chan struct{}
+	doneCh  chan struct{}
+}
+
+// NewLokiNotifier creates a LokiNotifier and starts its worker.
+// The tagExtractor is used to extract tags from annotations passed to Post.
+// If c is nil, an HTTP client honoring config.Timeout is created.
+func NewLokiNotifier(config LokiConfig, tagExtractor tagextractor.TagExtractor, c httpClient, logger *log.Logger) *LokiNotifier {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultLokiBatchSize
+	}
+	if config.BatchWait <= 0 {
+		config.BatchWait = DefaultLokiBatchWait
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Hostname == nil {
+		hostname, _ := os.Hostname()
+		config.Hostname = func() string { return hostname }
+	}
+	if c == nil {
+		c = &http.Client{Timeout: config.Timeout}
+	}
+
+	n := &LokiNotifier{
+		LokiConfig:   config,
+		tagExtractor: tagExtractor,
+		client:       c,
+		logger:       logger,
+		sleep:        time.Sleep,
+		wakeCh:       make(chan struct{}, 1),
+		closeCh:      make(chan struct{}),
+		doneCh:       make(chan struct{}),
+	}
+	go n.run()
+
+	return n
+}
+
+func (n *LokiNotifier) Post(annotation string, time time.Time) (int, error) {
+	text, tags := n.tagExtractor.Extract(annotation)
+
+	return n.PostAnnotation(Annotation{Text: text, Tags: tags, Time: time})
+}
+
+// PostAnnotation queues the annotation to be pushed with the next batch.
+// Loki has no notion of IDs, so 0 is returned once queued.
+func (n *LokiNotifier) PostAnnotation(annotation Annotation) (int, error) {
+	entry := lokiEntry{time: annotation.Time, line: annotation.Text}
+	if entry.time.IsZero() {
+		entry.time = time.Now()
+	}
+	if len(annotation.Tags) > 0 {
+		entry.tag = annotation.Tags[0]
+	}
+	if annotation.End {
+		entry.line += " (end)"
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		n.stats.Dropped++
+		return -1, fmt.Errorf("loki notifier is closed")
+	}
+	if len(n.pending) >= lokiMaxPendingBatches*n.BatchSize {
+		n.stats.Dropped++
+		return -1, fmt.Errorf("too many log lines waiting to be pushed to Loki")
+	}
+	if len(n.pending) == 0 {
+		n.oldest = time.Now()
+	}
+	n.pending = append(n.pending, entry)
+	if len(n.pending) == 1 || len(n.pending) >= n.BatchSize {
+		select {
+		case n.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+
+	return 0, nil
+}
+
+// Stats returns a snapshot of the counters
+func (n *LokiNotifier) Stats() LokiStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.stats
+}
+
+// Close stops accepting annotations and waits up to timeout for the pending ones to be pushed
+func (n *LokiNotifier) Close(timeout time.Duration) {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	n.mu.Unlock()
+	close(n.closeCh)
+
+	select {
+	case <-n.doneCh:
+	case <-time.After(timeout):
+		n.logger.Println("Timed out pushing pending log lines to Loki")
+	}
+}
+
+func (n *LokiNotifier) run() {
+	defer close(n.doneCh)
+
+	for {
+		n.mu.Lock()
+		closed := n.closed
+		count := len(n.pending)
+		wait := n.BatchWait - time.Since(n.oldest)
+		// Push full batches right away, partial ones once the oldest line waited for BatchWait,
+		// and everything on close
+		if count > 0 && (count >= n.BatchSize || wait <= 0 || closed) {
+			if count > n.BatchSize {
+				count = n.BatchSize
+			}
+			batch := n.pending[:count:count]
+			n.pending = n.pending[count:]
+			n.oldest = time.Now()
+			n.mu.Unlock()
+
+			n.pushBatch(batch)
+			continue
+		}
+		n.mu.Unlock()
+
+		if closed {
+			return
+		}
+
+		var timer *time.Timer
+		var timerCh <-chan time.Time
+		if count > 0 {
+			timer = time.NewTimer(wait)
+			timerCh = timer.C
+		}
+		select {
+		case <-n.wakeCh:
+		case <-timerCh:
+		case <-n.closeCh:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (n *LokiNotifier) pushBatch(batch []lokiEntry) {
+	err := n.push(batch)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err != nil {
+		n.stats.Dropped += uint64(len(batch))
+		n.logger.Printf("Error pushing %d log lines to Loki, dropping them: %v\n", len(batch), err)
+		return
+	}
+	n.stats.Pushed += uint64(len(batch))
+}
+
+// push sends the batch to Loki, retrying connection errors and HTTP 5xx responses
+func (n *LokiNotifier) push(batch []lokiEntry) error {
+	body, err := json.Marshal(n.pushRequest(batch))
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		var statusCode int
+		statusCode, err = n.send(body)
+		if err == nil || !isRetryable(statusCode) || attempt > n.Retries {
+			return err
+		}
+
+		n.logger.Printf("Error pushing to Loki, retrying: %v\n", err)
+		n.sleep(retryDelay(attempt, DefaultRetryBackoff, DefaultRetryMaxBackoff, 0))
+	}
+}
+
+// pushRequest groups the entries in streams by tag, in chronological order as required by Loki
+func (n *LokiNotifier) pushRequest(batch []lokiEntry) lokiPushRequest {
+	entries := append([]lokiEntry(nil), batch...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].time.Before(entries[j].time) })
+
+	host := n.Hostname()
+	req := lokiPushRequest{}
+	streams := map[string]int{}
+	for _, entry := range entries {
+		idx, ok := streams[entry.tag]
+		if !ok {
+			labels := map[string]string{"job": "qnapexporter", "host": host}
+			if entry.tag != "" {
+				labels["tag"] = entry.tag
+			}
+			idx = len(req.Streams)
+			streams[entry.tag] = idx
+			req.Streams = append(req.Streams, lokiStream{Stream: labels})
+		}
+		req.Streams[idx].Values = append(req.Streams[idx].Values, [2]string{strconv.FormatInt(entry.time.UnixNano(), 10), entry.line})
+	}
+
+	return req
+}
+
+// send posts the body once, returning the HTTP status code (0 if the request failed)
+func (n *LokiNotifier) send(body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	defer cancel()
+
+	url := strings.TrimRight(n.URL, "/") + lokiPushPath
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Username != "" || n.Password != "" {
+		req.SetBasicAuth(n.Username, n.Password)
+	}
+	if n.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", n.TenantID)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("push to Loki: %w", err)
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+
+	if resp.StatusCode >= 300 {
+		var message []byte
+		if resp.Body != nil {
+			message, _ = io.ReadAll(io.LimitReader(resp.Body, 1024))
+		}
+		return resp.StatusCode, fmt.Errorf("call to %s failed with HTTP %d %q: %s", url, resp.StatusCode, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return resp.StatusCode, nil
+}
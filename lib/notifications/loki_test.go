@@ -0,0 +1,146 @@
+package notifications
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeLokiServer returns a server recording the push requests, failing the first failures requests with HTTP 500
+func newFakeLokiServer(t *testing.T, failures int) (*httptest.Server, func() []lokiPushRequest) {
+	var mu sync.Mutex
+	var requests []lokiPushRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "/loki/api/v1/push", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "tenant1", r.Header.Get("X-Scope-OrgID"))
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "user1", username)
+		assert.Equal(t, "secret", password)
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var req lokiPushRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	return server, func() []lokiPushRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]lokiPushRequest(nil), requests...)
+	}
+}
+
+func newTestLokiNotifier(url string, batchSize int, batchWait time.Duration, retries int) *LokiNotifier {
+	n := NewLokiNotifier(
+		LokiConfig{
+			URL:       url,
+			Username:  "user1",
+			Password:  "secret",
+			TenantID:  "tenant1",
+			Hostname:  func() string { return "nas1" },
+			BatchSize: batchSize,
+			BatchWait: batchWait,
+			Retries:   retries,
+		},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+	n.sleep = func(time.Duration) {}
+
+	return n
+}
+
+func TestLokiNotifierBatchesBySize(t *testing.T) {
+	server, requests := newFakeLokiServer(t, 0)
+	defer server.Close()
+	n := newTestLokiNotifier(server.URL, 3, time.Hour, 0)
+	defer n.Close(time.Second)
+
+	t0 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	_, err := n.Post("[Backup] Backup started", t0.Add(time.Second))
+	require.NoError(t, err)
+	_, err = n.PostAnnotation(Annotation{Text: "On battery", Tags: []string{"ups", "power"}, Time: t0.Add(2 * time.Second), End: true})
+	require.NoError(t, err)
+	_, err = n.Post("[Backup] Backup finished", t0)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return len(requests()) == 1 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []lokiPushRequest{
+		{Streams: []lokiStream{
+			{
+				Stream: map[string]string{"job": "qnapexporter", "host": "nas1", "tag": "Backup"},
+				Values: [][2]string{{"1577880000000000000", "Backup finished"}, {"1577880001000000000", "Backup started"}},
+			},
+			{
+				Stream: map[string]string{"job": "qnapexporter", "host": "nas1", "tag": "ups"},
+				Values: [][2]string{{"1577880002000000000", "On battery (end)"}},
+			},
+		}},
+	}, requests())
+	assert.Eventually(t, func() bool { return n.Stats() == LokiStats{Pushed: 3} }, 5*time.Second, time.Millisecond)
+}
+
+func TestLokiNotifierBatchesByDelay(t *testing.T) {
+	server, requests := newFakeLokiServer(t, 0)
+	defer server.Close()
+	n := newTestLokiNotifier(server.URL, 100, 50*time.Millisecond, 0)
+	defer n.Close(time.Second)
+
+	_, err := n.Post("No tags", time.Now())
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, requests())
+
+	require.Eventually(t, func() bool { return len(requests()) == 1 }, 5*time.Second, time.Millisecond)
+	stream := requests()[0].Streams[0]
+	assert.Equal(t, map[string]string{"job": "qnapexporter", "host": "nas1"}, stream.Stream)
+	assert.Equal(t, "No tags", stream.Values[0][1])
+}
+
+func TestLokiNotifierRetries(t *testing.T) {
+	testCases := map[string]struct {
+		failures    int
+		wantPushed  uint64
+		wantDropped uint64
+	}{
+		"succeeds after retrying": {failures: 2, wantPushed: 1},
+		"gives up after retries":  {failures: 3, wantDropped: 1},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			server, _ := newFakeLokiServer(t, tc.failures)
+			defer server.Close()
+			n := newTestLokiNotifier(server.URL, 100, time.Hour, 2)
+
+			_, err := n.Post("[Backup] Backup started", time.Now())
+			require.NoError(t, err)
+			// Pending lines are pushed on close
+			n.Close(5 * time.Second)
+
+			assert.Equal(t, LokiStats{Pushed: tc.wantPushed, Dropped: tc.wantDropped}, n.Stats())
+			_, err = n.Post("[Backup] Backup finished", time.Now())
+			assert.Error(t, err)
+		})
+	}
+}
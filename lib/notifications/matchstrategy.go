@@ -0,0 +1,62 @@
+package notifications
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+)
+
+// MatchStrategy derives the key used to match an annotation with the open region it closes,
+// for annotations whose start and end texts differ in ways the built-in replacement rules don't cover
+type MatchStrategy interface {
+	// Key returns the normalized key of an annotation, or "" if it can only be matched by its exact text
+	Key(annotation string) string
+}
+
+type tagKeyStrategy struct {
+	patterns     []*regexp.Regexp
+	tagExtractor tagextractor.TagExtractor
+}
+
+// NewTagKeyStrategy creates a MatchStrategy keying each annotation by its tags and the identifier captured
+// by the first group of the first pattern matching its text, compared case-insensitively.
+// For instance, with the pattern `(?:started|finished) for (.+)`, "[Backup] Backup started for Photos" and
+// "[Backup] Backup finished for Photos" share the same key. The tagExtractor splits the tags from the text.
+func NewTagKeyStrategy(patterns []*regexp.Regexp, tagExtractor tagextractor.TagExtractor) MatchStrategy {
+	return &tagKeyStrategy{
+		patterns:     patterns,
+		tagExtractor: tagExtractor,
+	}
+}
+
+func (s *tagKeyStrategy) Key(annotation string) string {
+	text, tags := s.tagExtractor.Extract(annotation)
+
+	for _, re := range s.patterns {
+		m := re.FindStringSubmatch(text)
+		if len(m) < 2 {
+			continue
+		}
+		id := strings.ToLower(strings.TrimSpace(m[1]))
+		if id == "" {
+			continue
+		}
+
+		return strings.Join(normalizeTags(tags), ",") + "|" + id
+	}
+
+	return ""
+}
+
+// normalizeTags returns the lowercase tags, sorted and without duplicates
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(tag)))
+	}
+	sort.Strings(normalized)
+
+	return mergeTags(normalized, nil)
+}
@@ -0,0 +1,39 @@
+package notifications
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagKeyStrategy(t *testing.T) {
+	s := NewTagKeyStrategy(
+		[]*regexp.Regexp{
+			regexp.MustCompile(`(?:started|finished) for (.+)`),
+			regexp.MustCompile(`^Job (\S+) `),
+		},
+		tagextractor.NewNotificationCenterTagExtractor(),
+	)
+
+	testCases := map[string]struct {
+		annotation string
+		expected   string
+	}{
+		"matches first pattern":     {annotation: "[nas] [Backup] Backup started for Photos", expected: "backup,nas|photos"},
+		"normalizes case":           {annotation: "[Backup] [NAS] Backup finished for photos ", expected: "backup,nas|photos"},
+		"matches second pattern":    {annotation: "[HBS] Job daily completed", expected: "hbs|daily"},
+		"no tags":                   {annotation: "Backup started for Music", expected: "|music"},
+		"no matching pattern":       {annotation: "[nas] [Malware Remover] Started scanning.", expected: ""},
+		"empty capture group":       {annotation: "[Backup] Backup started for  ", expected: ""},
+		"different tags differ":     {annotation: "[nas] [Sync] Backup started for Photos", expected: "nas,sync|photos"},
+		"duplicate tags are merged": {annotation: "[nas] [nas] Backup started for Photos", expected: "nas|photos"},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tc.expected, s.Key(tc.annotation))
+		})
+	}
+}
@@ -33,3 +33,24 @@ func (_m *MockAnnotator) Post(annotation string, _a1 time.Time) (int, error) {
 
 	return r0, r1
 }
+
+// PostAnnotation provides a mock function with given fields: annotation
+func (_m *MockAnnotator) PostAnnotation(annotation Annotation) (int, error) {
+	ret := _m.Called(annotation)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(Annotation) int); ok {
+		r0 = rf(annotation)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(Annotation) error); ok {
+		r1 = rf(annotation)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -2,7 +2,11 @@
 
 package notifications
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	time "time"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // MockRegionMatcher is an autogenerated mock type for the RegionMatcher type
 type MockRegionMatcher struct {
@@ -14,6 +18,16 @@ func (_m *MockRegionMatcher) Add(id int, annotation string) {
 	_m.Called(id, annotation)
 }
 
+// AddKey provides a mock function with given fields: key, id, start
+func (_m *MockRegionMatcher) AddKey(key string, id int, start time.Time) {
+	_m.Called(key, id, start)
+}
+
+// Evict provides a mock function with given fields:
+func (_m *MockRegionMatcher) Evict() {
+	_m.Called()
+}
+
 // Match provides a mock function with given fields: annotation
 func (_m *MockRegionMatcher) Match(annotation string) int {
 	ret := _m.Called(annotation)
@@ -27,3 +41,31 @@ func (_m *MockRegionMatcher) Match(annotation string) int {
 
 	return r0
 }
+
+// MatchKey provides a mock function with given fields: key
+func (_m *MockRegionMatcher) MatchKey(key string) int {
+	ret := _m.Called(key)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(key)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// Remove provides a mock function with given fields: id
+func (_m *MockRegionMatcher) Remove(id int) bool {
+	ret := _m.Called(id)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(int) bool); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
@@ -0,0 +1,24 @@
+// Code generated by mockery v0.0.0-dev. DO NOT EDIT.
+
+package notifications
+
+import mock "github.com/stretchr/testify/mock"
+
+// MockTokenSource is an autogenerated mock type for the TokenSource type
+type MockTokenSource struct {
+	mock.Mock
+}
+
+// Token provides a mock function with given fields:
+func (_m *MockTokenSource) Token() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
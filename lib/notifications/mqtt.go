@@ -0,0 +1,185 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/pedropombeiro/qnapexporter/lib/httpclient"
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+)
+
+const (
+	// DefaultMQTTBaseTopic is the topic under which events are published if none is configured
+	DefaultMQTTBaseTopic = "qnapexporter"
+
+	mqttDefaultSubtopic = "event"
+)
+
+// MQTTConfig holds the settings used to publish annotations to an MQTT broker
+type MQTTConfig struct {
+	// BrokerURL is the address of the broker (e.g. tcp://broker:1883, ssl://broker:8883 or ws://broker:80)
+	BrokerURL string
+	// ClientID identifies the connection to the broker (defaults to qnapexporter-<hostname>)
+	ClientID string
+	Username string
+	Password string
+	// CAFile and InsecureSkipVerify configure the verification of the broker's TLS certificate
+	CAFile             string
+	InsecureSkipVerify bool
+	// BaseTopic is the prefix of the topics events are published to (defaults to DefaultMQTTBaseTopic)
+	BaseTopic string
+	QoS       byte
+	Retain    bool
+	// Timeout bounds the time spent waiting for the broker, both on connection and on each publish
+	// (defaults to DefaultTimeout)
+	Timeout time.Duration
+}
+
+type mqttNotifier struct {
+	MQTTConfig
+
+	tagExtractor tagextractor.TagExtractor
+	client       mqtt.Client
+	logger       *log.Logger
+}
+
+type mqttPayload struct {
+	Text      string    `json:"text"`
+	Tags      []string  `json:"tags"`
+	Timestamp time.Time `json:"timestamp"`
+	End       bool      `json:"end,omitempty"`
+}
+
+// NewMQTTNotifier creates an Annotator which publishes annotations as JSON to an MQTT broker.
+// The connection is established in the background and automatically re-established when lost,
+// so an unreachable broker doesn't prevent startup.
+// The tagExtractor is used to extract tags from annotations passed to Post.
+func NewMQTTNotifier(config MQTTConfig, tagExtractor tagextractor.TagExtractor, logger *log.Logger) (Annotator, error) {
+	if config.BrokerURL == "" {
+		return nil, errors.New("MQTT broker URL is required")
+	}
+	if config.QoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d, expected 0, 1 or 2", config.QoS)
+	}
+	if config.ClientID == "" {
+		hostname, _ := os.Hostname()
+		config.ClientID = "qnapexporter-" + hostname
+	}
+	config = withMQTTDefaults(config)
+
+	tlsConfig, err := httpclient.NewTLSConfig("MQTT", config.CAFile, config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.BrokerURL).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetConnectTimeout(config.Timeout).
+		SetWriteTimeout(config.Timeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(DefaultRetryMaxBackoff).
+		SetOrderMatters(false).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Printf("Lost connection to MQTT broker: %v\n", err)
+		}).
+		SetOnConnectHandler(func(mqtt.Client) {
+			logger.Printf("Connected to MQTT broker %s\n", config.BrokerURL)
+		})
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	client := mqtt.NewClient(opts)
+	// With ConnectRetry, the client keeps trying to connect in the background
+	client.Connect()
+
+	return newMQTTNotifier(config, tagExtractor, client, logger), nil
+}
+
+func newMQTTNotifier(config MQTTConfig, tagExtractor tagextractor.TagExtractor, client mqtt.Client, logger *log.Logger) *mqttNotifier {
+	return &mqttNotifier{
+		MQTTConfig:   withMQTTDefaults(config),
+		tagExtractor: tagExtractor,
+		client:       client,
+		logger:       logger,
+	}
+}
+
+func withMQTTDefaults(config MQTTConfig) MQTTConfig {
+	if config.BaseTopic == "" {
+		config.BaseTopic = DefaultMQTTBaseTopic
+	}
+	config.BaseTopic = strings.TrimSuffix(config.BaseTopic, "/")
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	return config
+}
+
+func (n *mqttNotifier) Post(annotation string, time time.Time) (int, error) {
+	text, tags := n.tagExtractor.Extract(annotation)
+
+	return n.PostAnnotation(Annotation{Text: text, Tags: tags, Time: time})
+}
+
+// PostAnnotation publishes the annotation to <base topic>/<first tag>, or <base topic>/event if it has no tags.
+// It waits at most for the configured timeout for the broker to acknowledge the message.
+// MQTT messages have no ID, so 0 is returned on success.
+func (n *mqttNotifier) PostAnnotation(annotation Annotation) (int, error) {
+	payload := mqttPayload{
+		Text:      annotation.Text,
+		Tags:      annotation.Tags,
+		Timestamp: annotation.Time,
+		End:       annotation.End,
+	}
+	if payload.Timestamp.IsZero() {
+		payload.Timestamp = time.Now()
+	}
+	if payload.Tags == nil {
+		payload.Tags = []string{}
+	}
+
+	jsonBytes, err := json.Marshal(payload)
+	if err != nil {
+		return -1, err
+	}
+
+	topic := n.topic(annotation.Tags)
+	token := n.client.Publish(topic, n.QoS, n.Retain, jsonBytes)
+	if !token.WaitTimeout(n.Timeout) {
+		err = fmt.Errorf("publish to MQTT topic %q: timed out after %v", topic, n.Timeout)
+	} else if token.Error() != nil {
+		err = fmt.Errorf("publish to MQTT topic %q: %w", topic, token.Error())
+	}
+	if err != nil {
+		n.logger.Printf("Error publishing MQTT event: %v\n", err)
+		return -1, err
+	}
+
+	return 0, nil
+}
+
+func (n *mqttNotifier) topic(tags []string) string {
+	subtopic := mqttDefaultSubtopic
+	if len(tags) > 0 {
+		// Wildcards and level separators are not allowed within a topic level
+		subtopic = strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_").Replace(strings.TrimSpace(tags[0]))
+	}
+	if subtopic == "" {
+		subtopic = mqttDefaultSubtopic
+	}
+
+	return n.BaseTopic + "/" + subtopic
+}
@@ -0,0 +1,128 @@
+package notifications
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMQTTToken struct {
+	mqtt.Token
+
+	completed bool
+	err       error
+}
+
+func (t *fakeMQTTToken) WaitTimeout(time.Duration) bool { return t.completed }
+func (t *fakeMQTTToken) Error() error                   { return t.err }
+
+type fakeMQTTClient struct {
+	mqtt.Client
+
+	token    *fakeMQTTToken
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.topic, c.qos, c.retained, c.payload = topic, qos, retained, payload.([]byte)
+	return c.token
+}
+
+func TestNewMQTTNotifierValidation(t *testing.T) {
+	_, err := NewMQTTNotifier(MQTTConfig{}, tagextractor.NewNoOpTagExtractor(), log.New(io.Discard, "", 0))
+	assert.EqualError(t, err, "MQTT broker URL is required")
+
+	_, err = NewMQTTNotifier(MQTTConfig{BrokerURL: "tcp://localhost:1883", QoS: 3}, tagextractor.NewNoOpTagExtractor(), log.New(io.Discard, "", 0))
+	assert.EqualError(t, err, "invalid MQTT QoS 3, expected 0, 1 or 2")
+}
+
+func TestMQTTNotifierPost(t *testing.T) {
+	testCases := map[string]struct {
+		config          MQTTConfig
+		annotation      string
+		token           *fakeMQTTToken
+		expectedTopic   string
+		expectedPayload string
+		expectedErr     string
+	}{
+		"publishes to the first tag": {
+			config:          MQTTConfig{BaseTopic: "nas/", QoS: 1, Retain: true},
+			annotation:      "[Storage & Snapshots] [Volume] RAID degraded",
+			token:           &fakeMQTTToken{completed: true},
+			expectedTopic:   "nas/Storage_&_Snapshots",
+			expectedPayload: `{"text":"RAID degraded","tags":["Storage & Snapshots","Volume"],"timestamp":"2020-01-01T12:00:00Z"}`,
+		},
+		"publishes untagged events to the default subtopic": {
+			annotation:      "Something happened",
+			token:           &fakeMQTTToken{completed: true},
+			expectedTopic:   "qnapexporter/event",
+			expectedPayload: `{"text":"Something happened","tags":[],"timestamp":"2020-01-01T12:00:00Z"}`,
+		},
+		"sanitizes wildcards": {
+			annotation:      "[a/b+#] text",
+			token:           &fakeMQTTToken{completed: true},
+			expectedTopic:   "qnapexporter/a_b__",
+			expectedPayload: `{"text":"text","tags":["a/b+#"],"timestamp":"2020-01-01T12:00:00Z"}`,
+		},
+		"times out": {
+			config:        MQTTConfig{Timeout: 5 * time.Second},
+			annotation:    "text",
+			token:         &fakeMQTTToken{completed: false},
+			expectedTopic: "qnapexporter/event",
+			expectedErr:   `publish to MQTT topic "qnapexporter/event": timed out after 5s`,
+		},
+		"fails": {
+			annotation:    "text",
+			token:         &fakeMQTTToken{completed: true, err: errors.New("not connected")},
+			expectedTopic: "qnapexporter/event",
+			expectedErr:   `publish to MQTT topic "qnapexporter/event": not connected`,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			client := &fakeMQTTClient{token: tc.token}
+			n := newMQTTNotifier(tc.config, tagextractor.NewNotificationCenterTagExtractor(), client, log.New(io.Discard, "", 0))
+
+			id, err := n.Post(tc.annotation, time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+
+			assert.Equal(t, tc.expectedTopic, client.topic)
+			assert.Equal(t, tc.config.QoS, client.qos)
+			assert.Equal(t, tc.config.Retain, client.retained)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				assert.Equal(t, -1, id)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Zero(t, id)
+			assert.JSONEq(t, tc.expectedPayload, string(client.payload))
+		})
+	}
+}
+
+func TestMQTTNotifierUnreachableBrokerDoesNotBlock(t *testing.T) {
+	n, err := NewMQTTNotifier(
+		MQTTConfig{BrokerURL: "tcp://127.0.0.1:1", QoS: 1, Timeout: 200 * time.Millisecond},
+		tagextractor.NewNoOpTagExtractor(),
+		log.New(io.Discard, "", 0),
+	)
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = n.PostAnnotation(Annotation{Text: "text"})
+
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
@@ -0,0 +1,174 @@
+package notifications
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+)
+
+// NotifierTarget is a backend of a MultiNotifier
+type NotifierTarget struct {
+	// Name identifies the backend in logs and errors (e.g. "slack")
+	Name      string
+	Annotator Annotator
+	// Tags restricts the backend to annotations with at least one of these tags (case-insensitive).
+	// If empty, the backend receives all annotations.
+	Tags []string
+}
+
+// MultiNotifierConfig holds the settings of a MultiNotifier
+type MultiNotifierConfig struct {
+	Targets []NotifierTarget
+	// Timeout bounds the time spent waiting for all the backends (defaults to DefaultTimeout).
+	// Backends which don't complete in time are reported as failed.
+	Timeout time.Duration
+	// RequireAll makes a post fail if any backend fails, instead of only if all of them fail
+	RequireAll bool
+}
+
+type multiNotifier struct {
+	MultiNotifierConfig
+
+	tagExtractor tagextractor.TagExtractor
+	logger       *log.Logger
+}
+
+type targetResult struct {
+	index int
+	id    int
+	err   error
+}
+
+// MultiError aggregates the errors returned by the backends of a MultiNotifier
+type MultiError []error
+
+func (e MultiError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the first aggregated error, so that errors.Is and errors.As can inspect it
+func (e MultiError) Unwrap() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e[0]
+}
+
+// NewMultiNotifier creates an Annotator which dispatches each annotation concurrently
+// to all the targets whose tag filter matches it.
+// The tagExtractor is used to extract the tags of annotations passed to Post, to match them against the filters.
+func NewMultiNotifier(config MultiNotifierConfig, tagExtractor tagextractor.TagExtractor, logger *log.Logger) Annotator {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	return &multiNotifier{
+		MultiNotifierConfig: config,
+		tagExtractor:        tagExtractor,
+		logger:              logger,
+	}
+}
+
+// Post forwards the raw annotation to the matching targets, so that each one extracts the tags itself.
+// The returned ID is the one returned by the first successful target, in configuration order.
+func (n *multiNotifier) Post(annotation string, time time.Time) (int, error) {
+	_, tags := n.tagExtractor.Extract(annotation)
+
+	return n.dispatch(tags, func(a Annotator) (int, error) {
+		return a.Post(annotation, time)
+	})
+}
+
+// PostAnnotation forwards the annotation to the matching targets.
+// The returned ID is the one returned by the first successful target, in configuration order.
+func (n *multiNotifier) PostAnnotation(annotation Annotation) (int, error) {
+	return n.dispatch(annotation.Tags, func(a Annotator) (int, error) {
+		return a.PostAnnotation(annotation)
+	})
+}
+
+func (n *multiNotifier) dispatch(tags []string, post func(Annotator) (int, error)) (int, error) {
+	var targets []int
+	for idx, target := range n.Targets {
+		if matchesTags(target.Tags, tags) {
+			targets = append(targets, idx)
+		}
+	}
+	if len(targets) == 0 {
+		return 0, nil
+	}
+
+	// Buffered so that targets completing after the timeout don't leak their goroutines
+	resultCh := make(chan targetResult, len(targets))
+	for _, idx := range targets {
+		go func(idx int) {
+			id, err := post(n.Targets[idx].Annotator)
+			resultCh <- targetResult{index: idx, id: id, err: err}
+		}(idx)
+	}
+
+	results := make(map[int]targetResult, len(targets))
+	timer := time.NewTimer(n.Timeout)
+	defer timer.Stop()
+collect:
+	for len(results) < len(targets) {
+		select {
+		case r := <-resultCh:
+			results[r.index] = r
+		case <-timer.C:
+			break collect
+		}
+	}
+
+	id := -1
+	succeeded := false
+	var errs MultiError
+	for _, idx := range targets {
+		r, ok := results[idx]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s: timed out after %v", n.Targets[idx].Name, n.Timeout))
+		case r.err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", n.Targets[idx].Name, r.err))
+		case !succeeded:
+			id, succeeded = r.id, true
+		}
+	}
+
+	if len(errs) == 0 {
+		return id, nil
+	}
+	n.logger.Printf("Error dispatching notification: %v\n", errs)
+	if succeeded && !n.RequireAll {
+		return id, nil
+	}
+
+	return -1, errs
+}
+
+// matchesTags returns whether an annotation with tags passes the filter, i.e. the filter is empty
+// or has at least one tag in common with the annotation
+func matchesTags(filter []string, tags []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	for _, f := range filter {
+		for _, t := range tags {
+			if strings.EqualFold(f, t) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
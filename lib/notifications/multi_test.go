@@ -0,0 +1,136 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiNotifierPost(t *testing.T) {
+	errBackend := errors.New("backend error")
+	const annotation = "[UPS] On battery"
+
+	type backend struct {
+		tags   []string
+		called bool
+		id     int
+		err    error
+	}
+	testCases := map[string]struct {
+		requireAll  bool
+		backends    []backend
+		expectedID  int
+		expectedErr string
+	}{
+		"dispatches to matching backends": {
+			backends: []backend{
+				{called: true, id: 10},
+				{tags: []string{"ups", "error"}, called: true},
+				{tags: []string{"error"}},
+			},
+			expectedID: 10,
+		},
+		"returns the ID of the first successful backend": {
+			backends: []backend{
+				{called: true, id: -1, err: errBackend},
+				{called: true, id: 0},
+			},
+			expectedID: 0,
+		},
+		"fails if all backends fail": {
+			backends: []backend{
+				{called: true, id: -1, err: errBackend},
+				{called: true, id: -1, err: errBackend},
+			},
+			expectedID:  -1,
+			expectedErr: "backend0: backend error; backend1: backend error",
+		},
+		"fails if any backend fails when all are required": {
+			requireAll: true,
+			backends: []backend{
+				{called: true, id: 10},
+				{called: true, id: -1, err: errBackend},
+			},
+			expectedID:  -1,
+			expectedErr: "backend1: backend error",
+		},
+		"does nothing when no backend matches": {
+			backends: []backend{
+				{tags: []string{"error"}},
+			},
+			expectedID: 0,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			ts := time.Now()
+			var targets []NotifierTarget
+			var mocks []*MockAnnotator
+			for idx, b := range tc.backends {
+				m := &MockAnnotator{}
+				if b.called {
+					m.On("Post", annotation, ts).Return(b.id, b.err).Once()
+				}
+				mocks = append(mocks, m)
+				targets = append(targets, NotifierTarget{Name: fmt.Sprintf("backend%d", idx), Annotator: m, Tags: b.tags})
+			}
+
+			n := NewMultiNotifier(
+				MultiNotifierConfig{Targets: targets, RequireAll: tc.requireAll},
+				tagextractor.NewNotificationCenterTagExtractor(),
+				log.New(io.Discard, "", 0),
+			)
+			id, err := n.Post(annotation, ts)
+
+			assert.Equal(t, tc.expectedID, id)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				assert.ErrorIs(t, err, errBackend)
+			} else {
+				assert.NoError(t, err)
+			}
+			for _, m := range mocks {
+				m.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestMultiNotifierPostAnnotationTimeout(t *testing.T) {
+	a := Annotation{Text: "Disk failure", Tags: []string{"error"}}
+	release := make(chan time.Time)
+	defer close(release)
+
+	fast := &MockAnnotator{}
+	fast.On("PostAnnotation", a).Return(1, nil).Once()
+	slow := &MockAnnotator{}
+	slow.On("PostAnnotation", a).Return(2, nil).WaitUntil(release)
+
+	n := NewMultiNotifier(
+		MultiNotifierConfig{
+			Targets: []NotifierTarget{
+				{Name: "slow", Annotator: slow},
+				{Name: "fast", Annotator: fast, Tags: []string{"Error"}},
+			},
+			Timeout:    50 * time.Millisecond,
+			RequireAll: true,
+		},
+		tagextractor.NewNoOpTagExtractor(),
+		log.New(io.Discard, "", 0),
+	)
+
+	start := time.Now()
+	id, err := n.PostAnnotation(a)
+
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, -1, id)
+	assert.EqualError(t, err, "slow: timed out after 50ms")
+	fast.AssertExpectations(t)
+}
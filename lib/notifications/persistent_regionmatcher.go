@@ -0,0 +1,97 @@
+package notifications
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type persistedCacheEntry struct {
+	ID         int       `json:"id"`
+	Annotation string    `json:"annotation"`
+	Added      time.Time `json:"added"`
+}
+
+// persistentRegionMatcher is a regionMatcher whose entries are saved to a JSON file,
+// so that open regions can still be closed after the exporter restarts
+type persistentRegionMatcher struct {
+	regionMatcher
+
+	path string
+}
+
+// NewPersistentRegionMatcher creates a RegionMatcher backed by the JSON file at path.
+// Entries older than maxAge (if non-zero) are discarded. A missing or corrupt file results in an empty cache.
+// The strategy (if non-nil) is used as in NewRegionMatcher.
+func NewPersistentRegionMatcher(cacheSize int, path string, maxAge time.Duration, strategy MatchStrategy, logger *log.Logger) RegionMatcher {
+	c := &persistentRegionMatcher{
+		regionMatcher: regionMatcher{
+			cacheSize: cacheSize,
+			maxAge:    maxAge,
+			strategy:  strategy,
+			logger:    logger,
+		},
+		path: path,
+	}
+	c.onChange = c.save
+	c.load()
+
+	return c
+}
+
+func (c *persistentRegionMatcher) load() {
+	contents, err := os.ReadFile(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.logger.Printf("Error reading annotation cache %q, starting empty: %v\n", c.path, err)
+		}
+		return
+	}
+
+	var entries []persistedCacheEntry
+	if err := json.Unmarshal(contents, &entries); err != nil {
+		c.logger.Printf("Error parsing annotation cache %q, starting empty: %v\n", c.path, err)
+		return
+	}
+
+	for _, entry := range entries {
+		c.cache = append(c.cache, cacheEntry{id: entry.ID, annotation: entry.Annotation, added: entry.Added})
+	}
+	c.evict()
+
+	c.logger.Printf("Loaded %d entries from annotation cache %q\n", len(c.cache), c.path)
+}
+
+func (c *persistentRegionMatcher) save() {
+	entries := make([]persistedCacheEntry, 0, len(c.cache))
+	for _, entry := range c.cache {
+		entries = append(entries, persistedCacheEntry{ID: entry.id, Annotation: entry.annotation, Added: entry.added})
+	}
+
+	contents, err := json.Marshal(entries)
+	if err != nil {
+		c.logger.Printf("Error marshalling annotation cache: %v\n", err)
+		return
+	}
+
+	// Write to a temporary file first, so that a crash never leaves a truncated cache behind
+	tmpFile, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		c.logger.Printf("Error saving annotation cache %q: %v\n", c.path, err)
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(contents)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), c.path)
+	}
+	if err != nil {
+		c.logger.Printf("Error saving annotation cache %q: %v\n", c.path, err)
+	}
+}
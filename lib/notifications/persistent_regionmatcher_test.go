@@ -0,0 +1,78 @@
+package notifications
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPersistentRegionMatcher(t *testing.T) {
+	c := NewPersistentRegionMatcher(20, filepath.Join(t.TempDir(), "cache.json"), time.Hour, nil, log.New(io.Discard, "", 0))
+
+	require.NotNil(t, c)
+	assert.IsType(t, &persistentRegionMatcher{}, c)
+}
+
+func TestPersistentRegionMatcherSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	logger := log.New(io.Discard, "", 0)
+
+	c := NewPersistentRegionMatcher(20, path, time.Hour, nil, logger)
+	c.Add(1, "[nas] [Malware Remover] Started scanning.")
+	c.Add(2, "[nas] [SecurityCounselor] Started running Security Checkup.")
+
+	c = NewPersistentRegionMatcher(20, path, time.Hour, nil, logger)
+	assert.Equal(t, 1, c.Match("[nas] [Malware Remover] Scan completed."))
+
+	// The matched entry must also be removed from the file
+	c = NewPersistentRegionMatcher(20, path, time.Hour, nil, logger)
+	assert.Equal(t, -1, c.Match("[nas] [Malware Remover] Scan completed."))
+	assert.Equal(t, 2, c.Match("[nas] [SecurityCounselor] Finished running Security Checkup."))
+}
+
+func TestPersistentRegionMatcherPrunesOldEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	entries := []persistedCacheEntry{
+		{ID: 1, Annotation: "[nas] [Malware Remover] Started scanning.", Added: time.Now().Add(-2 * time.Hour)},
+		{ID: 2, Annotation: "[nas] [SecurityCounselor] Started", Added: time.Now()},
+	}
+	contents, err := json.Marshal(entries)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, contents, 0o644))
+
+	c := NewPersistentRegionMatcher(20, path, time.Hour, nil, log.New(io.Discard, "", 0))
+
+	assert.Equal(t, -1, c.Match("[nas] [Malware Remover] Scan completed."))
+	assert.Equal(t, 2, c.Match("[nas] [SecurityCounselor] Finished"))
+}
+
+func TestPersistentRegionMatcherWithInvalidFile(t *testing.T) {
+	testCases := map[string]func(t *testing.T, path string){
+		"missing file": func(t *testing.T, path string) {},
+		"corrupt file": func(t *testing.T, path string) {
+			require.NoError(t, os.WriteFile(path, []byte("{not json"), 0o644))
+		},
+	}
+
+	for tn, setup := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache.json")
+			setup(t, path)
+
+			c := NewPersistentRegionMatcher(20, path, time.Hour, nil, log.New(io.Discard, "", 0))
+			require.NotNil(t, c)
+			assert.Equal(t, -1, c.Match("[nas] [Malware Remover] Scan completed."))
+
+			c.Add(1, "[nas] [Malware Remover] Started scanning.")
+			_, err := os.Stat(path)
+			assert.NoError(t, err)
+		})
+	}
+}
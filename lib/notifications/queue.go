@@ -0,0 +1,507 @@
+package notifications
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultQueueSize is the number of annotations kept in memory by a QueuedNotifier if none is configured
+	DefaultQueueSize = 100
+	// DefaultJournalMaxEntries is the number of annotations kept in the journal if no limit is configured
+	DefaultJournalMaxEntries = 10000
+)
+
+// ErrQueueFull is returned when an annotation can't be queued because the queue is full
+var ErrQueueFull = errors.New("notification queue is full")
+
+// QueueConfig holds the settings of a QueuedNotifier
+type QueueConfig struct {
+	// Size is the maximum number of annotations kept in memory (defaults to DefaultQueueSize)
+	Size int
+	// JournalPath is the path of a file where annotations are spilled when the in-memory queue is full or can't be
+	// delivered, and where undelivered annotations are saved on shutdown (defaults to empty, i.e. annotations are
+	// dropped)
+	JournalPath string
+	// JournalMaxEntries is the maximum number of annotations in the journal, above which the oldest ones are evicted
+	// (defaults to DefaultJournalMaxEntries)
+	JournalMaxEntries int
+	// Retries is the number of additional delivery attempts for each annotation
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled on every subsequent retry
+	// up to RetryMaxBackoff (default to DefaultRetryBackoff and DefaultRetryMaxBackoff)
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+}
+
+// QueueStats holds the counters of a QueuedNotifier
+type QueueStats struct {
+	// Depth is the number of annotations waiting to be delivered, including the journaled ones
+	Depth     int
+	Delivered uint64
+	Failed    uint64
+	Dropped   uint64
+	// Journaled counts the annotations written to the journal, Replayed those delivered from it, and Evicted those
+	// removed from it to keep it under JournalMaxEntries
+	Journaled uint64
+	Replayed  uint64
+	Evicted   uint64
+}
+
+// QueuedNotifier is an Annotator which queues annotations and delivers them asynchronously
+// to another Annotator from a worker goroutine, so that callers never wait for the backend
+type QueuedNotifier struct {
+	QueueConfig
+
+	next   Annotator
+	logger *log.Logger
+
+	mu        sync.Mutex
+	items     []queueItem
+	journaled int
+	closed    bool
+	stats     QueueStats
+	// outage is set while the annotations can't be delivered, which are then kept in the journal and replayed
+	// once the backend is reachable again
+	outage bool
+
+	wakeCh  chan struct{}
+	closeCh chan struct{}
+	abortCh chan struct{}
+	doneCh  chan struct{}
+}
+
+// queueItem is a queued call to either Post (Raw) or PostAnnotation (Annotation)
+type queueItem struct {
+	Raw        string     `json:"raw,omitempty"`
+	Time       time.Time  `json:"time"`
+	Annotation Annotation `json:"annotation"`
+	Structured bool       `json:"structured,omitempty"`
+
+	// replayed is set on the items read from the journal
+	replayed bool
+}
+
+// NewQueuedNotifier creates a QueuedNotifier delivering annotations to next and starts its worker.
+// Annotations left in the journal by a previous run are delivered first.
+func NewQueuedNotifier(config QueueConfig, next Annotator, logger *log.Logger) *QueuedNotifier {
+	if config.Size <= 0 {
+		config.Size = DefaultQueueSize
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = DefaultRetryBackoff
+	}
+	if config.RetryMaxBackoff <= 0 {
+		config.RetryMaxBackoff = DefaultRetryMaxBackoff
+	}
+	if config.JournalMaxEntries <= 0 {
+		config.JournalMaxEntries = DefaultJournalMaxEntries
+	}
+
+	n := &QueuedNotifier{
+		QueueConfig: config,
+		next:        next,
+		logger:      logger,
+		wakeCh:      make(chan struct{}, 1),
+		closeCh:     make(chan struct{}),
+		abortCh:     make(chan struct{}),
+		doneCh:      make(chan struct{}),
+	}
+	if config.JournalPath != "" {
+		items, err := n.readJournal()
+		if err != nil {
+			logger.Printf("Error reading notification journal, starting empty: %v\n", err)
+		} else if len(items) > 0 {
+			logger.Printf("Found %d undelivered notifications in journal %q\n", len(items), config.JournalPath)
+		}
+		n.journaled = len(items)
+		n.capJournal()
+	}
+
+	go n.run()
+
+	return n
+}
+
+// Post queues the annotation, returning 0 once queued since the ID is not known yet
+func (n *QueuedNotifier) Post(annotation string, time time.Time) (int, error) {
+	return n.enqueue(queueItem{Raw: annotation, Time: time})
+}
+
+// PostAnnotation queues the annotation, returning 0 once queued since the ID is not known yet
+func (n *QueuedNotifier) PostAnnotation(annotation Annotation) (int, error) {
+	if annotation.Time.IsZero() {
+		// Keep the time of the event rather than the time of delivery
+		annotation.Time = time.Now()
+	}
+
+	return n.enqueue(queueItem{Annotation: annotation, Structured: true})
+}
+
+// Stats returns a snapshot of the queue counters
+func (n *QueuedNotifier) Stats() QueueStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	stats := n.stats
+	stats.Depth = len(n.items) + n.journaled
+	return stats
+}
+
+// Close stops accepting annotations and waits up to timeout for the queued ones to be delivered.
+// Annotations which could not be delivered in time are saved to the journal, if configured.
+func (n *QueuedNotifier) Close(timeout time.Duration) {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	n.mu.Unlock()
+	close(n.closeCh)
+
+	select {
+	case <-n.doneCh:
+		return
+	case <-time.After(timeout):
+	}
+
+	close(n.abortCh)
+	<-n.doneCh
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if len(n.items) == 0 {
+		return
+	}
+	if n.JournalPath == "" {
+		n.logger.Printf("Dropping %d undelivered notifications on shutdown\n", len(n.items))
+		n.stats.Dropped += uint64(len(n.items))
+		n.items = nil
+		return
+	}
+
+	// The in-memory items are older than the journaled ones
+	journal, err := n.readJournal()
+	if err == nil {
+		err = n.writeJournal(append(n.items, journal...))
+	}
+	if err != nil {
+		n.logger.Printf("Error saving undelivered notifications to journal: %v\n", err)
+		n.stats.Dropped += uint64(len(n.items))
+	} else {
+		n.logger.Printf("Saved %d undelivered notifications to journal\n", len(n.items))
+		n.journaled += len(n.items)
+		n.stats.Journaled += uint64(len(n.items))
+		n.capJournal()
+	}
+	n.items = nil
+}
+
+func (n *QueuedNotifier) enqueue(item queueItem) (int, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		n.stats.Dropped++
+		return -1, errors.New("notification queue is closed")
+	}
+
+	// Once items are spilled to the journal, new ones must follow them to preserve ordering
+	if n.journaled == 0 && len(n.items) < n.Size {
+		n.items = append(n.items, item)
+	} else {
+		if n.JournalPath == "" {
+			n.stats.Dropped++
+			n.logger.Printf("Dropping notification: %v\n", ErrQueueFull)
+			return -1, ErrQueueFull
+		}
+		if err := n.appendJournal(item); err != nil {
+			n.stats.Dropped++
+			n.logger.Printf("Dropping notification: %v\n", err)
+			return -1, fmt.Errorf("%w: %v", ErrQueueFull, err)
+		}
+		n.journaled++
+		n.stats.Journaled++
+		n.capJournal()
+	}
+
+	select {
+	case n.wakeCh <- struct{}{}:
+	default:
+	}
+
+	return 0, nil
+}
+
+// run delivers the queued items until the queue is closed and empty, or delivery is aborted
+func (n *QueuedNotifier) run() {
+	defer close(n.doneCh)
+
+	for {
+		select {
+		case <-n.abortCh:
+			return
+		default:
+		}
+
+		item, ok := n.peek()
+		if !ok {
+			select {
+			case <-n.wakeCh:
+				continue
+			case <-n.closeCh:
+				if _, ok := n.peek(); ok {
+					continue
+				}
+				return
+			case <-n.abortCh:
+				return
+			}
+		}
+
+		if !n.deliver(item) {
+			// Aborted, the item stays queued so that it is saved to the journal
+			return
+		}
+	}
+}
+
+// peek returns the oldest queued item, refilling the in-memory queue from the journal if needed
+func (n *QueuedNotifier) peek() (queueItem, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if len(n.items) == 0 && n.journaled > 0 {
+		n.refill()
+	}
+	if len(n.items) == 0 {
+		return queueItem{}, false
+	}
+
+	return n.items[0], true
+}
+
+// deliver sends the item to the next Annotator with retries, returning false if delivery was aborted.
+// With a journal, the items which can't be delivered are journaled instead of given up on, and the oldest one is
+// then retried at the longest backoff until it is delivered.
+func (n *QueuedNotifier) deliver(item queueItem) bool {
+	n.mu.Lock()
+	outage := n.outage
+	n.mu.Unlock()
+	if outage {
+		select {
+		case <-time.After(n.RetryMaxBackoff):
+		case <-n.abortCh:
+			return false
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		var err error
+		if item.Structured {
+			_, err = n.next.PostAnnotation(item.Annotation)
+		} else {
+			// The time of the event is kept, so that replayed annotations are placed where they happened
+			_, err = n.next.Post(item.Raw, item.Time)
+		}
+
+		if err != nil && (outage || attempt > n.Retries) && n.JournalPath != "" {
+			n.mu.Lock()
+			if !n.outage {
+				n.logger.Printf("Journaling notifications until they can be delivered again: %v\n", err)
+			}
+			n.outage = true
+			n.spill()
+			n.mu.Unlock()
+			return true
+		}
+		if err == nil || attempt > n.Retries {
+			n.mu.Lock()
+			n.items = n.items[1:]
+			if err == nil {
+				n.stats.Delivered++
+				if item.replayed {
+					n.stats.Replayed++
+				}
+				if n.outage {
+					n.logger.Println("Notifications can be delivered again, replaying the journal")
+					n.outage = false
+				}
+			} else {
+				n.stats.Failed++
+				n.logger.Printf("Giving up on notification after %d attempts: %v\n", attempt, err)
+			}
+			n.mu.Unlock()
+			return true
+		}
+
+		select {
+		case <-time.After(retryDelay(attempt, n.RetryBackoff, n.RetryMaxBackoff, 0)):
+		case <-n.abortCh:
+			return false
+		}
+	}
+}
+
+// spill moves the in-memory items to the start of the journal, since they are older than the journaled ones.
+// The caller must hold mu.
+func (n *QueuedNotifier) spill() {
+	journal, err := n.readJournal()
+	if err == nil {
+		err = n.writeJournal(append(n.items, journal...))
+	}
+	if err != nil {
+		// The items are kept in memory, and retried
+		n.logger.Printf("Error journaling undelivered notifications: %v\n", err)
+		return
+	}
+
+	for _, item := range n.items {
+		if !item.replayed {
+			n.stats.Journaled++
+		}
+	}
+	n.journaled += len(n.items)
+	n.items = nil
+	n.capJournal()
+}
+
+// capJournal evicts the oldest items of the journal beyond JournalMaxEntries. The caller must hold mu.
+func (n *QueuedNotifier) capJournal() {
+	if n.journaled <= n.JournalMaxEntries {
+		return
+	}
+
+	items, err := n.readJournal()
+	if err != nil {
+		n.logger.Printf("Error reading notification journal: %v\n", err)
+		return
+	}
+	evicted := len(items) - n.JournalMaxEntries
+	if evicted <= 0 {
+		n.journaled = len(items)
+		return
+	}
+	if err := n.writeJournal(items[evicted:]); err != nil {
+		n.logger.Printf("Error evicting notifications from journal: %v\n", err)
+		return
+	}
+
+	n.logger.Printf("Evicted the %d oldest notifications from the journal, holding more than %d\n", evicted, n.JournalMaxEntries)
+	n.stats.Evicted += uint64(evicted)
+	n.journaled = n.JournalMaxEntries
+}
+
+// refill moves up to Size items from the journal to the in-memory queue
+func (n *QueuedNotifier) refill() {
+	items, err := n.readJournal()
+	if err != nil {
+		n.logger.Printf("Error reading notification journal, discarding it: %v\n", err)
+		n.stats.Dropped += uint64(n.journaled)
+		n.journaled = 0
+		_ = os.Remove(n.JournalPath)
+		return
+	}
+
+	count := len(items)
+	if count > n.Size {
+		count = n.Size
+	}
+	if err := n.writeJournal(items[count:]); err != nil {
+		n.logger.Printf("Error updating notification journal: %v\n", err)
+		return
+	}
+
+	for i := range items[:count] {
+		items[i].replayed = true
+	}
+	n.items = append(n.items, items[:count]...)
+	n.journaled = len(items) - count
+}
+
+func (n *QueuedNotifier) readJournal() ([]queueItem, error) {
+	f, err := os.Open(n.JournalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var items []queueItem
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var item queueItem
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			// Skip entries truncated by a crash
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return items, scanner.Err()
+}
+
+// writeJournal atomically replaces the journal with items, removing it if there are none
+func (n *QueuedNotifier) writeJournal(items []queueItem) error {
+	if len(items) == 0 {
+		err := os.Remove(n.JournalPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(n.JournalPath), filepath.Base(n.JournalPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), n.JournalPath)
+}
+
+func (n *QueuedNotifier) appendJournal(item queueItem) error {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(n.JournalPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
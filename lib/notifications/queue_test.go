@@ -0,0 +1,183 @@
+package notifications
+
+import (
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueuedNotifierDelivers(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	a := Annotation{Text: "Container started", Tags: []string{"docker"}, Time: ts}
+
+	m := &MockAnnotator{}
+	m.On("Post", "[UPS] On battery", ts).Return(1, nil).Once()
+	m.On("PostAnnotation", a).Return(2, nil).Once()
+
+	n := NewQueuedNotifier(QueueConfig{}, m, log.New(io.Discard, "", 0))
+
+	id, err := n.Post("[UPS] On battery", ts)
+	assert.NoError(t, err)
+	assert.Zero(t, id)
+	id, err = n.PostAnnotation(a)
+	assert.NoError(t, err)
+	assert.Zero(t, id)
+
+	n.Close(5 * time.Second)
+
+	m.AssertExpectations(t)
+	assert.Equal(t, "Post", m.Calls[0].Method)
+	assert.Equal(t, QueueStats{Delivered: 2}, n.Stats())
+
+	_, err = n.Post("after close", ts)
+	assert.Error(t, err)
+	assert.Equal(t, uint64(1), n.Stats().Dropped)
+}
+
+func TestQueuedNotifierRetries(t *testing.T) {
+	ts := time.Now()
+	m := &MockAnnotator{}
+	m.On("Post", "fails", ts).Return(-1, errors.New("connection refused")).Times(3)
+	m.On("Post", "succeeds", ts).Return(-1, errors.New("connection refused")).Once()
+	m.On("Post", "succeeds", ts).Return(1, nil).Once()
+
+	n := NewQueuedNotifier(QueueConfig{Retries: 2, RetryBackoff: time.Millisecond}, m, log.New(io.Discard, "", 0))
+	_, _ = n.Post("fails", ts)
+	_, _ = n.Post("succeeds", ts)
+	n.Close(5 * time.Second)
+
+	m.AssertExpectations(t)
+	assert.Equal(t, QueueStats{Delivered: 1, Failed: 1}, n.Stats())
+}
+
+func TestQueuedNotifierFull(t *testing.T) {
+	ts := time.Now()
+	release := make(chan time.Time)
+
+	m := &MockAnnotator{}
+	m.On("Post", mock.Anything, ts).Return(1, nil).WaitUntil(release)
+
+	n := NewQueuedNotifier(QueueConfig{Size: 1}, m, log.New(io.Discard, "", 0))
+	_, err := n.Post("first", ts)
+	require.NoError(t, err)
+	_, err = n.Post("second", ts)
+
+	assert.ErrorIs(t, err, ErrQueueFull)
+	assert.Equal(t, QueueStats{Depth: 1, Dropped: 1}, n.Stats())
+
+	close(release)
+	n.Close(5 * time.Second)
+	assert.Equal(t, QueueStats{Delivered: 1, Dropped: 1}, n.Stats())
+}
+
+func TestQueuedNotifierJournal(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	journalPath := filepath.Join(t.TempDir(), "journal")
+	release := make(chan time.Time)
+
+	blocked := &MockAnnotator{}
+	blocked.On("Post", "first", ts).Return(1, nil).WaitUntil(release).Once()
+
+	n := NewQueuedNotifier(QueueConfig{Size: 1, JournalPath: journalPath}, blocked, log.New(io.Discard, "", 0))
+	for _, text := range []string{"first", "second", "third"} {
+		_, err := n.Post(text, ts)
+		require.NoError(t, err)
+	}
+	_, err := n.PostAnnotation(Annotation{Text: "fourth", Time: ts})
+	require.NoError(t, err)
+	assert.Equal(t, 4, n.Stats().Depth)
+
+	// The first delivery completes after the shutdown deadline, so the others are kept in the journal
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+	n.Close(10 * time.Millisecond)
+
+	blocked.AssertExpectations(t)
+	assert.Equal(t, QueueStats{Depth: 3, Delivered: 1, Journaled: 3}, n.Stats())
+
+	m := &MockAnnotator{}
+	m.On("Post", "second", ts).Return(2, nil).Once()
+	m.On("Post", "third", ts).Return(3, nil).Once()
+	m.On("PostAnnotation", Annotation{Text: "fourth", Time: ts}).Return(4, nil).Once()
+
+	n = NewQueuedNotifier(QueueConfig{Size: 1, JournalPath: journalPath}, m, log.New(io.Discard, "", 0))
+	assert.Equal(t, 3, n.Stats().Depth)
+	n.Close(5 * time.Second)
+
+	m.AssertExpectations(t)
+	require.Len(t, m.Calls, 3)
+	assert.Equal(t, "second", m.Calls[0].Arguments[0])
+	assert.Equal(t, "third", m.Calls[1].Arguments[0])
+	assert.Equal(t, QueueStats{Delivered: 3, Replayed: 3}, n.Stats())
+	assert.NoFileExists(t, journalPath)
+}
+
+func TestQueuedNotifierJournalsDuringOutage(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	journalPath := filepath.Join(t.TempDir(), "journal")
+	reachable := make(chan struct{})
+
+	var mu sync.Mutex
+	var delivered []string
+	m := &fakeAnnotator{post: func(text string, ts time.Time) (int, error) {
+		select {
+		case <-reachable:
+		default:
+			return -1, errors.New("connection refused")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// The events keep their original time
+		assert.Equal(t, start.Add(time.Duration(len(delivered))*time.Minute), ts)
+		delivered = append(delivered, text)
+		return len(delivered), nil
+	}}
+
+	config := QueueConfig{Size: 2, JournalPath: journalPath, JournalMaxEntries: 3, RetryBackoff: time.Millisecond, RetryMaxBackoff: 10 * time.Millisecond}
+	n := NewQueuedNotifier(config, m, log.New(io.Discard, "", 0))
+	for i, text := range []string{"evicted", "first", "second", "third"} {
+		_, err := n.Post(text, start.Add(time.Duration(i-1)*time.Minute))
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return n.Stats().Evicted == 1 }, 5*time.Second, time.Millisecond)
+	assert.FileExists(t, journalPath)
+	stats := n.Stats()
+	assert.Equal(t, 3, stats.Depth)
+	assert.Zero(t, stats.Failed, "the notifications aren't given up on")
+
+	close(reachable)
+	require.Eventually(t, func() bool { return n.Stats().Depth == 0 }, 5*time.Second, time.Millisecond)
+	n.Close(5 * time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"first", "second", "third"}, delivered)
+	stats = n.Stats()
+	assert.Equal(t, uint64(4), stats.Journaled)
+	assert.Equal(t, uint64(3), stats.Replayed)
+	assert.Equal(t, uint64(3), stats.Delivered)
+	assert.NoFileExists(t, journalPath)
+}
+
+// fakeAnnotator posts the annotations with post
+type fakeAnnotator struct {
+	post func(text string, ts time.Time) (int, error)
+}
+
+func (a *fakeAnnotator) Post(text string, ts time.Time) (int, error) {
+	return a.post(text, ts)
+}
+
+func (a *fakeAnnotator) PostAnnotation(annotation Annotation) (int, error) {
+	return a.post(annotation.Text, annotation.Time)
+}
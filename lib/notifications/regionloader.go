@@ -0,0 +1,146 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPageSize is the number of annotations requested per call to the Grafana list API
+const defaultPageSize = 100
+
+// RegionLoader is implemented by Annotators which can seed their region cache from the annotations stored in Grafana
+type RegionLoader interface {
+	// LoadOpenRegions adds the annotations created since from which have not been closed yet to the region cache,
+	// returning the number of annotations added
+	LoadOpenRegions(from time.Time) (int, error)
+}
+
+// LoadOpenRegions queries Grafana for annotations matching the configured tags, and adds the ones which are still open
+// (i.e. are not yet regions) to the region cache, so that a restart in the middle of an event still closes the right region
+func (a *regionMatchingAnnotator) LoadOpenRegions(from time.Time) (int, error) {
+	annotations, err := a.listAnnotations(from, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	// Grafana returns the most recent annotations first, so add them in reverse order to keep the cache chronological
+	for idx := len(annotations) - 1; idx >= 0; idx-- {
+		ga := annotations[idx]
+		if ga.TimeEnd != 0 && ga.TimeEnd != ga.Time {
+			continue
+		}
+
+		// The age of the region counts from its start, so that regions left open for too long are still evicted
+		start := time.UnixMilli(ga.Time)
+		data := a.templateData(start)
+		a.cache.AddKey(a.tagExtractor.Restore(a.stripText(ga.Text, data), excludeTags(ga.Tags, a.expandTags(data))), ga.Id, start)
+		count++
+	}
+
+	a.logger.Printf("Loaded %d open annotations from Grafana\n", count)
+	return count, nil
+}
+
+// listAnnotations returns the annotations matching the configured tags in the [from, to] time range,
+// most recent first
+func (a *regionMatchingAnnotator) listAnnotations(from, to time.Time) ([]grafanaAnnotation, error) {
+	var annotations []grafanaAnnotation
+	seen := map[int]bool{}
+
+	cursor := to.UnixNano() / 1000000
+	for {
+		page, err := a.listAnnotationsPage(from.UnixNano()/1000000, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		oldest := cursor
+		added := 0
+		for _, ga := range page {
+			if ga.Time < oldest {
+				oldest = ga.Time
+			}
+			if seen[ga.Id] {
+				continue
+			}
+
+			seen[ga.Id] = true
+			annotations = append(annotations, ga)
+			added++
+		}
+
+		// Stop if this was the last page, or if paging can't make progress
+		// (i.e. a full page of annotations sharing the same timestamp)
+		if len(page) < a.pageSize || added == 0 {
+			break
+		}
+		cursor = oldest
+	}
+
+	return annotations, nil
+}
+
+func (a *regionMatchingAnnotator) listAnnotationsPage(from, to int64) ([]grafanaAnnotation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	query := url.Values{}
+	query.Set("from", strconv.FormatInt(from, 10))
+	query.Set("to", strconv.FormatInt(to, 10))
+	query.Set("limit", strconv.Itoa(a.pageSize))
+	query.Set("type", "annotation")
+	data := a.templateData(time.Now())
+	for _, tag := range a.tags {
+		// Tags depending on the time of each annotation can't be used to filter them
+		if tag.raw == "" || strings.Contains(tag.raw, ".Time") {
+			continue
+		}
+		query.Add("tags", tag.expand(data))
+	}
+	url := fmt.Sprintf("%s/api/annotations?%s", a.grafanaURL, query.Encode())
+
+	req, err := a.newRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list Grafana annotations: %w", a.connectionError(req, err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("call to %s failed with HTTP %d %q", url, resp.StatusCode, resp.Status)
+	}
+
+	var page []grafanaAnnotation
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("unmarshaling response body: %w", err)
+	}
+
+	return page, nil
+}
+
+// excludeTags returns the tags in t1 which are not present in t2
+func excludeTags(t1 []string, t2 []string) []string {
+	exclude := make(map[string]bool, len(t2))
+	for _, entry := range t2 {
+		exclude[entry] = true
+	}
+
+	var list []string
+	for _, entry := range t1 {
+		if !exclude[entry] {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -0,0 +1,137 @@
+package notifications
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeGrafanaListServer returns a server which implements the paging semantics of the Grafana annotation list API
+func newFakeGrafanaListServer(t *testing.T, annotations []grafanaAnnotation, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		query := r.URL.Query()
+		require.Equal(t, "/api/annotations", r.URL.Path)
+		require.Equal(t, []string{"nas", "notification-center"}, query["tags"])
+		require.Equal(t, "Bearer token1", r.Header.Get("Authorization"))
+
+		from, _ := strconv.ParseInt(query.Get("from"), 10, 64)
+		to, _ := strconv.ParseInt(query.Get("to"), 10, 64)
+		limit, _ := strconv.Atoi(query.Get("limit"))
+
+		sort.Slice(annotations, func(i, j int) bool { return annotations[i].Time > annotations[j].Time })
+		page := []grafanaAnnotation{}
+		for _, ga := range annotations {
+			if ga.Time >= from && ga.Time <= to && len(page) < limit {
+				page = append(page, ga)
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+}
+
+func TestLoadOpenRegions(t *testing.T) {
+	now := time.Now()
+	ms := func(d time.Duration) int64 { return now.Add(-d).UnixNano() / 1000000 }
+	tags := []string{"nas", "notification-center"}
+	annotations := []grafanaAnnotation{
+		{Id: 1, Time: ms(5 * time.Hour), Text: "Started scanning.", Tags: append(tags, "Malware Remover")},
+		{Id: 2, Time: ms(4 * time.Hour), TimeEnd: ms(3 * time.Hour), Text: "Started running Security Checkup.", Tags: append(tags, "SecurityCounselor")},
+		{Id: 3, Time: ms(3 * time.Hour), TimeEnd: ms(3 * time.Hour), Text: "begin \"start\" scripts ...", Tags: append(tags, "RunLast")},
+		{Id: 4, Time: ms(2 * time.Hour), Text: "Started creating scheduled snapshot. Volume: System_Vol.", Tags: append(tags, "Storage & Snapshots")},
+		{Id: 5, Time: ms(1 * time.Hour), Text: "Started running Security Checkup.", Tags: append(tags, "SecurityCounselor")},
+		{Id: 6, Time: ms(48 * time.Hour), Text: "Started scanning.", Tags: append(tags, "Malware Remover")},
+	}
+	requests := 0
+	server := newFakeGrafanaListServer(t, annotations, &requests)
+	defer server.Close()
+
+	cache := NewRegionMatcher(20, 0, nil, log.New(io.Discard, "", 0))
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{URL: server.URL, AuthToken: "token1", Tags: tags},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		cache,
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+	a.(*regionMatchingAnnotator).pageSize = 2
+
+	count, err := a.(RegionLoader).LoadOpenRegions(now.Add(-24 * time.Hour))
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, count)
+	assert.GreaterOrEqual(t, requests, 3)
+	assert.Equal(t, 1, cache.Match("[Malware Remover] Scan completed."))
+	assert.Equal(t, 3, cache.Match(`[RunLast] end "start" scripts`))
+	assert.Equal(t, 4, cache.Match("[Storage & Snapshots] Finished creating scheduled snapshot. Volume: System_Vol."))
+	assert.Equal(t, 5, cache.Match("[SecurityCounselor] Finished running Security Checkup."))
+	assert.Equal(t, -1, cache.Match("[SecurityCounselor] Finished running Security Checkup."))
+}
+
+func TestLoadOpenRegionsEvictsByAge(t *testing.T) {
+	now := time.Now()
+	tags := []string{"nas", "notification-center"}
+	annotations := []grafanaAnnotation{
+		{Id: 1, Time: now.Add(-3 * time.Hour).UnixMilli(), Text: "Started scanning.", Tags: append(tags, "Malware Remover")},
+		{Id: 2, Time: now.Add(-30 * time.Minute).UnixMilli(), Text: "Started running Security Checkup.", Tags: append(tags, "SecurityCounselor")},
+	}
+	requests := 0
+	server := newFakeGrafanaListServer(t, annotations, &requests)
+	defer server.Close()
+
+	cache := NewRegionMatcher(20, time.Hour, nil, log.New(io.Discard, "", 0))
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{URL: server.URL, AuthToken: "token1", Tags: tags},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		cache,
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	_, err := a.(RegionLoader).LoadOpenRegions(now.Add(-24 * time.Hour))
+	require.NoError(t, err)
+	cache.Evict()
+
+	// The region opened 3 hours ago is older than the maximum age, even though it was only just loaded
+	assert.Equal(t, -1, cache.Match("[Malware Remover] Scan completed."))
+	assert.Equal(t, 2, cache.Match("[SecurityCounselor] Finished running Security Checkup."))
+}
+
+func TestLoadOpenRegionsWithGrafanaError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	cacheMock := new(MockRegionMatcher)
+	defer cacheMock.AssertExpectations(t)
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{URL: server.URL},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		cacheMock,
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	count, err := a.(RegionLoader).LoadOpenRegions(time.Now().Add(-time.Hour))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "HTTP 401")
+	assert.Zero(t, count)
+}
+
+func TestExcludeTags(t *testing.T) {
+	assert.Equal(t, []string{"SecurityCounselor"}, excludeTags([]string{"nas", "SecurityCounselor"}, []string{"nas"}))
+	assert.Nil(t, excludeTags([]string{"nas"}, []string{"nas"}))
+	assert.Equal(t, []string{"nas"}, excludeTags([]string{"nas"}, nil))
+}
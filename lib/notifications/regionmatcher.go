@@ -1,10 +1,31 @@
 package notifications
 
-import "regexp"
+import (
+	"log"
+	"regexp"
+	"sync"
+	"time"
 
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+// RegionMatcher caches the IDs of the annotations which opened a region, so that the matching end event can close it.
+// Entries are identified by a key, which is either the text of the annotation or a stable identifier of the event
+// (e.g. "ups:apc1" or "volume:2"), so that the start and end events don't need to share the same text.
+// Implementations are safe for concurrent use.
 type RegionMatcher interface {
+	// Add caches the ID of an annotation which started now, keyed by its text
 	Add(id int, annotation string)
+	// AddKey caches the ID of an annotation which started at start, keyed by key
+	AddKey(key string, id int, start time.Time)
+	// Match returns the ID of the region closed by the annotation (removing it from the cache), or -1
 	Match(annotation string) int
+	// MatchKey returns the ID of the entry added with exactly the given key (removing it from the cache), or -1
+	MatchKey(key string) int
+	// Remove deletes the entry with the given annotation ID, returning whether it was found
+	Remove(id int) bool
+	// Evict removes the entries which have exceeded their maximum age
+	Evict()
 }
 
 type noOpRegionMatcher struct {
@@ -17,10 +38,24 @@ func NewNoOpRegionMatcher() RegionMatcher {
 func (c *noOpRegionMatcher) Add(id int, annotation string) {
 }
 
+func (c *noOpRegionMatcher) AddKey(key string, id int, start time.Time) {
+}
+
 func (c *noOpRegionMatcher) Match(annotation string) int {
 	return -1
 }
 
+func (c *noOpRegionMatcher) MatchKey(key string) int {
+	return -1
+}
+
+func (c *noOpRegionMatcher) Remove(id int) bool {
+	return false
+}
+
+func (c *noOpRegionMatcher) Evict() {
+}
+
 // replacementRules describes a regular expression to match the end event, and a substitution to convert it to the start event
 type replacementRule struct {
 	re           *regexp.Regexp
@@ -41,48 +76,71 @@ var rules = []replacementRule{
 }
 
 type cacheEntry struct {
-	id         int
+	id int
+	// annotation is the key of the entry, usually the text of the annotation
 	annotation string
+	// added is the start time of the region, from which the age of the entry is computed
+	added time.Time
 }
 
 type regionMatcher struct {
 	cacheSize int
-	cache     []cacheEntry
+	maxAge    time.Duration
+	strategy  MatchStrategy
+	logger    *log.Logger
+
+	mu    sync.Mutex
+	cache []cacheEntry
+	// onChange is called with the lock held whenever the cache contents change
+	onChange func()
 }
 
-func NewRegionMatcher(cacheSize int) RegionMatcher {
+// NewRegionMatcher creates a RegionMatcher holding at most cacheSize entries.
+// If maxAge is non-zero, entries older than maxAge are evicted on Add and on Evict.
+// If strategy is non-nil, annotations are first matched by the key it derives, falling back to the exact text rules.
+func NewRegionMatcher(cacheSize int, maxAge time.Duration, strategy MatchStrategy, logger *log.Logger) RegionMatcher {
 	return &regionMatcher{
 		cacheSize: cacheSize,
+		maxAge:    maxAge,
+		strategy:  strategy,
+		logger:    logger,
 	}
 }
 
 func (c *regionMatcher) Add(id int, annotation string) {
+	c.AddKey(annotation, id, time.Now())
+}
+
+func (c *regionMatcher) AddKey(key string, id int, start time.Time) {
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.cache = append(c.cache, cacheEntry{
 		id:         id,
-		annotation: annotation,
+		annotation: key,
+		added:      start,
 	})
-	if len(c.cache) > c.cacheSize {
-		c.cache = c.cache[1:]
-	}
+	c.evict()
+	c.changed()
 }
 
-func (c *regionMatcher) Match(annotation string) (id int) {
-	idx := -1
-	defer func() {
-		if idx >= 0 {
-			id = c.cache[idx].id
+func (c *regionMatcher) Match(annotation string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-			// Delete the cache entry
-			c.cache = append(c.cache[:idx], c.cache[idx+1:]...)
-		}
-	}()
+	if id := c.matchByKey(annotation); id != -1 {
+		return id
+	}
 
 	for _, r := range rules {
 		previousAnnotation := r.re.ReplaceAllString(annotation, r.substitution)
 		if previousAnnotation != annotation {
-			idx = c.findIndex(previousAnnotation)
-			if idx >= 0 {
-				return
+			if id := c.remove(previousAnnotation); id != -1 {
+				return id
 			}
 		}
 	}
@@ -90,6 +148,115 @@ func (c *regionMatcher) Match(annotation string) (id int) {
 	return -1
 }
 
+func (c *regionMatcher) MatchKey(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.remove(key)
+}
+
+func (c *regionMatcher) Remove(id int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for idx, entry := range c.cache {
+		if entry.id == id {
+			c.removeAt(idx)
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchByKey removes the most recent entry sharing the key of the annotation, returning its ID or -1 if not found.
+// An entry with the very same text is a repetition of the start event rather than its end, so it is not matched.
+// It must be called with the lock held.
+func (c *regionMatcher) matchByKey(annotation string) int {
+	if c.strategy == nil {
+		return -1
+	}
+	key := c.strategy.Key(annotation)
+	if key == "" {
+		return -1
+	}
+
+	for idx := len(c.cache) - 1; idx >= 0; idx-- {
+		entry := c.cache[idx]
+		if entry.annotation != annotation && c.strategy.Key(entry.annotation) == key {
+			utils.Debugf(c.logger, "Matched annotation %d by key %q\n", entry.id, key)
+			return c.removeAt(idx)
+		}
+	}
+
+	return -1
+}
+
+// remove deletes the entry for the given annotation, returning its ID or -1 if not found.
+// It must be called with the lock held.
+func (c *regionMatcher) remove(annotation string) int {
+	idx := c.findIndex(annotation)
+	if idx < 0 {
+		return -1
+	}
+
+	return c.removeAt(idx)
+}
+
+// removeAt deletes the entry at idx, returning its ID. It must be called with the lock held.
+func (c *regionMatcher) removeAt(idx int) int {
+	id := c.cache[idx].id
+
+	// Delete the cache entry
+	c.cache = append(c.cache[:idx], c.cache[idx+1:]...)
+	c.changed()
+
+	return id
+}
+
+func (c *regionMatcher) Evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.evict() {
+		c.changed()
+	}
+}
+
+// evict removes the expired entries and the oldest entries exceeding the cache size,
+// returning whether any entry was removed. It must be called with the lock held.
+func (c *regionMatcher) evict() bool {
+	count := len(c.cache)
+
+	if c.maxAge > 0 {
+		cutoff := time.Now().Add(-c.maxAge)
+		entries := c.cache[:0]
+		for _, entry := range c.cache {
+			if entry.added.After(cutoff) {
+				entries = append(entries, entry)
+				continue
+			}
+
+			utils.Debugf(c.logger, "Evicting expired annotation %d from region cache: %q\n", entry.id, entry.annotation)
+		}
+		c.cache = entries
+	}
+
+	for len(c.cache) > c.cacheSize {
+		entry := c.cache[0]
+		utils.Debugf(c.logger, "Evicting annotation %d from full region cache: %q\n", entry.id, entry.annotation)
+		c.cache = c.cache[1:]
+	}
+
+	return len(c.cache) != count
+}
+
+func (c *regionMatcher) changed() {
+	if c.onChange != nil {
+		c.onChange()
+	}
+}
+
 func (c *regionMatcher) findIndex(annotation string) int {
 	for idx, entry := range c.cache {
 		if entry.annotation == annotation {
@@ -1,9 +1,18 @@
 package notifications
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,14 +24,14 @@ func TestNewNoOpRegionMatcher(t *testing.T) {
 }
 
 func TestNewRegionMatcher(t *testing.T) {
-	c := NewRegionMatcher(20)
+	c := NewRegionMatcher(20, 0, nil, log.New(io.Discard, "", 0))
 
 	require.NotNil(t, c)
 	assert.IsType(t, &regionMatcher{}, c)
 }
 
 func TestRegionMatcherWithSmallCacheSize(t *testing.T) {
-	c := NewRegionMatcher(2)
+	c := NewRegionMatcher(2, 0, nil, log.New(io.Discard, "", 0))
 
 	c.Add(1, `[nas] [Storage & Snapshots] Started ext4lazyinit. Volume: ForeignMedia_Vol, Storage pool: "1".`)
 	c.Add(2, "message 2")
@@ -36,7 +45,7 @@ func TestRegionMatcherWithSmallCacheSize(t *testing.T) {
 }
 
 func TestRegionMatcher(t *testing.T) {
-	c := NewRegionMatcher(20)
+	c := NewRegionMatcher(20, 0, nil, log.New(io.Discard, "", 0))
 
 	id1 := c.Match("[nas] [Malware Remover] Started scanning.")
 	require.Equal(t, -1, id1)
@@ -90,3 +99,128 @@ func TestRegionMatcher(t *testing.T) {
 	id12 := c.Match(`[nas] [Antivirus] User stopped scan job "User data".`)
 	require.Equal(t, 12, id12)
 }
+
+func TestRegionMatcherEvictsExpiredEntries(t *testing.T) {
+	utils.DebugLogging = true
+	defer func() { utils.DebugLogging = false }()
+
+	var logs bytes.Buffer
+	c := NewRegionMatcher(20, time.Hour, nil, log.New(&logs, "", 0))
+	rm := c.(*regionMatcher)
+
+	c.Add(1, "[nas] [Malware Remover] Started scanning.")
+	c.Add(2, "[nas] [SecurityCounselor] Started")
+	rm.cache[0].added = time.Now().Add(-2 * time.Hour)
+
+	c.Evict()
+	assert.Len(t, rm.cache, 1)
+	assert.Contains(t, logs.String(), `DEBUG: Evicting expired annotation 1 from region cache: "[nas] [Malware Remover] Started scanning."`)
+
+	assert.Equal(t, -1, c.Match("[nas] [Malware Remover] Scan completed."))
+	assert.Equal(t, 2, c.Match("[nas] [SecurityCounselor] Finished"))
+}
+
+func TestRegionMatcherEvictsOnAdd(t *testing.T) {
+	var logs bytes.Buffer
+	c := NewRegionMatcher(2, time.Hour, nil, log.New(&logs, "", 0))
+	rm := c.(*regionMatcher)
+
+	c.Add(1, "[nas] [Malware Remover] Started scanning.")
+	c.Add(2, "[nas] [SecurityCounselor] Started")
+	rm.cache[1].added = time.Now().Add(-2 * time.Hour)
+	c.Add(3, "[nas] [Storage & Snapshots] Started creating scheduled snapshot. Volume: System_Vol.")
+
+	assert.Len(t, rm.cache, 2)
+	assert.Empty(t, logs.String(), "debug messages must not be logged when debug logging is disabled")
+	assert.Equal(t, 1, c.Match("[nas] [Malware Remover] Scan completed."))
+	assert.Equal(t, -1, c.Match("[nas] [SecurityCounselor] Finished"))
+	assert.Equal(t, 3, c.Match("[nas] [Storage & Snapshots] Finished creating scheduled snapshot. Volume: System_Vol."))
+}
+
+func TestRegionMatcherMatchKey(t *testing.T) {
+	c := NewRegionMatcher(20, 0, nil, log.New(io.Discard, "", 0))
+
+	c.Add(1, "[ups] On battery")
+	assert.Equal(t, -1, c.Match("[ups] On battery"))
+	assert.Equal(t, -1, c.MatchKey("[ups] On line"))
+	assert.Equal(t, 1, c.MatchKey("[ups] On battery"))
+	assert.Equal(t, -1, c.MatchKey("[ups] On battery"))
+}
+
+func TestRegionMatcherAddKey(t *testing.T) {
+	c := NewRegionMatcher(20, time.Hour, nil, log.New(io.Discard, "", 0))
+	rm := c.(*regionMatcher)
+
+	start := time.Now().Add(-time.Minute)
+	c.AddKey("volume:2", 1, start)
+	c.AddKey("ups:apc1", 2, time.Time{})
+
+	assert.Equal(t, start, rm.cache[0].added)
+	assert.False(t, rm.cache[1].added.IsZero())
+	assert.Equal(t, -1, c.Match("volume:2"))
+	assert.Equal(t, 2, c.MatchKey("ups:apc1"))
+	assert.Equal(t, 1, c.MatchKey("volume:2"))
+
+	// A region which started before the maximum age is evicted straight away
+	c.AddKey("volume:3", 3, time.Now().Add(-2*time.Hour))
+	assert.Equal(t, -1, c.MatchKey("volume:3"))
+}
+
+func TestRegionMatcherRemove(t *testing.T) {
+	c := NewRegionMatcher(20, 0, nil, log.New(io.Discard, "", 0))
+
+	c.Add(1, "[nas] [Malware Remover] Started scanning.")
+	c.AddKey("ups:apc1", 2, time.Now())
+
+	assert.True(t, c.Remove(1))
+	assert.False(t, c.Remove(1))
+	assert.Equal(t, -1, c.Match("[nas] [Malware Remover] Scan completed."))
+	assert.Equal(t, 2, c.MatchKey("ups:apc1"))
+}
+
+func TestRegionMatcherIsSafeForConcurrentUse(t *testing.T) {
+	c := NewRegionMatcher(1000, time.Hour, nil, log.New(io.Discard, "", 0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("disk:%d:%d", i, j)
+				c.AddKey(key, i*100+j, time.Now())
+				c.Match(key)
+				c.Evict()
+				if j%2 == 0 {
+					assert.Equal(t, i*100+j, c.MatchKey(key))
+				} else {
+					assert.True(t, c.Remove(i*100+j))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Empty(t, c.(*regionMatcher).cache)
+}
+
+func TestRegionMatcherWithStrategy(t *testing.T) {
+	s := &MockMatchStrategy{}
+	s.On("Key", mock.MatchedBy(func(a string) bool { return strings.Contains(a, "Photos") })).Return("backup|photos")
+	s.On("Key", mock.Anything).Return("")
+	c := NewRegionMatcher(20, 0, s, log.New(io.Discard, "", 0))
+
+	c.Add(1, "[Backup] Backup started for Photos")
+	c.Add(2, "[Backup] Backup started for Photos")
+	c.Add(3, "[nas] [Malware Remover] Started scanning.")
+
+	// Repeating the start event doesn't close the region
+	assert.Equal(t, -1, c.Match("[Backup] Backup started for Photos"))
+	// The most recent region with the same key is closed first
+	assert.Equal(t, 2, c.Match("[Backup] Backup finished for Photos"))
+	assert.Equal(t, 1, c.Match("[Backup] Backup finished for Photos"))
+	assert.Equal(t, -1, c.Match("[Backup] Backup finished for Photos"))
+	// Annotations without a key fall back to the exact text rules
+	assert.Equal(t, 3, c.Match("[nas] [Malware Remover] Scan completed."))
+}
@@ -0,0 +1,196 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRetentionInterval is the interval between runs of AnnotationRetention when none is configured
+const DefaultRetentionInterval = 24 * time.Hour
+
+// AnnotationPruner is implemented by Annotators which can delete the annotations they created from Grafana
+type AnnotationPruner interface {
+	// DeleteAnnotationsBefore deletes the annotations matching the configured tags which ended before the given time,
+	// returning the number of annotations deleted
+	DeleteAnnotationsBefore(before time.Time) (int, error)
+}
+
+// DeleteAnnotationsBefore lists the annotations matching the configured tags which ended before the given time,
+// and deletes them one by one. A failure to delete an annotation doesn't stop the others from being deleted.
+func (a *regionMatchingAnnotator) DeleteAnnotationsBefore(before time.Time) (int, error) {
+	data := a.templateData(time.Now())
+	var tags []string
+	for _, tag := range a.tags {
+		if tag.raw == "" || strings.Contains(tag.raw, ".Time") {
+			continue
+		}
+		tags = append(tags, tag.expand(data))
+	}
+	if len(tags) == 0 {
+		// Without a tag filter, every annotation in Grafana would be deleted
+		return 0, errors.New("refusing to delete Grafana annotations without tags to filter them")
+	}
+
+	// Grafana ignores the time range if from is 0
+	annotations, err := a.listAnnotations(time.Unix(0, int64(time.Millisecond)), before)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := before.UnixNano() / 1000000
+	deleted, attempted := 0, 0
+	var firstErr error
+	for _, ga := range annotations {
+		// The list includes the regions overlapping the cutoff, and should only include annotations with all the tags
+		if ga.TimeEnd > cutoff || len(excludeTags(tags, ga.Tags)) > 0 {
+			continue
+		}
+
+		attempted++
+		found, err := a.deleteAnnotation(ga.Id)
+		if err != nil {
+			a.logger.Printf("Error deleting Grafana annotation %d: %v\n", ga.Id, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if found {
+			deleted++
+		}
+	}
+
+	a.logger.Printf("Deleted %d Grafana annotations older than %s\n", deleted, before.Format(time.RFC3339))
+	if firstErr != nil {
+		return deleted, fmt.Errorf("failed to delete %d of %d Grafana annotations: %w", attempted-deleted, attempted, firstErr)
+	}
+
+	return deleted, nil
+}
+
+// deleteAnnotation deletes an annotation with retries, returning false if it no longer existed
+func (a *regionMatchingAnnotator) deleteAnnotation(id int) (bool, error) {
+	var (
+		statusCode int
+		err        error
+	)
+	attempt := 0
+	for attempt < 1+a.retries {
+		if attempt > 0 {
+			a.waitBeforeRetry(attempt)
+		}
+		attempt++
+
+		statusCode, err = a.sendDelete(id)
+		if err == nil || !isRetryable(statusCode) {
+			break
+		}
+	}
+
+	if statusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil && a.retries > 0 {
+		err = fmt.Errorf("%w (attempt %d)", err, attempt)
+	}
+
+	return err == nil, err
+}
+
+// sendDelete performs a single DELETE request, returning the HTTP status code (0 if no response was received)
+func (a *regionMatchingAnnotator) sendDelete(id int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/api/annotations/%d", a.grafanaURL, id)
+	req, err := a.newRequest(ctx, "DELETE", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, a.connectionError(req, err)
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+
+	if resp.StatusCode >= 300 {
+		if message := readErrorMessage(resp); message != "" {
+			return resp.StatusCode, fmt.Errorf("call to %s failed with HTTP %d %q: %s", url, resp.StatusCode, resp.Status, message)
+		}
+		return resp.StatusCode, fmt.Errorf("call to %s failed with HTTP %d %q", url, resp.StatusCode, resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// RetentionConfig holds the settings of AnnotationRetention
+type RetentionConfig struct {
+	// Retention is the age after which annotations are deleted
+	Retention time.Duration
+	// Interval is the time between runs (defaults to DefaultRetentionInterval)
+	Interval time.Duration
+}
+
+// AnnotationRetention periodically deletes the annotations older than the retention period
+type AnnotationRetention struct {
+	RetentionConfig
+
+	pruners []AnnotationPruner
+	logger  *log.Logger
+	deleted uint64
+}
+
+// NewAnnotationRetention creates an AnnotationRetention deleting old annotations through the given pruners
+func NewAnnotationRetention(config RetentionConfig, pruners []AnnotationPruner, logger *log.Logger) *AnnotationRetention {
+	if config.Interval <= 0 {
+		config.Interval = DefaultRetentionInterval
+	}
+
+	return &AnnotationRetention{
+		RetentionConfig: config,
+		pruners:         pruners,
+		logger:          logger,
+	}
+}
+
+// Run deletes the old annotations immediately and then on every interval, until ctx is done
+func (r *AnnotationRetention) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.Prune()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Prune deletes the annotations older than the retention period
+func (r *AnnotationRetention) Prune() {
+	before := time.Now().Add(-r.Retention)
+	for _, p := range r.pruners {
+		count, err := p.DeleteAnnotationsBefore(before)
+		atomic.AddUint64(&r.deleted, uint64(count))
+		if err != nil {
+			r.logger.Printf("Error deleting old Grafana annotations: %v\n", err)
+		}
+	}
+}
+
+// Deleted returns the number of annotations deleted so far
+func (r *AnnotationRetention) Deleted() uint64 {
+	return atomic.LoadUint64(&r.deleted)
+}
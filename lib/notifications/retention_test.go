@@ -0,0 +1,191 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeGrafanaDeleteServer returns a server which lists annotations like the Grafana API and records the deleted IDs.
+// Deleting an ID in failIDs fails with HTTP 500.
+func newFakeGrafanaDeleteServer(t *testing.T, annotations []grafanaAnnotation, failIDs map[int]bool, deleted *[]int) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		require.Equal(t, "Bearer token1", r.Header.Get("Authorization"))
+
+		if r.Method == "DELETE" {
+			id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/annotations/"))
+			require.NoError(t, err)
+			switch {
+			case failIDs[id]:
+				w.WriteHeader(http.StatusInternalServerError)
+			case id == 404:
+				w.WriteHeader(http.StatusNotFound)
+			default:
+				*deleted = append(*deleted, id)
+				_, _ = w.Write([]byte(`{"message":"Annotation deleted"}`))
+			}
+			return
+		}
+
+		query := r.URL.Query()
+		require.Equal(t, "/api/annotations", r.URL.Path)
+		require.Equal(t, []string{"nas", "notification-center"}, query["tags"])
+		from, _ := strconv.ParseInt(query.Get("from"), 10, 64)
+		to, _ := strconv.ParseInt(query.Get("to"), 10, 64)
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		require.Greater(t, from, int64(0))
+
+		sort.Slice(annotations, func(i, j int) bool { return annotations[i].Time > annotations[j].Time })
+		page := []grafanaAnnotation{}
+		for _, ga := range annotations {
+			end := ga.TimeEnd
+			if end == 0 {
+				end = ga.Time
+			}
+			if ga.Time <= to && end >= from && len(page) < limit {
+				page = append(page, ga)
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+}
+
+func TestDeleteAnnotationsBefore(t *testing.T) {
+	now := time.Now()
+	ms := func(d time.Duration) int64 { return now.Add(-d).UnixNano() / 1000000 }
+	tags := []string{"nas", "notification-center"}
+	annotations := []grafanaAnnotation{
+		{Id: 1, Time: ms(100 * time.Hour), Tags: append(tags, "Malware Remover")},
+		{Id: 2, Time: ms(90 * time.Hour), TimeEnd: ms(89 * time.Hour), Tags: append(tags, "Backup")},
+		{Id: 3, Time: ms(80 * time.Hour), TimeEnd: ms(1 * time.Hour), Tags: append(tags, "Backup")},
+		{Id: 4, Time: ms(70 * time.Hour), Tags: []string{"nas", "other"}},
+		{Id: 5, Time: ms(60 * time.Hour), Tags: tags},
+		{Id: 6, Time: ms(1 * time.Hour), Tags: tags},
+	}
+
+	tests := []struct {
+		name        string
+		failIDs     map[int]bool
+		wantDeleted []int
+		wantErr     string
+	}{
+		{
+			name:        "all deleted",
+			wantDeleted: []int{1, 2, 5},
+		},
+		{
+			name:        "partial failure",
+			failIDs:     map[int]bool{2: true},
+			wantDeleted: []int{1, 5},
+			wantErr:     "failed to delete 1 of 3 Grafana annotations",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var deleted []int
+			server := newFakeGrafanaDeleteServer(t, annotations, tc.failIDs, &deleted)
+			defer server.Close()
+
+			a := NewRegionMatchingAnnotator(
+				GrafanaConfig{URL: server.URL, AuthToken: "token1", Tags: tags},
+				tagextractor.NewNotificationCenterTagExtractor(),
+				NewNoOpRegionMatcher(),
+				nil,
+				log.New(io.Discard, "", 0),
+			)
+			a.(*regionMatchingAnnotator).pageSize = 2
+
+			count, err := a.(AnnotationPruner).DeleteAnnotationsBefore(now.Add(-48 * time.Hour))
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			sort.Ints(deleted)
+			assert.Equal(t, tc.wantDeleted, deleted)
+			assert.Equal(t, len(tc.wantDeleted), count)
+		})
+	}
+}
+
+func TestDeleteAnnotationsBefore_NotFound(t *testing.T) {
+	now := time.Now()
+	tags := []string{"nas", "notification-center"}
+	annotations := []grafanaAnnotation{
+		{Id: 404, Time: now.Add(-72*time.Hour).UnixNano() / 1000000, Tags: tags},
+	}
+	var deleted []int
+	server := newFakeGrafanaDeleteServer(t, annotations, nil, &deleted)
+	defer server.Close()
+
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{URL: server.URL, AuthToken: "token1", Tags: tags},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		NewNoOpRegionMatcher(),
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	count, err := a.(AnnotationPruner).DeleteAnnotationsBefore(now.Add(-48 * time.Hour))
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Empty(t, deleted)
+}
+
+func TestDeleteAnnotationsBefore_RequiresTags(t *testing.T) {
+	client := &mockHttpClient{}
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{URL: "http://grafana", Tags: []string{"{{.Time.Year}}"}},
+		tagextractor.NewNoOpTagExtractor(),
+		NewNoOpRegionMatcher(),
+		client,
+		log.New(io.Discard, "", 0),
+	)
+
+	_, err := a.(AnnotationPruner).DeleteAnnotationsBefore(time.Now())
+
+	assert.EqualError(t, err, "refusing to delete Grafana annotations without tags to filter them")
+	client.AssertNotCalled(t, "Do", mock.Anything)
+}
+
+func TestAnnotationRetention(t *testing.T) {
+	p1 := &MockAnnotationPruner{}
+	p2 := &MockAnnotationPruner{}
+	before := mock.MatchedBy(func(before time.Time) bool {
+		return time.Since(before) >= 24*time.Hour && time.Since(before) < 25*time.Hour
+	})
+	p1.On("DeleteAnnotationsBefore", before).Return(3, nil)
+	p2.On("DeleteAnnotationsBefore", before).Return(1, assert.AnError)
+
+	r := NewAnnotationRetention(RetentionConfig{Retention: 24 * time.Hour}, []AnnotationPruner{p1, p2}, log.New(io.Discard, "", 0))
+	assert.Equal(t, DefaultRetentionInterval, r.Interval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Run(ctx)
+
+	assert.Equal(t, uint64(4), r.Deleted())
+	p1.AssertNumberOfCalls(t, "DeleteAnnotationsBefore", 1)
+	p2.AssertNumberOfCalls(t, "DeleteAnnotationsBefore", 1)
+}
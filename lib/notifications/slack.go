@@ -0,0 +1,211 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+)
+
+// maxRetryAfter caps the delay requested by a rate-limited response
+const maxRetryAfter = 1 * time.Minute
+
+// SlackConfig holds the settings used to post to a Slack incoming webhook
+type SlackConfig struct {
+	WebhookURL string
+	// Channel, Username and Icon override the defaults configured for the webhook.
+	// Icon can either be an emoji (e.g. ":floppy_disk:") or an image URL.
+	Channel  string
+	Username string
+	Icon     string
+	// Timeout bounds each request made to Slack (defaults to DefaultTimeout)
+	Timeout time.Duration
+	// Retries is the number of additional attempts after a rate-limited (HTTP 429) response
+	Retries int
+}
+
+type slackNotifier struct {
+	SlackConfig
+
+	tagExtractor tagextractor.TagExtractor
+	client       httpClient
+	logger       *log.Logger
+	sleep        func(time.Duration)
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackMessage struct {
+	Channel   string       `json:"channel,omitempty"`
+	Username  string       `json:"username,omitempty"`
+	IconEmoji string       `json:"icon_emoji,omitempty"`
+	IconURL   string       `json:"icon_url,omitempty"`
+	Text      string       `json:"text"`
+	Blocks    []slackBlock `json:"blocks"`
+}
+
+// NewSlackNotifier creates an Annotator which posts annotations to a Slack incoming webhook.
+// The tagExtractor is used to extract tags from annotations passed to Post.
+// If c is nil, an HTTP client honoring config.Timeout is created.
+func NewSlackNotifier(config SlackConfig, tagExtractor tagextractor.TagExtractor, c httpClient, logger *log.Logger) Annotator {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if c == nil {
+		c = &http.Client{Timeout: config.Timeout}
+	}
+
+	return &slackNotifier{
+		SlackConfig:  config,
+		tagExtractor: tagExtractor,
+		client:       c,
+		logger:       logger,
+		sleep:        time.Sleep,
+	}
+}
+
+func (n *slackNotifier) Post(annotation string, time time.Time) (int, error) {
+	text, tags := n.tagExtractor.Extract(annotation)
+
+	return n.PostAnnotation(Annotation{Text: text, Tags: tags, Time: time})
+}
+
+// PostAnnotation posts the annotation to Slack. Slack messages have no ID, so 0 is returned on success.
+func (n *slackNotifier) PostAnnotation(annotation Annotation) (int, error) {
+	jsonBytes, err := json.Marshal(n.newMessage(annotation))
+	if err != nil {
+		return -1, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := n.send(jsonBytes)
+		if err == nil {
+			return 0, nil
+		}
+		if retryAfter < 0 || attempt > n.Retries {
+			n.logger.Printf("Error posting Slack notification: %v\n", err)
+			return -1, err
+		}
+
+		n.logger.Printf("Slack rate limit reached, retrying in %v\n", retryAfter)
+		n.sleep(retryAfter)
+	}
+}
+
+func (n *slackNotifier) newMessage(annotation Annotation) slackMessage {
+	t := annotation.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	var sb strings.Builder
+	for _, tag := range annotation.Tags {
+		sb.WriteString("`" + escapeSlackText(tag) + "` ")
+	}
+	sb.WriteString(escapeSlackText(annotation.Text))
+	if annotation.End {
+		sb.WriteString(" _(ended)_")
+	}
+
+	msg := slackMessage{
+		Channel:  n.Channel,
+		Username: n.Username,
+		Text:     sb.String(),
+		Blocks: []slackBlock{
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: sb.String()}},
+			{
+				Type: "context",
+				Elements: []slackText{
+					{
+						Type: "mrkdwn",
+						Text: fmt.Sprintf("<!date^%d^{date_short_pretty} {time_secs}|%s>", t.Unix(), t.UTC().Format(time.RFC3339)),
+					},
+				},
+			},
+		},
+	}
+	switch {
+	case strings.HasPrefix(n.Icon, "http://") || strings.HasPrefix(n.Icon, "https://"):
+		msg.IconURL = n.Icon
+	case n.Icon != "":
+		msg.IconEmoji = n.Icon
+	}
+
+	return msg
+}
+
+// send posts the message once, returning the delay requested by the server before retrying
+// or a negative delay if the request should not be retried
+func (n *slackNotifier) send(body []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return -1, fmt.Errorf("post Slack notification: %w", err)
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return parseRetryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("call to Slack failed with HTTP %d %q", resp.StatusCode, resp.Status)
+	case resp.StatusCode >= 300:
+		var message []byte
+		if resp.Body != nil {
+			message, _ = io.ReadAll(io.LimitReader(resp.Body, 1024))
+		}
+		return -1, fmt.Errorf("call to Slack failed with HTTP %d %q: %s", resp.StatusCode, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return 0, nil
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	var d time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = time.Until(t)
+	} else {
+		d = 1 * time.Second
+	}
+
+	switch {
+	case d < 0:
+		return 0
+	case d > maxRetryAfter:
+		return maxRetryAfter
+	}
+	return d
+}
+
+// escapeSlackText escapes the control characters of Slack's mrkdwn format
+func escapeSlackText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,164 @@
+package notifications
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSlackNotifier(t *testing.T) {
+	n := NewSlackNotifier(SlackConfig{}, tagextractor.NewNoOpTagExtractor(), nil, log.New(io.Discard, "", 0))
+
+	require.IsType(t, &slackNotifier{}, n)
+	assert.Equal(t, DefaultTimeout, n.(*slackNotifier).Timeout)
+}
+
+func TestSlackNotifierPost(t *testing.T) {
+	var messages []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var msg map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		messages = append(messages, msg)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	n := NewSlackNotifier(
+		SlackConfig{WebhookURL: server.URL, Channel: "#nas", Username: "qnapexporter", Icon: ":floppy_disk:"},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	id, err := n.Post("[ups] On battery <UPS1> & counting", time.Unix(1577880000, 0))
+	require.NoError(t, err)
+	assert.Zero(t, id)
+
+	require.Len(t, messages, 1)
+	msg := messages[0]
+	assert.Equal(t, "#nas", msg["channel"])
+	assert.Equal(t, "qnapexporter", msg["username"])
+	assert.Equal(t, ":floppy_disk:", msg["icon_emoji"])
+	assert.NotContains(t, msg, "icon_url")
+	assert.Equal(t, "`ups` On battery &lt;UPS1&gt; &amp; counting", msg["text"])
+
+	blocks := msg["blocks"].([]interface{})
+	require.Len(t, blocks, 2)
+	assert.Equal(t, map[string]interface{}{
+		"type": "section",
+		"text": map[string]interface{}{"type": "mrkdwn", "text": "`ups` On battery &lt;UPS1&gt; &amp; counting"},
+	}, blocks[0])
+	assert.Equal(t, map[string]interface{}{
+		"type": "context",
+		"elements": []interface{}{
+			map[string]interface{}{"type": "mrkdwn", "text": "<!date^1577880000^{date_short_pretty} {time_secs}|2020-01-01T12:00:00Z>"},
+		},
+	}, blocks[1])
+}
+
+func TestSlackNotifierPostAnnotationWithIconURL(t *testing.T) {
+	var msg slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+	}))
+	defer server.Close()
+
+	n := NewSlackNotifier(
+		SlackConfig{WebhookURL: server.URL, Icon: "https://example.com/icon.png"},
+		tagextractor.NewNoOpTagExtractor(),
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	_, err := n.PostAnnotation(Annotation{Text: "On battery", Tags: []string{"ups", "nas"}, End: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://example.com/icon.png", msg.IconURL)
+	assert.Empty(t, msg.IconEmoji)
+	assert.Equal(t, "`ups` `nas` On battery _(ended)_", msg.Text)
+}
+
+func TestSlackNotifierRateLimit(t *testing.T) {
+	testCases := map[string]struct {
+		retries          int
+		responses        []int
+		expectedRequests int
+		expectedSleeps   []time.Duration
+		expectErr        bool
+	}{
+		"honors Retry-After": {
+			retries:          3,
+			responses:        []int{429, 429, 200},
+			expectedRequests: 3,
+			expectedSleeps:   []time.Duration{2 * time.Second, 2 * time.Second},
+		},
+		"gives up after retries": {
+			retries:          1,
+			responses:        []int{429, 429, 200},
+			expectedRequests: 2,
+			expectedSleeps:   []time.Duration{2 * time.Second},
+			expectErr:        true,
+		},
+		"does not retry other errors": {
+			retries:          3,
+			responses:        []int{400, 200},
+			expectedRequests: 1,
+			expectErr:        true,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tc.responses[requests]
+				requests++
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "2")
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			n := NewSlackNotifier(
+				SlackConfig{WebhookURL: server.URL, Retries: tc.retries},
+				tagextractor.NewNoOpTagExtractor(),
+				nil,
+				log.New(io.Discard, "", 0),
+			)
+			var sleeps []time.Duration
+			n.(*slackNotifier).sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+			id, err := n.Post("test notification", time.Now())
+
+			assert.Equal(t, tc.expectedRequests, requests)
+			assert.Equal(t, tc.expectedSleeps, sleeps)
+			if tc.expectErr {
+				assert.Error(t, err)
+				assert.Equal(t, -1, id)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5"))
+	assert.Equal(t, maxRetryAfter, parseRetryAfter("3600"))
+	assert.Equal(t, time.Second, parseRetryAfter("invalid"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)))
+
+	d := parseRetryAfter(time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, 30*time.Second, d, float64(2*time.Second))
+}
@@ -31,3 +31,17 @@ func (_m *MockTagExtractor) Extract(annotation string) (string, []string) {
 
 	return r0, r1
 }
+
+// Restore provides a mock function with given fields: annotation, tags
+func (_m *MockTagExtractor) Restore(annotation string, tags []string) string {
+	ret := _m.Called(annotation, tags)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, []string) string); ok {
+		r0 = rf(annotation, tags)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
@@ -25,3 +25,10 @@ func TestNoOpExtractTags(t *testing.T) {
 	assert.Equal(t, "Started running Security Checkup.", a)
 	assert.Nil(t, tags)
 }
+
+func TestNoOpRestoreTags(t *testing.T) {
+	e := NewNoOpTagExtractor()
+
+	a := e.Restore("Started running Security Checkup.", []string{"nas", "SecurityCounselor"})
+	assert.Equal(t, "Started running Security Checkup.", a)
+}
@@ -12,16 +12,27 @@ func NewNotificationCenterTagExtractor() TagExtractor {
 func (c *notificationCenterTagExtractor) Extract(annotation string) (string, []string) {
 	var tags []string
 
-	for annotation[0] == '[' {
-		endIdx := strings.Index(annotation[1:], "] ")
-		if endIdx == -1 {
+	for strings.HasPrefix(annotation, "[") {
+		// Only the first "] " sequence terminates the tag, so tags may themselves contain "]".
+		// A "[" without a matching "] " (or an empty tag) is kept as literal text.
+		endIdx := strings.Index(annotation, "] ")
+		if endIdx <= 1 {
 			break
 		}
 
-		endIdx++
 		tags = append(tags, annotation[1:endIdx])
 		annotation = annotation[endIdx+2:]
 	}
 
 	return annotation, tags
 }
+
+func (c *notificationCenterTagExtractor) Restore(annotation string, tags []string) string {
+	var sb strings.Builder
+	for _, tag := range tags {
+		sb.WriteString("[" + tag + "] ")
+	}
+	sb.WriteString(annotation)
+
+	return sb.String()
+}
@@ -25,3 +25,77 @@ func TestExtractTags(t *testing.T) {
 	assert.Equal(t, "Started running Security Checkup.", a)
 	assert.Empty(t, tags)
 }
+
+func TestExtractTagsEdgeCases(t *testing.T) {
+	testCases := map[string]struct {
+		annotation         string
+		expectedAnnotation string
+		expectedTags       []string
+	}{
+		"empty annotation": {
+			annotation:         "",
+			expectedAnnotation: "",
+		},
+		"bracket only": {
+			annotation:         "[",
+			expectedAnnotation: "[",
+		},
+		"tag only": {
+			annotation:         "[tag]",
+			expectedAnnotation: "[tag]",
+		},
+		"tag with trailing space only": {
+			annotation:         "[tag] ",
+			expectedAnnotation: "",
+			expectedTags:       []string{"tag"},
+		},
+		"unclosed tag": {
+			annotation:         "[tag text",
+			expectedAnnotation: "[tag text",
+		},
+		"empty tag": {
+			annotation:         "[] text",
+			expectedAnnotation: "[] text",
+		},
+		"tag containing closing bracket": {
+			annotation:         "[a]b] text",
+			expectedAnnotation: "text",
+			expectedTags:       []string{"a]b"},
+		},
+		"multiple tags": {
+			annotation:         "[nas] [Storage & Snapshots] [x] Finished creating snapshot.",
+			expectedAnnotation: "Finished creating snapshot.",
+			expectedTags:       []string{"nas", "Storage & Snapshots", "x"},
+		},
+		"tags followed by unclosed bracket": {
+			annotation:         "[nas] [unclosed",
+			expectedAnnotation: "[unclosed",
+			expectedTags:       []string{"nas"},
+		},
+		"bracket in text": {
+			annotation:         "[nas] Volume [1] is ready",
+			expectedAnnotation: "Volume [1] is ready",
+			expectedTags:       []string{"nas"},
+		},
+	}
+
+	e := NewNotificationCenterTagExtractor()
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			a, tags := e.Extract(tc.annotation)
+
+			assert.Equal(t, tc.expectedAnnotation, a)
+			assert.Equal(t, tc.expectedTags, tags)
+		})
+	}
+}
+
+func TestRestoreTags(t *testing.T) {
+	e := NewNotificationCenterTagExtractor()
+
+	a := e.Restore("Started running Security Checkup.", []string{"nas", "SecurityCounselor"})
+	assert.Equal(t, "[nas] [SecurityCounselor] Started running Security Checkup.", a)
+
+	a = e.Restore("Started running Security Checkup.", nil)
+	assert.Equal(t, "Started running Security Checkup.", a)
+}
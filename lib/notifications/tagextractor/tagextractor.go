@@ -3,6 +3,8 @@ package tagextractor
 type TagExtractor interface {
 	// Extract takes an annotation and returns a modified annotation and an array of extracted tags
 	Extract(annotation string) (string, []string)
+	// Restore is the inverse of Extract, rebuilding the original annotation from the modified annotation and its tags
+	Restore(annotation string, tags []string) string
 }
 
 type noOpTagExtractor struct {
@@ -15,3 +17,7 @@ func NewNoOpTagExtractor() TagExtractor {
 func (c *noOpTagExtractor) Extract(annotation string) (string, []string) {
 	return annotation, nil
 }
+
+func (c *noOpTagExtractor) Restore(annotation string, tags []string) string {
+	return annotation
+}
@@ -0,0 +1,220 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+)
+
+// DefaultTelegramAPIURL is the base URL of the Telegram Bot API
+const DefaultTelegramAPIURL = "https://api.telegram.org"
+
+// TelegramConfig holds the settings used to post messages through a Telegram bot
+type TelegramConfig struct {
+	// APIURL is the base URL of the Bot API (defaults to DefaultTelegramAPIURL)
+	APIURL   string
+	BotToken string
+	ChatID   string
+	// Timeout bounds each request made to Telegram (defaults to DefaultTimeout)
+	Timeout time.Duration
+	// Retries is the number of additional attempts after a connection error,
+	// an HTTP 5xx response or a rate-limited response
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled on every subsequent retry
+	// up to RetryMaxBackoff (default to DefaultRetryBackoff and DefaultRetryMaxBackoff).
+	// Rate-limited responses are retried after the delay requested by Telegram instead.
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+}
+
+type telegramNotifier struct {
+	TelegramConfig
+
+	tagExtractor tagextractor.TagExtractor
+	client       httpClient
+	logger       *log.Logger
+	sleep        func(time.Duration)
+}
+
+type telegramMessage struct {
+	ChatID    string `json:"chat_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode"`
+}
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+	Result struct {
+		MessageID int `json:"message_id"`
+	} `json:"result"`
+}
+
+// NewTelegramNotifier creates an Annotator which posts annotations to a Telegram chat
+// through the sendMessage method of the Bot API.
+// The tagExtractor is used to extract tags from annotations passed to Post.
+// If c is nil, an HTTP client honoring config.Timeout is created.
+func NewTelegramNotifier(config TelegramConfig, tagExtractor tagextractor.TagExtractor, c httpClient, logger *log.Logger) Annotator {
+	if config.APIURL == "" {
+		config.APIURL = DefaultTelegramAPIURL
+	}
+	config.APIURL = strings.TrimSuffix(config.APIURL, "/")
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = DefaultRetryBackoff
+	}
+	if config.RetryMaxBackoff <= 0 {
+		config.RetryMaxBackoff = DefaultRetryMaxBackoff
+	}
+	if c == nil {
+		c = &http.Client{Timeout: config.Timeout}
+	}
+
+	return &telegramNotifier{
+		TelegramConfig: config,
+		tagExtractor:   tagExtractor,
+		client:         c,
+		logger:         logger,
+		sleep:          time.Sleep,
+	}
+}
+
+func (n *telegramNotifier) Post(annotation string, time time.Time) (int, error) {
+	text, tags := n.tagExtractor.Extract(annotation)
+
+	return n.PostAnnotation(Annotation{Text: text, Tags: tags, Time: time})
+}
+
+// PostAnnotation sends the annotation to the configured chat, returning the ID of the sent message
+func (n *telegramNotifier) PostAnnotation(annotation Annotation) (int, error) {
+	jsonBytes, err := json.Marshal(telegramMessage{
+		ChatID:    n.ChatID,
+		Text:      formatTelegramMessage(annotation),
+		ParseMode: "MarkdownV2",
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		id, retryAfter, err := n.send(jsonBytes)
+		if err == nil {
+			return id, nil
+		}
+		if retryAfter < 0 || attempt > n.Retries {
+			n.logger.Printf("Error posting Telegram notification: %v\n", err)
+			if n.Retries > 0 {
+				err = fmt.Errorf("%w (attempt %d)", err, attempt)
+			}
+			return -1, err
+		}
+
+		if retryAfter == 0 {
+			retryAfter = retryDelay(attempt, n.RetryBackoff, n.RetryMaxBackoff, 0)
+		}
+		n.logger.Printf("Error posting Telegram notification, retrying in %v: %v\n", retryAfter, err)
+		n.sleep(retryAfter)
+	}
+}
+
+// send posts the message once, returning the ID of the sent message.
+// On failure, it returns the delay requested by Telegram before retrying (0 if unspecified)
+// or a negative delay if the request should not be retried.
+func (n *telegramNotifier) send(body []byte) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.APIURL+"/bot"+n.BotToken+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return -1, -1, errors.New("create Telegram request: invalid API URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The request URL contains the bot token, so only report the underlying error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return -1, 0, fmt.Errorf("post Telegram notification: %w", err)
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+
+	var response telegramResponse
+	if resp.Body != nil {
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response)
+	}
+
+	if resp.StatusCode >= 300 || !response.OK {
+		err := fmt.Errorf("call to Telegram failed with HTTP %d %q", resp.StatusCode, resp.Status)
+		if response.Description != "" {
+			err = fmt.Errorf("call to Telegram failed with HTTP %d %q: %s", resp.StatusCode, resp.Status, response.Description)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			retryAfter := time.Duration(response.Parameters.RetryAfter) * time.Second
+			if retryAfter <= 0 {
+				retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			}
+			if retryAfter > maxRetryAfter {
+				retryAfter = maxRetryAfter
+			}
+			return -1, retryAfter, err
+		case isRetryable(resp.StatusCode):
+			return -1, 0, err
+		}
+		return -1, -1, err
+	}
+
+	return response.Result.MessageID, 0, nil
+}
+
+// formatTelegramMessage renders the annotation as a MarkdownV2 message, with the tags as hashtags
+func formatTelegramMessage(annotation Annotation) string {
+	var sb strings.Builder
+	for _, tag := range annotation.Tags {
+		sb.WriteString(escapeTelegramText("#"+tag) + " ")
+	}
+	sb.WriteString(escapeTelegramText(annotation.Text))
+	if annotation.End {
+		sb.WriteString(" _" + escapeTelegramText("(ended)") + "_")
+	}
+	if !annotation.Time.IsZero() {
+		sb.WriteString("\n" + escapeTelegramText(annotation.Time.Format(time.RFC3339)))
+	}
+
+	return sb.String()
+}
+
+// escapeTelegramText escapes all the characters which have a special meaning in MarkdownV2
+func escapeTelegramText(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("_*[]()~`>#+-=|{}.!\\", r) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
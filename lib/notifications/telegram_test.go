@@ -0,0 +1,144 @@
+package notifications
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTelegramNotifierPost(t *testing.T) {
+	var msg telegramMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/bot123:secret/sendMessage", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+
+		_, _ = io.WriteString(w, `{"ok":true,"result":{"message_id":42}}`)
+	}))
+	defer server.Close()
+
+	n := NewTelegramNotifier(
+		TelegramConfig{APIURL: server.URL + "/", BotToken: "123:secret", ChatID: "-100200"},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	id, err := n.Post("[Storage & Snapshots] [Volume 1] Rebuilding (50.5%) done!", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 42, id)
+
+	assert.Equal(t, "-100200", msg.ChatID)
+	assert.Equal(t, "MarkdownV2", msg.ParseMode)
+	assert.Equal(t, `\#Storage & Snapshots \#Volume 1 Rebuilding \(50\.5%\) done\!`+"\n"+`2020\-01\-01T12:00:00Z`, msg.Text)
+}
+
+func TestFormatTelegramMessage(t *testing.T) {
+	assert.Equal(t, `a\_b\*c\[d\]e\~f\`+"`"+`g\>h\+i\=j\|k\{l\}m\\n _\(ended\)_`,
+		formatTelegramMessage(Annotation{Text: "a_b*c[d]e~f`g>h+i=j|k{l}m\\n", End: true}))
+}
+
+func TestTelegramNotifierRetries(t *testing.T) {
+	testCases := map[string]struct {
+		retries          int
+		responses        []string
+		expectedRequests int
+		expectedSleeps   []time.Duration
+		expectedErr      string
+	}{
+		"honors retry_after": {
+			retries:          3,
+			responses:        []string{"429", "200"},
+			expectedRequests: 2,
+			expectedSleeps:   []time.Duration{7 * time.Second},
+		},
+		"retries server errors with backoff": {
+			retries:          3,
+			responses:        []string{"502", "502", "200"},
+			expectedRequests: 3,
+			expectedSleeps:   []time.Duration{1 * time.Second, 2 * time.Second},
+		},
+		"gives up after retries": {
+			retries:          1,
+			responses:        []string{"502", "502"},
+			expectedRequests: 2,
+			expectedSleeps:   []time.Duration{1 * time.Second},
+			expectedErr:      `call to Telegram failed with HTTP 502 "502 Bad Gateway": Bad Gateway (attempt 2)`,
+		},
+		"does not retry client errors": {
+			retries:          3,
+			responses:        []string{"400"},
+			expectedRequests: 1,
+			expectedErr:      `call to Telegram failed with HTTP 400 "400 Bad Request": Bad Request: can't parse entities (attempt 1)`,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				response := tc.responses[requests]
+				requests++
+				switch response {
+				case "429":
+					w.WriteHeader(http.StatusTooManyRequests)
+					_, _ = io.WriteString(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`)
+				case "502":
+					w.WriteHeader(http.StatusBadGateway)
+					_, _ = io.WriteString(w, `{"ok":false,"error_code":502,"description":"Bad Gateway"}`)
+				case "400":
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = io.WriteString(w, `{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities"}`)
+				default:
+					_, _ = io.WriteString(w, `{"ok":true,"result":{"message_id":1}}`)
+				}
+			}))
+			defer server.Close()
+
+			n := NewTelegramNotifier(
+				TelegramConfig{APIURL: server.URL, BotToken: "token", ChatID: "1", Retries: tc.retries},
+				tagextractor.NewNoOpTagExtractor(),
+				nil,
+				log.New(io.Discard, "", 0),
+			)
+			var sleeps []time.Duration
+			n.(*telegramNotifier).sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+			id, err := n.Post("test notification", time.Now())
+
+			assert.Equal(t, tc.expectedRequests, requests)
+			assert.Equal(t, tc.expectedSleeps, sleeps)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				assert.Equal(t, -1, id)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, id)
+			}
+		})
+	}
+}
+
+func TestTelegramNotifierConnectionErrorHidesToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	n := NewTelegramNotifier(
+		TelegramConfig{APIURL: server.URL, BotToken: "123:secret", ChatID: "1"},
+		tagextractor.NewNoOpTagExtractor(),
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	_, err := n.Post("test notification", time.Now())
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+}
@@ -0,0 +1,116 @@
+package notifications
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateData holds the fields available to the templates in the configured tags and text affixes
+type templateData struct {
+	Hostname string
+	Time     time.Time
+}
+
+// textTemplate is a string which may contain template placeholders such as {{.Hostname}}
+type textTemplate struct {
+	raw  string
+	tmpl *template.Template
+}
+
+// parseTextTemplate parses s, which is only treated as a template if it contains placeholders
+func parseTextTemplate(s string) (textTemplate, error) {
+	t := textTemplate{raw: s}
+	if !t.isTemplate() {
+		return t, nil
+	}
+
+	tmpl, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return t, fmt.Errorf("parse template %q: %w", s, err)
+	}
+	t.tmpl = tmpl
+
+	return t, nil
+}
+
+func (t textTemplate) isTemplate() bool {
+	return strings.Contains(t.raw, "{{")
+}
+
+// parseTemplateOrLog parses s, logging the error and falling back to the raw string if it is invalid
+func parseTemplateOrLog(s string, logger *log.Logger) textTemplate {
+	t, err := parseTextTemplate(s)
+	if err != nil {
+		logger.Printf("Error in Grafana annotation template, using it verbatim: %v\n", err)
+	}
+
+	return t
+}
+
+// expand executes the template, falling back to the raw string if it fails
+func (t textTemplate) expand(data templateData) string {
+	if t.tmpl == nil {
+		return t.raw
+	}
+
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return t.raw
+	}
+
+	return buf.String()
+}
+
+// CheckTemplates returns an error if any of the configured tags or text affixes is an invalid template
+func (c GrafanaConfig) CheckTemplates() error {
+	for _, s := range append([]string{c.TextPrefix, c.TextSuffix}, c.Tags...) {
+		if _, err := parseTextTemplate(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// templateData returns the data used to expand the templates for an annotation at time t
+func (a *regionMatchingAnnotator) templateData(t time.Time) templateData {
+	var hostname string
+	if a.hostname != nil {
+		hostname = a.hostname()
+	}
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+
+	return templateData{Hostname: hostname, Time: t}
+}
+
+// expandTags returns the configured tags, with their templates expanded
+func (a *regionMatchingAnnotator) expandTags(data templateData) []string {
+	if a.tags == nil {
+		return nil
+	}
+
+	tags := make([]string, 0, len(a.tags))
+	for _, tag := range a.tags {
+		tags = append(tags, tag.expand(data))
+	}
+
+	return tags
+}
+
+// expandText adds the configured prefix and suffix to the annotation text
+func (a *regionMatchingAnnotator) expandText(text string, data templateData) string {
+	return a.textPrefix.expand(data) + text + a.textSuffix.expand(data)
+}
+
+// stripText removes the configured prefix and suffix from an annotation text created by expandText
+func (a *regionMatchingAnnotator) stripText(text string, data templateData) string {
+	text = strings.TrimPrefix(text, a.textPrefix.expand(data))
+	return strings.TrimSuffix(text, a.textSuffix.expand(data))
+}
@@ -0,0 +1,81 @@
+package notifications
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostTemplatedAnnotation(t *testing.T) {
+	var posted grafanaAnnotation
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&posted))
+		_, _ = io.WriteString(w, `{"id":1}`)
+	}))
+	defer server.Close()
+
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{
+			URL:        server.URL,
+			Tags:       []string{"nas", "host:{{.Hostname}}", "{{.Time.Format \"2006-01\"}}", "{{.Unknown}}"},
+			TextPrefix: "{{.Hostname}}: ",
+			TextSuffix: " ({{.Time.UTC.Format \"15:04\"}})",
+			Hostname:   func() string { return "nas1" },
+		},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		NewNoOpRegionMatcher(),
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	_, err := a.Post("[UPS] On battery", time.Date(2020, 1, 1, 12, 30, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	assert.Equal(t, "nas1: On battery (12:30)", posted.Text)
+	assert.Equal(t, []string{"nas", "host:nas1", "2020-01", "{{.Unknown}}", "UPS"}, posted.Tags)
+}
+
+func TestCheckTemplates(t *testing.T) {
+	assert.NoError(t, GrafanaConfig{Tags: []string{"nas", "{{.Hostname}}"}, TextPrefix: "[{{.Hostname}}] "}.CheckTemplates())
+	assert.ErrorContains(t, GrafanaConfig{Tags: []string{"{{.Hostname"}}.CheckTemplates(), `parse template "{{.Hostname"`)
+	assert.Error(t, GrafanaConfig{TextSuffix: "{{if}}"}.CheckTemplates())
+}
+
+func TestLoadOpenTemplatedRegions(t *testing.T) {
+	now := time.Now()
+	ms := now.Add(-time.Hour).UnixNano() / 1000000
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, []string{"nas", "host:nas1"}, r.URL.Query()["tags"])
+		_ = json.NewEncoder(w).Encode([]grafanaAnnotation{
+			{Id: 7, Time: ms, Text: "nas1: Started scanning.", Tags: []string{"nas", "host:nas1", "Malware Remover"}},
+		})
+	}))
+	defer server.Close()
+
+	cache := NewRegionMatcher(20, 0, nil, log.New(io.Discard, "", 0))
+	a := NewRegionMatchingAnnotator(
+		GrafanaConfig{
+			URL:        server.URL,
+			Tags:       []string{"nas", "host:{{.Hostname}}", "{{.Time.Year}}"},
+			TextPrefix: "{{.Hostname}}: ",
+			Hostname:   func() string { return "nas1" },
+		},
+		tagextractor.NewNotificationCenterTagExtractor(),
+		cache,
+		nil,
+		log.New(io.Discard, "", 0),
+	)
+
+	count, err := a.(RegionLoader).LoadOpenRegions(now.Add(-24 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 7, cache.Match("[Malware Remover] Scan completed."))
+}
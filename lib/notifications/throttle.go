@@ -0,0 +1,190 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+// ErrRateLimited is returned when an annotation is dropped because the rate limit was reached
+var ErrRateLimited = errors.New("notification rate limit reached")
+
+// TokenBucket limits the rate of annotations, allowing bursts of up to its capacity.
+// It can be shared by several ThrottledNotifiers to enforce a global limit.
+type TokenBucket struct {
+	capacity float64
+	rate     float64 // tokens per second
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewTokenBucket creates a full TokenBucket allowing perMinute annotations per minute
+func NewTokenBucket(perMinute int) *TokenBucket {
+	b := &TokenBucket{
+		capacity: float64(perMinute),
+		rate:     float64(perMinute) / 60,
+		tokens:   float64(perMinute),
+		now:      time.Now,
+	}
+	b.last = b.now()
+
+	return b
+}
+
+// Allow takes a token from the bucket, returning false if there is none left
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+	}
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ThrottleConfig holds the settings of a ThrottledNotifier
+type ThrottleConfig struct {
+	// DedupWindow is the period during which annotations identical to a previous one are suppressed
+	// (defaults to 0, i.e. no deduplication)
+	DedupWindow time.Duration
+	// RateLimiter caps the rate of annotations (defaults to nil, i.e. no rate limiting)
+	RateLimiter *TokenBucket
+}
+
+// ThrottleStats holds the counters of a ThrottledNotifier
+type ThrottleStats struct {
+	Suppressed  uint64
+	RateLimited uint64
+}
+
+// ThrottledNotifier is an Annotator which suppresses duplicate annotations and rate limits the others
+// before forwarding them to another Annotator
+type ThrottledNotifier struct {
+	ThrottleConfig
+
+	next         Annotator
+	tagExtractor tagextractor.TagExtractor
+	logger       *log.Logger
+
+	mu    sync.Mutex
+	seen  map[string]*dedupEntry
+	stats ThrottleStats
+}
+
+type dedupEntry struct {
+	suppressed int
+	// repost sends the summary of the suppressed annotations, with suffix appended to the text
+	repost func(suffix string) (int, error)
+}
+
+// NewThrottledNotifier creates a ThrottledNotifier forwarding annotations to next.
+// The tagExtractor is used to extract the text of annotations passed to Post, which is compared to detect duplicates.
+func NewThrottledNotifier(config ThrottleConfig, tagExtractor tagextractor.TagExtractor, next Annotator, logger *log.Logger) *ThrottledNotifier {
+	return &ThrottledNotifier{
+		ThrottleConfig: config,
+		next:           next,
+		tagExtractor:   tagExtractor,
+		logger:         logger,
+		seen:           map[string]*dedupEntry{},
+	}
+}
+
+func (n *ThrottledNotifier) Post(annotation string, time time.Time) (int, error) {
+	text, _ := n.tagExtractor.Extract(annotation)
+
+	return n.forward(dedupKey(text, false), func(suffix string) (int, error) {
+		return n.next.Post(annotation+suffix, time)
+	})
+}
+
+func (n *ThrottledNotifier) PostAnnotation(annotation Annotation) (int, error) {
+	return n.forward(dedupKey(annotation.Text, annotation.End), func(suffix string) (int, error) {
+		a := annotation
+		a.Text += suffix
+		return n.next.PostAnnotation(a)
+	})
+}
+
+// Stats returns a snapshot of the throttling counters
+func (n *ThrottledNotifier) Stats() ThrottleStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.stats
+}
+
+func (n *ThrottledNotifier) forward(key string, post func(suffix string) (int, error)) (int, error) {
+	if n.DedupWindow > 0 {
+		n.mu.Lock()
+		if entry, ok := n.seen[key]; ok {
+			entry.suppressed++
+			entry.repost = post
+			n.stats.Suppressed++
+			n.mu.Unlock()
+
+			utils.Debugf(n.logger, "Suppressing duplicate notification %q\n", key)
+			return 0, nil
+		}
+
+		n.seen[key] = &dedupEntry{repost: post}
+		n.mu.Unlock()
+		time.AfterFunc(n.DedupWindow, func() { n.closeWindow(key) })
+	}
+
+	return n.allowAndPost(key, func() (int, error) { return post("") })
+}
+
+// closeWindow ends the deduplication window of key, posting a summary if annotations were suppressed
+func (n *ThrottledNotifier) closeWindow(key string) {
+	n.mu.Lock()
+	entry := n.seen[key]
+	delete(n.seen, key)
+	n.mu.Unlock()
+
+	if entry == nil || entry.suppressed == 0 {
+		return
+	}
+
+	suffix := fmt.Sprintf(" (%d similar events suppressed in the last %v)", entry.suppressed, n.DedupWindow)
+	_, _ = n.allowAndPost(key, func() (int, error) { return entry.repost(suffix) })
+}
+
+func (n *ThrottledNotifier) allowAndPost(key string, post func() (int, error)) (int, error) {
+	if n.RateLimiter != nil && !n.RateLimiter.Allow() {
+		n.mu.Lock()
+		n.stats.RateLimited++
+		n.mu.Unlock()
+
+		utils.Debugf(n.logger, "Dropping rate limited notification %q\n", key)
+		return -1, ErrRateLimited
+	}
+
+	return post()
+}
+
+func dedupKey(text string, end bool) string {
+	if end {
+		// The end of a region must not be mistaken for its start
+		return text + "\x00end"
+	}
+
+	return text
+}
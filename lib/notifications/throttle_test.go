@@ -0,0 +1,95 @@
+package notifications
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := NewTokenBucket(2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	now = now.Add(20 * time.Second)
+	assert.False(t, b.Allow())
+
+	now = now.Add(10 * time.Second)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	now = now.Add(time.Hour)
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+}
+
+func TestThrottledNotifierDeduplicates(t *testing.T) {
+	ts := time.Now()
+	m := &MockAnnotator{}
+	m.On("Post", "[UPS] On battery", ts).Return(1, nil).Once()
+	m.On("Post", "[UPS] On line", ts).Return(2, nil).Once()
+	m.On("PostAnnotation", Annotation{Text: "On battery", End: true}).Return(3, nil).Once()
+	summaryPosted := make(chan struct{})
+	m.On("Post", "[UPS] On battery (2 similar events suppressed in the last 50ms)", ts).Return(4, nil).Once().
+		Run(func(mock.Arguments) { close(summaryPosted) })
+
+	n := NewThrottledNotifier(ThrottleConfig{DedupWindow: 50 * time.Millisecond}, tagextractor.NewNotificationCenterTagExtractor(), m, log.New(io.Discard, "", 0))
+
+	id, err := n.Post("[UPS] On battery", ts)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+	id, err = n.Post("[UPS] On line", ts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, id)
+	id, err = n.Post("[Power] [UPS] On battery", ts)
+	assert.NoError(t, err)
+	assert.Zero(t, id)
+	_, _ = n.Post("[UPS] On battery", ts)
+	// The end of a region is not a duplicate of its start
+	id, err = n.PostAnnotation(Annotation{Text: "On battery", End: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, id)
+
+	assert.Equal(t, ThrottleStats{Suppressed: 2}, n.Stats())
+	select {
+	case <-summaryPosted:
+	case <-time.After(time.Second):
+		require.Fail(t, "summary of suppressed notifications not posted")
+	}
+	m.AssertExpectations(t)
+}
+
+func TestThrottledNotifierRateLimits(t *testing.T) {
+	ts := time.Now()
+	m := &MockAnnotator{}
+	m.On("Post", "first", ts).Return(1, nil).Once()
+	m.On("PostAnnotation", Annotation{Text: "second"}).Return(2, nil).Once()
+
+	bucket := NewTokenBucket(2)
+	n1 := NewThrottledNotifier(ThrottleConfig{RateLimiter: bucket}, tagextractor.NewNoOpTagExtractor(), m, log.New(io.Discard, "", 0))
+	n2 := NewThrottledNotifier(ThrottleConfig{RateLimiter: bucket}, tagextractor.NewNoOpTagExtractor(), m, log.New(io.Discard, "", 0))
+
+	_, err := n1.Post("first", ts)
+	assert.NoError(t, err)
+	_, err = n2.PostAnnotation(Annotation{Text: "second"})
+	assert.NoError(t, err)
+	id, err := n1.Post("third", ts)
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, -1, id)
+
+	m.AssertExpectations(t)
+	assert.Equal(t, ThrottleStats{RateLimited: 1}, n1.Stats())
+	assert.Equal(t, ThrottleStats{}, n2.Stats())
+}
@@ -0,0 +1,92 @@
+package notifications
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource provides the token used to authenticate against Grafana
+type TokenSource interface {
+	Token() string
+}
+
+// FileTokenSource reads the Grafana token from a file, re-reading it whenever the file changes,
+// so that rotated service account tokens take effect without restarting
+type FileTokenSource struct {
+	path   string
+	logger *log.Logger
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// NewFileTokenSource creates a FileTokenSource, failing if the token can't be read
+func NewFileTokenSource(path string, logger *log.Logger) (*FileTokenSource, error) {
+	s := &FileTokenSource{path: path, logger: logger}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Token returns the current token, reloading it first if the file has changed.
+// If the file can no longer be read, the last known token is returned.
+func (s *FileTokenSource) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		s.logger.Printf("Error checking Grafana token file %q: %v\n", s.path, err)
+		return s.token
+	}
+
+	if !info.ModTime().Equal(s.modTime) || info.Size() != s.size {
+		if err := s.reload(); err != nil {
+			s.logger.Printf("Error reloading Grafana token file, keeping previous token: %v\n", err)
+		}
+	}
+
+	return s.token
+}
+
+// Reload re-reads the token from the file
+func (s *FileTokenSource) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reload()
+}
+
+func (s *FileTokenSource) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("read Grafana token file: %w", err)
+	}
+
+	contents, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("read Grafana token file: %w", err)
+	}
+
+	token := strings.TrimSpace(string(contents))
+	if token == "" {
+		return fmt.Errorf("Grafana token file %q is empty", s.path)
+	}
+
+	if s.token != "" && token != s.token {
+		s.logger.Printf("Reloaded Grafana token from %q\n", s.path)
+	}
+	s.token = token
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package notifications
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFileTokenSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("  token1\n"), 0o600))
+
+	s, err := NewFileTokenSource(path, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	assert.Equal(t, "token1", s.Token())
+}
+
+func TestNewFileTokenSourceWithInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	emptyPath := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(emptyPath, []byte("\n"), 0o600))
+
+	_, err := NewFileTokenSource(filepath.Join(dir, "missing"), log.New(io.Discard, "", 0))
+	assert.Error(t, err)
+
+	_, err = NewFileTokenSource(emptyPath, log.New(io.Discard, "", 0))
+	assert.EqualError(t, err, `Grafana token file "`+emptyPath+`" is empty`)
+}
+
+func TestFileTokenSourceReloadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("token1"), 0o600))
+
+	s, err := NewFileTokenSource(path, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("token2"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	assert.Equal(t, "token2", s.Token())
+
+	// An unreadable file keeps the last known token
+	require.NoError(t, os.Remove(path))
+	assert.Equal(t, "token2", s.Token())
+	assert.Error(t, s.Reload())
+	assert.Equal(t, "token2", s.Token())
+}
+
+func TestPostAnnotationUsesTokenSource(t *testing.T) {
+	tokenSourceMock := new(MockTokenSource)
+	defer tokenSourceMock.AssertExpectations(t)
+	tokenSourceMock.On("Token").Once().Return("token2")
+
+	clientMock := new(mockHttpClient)
+	defer clientMock.AssertExpectations(t)
+	clientMock.On("Do", requestWithHeader("Authorization", "Bearer token2")).Once().Return(responseWithBody(`{"id": 1}`), nil)
+
+	a := NewSimpleAnnotator(
+		GrafanaConfig{URL: "http://grafana.com", AuthToken: "token1", AuthTokenSource: tokenSourceMock},
+		clientMock,
+		log.New(io.Discard, "", 0),
+	)
+
+	_, err := a.Post("test notification", time.Now())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,23 @@
+package notifications
+
+import (
+	"net/http"
+
+	"github.com/pedropombeiro/qnapexporter/lib/httpclient"
+)
+
+// NewGrafanaHTTPClient creates the HTTP client used to reach Grafana, honoring the timeout and TLS settings in config
+func NewGrafanaHTTPClient(config GrafanaConfig) (*http.Client, error) {
+	return httpclient.NewFactory(httpclient.Config{Timeout: DefaultTimeout}).Client("Grafana", config.HTTPConfig())
+}
+
+// HTTPConfig returns the settings of the HTTP client used to reach Grafana, e.g. to override those of a shared
+// httpclient.Factory
+func (c GrafanaConfig) HTTPConfig() httpclient.Config {
+	return httpclient.Config{
+		Timeout:            c.Timeout,
+		ProxyURL:           c.ProxyURL,
+		CAFile:             c.CAFile,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+}
@@ -0,0 +1,127 @@
+package notifications
+
+import (
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTLSGrafanaServer(t *testing.T) (*httptest.Server, string) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"id": 1}`)
+	}))
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, certPEM, 0o600))
+
+	return server, caFile
+}
+
+func TestNewGrafanaHTTPClient(t *testing.T) {
+	server, caFile := newTLSGrafanaServer(t)
+	defer server.Close()
+
+	testCases := map[string]struct {
+		config      GrafanaConfig
+		expectedErr string
+	}{
+		"system CAs": {
+			expectedErr: "x509",
+		},
+		"custom CA": {
+			config: GrafanaConfig{CAFile: caFile},
+		},
+		"insecure": {
+			config: GrafanaConfig{InsecureSkipVerify: true},
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			tc.config.URL = server.URL
+			c, err := NewGrafanaHTTPClient(tc.config)
+			require.NoError(t, err)
+
+			a := NewSimpleAnnotator(tc.config, c, log.New(io.Discard, "", 0))
+			_, err = a.Post("test notification", time.Now())
+
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestNewGrafanaHTTPClientWithInvalidCAFile(t *testing.T) {
+	dir := t.TempDir()
+	invalidCAFile := filepath.Join(dir, "invalid.pem")
+	require.NoError(t, os.WriteFile(invalidCAFile, []byte("not a certificate"), 0o600))
+
+	_, err := NewGrafanaHTTPClient(GrafanaConfig{CAFile: filepath.Join(dir, "missing.pem")})
+	assert.Error(t, err)
+
+	_, err = NewGrafanaHTTPClient(GrafanaConfig{CAFile: invalidCAFile})
+	assert.EqualError(t, err, `no valid certificates found in Grafana CA file "`+invalidCAFile+`"`)
+}
+
+func TestNewAnnotatorBuildsTransport(t *testing.T) {
+	a := NewSimpleAnnotator(GrafanaConfig{InsecureSkipVerify: true}, nil, log.New(io.Discard, "", 0))
+
+	c := a.(*regionMatchingAnnotator).client.(*http.Client)
+	require.IsType(t, &http.Transport{}, c.Transport)
+	assert.True(t, c.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+}
+
+func TestNewGrafanaHTTPClientWithProxy(t *testing.T) {
+	var proxiedURL string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedURL = r.URL.String()
+		_, _ = io.WriteString(w, `{"id": 1}`)
+	}))
+	defer proxy.Close()
+
+	config := GrafanaConfig{URL: "http://grafana.example.com", ProxyURL: proxy.URL}
+	c, err := NewGrafanaHTTPClient(config)
+	require.NoError(t, err)
+
+	a := NewSimpleAnnotator(config, c, log.New(io.Discard, "", 0))
+	id, err := a.Post("test notification", time.Now())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+	assert.Equal(t, "http://grafana.example.com/api/annotations", proxiedURL)
+}
+
+func TestPostAnnotationReportsUnreachableProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.NotFoundHandler())
+	proxyURL := proxy.URL
+	proxy.Close()
+
+	config := GrafanaConfig{URL: "http://grafana.example.com", ProxyURL: proxyURL}
+	a := NewSimpleAnnotator(config, nil, log.New(io.Discard, "", 0))
+	_, err := a.Post("test notification", time.Now())
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "(via proxy "+proxy.Listener.Addr().String()+")")
+}
+
+func TestNewGrafanaHTTPClientWithInvalidProxy(t *testing.T) {
+	_, err := NewGrafanaHTTPClient(GrafanaConfig{ProxyURL: "proxy:3128"})
+	assert.Error(t, err)
+
+	_, err = NewGrafanaHTTPClient(GrafanaConfig{ProxyURL: "://"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,187 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+)
+
+// DefaultWebhookTemplate is the template used to render the webhook body if none is configured
+const DefaultWebhookTemplate = `{"text":{{json .Text}},"tags":{{json .Tags}},"time":{{json .Time}},"hostname":{{json .Hostname}},"end":{{.End}}}`
+
+// WebhookConfig holds the settings used to post annotations to a generic JSON webhook
+type WebhookConfig struct {
+	URL string
+	// Template is a text/template rendering the JSON request body (defaults to DefaultWebhookTemplate).
+	// It is executed against a WebhookEvent and can use the json function to encode values.
+	Template string
+	// Headers are added to every request (e.g. authorization headers)
+	Headers map[string]string
+	// Timeout bounds each request made to the webhook (defaults to DefaultTimeout)
+	Timeout time.Duration
+	// Retries is the number of additional attempts after a connection error or an HTTP 5xx response
+	Retries int
+}
+
+// WebhookEvent holds the fields available to the webhook template
+type WebhookEvent struct {
+	Text     string
+	Tags     []string
+	Time     time.Time
+	Hostname string
+	End      bool
+}
+
+type webhookNotifier struct {
+	WebhookConfig
+
+	template     *template.Template
+	hostname     string
+	tagExtractor tagextractor.TagExtractor
+	client       httpClient
+	logger       *log.Logger
+	sleep        func(time.Duration)
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+}
+
+// NewWebhookNotifier creates an Annotator which posts annotations as JSON rendered from a template.
+// The tagExtractor is used to extract tags from annotations passed to Post.
+// If c is nil, an HTTP client honoring config.Timeout is created.
+// An error is returned if the template can't be parsed.
+func NewWebhookNotifier(config WebhookConfig, tagExtractor tagextractor.TagExtractor, c httpClient, logger *log.Logger) (Annotator, error) {
+	if config.Template == "" {
+		config.Template = DefaultWebhookTemplate
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if c == nil {
+		c = &http.Client{Timeout: config.Timeout}
+	}
+
+	tmpl, err := template.New("webhook").Funcs(webhookTemplateFuncs).Option("missingkey=error").Parse(config.Template)
+	if err != nil {
+		return nil, fmt.Errorf("parse webhook template: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &webhookNotifier{
+		WebhookConfig: config,
+		template:      tmpl,
+		hostname:      hostname,
+		tagExtractor:  tagExtractor,
+		client:        c,
+		logger:        logger,
+		sleep:         time.Sleep,
+	}, nil
+}
+
+func (n *webhookNotifier) Post(annotation string, time time.Time) (int, error) {
+	text, tags := n.tagExtractor.Extract(annotation)
+
+	return n.PostAnnotation(Annotation{Text: text, Tags: tags, Time: time})
+}
+
+// PostAnnotation renders the template for the annotation and posts it to the webhook.
+// Webhooks have no notion of IDs, so 0 is returned on success.
+func (n *webhookNotifier) PostAnnotation(annotation Annotation) (int, error) {
+	body, err := n.render(annotation)
+	if err != nil {
+		n.logger.Printf("Error rendering webhook template: %v\n", err)
+		return -1, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		var statusCode int
+		statusCode, err = n.send(body)
+		if err == nil {
+			return 0, nil
+		}
+		if !isRetryable(statusCode) || attempt > n.Retries {
+			break
+		}
+
+		n.logger.Printf("Error posting to webhook, retrying: %v\n", err)
+		n.sleep(retryDelay(attempt, DefaultRetryBackoff, DefaultRetryMaxBackoff, 0))
+	}
+
+	n.logger.Printf("Error posting to webhook: %v\n", err)
+	return -1, err
+}
+
+func (n *webhookNotifier) render(annotation Annotation) ([]byte, error) {
+	event := WebhookEvent{
+		Text:     annotation.Text,
+		Tags:     annotation.Tags,
+		Time:     annotation.Time,
+		Hostname: n.hostname,
+		End:      annotation.End,
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Tags == nil {
+		event.Tags = []string{}
+	}
+
+	var buf bytes.Buffer
+	if err := n.template.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("execute webhook template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("webhook template rendered invalid JSON: %s", buf.String())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// send posts the body once, returning the HTTP status code (0 if the request failed)
+func (n *webhookNotifier) send(body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range n.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("post to webhook: %w", err)
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+
+	if resp.StatusCode >= 300 {
+		var message []byte
+		if resp.Body != nil {
+			message, _ = io.ReadAll(io.LimitReader(resp.Body, 1024))
+		}
+		return resp.StatusCode, fmt.Errorf("call to %s failed with HTTP %d %q: %s", n.URL, resp.StatusCode, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return resp.StatusCode, nil
+}
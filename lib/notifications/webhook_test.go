@@ -0,0 +1,150 @@
+package notifications
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWebhookNotifierInvalidTemplate(t *testing.T) {
+	n, err := NewWebhookNotifier(WebhookConfig{Template: `{"text": {{.Text}`}, tagextractor.NewNoOpTagExtractor(), nil, log.New(io.Discard, "", 0))
+
+	assert.Nil(t, n)
+	assert.ErrorContains(t, err, "parse webhook template")
+}
+
+func TestWebhookNotifierPost(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	testCases := map[string]struct {
+		template     string
+		annotation   string
+		expectedBody string
+		expectErr    bool
+	}{
+		"default template": {
+			annotation:   `[Disk] Disk "1" is "hot"`,
+			expectedBody: `{"text":"Disk \"1\" is \"hot\"","tags":["Disk"],"time":"2020-01-01T12:00:00Z","hostname":"` + hostname + `","end":false}`,
+		},
+		"default template without tags": {
+			annotation:   "Plain notification",
+			expectedBody: `{"text":"Plain notification","tags":[],"time":"2020-01-01T12:00:00Z","hostname":"` + hostname + `","end":false}`,
+		},
+		"custom template": {
+			template:     `{"title":{{json .Hostname}},"message":{{json .Text}},"priority":5,"tags":{{json (join .Tags ",")}}}`,
+			annotation:   "[UPS] [Power] On battery",
+			expectedBody: `{"title":"` + hostname + `","message":"On battery","priority":5,"tags":"UPS,Power"}`,
+		},
+		"template rendering invalid JSON": {
+			template:   `{"message":"{{.Text}}"}`,
+			annotation: `Quote " in text`,
+			expectErr:  true,
+		},
+		"template with unknown field": {
+			template:   `{"message":{{json .Unknown}}}`,
+			annotation: "text",
+			expectErr:  true,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			var body string
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				assert.Equal(t, "POST", r.Method)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+				b, _ := io.ReadAll(r.Body)
+				body = string(b)
+			}))
+			defer server.Close()
+
+			n, err := NewWebhookNotifier(
+				WebhookConfig{URL: server.URL, Template: tc.template, Headers: map[string]string{"Authorization": "Bearer secret"}},
+				tagextractor.NewNotificationCenterTagExtractor(),
+				nil,
+				log.New(io.Discard, "", 0),
+			)
+			require.NoError(t, err)
+
+			id, err := n.Post(tc.annotation, time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+			if tc.expectErr {
+				assert.Error(t, err)
+				assert.Equal(t, -1, id)
+				assert.Zero(t, requests)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Zero(t, id)
+			assert.Equal(t, 1, requests)
+			assert.JSONEq(t, tc.expectedBody, body)
+		})
+	}
+}
+
+func TestWebhookNotifierRetries(t *testing.T) {
+	testCases := map[string]struct {
+		retries          int
+		statusCodes      []int
+		expectedRequests int
+		expectErr        bool
+	}{
+		"retries server errors": {
+			retries:          2,
+			statusCodes:      []int{503, 200},
+			expectedRequests: 2,
+		},
+		"gives up after retries": {
+			retries:          1,
+			statusCodes:      []int{503, 503},
+			expectedRequests: 2,
+			expectErr:        true,
+		},
+		"does not retry client errors": {
+			retries:          2,
+			statusCodes:      []int{404},
+			expectedRequests: 1,
+			expectErr:        true,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statusCodes[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			n, err := NewWebhookNotifier(
+				WebhookConfig{URL: server.URL, Retries: tc.retries},
+				tagextractor.NewNoOpTagExtractor(),
+				nil,
+				log.New(io.Discard, "", 0),
+			)
+			require.NoError(t, err)
+			n.(*webhookNotifier).sleep = func(time.Duration) {}
+
+			_, err = n.Post("test notification", time.Now())
+
+			assert.Equal(t, tc.expectedRequests, requests)
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+// DefaultGraphiteTemplate is the template of the paths of the samples when none is configured, e.g.
+// qnapexporter.nas1.node_network_receive_bytes_total.eth0
+const DefaultGraphiteTemplate = "qnapexporter.{{.Hostname}}.{{.Name}}{{range .LabelValues}}.{{.}}{{end}}"
+
+// graphiteUnsafeRe matches the characters which would split or break a path component
+var graphiteUnsafeRe = regexp.MustCompile(`[^A-Za-z0-9_:-]`)
+
+// GraphiteConfig holds the settings used to push samples to Carbon with the plaintext protocol
+type GraphiteConfig struct {
+	// Address is the host:port of the Carbon plaintext listener (e.g. carbon:2003)
+	Address string
+	// Template is the Go template of the path of each sample (DefaultGraphiteTemplate, if empty)
+	Template string
+}
+
+// graphitePathData holds the fields available to the path template, whose values are sanitized so that they
+// form a single path component
+type graphitePathData struct {
+	Hostname string
+	Name     string
+	Labels   map[string]string
+	// LabelValues holds the values of the labels, sorted by label name
+	LabelValues []string
+}
+
+// GraphiteSender pushes samples to Carbon over a TCP connection, which is opened again after it breaks
+type GraphiteSender struct {
+	GraphiteConfig
+
+	template *template.Template
+	conn     net.Conn
+}
+
+// NewGraphiteSender creates a GraphiteSender, validating the address and the template
+func NewGraphiteSender(config GraphiteConfig) (*GraphiteSender, error) {
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		return nil, fmt.Errorf("invalid Graphite address: %w", err)
+	}
+	if config.Template == "" {
+		config.Template = DefaultGraphiteTemplate
+	}
+	tmpl, err := template.New("").Option("missingkey=zero").Parse(config.Template)
+	if err != nil {
+		return nil, fmt.Errorf("parse Graphite template %q: %w", config.Template, err)
+	}
+
+	return &GraphiteSender{GraphiteConfig: config, template: tmpl}, nil
+}
+
+func (s *GraphiteSender) Name() string {
+	return "graphite"
+}
+
+func (s *GraphiteSender) Send(ctx context.Context, batch Batch) (bool, error) {
+	var body bytes.Buffer
+	if err := s.writeLines(&body, batch); err != nil {
+		return false, err
+	}
+
+	if s.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", s.Address)
+		if err != nil {
+			return true, fmt.Errorf("connect to Graphite: %w", err)
+		}
+		s.conn = conn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.conn.SetWriteDeadline(deadline)
+	}
+	if _, err := s.conn.Write(body.Bytes()); err != nil {
+		// Reconnect on the next attempt, since the lines may have been partially written
+		_ = s.Close()
+		return true, fmt.Errorf("push to Graphite: %w", err)
+	}
+
+	return false, nil
+}
+
+// Close closes the connection to Carbon, if any
+func (s *GraphiteSender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
+
+// writeLines writes the samples as plaintext protocol lines, skipping the NaN and infinite values
+func (s *GraphiteSender) writeLines(w *bytes.Buffer, batch Batch) error {
+	for _, sample := range batch.Samples {
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+		path, err := s.path(sample, batch.Hostname)
+		if err != nil {
+			return err
+		}
+		t := sample.Timestamp
+		if t.IsZero() {
+			t = batch.Time
+		}
+
+		_, _ = fmt.Fprintf(w, "%s %s %d\n", path, strconv.FormatFloat(sample.Value, 'g', -1, 64), t.Unix())
+	}
+
+	return nil
+}
+
+// path expands the template for sample
+func (s *GraphiteSender) path(sample exporter.Sample, hostname string) (string, error) {
+	data := graphitePathData{
+		Hostname: sanitizeGraphiteComponent(hostname),
+		Name:     sanitizeGraphiteComponent(sample.Name),
+		Labels:   make(map[string]string, len(sample.Labels)),
+	}
+	keys := make([]string, 0, len(sample.Labels))
+	for key, value := range sample.Labels {
+		keys = append(keys, key)
+		data.Labels[key] = sanitizeGraphiteComponent(value)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		data.LabelValues = append(data.LabelValues, data.Labels[key])
+	}
+
+	var sb strings.Builder
+	if err := s.template.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("expand Graphite template: %w", err)
+	}
+
+	return sb.String(), nil
+}
+
+// sanitizeGraphiteComponent replaces the dots, spaces and other unsafe characters of s with underscores, so that it
+// is a single path component
+func sanitizeGraphiteComponent(s string) string {
+	if s == "" {
+		return "_"
+	}
+
+	return graphiteUnsafeRe.ReplaceAllString(s, "_")
+}
@@ -0,0 +1,131 @@
+package push
+
+import (
+	"bufio"
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphitePath(t *testing.T) {
+	testCases := map[string]struct {
+		template string
+		sample   exporter.Sample
+		hostname string
+		want     string
+	}{
+		"default template": {
+			sample:   exporter.Sample{Name: "node_network_receive_bytes_total", Labels: map[string]string{"device": "eth0"}},
+			hostname: "nas1",
+			want:     "qnapexporter.nas1.node_network_receive_bytes_total.eth0",
+		},
+		"label values sorted by name": {
+			sample:   exporter.Sample{Name: "node_hwmon_temp_celsius", Labels: map[string]string{"sensor": "cpu", "chip": "coretemp"}},
+			hostname: "nas1",
+			want:     "qnapexporter.nas1.node_hwmon_temp_celsius.coretemp.cpu",
+		},
+		"sanitizes the components": {
+			sample:   exporter.Sample{Name: "node_volume_free_bytes", Labels: map[string]string{"volume": "Data Vol.1", "status": ""}},
+			hostname: "nas1.local",
+			want:     "qnapexporter.nas1_local.node_volume_free_bytes._.Data_Vol_1",
+		},
+		"custom template": {
+			template: "servers.{{.Hostname}}.{{.Labels.device}}.{{.Name}}",
+			sample:   exporter.Sample{Name: "node_disk_io_now", Labels: map[string]string{"device": "sda"}},
+			hostname: "nas1",
+			want:     "servers.nas1.sda.node_disk_io_now",
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			s, err := NewGraphiteSender(GraphiteConfig{Address: "carbon:2003", Template: tc.template})
+			require.NoError(t, err)
+
+			path, err := s.path(tc.sample, tc.hostname)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, path)
+		})
+	}
+}
+
+func TestNewGraphiteSender(t *testing.T) {
+	_, err := NewGraphiteSender(GraphiteConfig{Address: "carbon"})
+	assert.Error(t, err)
+	_, err = NewGraphiteSender(GraphiteConfig{Address: "carbon:2003", Template: "{{.Name"})
+	assert.Error(t, err)
+}
+
+// serveCarbon accepts connections on a local listener, sending the lines received to the returned channel
+func serveCarbon(t *testing.T) (net.Listener, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	lines := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+
+	return l, lines
+}
+
+func TestGraphiteSenderSend(t *testing.T) {
+	l, lines := serveCarbon(t)
+	defer l.Close()
+	s, err := NewGraphiteSender(GraphiteConfig{Address: l.Addr().String()})
+	require.NoError(t, err)
+	defer s.Close()
+
+	batch := Batch{
+		Hostname: "nas1",
+		Time:     time.Unix(1700000000, 0),
+		Samples: []exporter.Sample{
+			{Name: "node_load1", Value: 0.5},
+			{Name: "node_hwmon_temp_celsius", Value: math.NaN()},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = s.Send(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, "qnapexporter.nas1.node_load1 0.5 1700000000", <-lines)
+
+	// A broken connection is opened again on the next attempt
+	require.NoError(t, s.conn.Close())
+	_, err = s.Send(ctx, batch)
+	assert.Error(t, err)
+	assert.Nil(t, s.conn)
+	_, err = s.Send(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, "qnapexporter.nas1.node_load1 0.5 1700000000", <-lines)
+}
+
+func TestGraphiteSenderUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	s, err := NewGraphiteSender(GraphiteConfig{Address: address})
+	require.NoError(t, err)
+	retryable, err := s.Send(context.Background(), Batch{Samples: []exporter.Sample{{Name: "node_load1"}}})
+	assert.Error(t, err)
+	assert.True(t, retryable)
+}
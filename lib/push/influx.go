@@ -0,0 +1,167 @@
+package push
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+const (
+	influxWritePath = "/api/v2/write"
+	// influxHostTag is the tag holding the hostname, as named by Telegraf
+	influxHostTag = "host"
+)
+
+var (
+	influxMeasurementReplacer = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\ `)
+	influxTagReplacer         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `)
+)
+
+// InfluxConfig holds the settings used to push samples to the InfluxDB v2 write API
+type InfluxConfig struct {
+	// URL is the base URL of InfluxDB (e.g. http://influxdb:8086)
+	URL string
+	// Org and Bucket are the organization and bucket the samples are written to
+	Org    string
+	Bucket string
+	// Token is the API token, if InfluxDB requires authentication
+	Token string
+	// HTTPClient sends the requests, e.g. one shared with the other integrations (a new client, if nil)
+	HTTPClient *http.Client
+}
+
+// InfluxSender pushes samples as gzipped InfluxDB line protocol
+type InfluxSender struct {
+	InfluxConfig
+
+	client   *http.Client
+	writeURL string
+}
+
+// NewInfluxSender creates an InfluxSender, validating the URL
+func NewInfluxSender(config InfluxConfig) (*InfluxSender, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse InfluxDB URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("InfluxDB URL %q isn't an HTTP URL", config.URL)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("no InfluxDB bucket configured")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + influxWritePath
+	u.RawQuery = url.Values{"org": {config.Org}, "bucket": {config.Bucket}, "precision": {"ns"}}.Encode()
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	return &InfluxSender{InfluxConfig: config, client: client, writeURL: u.String()}, nil
+}
+
+func (s *InfluxSender) Name() string {
+	return "influxdb"
+}
+
+func (s *InfluxSender) Send(ctx context.Context, batch Batch) (bool, error) {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if err := WriteInfluxLines(zw, batch); err != nil {
+		return false, err
+	}
+	if err := zw.Close(); err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.writeURL, &body)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.Token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("push to InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retryable, fmt.Errorf("call to %s failed with HTTP %d %q: %s", s.URL, resp.StatusCode, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return false, nil
+}
+
+// WriteInfluxLines writes the samples of batch as InfluxDB line protocol: the measurement is the metric name,
+// the labels and the hostname are tags, and the sample is the value field. NaN and infinite values, which the line
+// protocol can't represent, are skipped, as are the empty tags.
+func WriteInfluxLines(w io.Writer, batch Batch) error {
+	bw := bufio.NewWriter(w)
+	for _, sample := range batch.Samples {
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+		t := sample.Timestamp
+		if t.IsZero() {
+			t = batch.Time
+		}
+		_, _ = bw.WriteString(FormatInfluxLine(sample, batch.Hostname, t.UnixNano()))
+		_ = bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
+
+// FormatInfluxLine formats a sample as a line of InfluxDB line protocol, without the trailing newline. A host label
+// takes precedence over the hostname.
+func FormatInfluxLine(sample exporter.Sample, hostname string, timestamp int64) string {
+	tags := make(map[string]string, len(sample.Labels)+1)
+	if hostname != "" {
+		tags[influxHostTag] = hostname
+	}
+	for key, value := range sample.Labels {
+		tags[key] = value
+	}
+	keys := make([]string, 0, len(tags))
+	for key, value := range tags {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	// InfluxDB recommends sorting the tags by key
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(influxMeasurementReplacer.Replace(sample.Name))
+	for _, key := range keys {
+		sb.WriteByte(',')
+		sb.WriteString(influxTagReplacer.Replace(key))
+		sb.WriteByte('=')
+		sb.WriteString(influxTagReplacer.Replace(tags[key]))
+	}
+	sb.WriteString(" value=")
+	sb.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatInt(timestamp, 10))
+
+	return sb.String()
+}
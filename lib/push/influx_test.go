@@ -0,0 +1,126 @@
+package push
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatInfluxLine(t *testing.T) {
+	testCases := map[string]struct {
+		sample   exporter.Sample
+		hostname string
+		want     string
+	}{
+		"without labels": {
+			sample:   exporter.Sample{Name: "node_load1", Value: 0.25},
+			hostname: "nas1",
+			want:     "node_load1,host=nas1 value=0.25 1700000000000000000",
+		},
+		"sorts the tags": {
+			sample:   exporter.Sample{Name: "node_hwmon_temp_celsius", Labels: map[string]string{"sensor": "cpu", "chip": "coretemp"}, Value: 45},
+			hostname: "nas1",
+			want:     "node_hwmon_temp_celsius,chip=coretemp,host=nas1,sensor=cpu value=45 1700000000000000000",
+		},
+		"escapes the tags": {
+			sample:   exporter.Sample{Name: "node_volume_free_bytes", Labels: map[string]string{"volume": "Data Vol,1=a"}, Value: 1e12},
+			hostname: "my nas",
+			want:     `node_volume_free_bytes,host=my\ nas,volume=Data\ Vol\,1\=a value=1e+12 1700000000000000000`,
+		},
+		"escapes the measurement": {
+			sample: exporter.Sample{Name: "a b,c=d", Value: 1},
+			want:   `a\ b\,c=d value=1 1700000000000000000`,
+		},
+		"skips empty tags": {
+			sample:   exporter.Sample{Name: "node_ups_status", Labels: map[string]string{"ups": "", "model": "SMT1500", "host": "ups1"}, Value: 1},
+			hostname: "nas1",
+			want:     "node_ups_status,host=ups1,model=SMT1500 value=1 1700000000000000000",
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tc.want, FormatInfluxLine(tc.sample, tc.hostname, 1700000000000000000))
+		})
+	}
+}
+
+func TestWriteInfluxLines(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	batch := Batch{
+		Time: now,
+		Samples: []exporter.Sample{
+			{Name: "node_load1", Value: 0.5},
+			{Name: "node_hwmon_temp_celsius", Value: math.NaN()},
+			{Name: "node_load5", Value: math.Inf(1)},
+			{Name: "node_boot_time_seconds", Value: 1, Timestamp: now.Add(-time.Second)},
+		},
+	}
+
+	var sb strings.Builder
+	require.NoError(t, WriteInfluxLines(&sb, batch))
+	assert.Equal(t, "node_load1 value=0.5 1700000000000000000\nnode_boot_time_seconds value=1 1699999999000000000\n", sb.String())
+}
+
+func TestNewInfluxSender(t *testing.T) {
+	s, err := NewInfluxSender(InfluxConfig{URL: "http://influxdb:8086/", Org: "home", Bucket: "nas"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://influxdb:8086/api/v2/write?bucket=nas&org=home&precision=ns", s.writeURL)
+
+	_, err = NewInfluxSender(InfluxConfig{URL: "http://influxdb:8086", Org: "home"})
+	assert.Error(t, err)
+	_, err = NewInfluxSender(InfluxConfig{URL: "influxdb:8086", Bucket: "nas"})
+	assert.Error(t, err)
+}
+
+func TestInfluxSenderSend(t *testing.T) {
+	testCases := map[string]struct {
+		status        int
+		wantErr       bool
+		wantRetryable bool
+	}{
+		"succeeds":    {status: http.StatusNoContent},
+		"unavailable": {status: http.StatusServiceUnavailable, wantErr: true, wantRetryable: true},
+		"bad request": {status: http.StatusBadRequest, wantErr: true},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/api/v2/write", r.URL.Path)
+				assert.Equal(t, "nas", r.URL.Query().Get("bucket"))
+				assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+				assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+				zr, err := gzip.NewReader(r.Body)
+				if assert.NoError(t, err) {
+					body, _ = io.ReadAll(zr)
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+			s, err := NewInfluxSender(InfluxConfig{URL: server.URL, Org: "home", Bucket: "nas", Token: "secret"})
+			require.NoError(t, err)
+
+			batch := Batch{Hostname: "nas1", Time: time.Unix(1700000000, 0), Samples: []exporter.Sample{{Name: "node_load1", Value: 0.5}}}
+			retryable, err := s.Send(context.Background(), batch)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantRetryable, retryable)
+			assert.Equal(t, "node_load1,host=nas1 value=0.5 1700000000000000000\n", string(body))
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+const (
+	otlpMetricsPath = "/v1/metrics"
+	otlpServiceName = "qnapexporter"
+	// otlpCumulative is the AGGREGATION_TEMPORALITY_CUMULATIVE of the counters
+	otlpCumulative = 2
+)
+
+// OTLPConfig holds the settings used to push samples to an OpenTelemetry collector over OTLP/HTTP
+type OTLPConfig struct {
+	// Endpoint is the URL of the collector (e.g. http://collector:4318), to which /v1/metrics is appended
+	// unless it has a path
+	Endpoint string
+	// Headers are sent with each request, e.g. for authentication
+	Headers map[string]string
+	// HTTPClient sends the requests, e.g. one shared with the other integrations (a new client, if nil)
+	HTTPClient *http.Client
+}
+
+// OTLPSender pushes samples as OTLP metrics, encoded as JSON. Counters become monotonic cumulative sums,
+// and the other samples gauges, with the resource attributes host.name and service.name.
+type OTLPSender struct {
+	OTLPConfig
+
+	client *http.Client
+}
+
+// NewOTLPSender creates an OTLPSender, validating the endpoint
+func NewOTLPSender(config OTLPConfig) (*OTLPSender, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse OTLP endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("OTLP endpoint %q isn't an HTTP URL", config.Endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpMetricsPath
+	}
+	config.Endpoint = u.String()
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	return &OTLPSender{OTLPConfig: config, client: client}, nil
+}
+
+func (s *OTLPSender) Name() string {
+	return "otlp"
+}
+
+func (s *OTLPSender) Send(ctx context.Context, batch Batch) (bool, error) {
+	body, err := json.Marshal(otlpRequest(batch))
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("push to OTLP collector: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		// The collector asks to retry with these statuses, as per the OTLP specification
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusBadGateway ||
+			resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
+		return retryable, fmt.Errorf("call to %s failed with HTTP %d %q: %s", s.Endpoint, resp.StatusCode, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return false, nil
+}
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+// otlpDouble encodes the special float values as strings, as per the JSON mapping of protobuf
+type otlpDouble float64
+
+func (d otlpDouble) MarshalJSON() ([]byte, error) {
+	v := float64(d)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Infinity"`), nil
+	}
+
+	return []byte(strconv.FormatFloat(v, 'g', -1, 64)), nil
+}
+
+type otlpDataPoint struct {
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+	// TimeUnixNano is a string, as per the JSON mapping of protobuf 64-bit integers
+	TimeUnixNano string     `json:"timeUnixNano"`
+	AsDouble     otlpDouble `json:"asDouble"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Gauge       *otlpGauge `json:"gauge,omitempty"`
+	Sum         *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	} `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+func otlpAttribute(key, value string) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	kv.Value.StringValue = value
+	return kv
+}
+
+// otlpRequest groups the samples of the batch by metric name, in the order they were collected
+func otlpRequest(batch Batch) otlpMetricsRequest {
+	var metrics []otlpMetric
+	indexes := map[string]int{}
+	for _, sample := range batch.Samples {
+		idx, ok := indexes[sample.Name]
+		if !ok {
+			m := otlpMetric{Name: sample.Name, Description: sample.Help}
+			if sample.Type == "counter" {
+				m.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			} else {
+				m.Gauge = &otlpGauge{}
+			}
+			idx = len(metrics)
+			indexes[sample.Name] = idx
+			metrics = append(metrics, m)
+		}
+
+		t := sample.Timestamp
+		if t.IsZero() {
+			t = batch.Time
+		}
+		point := otlpDataPoint{TimeUnixNano: strconv.FormatInt(t.UnixNano(), 10), AsDouble: otlpDouble(sample.Value)}
+		keys := make([]string, 0, len(sample.Labels))
+		for key := range sample.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			point.Attributes = append(point.Attributes, otlpAttribute(key, sample.Labels[key]))
+		}
+
+		if m := metrics[idx]; m.Sum != nil {
+			m.Sum.DataPoints = append(m.Sum.DataPoints, point)
+		} else {
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, point)
+		}
+	}
+
+	var scope otlpScopeMetrics
+	scope.Scope.Name = otlpServiceName
+	scope.Scope.Version = utils.VERSION
+	scope.Metrics = metrics
+
+	var resource otlpResourceMetrics
+	resource.Resource.Attributes = []otlpKeyValue{otlpAttribute("service.name", otlpServiceName)}
+	if batch.Hostname != "" {
+		resource.Resource.Attributes = append(resource.Resource.Attributes, otlpAttribute("host.name", batch.Hostname))
+	}
+	resource.ScopeMetrics = []otlpScopeMetrics{scope}
+
+	return otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{resource}}
+}
@@ -0,0 +1,144 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOTLPSender(t *testing.T) {
+	testCases := map[string]struct {
+		endpoint     string
+		wantEndpoint string
+		wantErr      bool
+	}{
+		"without a path": {endpoint: "http://collector:4318", wantEndpoint: "http://collector:4318/v1/metrics"},
+		"with a slash":   {endpoint: "https://collector:4318/", wantEndpoint: "https://collector:4318/v1/metrics"},
+		"with a path":    {endpoint: "http://collector/otlp/v1/metrics", wantEndpoint: "http://collector/otlp/v1/metrics"},
+		"not HTTP":       {endpoint: "collector:4318", wantErr: true},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			s, err := NewOTLPSender(OTLPConfig{Endpoint: tc.endpoint})
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantEndpoint, s.Endpoint)
+		})
+	}
+}
+
+func TestOTLPSenderSend(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/metrics", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	s, err := NewOTLPSender(OTLPConfig{Endpoint: server.URL, Headers: map[string]string{"Authorization": "Bearer secret"}})
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	batch := Batch{
+		Hostname: "nas1",
+		Time:     now,
+		Samples: []exporter.Sample{
+			{Name: "node_network_receive_bytes_total", Labels: map[string]string{"device": "eth0"}, Value: 10, Type: "counter", Help: "Received bytes"},
+			{Name: "node_hwmon_temp_celsius", Labels: map[string]string{"sensor": "cpu", "chip": "coretemp"}, Value: math.NaN(), Type: "gauge"},
+			{Name: "node_network_receive_bytes_total", Labels: map[string]string{"device": "eth1"}, Value: 20, Type: "counter"},
+			{Name: "node_load1", Value: math.Inf(1), Timestamp: now.Add(-time.Second)},
+		},
+	}
+	retryable, err := s.Send(context.Background(), batch)
+	require.NoError(t, err)
+	assert.False(t, retryable)
+
+	assert.JSONEq(t, `{"resourceMetrics": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "qnapexporter"}},
+			{"key": "host.name", "value": {"stringValue": "nas1"}}
+		]},
+		"scopeMetrics": [{
+			"scope": {"name": "qnapexporter", "version": "dev"},
+			"metrics": [
+				{
+					"name": "node_network_receive_bytes_total",
+					"description": "Received bytes",
+					"sum": {
+						"aggregationTemporality": 2,
+						"isMonotonic": true,
+						"dataPoints": [
+							{"attributes": [{"key": "device", "value": {"stringValue": "eth0"}}], "timeUnixNano": "1700000000000000000", "asDouble": 10},
+							{"attributes": [{"key": "device", "value": {"stringValue": "eth1"}}], "timeUnixNano": "1700000000000000000", "asDouble": 20}
+						]
+					}
+				},
+				{
+					"name": "node_hwmon_temp_celsius",
+					"gauge": {"dataPoints": [
+						{
+							"attributes": [
+								{"key": "chip", "value": {"stringValue": "coretemp"}},
+								{"key": "sensor", "value": {"stringValue": "cpu"}}
+							],
+							"timeUnixNano": "1700000000000000000",
+							"asDouble": "NaN"
+						}
+					]}
+				},
+				{
+					"name": "node_load1",
+					"gauge": {"dataPoints": [{"timeUnixNano": "1699999999000000000", "asDouble": "Infinity"}]}
+				}
+			]
+		}]
+	}]}`, string(body))
+}
+
+func TestOTLPSenderSendFailure(t *testing.T) {
+	testCases := map[string]struct {
+		status        int
+		wantRetryable bool
+	}{
+		"unavailable":       {status: http.StatusServiceUnavailable, wantRetryable: true},
+		"too many requests": {status: http.StatusTooManyRequests, wantRetryable: true},
+		"bad request":       {status: http.StatusBadRequest},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+			s, err := NewOTLPSender(OTLPConfig{Endpoint: server.URL})
+			require.NoError(t, err)
+
+			retryable, err := s.Send(context.Background(), Batch{Samples: []exporter.Sample{{Name: "node_load1"}}})
+			assert.Error(t, err)
+			assert.Equal(t, tc.wantRetryable, retryable)
+		})
+	}
+}
+
+func TestOTLPDoubleMarshalJSON(t *testing.T) {
+	b, err := json.Marshal([]otlpDouble{1.5, otlpDouble(math.Inf(-1)), 0})
+	require.NoError(t, err)
+	assert.Equal(t, `[1.5,"-Infinity",0]`, string(b))
+}
@@ -0,0 +1,197 @@
+// Package push delivers the samples collected by an exporter to backends which ingest metrics
+// rather than scraping them, on a fixed interval
+package push
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+const (
+	// DefaultInterval is the time between two pushes when none is configured
+	DefaultInterval = 1 * time.Minute
+	// DefaultTimeout bounds each attempt to push a batch when no timeout is configured
+	DefaultTimeout = 10 * time.Second
+
+	retryBackoff    = 1 * time.Second
+	retryMaxBackoff = 30 * time.Second
+)
+
+// Sender delivers batches of samples to a backend
+type Sender interface {
+	// Name identifies the backend in the logs and metrics (e.g. "otlp")
+	Name() string
+	// Send delivers the batch once, returning whether the failure, if any, may be retried
+	Send(ctx context.Context, batch Batch) (retryable bool, err error)
+}
+
+// Batch holds the samples collected by a scrape
+type Batch struct {
+	Samples []exporter.Sample
+	// Hostname is the host the samples were collected on
+	Hostname string
+	// Time is the time of the scrape, used for the samples without a timestamp
+	Time time.Time
+}
+
+// Config holds the settings of a Pusher
+type Config struct {
+	// Interval is the time between two pushes (DefaultInterval, if zero)
+	Interval time.Duration
+	// Timeout bounds each attempt to push a batch (DefaultTimeout, if zero)
+	Timeout time.Duration
+	// Retries is the number of additional attempts to push a batch after a retryable failure
+	Retries int
+	// BufferSize is the number of samples of the batches which couldn't be pushed kept for the next push, after
+	// which the oldest batches are dropped (none, if zero)
+	BufferSize int
+}
+
+// Pusher scrapes an exporter on a fixed interval, pushing the samples to a backend. A batch which can't be
+// pushed after the retries is kept for the next push if it fits in the buffer, or dropped, so that a backend outage
+// doesn't delay the next pushes.
+type Pusher struct {
+	Config
+
+	source exporter.SampleCollector
+	sender Sender
+	logger *log.Logger
+	sleep  func(time.Duration)
+
+	// pending holds the batches which couldn't be pushed yet, oldest first
+	pending        []Batch
+	pendingSamples int
+
+	mu    sync.Mutex
+	stats exporter.PushStats
+}
+
+// NewPusher creates a Pusher scraping source and pushing the samples with sender
+func NewPusher(config Config, source exporter.SampleCollector, sender Sender, logger *log.Logger) *Pusher {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	return &Pusher{
+		Config: config,
+		source: source,
+		sender: sender,
+		logger: logger,
+		sleep:  time.Sleep,
+	}
+}
+
+// Name returns the name of the backend
+func (p *Pusher) Name() string {
+	return p.sender.Name()
+}
+
+// Stats returns the counters of the batches pushed
+func (p *Pusher) Stats() exporter.PushStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stats
+}
+
+// Run pushes the samples every interval until ctx is done, closing the sender if it is an io.Closer
+func (p *Pusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	if c, ok := p.sender.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Push(ctx)
+		}
+	}
+}
+
+// CollectBatch scrapes source once, returning the samples collected even if some collectors failed
+func CollectBatch(source exporter.SampleCollector) (Batch, error) {
+	batch := Batch{Time: time.Now()}
+	samples, err := source.CollectSamples()
+	batch.Samples = samples
+	if hp, ok := source.(exporter.HostnameProvider); ok {
+		batch.Hostname = hp.Hostname()
+	}
+
+	return batch, err
+}
+
+// Push scrapes the exporter once and pushes the samples, even if some collectors failed, after the batches
+// still pending
+func (p *Pusher) Push(ctx context.Context) {
+	batch, err := CollectBatch(p.source)
+	if err != nil {
+		p.logger.Printf("Error collecting the samples pushed to %s: %v\n", p.Name(), err)
+	}
+	if len(batch.Samples) > 0 {
+		p.pending = append(p.pending, batch)
+		p.pendingSamples += len(batch.Samples)
+	}
+
+	for len(p.pending) > 0 {
+		batch := p.pending[0]
+		if err := p.send(ctx, batch); err != nil {
+			p.logger.Printf("Error pushing %d samples to %s: %v\n", len(batch.Samples), p.Name(), err)
+			break
+		}
+
+		p.pending = p.pending[1:]
+		p.pendingSamples -= len(batch.Samples)
+		p.mu.Lock()
+		p.stats.Sent++
+		p.stats.SamplesSent += uint64(len(batch.Samples))
+		p.mu.Unlock()
+	}
+
+	p.dropOverflow()
+}
+
+// dropOverflow drops the oldest pending batches until the others fit in the buffer
+func (p *Pusher) dropOverflow() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.pending) > 0 && p.pendingSamples > p.BufferSize {
+		batch := p.pending[0]
+		p.pending = p.pending[1:]
+		p.pendingSamples -= len(batch.Samples)
+		p.stats.Dropped++
+		p.stats.SamplesDropped += uint64(len(batch.Samples))
+		p.logger.Printf("Dropping %d samples which couldn't be pushed to %s\n", len(batch.Samples), p.Name())
+	}
+}
+
+// send pushes the batch, retrying the retryable failures
+func (p *Pusher) send(ctx context.Context, batch Batch) error {
+	delay := retryBackoff
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+		retryable, err := p.sender.Send(attemptCtx, batch)
+		cancel()
+		if err == nil || !retryable || attempt > p.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		p.logger.Printf("Error pushing to %s, retrying: %v\n", p.Name(), err)
+		p.sleep(delay)
+		if delay *= 2; delay > retryMaxBackoff {
+			delay = retryMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	samples []exporter.Sample
+	err     error
+}
+
+func (s *fakeSource) CollectSamples() ([]exporter.Sample, error) {
+	return s.samples, s.err
+}
+
+func (s *fakeSource) Hostname() string {
+	return "nas1"
+}
+
+// fakeSender fails the first failures attempts
+type fakeSender struct {
+	failures  int
+	retryable bool
+	attempts  int
+	batches   []Batch
+}
+
+func (s *fakeSender) Name() string {
+	return "fake"
+}
+
+func (s *fakeSender) Send(_ context.Context, batch Batch) (bool, error) {
+	s.attempts++
+	if s.failures > 0 {
+		s.failures--
+		return s.retryable, errors.New("backend unavailable")
+	}
+	s.batches = append(s.batches, batch)
+	return false, nil
+}
+
+func newTestPusher(source *fakeSource, sender *fakeSender, retries int) *Pusher {
+	p := NewPusher(Config{Retries: retries}, source, sender, log.New(io.Discard, "", 0))
+	p.sleep = func(time.Duration) {}
+
+	return p
+}
+
+func TestPusherRetries(t *testing.T) {
+	testCases := map[string]struct {
+		failures     int
+		retryable    bool
+		wantAttempts int
+		wantStats    exporter.PushStats
+	}{
+		"succeeds at once":        {wantAttempts: 1, wantStats: exporter.PushStats{Sent: 1, SamplesSent: 1}},
+		"succeeds after retrying": {failures: 2, retryable: true, wantAttempts: 3, wantStats: exporter.PushStats{Sent: 1, SamplesSent: 1}},
+		"gives up after retries":  {failures: 3, retryable: true, wantAttempts: 3, wantStats: exporter.PushStats{Dropped: 1, SamplesDropped: 1}},
+		"doesn't retry":           {failures: 1, wantAttempts: 1, wantStats: exporter.PushStats{Dropped: 1, SamplesDropped: 1}},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			source := &fakeSource{samples: []exporter.Sample{{Name: "node_load1", Value: 0.5, Type: "gauge"}}}
+			sender := &fakeSender{failures: tc.failures, retryable: tc.retryable}
+			p := newTestPusher(source, sender, 2)
+
+			p.Push(context.Background())
+
+			assert.Equal(t, tc.wantAttempts, sender.attempts)
+			assert.Equal(t, tc.wantStats, p.Stats())
+			if tc.wantStats.Sent > 0 {
+				assert.Equal(t, "nas1", sender.batches[0].Hostname)
+				assert.Equal(t, source.samples, sender.batches[0].Samples)
+				assert.False(t, sender.batches[0].Time.IsZero())
+			}
+		})
+	}
+}
+
+func TestPusherPartialSamples(t *testing.T) {
+	source := &fakeSource{
+		samples: []exporter.Sample{{Name: "node_load1", Value: 0.5, Type: "gauge"}},
+		err:     errors.New("ups: connection refused"),
+	}
+	sender := &fakeSender{}
+	p := newTestPusher(source, sender, 0)
+
+	p.Push(context.Background())
+	assert.Len(t, sender.batches, 1)
+
+	// A scrape without samples isn't pushed
+	source.samples = nil
+	p.Push(context.Background())
+	assert.Len(t, sender.batches, 1)
+	assert.Equal(t, exporter.PushStats{Sent: 1, SamplesSent: 1}, p.Stats())
+}
+
+func TestPusherBuffer(t *testing.T) {
+	source := &fakeSource{}
+	sender := &fakeSender{failures: 3}
+	p := newTestPusher(source, sender, 0)
+	p.BufferSize = 5
+	push := func(value float64) {
+		source.samples = []exporter.Sample{{Name: "node_load1", Value: value}, {Name: "node_load5", Value: value}}
+		p.Push(context.Background())
+	}
+
+	// The first batches are kept while they fit in the buffer, the oldest is dropped on the third failure
+	push(0)
+	push(1)
+	push(2)
+	assert.Equal(t, exporter.PushStats{Dropped: 1, SamplesDropped: 2}, p.Stats())
+	assert.Len(t, p.pending, 2)
+
+	// The pending batches are pushed first, in order
+	push(3)
+	assert.Equal(t, exporter.PushStats{Sent: 3, Dropped: 1, SamplesSent: 6, SamplesDropped: 2}, p.Stats())
+	if assert.Len(t, sender.batches, 3) {
+		for i, batch := range sender.batches {
+			assert.Equal(t, float64(i+1), batch.Samples[0].Value)
+		}
+	}
+	assert.Empty(t, p.pending)
+	assert.Zero(t, p.pendingSamples)
+}
@@ -0,0 +1,197 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+// DefaultStatsdMTU is the maximum size of a packet when none is configured, which fits in a single Ethernet frame
+// with the IP and UDP headers
+const DefaultStatsdMTU = 1432
+
+// statsdUnsafeReplacer replaces the characters which would split a line, a tag or the name from the value
+var statsdUnsafeReplacer = strings.NewReplacer(",", "_", ":", "_", "|", "_", "@", "_", "#", "_", "\n", "_")
+
+// StatsdConfig holds the settings used to push samples to a statsd server with the DogStatsD tag format
+type StatsdConfig struct {
+	// Address is the host:port of the statsd server (e.g. telegraf:8125)
+	Address string
+	// Network is either "udp" (if empty) or "tcp"
+	Network string
+	// MTU is the maximum size of the packets, into which the lines are batched (DefaultStatsdMTU, if zero)
+	MTU int
+	// CounterDeltas sends the counters as statsd counters of the increase since the previous push, instead of
+	// gauges of their current value
+	CounterDeltas bool
+}
+
+// StatsdSender pushes samples as statsd lines, e.g. node_disk_io_now:3|g|#node:nas1,device:sda. Over TCP, the
+// connection is opened again after it breaks.
+type StatsdSender struct {
+	StatsdConfig
+
+	conn net.Conn
+	// counters holds the value of the counters last pushed, by line prefix, when sending deltas
+	counters map[string]float64
+}
+
+// NewStatsdSender creates a StatsdSender, validating the address and the network
+func NewStatsdSender(config StatsdConfig) (*StatsdSender, error) {
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		return nil, fmt.Errorf("invalid statsd address: %w", err)
+	}
+	switch config.Network {
+	case "":
+		config.Network = "udp"
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("invalid statsd network %q, expected udp or tcp", config.Network)
+	}
+	if config.MTU <= 0 {
+		config.MTU = DefaultStatsdMTU
+	}
+
+	return &StatsdSender{StatsdConfig: config}, nil
+}
+
+func (s *StatsdSender) Name() string {
+	return "statsd"
+}
+
+func (s *StatsdSender) Send(ctx context.Context, batch Batch) (bool, error) {
+	lines, counters := s.lines(batch)
+
+	if s.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, s.Network, s.Address)
+		if err != nil {
+			return true, fmt.Errorf("connect to statsd: %w", err)
+		}
+		s.conn = conn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.conn.SetWriteDeadline(deadline)
+	}
+	for _, packet := range s.packets(lines) {
+		if _, err := s.conn.Write(packet); err != nil {
+			_ = s.Close()
+			return true, fmt.Errorf("push to statsd: %w", err)
+		}
+	}
+	if s.CounterDeltas {
+		s.counters = counters
+	}
+
+	return false, nil
+}
+
+// Close closes the connection to the statsd server, if any
+func (s *StatsdSender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
+
+// lines formats the samples as statsd lines, skipping the NaN and infinite values. With CounterDeltas, it also
+// returns the current values of the counters, whose first sample is skipped since it has no delta yet.
+func (s *StatsdSender) lines(batch Batch) ([]string, map[string]float64) {
+	var counters map[string]float64
+	if s.CounterDeltas {
+		counters = make(map[string]float64)
+	}
+
+	lines := make([]string, 0, len(batch.Samples))
+	for _, sample := range batch.Samples {
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+		name := sanitizeStatsd(sample.Name)
+		tags := statsdTags(sample, batch.Hostname)
+		value, kind := sample.Value, "g"
+		if s.CounterDeltas && sample.Type == "counter" {
+			key := name + tags
+			counters[key] = sample.Value
+			previous, ok := s.counters[key]
+			if !ok {
+				continue
+			}
+			if value >= previous {
+				// Otherwise the counter was reset, and increased by its whole value since
+				value -= previous
+			}
+			kind = "c"
+		}
+
+		lines = append(lines, name+":"+strconv.FormatFloat(value, 'f', -1, 64)+"|"+kind+tags)
+	}
+
+	return lines, counters
+}
+
+// packets joins the lines with newlines into packets of at most MTU bytes. A line longer than MTU is sent in a
+// packet of its own.
+func (s *StatsdSender) packets(lines []string) [][]byte {
+	var packets [][]byte
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > s.MTU {
+			packets = append(packets, packet)
+			packet = nil
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		packets = append(packets, packet)
+	}
+	if s.Network == "tcp" {
+		// The stream needs each packet to end with a newline, so that the last line isn't joined with the next one
+		for i := range packets {
+			packets[i] = append(packets[i], '\n')
+		}
+	}
+
+	return packets
+}
+
+// statsdTags formats the node tag followed by the labels sorted by name, e.g. |#node:nas1,device:sda
+func statsdTags(sample exporter.Sample, hostname string) string {
+	names := make([]string, 0, len(sample.Labels))
+	for name := range sample.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("|#node:")
+	sb.WriteString(sanitizeStatsd(hostname))
+	for _, name := range names {
+		sb.WriteString(",")
+		sb.WriteString(sanitizeStatsd(name))
+		sb.WriteString(":")
+		sb.WriteString(sanitizeStatsd(sample.Labels[name]))
+	}
+
+	return sb.String()
+}
+
+// sanitizeStatsd replaces the commas, colons and other characters of s which have a meaning in a statsd line with
+// underscores, since the format has no escaping
+func sanitizeStatsd(s string) string {
+	return statsdUnsafeReplacer.Replace(s)
+}
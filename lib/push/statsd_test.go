@@ -0,0 +1,115 @@
+package push
+
+import (
+	"context"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsdLines(t *testing.T) {
+	s, err := NewStatsdSender(StatsdConfig{Address: "telegraf:8125"})
+	require.NoError(t, err)
+
+	lines, _ := s.lines(Batch{
+		Hostname: "nas1",
+		Samples: []exporter.Sample{
+			{Name: "node_disk_io_now", Labels: map[string]string{"device": "sda"}, Value: 3, Type: "gauge"},
+			{Name: "node_network_receive_bytes_total", Labels: map[string]string{"device": "eth0"}, Value: 1024, Type: "counter"},
+			{Name: "node_volume_free_bytes", Labels: map[string]string{"volume": "Data:1,2|x", "status": "ready"}, Value: 1.5},
+			{Name: "node_hwmon_temp_celsius", Value: math.NaN()},
+		},
+	})
+	assert.Equal(t, []string{
+		"node_disk_io_now:3|g|#node:nas1,device:sda",
+		"node_network_receive_bytes_total:1024|g|#node:nas1,device:eth0",
+		"node_volume_free_bytes:1.5|g|#node:nas1,status:ready,volume:Data_1_2_x",
+	}, lines)
+}
+
+func TestStatsdCounterDeltas(t *testing.T) {
+	s, err := NewStatsdSender(StatsdConfig{Address: "telegraf:8125", CounterDeltas: true})
+	require.NoError(t, err)
+
+	batch := func(value float64) Batch {
+		return Batch{Hostname: "nas1", Samples: []exporter.Sample{
+			{Name: "node_load1", Value: 0.5, Type: "gauge"},
+			{Name: "node_network_receive_bytes_total", Labels: map[string]string{"device": "eth0"}, Value: value, Type: "counter"},
+		}}
+	}
+
+	// The first value of a counter has no delta
+	lines, counters := s.lines(batch(100))
+	assert.Equal(t, []string{"node_load1:0.5|g|#node:nas1"}, lines)
+	s.counters = counters
+
+	lines, counters = s.lines(batch(150))
+	assert.Equal(t, []string{"node_load1:0.5|g|#node:nas1", "node_network_receive_bytes_total:50|c|#node:nas1,device:eth0"}, lines)
+	s.counters = counters
+
+	// A reset counter increased by its whole value
+	lines, _ = s.lines(batch(20))
+	assert.Equal(t, "node_network_receive_bytes_total:20|c|#node:nas1,device:eth0", lines[1])
+}
+
+func TestStatsdPackets(t *testing.T) {
+	s, err := NewStatsdSender(StatsdConfig{Address: "telegraf:8125", MTU: 12})
+	require.NoError(t, err)
+
+	packets := s.packets([]string{"a:1|g", "b:2|g", "c:3|g", "long_name:4|g"})
+	require.Len(t, packets, 3)
+	assert.Equal(t, "a:1|g\nb:2|g", string(packets[0]))
+	assert.Equal(t, "c:3|g", string(packets[1]))
+	assert.Equal(t, "long_name:4|g", string(packets[2]))
+
+	s.Network = "tcp"
+	packets = s.packets([]string{"a:1|g", "b:2|g"})
+	require.Len(t, packets, 1)
+	assert.Equal(t, "a:1|g\nb:2|g\n", string(packets[0]))
+}
+
+func TestNewStatsdSender(t *testing.T) {
+	_, err := NewStatsdSender(StatsdConfig{Address: "telegraf"})
+	assert.Error(t, err)
+	_, err = NewStatsdSender(StatsdConfig{Address: "telegraf:8125", Network: "unix"})
+	assert.Error(t, err)
+}
+
+func TestStatsdSenderSend(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	s, err := NewStatsdSender(StatsdConfig{Address: pc.LocalAddr().String()})
+	require.NoError(t, err)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = s.Send(ctx, Batch{Hostname: "nas1", Samples: []exporter.Sample{{Name: "node_load1", Value: 0.5}, {Name: "node_load5", Value: 1}}})
+	require.NoError(t, err)
+
+	buf := make([]byte, DefaultStatsdMTU)
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node_load1:0.5|g|#node:nas1", "node_load5:1|g|#node:nas1"}, strings.Split(string(buf[:n]), "\n"))
+}
+
+func TestStatsdSenderUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	s, err := NewStatsdSender(StatsdConfig{Address: address, Network: "tcp"})
+	require.NoError(t, err)
+	retryable, err := s.Send(context.Background(), Batch{Samples: []exporter.Sample{{Name: "node_load1"}}})
+	assert.Error(t, err)
+	assert.True(t, retryable)
+}
@@ -0,0 +1,249 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+)
+
+const (
+	// DefaultZabbixKeyPrefix is prepended to the item keys when no prefix is configured
+	DefaultZabbixKeyPrefix = "qnap."
+	// DefaultZabbixMaxItems is the number of items sent in each request when no limit is configured, the same as
+	// zabbix_sender
+	DefaultZabbixMaxItems = 250
+
+	// zabbixHeader starts every message of the Zabbix protocol, followed by the flags (no compression)
+	zabbixHeader = "ZBXD\x01"
+	// zabbixMaxResponseSize bounds the response read from the server, which is a short JSON object
+	zabbixMaxResponseSize = 64 * 1024
+)
+
+// zabbixUnsafeRe matches the characters which aren't allowed in the name of an item key
+var zabbixUnsafeRe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// ZabbixConfig holds the settings used to push samples to a Zabbix server or proxy as trapper items
+type ZabbixConfig struct {
+	// Address is the host:port of the Zabbix server or proxy trapper (e.g. zabbix:10051)
+	Address string
+	// Host is the name of the host the items belong to in Zabbix (the hostname of the batch, if empty)
+	Host string
+	// KeyPrefix is prepended to the item keys (DefaultZabbixKeyPrefix, if empty)
+	KeyPrefix string
+	// MaxItems is the maximum number of items sent in a single request (DefaultZabbixMaxItems, if zero)
+	MaxItems int
+}
+
+// ZabbixSender pushes samples to a Zabbix server with the sender protocol, opening a connection for each request
+// like zabbix_sender does
+type ZabbixSender struct {
+	ZabbixConfig
+
+	// resumeTime and resumeItems track the items of a batch already accepted by the server, so that a retry of
+	// that batch after a failure in the middle of it doesn't send them twice
+	resumeTime  time.Time
+	resumeItems int
+}
+
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+	NS    int    `json:"ns"`
+}
+
+type zabbixRequest struct {
+	Request string       `json:"request"`
+	Data    []zabbixItem `json:"data"`
+	Clock   int64        `json:"clock"`
+	NS      int          `json:"ns"`
+}
+
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+// NewZabbixSender creates a ZabbixSender, validating the address
+func NewZabbixSender(config ZabbixConfig) (*ZabbixSender, error) {
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		return nil, fmt.Errorf("invalid Zabbix address: %w", err)
+	}
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = DefaultZabbixKeyPrefix
+	}
+	if config.MaxItems <= 0 {
+		config.MaxItems = DefaultZabbixMaxItems
+	}
+
+	return &ZabbixSender{ZabbixConfig: config}, nil
+}
+
+func (s *ZabbixSender) Name() string {
+	return "zabbix"
+}
+
+// Send pushes the batch in requests of at most MaxItems items. The requests already accepted are skipped when the
+// same batch is sent again after a failure.
+func (s *ZabbixSender) Send(ctx context.Context, batch Batch) (bool, error) {
+	items := s.items(batch)
+	if !s.resumeTime.Equal(batch.Time) {
+		s.resumeTime, s.resumeItems = batch.Time, 0
+	}
+
+	for s.resumeItems < len(items) {
+		end := s.resumeItems + s.MaxItems
+		if end > len(items) {
+			end = len(items)
+		}
+		retryable, err := s.sendItems(ctx, items[s.resumeItems:end])
+		if err != nil {
+			return retryable, err
+		}
+		s.resumeItems = end
+	}
+
+	return false, nil
+}
+
+// items converts the samples to Zabbix items, skipping the NaN and infinite values
+func (s *ZabbixSender) items(batch Batch) []zabbixItem {
+	host := s.Host
+	if host == "" {
+		host = batch.Hostname
+	}
+
+	items := make([]zabbixItem, 0, len(batch.Samples))
+	for _, sample := range batch.Samples {
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+		t := sample.Timestamp
+		if t.IsZero() {
+			t = batch.Time
+		}
+
+		items = append(items, zabbixItem{
+			Host:  host,
+			Key:   s.key(sample),
+			Value: strconv.FormatFloat(sample.Value, 'f', -1, 64),
+			Clock: t.Unix(),
+			NS:    t.Nanosecond(),
+		})
+	}
+
+	return items
+}
+
+// sendItems sends a single sender data request over a new connection, checking the response of the server
+func (s *ZabbixSender) sendItems(ctx context.Context, items []zabbixItem) (bool, error) {
+	now := time.Now()
+	body, err := json.Marshal(zabbixRequest{Request: "sender data", Data: items, Clock: now.Unix(), NS: now.Nanosecond()})
+	if err != nil {
+		return false, fmt.Errorf("encode Zabbix request: %w", err)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Address)
+	if err != nil {
+		return true, fmt.Errorf("connect to Zabbix: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(zabbixPacket(body)); err != nil {
+		return true, fmt.Errorf("push to Zabbix: %w", err)
+	}
+
+	data, err := readZabbixPacket(conn)
+	if err != nil {
+		return true, fmt.Errorf("read Zabbix response: %w", err)
+	}
+	var resp zabbixResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return false, fmt.Errorf("decode Zabbix response: %w", err)
+	}
+	if resp.Response != "success" {
+		return false, fmt.Errorf("zabbix refused the items: %s %s", resp.Response, resp.Info)
+	}
+
+	return false, nil
+}
+
+// key builds the item key of sample: the key prefix and the metric name, with the characters which aren't allowed
+// replaced with underscores, followed by the label values sorted by label name as the key parameters, e.g.
+// qnap.node_hdtmp_C[3] for node_hdtmp_C{disk="3"}. The parameters containing a comma, a bracket or a quote, or
+// starting with a space, are quoted.
+func (s *ZabbixSender) key(sample exporter.Sample) string {
+	key := zabbixUnsafeRe.ReplaceAllString(s.KeyPrefix+sample.Name, "_")
+	if len(sample.Labels) == 0 {
+		return key
+	}
+
+	names := make([]string, 0, len(sample.Labels))
+	for name := range sample.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	params := make([]string, 0, len(names))
+	for _, name := range names {
+		params = append(params, quoteZabbixParam(sample.Labels[name]))
+	}
+
+	return key + "[" + strings.Join(params, ",") + "]"
+}
+
+// quoteZabbixParam quotes value if it would otherwise be parsed as several parameters or as a different value
+func quoteZabbixParam(value string) string {
+	if !strings.ContainsAny(value, `,[]"`) && !strings.HasPrefix(value, " ") {
+		return value
+	}
+
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// zabbixPacket prepends the protocol header and the little-endian data length to data
+func zabbixPacket(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(zabbixHeader)
+	_ = binary.Write(&buf, binary.LittleEndian, uint64(len(data)))
+	buf.Write(data)
+
+	return buf.Bytes()
+}
+
+// readZabbixPacket reads a message of the Zabbix protocol from r, returning its data
+func readZabbixPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(zabbixHeader)]) != zabbixHeader {
+		return nil, errors.New("invalid Zabbix protocol header")
+	}
+	size := binary.LittleEndian.Uint64(header[len(zabbixHeader):])
+	if size > zabbixMaxResponseSize {
+		return nil, fmt.Errorf("response too large (%d bytes)", size)
+	}
+
+	data := make([]byte, size)
+	_, err := io.ReadFull(r, data)
+
+	return data, err
+}
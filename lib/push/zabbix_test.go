@@ -0,0 +1,144 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZabbixKey(t *testing.T) {
+	testCases := map[string]struct {
+		prefix string
+		sample exporter.Sample
+		want   string
+	}{
+		"no labels": {
+			sample: exporter.Sample{Name: "node_load1"},
+			want:   "qnap.node_load1",
+		},
+		"label values sorted by name": {
+			sample: exporter.Sample{Name: "node_hwmon_temp_celsius", Labels: map[string]string{"sensor": "cpu", "chip": "coretemp"}},
+			want:   "qnap.node_hwmon_temp_celsius[coretemp,cpu]",
+		},
+		"quotes the unsafe parameters": {
+			sample: exporter.Sample{Name: "node_volume_free_bytes", Labels: map[string]string{"volume": `Data, "Vol" [1]`, "status": " ready"}},
+			want:   `qnap.node_volume_free_bytes[" ready","Data, \"Vol\" [1]"]`,
+		},
+		"custom prefix and unsafe name": {
+			prefix: "nas:",
+			sample: exporter.Sample{Name: "node_hdtmp", Labels: map[string]string{"disk": "3"}},
+			want:   "nas_node_hdtmp[3]",
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			s, err := NewZabbixSender(ZabbixConfig{Address: "zabbix:10051", KeyPrefix: tc.prefix})
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, s.key(tc.sample))
+		})
+	}
+}
+
+func TestNewZabbixSender(t *testing.T) {
+	_, err := NewZabbixSender(ZabbixConfig{Address: "zabbix"})
+	assert.Error(t, err)
+}
+
+// serveZabbix accepts sender data requests on a local listener, sending the items received to the returned channel.
+// The requests are refused with a closed connection while fail returns true.
+func serveZabbix(t *testing.T, fail func() bool) (net.Listener, <-chan []zabbixItem) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	requests := make(chan []zabbixItem, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			func() {
+				defer conn.Close()
+				data, err := readZabbixPacket(conn)
+				if err != nil || fail() {
+					return
+				}
+				var req zabbixRequest
+				if json.Unmarshal(data, &req) != nil || req.Request != "sender data" {
+					return
+				}
+				requests <- req.Data
+				_, _ = conn.Write(zabbixPacket([]byte(`{"response":"success","info":"processed: 1; failed: 0"}`)))
+			}()
+		}
+	}()
+
+	return l, requests
+}
+
+func TestZabbixSenderSend(t *testing.T) {
+	var failing atomic.Bool
+	l, requests := serveZabbix(t, failing.Load)
+	defer l.Close()
+	s, err := NewZabbixSender(ZabbixConfig{Address: l.Addr().String(), MaxItems: 2})
+	require.NoError(t, err)
+
+	batch := Batch{
+		Hostname: "nas1",
+		Time:     time.Unix(1700000000, 0),
+		Samples: []exporter.Sample{
+			{Name: "node_load1", Value: 0.5},
+			{Name: "node_hdtmp", Labels: map[string]string{"disk": "1"}, Value: 35},
+			{Name: "node_hdtmp", Labels: map[string]string{"disk": "2"}, Value: 36},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = s.Send(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, []zabbixItem{
+		{Host: "nas1", Key: "qnap.node_load1", Value: "0.5", Clock: 1700000000},
+		{Host: "nas1", Key: "qnap.node_hdtmp[1]", Value: "35", Clock: 1700000000},
+	}, <-requests)
+	assert.Equal(t, []zabbixItem{{Host: "nas1", Key: "qnap.node_hdtmp[2]", Value: "36", Clock: 1700000000}}, <-requests)
+
+	// A retry of a batch only sends the requests which weren't accepted yet
+	s.resumeItems = 2
+	failing.Store(true)
+	retryable, err := s.Send(ctx, batch)
+	assert.Error(t, err)
+	assert.True(t, retryable)
+	failing.Store(false)
+	_, err = s.Send(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, []zabbixItem{{Host: "nas1", Key: "qnap.node_hdtmp[2]", Value: "36", Clock: 1700000000}}, <-requests)
+
+	// The next batch is sent in full, to the configured host
+	s.Host = "qnap"
+	batch.Time = batch.Time.Add(time.Minute)
+	batch.Samples = batch.Samples[:1]
+	_, err = s.Send(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, []zabbixItem{{Host: "qnap", Key: "qnap.node_load1", Value: "0.5", Clock: 1700000060}}, <-requests)
+}
+
+func TestZabbixSenderUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	s, err := NewZabbixSender(ZabbixConfig{Address: address})
+	require.NoError(t, err)
+	retryable, err := s.Send(context.Background(), Batch{Samples: []exporter.Sample{{Name: "node_load1"}}})
+	assert.Error(t, err)
+	assert.True(t, retryable)
+}
@@ -0,0 +1,115 @@
+package sdnotify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// Ready tells the service manager that startup is complete
+	Ready = "READY=1"
+	// Stopping tells the service manager that the service is shutting down
+	Stopping = "STOPPING=1"
+	// Watchdog resets the watchdog timer of the service manager
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notifier sends state changes to the service manager through the sd_notify protocol,
+// i.e. datagrams written to the unix socket named by the NOTIFY_SOCKET environment variable
+type Notifier struct {
+	socket string
+}
+
+// NewNotifier creates a Notifier writing to socket (typically the value of NOTIFY_SOCKET).
+// A name starting with "@" refers to an abstract socket. If socket is empty, the Notifier does nothing.
+func NewNotifier(socket string) *Notifier {
+	return &Notifier{socket: socket}
+}
+
+// Enabled returns whether the process runs under a service manager expecting notifications
+func (n *Notifier) Enabled() bool {
+	return n != nil && n.socket != ""
+}
+
+// Notify sends the state (e.g. Ready) to the service manager
+func (n *Notifier) Notify(state string) error {
+	if !n.Enabled() {
+		return nil
+	}
+
+	name := n.socket
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("connect to notify socket %q: %w", n.socket, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("send %q to notify socket %q: %w", state, n.socket, err)
+	}
+
+	return nil
+}
+
+// WatchdogInterval parses the WATCHDOG_USEC and WATCHDOG_PID environment variables set by the service manager,
+// returning the watchdog timeout of the process with the given PID (0 if the watchdog is disabled or meant for
+// another process)
+func WatchdogInterval(usec, watchdogPID string, pid int) (time.Duration, error) {
+	if usec == "" {
+		return 0, nil
+	}
+	if watchdogPID != "" {
+		p, err := strconv.Atoi(watchdogPID)
+		if err != nil {
+			return 0, fmt.Errorf("parse WATCHDOG_PID %q: %w", watchdogPID, err)
+		}
+		if p != pid {
+			return 0, nil
+		}
+	}
+
+	v, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+
+	return time.Duration(v) * time.Microsecond, nil
+}
+
+// WatchdogIntervalFromEnv returns the watchdog timeout configured for this process, see WatchdogInterval
+func WatchdogIntervalFromEnv() (time.Duration, error) {
+	return WatchdogInterval(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID"), os.Getpid())
+}
+
+// RunWatchdog sends Watchdog to the service manager every half timeout while healthy returns true,
+// so that the service manager restarts the process once it stays unhealthy for the whole timeout
+func (n *Notifier) RunWatchdog(ctx context.Context, timeout time.Duration, healthy func() bool, logger *log.Logger) {
+	if !n.Enabled() || timeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !healthy() {
+				continue
+			}
+			if err := n.Notify(Watchdog); err != nil {
+				logger.Printf("Error notifying the watchdog: %v\n", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
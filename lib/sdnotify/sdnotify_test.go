@@ -0,0 +1,130 @@
+package sdnotify
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listen(t *testing.T) (string, *net.UnixConn) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return socket, conn
+}
+
+func receive(t *testing.T, conn *net.UnixConn, timeout time.Duration) (string, bool) {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(timeout)))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", false
+	}
+
+	return string(buf[:n]), true
+}
+
+func TestNotify(t *testing.T) {
+	socket, conn := listen(t)
+	n := NewNotifier(socket)
+
+	assert.True(t, n.Enabled())
+	require.NoError(t, n.Notify(Ready))
+
+	state, ok := receive(t, conn, time.Second)
+	require.True(t, ok)
+	assert.Equal(t, "READY=1", state)
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	n := NewNotifier("")
+
+	assert.False(t, n.Enabled())
+	assert.NoError(t, n.Notify(Ready))
+	// Returns right away
+	n.RunWatchdog(context.Background(), time.Second, func() bool { return true }, log.New(io.Discard, "", 0))
+}
+
+func TestNotifyUnreachableSocket(t *testing.T) {
+	n := NewNotifier(filepath.Join(t.TempDir(), "missing.sock"))
+
+	assert.ErrorContains(t, n.Notify(Ready), "connect to notify socket")
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := map[string]struct {
+		usec, pid string
+		want      time.Duration
+		wantErr   bool
+	}{
+		"disabled":           {},
+		"any process":        {usec: "30000000", want: 30 * time.Second},
+		"this process":       {usec: "500000", pid: "42", want: 500 * time.Millisecond},
+		"another process":    {usec: "500000", pid: "41"},
+		"invalid timeout":    {usec: "soon", wantErr: true},
+		"zero timeout":       {usec: "0", wantErr: true},
+		"invalid process":    {usec: "500000", pid: "me", wantErr: true},
+		"ignored when unset": {pid: "me"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := WatchdogInterval(tt.usec, tt.pid, 42)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	socket, conn := listen(t)
+	n := NewNotifier(socket)
+
+	healthy := make(chan bool, 1)
+	healthy <- true
+	isHealthy := func() bool {
+		h := <-healthy
+		healthy <- h
+		return h
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n.RunWatchdog(ctx, 100*time.Millisecond, isHealthy, log.New(io.Discard, "", 0))
+	}()
+
+	state, ok := receive(t, conn, time.Second)
+	require.True(t, ok)
+	assert.Equal(t, "WATCHDOG=1", state)
+
+	// The watchdog isn't notified while unhealthy
+	<-healthy
+	healthy <- false
+	for {
+		// Drain a notification sent before the change
+		if _, ok := receive(t, conn, 60*time.Millisecond); !ok {
+			break
+		}
+	}
+	_, ok = receive(t, conn, 200*time.Millisecond)
+	assert.False(t, ok)
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,413 @@
+package sources
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+const (
+	// DefaultConnLogPath is the location of the QTS connection log
+	DefaultConnLogPath = "/etc/logs/conn.log"
+	// DefaultConnLogInterval is the interval between checks of the connection log when none is configured
+	DefaultConnLogInterval = 30 * time.Second
+	// DefaultConnLogAnnotationRate is the number of annotations per minute posted for the connection log when no
+	// rate is configured, so that e.g. a brute-force attempt doesn't flood Grafana
+	DefaultConnLogAnnotationRate = 10
+
+	// connLogBatchSize bounds the number of connections read from the SQLite database on each check
+	connLogBatchSize = 500
+)
+
+// ConnLogRule selects the records of the connection log which are counted, and annotated if Annotate is set
+type ConnLogRule struct {
+	// Name identifies the rule in the rule label of qnap_connlog_events_total
+	Name string
+	// Service, if set, is the service of the records matched, compared case-insensitively (e.g. SSH or SAMBA)
+	Service string
+	// Pattern, if set, is matched against the text of the records, e.g. "SSH Login Fail by admin from 10.0.0.5"
+	Pattern *regexp.Regexp
+	// Annotate posts an annotation for each record matched, tagged with Tags (the rule name, if empty)
+	Annotate bool
+	Tags     []string
+}
+
+// ParseConnLogRule parses a rule made of semicolon-separated settings, e.g.
+// "name=ssh_failed_login;service=SSH;match=(?i)login fail;action=annotate;tags=security|ssh". The name is
+// required, and the action is either count (the default) or annotate.
+func ParseConnLogRule(s string) (ConnLogRule, error) {
+	var rule ConnLogRule
+	for _, setting := range strings.Split(s, ";") {
+		if strings.TrimSpace(setting) == "" {
+			continue
+		}
+		tokens := strings.SplitN(setting, "=", 2)
+		if len(tokens) != 2 {
+			return ConnLogRule{}, fmt.Errorf("invalid connection log rule setting %q, expected key=value", setting)
+		}
+		key, value := strings.TrimSpace(tokens[0]), strings.TrimSpace(tokens[1])
+		switch key {
+		case "name":
+			rule.Name = value
+		case "service":
+			rule.Service = value
+		case "match":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return ConnLogRule{}, fmt.Errorf("invalid connection log rule pattern %q: %w", value, err)
+			}
+			rule.Pattern = re
+		case "action":
+			switch value {
+			case "count":
+			case "annotate":
+				rule.Annotate = true
+			default:
+				return ConnLogRule{}, fmt.Errorf("invalid connection log rule action %q, expected count or annotate", value)
+			}
+		case "tags":
+			for _, tag := range strings.Split(value, "|") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					rule.Tags = append(rule.Tags, tag)
+				}
+			}
+		default:
+			return ConnLogRule{}, fmt.Errorf("unknown connection log rule setting %q", key)
+		}
+	}
+	if rule.Name == "" {
+		return ConnLogRule{}, fmt.Errorf("connection log rule %q has no name", s)
+	}
+	if len(rule.Tags) == 0 {
+		rule.Tags = []string{rule.Name}
+	}
+
+	return rule, nil
+}
+
+// matches returns whether the rule selects the connection
+func (r ConnLogRule) matches(c connection) bool {
+	if r.Service != "" && !strings.EqualFold(r.Service, c.service) {
+		return false
+	}
+
+	return r.Pattern == nil || r.Pattern.MatchString(c.text())
+}
+
+// ConnLogConfig holds the settings of a ConnLogWatcher
+type ConnLogConfig struct {
+	// Path is the QTS connection log, either an SQLite database or a flat file with one CSV record per line holding
+	// the same columns as the NASLOG_CONN table (defaults to DefaultConnLogPath)
+	Path string
+	// StatePath is the file where the ID of the last connection read is saved, so that the connections logged while
+	// stopped are read on startup (defaults to empty, i.e. only connections logged after startup are read)
+	StatePath string
+	// Interval is the time between checks of the connection log (defaults to DefaultConnLogInterval)
+	Interval time.Duration
+	// AnnotationRate is the maximum number of annotations per minute (defaults to DefaultConnLogAnnotationRate)
+	AnnotationRate int
+	Rules          []ConnLogRule
+}
+
+// connection is a record of the QTS connection log
+type connection struct {
+	id                 int64
+	time               time.Time
+	user, ip, resource string
+	service, action    string
+}
+
+// text describes the connection, e.g. "SSH Login Fail by admin from 10.0.0.5"
+func (c connection) text() string {
+	text := fmt.Sprintf("%s %s by %s from %s", c.service, c.action, c.user, c.ip)
+	if c.resource != "" && c.resource != "---" {
+		text += " on " + c.resource
+	}
+
+	return text
+}
+
+// ConnLogWatcher counts the new records of the QTS connection log matching its rules, e.g. failed logins over SMB
+// or SSH, and annotates them for the rules which ask for it
+type ConnLogWatcher struct {
+	ConnLogConfig
+
+	annotator notifications.Annotator
+	logger    *log.Logger
+	limiter   *notifications.TokenBucket
+	// execCommand runs the sqlite3 CLI to query the database
+	execCommand func(cmd string, args ...string) (string, error)
+
+	lastID int64
+	// initialized is set once the last connection ID is known
+	initialized bool
+
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// NewConnLogWatcher creates a ConnLogWatcher posting annotations through annotator
+func NewConnLogWatcher(config ConnLogConfig, annotator notifications.Annotator, logger *log.Logger) *ConnLogWatcher {
+	if config.Path == "" {
+		config.Path = DefaultConnLogPath
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultConnLogInterval
+	}
+	if config.AnnotationRate <= 0 {
+		config.AnnotationRate = DefaultConnLogAnnotationRate
+	}
+
+	w := &ConnLogWatcher{
+		ConnLogConfig: config,
+		annotator:     annotator,
+		logger:        logger,
+		limiter:       notifications.NewTokenBucket(config.AnnotationRate),
+		execCommand:   utils.ExecCommand,
+		counts:        make(map[string]uint64, len(config.Rules)),
+	}
+	for _, rule := range config.Rules {
+		w.counts[rule.Name] = 0
+	}
+	if config.StatePath != "" {
+		id, err := readLastEventID(config.StatePath)
+		switch {
+		case err == nil:
+			w.lastID, w.initialized = id, true
+		case !errors.Is(err, os.ErrNotExist):
+			logger.Printf("Error reading connection log state %q, skipping existing connections: %v\n", config.StatePath, err)
+		}
+	}
+
+	return w
+}
+
+// Counts returns the number of connections matched by each rule, by rule name
+func (w *ConnLogWatcher) Counts() map[string]uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	counts := make(map[string]uint64, len(w.counts))
+	for name, count := range w.counts {
+		counts[name] = count
+	}
+
+	return counts
+}
+
+// Run checks the connection log on every interval until ctx is done
+func (w *ConnLogWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.poll(); err != nil {
+			w.logger.Printf("Error reading QTS connection log: %v\n", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll applies the rules to the connections logged since the last check.
+// On the first check without saved state, the existing connections are skipped.
+func (w *ConnLogWatcher) poll() error {
+	if !w.initialized {
+		id, err := w.latestConnectionID()
+		if err != nil {
+			return err
+		}
+		w.initialized = true
+		w.logger.Printf("Skipping existing connections in QTS connection log, up to ID %d\n", id)
+		return w.setLastID(id)
+	}
+
+	connections, err := w.readConnections(w.lastID)
+	if err != nil {
+		return err
+	}
+	if len(connections) == 0 && w.lastID > 0 {
+		// Nothing new: check whether the log was cleared, in which case the IDs start over
+		id, err := w.latestConnectionID()
+		if err != nil || id >= w.lastID {
+			return err
+		}
+		w.logger.Printf("QTS connection log was cleared, reading it from the start\n")
+		if err := w.setLastID(0); err != nil {
+			return err
+		}
+		if connections, err = w.readConnections(0); err != nil {
+			return err
+		}
+	}
+
+	rateLimited := 0
+	for _, c := range connections {
+		for _, rule := range w.Rules {
+			if !rule.matches(c) {
+				continue
+			}
+			w.mu.Lock()
+			w.counts[rule.Name]++
+			w.mu.Unlock()
+			if !rule.Annotate {
+				continue
+			}
+			if !w.limiter.Allow() {
+				rateLimited++
+				continue
+			}
+			utils.Debugf(w.logger, "Posting QTS connection %d: %s\n", c.id, c.text())
+			if _, err := w.annotator.PostAnnotation(notifications.Annotation{Text: c.text(), Tags: rule.Tags, Time: c.time}); err != nil {
+				w.logger.Printf("Error posting QTS connection %d: %v\n", c.id, err)
+			}
+		}
+		if err := w.setLastID(c.id); err != nil {
+			return err
+		}
+	}
+	if rateLimited > 0 {
+		w.logger.Printf("Skipped %d QTS connection annotations over the rate limit of %d per minute\n", rateLimited, w.AnnotationRate)
+	}
+
+	return nil
+}
+
+// readConnections returns the connections with an ID greater than after, in ascending ID order
+func (w *ConnLogWatcher) readConnections(after int64) ([]connection, error) {
+	isDB, err := isSQLiteFile(w.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !isDB {
+		f, err := os.Open(w.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return parseConnections(bufio.NewReader(f), after)
+	}
+
+	query := fmt.Sprintf(
+		"SELECT conn_id, conn_type, conn_date, conn_time, conn_user, conn_ip, conn_comp, conn_res, conn_serv, conn_action "+
+			"FROM NASLOG_CONN WHERE conn_id > %d ORDER BY conn_id LIMIT %d;",
+		after, connLogBatchSize)
+	output, err := w.execCommand("sqlite3", "-readonly", "-csv", w.Path, query)
+	if err != nil {
+		return nil, fmt.Errorf("query QTS connection log %q: %w", w.Path, err)
+	}
+
+	return parseConnections(strings.NewReader(output), after)
+}
+
+// latestConnectionID returns the highest ID in the connection log, or 0 if it is empty
+func (w *ConnLogWatcher) latestConnectionID() (int64, error) {
+	isDB, err := isSQLiteFile(w.Path)
+	if err != nil {
+		return 0, err
+	}
+	if !isDB {
+		connections, err := w.readConnections(0)
+		var id int64
+		for _, c := range connections {
+			if c.id > id {
+				id = c.id
+			}
+		}
+		return id, err
+	}
+
+	output, err := w.execCommand("sqlite3", "-readonly", w.Path, "SELECT COALESCE(MAX(conn_id), 0) FROM NASLOG_CONN;")
+	if err != nil {
+		return 0, fmt.Errorf("query QTS connection log %q: %w", w.Path, err)
+	}
+	id, err := strconv.ParseInt(output, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse QTS connection log ID %q: %w", output, err)
+	}
+
+	return id, nil
+}
+
+// parseConnections parses CSV records with the columns of the NASLOG_CONN table, returning the connections with an
+// ID greater than after. Records which can't be parsed (e.g. a header) are skipped.
+func parseConnections(r io.Reader, after int64) ([]connection, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var connections []connection
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				continue
+			}
+			return nil, err
+		}
+		if len(record) < 10 {
+			continue
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+
+		id, err := strconv.ParseInt(record[0], 10, 64)
+		if err != nil || id <= after {
+			continue
+		}
+		t, err := time.ParseInLocation(eventTimeLayout, record[2]+" "+record[3], time.Local)
+		if err != nil {
+			t = time.Now()
+		}
+
+		connections = append(connections, connection{
+			id:       id,
+			time:     t,
+			user:     record[4],
+			ip:       record[5],
+			resource: record[7],
+			service:  record[8],
+			action:   record[9],
+		})
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].id < connections[j].id })
+
+	return connections, nil
+}
+
+func (w *ConnLogWatcher) setLastID(id int64) error {
+	w.lastID = id
+	if w.StatePath == "" {
+		return nil
+	}
+
+	if err := os.WriteFile(w.StatePath, []byte(strconv.FormatInt(id, 10)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("save connection log state: %w", err)
+	}
+
+	return nil
+}
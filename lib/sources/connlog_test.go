@@ -0,0 +1,115 @@
+package sources
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const connLogHeader = "conn_id,conn_type,conn_date,conn_time,conn_user,conn_ip,conn_comp,conn_res,conn_serv,conn_action\n"
+
+func TestParseConnLogRule(t *testing.T) {
+	rule, err := ParseConnLogRule("name=ssh_failed_login; service=SSH; match=(?i)login fail; action=annotate; tags=security|ssh")
+	require.NoError(t, err)
+	assert.Equal(t, "ssh_failed_login", rule.Name)
+	assert.Equal(t, "SSH", rule.Service)
+	assert.Equal(t, "(?i)login fail", rule.Pattern.String())
+	assert.True(t, rule.Annotate)
+	assert.Equal(t, []string{"security", "ssh"}, rule.Tags)
+
+	rule, err = ParseConnLogRule("name=smb_access;service=samba")
+	require.NoError(t, err)
+	assert.False(t, rule.Annotate)
+	assert.Nil(t, rule.Pattern)
+	assert.Equal(t, []string{"smb_access"}, rule.Tags)
+
+	for _, spec := range []string{"service=SSH", "name=x;match=(", "name=x;action=page", "name=x;color=red", "name=x;service"} {
+		_, err := ParseConnLogRule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestConnLogWatcherFlatFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "conn.log")
+	statePath := filepath.Join(dir, "conn.state")
+	require.NoError(t, os.WriteFile(path, []byte(connLogHeader), 0o644))
+	appendEvents(t, path, `1,1,2023-04-01,10:00:00,admin,10.0.0.5,---,---,SSH,Login Fail`)
+
+	annotator := &notifications.MockAnnotator{}
+	defer annotator.AssertExpectations(t)
+	annotator.On("PostAnnotation", notifications.Annotation{
+		Text: "SSH Login Fail by root from 10.0.0.6",
+		Tags: []string{"security"},
+		Time: time.Date(2023, 4, 1, 11, 0, 0, 0, time.Local),
+	}).Once().Return(0, nil)
+
+	rules := []ConnLogRule{}
+	for _, spec := range []string{"name=ssh_failed_login;service=ssh;match=Login Fail;action=annotate;tags=security", "name=smb_writes;service=SAMBA;match=Write"} {
+		rule, err := ParseConnLogRule(spec)
+		require.NoError(t, err)
+		rules = append(rules, rule)
+	}
+	config := ConnLogConfig{Path: path, StatePath: statePath, AnnotationRate: 1, Rules: rules}
+	w := NewConnLogWatcher(config, annotator, log.New(io.Discard, "", 0))
+
+	// Existing connections are skipped
+	require.NoError(t, w.poll())
+	annotator.AssertNotCalled(t, "PostAnnotation", mock.Anything)
+	assert.Equal(t, map[string]uint64{"ssh_failed_login": 0, "smb_writes": 0}, w.Counts())
+
+	// The second failed login is over the rate limit, but still counted
+	appendEvents(t, path,
+		`2,1,2023-04-01,11:00:00,root,10.0.0.6,---,---,SSH,Login Fail`,
+		`3,0,2023-04-01,11:00:01,alice,10.0.0.7,laptop,Public/report.pdf,SAMBA,Write`,
+		`4,1,2023-04-01,11:00:02,root,10.0.0.6,---,---,SSH,Login Fail`,
+		`5,0,2023-04-01,11:00:03,alice,10.0.0.7,---,---,SSH,Login OK`,
+	)
+	require.NoError(t, w.poll())
+	assert.Equal(t, map[string]uint64{"ssh_failed_login": 2, "smb_writes": 1}, w.Counts())
+
+	// The position is saved across restarts
+	state, err := os.ReadFile(statePath)
+	require.NoError(t, err)
+	assert.Equal(t, "5\n", string(state))
+	restarted := NewConnLogWatcher(config, annotator, log.New(io.Discard, "", 0))
+	require.NoError(t, restarted.poll())
+	assert.Equal(t, map[string]uint64{"ssh_failed_login": 0, "smb_writes": 0}, restarted.Counts())
+}
+
+func TestConnLogWatcherSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conn.log")
+	require.NoError(t, os.WriteFile(path, append(sqliteMagic, make([]byte, 100)...), 0o644))
+
+	rule, err := ParseConnLogRule("name=failed_login;match=Login Fail")
+	require.NoError(t, err)
+	var queries []string
+	w := NewConnLogWatcher(ConnLogConfig{Path: path, Rules: []ConnLogRule{rule}}, &notifications.MockAnnotator{}, log.New(io.Discard, "", 0))
+	w.execCommand = func(cmd string, args ...string) (string, error) {
+		assert.Equal(t, "sqlite3", cmd)
+		query := args[len(args)-1]
+		queries = append(queries, query)
+		if strings.Contains(query, "MAX(conn_id)") {
+			return "41", nil
+		}
+		assert.Contains(t, args, "-csv")
+		return `42,1,2023-04-01,11:00:00,admin,10.0.0.5,---,---,SAMBA,Login Fail`, nil
+	}
+
+	require.NoError(t, w.poll())
+	require.NoError(t, w.poll())
+
+	require.Len(t, queries, 2)
+	assert.Contains(t, queries[1], "WHERE conn_id > 41 ORDER BY conn_id")
+	assert.Equal(t, int64(42), w.lastID)
+	assert.Equal(t, map[string]uint64{"failed_login": 1}, w.Counts())
+}
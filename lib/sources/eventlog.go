@@ -0,0 +1,319 @@
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+const (
+	// DefaultEventLogPath is the location of the QTS system event log
+	DefaultEventLogPath = "/etc/logs/event.log"
+	// DefaultEventLogInterval is the interval between checks of the event log when none is configured
+	DefaultEventLogInterval = 30 * time.Second
+
+	// eventLogBatchSize bounds the number of events read from the SQLite database on each check
+	eventLogBatchSize = 500
+	eventTimeLayout   = "2006-01-02 15:04:05"
+)
+
+// sqliteMagic is the header of SQLite database files
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// eventSeverityTags maps the QTS event types to annotation tags
+var eventSeverityTags = map[string]string{
+	"0": "info",
+	"1": "warning",
+	"2": "error",
+}
+
+// EventLogConfig holds the settings of an EventLogWatcher
+type EventLogConfig struct {
+	// Path is the QTS event log, either an SQLite database (QTS 4.x) or a flat file with one CSV record per line
+	// holding the same columns as the NASLOG_EVENT table (defaults to DefaultEventLogPath)
+	Path string
+	// StatePath is the file where the ID of the last event posted is saved, so that history isn't posted again
+	// after a restart (defaults to empty, i.e. only events logged after startup are posted)
+	StatePath string
+	// Interval is the time between checks of the event log (defaults to DefaultEventLogInterval)
+	Interval time.Duration
+}
+
+// event is a record of the QTS event log
+type event struct {
+	id       int64
+	severity string
+	time     time.Time
+	text     string
+}
+
+// EventLogWatcher posts the new events of the QTS event log as annotations,
+// tagged with their severity ([error], [warning] or [info])
+type EventLogWatcher struct {
+	EventLogConfig
+
+	annotator notifications.Annotator
+	logger    *log.Logger
+	// execCommand runs the sqlite3 CLI to query the database
+	execCommand func(cmd string, args ...string) (string, error)
+
+	lastID int64
+	// initialized is set once the last event ID is known
+	initialized bool
+}
+
+// NewEventLogWatcher creates an EventLogWatcher posting events through annotator
+func NewEventLogWatcher(config EventLogConfig, annotator notifications.Annotator, logger *log.Logger) *EventLogWatcher {
+	if config.Path == "" {
+		config.Path = DefaultEventLogPath
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultEventLogInterval
+	}
+
+	w := &EventLogWatcher{
+		EventLogConfig: config,
+		annotator:      annotator,
+		logger:         logger,
+		execCommand:    utils.ExecCommand,
+	}
+	if config.StatePath != "" {
+		id, err := readLastEventID(config.StatePath)
+		switch {
+		case err == nil:
+			w.lastID, w.initialized = id, true
+		case !errors.Is(err, os.ErrNotExist):
+			logger.Printf("Error reading event log state %q, skipping existing events: %v\n", config.StatePath, err)
+		}
+	}
+
+	return w
+}
+
+// Run checks the event log on every interval until ctx is done
+func (w *EventLogWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.poll(); err != nil {
+			w.logger.Printf("Error reading QTS event log: %v\n", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll posts the events logged since the last check.
+// On the first check without saved state, the existing events are skipped.
+func (w *EventLogWatcher) poll() error {
+	if !w.initialized {
+		id, err := w.latestEventID()
+		if err != nil {
+			return err
+		}
+		w.initialized = true
+		w.logger.Printf("Skipping existing events in QTS event log, up to ID %d\n", id)
+		return w.setLastID(id)
+	}
+
+	events, err := w.readEvents(w.lastID)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 && w.lastID > 0 {
+		// Nothing new: check whether the log was cleared, in which case the IDs start over
+		id, err := w.latestEventID()
+		if err != nil || id >= w.lastID {
+			return err
+		}
+		w.logger.Printf("QTS event log was cleared, reading it from the start\n")
+		if err := w.setLastID(0); err != nil {
+			return err
+		}
+		if events, err = w.readEvents(0); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range events {
+		utils.Debugf(w.logger, "Posting QTS event %d: %s\n", e.id, e.text)
+		if _, err := w.annotator.PostAnnotation(notifications.Annotation{Text: e.text, Tags: []string{e.severity}, Time: e.time}); err != nil {
+			w.logger.Printf("Error posting QTS event %d: %v\n", e.id, err)
+		}
+		if err := w.setLastID(e.id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readEvents returns the events with an ID greater than after, in ascending ID order
+func (w *EventLogWatcher) readEvents(after int64) ([]event, error) {
+	isDB, err := isSQLiteFile(w.Path)
+	if err != nil {
+		return nil, err
+	}
+	if isDB {
+		return w.readSQLiteEvents(after)
+	}
+
+	return w.readFlatFileEvents(after)
+}
+
+// latestEventID returns the highest ID in the event log, or 0 if it is empty
+func (w *EventLogWatcher) latestEventID() (int64, error) {
+	isDB, err := isSQLiteFile(w.Path)
+	if err != nil {
+		return 0, err
+	}
+	if !isDB {
+		events, err := w.readFlatFileEvents(0)
+		return maxEventID(events), err
+	}
+
+	output, err := w.execCommand("sqlite3", "-readonly", w.Path, "SELECT COALESCE(MAX(event_id), 0) FROM NASLOG_EVENT;")
+	if err != nil {
+		return 0, fmt.Errorf("query QTS event log %q: %w", w.Path, err)
+	}
+	id, err := strconv.ParseInt(output, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse QTS event log ID %q: %w", output, err)
+	}
+
+	return id, nil
+}
+
+func (w *EventLogWatcher) readSQLiteEvents(after int64) ([]event, error) {
+	query := fmt.Sprintf(
+		"SELECT event_id, event_type, event_date, event_time, event_user, event_ip, event_comp, event_desc "+
+			"FROM NASLOG_EVENT WHERE event_id > %d ORDER BY event_id LIMIT %d;",
+		after, eventLogBatchSize)
+	output, err := w.execCommand("sqlite3", "-readonly", "-csv", w.Path, query)
+	if err != nil {
+		return nil, fmt.Errorf("query QTS event log %q: %w", w.Path, err)
+	}
+
+	return parseEvents(strings.NewReader(output), after)
+}
+
+func (w *EventLogWatcher) readFlatFileEvents(after int64) ([]event, error) {
+	f, err := os.Open(w.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseEvents(bufio.NewReader(f), after)
+}
+
+// parseEvents parses CSV records with the columns of the NASLOG_EVENT table, returning the events with an ID
+// greater than after. Records which can't be parsed (e.g. a header) are skipped.
+func parseEvents(r io.Reader, after int64) ([]event, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var events []event
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				continue
+			}
+			return nil, err
+		}
+		if len(record) < 8 {
+			continue
+		}
+
+		id, err := strconv.ParseInt(strings.TrimSpace(record[0]), 10, 64)
+		if err != nil || id <= after {
+			continue
+		}
+		severity, ok := eventSeverityTags[strings.TrimSpace(record[1])]
+		if !ok {
+			severity = "info"
+		}
+		t, err := time.ParseInLocation(eventTimeLayout, strings.TrimSpace(record[2])+" "+strings.TrimSpace(record[3]), time.Local)
+		if err != nil {
+			t = time.Now()
+		}
+
+		events = append(events, event{id: id, severity: severity, time: t, text: strings.TrimSpace(record[7])})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].id < events[j].id })
+
+	return events, nil
+}
+
+func (w *EventLogWatcher) setLastID(id int64) error {
+	w.lastID = id
+	if w.StatePath == "" {
+		return nil
+	}
+
+	if err := os.WriteFile(w.StatePath, []byte(strconv.FormatInt(id, 10)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("save event log state: %w", err)
+	}
+
+	return nil
+}
+
+func readLastEventID(path string) (int64, error) {
+	contents, err := utils.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(contents, 10, 64)
+}
+
+func isSQLiteFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(sqliteMagic))
+	if _, err := io.ReadFull(f, header); err != nil {
+		// Too short to be a database
+		return false, nil
+	}
+
+	return bytes.Equal(header, sqliteMagic), nil
+}
+
+func maxEventID(events []event) int64 {
+	var id int64
+	for _, e := range events {
+		if e.id > id {
+			id = e.id
+		}
+	}
+
+	return id
+}
@@ -0,0 +1,146 @@
+package sources
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const eventLogHeader = "event_id,event_type,event_date,event_time,event_user,event_ip,event_comp,event_desc\n"
+
+func appendEvents(t *testing.T, path string, lines ...string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range lines {
+		_, err := f.WriteString(line + "\n")
+		require.NoError(t, err)
+	}
+}
+
+func TestEventLogWatcherFlatFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "event.log")
+	statePath := filepath.Join(dir, "event.state")
+	require.NoError(t, os.WriteFile(path, []byte(eventLogHeader), 0o644))
+	appendEvents(t, path, `1,0,2023-04-01,10:00:00,System,127.0.0.1,localhost,"Old event"`)
+
+	annotator := &notifications.MockAnnotator{}
+	defer annotator.AssertExpectations(t)
+	annotator.On("PostAnnotation", notifications.Annotation{
+		Text: "[Hardware Status] Host: Disk 3 plugged in.",
+		Tags: []string{"info"},
+		Time: time.Date(2023, 4, 1, 11, 0, 0, 0, time.Local),
+	}).Once().Return(0, nil)
+	annotator.On("PostAnnotation", notifications.Annotation{
+		Text: "[Hardware Status] System fan 1 failed, please check it.",
+		Tags: []string{"error"},
+		Time: time.Date(2023, 4, 1, 11, 5, 0, 0, time.Local),
+	}).Once().Return(0, nil)
+	annotator.On("PostAnnotation", notifications.Annotation{
+		Text: `[Firmware Update] Updated system from version "5.0.1" to "5.1.0".`,
+		Tags: []string{"warning"},
+		Time: time.Date(2023, 4, 1, 12, 0, 0, 0, time.Local),
+	}).Once().Return(0, nil)
+
+	w := NewEventLogWatcher(EventLogConfig{Path: path, StatePath: statePath}, annotator, log.New(io.Discard, "", 0))
+
+	// Existing events are skipped
+	require.NoError(t, w.poll())
+	annotator.AssertNotCalled(t, "PostAnnotation", mock.Anything)
+
+	appendEvents(t, path,
+		`2,0,2023-04-01,11:00:00,System,127.0.0.1,localhost,[Hardware Status] Host: Disk 3 plugged in.`,
+		`3,2,2023-04-01,11:05:00,System,127.0.0.1,localhost,"[Hardware Status] System fan 1 failed, please check it."`,
+	)
+	require.NoError(t, w.poll())
+
+	state, err := os.ReadFile(statePath)
+	require.NoError(t, err)
+	assert.Equal(t, "3\n", string(state))
+
+	// A new watcher resumes from the saved state
+	appendEvents(t, path, `4,1,2023-04-01,12:00:00,System,127.0.0.1,localhost,"[Firmware Update] Updated system from version ""5.0.1"" to ""5.1.0""."`)
+	w = NewEventLogWatcher(EventLogConfig{Path: path, StatePath: statePath}, annotator, log.New(io.Discard, "", 0))
+	require.NoError(t, w.poll())
+	require.NoError(t, w.poll())
+}
+
+func TestEventLogWatcherCleared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "event.log")
+	appendEvents(t, path, `50,0,2023-04-01,10:00:00,System,127.0.0.1,localhost,Old event`)
+
+	annotator := &notifications.MockAnnotator{}
+	defer annotator.AssertExpectations(t)
+	annotator.On("PostAnnotation", mock.MatchedBy(func(a notifications.Annotation) bool {
+		return a.Text == "[Event Log] Event log cleared."
+	})).Once().Return(0, nil)
+
+	w := NewEventLogWatcher(EventLogConfig{Path: path}, annotator, log.New(io.Discard, "", 0))
+	require.NoError(t, w.poll())
+	assert.Equal(t, int64(50), w.lastID)
+
+	require.NoError(t, os.WriteFile(path, []byte(`1,0,2023-04-02,10:00:00,System,127.0.0.1,localhost,[Event Log] Event log cleared.`+"\n"), 0o644))
+	require.NoError(t, w.poll())
+	assert.Equal(t, int64(1), w.lastID)
+}
+
+func TestEventLogWatcherSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "event.log")
+	require.NoError(t, os.WriteFile(path, append(sqliteMagic, make([]byte, 100)...), 0o644))
+
+	annotator := &notifications.MockAnnotator{}
+	defer annotator.AssertExpectations(t)
+	annotator.On("PostAnnotation", notifications.Annotation{
+		Text: "[UPS] Power failure detected, the NAS is running on battery.",
+		Tags: []string{"warning"},
+		Time: time.Date(2023, 4, 1, 11, 0, 0, 0, time.Local),
+	}).Once().Return(0, nil)
+
+	var queries []string
+	w := NewEventLogWatcher(EventLogConfig{Path: path}, annotator, log.New(io.Discard, "", 0))
+	w.execCommand = func(cmd string, args ...string) (string, error) {
+		assert.Equal(t, "sqlite3", cmd)
+		query := args[len(args)-1]
+		queries = append(queries, query)
+		if strings.Contains(query, "MAX(event_id)") {
+			return "41", nil
+		}
+		assert.Contains(t, args, "-csv")
+		return `42,1,2023-04-01,11:00:00,System,127.0.0.1,localhost,"[UPS] Power failure detected, the NAS is running on battery."`, nil
+	}
+
+	require.NoError(t, w.poll())
+	require.NoError(t, w.poll())
+
+	require.Len(t, queries, 2)
+	assert.Contains(t, queries[1], "WHERE event_id > 41 ORDER BY event_id")
+	assert.Equal(t, int64(42), w.lastID)
+}
+
+func TestParseEvents(t *testing.T) {
+	events, err := parseEvents(strings.NewReader(eventLogHeader+strings.Join([]string{
+		`3,2,2023-04-01,11:05:00,System,127.0.0.1,localhost,Third`,
+		`2,9,not a date,,System,127.0.0.1,localhost,Second`,
+		`1,0,2023-04-01,10:00:00,System,127.0.0.1,localhost,First`,
+		`truncated,line`,
+	}, "\n")), 1)
+
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(2), events[0].id)
+	assert.Equal(t, "info", events[0].severity)
+	assert.Equal(t, "Second", events[0].text)
+	assert.Equal(t, int64(3), events[1].id)
+	assert.Equal(t, "error", events[1].severity)
+}
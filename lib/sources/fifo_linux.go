@@ -0,0 +1,7 @@
+package sources
+
+import "syscall"
+
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0o660)
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package sources
+
+import "errors"
+
+func mkfifo(path string) error {
+	return errors.New("named pipes are only supported on Linux")
+}
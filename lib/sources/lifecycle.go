@@ -0,0 +1,128 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+// DefaultLifecycleRetryInterval is the interval between attempts to post the startup annotation when none is configured
+const DefaultLifecycleRetryInterval = 30 * time.Second
+
+// LifecycleConfig holds the settings of a Lifecycle
+type LifecycleConfig struct {
+	// StatePath is a marker file written on startup and removed on clean shutdown, to detect unclean shutdowns
+	// (defaults to empty, i.e. unclean shutdowns aren't detected)
+	StatePath string
+	// RetryInterval is the interval between attempts to post the startup annotation
+	// (defaults to DefaultLifecycleRetryInterval)
+	RetryInterval time.Duration
+}
+
+// Lifecycle annotates the time the exporter is running as a region, opened on startup and closed on clean shutdown.
+// A restart after an unclean shutdown (i.e. the marker file of the previous run still exists) is mentioned in the text.
+type Lifecycle struct {
+	LifecycleConfig
+
+	annotator notifications.Annotator
+	logger    *log.Logger
+
+	mu     sync.Mutex
+	text   string
+	posted bool
+	done   chan struct{}
+}
+
+// NewLifecycle creates a Lifecycle posting annotations through annotator
+func NewLifecycle(config LifecycleConfig, annotator notifications.Annotator, logger *log.Logger) *Lifecycle {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = DefaultLifecycleRetryInterval
+	}
+
+	return &Lifecycle{
+		LifecycleConfig: config,
+		annotator:       annotator,
+		logger:          logger,
+		text:            "Exporter started",
+		done:            make(chan struct{}),
+	}
+}
+
+// Start writes the marker file and posts the startup annotation from a separate goroutine,
+// retrying until it is delivered or ctx is done, so that an unreachable notifier doesn't delay startup
+func (l *Lifecycle) Start(ctx context.Context) {
+	now := time.Now()
+	if l.StatePath != "" {
+		if previous, err := utils.ReadFile(l.StatePath); err == nil {
+			l.text += fmt.Sprintf(" after an unclean shutdown of the run started %s", strings.TrimSpace(previous))
+			l.logger.Printf("Found %q, the previous run didn't shut down cleanly\n", l.StatePath)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			l.logger.Printf("Error reading exporter state %q: %v\n", l.StatePath, err)
+		}
+
+		if err := os.WriteFile(l.StatePath, []byte(now.Format(time.RFC3339)+"\n"), 0o644); err != nil {
+			l.logger.Printf("Error writing exporter state %q: %v\n", l.StatePath, err)
+		}
+	}
+
+	go func() {
+		defer close(l.done)
+
+		annotation := notifications.Annotation{Text: l.text, Tags: []string{"exporter"}, Time: now}
+		for {
+			_, err := l.annotator.PostAnnotation(annotation)
+			if err == nil {
+				l.mu.Lock()
+				l.posted = true
+				l.mu.Unlock()
+				return
+			}
+			l.logger.Printf("Error posting startup annotation, retrying in %v: %v\n", l.RetryInterval, err)
+
+			select {
+			case <-time.After(l.RetryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop closes the region opened by Start, waiting up to timeout for the startup annotation to be delivered,
+// and removes the marker file
+func (l *Lifecycle) Stop(timeout time.Duration) {
+	defer func() {
+		if l.StatePath == "" {
+			return
+		}
+		if err := os.Remove(l.StatePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			l.logger.Printf("Error removing exporter state %q: %v\n", l.StatePath, err)
+		}
+	}()
+
+	select {
+	case <-l.done:
+	case <-time.After(timeout):
+	}
+
+	l.mu.Lock()
+	posted := l.posted
+	l.mu.Unlock()
+	if !posted {
+		// There is no region to close
+		l.logger.Println("Startup annotation wasn't delivered, skipping shutdown annotation")
+		return
+	}
+
+	if _, err := l.annotator.PostAnnotation(notifications.Annotation{Text: l.text, Tags: []string{"exporter"}, End: true}); err != nil {
+		l.logger.Printf("Error posting shutdown annotation: %v\n", err)
+	}
+}
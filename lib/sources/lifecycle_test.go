@@ -0,0 +1,67 @@
+package sources
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycle(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "qnapexporter.state")
+	isStart := mock.MatchedBy(func(a notifications.Annotation) bool { return !a.End })
+	isEnd := mock.MatchedBy(func(a notifications.Annotation) bool { return a.End })
+
+	// Clean run, with the notifier unreachable at first
+	annotator := &notifications.MockAnnotator{}
+	annotator.On("PostAnnotation", isStart).Return(0, assert.AnError).Once()
+	annotator.On("PostAnnotation", isStart).Return(1, nil).Once()
+	annotator.On("PostAnnotation", isEnd).Return(1, nil).Once()
+
+	l := NewLifecycle(LifecycleConfig{StatePath: statePath, RetryInterval: time.Millisecond}, annotator, log.New(io.Discard, "", 0))
+	l.Start(context.Background())
+	require.FileExists(t, statePath)
+	l.Stop(5 * time.Second)
+
+	annotator.AssertExpectations(t)
+	end := annotator.Calls[2].Arguments.Get(0).(notifications.Annotation)
+	assert.Equal(t, "Exporter started", end.Text)
+	assert.Equal(t, []string{"exporter"}, end.Tags)
+	assert.NoFileExists(t, statePath)
+
+	// Unclean shutdown of the previous run
+	require.NoError(t, os.WriteFile(statePath, []byte("2020-01-01T12:00:00Z\n"), 0o644))
+	annotator = &notifications.MockAnnotator{}
+	annotator.On("PostAnnotation", mock.Anything).Return(2, nil)
+
+	l = NewLifecycle(LifecycleConfig{StatePath: statePath}, annotator, log.New(io.Discard, "", 0))
+	l.Start(context.Background())
+	l.Stop(5 * time.Second)
+
+	annotator.AssertNumberOfCalls(t, "PostAnnotation", 2)
+	start := annotator.Calls[0].Arguments.Get(0).(notifications.Annotation)
+	assert.Equal(t, "Exporter started after an unclean shutdown of the run started 2020-01-01T12:00:00Z", start.Text)
+	assert.False(t, start.End)
+	assert.True(t, annotator.Calls[1].Arguments.Get(0).(notifications.Annotation).End)
+}
+
+func TestLifecycleSkipsShutdownWhenStartupWasNotDelivered(t *testing.T) {
+	annotator := &notifications.MockAnnotator{}
+	annotator.On("PostAnnotation", mock.Anything).Return(0, assert.AnError)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := NewLifecycle(LifecycleConfig{RetryInterval: time.Hour}, annotator, log.New(io.Discard, "", 0))
+	l.Start(ctx)
+	cancel()
+	l.Stop(5 * time.Second)
+
+	annotator.AssertNumberOfCalls(t, "PostAnnotation", 1)
+}
@@ -0,0 +1,238 @@
+package sources
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/pedropombeiro/qnapexporter/lib/utils"
+)
+
+const (
+	// DefaultLineSourceBufferSize is the number of lines waiting to be posted when none is configured
+	DefaultLineSourceBufferSize = 100
+	// DefaultLineSourcePollInterval is the interval between checks of a tailed file when none is configured
+	DefaultLineSourcePollInterval = time.Second
+)
+
+// LineSourceConfig holds the settings of a LineSource
+type LineSourceConfig struct {
+	// Path is a named pipe, created if it doesn't exist, or a regular file which is tailed
+	Path string
+	// BufferSize is the number of lines waiting to be posted, beyond which new lines are dropped
+	// (defaults to DefaultLineSourceBufferSize)
+	BufferSize int
+	// PollInterval is the interval between checks of a tailed file for new lines, truncation or rotation
+	// (defaults to DefaultLineSourcePollInterval)
+	PollInterval time.Duration
+}
+
+// LineSource posts each non-empty line written to a named pipe or appended to a file as an annotation,
+// using the "[tag] text" syntax. Lines are posted from a separate goroutine, so that a slow or unavailable
+// notifier never blocks the writers.
+type LineSource struct {
+	LineSourceConfig
+
+	annotator notifications.Annotator
+	logger    *log.Logger
+	lines     chan string
+	dropped   uint64
+}
+
+// NewLineSource creates a LineSource posting lines through annotator
+func NewLineSource(config LineSourceConfig, annotator notifications.Annotator, logger *log.Logger) *LineSource {
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultLineSourceBufferSize
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultLineSourcePollInterval
+	}
+
+	return &LineSource{
+		LineSourceConfig: config,
+		annotator:        annotator,
+		logger:           logger,
+		lines:            make(chan string, config.BufferSize),
+	}
+}
+
+// Dropped returns the number of lines which could not be posted
+func (s *LineSource) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Run reads lines from the configured path until ctx is done, creating a named pipe if the path doesn't exist
+func (s *LineSource) Run(ctx context.Context) error {
+	info, err := os.Stat(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		if err = mkfifo(s.Path); err != nil {
+			return fmt.Errorf("create named pipe %q: %w", s.Path, err)
+		}
+		s.logger.Printf("Created named pipe %q for annotations\n", s.Path)
+		info, err = os.Stat(s.Path)
+	}
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer func() { <-done }()
+	defer close(s.lines)
+	go func() {
+		defer close(done)
+		s.postLines()
+	}()
+
+	if info.Mode()&os.ModeNamedPipe != 0 {
+		return s.readPipe(ctx)
+	}
+
+	return s.tailFile(ctx)
+}
+
+// postLines posts the buffered lines until the buffer is closed
+func (s *LineSource) postLines() {
+	for line := range s.lines {
+		if _, err := s.annotator.Post(line, time.Now()); err != nil {
+			atomic.AddUint64(&s.dropped, 1)
+			s.logger.Printf("Error posting annotation from %q: %v\n", s.Path, err)
+		}
+	}
+}
+
+// emit buffers a line to be posted, dropping it if the buffer is full
+func (s *LineSource) emit(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	select {
+	case s.lines <- line:
+		utils.Debugf(s.logger, "Read annotation from %q: %s\n", s.Path, line)
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		s.logger.Printf("Dropping annotation from %q, too many annotations waiting to be posted: %s\n", s.Path, line)
+	}
+}
+
+// readPipe reads lines from the named pipe. The pipe is opened for writing as well, so that it never reaches
+// EOF when a writer closes it, and opening it doesn't wait for a writer.
+func (s *LineSource) readPipe(ctx context.Context) error {
+	for {
+		f, err := os.OpenFile(s.Path, os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("open named pipe %q: %w", s.Path, err)
+		}
+
+		// Closing the pipe interrupts the pending read
+		readDone := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				f.Close()
+			case <-readDone:
+			}
+		}()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			s.emit(scanner.Text())
+		}
+		err = scanner.Err()
+		close(readDone)
+		f.Close()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			s.logger.Printf("Error reading named pipe %q, reopening it: %v\n", s.Path, err)
+		}
+	}
+}
+
+// tailFile reads the lines appended to the file, starting from its current end.
+// The file is read again from the start when it is truncated, or reopened when it is replaced (i.e. rotated).
+func (s *LineSource) tailFile(ctx context.Context) error {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	var partial string
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			line, err := reader.ReadString('\n')
+			offset += int64(len(line))
+			if err != nil {
+				// Keep incomplete lines until the writer finishes them
+				partial += line
+				break
+			}
+			s.emit(partial + line)
+			partial = ""
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+
+		current, err := os.Stat(s.Path)
+		if err != nil {
+			// The file may be in the middle of a rotation
+			continue
+		}
+		opened, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case !os.SameFile(opened, current):
+			s.logger.Printf("File %q was rotated, reopening it\n", s.Path)
+			// Read what was appended to the old file before it was rotated
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					break
+				}
+				s.emit(partial + line)
+				partial = ""
+			}
+
+			newFile, err := os.Open(s.Path)
+			if err != nil {
+				continue
+			}
+			f.Close()
+			f, offset, partial = newFile, 0, ""
+			reader.Reset(f)
+		case current.Size() < offset:
+			s.logger.Printf("File %q was truncated, reading it from the start\n", s.Path)
+			if offset, err = f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			partial = ""
+			reader.Reset(f)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package sources
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pedropombeiro/qnapexporter/lib/notifications"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newRecordingAnnotator returns a MockAnnotator sending the posted annotations to the returned channel
+func newRecordingAnnotator() (*notifications.MockAnnotator, chan string) {
+	posted := make(chan string, 10)
+	annotator := &notifications.MockAnnotator{}
+	annotator.On("Post", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { posted <- args.String(0) }).
+		Return(0, nil)
+
+	return annotator, posted
+}
+
+func receive(t *testing.T, posted chan string) string {
+	select {
+	case s := <-posted:
+		return s
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for annotation")
+		return ""
+	}
+}
+
+func writeFile(t *testing.T, path string, flag int, contents string) {
+	f, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(contents)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func startLineSource(t *testing.T, config LineSourceConfig, annotator notifications.Annotator) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewLineSource(config, annotator, log.New(io.Discard, "", 0))
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Run(ctx) }()
+
+	return func() {
+		cancel()
+		select {
+		case err := <-errCh:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "line source didn't stop")
+		}
+	}
+}
+
+func TestLineSourceTailsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "annotations.log")
+	writeFile(t, path, os.O_TRUNC, "[old] Not posted again\n")
+
+	annotator, posted := newRecordingAnnotator()
+	stop := startLineSource(t, LineSourceConfig{Path: path, PollInterval: 10 * time.Millisecond}, annotator)
+	defer stop()
+	time.Sleep(50 * time.Millisecond)
+
+	writeFile(t, path, os.O_APPEND, "[backup] Backup started\n\n[backup] Backup")
+	assert.Equal(t, "[backup] Backup started", receive(t, posted))
+	writeFile(t, path, os.O_APPEND, " finished\n")
+	assert.Equal(t, "[backup] Backup finished", receive(t, posted))
+
+	// Truncated
+	writeFile(t, path, os.O_TRUNC, "After truncation\n")
+	assert.Equal(t, "After truncation", receive(t, posted))
+
+	// Rotated
+	require.NoError(t, os.Rename(path, path+".1"))
+	writeFile(t, path, os.O_TRUNC, "After rotation\n")
+	assert.Equal(t, "After rotation", receive(t, posted))
+}
+
+func TestLineSourceReadsNamedPipe(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("named pipes are only supported on Linux")
+	}
+	path := filepath.Join(t.TempDir(), "annotations.fifo")
+
+	annotator, posted := newRecordingAnnotator()
+	stop := startLineSource(t, LineSourceConfig{Path: path}, annotator)
+	defer stop()
+
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Mode()&os.ModeNamedPipe != 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The reader survives writers closing the pipe
+	writeFile(t, path, 0, "[script] First\n")
+	assert.Equal(t, "[script] First", receive(t, posted))
+	writeFile(t, path, 0, "[script] Second\n[script] Third\n")
+	assert.Equal(t, "[script] Second", receive(t, posted))
+	assert.Equal(t, "[script] Third", receive(t, posted))
+}
+
+func TestLineSourceDropsWhenNotifierIsBlocked(t *testing.T) {
+	release := make(chan struct{})
+	annotator := &notifications.MockAnnotator{}
+	annotator.On("Post", mock.Anything, mock.Anything).
+		Run(func(mock.Arguments) { <-release }).
+		Return(0, nil)
+
+	s := NewLineSource(LineSourceConfig{Path: "unused", BufferSize: 2}, annotator, log.New(io.Discard, "", 0))
+	go s.postLines()
+
+	// The first line is taken by the blocked poster, the next 2 are buffered
+	s.emit("line 1")
+	require.Eventually(t, func() bool { return len(s.lines) == 0 }, 5*time.Second, time.Millisecond)
+	s.emit("line 2")
+	s.emit("line 3")
+	s.emit("line 4")
+	s.emit("line 5")
+	s.emit("   ")
+
+	assert.Equal(t, uint64(2), s.Dropped())
+	close(release)
+	close(s.lines)
+}
@@ -1,6 +1,7 @@
 package status
 
 import (
+	"fmt"
 	"html/template"
 	"io"
 	"time"
@@ -30,10 +31,35 @@ const (
 </head>
 
 <body>
+	<h1>qnapexporter</h1>
+	<table>
+		<tbody>
+			{{ range .Summary }}
+			<tr>
+				<th>{{ .Name }}</th>
+				<td>{{ .Value }}</td>
+			</tr>
+			{{ end }}
+		</tbody>
+	</table>
+	{{ if .Errors }}
+	<h2>Collector errors</h2>
+	<table>
+		<tbody>
+			{{ range $collector, $err := .Errors }}
+			<tr>
+				<th>{{ $collector }}</th>
+				<td>{{ $err }}</td>
+			</tr>
+			{{ end }}
+		</tbody>
+	</table>
+	{{ end }}
+
 	<h1>Active endpoints</h1>
 	<table>
 		<tbody>
-			{{ range . }}
+			{{ range .Endpoints }}
 			{{ if .Path }}
 			<tr>
 				<td>
@@ -72,8 +98,14 @@ type endpointStatus struct {
 	Properties map[string]string
 }
 
+type property struct {
+	Name, Value string
+}
+
 type Status struct {
 	MetricsEndpoint      string
+	HealthEndpoint       string
+	ReadinessEndpoint    string
 	NotificationEndpoint string
 	ExporterStatus       exporter.Status
 	LastNotification     time.Time
@@ -99,6 +131,26 @@ func (s *Status) WriteHTML(w io.Writer) error {
 		},
 	}
 	endpoints := []endpointStatus{ms}
+	endpoints = append(endpoints, endpointStatus{
+		Path: s.HealthEndpoint,
+		Properties: map[string]string{
+			"Status": "OK",
+		},
+	})
+	readiness := "OK"
+	switch {
+	case e.EnvironmentRead.IsZero():
+		readiness = "Reading environment"
+	case e.EnvironmentError != "":
+		readiness = e.EnvironmentError
+	}
+	endpoints = append(endpoints, endpointStatus{
+		Path: s.ReadinessEndpoint,
+		Properties: map[string]string{
+			"Status":           readiness,
+			"Environment read": humanizeTime(e.EnvironmentRead),
+		},
+	})
 	endpoints = append(endpoints, endpointStatus{
 		Path: s.NotificationEndpoint,
 		Properties: map[string]string{
@@ -106,9 +158,32 @@ func (s *Status) WriteHTML(w io.Writer) error {
 		},
 	})
 
+	lastScrape := "N/A"
+	switch {
+	case e.LastFetch.IsZero():
+	case len(e.LastFetchErrors) == 0:
+		lastScrape = humanizeTime(e.LastFetch) + ", succeeded"
+	default:
+		lastScrape = fmt.Sprintf("%s, %s failed", humanizeTime(e.LastFetch), english.Plural(len(e.LastFetchErrors), "collector", ""))
+	}
+	data := struct {
+		Summary   []property
+		Errors    map[string]string
+		Endpoints []endpointStatus
+	}{
+		Summary: []property{
+			{"Version", fmt.Sprintf("%s (%s-%s) built on %s", e.Version, e.Revision, e.Branch, e.Built)},
+			{"Hostname", humanizeString(e.Hostname)},
+			{"Collectors", humanizeList(e.Collectors)},
+			{"Last scrape", lastScrape},
+		},
+		Errors:    e.LastFetchErrors,
+		Endpoints: endpoints,
+	}
+
 	tmpl, err := template.New("html").Parse(statusHtmlTemplate)
 	if err == nil {
-		err = tmpl.Execute(w, endpoints)
+		err = tmpl.Execute(w, data)
 		if err == nil {
 			return nil
 		}
@@ -125,6 +200,14 @@ func humanizeList(a []string) string {
 	return english.OxfordWordSeries(a, "and")
 }
 
+func humanizeString(s string) string {
+	if s == "" {
+		return "N/A"
+	}
+
+	return s
+}
+
 func humanizeTime(t time.Time) string {
 	if t.IsZero() {
 		return "N/A"
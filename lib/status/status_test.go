@@ -1,11 +1,15 @@
 package status
 
 import (
+	"bytes"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/pedropombeiro/qnapexporter/lib/exporter"
 )
 
 func TestWriteHTML(t *testing.T) {
@@ -13,3 +17,67 @@ func TestWriteHTML(t *testing.T) {
 	err := s.WriteHTML(os.Stderr)
 	require.NoError(t, err)
 }
+
+func TestWriteHTMLSummary(t *testing.T) {
+	s := Status{
+		MetricsEndpoint: "/metrics",
+		HealthEndpoint:  "/healthz",
+		ExporterStatus: exporter.Status{
+			Version:         "1.2.3",
+			Revision:        "abcdef",
+			Branch:          "main",
+			Built:           "today",
+			Hostname:        "nas1",
+			Collectors:      []string{"cpu", "hd"},
+			LastFetch:       time.Now(),
+			LastFetchErrors: map[string]string{"hd": "exit status 1"},
+		},
+	}
+	var buf bytes.Buffer
+
+	require.NoError(t, s.WriteHTML(&buf))
+
+	html := buf.String()
+	assert.Contains(t, html, "1.2.3 (abcdef-main) built on today")
+	assert.Contains(t, html, "<td>nas1</td>")
+	assert.Contains(t, html, "<td>cpu and hd</td>")
+	assert.Contains(t, html, "now, 1 collector failed")
+	assert.Contains(t, html, "<th>hd</th>")
+	assert.Contains(t, html, "<td>exit status 1</td>")
+	assert.Contains(t, html, `<a href="/metrics">`)
+	assert.Contains(t, html, `<a href="/healthz">`)
+}
+
+func TestWriteHTMLBeforeFirstScrape(t *testing.T) {
+	s := Status{MetricsEndpoint: "/metrics"}
+	var buf bytes.Buffer
+
+	require.NoError(t, s.WriteHTML(&buf))
+
+	assert.Contains(t, buf.String(), "<th>Last scrape</th>\n\t\t\t\t<td>N/A</td>")
+	assert.NotContains(t, buf.String(), "Collector errors")
+}
+
+func TestWriteHTMLReadiness(t *testing.T) {
+	tests := map[string]struct {
+		status exporter.Status
+		want   string
+	}{
+		"reading":  {want: "Reading environment"},
+		"ready":    {status: exporter.Status{EnvironmentRead: time.Now()}, want: "OK"},
+		"degraded": {status: exporter.Status{EnvironmentRead: time.Now(), EnvironmentError: "read environment: get disk count: exit status 1"}, want: "read environment: get disk count: exit status 1"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := Status{ReadinessEndpoint: "/readyz", ExporterStatus: tt.status}
+			var buf bytes.Buffer
+
+			require.NoError(t, s.WriteHTML(&buf))
+
+			html := buf.String()
+			assert.Contains(t, html, `<a href="/readyz">`)
+			assert.Contains(t, html, "<td>"+tt.want+"</td>")
+		})
+	}
+}
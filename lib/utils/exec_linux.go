@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setProcessGroup starts the command in a process group of its own
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills every process in the group led by p
+func killProcessGroup(p *os.Process) {
+	_ = syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
+
+// CountChildProcesses returns the number of child processes of the current process, and how many of them are
+// zombies which haven't been reaped
+func CountChildProcesses() (children, zombies int, err error) {
+	paths, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pid := os.Getpid()
+	for _, path := range paths {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			// The process exited since the glob
+			continue
+		}
+		state, ppid, ok := parseProcStat(string(contents))
+		if !ok || ppid != pid {
+			continue
+		}
+		children++
+		if state == "Z" {
+			zombies++
+		}
+	}
+
+	return children, zombies, nil
+}
+
+// parseProcStat returns the state and parent PID in the contents of /proc/<pid>/stat, after the command name
+// which may contain spaces and parentheses
+func parseProcStat(stat string) (state string, ppid int, ok bool) {
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return "", 0, false
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 2 {
+		return "", 0, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, false
+	}
+
+	return fields[0], ppid, true
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// processAlive returns whether the process is running, i.e. exists and isn't a zombie
+func processAlive(pid int) bool {
+	contents, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	state, _, ok := parseProcStat(string(contents))
+
+	return ok && state != "Z"
+}
+
+func TestExecCommandContextKillsGrandchildren(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	// The sleeps don't inherit the output pipes, so that the call returns as soon as the shell is killed, even if
+	// they survive it
+	cmd := fakeCommand(t, fmt.Sprintf("sleep 60 >/dev/null 2>&1 &\necho $! > %s\nsleep 60 >/dev/null 2>&1", pidFile))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := ExecCommandContext(ctx, cmd)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	contents, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	require.NoError(t, err)
+	defer func() { _ = syscall.Kill(pid, syscall.SIGKILL) }()
+	assert.Eventually(t, func() bool { return !processAlive(pid) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCountChildProcesses(t *testing.T) {
+	before, beforeZombies, err := CountChildProcesses()
+	require.NoError(t, err)
+
+	c := exec.Command("sleep", "30")
+	require.NoError(t, c.Start())
+	defer func() {
+		_ = c.Process.Kill()
+		_ = c.Wait()
+	}()
+
+	children, zombies, err := CountChildProcesses()
+	require.NoError(t, err)
+	assert.Equal(t, before+1, children)
+	assert.Equal(t, beforeZombies, zombies)
+
+	// Until it is reaped, the killed child is a zombie
+	require.NoError(t, c.Process.Kill())
+	assert.Eventually(t, func() bool {
+		_, zombies, _ := CountChildProcesses()
+		return zombies == beforeZombies+1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestParseProcStat(t *testing.T) {
+	state, ppid, ok := parseProcStat("1234 (my (odd) cmd) S 42 1234 1234 0 -1 4194560")
+	require.True(t, ok)
+	assert.Equal(t, "S", state)
+	assert.Equal(t, 42, ppid)
+
+	_, _, ok = parseProcStat("1234 (truncated")
+	assert.False(t, ok)
+}
@@ -0,0 +1,19 @@
+// +build !linux
+
+package utils
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(c *exec.Cmd) {}
+
+func killProcessGroup(p *os.Process) {
+	_ = p.Kill()
+}
+
+// CountChildProcesses isn't supported outside of Linux, reporting no child processes
+func CountChildProcesses() (children, zombies int, err error) {
+	return 0, 0, nil
+}
@@ -0,0 +1,18 @@
+package utils
+
+import "log"
+
+// DebugLogging enables the output of Debugf. It is meant to be set once at startup.
+var DebugLogging bool
+
+// DebugLogger receives the debug messages of this package, e.g. the standard error of the commands which fail
+var DebugLogger *log.Logger
+
+// Debugf prints a debug message to logger, if debug logging is enabled
+func Debugf(logger *log.Logger, format string, v ...interface{}) {
+	if !DebugLogging || logger == nil {
+		return
+	}
+
+	logger.Printf("DEBUG: "+format, v...)
+}
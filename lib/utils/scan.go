@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// DefaultMaxLineLength is the default value of LineLimits.MaxLineLength
+	DefaultMaxLineLength = 64 * 1024
+	// DefaultMaxBytes is the default value of LineLimits.MaxBytes
+	DefaultMaxBytes = 1024 * 1024
+)
+
+var (
+	// ErrLineTooLong is returned by ScanFileLines when a line exceeds LineLimits.MaxLineLength
+	ErrLineTooLong = errors.New("line too long")
+	// ErrFileTooLarge is returned by ScanFileLines when a file exceeds LineLimits.MaxBytes
+	ErrFileTooLarge = errors.New("file too large")
+)
+
+// LineLimits bounds the memory used by ScanFileLines
+type LineLimits struct {
+	// MaxLineLength is the maximum length of a line, in bytes (DefaultMaxLineLength, if zero)
+	MaxLineLength int
+	// MaxBytes is the maximum size of the file, in bytes (DefaultMaxBytes, if zero)
+	MaxBytes int64
+}
+
+func (l LineLimits) withDefaults() LineLimits {
+	if l.MaxLineLength <= 0 {
+		l.MaxLineLength = DefaultMaxLineLength
+	}
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultMaxBytes
+	}
+
+	return l
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// ScanFileLines reads a file line by line, calling fn with each non-blank line stripped of its trailing white space,
+// without holding more than a line in memory. It stops at the first error returned by fn, or once the file
+// exceeds the limits, in which case fn may have been called for the preceding lines.
+func ScanFileLines(f string, limits LineLimits, fn func(line string) error) error {
+	limits = limits.withDefaults()
+
+	file, err := os.Open(f)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Reading one byte past the limit tells a file of exactly MaxBytes apart from a larger one
+	r := &countingReader{r: io.LimitReader(file, limits.MaxBytes+1)}
+	scanner := bufio.NewScanner(r)
+	bufSize := 4096
+	if limits.MaxLineLength < bufSize {
+		bufSize = limits.MaxLineLength
+	}
+	// The scanner buffer also holds the line terminator
+	scanner.Buffer(make([]byte, 0, bufSize), limits.MaxLineLength+1)
+
+	for scanner.Scan() {
+		if r.n > limits.MaxBytes {
+			return fmt.Errorf("read %s: %w (more than %d bytes)", f, ErrFileTooLarge, limits.MaxBytes)
+		}
+		if len(scanner.Bytes()) > limits.MaxLineLength {
+			// The last line, which isn't followed by a terminator
+			return fmt.Errorf("read %s: %w (more than %d bytes)", f, ErrLineTooLong, limits.MaxLineLength)
+		}
+
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("read %s: %w (more than %d bytes)", f, ErrLineTooLong, limits.MaxLineLength)
+		}
+
+		return fmt.Errorf("read %s: %w", f, err)
+	}
+	if r.n > limits.MaxBytes {
+		return fmt.Errorf("read %s: %w (more than %d bytes)", f, ErrFileTooLarge, limits.MaxBytes)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t testing.TB, contents string) string {
+	path := filepath.Join(t.TempDir(), "stats")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+
+	return path
+}
+
+func scanLines(path string, limits LineLimits) ([]string, error) {
+	var lines []string
+	err := ScanFileLines(path, limits, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+
+	return lines, err
+}
+
+func TestScanFileLines(t *testing.T) {
+	tests := map[string]struct {
+		contents string
+		limits   LineLimits
+		want     []string
+		wantErr  error
+	}{
+		"trailing white space": {contents: "reads: 10 \r\n\nwrites: 20\n\n", want: []string{"reads: 10", "writes: 20"}},
+		"no trailing newline":  {contents: "reads: 10\nwrites: 20", want: []string{"reads: 10", "writes: 20"}},
+		"empty":                {contents: ""},
+		"line at limit":        {contents: "12345\n123\n", limits: LineLimits{MaxLineLength: 5}, want: []string{"12345", "123"}},
+		"line too long":        {contents: "123\n123456\n", limits: LineLimits{MaxLineLength: 5}, want: []string{"123"}, wantErr: ErrLineTooLong},
+		"last line too long":   {contents: "123\n123456", limits: LineLimits{MaxLineLength: 5}, want: []string{"123"}, wantErr: ErrLineTooLong},
+		"file at limit":        {contents: "1234\n6789", limits: LineLimits{MaxBytes: 9}, want: []string{"1234", "6789"}},
+		"file too large":       {contents: "1234\n6789\n", limits: LineLimits{MaxBytes: 9}, wantErr: ErrFileTooLarge},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			lines, err := scanLines(writeFile(t, tt.contents), tt.limits)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, lines)
+		})
+	}
+}
+
+func TestScanFileLinesCallbackError(t *testing.T) {
+	errStop := errors.New("stop")
+	var calls int
+	err := ScanFileLines(writeFile(t, "a\nb\nc\n"), LineLimits{}, func(line string) error {
+		calls++
+		return errStop
+	})
+
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestScanFileLinesNotFound(t *testing.T) {
+	_, err := scanLines(filepath.Join(t.TempDir(), "missing"), LineLimits{})
+
+	assert.True(t, os.IsNotExist(err))
+}
+
+// largeStatsFile writes a synthetic statistics file of about 512KiB
+func largeStatsFile(b *testing.B) string {
+	var sb strings.Builder
+	for i := 0; sb.Len() < 512*1024; i++ {
+		fmt.Fprintf(&sb, "statistic_%d: %d\n", i, i*1000)
+	}
+
+	return writeFile(b, sb.String())
+}
+
+func BenchmarkReadFileLines(b *testing.B) {
+	path := largeStatsFile(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		lines, err := ReadFileLines(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, line := range lines {
+			_ = strings.SplitN(line, ":", 2)
+		}
+	}
+}
+
+func BenchmarkScanFileLines(b *testing.B) {
+	path := largeStatsFile(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := ScanFileLines(path, LineLimits{}, func(line string) error {
+			_ = strings.SplitN(line, ":", 2)
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
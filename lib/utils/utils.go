@@ -1,11 +1,21 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// DefaultCommandTimeout is the default value of CommandTimeout
+const DefaultCommandTimeout = 10 * time.Second
+
 // ReadFile reads the entire contents of a file as a string
 func ReadFile(f string) (string, error) {
 	contents, err := os.ReadFile(f)
@@ -13,7 +23,7 @@ func ReadFile(f string) (string, error) {
 		return "", err
 	}
 
-	return strings.TrimSpace(string(contents)), nil
+	return trimOutput(string(contents)), nil
 }
 
 // ReadFileLines reads the entire contents of a file as an array of lines
@@ -23,33 +33,166 @@ func ReadFileLines(f string) ([]string, error) {
 		return nil, err
 	}
 
-	return strings.Split(contents, "\n"), nil
+	return SplitLines(contents), nil
+}
+
+// trimOutput strips the leading and trailing white space, including the final newline, from the output of a file
+// or command, so that parsers don't need to
+func trimOutput(output string) string {
+	return strings.TrimSpace(output)
 }
 
-// ExecCommand executes a command and returns the standard output, as well as any error
+// SplitLines splits trimmed output into lines, without their trailing carriage returns
+func SplitLines(output string) []string {
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+
+	return lines
+}
+
+// CommandError is the error returned when a command exits with a non-zero status
+type CommandError struct {
+	// Command is the name of the command, as passed to ExecCommand
+	Command  string
+	ExitCode int
+	// Stderr holds the trimmed standard error of the command
+	Stderr string
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s exited with status %d", e.Command, e.ExitCode)
+	if line := strings.SplitN(e.Stderr, "\n", 2)[0]; line != "" {
+		msg += ": " + strings.TrimSpace(line)
+	}
+
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// CommandLogger, if set, receives the arguments, raw standard output and error, and exit status of every command
+// executed by ExecCommand. It is meant to be set once at startup, e.g. when debugging a collector.
+var CommandLogger *log.Logger
+
+// CommandTimeout bounds the duration of the commands executed without a context deadline
+var CommandTimeout = DefaultCommandTimeout
+
+// ExecCommand executes a command and returns the standard output, as well as any error.
+// The command is killed if it doesn't complete within CommandTimeout.
 func ExecCommand(cmd string, args ...string) (string, error) {
-	var (
-		err    error
-		output []byte
-	)
+	return ExecCommandContext(context.Background(), cmd, args...)
+}
+
+// ExecCommandContext executes a command and returns the trimmed standard output, as well as any error.
+// A non-zero exit status is reported as a *CommandError.
+// If ctx has no deadline, CommandTimeout applies. Once ctx is done, the process group of the command is killed,
+// so that any process it spawned doesn't outlive it.
+func ExecCommandContext(ctx context.Context, cmd string, args ...string) (string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, CommandTimeout)
+		defer cancel()
+	}
 
-	c := exec.Command(cmd, args...)
-	if output, err = c.Output(); err != nil {
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, cmd, args...)
+	c.Env = commandEnv()
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	setProcessGroup(c)
+
+	err := c.Start()
+	if err == nil {
+		// exec.CommandContext only kills the command itself, and Wait would then block until its children
+		// close the output pipes
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killProcessGroup(c.Process)
+			case <-done:
+			}
+		}()
+		err = c.Wait()
+		close(done)
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err != nil && ctx.Err() != nil:
+		err = fmt.Errorf("%s: %w", cmd, ctx.Err())
+	case errors.As(err, &exitErr):
+		err = &CommandError{Command: cmd, ExitCode: exitErr.ExitCode(), Stderr: trimOutput(stderr.String()), Err: err}
+	}
+	if CommandLogger != nil {
+		CommandLogger.Printf("exec %q: stdout=%q stderr=%q err=%v\n", c.Args, stdout.String(), stderr.String(), err)
+	}
+	if err != nil {
+		if stderr.Len() > 0 {
+			Debugf(DebugLogger, "%q failed (%v), standard error:\n%s\n", c.Args, err, trimOutput(stderr.String()))
+		}
 		return "", err
 	}
 
-	return strings.TrimSpace(string(output)), nil
+	return trimOutput(stdout.String()), nil
+}
+
+// commandEnv returns the environment of the exporter with the C locale, so that the output of the commands parsed
+// doesn't depend on the locale of the NAS (e.g. decimal commas with de_DE)
+func commandEnv() []string {
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, kv := range os.Environ() {
+		if name := strings.SplitN(kv, "=", 2)[0]; name == "LANG" || name == "LANGUAGE" || strings.HasPrefix(name, "LC_") {
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	return append(env, "LANG=C", "LC_ALL=C")
+}
+
+// ParseFloat parses a number printed by a command, also accepting the decimal commas and the thousands separators
+// of a locale which isn't C, e.g. "1.234,56" or "1,234.56"
+func ParseFloat(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	normalized := strings.NewReplacer("\u00a0", "", "\u202f", "", "'", "").Replace(s)
+	lastComma, lastDot := strings.LastIndex(normalized, ","), strings.LastIndex(normalized, ".")
+	switch {
+	case lastComma < 0:
+	case lastComma > lastDot && strings.Count(normalized, ",") == 1:
+		// The comma is the decimal separator, and the dots (if any) separate the thousands
+		normalized = strings.Replace(strings.ReplaceAll(normalized, ".", ""), ",", ".", 1)
+	default:
+		// The commas separate the thousands
+		normalized = strings.ReplaceAll(normalized, ",", "")
+	}
+
+	value, err := strconv.ParseFloat(normalized, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse number %q: %w", s, errors.Unwrap(err))
+	}
+
+	return value, nil
 }
 
 // ExecCommandGetLines executes a command and returns the standard output
 // as an array of lines, as well as any error
 func ExecCommandGetLines(cmd string, args ...string) ([]string, error) {
-	output, err := ExecCommand(cmd, args...)
+	return ExecCommandGetLinesContext(context.Background(), cmd, args...)
+}
+
+// ExecCommandGetLinesContext is like ExecCommandGetLines, with the timeout semantics of ExecCommandContext
+func ExecCommandGetLinesContext(ctx context.Context, cmd string, args ...string) ([]string, error) {
+	output, err := ExecCommandContext(ctx, cmd, args...)
 	if err != nil {
 		return nil, err
 	}
 
-	return strings.Split(output, "\n"), nil
+	return SplitLines(output), nil
 }
 
 func FindMatchingLines(token string, output string) []string {
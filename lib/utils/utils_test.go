@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCommand writes an executable shell script with the given body, returning its path
+func fakeCommand(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "fake")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755))
+
+	return path
+}
+
+func TestExecCommand(t *testing.T) {
+	cmd := fakeCommand(t, `echo "  $1 $2  "`)
+
+	output, err := ExecCommand(cmd, "hello", "world")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", output)
+
+	lines, err := ExecCommandGetLines(fakeCommand(t, "echo a; echo b"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, lines)
+}
+
+func TestExecCommandUsesCLocale(t *testing.T) {
+	t.Setenv("LANG", "de_DE.UTF-8")
+	t.Setenv("LC_NUMERIC", "de_DE.UTF-8")
+	t.Setenv("QNAP_TEST", "kept")
+
+	output, err := ExecCommand(fakeCommand(t, `echo "$LANG $LC_ALL ${LC_NUMERIC:-unset} $QNAP_TEST"`))
+	require.NoError(t, err)
+	assert.Equal(t, "C C unset kept", output)
+}
+
+func TestParseFloat(t *testing.T) {
+	testCases := map[string]float64{
+		"35":           35,
+		" 35.5 ":       35.5,
+		"35,5":         35.5,
+		"1.234,56":     1234.56,
+		"1,234.56":     1234.56,
+		"1,234,567":    1234567,
+		"1.234.567,8":  1234567.8,
+		"1\u00a0234,5": 1234.5,
+		"-0,25":        -0.25,
+		"1e3":          1000,
+	}
+	for s, want := range testCases {
+		value, err := ParseFloat(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, want, value, s)
+		}
+	}
+
+	for _, s := range []string{"", "--", "35 C", "abc"} {
+		_, err := ParseFloat(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestExecCommandFailure(t *testing.T) {
+	defer func(enabled bool, logger *log.Logger) { DebugLogging, DebugLogger = enabled, logger }(DebugLogging, DebugLogger)
+	var debugLog bytes.Buffer
+	DebugLogging, DebugLogger = true, log.New(&debugLog, "", 0)
+
+	cmd := fakeCommand(t, "echo partial output\necho 'first error  ' >&2\necho 'second error' >&2\nexit 3")
+
+	output, err := ExecCommand(cmd, "hdtmp", "1")
+
+	assert.Empty(t, output)
+	var cmdErr *CommandError
+	require.ErrorAs(t, err, &cmdErr)
+	assert.Equal(t, 3, cmdErr.ExitCode)
+	assert.Equal(t, "first error  \nsecond error", cmdErr.Stderr)
+	assert.EqualError(t, err, cmd+" exited with status 3: first error")
+	var exitErr *exec.ExitError
+	assert.ErrorAs(t, err, &exitErr)
+	assert.Contains(t, debugLog.String(), "first error  \nsecond error\n")
+}
+
+func TestExecCommandFailureWithoutStderr(t *testing.T) {
+	cmd := fakeCommand(t, "exit 1")
+
+	_, err := ExecCommand(cmd)
+
+	assert.EqualError(t, err, cmd+" exited with status 1")
+}
+
+func TestExecCommandNotFound(t *testing.T) {
+	_, err := ExecCommand("qnapexporter-nonexistent-binary")
+
+	assert.ErrorIs(t, err, exec.ErrNotFound)
+	var cmdErr *CommandError
+	assert.False(t, errors.As(err, &cmdErr))
+}
+
+func TestExecCommandGetLinesTrimsOutput(t *testing.T) {
+	lines, err := ExecCommandGetLines(fakeCommand(t, `printf '\n a\r\nb \n\n'`))
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, lines)
+}
+
+func TestExecCommandContextTimeout(t *testing.T) {
+	// The background sleep keeps standard output open, so that the call only returns early if the whole
+	// process group is killed
+	cmd := fakeCommand(t, "sleep 30 &\nsleep 30")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	output, err := ExecCommandContext(ctx, cmd)
+
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Empty(t, output)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestExecCommandDefaultTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { CommandTimeout = timeout }(CommandTimeout)
+	CommandTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := ExecCommandGetLinesContext(context.Background(), fakeCommand(t, "sleep 30"))
+
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestExecCommandContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ExecCommandContext(ctx, fakeCommand(t, "echo unreachable"))
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
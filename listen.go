@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixListenPrefix prefixes the listen addresses which are paths of Unix domain sockets
+const unixListenPrefix = "unix:"
+
+// listenFlags collects repeated --listen flags, each either host:port or unix:/path/to.sock
+type listenFlags []string
+
+func (l *listenFlags) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listenFlags) Values() []string {
+	return *l
+}
+
+func (l *listenFlags) Set(value string) error {
+	if strings.HasPrefix(value, unixListenPrefix) {
+		if value == unixListenPrefix {
+			return fmt.Errorf("invalid listen address %q, expected unix:/path/to.sock", value)
+		}
+	} else if _, _, err := net.SplitHostPort(value); err != nil {
+		return fmt.Errorf("invalid listen address %q, expected host:port or unix:/path/to.sock", value)
+	}
+
+	*l = append(*l, value)
+	return nil
+}
+
+// parseSocketMode parses the octal permissions of the Unix domain sockets, e.g. 0660
+func parseSocketMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid socket mode %q, expected octal permissions such as 0660", s)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// openListeners listens on every address, setting the permissions of the Unix domain sockets to socketMode. The
+// socket files are removed when their listener is closed. If any address can't be listened on, the listeners
+// already open are closed.
+func openListeners(addresses []string, socketMode os.FileMode) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		l, err := openListener(address, socketMode)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("listen on %s: %w", address, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+func openListener(address string, socketMode os.FileMode) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixListenPrefix) {
+		return net.Listen("tcp", address)
+	}
+	path := strings.TrimPrefix(address, unixListenPrefix)
+
+	// Remove the socket left behind by an exporter which didn't exit cleanly, but never another kind of file
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New("file exists and isn't a socket")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// listenerAddress describes the address l listens on, as passed to --listen
+func listenerAddress(l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return unixListenPrefix + l.Addr().String()
+	}
+
+	return l.Addr().String()
+}
@@ -2,60 +2,467 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/pedropombeiro/qnapexporter/lib/config"
 	"github.com/pedropombeiro/qnapexporter/lib/exporter"
 	"github.com/pedropombeiro/qnapexporter/lib/exporter/prometheus"
+	"github.com/pedropombeiro/qnapexporter/lib/httpclient"
 	"github.com/pedropombeiro/qnapexporter/lib/notifications"
 	"github.com/pedropombeiro/qnapexporter/lib/notifications/tagextractor"
+	"github.com/pedropombeiro/qnapexporter/lib/push"
+	"github.com/pedropombeiro/qnapexporter/lib/sdnotify"
+	"github.com/pedropombeiro/qnapexporter/lib/sources"
 	"github.com/pedropombeiro/qnapexporter/lib/status"
 	"github.com/pedropombeiro/qnapexporter/lib/utils"
 )
 
 const (
 	metricsEndpoint      = "/metrics"
+	healthEndpoint       = "/healthz"
+	readinessEndpoint    = "/readyz"
 	notificationEndpoint = "/notification"
+	annotationEndpoint   = "/annotation"
+	alertmanagerEndpoint = "/alertmanager"
+	debugVarsEndpoint    = "/debug/vars"
+	influxEndpoint       = "/metrics/influx"
+
+	// envPrefix prefixes the environment variables setting the flags (e.g. QNAPEXPORTER_GRAFANA_URL)
+	envPrefix = "QNAPEXPORTER_"
 )
 
 var (
 	healthCheckExpiry   time.Time
 	healthCheckValidity time.Duration = time.Duration(5 * time.Minute)
+
+	// defaultScrapeTimeout is the scrape timeout assumed when Prometheus doesn't send it (its default)
+	defaultScrapeTimeout = time.Duration(10 * time.Second)
+
+	regionEvictionInterval = time.Duration(1 * time.Minute)
+	childProcessInterval   = time.Duration(1 * time.Minute)
 )
 
 type httpServerArgs struct {
-	exporter    exporter.Exporter
-	port        string
-	healthcheck string
-	logger      *log.Logger
+	exporter exporter.Exporter
+	// listeners holds the sockets the HTTP requests are served on, closed on shutdown
+	listeners       []net.Listener
+	annotationToken string
+	// alertmanagerToken enables the Alertmanager webhook receiver
+	alertmanagerToken string
+	healthcheck       string
+	logger            *log.Logger
+	// debug enables the endpoint describing the internal state of the exporter
+	debug bool
+}
+
+// pushTarget is a backend the metrics are pushed to, on its own interval
+type pushTarget struct {
+	sender push.Sender
+	config push.Config
+}
+
+// headerFlags collects repeated "Name: value" flags into HTTP headers
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	headers := make([]string, 0, len(h))
+	for name, value := range h {
+		headers = append(headers, name+": "+value)
+	}
+
+	return strings.Join(headers, ", ")
+}
+
+func (h headerFlags) Values() []string {
+	headers := make([]string, 0, len(h))
+	for name, value := range h {
+		headers = append(headers, name+": "+value)
+	}
+	sort.Strings(headers)
+
+	return headers
+}
+
+func (h headerFlags) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) < 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("invalid header %q, expected format is 'Name: value'", value)
+	}
+
+	h[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	return nil
+}
+
+// regexpFlags collects repeated flags into regular expressions
+type regexpFlags []*regexp.Regexp
+
+func (r *regexpFlags) String() string {
+	patterns := make([]string, 0, len(*r))
+	for _, re := range *r {
+		patterns = append(patterns, re.String())
+	}
+
+	return strings.Join(patterns, ", ")
+}
+
+func (r *regexpFlags) Values() []string {
+	patterns := make([]string, 0, len(*r))
+	for _, re := range *r {
+		patterns = append(patterns, re.String())
+	}
+
+	return patterns
+}
+
+func (r *regexpFlags) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return fmt.Errorf("invalid regular expression %q: %w", value, err)
+	}
+
+	*r = append(*r, re)
+	return nil
+}
+
+// connLogRuleFlags collects repeated flags into rules of the QTS connection log watcher
+type connLogRuleFlags struct {
+	specs []string
+	rules []sources.ConnLogRule
+}
+
+func (c *connLogRuleFlags) String() string {
+	return strings.Join(c.specs, ", ")
+}
+
+func (c *connLogRuleFlags) Values() []string {
+	return c.specs
+}
+
+func (c *connLogRuleFlags) Set(value string) error {
+	rule, err := sources.ParseConnLogRule(value)
+	if err != nil {
+		return err
+	}
+
+	c.specs = append(c.specs, value)
+	c.rules = append(c.rules, rule)
+	return nil
 }
 
 func main() {
 	runtime.GOMAXPROCS(0)
 
-	port := flag.String("port", ":9094", "Port to serve at (e.g. :9094).")
+	port := flag.String("port", ":9094", "Port to serve at (e.g. :9094), unless --listen is set.")
+	var listen listenFlags
+	flag.Var(&listen, "listen", "Address to serve at, either host:port or unix:/path/to.sock for a Unix domain socket (can be repeated, defaults to --port).")
+	listenSocketMode := flag.String("listen-socket-mode", "0660", "Octal permissions of the Unix domain sockets served at.")
 	pingTarget := flag.String("ping-target", "", "Host to periodically ping (e.g. 1.1.1.1).")
+	pingSource := flag.String("ping-source", "", "Address or name of the interface the pings are sent from, e.g. to measure the path through a backup VLAN (defaults to empty, i.e. as routed).")
+	dnsTargets := flag.String("dns-targets", "", "Comma-separated hostnames resolved on every scrape to probe the DNS resolution (defaults to empty, i.e. disabled).")
+	dnsResolvers := flag.String("dns-resolvers", prometheus.DNSSystemResolver, "Comma-separated DNS servers (host or host:port) the --dns-targets are resolved with, where system stands for the resolver configured on the NAS.")
+	ntpServer := flag.String("ntp-server", "", "NTP server the offset of the local clock is measured against, with a single SNTP query per minute at most (e.g. pool.ntp.org).")
 	healthcheck := flag.String("healthcheck", os.Getenv("HEALTHCHECK_CONFIG"), "Healthcheck service to ping every 5 minutes (currently supported: healthchecks.io:<check-id>).")
+	httpTimeout := flag.Duration("http-timeout", httpclient.DefaultTimeout, "Timeout for each request sent by the outbound integrations (e.g. Slack, Loki, OTLP), unless overridden for the integration.")
+	httpProxy := flag.String("http-proxy", "", "Proxy URL used by the outbound integrations (defaults to the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables).")
+	httpCAFile := flag.String("http-ca-file", "", "Path of a PEM file with additional certificate authorities to trust for the outbound integrations.")
+	httpInsecure := flag.Bool("http-insecure-skip-verify", false, "Disable the verification of the TLS certificates of the outbound integrations.")
+	httpMaxIdleConnsPerHost := flag.Int("http-max-idle-conns-per-host", httpclient.DefaultMaxIdleConnsPerHost, "Number of keep-alive connections the outbound integrations keep open to each host.")
+	httpIdleConnTimeout := flag.Duration("http-idle-conn-timeout", httpclient.DefaultIdleConnTimeout, "Time an unused keep-alive connection of the outbound integrations is kept open.")
 	grafanaURL := flag.String("grafana-url", os.Getenv("GRAFANA_URL"), "Grafana host (e.g.: https://grafana.example.com).")
 	grafanaAuthToken := flag.String("grafana-auth-token", os.Getenv("GRAFANA_AUTH_TOKEN"), "Grafana authorization token.")
-	grafanaTags := flag.String("grafana-tags", os.Getenv("GRAFANA_TAGS"), "Grafana annotation tags, separated by quotes (default: 'nas').")
+	grafanaTokenFile := flag.String("grafana-token-file", os.Getenv("GRAFANA_TOKEN_FILE"), "Path of a file containing the Grafana authorization token, reloaded when it changes or on SIGHUP.")
+	grafanaUsername := flag.String("grafana-username", os.Getenv("GRAFANA_USERNAME"), "Grafana username for basic authentication (only used if no token is configured).")
+	grafanaPassword := flag.String("grafana-password", os.Getenv("GRAFANA_PASSWORD"), "Grafana password for basic authentication.")
+	grafanaOrgID := flag.Int64("grafana-org-id", 0, "Grafana organization ID to post annotations to (defaults to the user's current organization).")
+	grafanaTags := flag.String("grafana-tags", os.Getenv("GRAFANA_TAGS"), "Grafana annotation tags, separated by quotes (default: 'nas'). Tags can contain templates such as {{.Hostname}}.")
+	grafanaDashboardUID := flag.String("grafana-dashboard-uid", os.Getenv("GRAFANA_DASHBOARD_UID"), "UID of the Grafana dashboard to restrict annotations to (defaults to empty, i.e. global annotations).")
+	grafanaPanelID := flag.Int("grafana-panel-id", 0, "ID of the Grafana panel to restrict annotations to (requires --grafana-dashboard-uid).")
+	grafanaCAFile := flag.String("grafana-ca-file", os.Getenv("GRAFANA_CA_FILE"), "Path of a PEM file with additional certificate authorities to trust for Grafana.")
+	grafanaInsecure := flag.Bool("grafana-insecure-skip-verify", false, "Disable the verification of the Grafana TLS certificate.")
+	grafanaProxy := flag.String("grafana-proxy", os.Getenv("GRAFANA_PROXY"), "Proxy URL used to reach Grafana (defaults to the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables).")
+	grafanaTimeout := flag.Duration("grafana-timeout", notifications.DefaultTimeout, "Timeout for each request to Grafana.")
+	grafanaRetries := flag.Int("grafana-retries", 3, "Number of retries for Grafana requests failing with connection errors or HTTP 5xx.")
+	grafanaRetryBackoff := flag.Duration("grafana-retry-backoff", notifications.DefaultRetryBackoff, "Delay before the first Grafana retry, doubled on every subsequent retry.")
+	grafanaRetryMaxBackoff := flag.Duration("grafana-retry-max-backoff", notifications.DefaultRetryMaxBackoff, "Maximum delay between Grafana retries.")
+	grafanaRetryJitter := flag.Float64("grafana-retry-jitter", 0.2, "Fraction (0-1) of each Grafana retry delay that is randomized.")
+	grafanaTextPrefix := flag.String("grafana-text-prefix", os.Getenv("GRAFANA_TEXT_PREFIX"), "Prefix added to the text of Grafana annotations, which can contain templates such as {{.Hostname}}.")
+	grafanaTextSuffix := flag.String("grafana-text-suffix", os.Getenv("GRAFANA_TEXT_SUFFIX"), "Suffix added to the text of Grafana annotations, which can contain templates such as {{.Hostname}}.")
+	grafanaFilterTags := flag.String("grafana-filter-tags", "", "Only send notifications with at least one of these comma-separated tags to Grafana (defaults to empty, i.e. all notifications).")
+	grafanaCacheFile := flag.String("grafana-cache-file", "", "Path of a file where open Grafana annotation regions are persisted across restarts (defaults to empty, i.e. in-memory only).")
+	grafanaCacheSize := flag.Int("grafana-cache-size", 20, "Maximum number of open Grafana annotation regions kept in the cache.")
+	grafanaCacheMaxAge := flag.Duration("grafana-cache-max-age", 24*time.Hour, "Maximum age of open Grafana annotation regions, after which they are evicted from the cache.")
+	grafanaCacheRebuildWindow := flag.Duration("grafana-cache-rebuild-window", 0, "On startup, look for Grafana annotations created within this window which are still open (defaults to 0, i.e. disabled).")
+	var grafanaRegionPatterns regexpFlags
+	flag.Var(&grafanaRegionPatterns, "grafana-region-pattern", "Regular expression whose first capture group identifies the region of an annotation, so that start and end events with the same tags and identifier form a region even when their texts differ (can be repeated).")
+	grafanaRetention := flag.Duration("grafana-retention", 0, "Delete the Grafana annotations posted by the exporter once they are older than this, checking daily (defaults to 0, i.e. annotations are never deleted).")
+	slackWebhookURL := flag.String("slack-webhook-url", os.Getenv("SLACK_WEBHOOK_URL"), "Slack incoming webhook URL to post notifications to.")
+	slackChannel := flag.String("slack-channel", os.Getenv("SLACK_CHANNEL"), "Slack channel overriding the webhook's default channel (e.g. #nas).")
+	slackUsername := flag.String("slack-username", "", "Username shown for Slack messages (defaults to the webhook's configured name).")
+	slackIcon := flag.String("slack-icon", "", "Emoji (e.g. :floppy_disk:) or image URL shown as the Slack message icon.")
+	slackRetries := flag.Int("slack-retries", 3, "Number of retries for Slack requests which are rate limited.")
+	slackFilterTags := flag.String("slack-filter-tags", "", "Only send notifications with at least one of these comma-separated tags to Slack (e.g. error,ups).")
+	telegramBotToken := flag.String("telegram-bot-token", os.Getenv("TELEGRAM_BOT_TOKEN"), "Telegram bot token used to post notifications.")
+	telegramChatID := flag.String("telegram-chat-id", os.Getenv("TELEGRAM_CHAT_ID"), "Telegram chat ID (or @channel) to post notifications to.")
+	telegramRetries := flag.Int("telegram-retries", 3, "Number of retries for Telegram requests failing with connection errors, HTTP 5xx or rate limits.")
+	telegramFilterTags := flag.String("telegram-filter-tags", "", "Only send notifications with at least one of these comma-separated tags to Telegram.")
+	webhookURL := flag.String("webhook-url", os.Getenv("WEBHOOK_URL"), "URL of a generic webhook to post notifications to as JSON.")
+	webhookTemplateFile := flag.String("webhook-template-file", os.Getenv("WEBHOOK_TEMPLATE_FILE"), "Path of a Go template file rendering the JSON body posted to the webhook (defaults to a body with the text, tags, time and hostname).")
+	webhookHeaders := headerFlags{}
+	flag.Var(webhookHeaders, "webhook-header", "Extra HTTP header sent to the webhook, in the 'Name: value' format (can be repeated).")
+	webhookRetries := flag.Int("webhook-retries", 3, "Number of retries for webhook requests failing with connection errors or HTTP 5xx.")
+	webhookTest := flag.Bool("webhook-test", false, "Send a sample notification to the webhook and exit.")
+	webhookFilterTags := flag.String("webhook-filter-tags", "", "Only send notifications with at least one of these comma-separated tags to the webhook.")
+	mqttBrokerURL := flag.String("mqtt-broker-url", os.Getenv("MQTT_BROKER_URL"), "MQTT broker to publish notifications to (e.g. tcp://broker:1883 or ssl://broker:8883).")
+	mqttUsername := flag.String("mqtt-username", os.Getenv("MQTT_USERNAME"), "MQTT username.")
+	mqttPassword := flag.String("mqtt-password", os.Getenv("MQTT_PASSWORD"), "MQTT password.")
+	mqttCAFile := flag.String("mqtt-ca-file", os.Getenv("MQTT_CA_FILE"), "Path of a PEM file with additional certificate authorities to trust for the MQTT broker.")
+	mqttInsecure := flag.Bool("mqtt-insecure-skip-verify", false, "Disable the verification of the MQTT broker TLS certificate.")
+	mqttTopic := flag.String("mqtt-topic", notifications.DefaultMQTTBaseTopic, "Base MQTT topic, events are published to <topic>/<first tag>.")
+	mqttQoS := flag.Uint("mqtt-qos", 0, "MQTT QoS level (0, 1 or 2).")
+	mqttRetain := flag.Bool("mqtt-retain", false, "Publish MQTT messages with the retain flag.")
+	mqttFilterTags := flag.String("mqtt-filter-tags", "", "Only publish notifications with at least one of these comma-separated tags to MQTT.")
+	lokiURL := flag.String("loki-url", os.Getenv("LOKI_URL"), "Loki base URL to push notifications to as log lines (e.g. http://loki:3100).")
+	lokiUsername := flag.String("loki-username", os.Getenv("LOKI_USERNAME"), "Loki username for basic authentication.")
+	lokiPassword := flag.String("loki-password", os.Getenv("LOKI_PASSWORD"), "Loki password for basic authentication.")
+	lokiTenantID := flag.String("loki-tenant-id", os.Getenv("LOKI_TENANT_ID"), "Loki tenant ID, sent as the X-Scope-OrgID header.")
+	lokiBatchSize := flag.Int("loki-batch-size", notifications.DefaultLokiBatchSize, "Maximum number of log lines pushed to Loki in a single request.")
+	lokiBatchWait := flag.Duration("loki-batch-wait", notifications.DefaultLokiBatchWait, "Maximum time a log line waits before being pushed to Loki.")
+	lokiRetries := flag.Int("loki-retries", 3, "Number of additional attempts to push a batch to Loki after a connection error or HTTP 5xx response.")
+	lokiFilterTags := flag.String("loki-filter-tags", "", "Only push notifications with at least one of these comma-separated tags to Loki.")
+	otlpEndpoint := flag.String("otlp-endpoint", os.Getenv("OTLP_ENDPOINT"), "URL of an OpenTelemetry collector to push the metrics to over OTLP/HTTP, e.g. http://collector:4318 (defaults to empty, i.e. disabled).")
+	otlpHeaders := headerFlags{}
+	flag.Var(otlpHeaders, "otlp-header", "Extra HTTP header sent to the OpenTelemetry collector, e.g. for authentication, in the 'Name: value' format (can be repeated).")
+	otlpInterval := flag.Duration("otlp-interval", push.DefaultInterval, "Interval between two pushes of the metrics to the OpenTelemetry collector.")
+	otlpRetries := flag.Int("otlp-retries", 3, "Number of additional attempts to push the metrics to the OpenTelemetry collector after a connection error or a retryable HTTP response, after which they are dropped.")
+	influxURL := flag.String("influx-url", os.Getenv("INFLUX_URL"), "Base URL of an InfluxDB v2 server to push the metrics to as line protocol, e.g. http://influxdb:8086 (defaults to empty, i.e. disabled).")
+	influxToken := flag.String("influx-token", os.Getenv("INFLUX_TOKEN"), "InfluxDB API token.")
+	influxOrg := flag.String("influx-org", os.Getenv("INFLUX_ORG"), "InfluxDB organization the metrics are written to.")
+	influxBucket := flag.String("influx-bucket", os.Getenv("INFLUX_BUCKET"), "InfluxDB bucket the metrics are written to.")
+	influxInterval := flag.Duration("influx-interval", push.DefaultInterval, "Interval between two pushes of the metrics to InfluxDB.")
+	influxRetries := flag.Int("influx-retries", 3, "Number of additional attempts to push the metrics to InfluxDB after a connection error or an HTTP 429 or 5xx response, after which they are dropped.")
+	graphiteAddress := flag.String("graphite-address", os.Getenv("GRAPHITE_ADDRESS"), "host:port of a Carbon plaintext listener to push the metrics to, e.g. carbon:2003 (defaults to empty, i.e. disabled).")
+	graphiteTemplate := flag.String("graphite-template", push.DefaultGraphiteTemplate, "Go template of the Graphite path of each metric, with the {{.Hostname}}, {{.Name}}, {{.Labels}} and {{.LabelValues}} fields.")
+	graphiteInterval := flag.Duration("graphite-interval", push.DefaultInterval, "Interval between two pushes of the metrics to Graphite.")
+	graphiteRetries := flag.Int("graphite-retries", 1, "Number of additional attempts to push the metrics to Graphite after a connection error, reconnecting each time.")
+	graphiteBufferSize := flag.Int("graphite-buffer-size", 10000, "Maximum number of metric samples kept while Graphite is unreachable to push them later, after which the oldest are dropped.")
+	zabbixAddress := flag.String("zabbix-address", os.Getenv("ZABBIX_ADDRESS"), "host:port of a Zabbix server or proxy trapper to push the metrics to as trapper items, e.g. zabbix:10051 (defaults to empty, i.e. disabled).")
+	zabbixHost := flag.String("zabbix-host", os.Getenv("ZABBIX_HOST"), "Name of the host the items belong to in Zabbix (defaults to the hostname).")
+	zabbixKeyPrefix := flag.String("zabbix-key-prefix", push.DefaultZabbixKeyPrefix, "Prefix of the Zabbix item keys, followed by the metric name and the label values sorted by label name as parameters.")
+	zabbixInterval := flag.Duration("zabbix-interval", push.DefaultInterval, "Interval between two pushes of the metrics to Zabbix.")
+	zabbixRetries := flag.Int("zabbix-retries", 1, "Number of additional attempts to push the metrics to Zabbix after a connection error.")
+	zabbixBufferSize := flag.Int("zabbix-buffer-size", 10000, "Maximum number of metric samples kept while Zabbix is unreachable to push them later, after which the oldest are dropped.")
+	statsdAddress := flag.String("statsd-address", os.Getenv("STATSD_ADDRESS"), "host:port of a statsd server to push the metrics to with DogStatsD tags, e.g. telegraf:8125 (defaults to empty, i.e. disabled).")
+	statsdNetwork := flag.String("statsd-network", "udp", "Protocol used to push the metrics to statsd, either udp or tcp.")
+	statsdMTU := flag.Int("statsd-mtu", push.DefaultStatsdMTU, "Maximum size in bytes of the packets sent to statsd, into which the metric lines are batched.")
+	statsdCounterDeltas := flag.Bool("statsd-counter-deltas", false, "Push the counters to statsd as counters of their increase since the previous push, instead of gauges of their current value.")
+	statsdInterval := flag.Duration("statsd-interval", push.DefaultInterval, "Interval between two pushes of the metrics to statsd.")
+	notifyTimeout := flag.Duration("notify-timeout", 30*time.Second, "Maximum time spent delivering a notification to all the backends.")
+	notifyRequireAll := flag.Bool("notify-require-all", false, "Consider a notification failed if any backend fails, rather than only if all of them fail.")
+	notifyQueueSize := flag.Int("notify-queue-size", 0, "Deliver notifications asynchronously from a queue holding up to this many notifications (defaults to 0, i.e. synchronous delivery).")
+	notifyQueueJournalDir := flag.String("notify-queue-journal-dir", "", "Directory where queued notifications are spilled when the queue is full or they can't be delivered, and saved on shutdown (defaults to empty, i.e. in-memory only).")
+	notifyQueueJournalSize := flag.Int("notify-queue-journal-size", notifications.DefaultJournalMaxEntries, "Maximum number of notifications kept in each journal, above which the oldest ones are evicted.")
+	notifyQueueRetries := flag.Int("notify-queue-retries", 3, "Number of additional delivery attempts for queued notifications.")
+	notifyShutdownTimeout := flag.Duration("notify-shutdown-timeout", 10*time.Second, "Maximum time spent delivering queued notifications on shutdown.")
+	notifyDedupWindow := flag.Duration("notify-dedup-window", 0, "Suppress notifications identical to a previous one within this window (defaults to 0, i.e. disabled).")
+	notifyRateLimit := flag.Int("notify-rate-limit", 0, "Maximum number of notifications per minute (defaults to 0, i.e. unlimited).")
+	upsAnnotations := flag.Bool("ups-annotations", true, "Post a notification region while the UPS is running on battery.")
+	storageAnnotations := flag.Bool("storage-annotations", true, "Post a notification region while a volume isn't ready or an md array is degraded.")
+	thermalThresholds := flag.String("thermal-thresholds", os.Getenv("THERMAL_THRESHOLDS"), "Comma-separated temperature thresholds per sensor class (cpu, system or hd), above which a notification region is posted (e.g. cpu=85,hd=50). Classes without a temperature use a default threshold (defaults to empty, i.e. disabled).")
+	thermalDuration := flag.Duration("thermal-duration", prometheus.DefaultThermalDuration, "Time a temperature must stay above its threshold before a notification region is posted.")
+	networkAnnotations := flag.Bool("network-annotations", true, "Post a notification region while the link of a physical interface is down.")
+	diskAnnotations := flag.Bool("disk-annotations", true, "Post a notification when the SMART status of a disk changes, or a disk is added or removed.")
+	eventLog := flag.Bool("event-log", false, "Post the new events of the QTS system event log as notifications, tagged with their severity.")
+	eventLogPath := flag.String("event-log-path", sources.DefaultEventLogPath, "Path of the QTS system event log (an SQLite database, or a file with one CSV record per line).")
+	eventLogStateFile := flag.String("event-log-state-file", "", "Path of a file remembering the last QTS event posted, so that events logged while stopped are posted on startup (defaults to empty, i.e. only events logged after startup are posted).")
+	eventLogInterval := flag.Duration("event-log-interval", sources.DefaultEventLogInterval, "Interval between checks of the QTS system event log.")
+	var connLogRules connLogRuleFlags
+	flag.Var(&connLogRules, "connlog-rule", "Rule counting the new records of the QTS connection log in qnap_connlog_events_total, made of semicolon-separated settings, e.g. 'name=ssh_failed_login;service=SSH;match=(?i)login fail;action=annotate;tags=security|ssh' (can be repeated, defaults to none, i.e. the connection log isn't watched).")
+	connLogPath := flag.String("connlog-path", sources.DefaultConnLogPath, "Path of the QTS connection log (an SQLite database, or a file with one CSV record per line).")
+	connLogStateFile := flag.String("connlog-state-file", "", "Path of a file remembering the last QTS connection read, so that connections logged while stopped are read on startup (defaults to empty, i.e. only connections logged after startup are read).")
+	connLogInterval := flag.Duration("connlog-interval", sources.DefaultConnLogInterval, "Interval between checks of the QTS connection log.")
+	connLogAnnotationRate := flag.Int("connlog-annotation-rate", sources.DefaultConnLogAnnotationRate, "Maximum number of notifications per minute posted by the connection log rules with action=annotate, so that a brute-force attempt doesn't flood Grafana.")
+	annotationToken := flag.String("annotation-token", os.Getenv("ANNOTATION_TOKEN"), "Token required to post notifications to the /annotation endpoint, which is only enabled if set.")
+	alertmanagerToken := flag.String("alertmanager-token", os.Getenv("ALERTMANAGER_TOKEN"), "Token required to post Alertmanager webhook notifications to the /alertmanager endpoint, which is only enabled if set. Firing alerts open a notification region, closed once they are resolved.")
+	annotationPipe := flag.String("annotation-pipe", os.Getenv("ANNOTATION_PIPE"), "Path of a named pipe (created if missing) or file to tail, where each line written is posted as a notification, with the '[tag] text' syntax.")
+	lifecycleAnnotations := flag.Bool("lifecycle-annotations", false, "Post a notification region covering the time the exporter is running, opened on startup and closed on clean shutdown.")
+	lifecycleStateFile := flag.String("lifecycle-state-file", "", "Path of a marker file removed on clean shutdown, used to mention unclean shutdowns of the previous run in the startup notification (defaults to empty, i.e. disabled).")
+	rootFS := flag.String("path.rootfs", prometheus.DefaultRootFS, "Root of the host file system, e.g. when running in a container with the host's / mounted at /host.")
+	procFS := flag.String("path.procfs", prometheus.DefaultProcFS, "Mount point of the host procfs.")
+	sysFS := flag.String("path.sysfs", prometheus.DefaultSysFS, "Mount point of the host sysfs.")
+	commandTimeout := flag.Duration("command-timeout", utils.DefaultCommandTimeout, "Maximum time spent running each command used to collect metrics (e.g. getsysinfo), after which it is killed along with any process it spawned.")
+	networkInterfaceClasses := flag.String("network-interface-classes", prometheus.InterfaceClassPhysical, "Comma-separated classes of network interfaces to report (physical, loopback, bridges or virtual-ephemeral).")
+	networkAggregateEphemeral := flag.Bool("network-aggregate-ephemeral", true, "Report the sum of the counters of the virtual-ephemeral interfaces (veth*) as a single veth_total device, rather than a series per interface.")
+	networkSnapshot := flag.Bool("network-snapshot", false, "Read the counters of all the network interfaces at once from /proc/net/dev rather than one sysfs file per counter, timestamping them with the time of the read so that both directions of an interface match.")
+	networkAddressInfo := flag.Bool("network-address-info", false, "Also report the global addresses of the network interfaces as node_network_address_info, rather than only whether each interface has an IPv4 and an IPv6 one.")
+	childProcessThreshold := flag.Int("child-process-threshold", 20, "Log a warning when the exporter has more child processes than this, e.g. commands which outlived their timeout (0 disables the check).")
+	serveStale := flag.Bool("serve-stale", false, "Serve the metrics of the last successful scrape, with their original timestamps, when a scrape doesn't complete within the scrape timeout (qnapexporter_serving_stale is then 1).")
+	serveStaleMaxAge := flag.Duration("serve-stale-max-age", time.Minute, "Maximum age of the metrics served by --serve-stale, usually the scrape interval.")
+	seriesWarningThreshold := flag.Int("series-warning-threshold", 10000, "Log a warning once when a scrape exports more series than this, which usually means a label has too many values (0 disables the warning).")
+	collectorFailureThreshold := flag.Int("collector-failure-threshold", prometheus.DefaultBreakerThreshold, "Number of consecutive failures after which a collector is skipped for a backoff period, then run again (0 never skips collectors).")
+	collectorBackoff := flag.Duration("collector-backoff", prometheus.DefaultBreakerBackoff, "Time a collector which keeps failing is first skipped for, doubling after each failure.")
+	collectorMaxBackoff := flag.Duration("collector-max-backoff", prometheus.DefaultBreakerMaxBackoff, "Longest time a collector which keeps failing is skipped for.")
+	scrapeTimeoutHint := flag.Duration("scrape-timeout-hint", 0, "Scrape timeout configured in Prometheus, used to warn when the scrapes approach it (defaults to 0, i.e. disabled).")
+	scrapeDurationBudget := flag.Float64("scrape-duration-budget", prometheus.DefaultScrapeBudget, "Fraction of --scrape-timeout-hint above which a scrape counts as slow.")
+	scrapeDurationWarningScrapes := flag.Int("scrape-duration-warning-scrapes", prometheus.DefaultScrapeWarningScrapes, "Number of consecutive slow scrapes after which a notification is posted and node_scrape_duration_warning is set, and of fast scrapes after which it is cleared.")
+	quietHoursSpec := flag.String("quiet-hours", os.Getenv("QUIET_HOURS"), "Comma-separated local time ranges during which the collectors which spin the disks up are paused, optionally preceded by weekdays, e.g. \"Mon-Fri 23:00-07:00,Sat-Sun 01:00-09:00\" (defaults to empty, i.e. never).")
+	diskIDLabels := flag.Bool("disk-id-labels", false, "Add the stable identity of the removable disks (e.g. USB backup drives) to their metrics as an id label, from /dev/disk/by-id or the file system label, since their sdX name changes whenever they are plugged in.")
+	ethtoolStats := flag.Bool("ethtool-stats", false, "Report the NIC error and drop counters of the physical interfaces, as returned by ethtool -S.")
+	quotaStats := flag.Bool("quota-stats", false, "Report the space used by the users with the most usage of the volumes with quotas, as returned by repquota or zfs userspace.")
+	gpuStats := flag.Bool("gpu-stats", false, "Report the temperature and the current and maximum frequency of the Intel integrated GPU, e.g. to follow QuickSync transcoding.")
+	malwareScanStats := flag.Bool("malware-scan-stats", false, "Report the time and number of threats found of the last Malware Remover scan, read from the status file in the directory of the application.")
+	quotaTopUsers := flag.Int("quota-top-users", prometheus.DefaultQuotaTopUsers, "Maximum number of users whose quota usage is reported, by usage.")
+	quotaInterval := flag.Duration("quota-interval", prometheus.DefaultQuotaInterval, "Time the quota usage is cached for, since reading it is slow.")
+	loadPerCPU := flag.Bool("load-per-cpu", false, "Report the load averages divided by the number of logical CPUs (node_load1_per_cpu, node_load5_per_cpu and node_load15_per_cpu), so that a single threshold fits every model.")
+	dropLegacyMetricNames := flag.Bool("drop-legacy-metric-names", false, "Stop exporting the temperature and fan metrics under their legacy names (e.g. node_cputmp_C), once the dashboards use their new names (e.g. node_cpu_temperature_celsius).")
+	storagePoolInterval := flag.Duration("storage-pool-interval", prometheus.DefaultStoragePoolInterval, "Time the storage pools and RAID groups listed by qcli_storage are cached for.")
+	certificateFiles := flag.String("certificate-files", prometheus.DefaultCertificateFile, "Comma-separated paths of PEM files whose certificate expiry is reported (defaults to the certificate of the QTS web UI).")
+	certificateTargets := flag.String("certificate-targets", "", "Comma-separated host:port addresses whose TLS certificate expiry is reported, e.g. of reverse proxies (defaults to empty, i.e. none).")
+	getsysinfoConcurrency := flag.Int("getsysinfo-concurrency", prometheus.DefaultGetsysinfoConcurrency, "Maximum number of disks queried at once with getsysinfo (1 queries them one after the other).")
+	collectorStartSpread := flag.Duration("collector-start-spread", 0, "Stagger the start of the collectors evenly over this window on each scrape, the ones running commands first, rather than spawning all their commands at once (at most 2s; defaults to 0, i.e. all at once).")
+	runCollector := flag.String("run-collector", "", "Run the named collector once, print its metrics and the commands it executed, and exit (same as the test command).")
+	configFile := flag.String("config", "", "Path of a YAML configuration file setting any of these flags, keyed by flag name (flags set on the command line take precedence).")
+	selfCheck := flag.Bool("self-check", true, "Run each enabled collector once on startup and log a report of those which fail, with hints to fix them.")
+	strict := flag.Bool("strict", false, "Exit with a non-zero status if any enabled collector fails the startup self-check.")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration, print the effective configuration and exit.")
 	logFile := flag.String("log", "", "Log file path (defaults to empty, i.e. STDOUT).")
+	debug := flag.Bool("debug", false, "Enable debug logging, and the /debug/vars endpoint describing the internal state of the exporter.")
 	defaultUsage := flag.Usage
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "qnapexporter version %s (%s-%s) built on %s\n", utils.VERSION, utils.REVISION, utils.BRANCH, utils.BUILT)
 		fmt.Fprintln(flag.CommandLine.Output(), "")
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Commands:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  collectors [--json]  List the collectors, their prerequisites and metric families")
+		fmt.Fprintln(flag.CommandLine.Output(), "  test <collector>     Run a collector once, printing its metrics and the commands it executed")
+		fmt.Fprintln(flag.CommandLine.Output(), "  dashboard [--generic] [--title <title>] [--job <job>]")
+		fmt.Fprintln(flag.CommandLine.Output(), "                       Print a Grafana dashboard for the metrics of this NAS (of any NAS with --generic)")
+		fmt.Fprintln(flag.CommandLine.Output(), "")
 		defaultUsage()
 	}
 	flag.Parse()
+	if err := config.LoadEnv(flag.CommandLine, envPrefix, os.Environ()); err != nil {
+		log.Fatalf("Error loading configuration: %v\n", err)
+	}
+	if *configFile != "" {
+		if err := config.Load(flag.CommandLine, *configFile); err != nil {
+			log.Fatalf("Error loading configuration: %v\n", err)
+		}
+	}
+	if *checkConfig {
+		if err := config.Write(os.Stdout, flag.CommandLine, "config", "check-config"); err != nil {
+			log.Fatalf("Error printing configuration: %v\n", err)
+		}
+		os.Exit(0)
+	}
+
+	// Also bounds the commands run outside of the exporter, e.g. by the event log watcher
+	utils.CommandTimeout = *commandTimeout
+
+	classes, err := prometheus.ParseInterfaceClasses(*networkInterfaceClasses)
+	if err != nil {
+		log.Fatalf("Invalid network interface classes: %v\n", err)
+	}
+	network := prometheus.NetworkConfig{Classes: classes, AggregateEphemeral: *networkAggregateEphemeral, Snapshot: *networkSnapshot, AddressInfo: *networkAddressInfo}
+	quota := prometheus.QuotaConfig{Enabled: *quotaStats, TopUsers: *quotaTopUsers, Interval: *quotaInterval}
+	dns := prometheus.DNSConfig{Targets: splitList(*dnsTargets), Resolvers: splitList(*dnsResolvers)}
+	certificates := prometheus.CertificateConfig{Files: splitList(*certificateFiles), Targets: splitList(*certificateTargets)}
+	breaker := prometheus.BreakerConfig{Threshold: *collectorFailureThreshold, Backoff: *collectorBackoff, MaxBackoff: *collectorMaxBackoff}
+	quietHours, err := prometheus.ParseQuietHours(*quietHoursSpec)
+	if err != nil {
+		log.Fatalf("Invalid quiet hours: %v\n", err)
+	}
+	thermal, err := prometheus.ParseThermalThresholds(*thermalThresholds)
+	if err != nil {
+		log.Fatalf("Invalid temperature thresholds: %v\n", err)
+	}
+	thermal.Duration = *thermalDuration
+	// newExporterConfig builds the configuration of the exporter shared by the server and the commands, so that they
+	// run the same collectors. The server then adds the annotator and the counters of the components it runs.
+	newExporterConfig := func(logger *log.Logger) prometheus.ExporterConfig {
+		config := prometheus.ExporterConfig{
+			PingTarget:             *pingTarget,
+			PingSource:             *pingSource,
+			NTPServer:              *ntpServer,
+			DNS:                    dns,
+			Paths:                  prometheus.Paths{RootFS: *rootFS, ProcFS: *procFS, SysFS: *sysFS},
+			Network:                network,
+			DiskIDLabels:           *diskIDLabels,
+			EthtoolStats:           *ethtoolStats,
+			Quota:                  quota,
+			GPUStats:               *gpuStats,
+			MalwareScanStats:       *malwareScanStats,
+			StoragePoolInterval:    *storagePoolInterval,
+			DropLegacyMetricNames:  *dropLegacyMetricNames,
+			LoadPerCPU:             *loadPerCPU,
+			Certificates:           certificates,
+			Breaker:                breaker,
+			ScrapeDuration:         prometheus.ScrapeDurationConfig{Timeout: *scrapeTimeoutHint, Budget: *scrapeDurationBudget, Scrapes: *scrapeDurationWarningScrapes},
+			QuietHours:             quietHours,
+			CommandTimeout:         *commandTimeout,
+			GetsysinfoConcurrency:  *getsysinfoConcurrency,
+			StartSpread:            *collectorStartSpread,
+			SeriesWarningThreshold: *seriesWarningThreshold,
+			// The annotations are only posted once the server sets the annotator
+			UpsAnnotations:     *upsAnnotations,
+			StorageAnnotations: *storageAnnotations,
+			DiskAnnotations:    *diskAnnotations,
+			NetworkAnnotations: *networkAnnotations,
+			Thermal:            thermal,
+			Logger:             logger,
+			// Spare the first scrape the cost of reading the environment
+			ReadEnvironmentOnStartup: true,
+		}
+		if *serveStale {
+			config.StaleMaxAge = *serveStaleMaxAge
+		}
+
+		return config
+	}
+
+	command, commandArgs := flag.Arg(0), flag.Args()
+	if *runCollector != "" {
+		command, commandArgs = "test", []string{"test", *runCollector}
+	}
+	switch command {
+	case "":
+	case "collectors", "test", "dashboard":
+		// Commands run the collectors without starting the HTTP server, logging to STDERR in debug mode
+		commandLogger := log.New(io.Discard, "", log.LstdFlags)
+		if *debug {
+			commandLogger.SetOutput(os.Stderr)
+		}
+		exporterConfig := newExporterConfig(commandLogger)
+		var exporterStatus exporter.Status
+		e := prometheus.NewExporter(exporterConfig, &exporterStatus)
+		defer e.Close()
+
+		switch command {
+		case "collectors":
+			os.Exit(runCollectorsCommand(commandArgs[1:], e, os.Stdout, os.Stderr))
+		case "dashboard":
+			os.Exit(runDashboardCommand(commandArgs[1:], e, &exporterStatus, os.Stdout, os.Stderr))
+		default:
+			os.Exit(runTestCommand(commandArgs[1:], e, os.Stdout, os.Stderr))
+		}
+	default:
+		log.Fatalf("Unknown command %q (expected collectors, test or dashboard)\n", command)
+	}
 
 	healthCheckExpiry = time.Now()
 
@@ -70,9 +477,17 @@ func main() {
 		logWriter = lf
 	}
 	logger := log.New(logWriter, "", log.LstdFlags)
+	utils.DebugLogging = *debug
+	utils.DebugLogger = logger
+	var effectiveConfig strings.Builder
+	if err := config.Write(&effectiveConfig, flag.CommandLine, "check-config"); err == nil {
+		logger.Printf("Effective configuration:\n%s", effectiveConfig.String())
+	}
 
 	serverStatus := &status.Status{
-		MetricsEndpoint: metricsEndpoint,
+		MetricsEndpoint:   metricsEndpoint,
+		HealthEndpoint:    healthEndpoint,
+		ReadinessEndpoint: readinessEndpoint,
 		ExporterStatus: exporter.Status{
 			Branch:   utils.BRANCH,
 			Revision: utils.REVISION,
@@ -80,38 +495,417 @@ func main() {
 			Version:  utils.VERSION,
 		},
 	}
-	if *grafanaURL != "" {
+	if *grafanaURL != "" || *slackWebhookURL != "" || *telegramBotToken != "" || *webhookURL != "" || *mqttBrokerURL != "" || *lokiURL != "" {
 		serverStatus.NotificationEndpoint = notificationEndpoint
 	}
 
-	config := prometheus.ExporterConfig{
-		PingTarget: *pingTarget,
-		Logger:     logger,
+	var tokenSource *notifications.FileTokenSource
+	if *grafanaTokenFile != "" {
+		var err error
+		tokenSource, err = notifications.NewFileTokenSource(*grafanaTokenFile, logger)
+		if err != nil {
+			log.Fatalf("Error loading Grafana token: %v\n", err)
+		}
 	}
-	e := prometheus.NewExporter(config, &serverStatus.ExporterStatus)
 
-	args := httpServerArgs{
-		exporter:    e,
-		port:        *port,
-		healthcheck: *healthcheck,
-		logger:      logger,
+	// The exporter is created once the notifiers are set up, but detects the hostname used in annotations
+	var e exporter.Exporter
+	grafanaConfig := notifications.GrafanaConfig{
+		URL:       *grafanaURL,
+		AuthToken: *grafanaAuthToken,
+		Username:  *grafanaUsername,
+		Password:  *grafanaPassword,
+		OrgID:     *grafanaOrgID,
+		Timeout:   *grafanaTimeout,
+
+		CAFile:             *grafanaCAFile,
+		InsecureSkipVerify: *grafanaInsecure,
+		ProxyURL:           *grafanaProxy,
+
+		DashboardUID: *grafanaDashboardUID,
+		PanelID:      *grafanaPanelID,
+
+		Retries:         *grafanaRetries,
+		RetryBackoff:    *grafanaRetryBackoff,
+		RetryMaxBackoff: *grafanaRetryMaxBackoff,
+		RetryJitter:     *grafanaRetryJitter,
+
+		TextPrefix: *grafanaTextPrefix,
+		TextSuffix: *grafanaTextSuffix,
+		Hostname: func() string {
+			if hp, ok := e.(exporter.HostnameProvider); ok {
+				return hp.Hostname()
+			}
+			return ""
+		},
+	}
+	if tokenSource != nil {
+		grafanaConfig.AuthTokenSource = tokenSource
+	}
+	notifCenterConfig := grafanaConfig
+	notifCenterConfig.Tags = append(strings.Split(*grafanaTags, ","), "notification-center")
+	if err := notifCenterConfig.CheckTemplates(); err != nil {
+		log.Fatalf("Error in Grafana annotation templates: %v\n", err)
+	}
+	// The outbound integrations share the keep-alive connections of their clients, unless their settings differ
+	httpClients := httpclient.NewFactory(httpclient.Config{
+		Timeout:             *httpTimeout,
+		ProxyURL:            *httpProxy,
+		CAFile:              *httpCAFile,
+		InsecureSkipVerify:  *httpInsecure,
+		MaxIdleConnsPerHost: *httpMaxIdleConnsPerHost,
+		IdleConnTimeout:     *httpIdleConnTimeout,
+	})
+	httpClient := func(service string) *http.Client {
+		client, err := httpClients.Client(service, httpclient.Config{})
+		if err != nil {
+			log.Fatalf("Error creating %s HTTP client: %v\n", service, err)
+		}
+		return client
+	}
+	grafanaClient, err := httpClients.Client("Grafana", grafanaConfig.HTTPConfig())
+	if err != nil {
+		log.Fatalf("Error creating Grafana HTTP client: %v\n", err)
+	}
+
+	var matchStrategy notifications.MatchStrategy
+	if len(grafanaRegionPatterns) > 0 {
+		matchStrategy = notifications.NewTagKeyStrategy(grafanaRegionPatterns, tagextractor.NewNotificationCenterTagExtractor())
+	}
+	regionMatcher := notifications.NewRegionMatcher(*grafanaCacheSize, *grafanaCacheMaxAge, matchStrategy, logger)
+	if *grafanaCacheFile != "" {
+		regionMatcher = notifications.NewPersistentRegionMatcher(*grafanaCacheSize, *grafanaCacheFile, *grafanaCacheMaxAge, matchStrategy, logger)
 	}
 	notifCenterAnnotator := notifications.NewRegionMatchingAnnotator(
-		*grafanaURL,
-		*grafanaAuthToken,
-		append(strings.Split(*grafanaTags, ","), "notification-center"),
+		notifCenterConfig,
 		tagextractor.NewNotificationCenterTagExtractor(),
-		notifications.NewRegionMatcher(20),
-		&http.Client{Timeout: 5 * time.Second},
+		regionMatcher,
+		grafanaClient,
 		logger,
 	)
+	if *grafanaURL != "" && *grafanaCacheRebuildWindow > 0 {
+		if loader, ok := notifCenterAnnotator.(notifications.RegionLoader); ok {
+			if _, err := loader.LoadOpenRegions(time.Now().Add(-*grafanaCacheRebuildWindow)); err != nil {
+				logger.Printf("Error loading open annotations from Grafana: %v\n", err)
+			}
+		}
+	}
+	dockerConfig := grafanaConfig
+	dockerConfig.Tags = append(strings.Split(*grafanaTags, ","), "docker")
 	dockerAnnotator := notifications.NewSimpleAnnotator(
-		*grafanaURL,
-		*grafanaAuthToken,
-		append(strings.Split(*grafanaTags, ","), "docker"),
-		&http.Client{Timeout: 5 * time.Second},
+		dockerConfig,
+		grafanaClient,
 		logger,
 	)
+	var webhookConfig notifications.WebhookConfig
+	if *webhookURL != "" {
+		webhookConfig = notifications.WebhookConfig{
+			URL:     *webhookURL,
+			Headers: webhookHeaders,
+			Retries: *webhookRetries,
+		}
+		if *webhookTemplateFile != "" {
+			tmpl, err := os.ReadFile(*webhookTemplateFile)
+			if err != nil {
+				log.Fatalf("Error reading webhook template: %v\n", err)
+			}
+			webhookConfig.Template = string(tmpl)
+		}
+		// Validate the template on startup rather than on the first notification
+		if _, err := notifications.NewWebhookNotifier(webhookConfig, tagextractor.NewNoOpTagExtractor(), nil, logger); err != nil {
+			log.Fatalf("Error creating webhook notifier: %v\n", err)
+		}
+	}
+	if *webhookTest {
+		os.Exit(sendWebhookTest(webhookConfig, logger))
+	}
+
+	// Each notification source dispatches to all the configured backends
+	var notifCenterTargets, dockerTargets []notifications.NotifierTarget
+	if *grafanaURL != "" {
+		notifCenterTargets = append(notifCenterTargets, notifications.NotifierTarget{Name: "grafana", Annotator: notifCenterAnnotator, Tags: splitList(*grafanaFilterTags)})
+		dockerTargets = append(dockerTargets, notifications.NotifierTarget{Name: "grafana", Annotator: dockerAnnotator, Tags: splitList(*grafanaFilterTags)})
+	}
+	if *slackWebhookURL != "" {
+		slackConfig := notifications.SlackConfig{
+			WebhookURL: *slackWebhookURL,
+			Channel:    *slackChannel,
+			Username:   *slackUsername,
+			Icon:       *slackIcon,
+			Retries:    *slackRetries,
+		}
+		notifCenterTargets = append(notifCenterTargets, notifications.NotifierTarget{
+			Name:      "slack",
+			Annotator: notifications.NewSlackNotifier(slackConfig, tagextractor.NewNotificationCenterTagExtractor(), httpClient("Slack"), logger),
+			Tags:      splitList(*slackFilterTags),
+		})
+		dockerTargets = append(dockerTargets, notifications.NotifierTarget{
+			Name:      "slack",
+			Annotator: notifications.NewSlackNotifier(slackConfig, tagextractor.NewNoOpTagExtractor(), httpClient("Slack"), logger),
+			Tags:      splitList(*slackFilterTags),
+		})
+	}
+	if *telegramBotToken != "" {
+		telegramConfig := notifications.TelegramConfig{
+			BotToken: *telegramBotToken,
+			ChatID:   *telegramChatID,
+			Retries:  *telegramRetries,
+		}
+		notifCenterTargets = append(notifCenterTargets, notifications.NotifierTarget{
+			Name:      "telegram",
+			Annotator: notifications.NewTelegramNotifier(telegramConfig, tagextractor.NewNotificationCenterTagExtractor(), httpClient("Telegram"), logger),
+			Tags:      splitList(*telegramFilterTags),
+		})
+		dockerTargets = append(dockerTargets, notifications.NotifierTarget{
+			Name:      "telegram",
+			Annotator: notifications.NewTelegramNotifier(telegramConfig, tagextractor.NewNoOpTagExtractor(), httpClient("Telegram"), logger),
+			Tags:      splitList(*telegramFilterTags),
+		})
+	}
+	if *webhookURL != "" {
+		notifCenterWebhook, _ := notifications.NewWebhookNotifier(webhookConfig, tagextractor.NewNotificationCenterTagExtractor(), httpClient("webhook"), logger)
+		dockerWebhook, _ := notifications.NewWebhookNotifier(webhookConfig, tagextractor.NewNoOpTagExtractor(), httpClient("webhook"), logger)
+		notifCenterTargets = append(notifCenterTargets, notifications.NotifierTarget{Name: "webhook", Annotator: notifCenterWebhook, Tags: splitList(*webhookFilterTags)})
+		dockerTargets = append(dockerTargets, notifications.NotifierTarget{Name: "webhook", Annotator: dockerWebhook, Tags: splitList(*webhookFilterTags)})
+	}
+	if *mqttBrokerURL != "" {
+		mqttConfig := notifications.MQTTConfig{
+			BrokerURL:          *mqttBrokerURL,
+			Username:           *mqttUsername,
+			Password:           *mqttPassword,
+			CAFile:             *mqttCAFile,
+			InsecureSkipVerify: *mqttInsecure,
+			BaseTopic:          *mqttTopic,
+			QoS:                byte(*mqttQoS),
+			Retain:             *mqttRetain,
+		}
+		if *mqttQoS > 2 {
+			log.Fatalf("Invalid MQTT QoS %d, expected 0, 1 or 2\n", *mqttQoS)
+		}
+		annotator, err := notifications.NewMQTTNotifier(mqttConfig, tagextractor.NewNotificationCenterTagExtractor(), logger)
+		if err != nil {
+			log.Fatalf("Error creating MQTT notifier: %v\n", err)
+		}
+		// Both sources share the connection to the broker; docker events are published untagged
+		notifCenterTargets = append(notifCenterTargets, notifications.NotifierTarget{Name: "mqtt", Annotator: annotator, Tags: splitList(*mqttFilterTags)})
+		dockerTargets = append(dockerTargets, notifications.NotifierTarget{Name: "mqtt", Annotator: annotator, Tags: splitList(*mqttFilterTags)})
+	}
+	var lokiNotifier *notifications.LokiNotifier
+	if *lokiURL != "" {
+		lokiConfig := notifications.LokiConfig{
+			URL:       *lokiURL,
+			Username:  *lokiUsername,
+			Password:  *lokiPassword,
+			TenantID:  *lokiTenantID,
+			Hostname:  grafanaConfig.Hostname,
+			BatchSize: *lokiBatchSize,
+			BatchWait: *lokiBatchWait,
+			Retries:   *lokiRetries,
+		}
+		// Both sources share the batches; docker events are pushed untagged
+		lokiNotifier = notifications.NewLokiNotifier(lokiConfig, tagextractor.NewNotificationCenterTagExtractor(), httpClient("Loki"), logger)
+		notifCenterTargets = append(notifCenterTargets, notifications.NotifierTarget{Name: "loki", Annotator: lokiNotifier, Tags: splitList(*lokiFilterTags)})
+		dockerTargets = append(dockerTargets, notifications.NotifierTarget{Name: "loki", Annotator: lokiNotifier, Tags: splitList(*lokiFilterTags)})
+	}
+	multiConfig := notifications.MultiNotifierConfig{Timeout: *notifyTimeout, RequireAll: *notifyRequireAll}
+	multiConfig.Targets = notifCenterTargets
+	notifCenterNotifier := notifications.NewMultiNotifier(multiConfig, tagextractor.NewNotificationCenterTagExtractor(), logger)
+	multiConfig.Targets = dockerTargets
+	dockerNotifier := notifications.NewMultiNotifier(multiConfig, tagextractor.NewNoOpTagExtractor(), logger)
+
+	config := newExporterConfig(logger)
+	var pushTargets []pushTarget
+	if *otlpEndpoint != "" {
+		otlpSender, err := push.NewOTLPSender(push.OTLPConfig{Endpoint: *otlpEndpoint, Headers: otlpHeaders, HTTPClient: httpClient("OTLP")})
+		if err != nil {
+			log.Fatalf("Invalid OTLP configuration: %v\n", err)
+		}
+		pushTargets = append(pushTargets, pushTarget{otlpSender, push.Config{Interval: *otlpInterval, Retries: *otlpRetries}})
+	}
+	if *influxURL != "" {
+		influxConfig := push.InfluxConfig{URL: *influxURL, Org: *influxOrg, Bucket: *influxBucket, Token: *influxToken, HTTPClient: httpClient("InfluxDB")}
+		influxSender, err := push.NewInfluxSender(influxConfig)
+		if err != nil {
+			log.Fatalf("Invalid InfluxDB configuration: %v\n", err)
+		}
+		pushTargets = append(pushTargets, pushTarget{influxSender, push.Config{Interval: *influxInterval, Retries: *influxRetries}})
+	}
+	if *graphiteAddress != "" {
+		graphiteSender, err := push.NewGraphiteSender(push.GraphiteConfig{Address: *graphiteAddress, Template: *graphiteTemplate})
+		if err != nil {
+			log.Fatalf("Invalid Graphite configuration: %v\n", err)
+		}
+		graphiteConfig := push.Config{Interval: *graphiteInterval, Retries: *graphiteRetries, BufferSize: *graphiteBufferSize}
+		pushTargets = append(pushTargets, pushTarget{graphiteSender, graphiteConfig})
+	}
+	if *zabbixAddress != "" {
+		zabbixSender, err := push.NewZabbixSender(push.ZabbixConfig{Address: *zabbixAddress, Host: *zabbixHost, KeyPrefix: *zabbixKeyPrefix})
+		if err != nil {
+			log.Fatalf("Invalid Zabbix configuration: %v\n", err)
+		}
+		zabbixConfig := push.Config{Interval: *zabbixInterval, Retries: *zabbixRetries, BufferSize: *zabbixBufferSize}
+		pushTargets = append(pushTargets, pushTarget{zabbixSender, zabbixConfig})
+	}
+	if *statsdAddress != "" {
+		statsdConfig := push.StatsdConfig{Address: *statsdAddress, Network: *statsdNetwork, MTU: *statsdMTU, CounterDeltas: *statsdCounterDeltas}
+		statsdSender, err := push.NewStatsdSender(statsdConfig)
+		if err != nil {
+			log.Fatalf("Invalid statsd configuration: %v\n", err)
+		}
+		pushTargets = append(pushTargets, pushTarget{statsdSender, push.Config{Interval: *statsdInterval}})
+	}
+	var pushers []*push.Pusher
+	if len(pushTargets) > 0 {
+		// pushers is filled in right after the exporter is created, before it is first scraped
+		config.PushStats = func() map[string]exporter.PushStats {
+			stats := make(map[string]exporter.PushStats, len(pushers))
+			for _, p := range pushers {
+				stats[p.Name()] = p.Stats()
+			}
+			return stats
+		}
+	}
+	var queues []*notifications.QueuedNotifier
+	if *notifyQueueSize > 0 {
+		queueConfig := notifications.QueueConfig{Size: *notifyQueueSize, Retries: *notifyQueueRetries, JournalMaxEntries: *notifyQueueJournalSize}
+		if *notifyQueueJournalDir != "" {
+			queueConfig.JournalPath = filepath.Join(*notifyQueueJournalDir, "notification-center.journal")
+		}
+		notifCenterQueue := notifications.NewQueuedNotifier(queueConfig, notifCenterNotifier, logger)
+		if *notifyQueueJournalDir != "" {
+			queueConfig.JournalPath = filepath.Join(*notifyQueueJournalDir, "docker.journal")
+		}
+		dockerQueue := notifications.NewQueuedNotifier(queueConfig, dockerNotifier, logger)
+
+		queues = []*notifications.QueuedNotifier{notifCenterQueue, dockerQueue}
+		notifCenterNotifier, dockerNotifier = notifCenterQueue, dockerQueue
+	}
+	var throttles []*notifications.ThrottledNotifier
+	if *notifyDedupWindow > 0 || *notifyRateLimit > 0 {
+		throttleConfig := notifications.ThrottleConfig{DedupWindow: *notifyDedupWindow}
+		if *notifyRateLimit > 0 {
+			throttleConfig.RateLimiter = notifications.NewTokenBucket(*notifyRateLimit)
+		}
+		notifCenterThrottle := notifications.NewThrottledNotifier(throttleConfig, tagextractor.NewNotificationCenterTagExtractor(), notifCenterNotifier, logger)
+		dockerThrottle := notifications.NewThrottledNotifier(throttleConfig, tagextractor.NewNoOpTagExtractor(), dockerNotifier, logger)
+
+		throttles = []*notifications.ThrottledNotifier{notifCenterThrottle, dockerThrottle}
+		notifCenterNotifier, dockerNotifier = notifCenterThrottle, dockerThrottle
+	}
+	var retention *notifications.AnnotationRetention
+	if *grafanaURL != "" && *grafanaRetention > 0 {
+		var pruners []notifications.AnnotationPruner
+		for _, a := range []notifications.Annotator{notifCenterAnnotator, dockerAnnotator} {
+			if p, ok := a.(notifications.AnnotationPruner); ok {
+				pruners = append(pruners, p)
+			}
+		}
+		retention = notifications.NewAnnotationRetention(notifications.RetentionConfig{Retention: *grafanaRetention}, pruners, logger)
+	}
+	var lineSource *sources.LineSource
+	if *annotationPipe != "" {
+		lineSource = sources.NewLineSource(sources.LineSourceConfig{Path: *annotationPipe}, notifCenterNotifier, logger)
+	}
+	var connLog *sources.ConnLogWatcher
+	if len(connLogRules.rules) > 0 {
+		connLogConfig := sources.ConnLogConfig{
+			Path:           *connLogPath,
+			StatePath:      *connLogStateFile,
+			Interval:       *connLogInterval,
+			AnnotationRate: *connLogAnnotationRate,
+			Rules:          connLogRules.rules,
+		}
+		connLog = sources.NewConnLogWatcher(connLogConfig, notifCenterNotifier, logger)
+		config.ConnLogStats = connLog.Counts
+	}
+	if len(queues) > 0 || len(throttles) > 0 || retention != nil || lineSource != nil || lokiNotifier != nil {
+		config.NotificationStats = func() exporter.NotificationStats {
+			var stats exporter.NotificationStats
+			for _, q := range queues {
+				s := q.Stats()
+				stats.QueueDepth += s.Depth
+				stats.Delivered += s.Delivered
+				stats.Failed += s.Failed
+				stats.Dropped += s.Dropped
+				stats.Journaled += s.Journaled
+				stats.Replayed += s.Replayed
+				stats.Evicted += s.Evicted
+			}
+			for _, t := range throttles {
+				s := t.Stats()
+				stats.Suppressed += s.Suppressed
+				stats.RateLimited += s.RateLimited
+			}
+			if retention != nil {
+				stats.AnnotationsDeleted = retention.Deleted()
+			}
+			if lineSource != nil {
+				stats.Dropped += lineSource.Dropped()
+			}
+			if lokiNotifier != nil {
+				stats.Dropped += lokiNotifier.Stats().Dropped
+			}
+			return stats
+		}
+	}
+	if serverStatus.NotificationEndpoint != "" {
+		config.Annotator = notifCenterNotifier
+	}
+	// Report readiness and liveness when supervised by systemd (or another service manager implementing sd_notify)
+	serviceManager := sdnotify.NewNotifier(os.Getenv("NOTIFY_SOCKET"))
+	if serviceManager.Enabled() {
+		config.OnReady = func() {
+			if err := serviceManager.Notify(sdnotify.Ready); err != nil {
+				logger.Printf("Error notifying readiness: %v\n", err)
+			}
+		}
+	}
+	e = prometheus.NewExporter(config, &serverStatus.ExporterStatus)
+	if source, ok := e.(exporter.SampleCollector); ok {
+		for _, target := range pushTargets {
+			pushers = append(pushers, push.NewPusher(target.config, source, target.sender, logger))
+		}
+	}
+
+	if sc, ok := e.(exporter.SelfChecker); ok && (*selfCheck || *strict) {
+		runSelfCheck := func() []exporter.SelfCheckResult {
+			results := sc.SelfCheck()
+			var report strings.Builder
+			writeSelfCheckReport(&report, results)
+			logger.Print(report.String())
+			return results
+		}
+		if *strict {
+			// Before serving, so that a misdeployed exporter never reports itself as ready
+			if selfCheckFailed(runSelfCheck()) {
+				log.Fatalln("Exiting since collectors failed the self-check (--strict)")
+			}
+		} else {
+			go runSelfCheck()
+		}
+	}
+
+	if len(listen) == 0 {
+		listen = listenFlags{*port}
+	}
+	socketMode, err := parseSocketMode(*listenSocketMode)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	listeners, err := openListeners(listen, socketMode)
+	if err != nil {
+		log.Fatalf("Error listening to HTTP requests: %v\n", err)
+	}
+
+	args := httpServerArgs{
+		exporter:          e,
+		listeners:         listeners,
+		annotationToken:   *annotationToken,
+		alertmanagerToken: *alertmanagerToken,
+		healthcheck:       *healthcheck,
+		logger:            logger,
+		debug:             *debug,
+	}
 
 	ctx, cancelFn := context.WithCancel(context.Background())
 
@@ -125,21 +919,178 @@ func main() {
 		<-exitCh
 	}()
 
-	go func() { _ = handleDockerEvents(ctx, args, dockerAnnotator, &serverStatus.ExporterStatus) }()
+	// Reload configuration on SIGHUP
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			logger.Println("Received SIGHUP, reloading")
+			if tokenSource != nil {
+				if err := tokenSource.Reload(); err != nil {
+					logger.Printf("Error reloading Grafana token: %v\n", err)
+				}
+			}
+			if r, ok := e.(exporter.Reloader); ok {
+				r.Reload()
+			}
+		}
+	}()
+
+	go evictRegionsPeriodically(ctx, regionMatcher)
+	if *childProcessThreshold > 0 {
+		go checkChildProcessesPeriodically(ctx, *childProcessThreshold, logger)
+	}
+	for _, p := range pushers {
+		go p.Run(ctx)
+	}
+	if retention != nil {
+		go retention.Run(ctx)
+	}
+	go func() { _ = handleDockerEvents(ctx, args, dockerNotifier, &serverStatus.ExporterStatus) }()
+	if lineSource != nil {
+		go func() {
+			if err := lineSource.Run(ctx); err != nil {
+				logger.Printf("Error reading annotations from %q: %v\n", *annotationPipe, err)
+			}
+		}()
+	}
+	if *eventLog {
+		eventLogConfig := sources.EventLogConfig{Path: *eventLogPath, StatePath: *eventLogStateFile, Interval: *eventLogInterval}
+		go sources.NewEventLogWatcher(eventLogConfig, notifCenterNotifier, logger).Run(ctx)
+	}
+	if connLog != nil {
+		go connLog.Run(ctx)
+	}
+
+	watchdogTimeout, err := sdnotify.WatchdogIntervalFromEnv()
+	if err != nil {
+		logger.Printf("Error reading the watchdog configuration: %v\n", err)
+	}
+	if h, ok := e.(exporter.HealthReporter); ok && serviceManager.Enabled() && watchdogTimeout > 0 {
+		logger.Printf("Notifying the watchdog every %v while scrapes succeed\n", watchdogTimeout/2)
+		go serviceManager.RunWatchdog(ctx, watchdogTimeout, func() bool { return h.Healthy(watchdogTimeout) }, logger)
+	}
+
+	var lifecycle *sources.Lifecycle
+	if *lifecycleAnnotations && serverStatus.NotificationEndpoint != "" {
+		lifecycle = sources.NewLifecycle(sources.LifecycleConfig{StatePath: *lifecycleStateFile}, notifCenterNotifier, logger)
+		lifecycle.Start(ctx)
+	}
 
-	err := serveHTTP(ctx, args, notifCenterAnnotator, serverStatus)
+	err = serveHTTP(ctx, args, notifCenterNotifier, serverStatus)
 	if err != nil {
 		log.Println(err.Error())
 	}
+	_ = serviceManager.Notify(sdnotify.Stopping)
+	if lifecycle != nil {
+		lifecycle.Stop(*notifyShutdownTimeout)
+	}
+	for _, q := range queues {
+		q.Close(*notifyShutdownTimeout)
+	}
+	if lokiNotifier != nil {
+		lokiNotifier.Close(*notifyShutdownTimeout)
+	}
 	os.Exit(1)
 }
 
+// splitList splits a comma-separated list (e.g. of tags), ignoring empty entries
+func splitList(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// sendWebhookTest posts a sample notification to the webhook, returning the process exit code
+func sendWebhookTest(config notifications.WebhookConfig, logger *log.Logger) int {
+	if config.URL == "" {
+		logger.Println("--webhook-test requires --webhook-url")
+		return 2
+	}
+
+	annotator, err := notifications.NewWebhookNotifier(config, tagextractor.NewNoOpTagExtractor(), nil, logger)
+	if err != nil {
+		logger.Printf("Error creating webhook notifier: %v\n", err)
+		return 1
+	}
+	if _, err := annotator.PostAnnotation(notifications.Annotation{Text: "Test notification from qnapexporter", Tags: []string{"test"}}); err != nil {
+		return 1
+	}
+
+	logger.Printf("Sent test notification to %s\n", config.URL)
+	return 0
+}
+
+func evictRegionsPeriodically(ctx context.Context, regionMatcher notifications.RegionMatcher) {
+	ticker := time.NewTicker(regionEvictionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			regionMatcher.Evict()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkChildProcessesPeriodically logs when the number of child processes exceeds threshold, or grows while above it,
+// since the commands run by the collectors are expected to be killed along with their own children on timeout
+func checkChildProcessesPeriodically(ctx context.Context, threshold int, logger *log.Logger) {
+	ticker := time.NewTicker(childProcessInterval)
+	defer ticker.Stop()
+
+	reported := threshold
+	for {
+		select {
+		case <-ticker.C:
+			children, zombies, err := utils.CountChildProcesses()
+			if err != nil {
+				logger.Printf("Error counting the child processes: %v\n", err)
+				continue
+			}
+			if children > reported {
+				logger.Printf("The exporter has %d child processes (%d zombies), more than the threshold of %d\n", children, zombies, threshold)
+				reported = children
+			} else if children <= threshold {
+				reported = threshold
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func handleMetricsHTTPRequest(w http.ResponseWriter, r *http.Request, args httpServerArgs) {
+	// The families can be selected with anchored regular expressions, e.g. /metrics?name[]=node_hdtmp_C
+	var out io.Writer = w
+	if patterns := append(r.URL.Query()["name[]"], r.URL.Query()["match[]"]...); len(patterns) > 0 {
+		filter, err := prometheus.ParseNameFilter(patterns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fw := filter.Writer(w)
+		defer fw.Close()
+		out = fw
+	}
+
 	w.Header().Add("Content-Type", "text/plain")
 
 	handleHealthcheckStart(args.healthcheck)
 
-	err := args.exporter.WriteMetrics(w)
+	var err error
+	if dw, ok := args.exporter.(exporter.DeadlineWriter); ok {
+		err = dw.WriteMetricsWithin(out, scrapeTimeout(r))
+	} else {
+		err = args.exporter.WriteMetrics(out)
+	}
 	if err != nil {
 		args.logger.Println(err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -148,6 +1099,35 @@ func handleMetricsHTTPRequest(w http.ResponseWriter, r *http.Request, args httpS
 	handleHealthcheckEnd(args.healthcheck, err)
 }
 
+// scrapeTimeout returns the time the metrics must be written out within, leaving Prometheus a margin to receive them
+// before its scrape timeout (sent in the X-Prometheus-Scrape-Timeout-Seconds header)
+func scrapeTimeout(r *http.Request) time.Duration {
+	timeout := defaultScrapeTimeout
+	if seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil && seconds > 0 {
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+
+	return timeout * 9 / 10
+}
+
+// handleInfluxHTTPRequest serves the metrics as InfluxDB line protocol, e.g. for the http input plugin of Telegraf
+func handleInfluxHTTPRequest(w http.ResponseWriter, args httpServerArgs) {
+	source, ok := args.exporter.(exporter.SampleCollector)
+	if !ok {
+		http.Error(w, "The exporter doesn't return its samples", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+
+	// Like on /metrics, the samples of the collectors which succeeded are served
+	batch, err := push.CollectBatch(source)
+	if err != nil {
+		args.logger.Println(err.Error())
+	}
+	_ = push.WriteInfluxLines(w, batch)
+}
+
 func handleNotificationHTTPRequest(w http.ResponseWriter, r *http.Request, annotator notifications.Annotator) {
 	notification := r.URL.Query().Get("text")
 	if len(notification) == 0 {
@@ -158,7 +1138,14 @@ func handleNotificationHTTPRequest(w http.ResponseWriter, r *http.Request, annot
 	_, _ = annotator.Post(notification, time.Now())
 }
 
+// handleRootHTTPRequest serves the status page, which is rendered from the status of the last scrape
+// rather than triggering one
 func handleRootHTTPRequest(w http.ResponseWriter, r *http.Request, serverStatus *status.Status, logger *log.Logger) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
 	w.Header().Add("Content-Type", "text/html")
 	w.Header().Add("Cache-Control", "no-cache")
 
@@ -170,6 +1157,44 @@ func handleRootHTTPRequest(w http.ResponseWriter, r *http.Request, serverStatus
 	}
 }
 
+// handleReadinessHTTPRequest responds with 503 Service Unavailable until the exporter has read the environment
+// successfully, followed by the report of the startup self-check with ?verbose=1
+func handleReadinessHTTPRequest(w http.ResponseWriter, r *http.Request, e exporter.Exporter) {
+	w.Header().Add("Content-Type", "text/plain")
+	readiness := "OK"
+	if rr, ok := e.(exporter.ReadinessReporter); ok {
+		if err := rr.Ready(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			readiness = err.Error()
+		}
+	}
+	_, _ = fmt.Fprintln(w, readiness)
+
+	sc, ok := e.(exporter.SelfChecker)
+	if !ok || r.URL.Query().Get("verbose") != "1" {
+		return
+	}
+	_, _ = fmt.Fprintln(w, "")
+	if results := sc.LastSelfCheck(); results != nil {
+		writeSelfCheckReport(w, results)
+	} else {
+		_, _ = fmt.Fprintln(w, "The self-check hasn't completed yet")
+	}
+}
+
+func handleDebugVarsHTTPRequest(w http.ResponseWriter, e exporter.Exporter) {
+	dr, ok := e.(exporter.DebugStateReporter)
+	if !ok {
+		http.Error(w, "The exporter doesn't describe its internal state", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(dr.DebugState())
+}
+
 func serveHTTP(ctx context.Context, args httpServerArgs, annotator notifications.Annotator, serverStatus *status.Status) error {
 	defer args.exporter.Close()
 
@@ -180,19 +1205,51 @@ func serveHTTP(ctx context.Context, args httpServerArgs, annotator notifications
 	http.HandleFunc(metricsEndpoint, func(w http.ResponseWriter, r *http.Request) {
 		handleMetricsHTTPRequest(w, r, args)
 	})
+	http.HandleFunc(influxEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		handleInfluxHTTPRequest(w, args)
+	})
+	http.HandleFunc(healthEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/plain")
+		_, _ = fmt.Fprintln(w, "OK")
+	})
+	http.HandleFunc(readinessEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		handleReadinessHTTPRequest(w, r, args.exporter)
+	})
+	if args.debug {
+		// Not the expvar package, which would always serve its variables on the default mux
+		http.HandleFunc(debugVarsEndpoint, func(w http.ResponseWriter, r *http.Request) {
+			handleDebugVarsHTTPRequest(w, args.exporter)
+		})
+	}
 	if serverStatus.NotificationEndpoint != "" {
 		http.HandleFunc(notificationEndpoint, func(w http.ResponseWriter, r *http.Request) {
 			serverStatus.LastNotification = time.Now()
 			handleNotificationHTTPRequest(w, r, annotator)
 		})
 	}
+	if serverStatus.NotificationEndpoint != "" && args.annotationToken != "" {
+		annotationHandler := notifications.NewAnnotationHandler(annotator, args.annotationToken, args.logger)
+		http.HandleFunc(annotationEndpoint, func(w http.ResponseWriter, r *http.Request) {
+			serverStatus.LastNotification = time.Now()
+			annotationHandler.ServeHTTP(w, r)
+		})
+	}
+	if serverStatus.NotificationEndpoint != "" && args.alertmanagerToken != "" {
+		alertmanagerHandler := notifications.NewAlertmanagerHandler(annotator, args.alertmanagerToken, args.logger)
+		http.HandleFunc(alertmanagerEndpoint, func(w http.ResponseWriter, r *http.Request) {
+			serverStatus.LastNotification = time.Now()
+			alertmanagerHandler.ServeHTTP(w, r)
+		})
+	}
 
-	// listen to port
-	server := http.Server{Addr: args.port}
+	server := http.Server{}
 	server.ErrorLog = args.logger
+	errCh := make(chan error, len(args.listeners))
+	for _, l := range args.listeners {
+		log.Printf("Listening to HTTP requests at %s\n", listenerAddress(l))
+		go func(l net.Listener) { errCh <- server.Serve(l) }(l)
+	}
 	go func() {
-		log.Printf("Listening to HTTP requests at %s\n", args.port)
-
 		// Wait for program exit
 		<-ctx.Done()
 
@@ -205,7 +1262,11 @@ func serveHTTP(ctx context.Context, args httpServerArgs, annotator notifications
 		}
 	}()
 
-	return server.ListenAndServe()
+	// Stop serving on all the listeners, removing the socket files, if any of them fails
+	err := <-errCh
+	_ = server.Close()
+
+	return err
 }
 
 func handleHealthcheckStart(healthcheck string) {